@@ -43,6 +43,7 @@ const (
 	downloadAction       OpType = "download_action"
 	downloadBlob         OpType = "download_blob"
 	downloadDir          OpType = "download_dir"
+	diffTrees            OpType = "diff_trees"
 	executeAction        OpType = "execute_action"
 	checkDeterminism     OpType = "check_determinism"
 	uploadBlob           OpType = "upload_blob"
@@ -56,6 +57,7 @@ var supportedOps = []OpType{
 	downloadAction,
 	downloadBlob,
 	downloadDir,
+	diffTrees,
 	executeAction,
 	checkDeterminism,
 	uploadBlob,
@@ -65,6 +67,7 @@ var supportedOps = []OpType{
 var (
 	operation    = flag.String("operation", "", fmt.Sprintf("Specifies the operation to perform. Supported values: %v", supportedOps))
 	digest       = flag.String("digest", "", "Digest in <digest/size_bytes> format.")
+	otherDigest  = flag.String("other_digest", "", "For diff_trees: the digest of the input root to compare with the one of --digest, in <digest/size_bytes> format.")
 	pathPrefix   = flag.String("path", "", "Path to which outputs should be downloaded to.")
 	overwrite    = flag.Bool("overwrite", false, "Overwrite the output path if it already exist.")
 	actionRoot   = flag.String("action_root", "", "For execute_action: the root of the action spec, containing ac.textproto (Action proto), cmd.textproto (Command proto), and input/ (root of the input tree).")
@@ -112,6 +115,16 @@ func main() {
 			log.Exitf("error downloading directory for digest %v: %v", getDigestFlag(), err)
 		}
 
+	case diffTrees:
+		if *otherDigest == "" {
+			log.Exitf("--other_digest must be specified.")
+		}
+		res, err := c.DiffTrees(ctx, getDigestFlag(), *otherDigest)
+		if err != nil {
+			log.Exitf("error comparing input roots %v and %v: %v", getDigestFlag(), *otherDigest, err)
+		}
+		os.Stdout.Write([]byte(res))
+
 	case showAction:
 		res, err := c.ShowAction(ctx, getDigestFlag())
 		if err != nil {
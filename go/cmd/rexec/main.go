@@ -24,28 +24,49 @@ import (
 	"os"
 	"path"
 
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/client"
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/command"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/diskcache"
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/filemetadata"
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/moreflag"
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/outerr"
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/rexec"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/uploaddedup"
 
 	rflags "github.com/bazelbuild/remote-apis-sdks/go/pkg/flags"
 	log "github.com/golang/glog"
 )
 
+var outputPaths, outputGlobs []string
+
+var cacheSalt = flag.String("cache_salt", "", "If set, mixed into the action digest so that the command does not share remote cache entries with the same command run with another salt, e.g. to invalidate them per release or experiment.")
+
+var (
+	diskCacheDir     = flag.String("disk_cache_dir", "", "If set, a local directory caching action results and output blobs, consulted before the remote cache.")
+	diskCacheMaxSize = flag.Int64("disk_cache_max_size", 10<<30, "Maximum size in bytes of --disk_cache_dir, above which the least recently used entries are evicted.")
+)
+
+var uploadedDigestsFile = flag.String("uploaded_digests_file", "", "If set, a file recording the digests recently uploaded by rexec invocations on this machine, so that they are not queried and uploaded again.")
+
 func initFlags(cmd *command.Command, opt *command.ExecutionOptions) {
 	flag.StringVar(&cmd.Identifiers.CommandID, "command_id", "", "An identifier for the command for debugging.")
 	flag.StringVar(&cmd.Identifiers.InvocationID, "invocation_id", "", "An identifier for a group of commands for debugging.")
 	flag.StringVar(&cmd.Identifiers.ToolName, "tool_name", "", "The name of the tool to associate with executed commands.")
+	flag.Var((*moreflag.StringMapValue)(&cmd.Identifiers.Labels), "labels", "Comma-separated key value pairs in the form key=value, sent to the remote server to annotate the command in its logs, e.g. target=//foo:bar.")
 	flag.StringVar(&cmd.ExecRoot, "exec_root", "", "The exec root of the command. The path from which all inputs and outputs are defined relatively.")
 	flag.StringVar(&cmd.WorkingDir, "working_directory", "", "The working directory, relative to the exec root, for the command to run in. It must be a directory which exists in the input tree. If it is left empty, then the action is run in the exec root.")
+	flag.StringVar(&cmd.RemoteWorkingDir, "remote_working_directory", "", "The working directory, relative to the exec root, for the command to run in remotely, if it differs from the local one, e.g. for toolchains that require a canonical layout. It must have the same depth as the working directory. Inputs and outputs are mapped back to the local working directory.")
 	flag.Var((*moreflag.StringListValue)(&cmd.InputSpec.Inputs), "inputs", "Comma-separated command input paths, relative to exec root.")
 	flag.Var((*moreflag.StringListValue)(&cmd.OutputFiles), "output_files", "Comma-separated command output file paths, relative to exec root.")
 	flag.Var((*moreflag.StringListValue)(&cmd.OutputDirs), "output_directories", "Comma-separated command output directory paths, relative to exec root.")
-	flag.DurationVar(&cmd.Timeout, "exec_timeout", 0, "Timeout for the command. Value of 0 means no timeout.")
+	flag.Var((*moreflag.StringListValue)(&outputPaths), "output_paths", "Comma-separated command output paths, relative to exec root, that may be files or directories.")
+	flag.Var((*moreflag.StringListValue)(&outputGlobs), "output_globs", "Comma-separated glob patterns of command output files, relative to exec root, in which ** matches any number of directories.")
+	flag.DurationVar(&cmd.Timeout, "exec_timeout", 0, "Timeout for the command run on the worker, not counting queue time. Value of 0 means no timeout.")
+	flag.DurationVar(&opt.ClientDeadline, "client_deadline", 0, "Maximum time to wait for the remote execution, including queue time. Value of 0 means no deadline.")
 	flag.Var((*moreflag.StringMapValue)(&cmd.Platform), "platform", "Comma-separated key value pairs in the form key=value. This is used to identify remote platform settings like the docker image to use to run the command.")
 	flag.Var((*moreflag.StringMapValue)(&cmd.InputSpec.EnvironmentVariables), "environment_variables", "Environment variables to pass through to remote execution, as comma-separated key value pairs in the form key=value.")
+	flag.Var((*moreflag.StringListValue)(&cmd.InputSpec.EnvironmentPassthrough), "environment_passthrough", "Comma-separated names of variables of the local environment to pass through to remote execution.")
+	flag.Var((*moreflag.StringListValue)(&cmd.InputSpec.EnvironmentAllowlist), "environment_allowlist", "If set, comma-separated names of the only environment variables passed to remote execution.")
 	flag.BoolVar(&opt.AcceptCached, "accept_cached", true, "Boolean indicating whether to accept remote cache hits.")
 	flag.BoolVar(&opt.DoNotCache, "do_not_cache", false, "Boolean indicating whether to skip caching the command result remotely.")
 	flag.BoolVar(&opt.DownloadOutputs, "download_outputs", true, "Boolean indicating whether to download outputs after the command is executed.")
@@ -62,6 +83,15 @@ func main() {
 	}
 	flag.Parse()
 	cmd.Args = flag.Args()
+	if *cacheSalt != "" {
+		opt.Salt = []byte(*cacheSalt)
+	}
+	for _, p := range outputPaths {
+		cmd.Outputs = append(cmd.Outputs, &command.OutputSpec{Path: p, Type: command.WildcardOutputType})
+	}
+	for _, p := range outputGlobs {
+		cmd.Outputs = append(cmd.Outputs, &command.OutputSpec{Path: p, Type: command.GlobOutputType})
+	}
 	if err := cmd.Validate(); err != nil {
 		flag.Usage()
 		log.Exitf("Invalid command provided: %v", err)
@@ -73,10 +103,24 @@ func main() {
 		log.Exitf("error connecting to remote execution client: %v", err)
 	}
 	defer grpcClient.Close()
+	if *uploadedDigestsFile != "" {
+		if s, err := uploaddedup.OpenFile(*uploadedDigestsFile, grpcClient.InstanceName, 0); err != nil {
+			log.Warningf("Failed to open the uploaded digests file: %v", err)
+		} else {
+			client.UploadDedup{UploadedDigests: s}.Apply(grpcClient)
+		}
+	}
 	c := &rexec.Client{
 		FileMetadataCache: filemetadata.NewNoopCache(),
 		GrpcClient:        grpcClient,
 	}
+	if *diskCacheDir != "" {
+		dc, err := diskcache.New(*diskCacheDir, *diskCacheMaxSize)
+		if err != nil {
+			log.Exitf("error opening the disk cache: %v", err)
+		}
+		c.DiskCache = dc
+	}
 	res, _ := c.Run(ctx, cmd, opt, outerr.SystemOutErr)
 	switch res.Status {
 	case command.NonZeroExitResultStatus:
@@ -89,5 +133,14 @@ func main() {
 		fmt.Fprintf(os.Stderr, "Remote execution error: %v.\n", res.Err)
 	case command.LocalErrorResultStatus:
 		fmt.Fprintf(os.Stderr, "Local error: %v.\n", res.Err)
+	case command.ClientDeadlineResultStatus:
+		fmt.Fprintf(os.Stderr, "Gave up waiting for the remote action: %v.\n", res.Err)
+	case command.CancelledResultStatus:
+		fmt.Fprintf(os.Stderr, "Remote execution was cancelled: %v.\n", res.Err)
+	}
+	if !res.IsOk() {
+		// Exit with the command's exit code, or the one corresponding to the failure.
+		grpcClient.Close()
+		os.Exit(res.ExitCode)
 	}
 }
@@ -0,0 +1,36 @@
+// Main package for the uploaddedup binary.
+//
+// This tool serves the digests recently uploaded to the CAS by the SDK processes of a machine, so
+// that they avoid redundant FindMissingBlobs queries and uploads. See the uploaddedup package.
+//
+// Example usage:
+//
+//	uploaddedup --socket /tmp/uploaddedup.sock --ttl 30m
+package main
+
+import (
+	"flag"
+
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/uploaddedup"
+
+	log "github.com/golang/glog"
+)
+
+var (
+	socket     = flag.String("socket", "", "The path of the Unix domain socket to listen on.")
+	ttl        = flag.Duration("ttl", uploaddedup.DefaultTTL, "The time digests are remembered for after they were last uploaded.")
+	maxEntries = flag.Int("max_entries", uploaddedup.DefaultMaxEntries, "The maximum number of digests remembered.")
+)
+
+func main() {
+	flag.Parse()
+	if *socket == "" {
+		log.Exitf("--socket must be specified.")
+	}
+	s := uploaddedup.NewServer()
+	s.TTL = *ttl
+	s.MaxEntries = *maxEntries
+	if err := s.ListenAndServe(*socket); err != nil {
+		log.Exitf("Serving failed: %v", err)
+	}
+}
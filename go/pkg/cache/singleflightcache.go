@@ -72,6 +72,18 @@ func (s *SingleFlight) Delete(key interface{}) {
 	s.store.Delete(key)
 }
 
+// DeleteIf removes the keys for which pred returns true from the cache.
+func (s *SingleFlight) DeleteIf(pred func(key interface{}) bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	s.store.Range(func(key, _ interface{}) bool {
+		if pred(key) {
+			s.store.Delete(key)
+		}
+		return true
+	})
+}
+
 // Reset invalidates all cache entries.
 func (s *SingleFlight) Reset() {
 	s.mu.Lock()
@@ -119,6 +119,25 @@ func TestDelete(t *testing.T) {
 	}
 }
 
+func TestDeleteIf(t *testing.T) {
+	s := &SingleFlight{}
+	s.Store(key1, val1)
+	s.Store(key2, val2)
+	s.Store(key3, val3)
+
+	s.DeleteIf(func(key interface{}) bool { return key != key2 })
+
+	if _, _, loaded := s.Load(key1); loaded {
+		t.Errorf("Load(%v) loaded a deleted key", key1)
+	}
+	if _, _, loaded := s.Load(key3); loaded {
+		t.Errorf("Load(%v) loaded a deleted key", key3)
+	}
+	if val, _, loaded := s.Load(key2); !loaded || val != val2 {
+		t.Errorf("Load(%v) = %v, %v, want %v, true", key2, val, loaded, val2)
+	}
+}
+
 // This test launches several concurrent goroutines to load/store and delete keys from the map. The
 // purpose of the test is to expose whether a race condition would result in an inconsistent state
 // of the internal maps of the cache, which would result in an error reported by LoadOrStore.
@@ -40,6 +40,7 @@ type Chunker struct {
 	contents   []byte
 	offset     int64
 	reachedEOF bool
+	compressed bool
 
 	ue *uploadinfo.Entry
 }
@@ -60,8 +61,13 @@ func New(ue *uploadinfo.Entry, compressed bool, chunkSize int) (*Chunker, error)
 		c = &Chunker{
 			contents: contents,
 		}
-	} else if ue.IsFile() {
-		r := reader.NewFileReadSeeker(ue.Path, IOBufferSize)
+	} else if ue.IsFile() || ue.IsReader() {
+		var r reader.ReadSeeker
+		if ue.IsFile() {
+			r = reader.NewFileReadSeeker(ue.Path, IOBufferSize)
+		} else {
+			r = reader.NewReadSeeker(ue.Open, IOBufferSize)
+		}
 		if compressed {
 			var err error
 			r, err = reader.NewCompressedSeeker(r)
@@ -81,6 +87,7 @@ func New(ue *uploadinfo.Entry, compressed bool, chunkSize int) (*Chunker, error)
 	}
 
 	c.chunkSize = chunkSize
+	c.compressed = compressed
 	c.ue = ue
 	return c, nil
 }
@@ -106,14 +113,31 @@ func (c *Chunker) ChunkSize() int {
 
 // Reset the Chunker state to when it was newly constructed.
 // Useful for upload retries.
-// TODO(olaola): implement Seek(offset) when we have resumable uploads.
 func (c *Chunker) Reset() error {
-	if c.r != nil {
-		if err := c.r.SeekOffset(0); err != nil {
-			return errors.Wrapf(err, "failed to call SeekOffset(0) for %s", c.ue.Path)
+	return c.SeekOffset(0)
+}
+
+// SeekOffset sets the offset of the next chunk, for retries to resume an upload where the server
+// committed it rather than restart it. The offset is in the uploaded data, which is compressed if
+// the Chunker compresses, and can be the size of the data, for the next chunk to be the empty last
+// one. Files that are compressed on the fly can only be seeked to 0, as the offset of the
+// compressed data in the file is not known.
+func (c *Chunker) SeekOffset(offset int64) error {
+	size := c.ue.Digest.Size
+	if c.contents != nil {
+		size = int64(len(c.contents))
+	} else if c.compressed && offset != 0 {
+		return fmt.Errorf("cannot seek %s to %d: the compressed data is not seekable", c, offset)
+	}
+	if offset < 0 || offset > size {
+		return fmt.Errorf("cannot seek %s to %d: out of range", c, offset)
+	}
+	if c.r != nil && c.contents == nil {
+		if err := c.r.SeekOffset(offset); err != nil {
+			return errors.Wrapf(err, "failed to call SeekOffset(%d) for %s", offset, c.ue.Path)
 		}
 	}
-	c.offset = 0
+	c.offset = offset
 	c.reachedEOF = false
 	return nil
 }
@@ -2,6 +2,7 @@ package chunker
 
 import (
 	"bytes"
+	"io"
 	"os"
 	"path/filepath"
 	"testing"
@@ -150,6 +151,42 @@ func TestChunkerFromFile(t *testing.T) {
 	}
 }
 
+func TestChunkerFromReader(t *testing.T) {
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			IOBufferSize = 4
+			ue := uploadinfo.EntryFromReader(digest.NewFromBlob(tc.blob), func() (io.ReadCloser, error) {
+				// Not seekable, so that seeking skips the data.
+				return io.NopCloser(bytes.NewBuffer(tc.blob)), nil
+			})
+			c, err := New(ue, false, tc.chunkSize)
+			if err != nil {
+				t.Fatalf("Could not make chunker from UEntry: %v", err)
+			}
+			for i := 0; i < 2; i++ {
+				var gotChunks []*Chunk
+				for _, wantChunk := range tc.wantChunks {
+					if !c.HasNext() {
+						t.Errorf("%s: c.HasNext() was false on blob %q, expecting next chunk %q", tc.name, tc.blob, string(wantChunk.Data))
+					}
+					got, err := c.Next()
+					if err != nil {
+						t.Errorf("%s: c.Next() gave error %v on blob %q, expecting next chunk %q", tc.name, err, tc.blob, string(wantChunk.Data))
+					}
+					gotChunks = append(gotChunks, got)
+				}
+				if diff := cmp.Diff(tc.wantChunks, gotChunks); diff != "" {
+					t.Errorf("%s: Chunker gave result diff (-want +got):\n%s", tc.name, diff)
+				}
+				// Retries read the data again.
+				if err := c.Reset(); err != nil {
+					t.Fatalf("c.Reset() failed: %v", err)
+				}
+			}
+		})
+	}
+}
+
 func TestChunkerFullData(t *testing.T) {
 	t.Parallel()
 	for _, tc := range tests {
@@ -380,3 +417,76 @@ func TestChunkerResetOptimization_FullData(t *testing.T) {
 		t.Errorf("c.FullData() gave result diff, want %q, got %q", string(blob), string(got))
 	}
 }
+
+func TestChunkerSeekOffset(t *testing.T) {
+	blob := []byte("1234567890")
+	dg := digest.NewFromBlob(blob)
+	path := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(path, blob, 0777); err != nil {
+		t.Fatalf("failed to write temp file: %v", err)
+	}
+	for name, ue := range map[string]*uploadinfo.Entry{
+		"blob": uploadinfo.EntryFromBlob(blob),
+		"file": uploadinfo.EntryFromFile(dg, path),
+	} {
+		t.Run(name, func(t *testing.T) {
+			c, err := New(ue, false, 4)
+			if err != nil {
+				t.Fatalf("Could not make chunker from UEntry: %v", err)
+			}
+			if _, err := c.Next(); err != nil {
+				t.Fatalf("c.Next() failed: %v", err)
+			}
+			if err := c.SeekOffset(5); err != nil {
+				t.Fatalf("c.SeekOffset(5) failed: %v", err)
+			}
+			var got []*Chunk
+			for c.HasNext() {
+				chunk, err := c.Next()
+				if err != nil {
+					t.Fatalf("c.Next() failed: %v", err)
+				}
+				got = append(got, chunk)
+			}
+			want := []*Chunk{{Data: []byte("6789"), Offset: 5}, {Data: []byte("0"), Offset: 9}}
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Errorf("Chunks after c.SeekOffset(5) gave result diff (-want +got):\n%s", diff)
+			}
+
+			if err := c.SeekOffset(int64(len(blob))); err != nil {
+				t.Fatalf("c.SeekOffset(%d) failed: %v", len(blob), err)
+			}
+			chunk, err := c.Next()
+			if err != nil {
+				t.Fatalf("c.Next() failed: %v", err)
+			}
+			if len(chunk.Data) != 0 || chunk.Offset != int64(len(blob)) || c.HasNext() {
+				t.Errorf("c.Next() after seeking to the end = %+v, HasNext() = %v, want the empty last chunk", chunk, c.HasNext())
+			}
+
+			for _, off := range []int64{-1, int64(len(blob)) + 1} {
+				if err := c.SeekOffset(off); err == nil {
+					t.Errorf("c.SeekOffset(%d) succeeded, want error", off)
+				}
+			}
+		})
+	}
+}
+
+func TestChunkerSeekOffsetCompressedFile(t *testing.T) {
+	blob := []byte("1234567890")
+	path := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(path, blob, 0777); err != nil {
+		t.Fatalf("failed to write temp file: %v", err)
+	}
+	c, err := New(uploadinfo.EntryFromFile(digest.NewFromBlob(blob), path), true, 4)
+	if err != nil {
+		t.Fatalf("Could not make chunker from UEntry: %v", err)
+	}
+	if err := c.SeekOffset(0); err != nil {
+		t.Errorf("c.SeekOffset(0) failed: %v", err)
+	}
+	if err := c.SeekOffset(2); err == nil {
+		t.Errorf("c.SeekOffset(2) of a compressed file succeeded, want error")
+	}
+}
@@ -166,7 +166,8 @@ func TestBatchUpdateBlobsIndividualRequestRetries(t *testing.T) {
 				{Digest: digest.TestNew("c", 1).ToProto(), Data: []byte{3}},
 				{Digest: digest.TestNew("d", 1).ToProto(), Data: []byte{4}},
 			},
-			InstanceName: "instance",
+			InstanceName:   "instance",
+			DigestFunction: repb.DigestFunction_SHA256,
 		},
 		{
 			Requests: []*repb.BatchUpdateBlobsRequest_Request{
@@ -174,14 +175,16 @@ func TestBatchUpdateBlobsIndividualRequestRetries(t *testing.T) {
 				{Digest: digest.TestNew("c", 1).ToProto(), Data: []byte{3}},
 				{Digest: digest.TestNew("d", 1).ToProto(), Data: []byte{4}},
 			},
-			InstanceName: "instance",
+			InstanceName:   "instance",
+			DigestFunction: repb.DigestFunction_SHA256,
 		},
 		{
 			Requests: []*repb.BatchUpdateBlobsRequest_Request{
 				{Digest: digest.TestNew("c", 1).ToProto(), Data: []byte{3}},
 				{Digest: digest.TestNew("d", 1).ToProto(), Data: []byte{4}},
 			},
-			InstanceName: "instance",
+			InstanceName:   "instance",
+			DigestFunction: repb.DigestFunction_SHA256,
 		},
 	}
 	if len(fake.updateRequests) != len(wantRequests) {
@@ -247,7 +250,8 @@ func TestBatchReadBlobsIndividualRequestRetries(t *testing.T) {
 				digest.TestNew("c", 1).ToProto(),
 				digest.TestNew("d", 1).ToProto(),
 			},
-			InstanceName: "instance",
+			InstanceName:   "instance",
+			DigestFunction: repb.DigestFunction_SHA256,
 		},
 		{
 			Digests: []*repb.Digest{
@@ -255,14 +259,16 @@ func TestBatchReadBlobsIndividualRequestRetries(t *testing.T) {
 				digest.TestNew("c", 1).ToProto(),
 				digest.TestNew("d", 1).ToProto(),
 			},
-			InstanceName: "instance",
+			InstanceName:   "instance",
+			DigestFunction: repb.DigestFunction_SHA256,
 		},
 		{
 			Digests: []*repb.Digest{
 				digest.TestNew("c", 1).ToProto(),
 				digest.TestNew("d", 1).ToProto(),
 			},
-			InstanceName: "instance",
+			InstanceName:   "instance",
+			DigestFunction: repb.DigestFunction_SHA256,
 		},
 	}
 	if len(fake.readRequests) != len(wantRequests) {
@@ -17,7 +17,7 @@ import (
 
 // WriteBytes uploads a byte slice.
 func (c *Client) WriteBytes(ctx context.Context, name string, data []byte) error {
-	ue := uploadinfo.EntryFromBlob(data)
+	ue := uploadinfo.EntryFromBlobWith(c.digestFn, data)
 	ch, err := chunker.New(ue, false, int(c.ChunkMaxSize))
 	if err != nil {
 		return err
@@ -31,7 +31,7 @@ func (c *Client) WriteBytes(ctx context.Context, name string, data []byte) error
 // ByteStream.WriteRequest.FinishWrite and an arbitrary offset are supported for uploads with LogStream
 // resource name. If doNotFinalize is set to true, ByteStream.WriteRequest.FinishWrite will be set to false.
 func (c *Client) WriteBytesAtRemoteOffset(ctx context.Context, name string, data []byte, doNotFinalize bool, initialOffset int64) (int64, error) {
-	ue := uploadinfo.EntryFromBlob(data)
+	ue := uploadinfo.EntryFromBlobWith(c.digestFn, data)
 	ch, err := chunker.New(ue, false, int(c.ChunkMaxSize))
 	if err != nil {
 		return 0, errors.Wrap(err, "failed to create a chunk")
@@ -43,17 +43,103 @@ func (c *Client) WriteBytesAtRemoteOffset(ctx context.Context, name string, data
 	return writtenBytes, nil
 }
 
-// writeChunked uploads chunked data with a given resource name to the CAS.
+// ByteStreamWriteStats are counters of the ByteStream writes of a client.
+type ByteStreamWriteStats struct {
+	// Retries is the number of times a write was retried after a failure.
+	Retries int64
+	// Resumes is the number of retries that continued from the data committed by the server,
+	// rather than from the beginning.
+	Resumes int64
+	// ResumedBytes is the number of bytes that resumed retries did not send again.
+	ResumedBytes int64
+}
+
+// ByteStreamWriteStats returns the counters of the ByteStream writes of the client so far.
+func (c *Client) ByteStreamWriteStats() ByteStreamWriteStats {
+	return ByteStreamWriteStats{
+		Retries:      c.writeRetries.Load(),
+		Resumes:      c.writeResumes.Load(),
+		ResumedBytes: c.writeResumedBytes.Load(),
+	}
+}
+
+// ByteStreamReadStats are counters of the ByteStream reads of a client.
+type ByteStreamReadStats struct {
+	// Retries is the number of times a read was retried after a failure.
+	Retries int64
+	// Resumes is the number of retries that continued from the data already received, rather than
+	// from the beginning.
+	Resumes int64
+	// ResumedBytes is the number of bytes that resumed retries did not receive again.
+	ResumedBytes int64
+}
+
+// ByteStreamReadStats returns the counters of the ByteStream reads of the client so far.
+func (c *Client) ByteStreamReadStats() ByteStreamReadStats {
+	return ByteStreamReadStats{
+		Retries:      c.readRetries.Load(),
+		Resumes:      c.readResumes.Load(),
+		ResumedBytes: c.readResumedBytes.Load(),
+	}
+}
+
+// countReadRetry records a retry of a read, after received bytes were received.
+func (c *Client) countReadRetry(received int64) {
+	c.readRetries.Add(1)
+	if received > 0 {
+		c.readResumes.Add(1)
+		c.readResumedBytes.Add(received)
+	}
+}
+
+// remainingLimit returns the read limit of a read resumed after received bytes, out of limit, or
+// no limit if limit is 0. It returns done if the read already received all the bytes of its limit,
+// in which case it must not be resumed: a limit of 0 would read to the end of the blob.
+func remainingLimit(limit, received int64) (remaining int64, done bool) {
+	if limit == 0 {
+		return 0, false
+	}
+	if received >= limit {
+		return 0, true
+	}
+	return limit - received, false
+}
+
+// writeChunked uploads chunked data with a given resource name to the CAS. Retries of a finalized
+// write from offset 0 resume from the size committed by the server, as reported by
+// QueryWriteStatus, if the chunker can seek to it. Other retries restart from initialOffset.
 func (c *Client) writeChunked(ctx context.Context, name string, ch *chunker.Chunker, doNotFinalize bool, initialOffset int64) (int64, error) {
 	var totalBytes int64
+	attempts := 0
 	closure := func() error {
-		// Retry by starting the stream from the beginning.
-		if err := ch.Reset(); err != nil {
-			return errors.Wrap(err, "failed to Reset")
+		attempts++
+		committed := int64(0)
+		if attempts > 1 {
+			c.writeRetries.Add(1)
+			if !doNotFinalize && initialOffset == 0 {
+				var complete bool
+				committed, complete = c.committedWriteSize(ctx, name)
+				if complete {
+					totalBytes = committed
+					return nil
+				}
+			}
+		}
+		if committed > 0 {
+			if err := ch.SeekOffset(committed); err != nil {
+				log.V(2).Infof("Restarting write of %s instead of resuming it at %d: %v", name, committed, err)
+				committed = 0
+			} else {
+				c.writeResumes.Add(1)
+				c.writeResumedBytes.Add(committed)
+			}
 		}
-		totalBytes = int64(0)
-		// TODO(olaola): implement resumable uploads. initialOffset passed in allows to
-		// start writing data at an arbitrary offset, but retries still restart from initialOffset.
+		if committed == 0 {
+			if err := ch.Reset(); err != nil {
+				return errors.Wrap(err, "failed to Reset")
+			}
+		}
+		totalBytes = committed
 
 		stream, err := c.Write(ctx)
 		if err != nil {
@@ -71,6 +157,9 @@ func (c *Client) writeChunked(ctx context.Context, name string, ch *chunker.Chun
 			if !ch.HasNext() && !doNotFinalize {
 				req.FinishWrite = true
 			}
+			if err := c.uploadLimiter.wait(ctx, len(req.Data)); err != nil {
+				return err
+			}
 			err = c.CallWithTimeout(ctx, "Write", func(_ context.Context) error { return stream.Send(req) })
 			if err == io.EOF {
 				break
@@ -85,10 +174,25 @@ func (c *Client) writeChunked(ctx context.Context, name string, ch *chunker.Chun
 		}
 		return nil
 	}
-	err := c.Retrier.Do(ctx, closure)
+	err := c.retryRPC(ctx, closure)
 	return totalBytes, err
 }
 
+// committedWriteSize returns the size of the data of the resource committed by the server, and
+// whether its write is complete. Errors are logged and taken as nothing committed.
+func (c *Client) committedWriteSize(ctx context.Context, name string) (int64, bool) {
+	var res *bspb.QueryWriteStatusResponse
+	err := c.CallWithTimeout(ctx, "QueryWriteStatus", func(ctx context.Context) (e error) {
+		res, e = c.byteStream.QueryWriteStatus(ctx, &bspb.QueryWriteStatusRequest{ResourceName: name}, c.RPCOpts()...)
+		return e
+	})
+	if err != nil {
+		log.V(2).Infof("Failed to query the status of the write of %s: %v", name, err)
+		return 0, false
+	}
+	return res.CommittedSize, res.Complete
+}
+
 // ReadBytes fetches a resource's contents into a byte slice.
 //
 // ReadBytes panics with ErrTooLarge if an attempt is made to read a resource with contents too
@@ -154,9 +258,12 @@ func (c *Client) readStreamed(ctx context.Context, name string, offset, limit in
 			break
 		}
 		if err != nil {
-			return 0, err
+			return n, err
 		}
 		log.V(3).Infof("Read: resource:%s offset:%d len(data):%d", name, offset, len(resp.Data))
+		if err := c.downloadLimiter.wait(ctx, len(resp.Data)); err != nil {
+			return n, err
+		}
 		nm, err := w.Write(resp.Data)
 		if err != nil {
 			// Wrapping the error to ensure it may never get retried.
@@ -177,12 +284,23 @@ func (c *Client) readStreamed(ctx context.Context, name string, offset, limit in
 	return n, nil
 }
 
+// readStreamedRetried is readStreamed retried with the client's retrier. Retries resume from the
+// data already received.
 func (c *Client) readStreamedRetried(ctx context.Context, name string, offset, limit int64, w io.Writer) (int64, error) {
 	var n int64
+	attempts := 0
 	closure := func() error {
-		m, err := c.readStreamed(ctx, name, offset+n, limit, w)
+		remaining, done := remainingLimit(limit, n)
+		if done {
+			return nil
+		}
+		attempts++
+		if attempts > 1 {
+			c.countReadRetry(n)
+		}
+		m, err := c.readStreamed(ctx, name, offset+n, remaining, w)
 		n += m
 		return err
 	}
-	return n, c.Retrier.Do(ctx, closure)
+	return n, c.retryRPC(ctx, closure)
 }
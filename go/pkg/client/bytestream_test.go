@@ -250,3 +250,25 @@ func (b *ByteStream) Write(stream bsgrpc.ByteStream_WriteServer) error {
 
 	return nil
 }
+
+func TestRemainingLimit(t *testing.T) {
+	tests := []struct {
+		limit, received int64
+		want            int64
+		wantDone        bool
+	}{
+		{limit: 0, received: 0, want: 0},
+		{limit: 0, received: 10, want: 0},
+		{limit: 10, received: 0, want: 10},
+		{limit: 10, received: 4, want: 6},
+		// A complete read must not be resumed with a limit of 0, which would read to the end.
+		{limit: 10, received: 10, wantDone: true},
+		{limit: 10, received: 12, wantDone: true},
+	}
+	for _, tc := range tests {
+		got, done := remainingLimit(tc.limit, tc.received)
+		if got != tc.want || done != tc.wantDone {
+			t.Errorf("remainingLimit(%d, %d) = %d, %t, want %d, %t", tc.limit, tc.received, got, done, tc.want, tc.wantDone)
+		}
+	}
+}
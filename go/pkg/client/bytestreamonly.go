@@ -0,0 +1,116 @@
+package client
+
+import (
+	"context"
+
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/chunker"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/digest"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/uploadinfo"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	repb "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	log "github.com/golang/glog"
+)
+
+// BytestreamOnly is an Opt that makes the client avoid the batch CAS RPCs and GetTree, for
+// minimal servers that implement only the ByteStream API and FindMissingBlobs of the CAS. Blobs are
+// then transferred one at a time, and directory trees are read one directory at a time.
+//
+// The client also switches to this mode by itself the first time the server returns UNIMPLEMENTED
+// for BatchUpdateBlobs, BatchReadBlobs or GetTree.
+type BytestreamOnly bool
+
+// Apply sets the BytestreamOnly flag on a client.
+func (b BytestreamOnly) Apply(c *Client) {
+	c.bytestreamOnly.Store(bool(b))
+}
+
+// batchOps returns whether the client should use batch CAS RPCs.
+func (c *Client) batchOps() bool {
+	return bool(c.useBatchOps) && !c.bytestreamOnly.Load()
+}
+
+// fallBackToBytestream returns whether err means that the server does not implement the rpc, in
+// which case the client switches to bytestream-only mode.
+func (c *Client) fallBackToBytestream(rpc string, err error) bool {
+	if status.Code(err) != codes.Unimplemented {
+		return false
+	}
+	if !c.bytestreamOnly.Swap(true) {
+		log.Warningf("The server does not implement %s, switching to bytestream-only mode: %v", rpc, err)
+	}
+	return true
+}
+
+// writeBlobsIndividually is the bytestream-only equivalent of BatchWriteBlobs.
+func (c *Client) writeBlobsIndividually(ctx context.Context, blobs map[digest.Digest][]byte) (map[digest.Digest]error, error) {
+	failed := make(map[digest.Digest]error)
+	var lastErr error
+	for dg, blob := range blobs {
+		ue := uploadinfo.EntryFromBlobWith(c.digestFn, blob)
+		ch, err := chunker.New(ue, c.shouldCompressEntry(ue), int(c.ChunkMaxSize))
+		if err == nil {
+			_, err = c.writeChunked(ctx, c.writeRscName(ue), ch, false, 0)
+		}
+		if err != nil {
+			failed[dg] = err
+			lastErr = err
+		}
+	}
+	return failed, lastErr
+}
+
+// readBlobsIndividually is the bytestream-only equivalent of BatchDownloadBlobsWithStats.
+func (c *Client) readBlobsIndividually(ctx context.Context, dgs []digest.Digest) (map[digest.Digest]CompressedBlobInfo, map[digest.Digest]error, error) {
+	res := make(map[digest.Digest]CompressedBlobInfo)
+	failed := make(map[digest.Digest]error)
+	var lastErr error
+	for _, dg := range dgs {
+		if dg.Size == 0 {
+			res[c.digestFn.Empty()] = CompressedBlobInfo{}
+			continue
+		}
+		data, stats, err := c.readBlob(ctx, dg, 0, 0)
+		if err != nil {
+			failed[dg] = err
+			lastErr = err
+			continue
+		}
+		res[dg] = CompressedBlobInfo{CompressedSize: stats.RealMoved, Data: data}
+	}
+	return res, failed, lastErr
+}
+
+// readDirectoryTree is the bytestream-only equivalent of GetDirectoryTree. It reads the tree one
+// directory at a time, in breadth-first order.
+func (c *Client) readDirectoryTree(ctx context.Context, d *repb.Digest) ([]*repb.Directory, error) {
+	var result []*repb.Directory
+	seen := map[digest.Digest]bool{}
+	queue := []*repb.Digest{d}
+	for len(queue) > 0 {
+		dg, err := digest.NewFromProto(queue[0])
+		if err != nil {
+			return nil, err
+		}
+		queue = queue[1:]
+		if seen[dg] {
+			continue
+		}
+		seen[dg] = true
+		blob, _, err := c.readBlob(ctx, dg, 0, 0)
+		if err != nil {
+			return nil, err
+		}
+		dir := &repb.Directory{}
+		if err := proto.Unmarshal(blob, dir); err != nil {
+			return nil, err
+		}
+		result = append(result, dir)
+		for _, sub := range dir.Directories {
+			queue = append(queue, sub.Digest)
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,122 @@
+package client_test
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/client"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/digest"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/fakes"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/uploadinfo"
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/testing/protocmp"
+
+	regrpc "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	repb "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	bsgrpc "google.golang.org/genproto/googleapis/bytestream"
+)
+
+// minimalCAS is a CAS that implements only FindMissingBlobs besides the ByteStream API.
+type minimalCAS struct {
+	*fakes.CAS
+}
+
+func (minimalCAS) BatchUpdateBlobs(context.Context, *repb.BatchUpdateBlobsRequest) (*repb.BatchUpdateBlobsResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "BatchUpdateBlobs")
+}
+
+func (minimalCAS) BatchReadBlobs(context.Context, *repb.BatchReadBlobsRequest) (*repb.BatchReadBlobsResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "BatchReadBlobs")
+}
+
+func (minimalCAS) GetTree(*repb.GetTreeRequest, regrpc.ContentAddressableStorage_GetTreeServer) error {
+	return status.Error(codes.Unimplemented, "GetTree")
+}
+
+func TestBytestreamOnly(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name string
+		// minimal is whether the server lacks the batch RPCs and GetTree.
+		minimal bool
+		opts    []client.Opt
+	}{
+		{name: "auto-detected", minimal: true},
+		{name: "forced", opts: []client.Opt{client.BytestreamOnly(true)}},
+	}
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			ctx := context.Background()
+			listener, err := net.Listen("tcp", ":0")
+			if err != nil {
+				t.Fatalf("Cannot listen: %v", err)
+			}
+			defer listener.Close()
+			fake := fakes.NewCAS()
+			server := grpc.NewServer()
+			if tc.minimal {
+				regrpc.RegisterContentAddressableStorageServer(server, minimalCAS{fake})
+			} else {
+				regrpc.RegisterContentAddressableStorageServer(server, fake)
+			}
+			bsgrpc.RegisterByteStreamServer(server, fake)
+			go server.Serve(listener)
+			defer server.Stop()
+			opts := append([]client.Opt{client.StartupCapabilities(false)}, tc.opts...)
+			c, err := client.NewClient(ctx, instance, client.DialParams{
+				Service:    listener.Addr().String(),
+				NoSecurity: true,
+			}, opts...)
+			if err != nil {
+				t.Fatalf("Error connecting to server: %v", err)
+			}
+			defer c.Close()
+
+			foo, bar := []byte("foo"), []byte("bar")
+			fooDg, barDg := digest.NewFromBlob(foo), digest.NewFromBlob(bar)
+			if _, _, err := c.UploadIfMissing(ctx, uploadinfo.EntryFromBlob(foo), uploadinfo.EntryFromBlob(bar)); err != nil {
+				t.Fatalf("UploadIfMissing() failed: %v", err)
+			}
+			for _, dg := range []digest.Digest{fooDg, barDg} {
+				if _, ok := fake.Get(dg); !ok {
+					t.Errorf("blob %v was not uploaded", dg)
+				}
+			}
+			got, err := c.BatchDownloadBlobs(ctx, []digest.Digest{fooDg, barDg})
+			if err != nil {
+				t.Fatalf("BatchDownloadBlobs() failed: %v", err)
+			}
+			if diff := cmp.Diff(map[digest.Digest][]byte{fooDg: foo, barDg: bar}, got); diff != "" {
+				t.Errorf("BatchDownloadBlobs() gave diff (-want +got):\n%s", diff)
+			}
+
+			child := &repb.Directory{Files: []*repb.FileNode{{Name: "foo", Digest: fooDg.ToProto()}}}
+			childBlob, err := proto.Marshal(child)
+			if err != nil {
+				t.Fatalf("failed to marshal Directory: %v", err)
+			}
+			root := &repb.Directory{Directories: []*repb.DirectoryNode{{Name: "child", Digest: fake.Put(childBlob).ToProto()}}}
+			rootBlob, err := proto.Marshal(root)
+			if err != nil {
+				t.Fatalf("failed to marshal Directory: %v", err)
+			}
+			tree, err := c.GetDirectoryTree(ctx, fake.Put(rootBlob).ToProto())
+			if err != nil {
+				t.Fatalf("GetDirectoryTree() failed: %v", err)
+			}
+			if diff := cmp.Diff([]*repb.Directory{root, child}, tree, protocmp.Transform()); diff != "" {
+				t.Errorf("GetDirectoryTree() gave diff (-want +got):\n%s", diff)
+			}
+			if n := fake.BatchReqs(); n != 0 {
+				t.Errorf("the client sent %d batch requests, want 0", n)
+			}
+		})
+	}
+}
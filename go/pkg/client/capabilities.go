@@ -3,12 +3,38 @@ package client
 import (
 	"context"
 
-	"github.com/bazelbuild/remote-apis-sdks/go/pkg/digest"
 	"github.com/pkg/errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 
 	repb "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	svpb "github.com/bazelbuild/remote-apis/build/bazel/semver"
+	log "github.com/golang/glog"
 )
 
+// FallbackCapabilities are the capabilities assumed when the server does not implement
+// GetCapabilities, as is the case for some proxies and legacy deployments. Missing fields are
+// treated as in a server reply: for example, leaving CacheCapabilities unset keeps the configured
+// MaxBatchSize. A nil ServerCapabilities disables the fallback.
+type FallbackCapabilities struct {
+	*repb.ServerCapabilities
+}
+
+// Apply sets the fallback capabilities of a client.
+func (f FallbackCapabilities) Apply(c *Client) {
+	c.fallbackCaps = f.ServerCapabilities
+}
+
+// DefaultFallbackCapabilities returns the conservative capabilities assumed by default when the
+// server does not implement GetCapabilities: the lowest version of the v2 API, no compression, and
+// the configured batch size.
+func DefaultFallbackCapabilities() *repb.ServerCapabilities {
+	return &repb.ServerCapabilities{
+		LowApiVersion:  &svpb.SemVer{Major: 2},
+		HighApiVersion: &svpb.SemVer{Major: 2},
+	}
+}
+
 // CheckCapabilities verifies that this client can work with the remote server
 // in terms of API version and digest function. It sets some client parameters
 // according to remote server preferences, like MaxBatchSize.
@@ -16,12 +42,28 @@ func (c *Client) CheckCapabilities(ctx context.Context) (err error) {
 	// Only query the server once. There is no need for a lock, because we will
 	// usually make the call on startup.
 	if c.serverCaps == nil {
-		if c.serverCaps, err = c.GetCapabilities(ctx); err != nil {
+		caps, err := c.GetCapabilities(ctx)
+		switch {
+		case status.Code(err) == codes.Unimplemented && c.fallbackCaps != nil:
+			log.Warningf("The server does not implement GetCapabilities, assuming %v", c.fallbackCaps)
+			caps = c.fallbackCaps
+		case err != nil:
 			return err
 		}
+		c.serverCaps = caps
 	}
 
-	if err := digest.CheckCapabilities(c.serverCaps); err != nil {
+	if c.negotiateDigestFunction {
+		fn, err := c.digestFn.Negotiate(c.serverCaps)
+		if err != nil {
+			return err
+		}
+		if fn.Value() != c.digestFn.Value() {
+			log.Infof("Using the digest function %v required by the server", fn)
+		}
+		c.digestFn = fn
+	}
+	if err := c.digestFn.CheckCapabilities(c.serverCaps); err != nil {
 		return errors.Wrapf(err, "digest function mismatch")
 	}
 
@@ -30,19 +72,13 @@ func (c *Client) CheckCapabilities(ctx context.Context) (err error) {
 	}
 
 	if useCompression := c.CompressedBytestreamThreshold >= 0; useCompression {
-		if c.serverCaps.CacheCapabilities.SupportedCompressors == nil {
-			return errors.New("the server does not support compression")
-		}
-
-		foundZstd := false
-		for _, sComp := range c.serverCaps.CacheCapabilities.SupportedCompressors {
-			if sComp == repb.Compressor_ZSTD {
-				foundZstd = true
-				break
+		if err := checkZstdSupport(c.serverCaps); err != nil {
+			if !c.negotiateCompression {
+				return err
 			}
-		}
-		if !foundZstd {
-			return errors.New("zstd is not supported by server, while the SDK only supports ZSTD compression")
+			log.Warningf("Disabling compression: %v", err)
+			c.CompressedBytestreamThreshold = -1
+			return nil
 		}
 		for _, compressor := range c.serverCaps.CacheCapabilities.SupportedBatchUpdateCompressors {
 			if compressor == repb.Compressor_ZSTD {
@@ -53,6 +89,20 @@ func (c *Client) CheckCapabilities(ctx context.Context) (err error) {
 	return nil
 }
 
+// checkZstdSupport returns an error if the server does not support zstd compression of
+// ByteStream blobs, the only compression the SDK supports.
+func checkZstdSupport(caps *repb.ServerCapabilities) error {
+	if caps.GetCacheCapabilities().GetSupportedCompressors() == nil {
+		return errors.New("the server does not support compression")
+	}
+	for _, sComp := range caps.CacheCapabilities.SupportedCompressors {
+		if sComp == repb.Compressor_ZSTD {
+			return nil
+		}
+	}
+	return errors.New("zstd is not supported by server, while the SDK only supports ZSTD compression")
+}
+
 // GetCapabilities returns the capabilities for the targeted servers.
 // If the CAS URL was set differently to the execution server then the CacheCapabilities will
 // be determined from that; ExecutionCapabilities will always come from the main URL.
@@ -86,11 +136,17 @@ func (c *Client) SupportsActionPlatformProperties() bool {
 }
 
 // SupportsCommandOutputPaths returns whether the server's RE API version
-// supports the `Command.action_paths` field.
+// supports the `Command.output_paths` field.
 func (c *Client) SupportsCommandOutputPaths() bool {
 	return supportsCommandOutputPaths(c.serverCaps)
 }
 
+// SupportsAbsoluteSymlinks returns whether the server allows symlinks with absolute targets, as
+// reported by `CacheCapabilities.symlink_absolute_path_strategy`.
+func (c *Client) SupportsAbsoluteSymlinks() bool {
+	return c.serverCaps.GetCacheCapabilities().GetSymlinkAbsolutePathStrategy() == repb.SymlinkAbsolutePathStrategy_ALLOWED
+}
+
 // HighAPIVersionNewerThanOrEqualTo returns whether the latest version reported
 // as supported in ServerCapabilities matches or is more recent than a
 // reference major/minor version.
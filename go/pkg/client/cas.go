@@ -2,10 +2,12 @@ package client
 
 import (
 	"context"
+	"fmt"
 	"io"
 	"os"
 	"path/filepath"
 	"sort"
+	"sync"
 
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/contextmd"
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/digest"
@@ -113,12 +115,84 @@ func (c *Client) makeBatches(ctx context.Context, dgs []digest.Digest, optimizeS
 	return batches
 }
 
+// BatchError is the error of an operation on many blobs, of which some blobs failed.
+type BatchError struct {
+	// Op is the name of the operation, such as "write" or "read".
+	Op string
+	// Blobs are the errors of the blobs that failed, by digest.
+	Blobs map[digest.Digest]error
+}
+
+func (e *BatchError) Error() string {
+	dgs := make([]digest.Digest, 0, len(e.Blobs))
+	for dg := range e.Blobs {
+		dgs = append(dgs, dg)
+	}
+	sort.Slice(dgs, func(i, j int) bool { return dgs[i].String() < dgs[j].String() })
+	return fmt.Sprintf("failed to %s %d blobs, including %s: %v", e.Op, len(dgs), dgs[0], e.Blobs[dgs[0]])
+}
+
+// inBatches runs op on batches of the digests concurrently, as made by makeBatches if batch
+// operations are used, or else one digest at a time. The transfer of each batch is scheduled with
+// acquire. It returns the errors of the blobs that failed, by digest: the errors returned by op for
+// its blobs, or else the error of op for all the blobs of its batch.
+func (c *Client) inBatches(ctx context.Context, dgs []digest.Digest, acquire func(context.Context, int64) (func(), error), op func([]digest.Digest) (map[digest.Digest]error, error)) map[digest.Digest]error {
+	// makeBatches sorts the digests in place.
+	dgs = append([]digest.Digest(nil), dgs...)
+	var batches [][]digest.Digest
+	if c.batchOps() {
+		batches = c.makeBatches(ctx, dgs, true)
+	} else {
+		for i := range dgs {
+			batches = append(batches, dgs[i:i+1])
+		}
+	}
+	failed := make(map[digest.Digest]error)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, batch := range batches {
+		batch := batch
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var batchFailed map[digest.Digest]error
+			release, err := acquire(ctx, batchSize(batch))
+			if err == nil {
+				batchFailed, err = op(batch)
+				release()
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			for dg, e := range batchFailed {
+				failed[dg] = e
+			}
+			if err != nil && len(batchFailed) == 0 {
+				for _, dg := range batch {
+					failed[dg] = err
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return failed
+}
+
+// makeQueryBatches splits the digests into batches for FindMissingBlobs, of at most
+// MaxQueryBatchDigests digests and, unless a single digest exceeds it, MaxBatchSize bytes per
+// request.
 func (c *Client) makeQueryBatches(ctx context.Context, digests []digest.Digest) [][]digest.Digest {
 	var batches [][]digest.Digest
+	// The request also has the instance name and the digest function.
+	overhead := marshalledFieldSize(int64(len(c.InstanceName))) + 2
 	for len(digests) > 0 {
-		batchSize := int(c.MaxQueryBatchDigests)
-		if len(digests) < int(c.MaxQueryBatchDigests) {
-			batchSize = len(digests)
+		batchSize, reqSize := 0, overhead
+		for batchSize < len(digests) && batchSize < int(c.MaxQueryBatchDigests) {
+			sz := marshalledDigestSize(digests[batchSize])
+			if batchSize > 0 && reqSize+sz > int64(c.MaxBatchSize) {
+				break
+			}
+			reqSize += sz
+			batchSize++
 		}
 		batch := make([]digest.Digest, 0, batchSize)
 		for i := 0; i < batchSize; i++ {
@@ -135,6 +209,16 @@ func marshalledFieldSize(size int64) int64 {
 	return 1 + int64(protowire.SizeVarint(uint64(size))) + size
 }
 
+// marshalledDigestSize returns the size of the digest as a repeated Digest field, such as
+// FindMissingBlobsRequest.blob_digests.
+func marshalledDigestSize(d digest.Digest) int64 {
+	digestSize := marshalledFieldSize(int64(len(d.Hash)))
+	if d.Size > 0 {
+		digestSize += 1 + int64(protowire.SizeVarint(uint64(d.Size)))
+	}
+	return marshalledFieldSize(digestSize)
+}
+
 func marshalledRequestSize(d digest.Digest) int64 {
 	// An additional BatchUpdateBlobsRequest_Request includes the Digest and data fields,
 	// as well as the message itself. Every field has a 1-byte size tag, followed by
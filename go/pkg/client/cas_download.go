@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"path"
 	"path/filepath"
 	"sort"
 	"strconv"
@@ -29,6 +30,11 @@ import (
 // It returns the number of logical and real bytes downloaded, which may be different from sum
 // of sizes of the files due to dedupping and compression.
 func (c *Client) DownloadFiles(ctx context.Context, outDir string, outputs map[digest.Digest]*TreeOutput) (*MovedBytesMetadata, error) {
+	ctx, done, err := c.beginOp(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
 	stats := &MovedBytesMetadata{}
 
 	if !c.UnifiedDownloads {
@@ -78,11 +84,21 @@ func (c *Client) DownloadFiles(ctx context.Context, outDir string, outputs map[d
 	return stats, nil
 }
 
-// DownloadOutputs downloads the specified outputs. It returns the amount of downloaded bytes.
+// DownloadOutputs downloads the specified outputs, or hands them over to the client's
+// OutputService if it has one. It returns the amount of downloaded bytes.
 // It returns the number of logical and real bytes downloaded, which may be different from sum
 // of sizes of the files due to dedupping and compression.
 func (c *Client) DownloadOutputs(ctx context.Context, outs map[string]*TreeOutput, outDir string, cache filemetadata.Cache) (*MovedBytesMetadata, error) {
-	var symlinks, copies []*TreeOutput
+	ctx, done, err := c.beginOp(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	if c.OutputService != nil {
+		return c.putOutputs(ctx, outs, outDir)
+	}
+	cache = c.FileMetadataCache(cache)
+	var symlinks, copies, inlined, cased []*TreeOutput
 	downloads := make(map[digest.Digest]*TreeOutput)
 	fullStats := &MovedBytesMetadata{}
 	for _, out := range outs {
@@ -96,12 +112,29 @@ func (c *Client) DownloadOutputs(ctx context.Context, outs map[string]*TreeOutpu
 		if err := os.MkdirAll(filepath.Dir(path), c.DirMode); err != nil {
 			return fullStats, err
 		}
+		// Outputs made read-only by a previous download cannot be overwritten in place.
+		if c.ReadOnlyOutputs {
+			if err := removeNonDir(path); err != nil {
+				return fullStats, err
+			}
+		}
 		// We create the symbolic links after all regular downloads are finished, because dangling
 		// links will not work.
 		if out.SymlinkTarget != "" {
+			if err := c.checkOutputSymlink(out); err != nil {
+				return fullStats, err
+			}
 			symlinks = append(symlinks, out)
 			continue
 		}
+		if out.inlined(c.digestFn) {
+			inlined = append(inlined, out)
+			continue
+		}
+		if c.LocalCAS != nil {
+			cased = append(cased, out)
+			continue
+		}
 		if _, ok := downloads[out.Digest]; ok {
 			copies = append(copies, out)
 			// All copies are effectivelly cached
@@ -131,6 +164,41 @@ func (c *Client) DownloadOutputs(ctx context.Context, outs map[string]*TreeOutpu
 			return fullStats, err
 		}
 	}
+	if len(cased) > 0 {
+		stats, err := c.LocalCAS.materialize(ctx, c, outDir, cased)
+		fullStats.addFrom(stats)
+		if err != nil {
+			return fullStats, err
+		}
+		for _, out := range cased {
+			md := &filemetadata.Metadata{
+				Digest:       out.Digest,
+				IsExecutable: out.IsExecutable,
+			}
+			if err := cache.Update(filepath.Join(outDir, out.Path), md); err != nil {
+				return fullStats, err
+			}
+		}
+	}
+	for _, out := range inlined {
+		perm := c.RegularMode
+		if out.IsExecutable {
+			perm = c.ExecutableMode
+		}
+		if err := os.WriteFile(filepath.Join(outDir, out.Path), out.Contents, perm); err != nil {
+			return fullStats, err
+		}
+		fullStats.Requested += out.Digest.Size
+		fullStats.LogicalMoved += out.Digest.Size
+		fullStats.RealMoved += out.Digest.Size
+		md := &filemetadata.Metadata{
+			Digest:       out.Digest,
+			IsExecutable: out.IsExecutable,
+		}
+		if err := cache.Update(filepath.Join(outDir, out.Path), md); err != nil {
+			return fullStats, err
+		}
+	}
 	for _, out := range copies {
 		perm := c.RegularMode
 		if out.IsExecutable {
@@ -149,13 +217,127 @@ func (c *Client) DownloadOutputs(ctx context.Context, outs map[string]*TreeOutpu
 			return fullStats, err
 		}
 	}
+	if err := c.setOutputMtimes(outs, outDir); err != nil {
+		return fullStats, err
+	}
+	if err := c.setOutputPerms(outs, outDir); err != nil {
+		return fullStats, err
+	}
 	return fullStats, nil
 }
 
+// removeNonDir removes path if it exists and is not a directory.
+func removeNonDir(path string) error {
+	fi, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if fi.IsDir() {
+		return nil
+	}
+	return os.Remove(path)
+}
+
+// hasOutputPermPolicy returns whether the client changes the permissions of downloaded outputs
+// beyond the modes they are created with.
+func (c *Client) hasOutputPermPolicy() bool {
+	return c.DownloadUmask != 0 || bool(c.ReadOnlyOutputs) || c.OutputPermissionFunc != nil
+}
+
+// OutputPerm returns the permissions that should be set on a downloaded output.
+func (c *Client) OutputPerm(out *TreeOutput) os.FileMode {
+	perm := c.RegularMode
+	if out.IsEmptyDirectory {
+		perm = c.DirMode
+	} else if out.IsExecutable {
+		perm = c.ExecutableMode
+	}
+	perm &^= os.FileMode(c.DownloadUmask)
+	if c.ReadOnlyOutputs {
+		perm &^= 0222
+	}
+	if c.OutputPermissionFunc != nil {
+		perm = c.OutputPermissionFunc(out.Path, perm)
+	}
+	return perm.Perm()
+}
+
+// setOutputPerms sets the permissions of the downloaded outputs according to the client's
+// DownloadUmask, ReadOnlyOutputs and OutputPermissionFunc. Symlinks are left as is, since changing
+// their permissions would modify their targets instead.
+func (c *Client) setOutputPerms(outs map[string]*TreeOutput, outDir string) error {
+	if !c.hasOutputPermPolicy() {
+		return nil
+	}
+	for _, out := range outs {
+		if out.SymlinkTarget != "" {
+			continue
+		}
+		if err := os.Chmod(filepath.Join(outDir, out.Path), c.OutputPerm(out)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// outputMtime returns the modification time that should be set on a downloaded output, or the zero
+// time if it should be left as is.
+func (c *Client) outputMtime(out *TreeOutput) time.Time {
+	if mt := out.NodeProperties.GetMtime(); bool(c.RestoreOutputMtimes) && mt != nil {
+		return mt.AsTime()
+	}
+	return time.Time(c.OutputMtime)
+}
+
+// setsOutputMtimes returns whether the client sets the modification times of downloaded outputs.
+func (c *Client) setsOutputMtimes() bool {
+	return bool(c.RestoreOutputMtimes) || !time.Time(c.OutputMtime).IsZero()
+}
+
+// setOutputMtimes sets the modification times of the downloaded outputs according to the client's
+// RestoreOutputMtimes and OutputMtime. Symlinks are left as is, since setting their times would
+// modify their targets instead.
+func (c *Client) setOutputMtimes(outs map[string]*TreeOutput, outDir string) error {
+	if !c.setsOutputMtimes() {
+		return nil
+	}
+	for _, out := range outs {
+		if out.SymlinkTarget != "" {
+			continue
+		}
+		mt := c.outputMtime(out)
+		if mt.IsZero() {
+			continue
+		}
+		if err := os.Chtimes(filepath.Join(outDir, out.Path), mt, mt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DownloadFilter selects the outputs of a directory to download. The paths of the outputs are
+// relative to the directory.
+type DownloadFilter func(out *TreeOutput) bool
+
 // DownloadDirectory downloads the entire directory of given digest.
 // It returns the number of logical and real bytes downloaded, which may be different from sum
 // of sizes of the files due to dedupping and compression.
 func (c *Client) DownloadDirectory(ctx context.Context, d digest.Digest, outDir string, cache filemetadata.Cache) (map[string]*TreeOutput, *MovedBytesMetadata, error) {
+	return c.DownloadDirectoryFiltered(ctx, d, outDir, cache, nil)
+}
+
+// DownloadDirectoryFiltered is like DownloadDirectory, but only downloads the outputs selected by
+// filter, unless it is nil. It returns the outputs downloaded.
+func (c *Client) DownloadDirectoryFiltered(ctx context.Context, d digest.Digest, outDir string, cache filemetadata.Cache, filter DownloadFilter) (map[string]*TreeOutput, *MovedBytesMetadata, error) {
+	ctx, done, err := c.beginOp(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer done()
 	dir := &repb.Directory{}
 	stats := &MovedBytesMetadata{}
 
@@ -177,7 +359,43 @@ func (c *Client) DownloadDirectory(ctx context.Context, d digest.Digest, outDir
 	if err != nil {
 		return nil, stats, err
 	}
+	return c.downloadFiltered(ctx, outputs, outDir, cache, filter, stats)
+}
 
+// DownloadTree downloads the directory of the Tree proto of the given digest, such as the
+// tree_digest of an OutputDirectory, to outDir. Only the outputs selected by filter are
+// downloaded, unless it is nil. Unlike DownloadDirectory, it reads no Directory protos, since the
+// Tree holds all of them. It returns the outputs downloaded.
+func (c *Client) DownloadTree(ctx context.Context, treeDigest digest.Digest, outDir string, cache filemetadata.Cache, filter DownloadFilter) (map[string]*TreeOutput, *MovedBytesMetadata, error) {
+	ctx, done, err := c.beginOp(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer done()
+	tree := &repb.Tree{}
+	stats := &MovedBytesMetadata{}
+
+	protoStats, err := c.ReadProto(ctx, treeDigest, tree)
+	stats.addFrom(protoStats)
+	if err != nil {
+		return nil, stats, fmt.Errorf("digest %v cannot be mapped to a tree proto: %v", treeDigest, err)
+	}
+	outputs, err := c.FlattenTree(tree, "")
+	if err != nil {
+		return nil, stats, err
+	}
+	return c.downloadFiltered(ctx, outputs, outDir, cache, filter, stats)
+}
+
+// downloadFiltered downloads the outputs selected by filter, unless it is nil, adding to stats.
+func (c *Client) downloadFiltered(ctx context.Context, outputs map[string]*TreeOutput, outDir string, cache filemetadata.Cache, filter DownloadFilter, stats *MovedBytesMetadata) (map[string]*TreeOutput, *MovedBytesMetadata, error) {
+	if filter != nil {
+		for path, out := range outputs {
+			if !filter(out) {
+				delete(outputs, path)
+			}
+		}
+	}
 	outStats, err := c.DownloadOutputs(ctx, outputs, outDir, cache)
 	stats.addFrom(outStats)
 	return outputs, stats, err
@@ -194,10 +412,20 @@ type CompressedBlobInfo struct {
 }
 
 func (c *Client) BatchDownloadBlobsWithStats(ctx context.Context, dgs []digest.Digest) (map[digest.Digest]CompressedBlobInfo, error) {
+	res, _, err := c.batchDownloadBlobs(ctx, dgs)
+	return res, err
+}
+
+// batchDownloadBlobs is BatchDownloadBlobsWithStats, also returning the final error of each blob
+// that failed.
+func (c *Client) batchDownloadBlobs(ctx context.Context, dgs []digest.Digest) (map[digest.Digest]CompressedBlobInfo, map[digest.Digest]error, error) {
+	if c.bytestreamOnly.Load() {
+		return c.readBlobsIndividually(ctx, dgs)
+	}
 	if len(dgs) > int(c.MaxBatchDigests) {
-		return nil, fmt.Errorf("batch read of %d total blobs exceeds maximum of %d", len(dgs), c.MaxBatchDigests)
+		return nil, nil, fmt.Errorf("batch read of %d total blobs exceeds maximum of %d", len(dgs), c.MaxBatchDigests)
 	}
-	req := &repb.BatchReadBlobsRequest{InstanceName: c.InstanceName}
+	req := &repb.BatchReadBlobsRequest{InstanceName: c.InstanceName, DigestFunction: c.digestFn.Value()}
 	if c.useBatchCompression {
 		req.AcceptableCompressors = []repb.Compressor_Value{repb.Compressor_ZSTD}
 	}
@@ -212,11 +440,12 @@ func (c *Client) BatchDownloadBlobsWithStats(ctx context.Context, dgs []digest.D
 		req.Digests = append(req.Digests, dg.ToProto())
 	}
 	if sz > int64(c.MaxBatchSize) {
-		return nil, fmt.Errorf("batch read of %d total bytes exceeds maximum of %d", sz, c.MaxBatchSize)
+		return nil, nil, fmt.Errorf("batch read of %d total bytes exceeds maximum of %d", sz, c.MaxBatchSize)
 	}
 	res := make(map[digest.Digest]CompressedBlobInfo)
+	failed := make(map[digest.Digest]error)
 	if foundEmpty {
-		res[digest.Empty] = CompressedBlobInfo{}
+		res[c.digestFn.Empty()] = CompressedBlobInfo{}
 	}
 	opts := c.RPCOpts()
 	closure := func() error {
@@ -234,9 +463,11 @@ func (c *Client) BatchDownloadBlobsWithStats(ctx context.Context, dgs []digest.D
 		var retriableError error
 		allRetriable := true
 		for _, r := range resp.Responses {
+			dg := digest.NewFromProtoUnvalidated(r.Digest)
 			st := status.FromProto(r.Status)
 			if st.Code() != codes.OK {
 				e := st.Err()
+				failed[dg] = e
 				if c.Retrier.ShouldRetry(e) {
 					failedDgs = append(failedDgs, r.Digest)
 					retriableError = e
@@ -255,21 +486,29 @@ func (c *Client) BatchDownloadBlobsWithStats(ctx context.Context, dgs []digest.D
 					CompressedSize = len(r.Data)
 					b, err := zstdDecoder.DecodeAll(r.Data, nil)
 					if err != nil {
+						numErrs++
+						allRetriable = false
 						errDg = r.Digest
 						errMsg = err.Error()
+						failed[dg] = err
 						continue
 					}
 					r.Data = b
 				default:
+					numErrs++
+					allRetriable = false
 					errDg = r.Digest
-					errMsg = fmt.Sprintf("blob returned with unsupported compressor %s", r.Compressor)
+					e := fmt.Errorf("blob returned with unsupported compressor %s", r.Compressor)
+					errMsg = e.Error()
+					failed[dg] = e
 					continue
 				}
 				bi := CompressedBlobInfo{
 					CompressedSize: int64(CompressedSize),
 					Data:           r.Data,
 				}
-				res[digest.NewFromProtoUnvalidated(r.Digest)] = bi
+				res[dg] = bi
+				delete(failed, dg)
 			}
 		}
 		req.Digests = failedDgs
@@ -281,7 +520,19 @@ func (c *Client) BatchDownloadBlobsWithStats(ctx context.Context, dgs []digest.D
 		}
 		return nil
 	}
-	return res, c.Retrier.Do(ctx, closure)
+	err := c.retryRPC(ctx, closure)
+	if c.fallBackToBytestream("BatchReadBlobs", err) {
+		return c.readBlobsIndividually(ctx, dgs)
+	}
+	if err != nil {
+		// The blobs still to be read failed with the last error, unless they failed themselves.
+		for _, d := range req.Digests {
+			if dg := digest.NewFromProtoUnvalidated(d); failed[dg] == nil {
+				failed[dg] = err
+			}
+		}
+	}
+	return res, failed, err
 }
 
 // BatchDownloadBlobs downloads a number of blobs from the CAS to memory. They must collectively be below the
@@ -297,6 +548,48 @@ func (c *Client) BatchDownloadBlobs(ctx context.Context, dgs []digest.Digest) (m
 	return res, err
 }
 
+// DownloadBlobsInBatches downloads blobs from the CAS to memory, like BatchDownloadBlobs but
+// without its size limits: the blobs are packed into as few BatchReadBlobs requests as MaxBatchSize
+// and MaxBatchDigests allow, and the blobs too large for a batch are read with ByteStream. All the
+// blobs are attempted, and the blobs read are returned even if some failed, in which case the error
+// is a *BatchError.
+func (c *Client) DownloadBlobsInBatches(ctx context.Context, dgs []digest.Digest) (map[digest.Digest][]byte, error) {
+	res := make(map[digest.Digest][]byte)
+	var mu sync.Mutex
+	seen := make(map[digest.Digest]bool)
+	var toRead []digest.Digest
+	for _, dg := range dgs {
+		if dg.Size == 0 {
+			res[dg] = nil
+		} else if !seen[dg] {
+			seen[dg] = true
+			toRead = append(toRead, dg)
+		}
+	}
+	failed := c.inBatches(ctx, toRead, c.acquireDownload, func(batch []digest.Digest) (map[digest.Digest]error, error) {
+		var (
+			biRes       map[digest.Digest]CompressedBlobInfo
+			batchFailed map[digest.Digest]error
+			err         error
+		)
+		if len(batch) > 1 {
+			biRes, batchFailed, err = c.batchDownloadBlobs(ctx, batch)
+		} else {
+			biRes, batchFailed, err = c.readBlobsIndividually(ctx, batch)
+		}
+		mu.Lock()
+		for dg, bi := range biRes {
+			res[dg] = bi.Data
+		}
+		mu.Unlock()
+		return batchFailed, err
+	})
+	if len(failed) > 0 {
+		return res, &BatchError{Op: "read", Blobs: failed}
+	}
+	return res, nil
+}
+
 // ReadBlob fetches a blob from the CAS into a byte slice.
 // Returns the size of the blob and the amount of bytes moved through the wire.
 func (c *Client) ReadBlob(ctx context.Context, d digest.Digest) ([]byte, *MovedBytesMetadata, error) {
@@ -322,6 +615,57 @@ func (c *Client) ReadBlobToFile(ctx context.Context, d digest.Digest, fpath stri
 	return c.readBlobStreamed(ctx, d, 0, 0, f)
 }
 
+// ReadBlobTo fetches a blob from the CAS and streams it into w, without buffering it in memory.
+// A retried read resumes where the previous attempt stopped, so w receives every byte once.
+func (c *Client) ReadBlobTo(ctx context.Context, d digest.Digest, w io.Writer) (*MovedBytesMetadata, error) {
+	return c.readBlobStreamed(ctx, d, 0, 0, w)
+}
+
+// DefaultReadRangeSize is the default size of the ranges ReadBlobToWriterAt reads in parallel.
+const DefaultReadRangeSize = 16 * 1024 * 1024
+
+// ReadBlobToWriterAt fetches a blob from the CAS into w, reading ranges of rangeSize bytes, or
+// DefaultReadRangeSize if it is not positive, in parallel up to the CAS concurrency. The ranges are
+// read back from w to verify the digest of the blob, so w must also be an io.ReaderAt, such as an
+// *os.File, for the blob to be read in ranges. Compressed reads cannot be ranged, so blobs the
+// client reads compressed are streamed in one range, as are blobs written to other writers.
+func (c *Client) ReadBlobToWriterAt(ctx context.Context, d digest.Digest, w io.WriterAt, rangeSize int64) (*MovedBytesMetadata, error) {
+	if rangeSize <= 0 {
+		rangeSize = DefaultReadRangeSize
+	}
+	r, ok := w.(io.ReaderAt)
+	if !ok || c.shouldCompress(d.Size) || d.Size <= rangeSize {
+		return c.readBlobStreamed(ctx, d, 0, 0, io.NewOffsetWriter(w, 0))
+	}
+	stats := &MovedBytesMetadata{Requested: d.Size}
+	var mu sync.Mutex
+	eg, eCtx := errgroup.WithContext(ctx)
+	eg.SetLimit(int(c.downloadConcurrency))
+	for off := int64(0); off < d.Size; off += rangeSize {
+		off := off
+		eg.Go(func() error {
+			s, err := c.readBlobStreamed(eCtx, d, off, rangeSize, io.NewOffsetWriter(w, off))
+			mu.Lock()
+			stats.LogicalMoved += s.LogicalMoved
+			stats.RealMoved += s.RealMoved
+			mu.Unlock()
+			return err
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return stats, err
+	}
+	// Ranges are not digested individually, so the assembled blob is.
+	dw := c.digestFn.NewWriter()
+	if _, err := io.Copy(dw, io.NewSectionReader(r, 0, d.Size)); err != nil {
+		return stats, fmt.Errorf("failed to read back blob %s: %w", d, err)
+	}
+	if dg := dw.Digest(); dg != d {
+		return stats, fmt.Errorf("calculated digest %s != expected digest %s", dg, d)
+	}
+	return stats, nil
+}
+
 // ReadProto reads a blob from the CAS and unmarshals it into the given message.
 // Returns the size of the proto and the amount of bytes moved through the wire.
 func (c *Client) ReadProto(ctx context.Context, d digest.Digest, msg proto.Message) (*MovedBytesMetadata, error) {
@@ -371,9 +715,18 @@ func (c *Client) readBlobStreamed(ctx context.Context, d digest.Digest, offset,
 	if limit > 0 && limit < sz {
 		sz = limit
 	}
-	wt := newWriteTracker(w)
+	wt := newWriteTracker(c.digestFn, w)
 	defer func() { stats.LogicalMoved = wt.n }()
+	attempts := 0
 	closure := func() (err error) {
+		remaining, complete := remainingLimit(limit, wt.n)
+		if complete {
+			return nil
+		}
+		attempts++
+		if attempts > 1 {
+			c.countReadRetry(wt.n)
+		}
 		name, wc, done, e := c.maybeCompressReadBlob(d, wt)
 		if e != nil {
 			return e
@@ -396,7 +749,8 @@ func (c *Client) readBlobStreamed(ctx context.Context, d digest.Digest, offset,
 			}
 		}()
 
-		wireBytes, err := c.readStreamed(ctx, name, offset+wt.n, limit, wc)
+		// Retries resume from the data already received.
+		wireBytes, err := c.readStreamed(ctx, name, offset+wt.n, remaining, wc)
 		stats.RealMoved += wireBytes
 		if err != nil {
 			return err
@@ -404,7 +758,7 @@ func (c *Client) readBlobStreamed(ctx context.Context, d digest.Digest, offset,
 		return nil
 	}
 	// Only retry on transient backend issues.
-	if err := c.Retrier.Do(ctx, closure); err != nil {
+	if err := c.retryRPC(ctx, closure); err != nil {
 		return stats, err
 	}
 	if wt.n != sz {
@@ -413,17 +767,8 @@ func (c *Client) readBlobStreamed(ctx context.Context, d digest.Digest, offset,
 
 	// Incomplete reads only, since we can't reliably calculate hash without the full blob
 	if d.Size == sz {
-		// Signal for writeTracker to take the digest of the data.
-		if err := wt.Close(); err != nil {
-			return stats, err
-		}
-		// Wait for the digest to be ready.
-		if err := <-wt.ready; err != nil {
-			return stats, err
-		}
-		close(wt.ready)
-		if wt.dg != d {
-			return stats, fmt.Errorf("calculated digest %s != expected digest %s", wt.dg, d)
+		if dg := wt.dw.Digest(); dg != d {
+			return stats, fmt.Errorf("calculated digest %s != expected digest %s", dg, d)
 		}
 	}
 
@@ -436,13 +781,17 @@ func (c *Client) GetDirectoryTree(ctx context.Context, d *repb.Digest) (result [
 	if digest.NewFromProtoUnvalidated(d).IsEmpty() {
 		return []*repb.Directory{&repb.Directory{}}, nil
 	}
+	if c.bytestreamOnly.Load() {
+		return c.readDirectoryTree(ctx, d)
+	}
 	pageTok := ""
 	result = []*repb.Directory{}
 	closure := func(ctx context.Context) error {
 		stream, err := c.GetTree(ctx, &repb.GetTreeRequest{
-			InstanceName: c.InstanceName,
-			RootDigest:   d,
-			PageToken:    pageTok,
+			InstanceName:   c.InstanceName,
+			RootDigest:     d,
+			PageToken:      pageTok,
+			DigestFunction: c.digestFn.Value(),
 		})
 		if err != nil {
 			return err
@@ -461,21 +810,77 @@ func (c *Client) GetDirectoryTree(ctx context.Context, d *repb.Digest) (result [
 		}
 		return nil
 	}
-	if err := c.Retrier.Do(ctx, func() error { return c.CallWithTimeout(ctx, "GetTree", closure) }); err != nil {
+	if err := c.retryRPC(ctx, func() error { return c.CallWithTimeout(ctx, "GetTree", closure) }); err != nil {
+		if c.fallBackToBytestream("GetTree", err) {
+			return c.readDirectoryTree(ctx, d)
+		}
 		return nil, err
 	}
 	return result, nil
 }
 
+// GetDirectoryTreeProto is like GetDirectoryTree, but assembles the directories into a Tree proto
+// whose Root is the Directory with the given digest, and whose Children are its descendants, each
+// listed once.
+func (c *Client) GetDirectoryTreeProto(ctx context.Context, d *repb.Digest) (*repb.Tree, error) {
+	dirs, err := c.GetDirectoryTree(ctx, d)
+	if err != nil {
+		return nil, err
+	}
+	root := digest.NewFromProtoUnvalidated(d)
+	tree := &repb.Tree{}
+	seen := make(map[digest.Digest]bool)
+	for _, dir := range dirs {
+		dg, err := c.digestFn.NewFromMessage(dir)
+		if err != nil {
+			return nil, err
+		}
+		if seen[dg] {
+			continue
+		}
+		seen[dg] = true
+		if dg == root {
+			tree.Root = dir
+		} else {
+			tree.Children = append(tree.Children, dir)
+		}
+	}
+	if tree.Root == nil {
+		return nil, fmt.Errorf("the directory tree of %v does not contain its root", root)
+	}
+	return tree, nil
+}
+
+// checkOutputSymlink returns an error if the output symlink may not be materialized according to
+// the client's AbsoluteOutputSymlinks.
+func (c *Client) checkOutputSymlink(out *TreeOutput) error {
+	if !path.IsAbs(out.SymlinkTarget) && !filepath.IsAbs(out.SymlinkTarget) {
+		return nil
+	}
+	switch c.AbsoluteOutputSymlinks {
+	case AbsoluteOutputSymlinksPerCapabilities:
+	case RejectAbsoluteOutputSymlinks:
+		return fmt.Errorf("output symlink %s has an absolute target %q", out.Path, out.SymlinkTarget)
+	default:
+		return nil
+	}
+	if c.serverCaps.GetCacheCapabilities().GetSymlinkAbsolutePathStrategy() != repb.SymlinkAbsolutePathStrategy_DISALLOWED {
+		return nil
+	}
+	return fmt.Errorf("output symlink %s has an absolute target %q, which the server capabilities disallow", out.Path, out.SymlinkTarget)
+}
+
 // FlattenActionOutputs collects and flattens all the outputs of an action.
 // It downloads the output directory metadata, if required, but not the leaf file blobs.
 func (c *Client) FlattenActionOutputs(ctx context.Context, ar *repb.ActionResult) (map[string]*TreeOutput, error) {
 	outs := make(map[string]*TreeOutput)
 	for _, file := range ar.OutputFiles {
 		outs[file.Path] = &TreeOutput{
-			Path:         file.Path,
-			Digest:       digest.NewFromProtoUnvalidated(file.Digest),
-			IsExecutable: file.IsExecutable,
+			Path:           file.Path,
+			Digest:         digest.NewFromProtoUnvalidated(file.Digest),
+			IsExecutable:   file.IsExecutable,
+			NodeProperties: file.NodeProperties,
+			Contents:       file.Contents,
 		}
 	}
 	for _, sm := range ar.OutputFileSymlinks {
@@ -490,6 +895,14 @@ func (c *Client) FlattenActionOutputs(ctx context.Context, ar *repb.ActionResult
 			SymlinkTarget: sm.Target,
 		}
 	}
+	// Since v2.1 of the RE API, servers report all symlinks in `output_symlinks`, and may or may
+	// not also populate the deprecated fields above for older clients.
+	for _, sm := range ar.OutputSymlinks {
+		outs[sm.Path] = &TreeOutput{
+			Path:          sm.Path,
+			SymlinkTarget: sm.Target,
+		}
+	}
 	for _, dir := range ar.OutputDirectories {
 		t := &repb.Tree{}
 		if _, err := c.ReadProto(ctx, digest.NewFromProtoUnvalidated(dir.TreeDigest), t); err != nil {
@@ -510,6 +923,11 @@ func (c *Client) FlattenActionOutputs(ctx context.Context, ar *repb.ActionResult
 // It returns the number of logical and real bytes downloaded, which may be different from sum
 // of sizes of the files due to dedupping and compression.
 func (c *Client) DownloadActionOutputs(ctx context.Context, resPb *repb.ActionResult, outDir string, cache filemetadata.Cache) (*MovedBytesMetadata, error) {
+	ctx, done, err := c.beginOp(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
 	outs, err := c.FlattenActionOutputs(ctx, resPb)
 	if err != nil {
 		return nil, err
@@ -577,50 +995,24 @@ func NewCompressedWriteBuffer(w io.Writer) (io.WriteCloser, chan error, error) {
 // how much data was written.
 type writerTracker struct {
 	w  io.Writer
-	pw *io.PipeWriter
-	dg digest.Digest
+	dw *digest.Writer
 	// Tracked independently of the digest as we might want to retry
 	// on partial reads.
-	n     int64
-	ready chan error
+	n int64
 }
 
-func newWriteTracker(w io.Writer) *writerTracker {
-	pr, pw := io.Pipe()
-	wt := &writerTracker{
-		pw:    pw,
-		w:     w,
-		ready: make(chan error, 1),
-		n:     0,
-	}
-
-	go func() {
-		var err error
-		wt.dg, err = digest.NewFromReader(pr)
-		wt.ready <- err
-	}()
-
-	return wt
+func newWriteTracker(fn digest.Function, w io.Writer) *writerTracker {
+	return &writerTracker{w: w, dw: fn.NewWriter()}
 }
 
 func (wt *writerTracker) Write(p []byte) (int, error) {
-	// Any error on this write will be reflected on the
-	// pipe reader end when trying to calculate the digest.
-	// Additionally, if we are not downloading the entire
-	// blob, we can't even verify the digest to begin with.
-	// So we can ignore errors on this pipewriter.
-	wt.pw.Write(p)
 	n, err := wt.w.Write(p)
+	// Only the bytes written are digested, so that a retry resuming at wt.n continues the digest.
+	wt.dw.Write(p[:n])
 	wt.n += int64(n)
 	return n, err
 }
 
-// Close closes the pipe - which triggers the end of the
-// digest creation.
-func (wt *writerTracker) Close() error {
-	return wt.pw.Close()
-}
-
 type downloadRequest struct {
 	digest digest.Digest
 	outDir string
@@ -671,16 +1063,22 @@ func (c *Client) download(ctx context.Context, data []*downloadRequest) {
 	// This will download once and copy to the other locations.
 	reqs := make(map[digest.Digest][]*downloadRequest)
 	var metas []*contextmd.Metadata
+	// actions are the actions that first requested each digest, which the transfers are scheduled
+	// for.
+	actions := make(map[digest.Digest]string)
 	for _, r := range data {
 		rs := reqs[r.digest]
 		rs = append(rs, r)
 		reqs[r.digest] = rs
 		metas = append(metas, r.meta)
+		if _, ok := actions[r.digest]; !ok {
+			actions[r.digest] = r.meta.ActionID
+		}
 	}
 
 	var dgs []digest.Digest
 
-	if bool(c.useBatchOps) && bool(c.UtilizeLocality) {
+	if c.batchOps() && bool(c.UtilizeLocality) {
 		paths := make([]*TreeOutput, 0, len(data))
 		for _, r := range data {
 			paths = append(paths, r.output)
@@ -712,7 +1110,7 @@ func (c *Client) download(ctx context.Context, data []*downloadRequest) {
 
 	contextmd.Infof(ctx, log.Level(2), "%d digests to download (%d reqs)", len(dgs), len(reqs))
 	var batches [][]digest.Digest
-	if c.useBatchOps {
+	if c.batchOps() {
 		batches = c.makeBatches(ctx, dgs, !bool(c.UtilizeLocality))
 	} else {
 		contextmd.Infof(ctx, log.Level(2), "Downloading them individually")
@@ -725,13 +1123,22 @@ func (c *Client) download(ctx context.Context, data []*downloadRequest) {
 	for i, batch := range batches {
 		i, batch := i, batch // https://golang.org/doc/faq#closures_and_goroutines
 		go func() {
-			if c.casDownloaders.Acquire(ctx, 1) == nil {
-				defer c.casDownloaders.Release(1)
+			if release, err := c.acquireDownloadFor(ctx, actions[batch[0]], batchSize(batch)); err == nil {
+				defer release()
 			}
 			if i%logInterval == 0 {
 				contextmd.Infof(ctx, log.Level(2), "%d batches left to download", len(batches)-i)
 			}
 			if len(batch) > 1 {
+				release, ok := c.reserveDownloadMemory(batchSize(batch))
+				if !ok {
+					contextmd.Infof(ctx, log.Level(3), "Streaming batch of %d files over the download memory budget", len(batch))
+					for _, dg := range batch {
+						c.downloadSingle(ctx, dg, reqs)
+					}
+					return
+				}
+				defer release()
 				c.downloadBatch(ctx, batch, reqs)
 			} else {
 				rs := reqs[batch[0]]
@@ -834,7 +1241,7 @@ func (c *Client) downloadNonUnified(ctx context.Context, outDir string, outputs
 	statsMu := sync.Mutex{}
 	fullStats := &MovedBytesMetadata{}
 
-	if bool(c.useBatchOps) && bool(c.UtilizeLocality) {
+	if c.batchOps() && bool(c.UtilizeLocality) {
 		paths := make([]*TreeOutput, 0, len(outputs))
 		for _, output := range outputs {
 			paths = append(paths, output)
@@ -858,7 +1265,7 @@ func (c *Client) downloadNonUnified(ctx context.Context, outDir string, outputs
 
 	contextmd.Infof(ctx, log.Level(2), "%d items to download", len(dgs))
 	var batches [][]digest.Digest
-	if c.useBatchOps {
+	if c.batchOps() {
 		batches = c.makeBatches(ctx, dgs, !bool(c.UtilizeLocality))
 	} else {
 		contextmd.Infof(ctx, log.Level(2), "Downloading them individually")
@@ -868,18 +1275,45 @@ func (c *Client) downloadNonUnified(ctx context.Context, outDir string, outputs
 		}
 	}
 
+	downloadFile := func(out *TreeOutput) error {
+		path := filepath.Join(outDir, out.Path)
+		contextmd.Infof(ctx, log.Level(3), "Downloading single file with digest %s to %s", out.Digest, path)
+		stats, err := c.ReadBlobToFile(ctx, out.Digest, path)
+		if err != nil {
+			return err
+		}
+		statsMu.Lock()
+		fullStats.addFrom(stats)
+		statsMu.Unlock()
+		if out.IsExecutable {
+			return os.Chmod(path, c.ExecutableMode)
+		}
+		return nil
+	}
 	eg, eCtx := errgroup.WithContext(ctx)
 	for i, batch := range batches {
 		i, batch := i, batch // https://golang.org/doc/faq#closures_and_goroutines
 		eg.Go(func() error {
-			if err := c.casDownloaders.Acquire(eCtx, 1); err != nil {
+			release, err := c.acquireDownload(eCtx, batchSize(batch))
+			if err != nil {
 				return err
 			}
-			defer c.casDownloaders.Release(1)
+			defer release()
 			if i%logInterval == 0 {
 				contextmd.Infof(ctx, log.Level(2), "%d batches left to download", len(batches)-i)
 			}
 			if len(batch) > 1 {
+				release, ok := c.reserveDownloadMemory(batchSize(batch))
+				if !ok {
+					contextmd.Infof(ctx, log.Level(3), "Streaming batch of %d files over the download memory budget", len(batch))
+					for _, dg := range batch {
+						if err := downloadFile(outputs[dg]); err != nil {
+							return err
+						}
+					}
+					return eCtx.Err()
+				}
+				defer release()
 				contextmd.Infof(ctx, log.Level(3), "Downloading batch of %d files", len(batch))
 				bchMap, err := c.BatchDownloadBlobsWithStats(eCtx, batch)
 				for _, dg := range batch {
@@ -900,22 +1334,8 @@ func (c *Client) downloadNonUnified(ctx context.Context, outDir string, outputs
 				if err != nil {
 					return err
 				}
-			} else {
-				out := outputs[batch[0]]
-				path := filepath.Join(outDir, out.Path)
-				contextmd.Infof(ctx, log.Level(3), "Downloading single file with digest %s to %s", out.Digest, path)
-				stats, err := c.ReadBlobToFile(ctx, out.Digest, path)
-				if err != nil {
-					return err
-				}
-				statsMu.Lock()
-				fullStats.addFrom(stats)
-				statsMu.Unlock()
-				if out.IsExecutable {
-					if err := os.Chmod(path, c.ExecutableMode); err != nil {
-						return err
-					}
-				}
+			} else if err := downloadFile(outputs[batch[0]]); err != nil {
+				return err
 			}
 			if eCtx.Err() != nil {
 				return eCtx.Err()
@@ -969,15 +1389,25 @@ type writeDummyCloser struct {
 
 func (w *writeDummyCloser) Close() error { return nil }
 
+// blobSegments returns the segments of a blob resource name that identify the blob: the digest
+// function, if it has to be named, the hash and the size.
+func (c *Client) blobSegments(hash string, sizeBytes int64) []string {
+	size := strconv.FormatInt(sizeBytes, 10)
+	if fn := c.digestFn.ResourceNameSegment(); fn != "" {
+		return []string{fn, hash, size}
+	}
+	return []string{hash, size}
+}
+
 func (c *Client) resourceNameRead(hash string, sizeBytes int64) string {
-	rname, _ := c.ResourceName("blobs", hash, strconv.FormatInt(sizeBytes, 10))
+	rname, _ := c.ResourceName(append([]string{"blobs"}, c.blobSegments(hash, sizeBytes)...)...)
 	return rname
 }
 
 // TODO(rubensf): Converge compressor to proto in https://github.com/bazelbuild/remote-apis/pull/168 once
 // that gets merged in.
 func (c *Client) resourceNameCompressedRead(hash string, sizeBytes int64) string {
-	rname, _ := c.ResourceName("compressed-blobs", "zstd", hash, strconv.FormatInt(sizeBytes, 10))
+	rname, _ := c.ResourceName(append([]string{"compressed-blobs", "zstd"}, c.blobSegments(hash, sizeBytes)...)...)
 	return rname
 }
 
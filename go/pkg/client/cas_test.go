@@ -6,12 +6,15 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"io"
 	"math/rand"
 	"net"
 	"os"
 	"path/filepath"
-
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -27,6 +30,8 @@ import (
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/testing/protocmp"
+	"google.golang.org/protobuf/types/known/timestamppb"
 
 	// Redundant imports are required for the google3 mirror. Aliases should not be changed.
 	regrpc "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
@@ -86,6 +91,71 @@ func TestSplitEndpoints(t *testing.T) {
 	}
 }
 
+func TestReadBlobToWriters(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	e, cleanup := fakes.NewTestEnv(t)
+	defer cleanup()
+	c := e.Client.GrpcClient
+	blob := make([]byte, 1000)
+	rand.Read(blob)
+	dg := e.Server.CAS.Put(blob)
+
+	var buf bytes.Buffer
+	if _, err := c.ReadBlobTo(ctx, dg, &buf); err != nil {
+		t.Fatalf("c.ReadBlobTo(ctx, %v) gave error %v, want nil", dg, err)
+	}
+	if !bytes.Equal(blob, buf.Bytes()) {
+		t.Errorf("c.ReadBlobTo(ctx, %v) wrote %d different bytes, want the blob", dg, buf.Len())
+	}
+
+	path := filepath.Join(t.TempDir(), "blob")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("os.Create(%q) failed: %v", path, err)
+	}
+	defer f.Close()
+	stats, err := c.ReadBlobToWriterAt(ctx, dg, f, 300)
+	if err != nil {
+		t.Fatalf("c.ReadBlobToWriterAt(ctx, %v) gave error %v, want nil", dg, err)
+	}
+	if stats.LogicalMoved != dg.Size {
+		t.Errorf("c.ReadBlobToWriterAt(ctx, %v) moved %d bytes, want %d", dg, stats.LogicalMoved, dg.Size)
+	}
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("os.ReadFile(%q) failed: %v", path, err)
+	}
+	if !bytes.Equal(blob, got) {
+		t.Errorf("c.ReadBlobToWriterAt(ctx, %v) wrote different contents, want the blob", dg)
+	}
+	if reads := e.Server.CAS.BlobReads(dg); reads != 1+4 {
+		t.Errorf("expected 5 blob reads to the fake, 1 whole and 4 ranged, got %v", reads)
+	}
+}
+
+func TestReadBlobToWriterAtCorrupted(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	e, cleanup := fakes.NewTestEnv(t)
+	defer cleanup()
+	c := e.Client.GrpcClient
+	blob := make([]byte, 1000)
+	rand.Read(blob)
+	dg := e.Server.CAS.Put(blob)
+	// The fake serves the stored slice, so this corrupts one of the ranges.
+	blob[500]++
+
+	f, err := os.Create(filepath.Join(t.TempDir(), "blob"))
+	if err != nil {
+		t.Fatalf("os.Create() failed: %v", err)
+	}
+	defer f.Close()
+	if _, err := c.ReadBlobToWriterAt(ctx, dg, f, 300); err == nil {
+		t.Errorf("c.ReadBlobToWriterAt(ctx, %v) of a corrupted blob succeeded, want error", dg)
+	}
+}
+
 func TestReadEmptyBlobDoesNotCallServer(t *testing.T) {
 	t.Parallel()
 	ctx := context.Background()
@@ -434,6 +504,74 @@ func TestMissingBlobs(t *testing.T) {
 	}
 }
 
+func TestContainsBlobsMissingCache(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	e, cleanup := fakes.NewTestEnv(t)
+	defer cleanup()
+	fake := e.Server.CAS
+	c := e.Client.GrpcClient
+	client.MissingBlobsCacheTTL(time.Hour).Apply(c)
+	foo, bar := []byte("foo"), []byte("bar")
+	fooDg, barDg := fake.Put(foo), digest.NewFromBlob(bar)
+
+	want := map[digest.Digest]bool{fooDg: true, barDg: false}
+	for i := 0; i < 2; i++ {
+		got, err := c.ContainsBlobs(ctx, []digest.Digest{fooDg, barDg})
+		if err != nil {
+			t.Fatalf("ContainsBlobs() failed: %v", err)
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("ContainsBlobs() gave diff (-want +got):\n%s", diff)
+		}
+	}
+	// The missing blob is only queried once, while the present one is queried every time.
+	if n := fake.BlobMissingReqs(barDg); n != 1 {
+		t.Errorf("missing blob was queried %d times, want 1", n)
+	}
+	if n := fake.BlobMissingReqs(fooDg); n != 2 {
+		t.Errorf("present blob was queried %d times, want 2", n)
+	}
+
+	// Uploading the blob through the client forgets it was missing.
+	if _, _, err := c.UploadIfMissing(ctx, uploadinfo.EntryFromBlob(bar)); err != nil {
+		t.Fatalf("UploadIfMissing() failed: %v", err)
+	}
+	got, err := c.ContainsBlobs(ctx, []digest.Digest{barDg})
+	if err != nil {
+		t.Fatalf("ContainsBlobs() failed: %v", err)
+	}
+	if !got[barDg] {
+		t.Errorf("ContainsBlobs() after upload = %v, want %v present", got, barDg)
+	}
+}
+
+func TestMissingBlobsCacheExpires(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	e, cleanup := fakes.NewTestEnv(t)
+	defer cleanup()
+	fake := e.Server.CAS
+	c := e.Client.GrpcClient
+	client.MissingBlobsCacheTTL(10 * time.Millisecond).Apply(c)
+	foo := []byte("foo")
+	fooDg := digest.NewFromBlob(foo)
+
+	if missing, err := c.MissingBlobs(ctx, []digest.Digest{fooDg}); err != nil || len(missing) != 1 {
+		t.Fatalf("MissingBlobs() = %v, %v, want %v missing", missing, err, fooDg)
+	}
+	// Blobs uploaded by others are seen once the cache entries expire.
+	fake.Put(foo)
+	time.Sleep(20 * time.Millisecond)
+	missing, err := c.MissingBlobs(ctx, []digest.Digest{fooDg})
+	if err != nil {
+		t.Fatalf("MissingBlobs() failed: %v", err)
+	}
+	if len(missing) != 0 {
+		t.Errorf("MissingBlobs() after expiry = %v, want none", missing)
+	}
+}
+
 func TestUploadConcurrent(t *testing.T) {
 	t.Parallel()
 	blobs := make([][]byte, 50)
@@ -855,6 +993,193 @@ func TestUpload(t *testing.T) {
 	}
 }
 
+func TestUploadConcurrencyLimits(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name string
+		opts []client.Opt
+		max  int
+	}{
+		{
+			name: "uploads",
+			opts: []client.Opt{client.UseBatchOps(false), client.MaxConcurrentUploads(3)},
+			max:  3,
+		},
+		{
+			name: "uploads after CASConcurrency",
+			opts: []client.Opt{client.UseBatchOps(false), client.MaxConcurrentUploads(3), client.CASConcurrency(10), client.MaxConcurrentUploads(2)},
+			max:  2,
+		},
+		{
+			name: "RPCs",
+			opts: []client.Opt{client.MaxConcurrentRPCs(2)},
+			max:  2,
+		},
+	}
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			ctx := context.Background()
+			e, cleanup := fakes.NewTestEnv(t)
+			defer cleanup()
+			fake := e.Server.CAS
+			fake.ReqSleepDuration = 5 * time.Millisecond
+			c := e.Client.GrpcClient
+			c.MaxBatchDigests = 2
+			for _, o := range tc.opts {
+				o.Apply(c)
+			}
+
+			var input []*uploadinfo.Entry
+			for i := 0; i < 50; i++ {
+				input = append(input, uploadinfo.EntryFromBlob([]byte(fmt.Sprintf("blob%d", i))))
+			}
+			if _, _, err := c.UploadIfMissing(ctx, input...); err != nil {
+				t.Fatalf("c.UploadIfMissing(ctx, input) gave error %v, expected nil", err)
+			}
+			if fake.MaxConcurrency() > tc.max {
+				t.Errorf("CAS concurrency %v was higher than max %v", fake.MaxConcurrency(), tc.max)
+			}
+		})
+	}
+}
+
+func TestWriteBlobResumesInterruptedWrite(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	e, cleanup := fakes.NewTestEnv(t)
+	defer cleanup()
+	fake := e.Server.CAS
+	c := e.Client.GrpcClient
+	client.UseBatchOps(false).Apply(c)
+	client.ChunkMaxSize(10).Apply(c)
+	interrupted := false
+	fake.WriteInterrupt = func(_ string, size int64) bool {
+		if !interrupted && size >= 50 {
+			interrupted = true
+			return true
+		}
+		return false
+	}
+
+	blob := bytes.Repeat([]byte("0123456789"), 10)
+	dg := digest.NewFromBlob(blob)
+	if _, err := c.WriteBlob(ctx, blob); err != nil {
+		t.Fatalf("c.WriteBlob(ctx, blob) gave error %v, expected nil", err)
+	}
+	if gotBlob, ok := fake.Get(dg); !ok || !bytes.Equal(blob, gotBlob) {
+		t.Errorf("blob with digest %s was not uploaded correctly: got %q, %v", dg, gotBlob, ok)
+	}
+	want := client.ByteStreamWriteStats{Retries: 1, Resumes: 1, ResumedBytes: 50}
+	if diff := cmp.Diff(want, c.ByteStreamWriteStats()); diff != "" {
+		t.Errorf("c.ByteStreamWriteStats() gave diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestReadBlobResumesInterruptedRead(t *testing.T) {
+	t.Parallel()
+	blob := bytes.Repeat([]byte("0123456789"), 10)
+	tests := []struct {
+		name          string
+		offset, limit int64
+		interruptAt   int64
+		want          []byte
+		wantStats     client.ByteStreamReadStats
+	}{
+		{
+			name:        "whole blob",
+			interruptAt: 50,
+			want:        blob,
+			wantStats:   client.ByteStreamReadStats{Retries: 1, Resumes: 1, ResumedBytes: 50},
+		},
+		{
+			name:        "range",
+			offset:      20,
+			limit:       50,
+			interruptAt: 40,
+			want:        blob[20:70],
+			wantStats:   client.ByteStreamReadStats{Retries: 1, Resumes: 1, ResumedBytes: 20},
+		},
+		{
+			name:        "range interrupted after its last byte",
+			offset:      20,
+			limit:       50,
+			interruptAt: 70,
+			want:        blob[20:70],
+		},
+	}
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			ctx := context.Background()
+			e, cleanup := fakes.NewTestEnv(t)
+			defer cleanup()
+			fake := e.Server.CAS
+			c := e.Client.GrpcClient
+			client.UseBatchOps(false).Apply(c)
+			dg := fake.Put(blob)
+			fake.ReadChunkSize = 10
+			interrupted := false
+			fake.ReadInterrupt = func(_ string, offset int64) bool {
+				if !interrupted && offset >= tc.interruptAt {
+					interrupted = true
+					return true
+				}
+				return false
+			}
+
+			got, _, err := c.ReadBlobRange(ctx, dg, tc.offset, tc.limit)
+			if err != nil {
+				t.Fatalf("c.ReadBlobRange(ctx, %v, %d, %d) gave error %v, expected nil", dg, tc.offset, tc.limit, err)
+			}
+			if !bytes.Equal(got, tc.want) {
+				t.Errorf("c.ReadBlobRange(ctx, %v, %d, %d) = %q, want %q", dg, tc.offset, tc.limit, got, tc.want)
+			}
+			if diff := cmp.Diff(tc.wantStats, c.ByteStreamReadStats()); diff != "" {
+				t.Errorf("c.ByteStreamReadStats() gave diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestReadBytesResumesInterruptedRead(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	e, cleanup := fakes.NewTestEnv(t)
+	defer cleanup()
+	fake := e.Server.CAS
+	c := e.Client.GrpcClient
+	blob := bytes.Repeat([]byte("0123456789"), 10)
+	dg := fake.Put(blob)
+	fake.ReadChunkSize = 10
+	interrupted := false
+	fake.ReadInterrupt = func(_ string, offset int64) bool {
+		if !interrupted && offset >= 50 {
+			interrupted = true
+			return true
+		}
+		return false
+	}
+
+	name, err := c.ResourceName("blobs", dg.Hash, strconv.FormatInt(dg.Size, 10))
+	if err != nil {
+		t.Fatalf("c.ResourceName() failed: %v", err)
+	}
+	got, err := c.ReadBytes(ctx, name)
+	if err != nil {
+		t.Fatalf("c.ReadBytes(ctx, %q) failed: %v", name, err)
+	}
+	if !bytes.Equal(got, blob) {
+		t.Errorf("c.ReadBytes(ctx, %q) = %q, want %q", name, got, blob)
+	}
+	want := client.ByteStreamReadStats{Retries: 1, Resumes: 1, ResumedBytes: 50}
+	if diff := cmp.Diff(want, c.ByteStreamReadStats()); diff != "" {
+		t.Errorf("c.ByteStreamReadStats() gave diff (-want +got):\n%s", diff)
+	}
+}
+
 func TestWriteBlobsBatching(t *testing.T) {
 	t.Parallel()
 	ctx := context.Background()
@@ -938,6 +1263,91 @@ func TestWriteBlobsBatching(t *testing.T) {
 	}
 }
 
+func TestWriteBlobsInBatches(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	e, cleanup := fakes.NewTestEnv(t)
+	defer cleanup()
+	fake := e.Server.CAS
+	c := e.Client.GrpcClient
+	c.MaxBatchSize = 500
+	c.MaxBatchDigests = 4
+
+	blobs := make(map[digest.Digest][]byte)
+	for i := 0; i < 9; i++ {
+		blob := []byte(fmt.Sprintf("blob%d", i))
+		blobs[digest.NewFromBlob(blob)] = blob
+	}
+	big := make([]byte, 400)
+	blobs[digest.NewFromBlob(big)] = big
+	// A blob under the wrong digest fails on the server, in a batch with the other blobs.
+	badDg := digest.TestNew("bad", 3)
+	blobs[badDg] = []byte("foo")
+
+	err := c.WriteBlobsInBatches(ctx, blobs)
+	var be *client.BatchError
+	if !errors.As(err, &be) {
+		t.Fatalf("c.WriteBlobsInBatches(ctx, blobs) gave error %v, expected a *client.BatchError", err)
+	}
+	if len(be.Blobs) != 1 || be.Blobs[badDg] == nil {
+		t.Errorf("c.WriteBlobsInBatches(ctx, blobs) failed blobs %v, expected only %s", be.Blobs, badDg)
+	}
+	for d, blob := range blobs {
+		if d == badDg {
+			continue
+		}
+		if gotBlob, ok := fake.Get(d); !ok {
+			t.Errorf("blob with digest %s was not uploaded, expected it to be present in the CAS", d)
+		} else if !bytes.Equal(blob, gotBlob) {
+			t.Errorf("blob with digest %s had diff on uploaded blob: wanted %v, got %v", d, blob, gotBlob)
+		}
+	}
+	if fake.BatchReqs() != 3 {
+		t.Errorf("%d requests were made to BatchUpdateBlobs, wanted 3", fake.BatchReqs())
+	}
+	if fake.WriteReqs() != 1 {
+		t.Errorf("%d requests were made to Write, wanted 1", fake.WriteReqs())
+	}
+}
+
+func TestDownloadBlobsInBatches(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	e, cleanup := fakes.NewTestEnv(t)
+	defer cleanup()
+	fake := e.Server.CAS
+	c := e.Client.GrpcClient
+	c.MaxBatchSize = 500
+	c.MaxBatchDigests = 4
+
+	want := make(map[digest.Digest][]byte)
+	var dgs []digest.Digest
+	for i := 0; i < 9; i++ {
+		blob := []byte(fmt.Sprintf("blob%d", i))
+		want[fake.Put(blob)] = blob
+	}
+	big := make([]byte, 400)
+	big[0] = 1
+	want[fake.Put(big)] = big
+	for d := range want {
+		dgs = append(dgs, d)
+	}
+	missing := digest.NewFromBlob([]byte("missing"))
+	dgs = append(dgs, missing, dgs[0])
+
+	got, err := c.DownloadBlobsInBatches(ctx, dgs)
+	var be *client.BatchError
+	if !errors.As(err, &be) {
+		t.Fatalf("c.DownloadBlobsInBatches(ctx, dgs) gave error %v, expected a *client.BatchError", err)
+	}
+	if len(be.Blobs) != 1 || be.Blobs[missing] == nil {
+		t.Errorf("c.DownloadBlobsInBatches(ctx, dgs) failed blobs %v, expected only %s", be.Blobs, missing)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("c.DownloadBlobsInBatches(ctx, dgs) gave diff (-want +got):\n%s", diff)
+	}
+}
+
 func TestFlattenActionOutputs(t *testing.T) {
 	t.Parallel()
 	ctx := context.Background()
@@ -994,6 +1404,9 @@ func TestFlattenActionOutputs(t *testing.T) {
 			&repb.OutputSymlink{Path: "x/bar", Target: "../dir/a/bar"}},
 		OutputDirectorySymlinks: []*repb.OutputSymlink{
 			&repb.OutputSymlink{Path: "x/a", Target: "../dir/a"}},
+		OutputSymlinks: []*repb.OutputSymlink{
+			&repb.OutputSymlink{Path: "x/a", Target: "../dir/a"},
+			&repb.OutputSymlink{Path: "x/foo", Target: "../foo"}},
 		OutputDirectories: []*repb.OutputDirectory{
 			&repb.OutputDirectory{Path: "dir", TreeDigest: treeDigest.ToProto()},
 			&repb.OutputDirectory{Path: "dir2", TreeDigest: treeADigest.ToProto()},
@@ -1012,6 +1425,7 @@ func TestFlattenActionOutputs(t *testing.T) {
 		"foo":         &client.TreeOutput{Digest: fooDigest},
 		"x/a":         &client.TreeOutput{SymlinkTarget: "../dir/a"},
 		"x/bar":       &client.TreeOutput{SymlinkTarget: "../dir/a/bar"},
+		"x/foo":       &client.TreeOutput{SymlinkTarget: "../foo"},
 	}
 	if len(outputs) != len(wantOutputs) {
 		t.Errorf("FlattenActionOutputs gave wrong number of outputs: want %d, got %d", len(wantOutputs), len(outputs))
@@ -1351,6 +1765,98 @@ func TestDownloadActionOutputs_TestFileModifiedTimestamp(t *testing.T) {
 	}
 }
 
+func TestDownloadActionOutputsRestoresMtimes(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	e, cleanup := fakes.NewTestEnv(t)
+	defer cleanup()
+	fake := e.Server.CAS
+	c := e.Client.GrpcClient
+	restoredMtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	constMtime := time.Date(2010, 1, 2, 3, 4, 5, 0, time.UTC)
+	client.RestoreOutputMtimes(true).Apply(c)
+	client.OutputMtime(constMtime).Apply(c)
+
+	fooDigest := fake.Put([]byte("foo"))
+	barDigest := fake.Put([]byte("bar"))
+	ar := &repb.ActionResult{
+		OutputFiles: []*repb.OutputFile{
+			&repb.OutputFile{
+				Path:           "foo",
+				Digest:         fooDigest.ToProto(),
+				NodeProperties: &repb.NodeProperties{Mtime: timestamppb.New(restoredMtime)},
+			},
+			&repb.OutputFile{Path: "bar", Digest: barDigest.ToProto()},
+			&repb.OutputFile{Path: "bar2", Digest: barDigest.ToProto()},
+		},
+	}
+	execRoot := t.TempDir()
+	if _, err := c.DownloadActionOutputs(ctx, ar, execRoot, filemetadata.NewNoopCache()); err != nil {
+		t.Fatalf("error in DownloadActionOutputs: %s", err)
+	}
+	wantMtimes := map[string]time.Time{
+		"foo":  restoredMtime,
+		"bar":  constMtime,
+		"bar2": constMtime,
+	}
+	for path, want := range wantMtimes {
+		fi, err := os.Stat(filepath.Join(execRoot, path))
+		if err != nil {
+			t.Fatalf("expected output %s is missing: %v", path, err)
+		}
+		if !fi.ModTime().Equal(want) {
+			t.Errorf("output %s has mtime %v, want %v", path, fi.ModTime(), want)
+		}
+	}
+}
+
+func TestDownloadActionOutputsPermissions(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	e, cleanup := fakes.NewTestEnv(t)
+	defer cleanup()
+	fake := e.Server.CAS
+	c := e.Client.GrpcClient
+	client.DownloadUmask(0022).Apply(c)
+	client.ReadOnlyOutputs(true).Apply(c)
+	client.OutputPermissionFunc(func(path string, perm os.FileMode) os.FileMode {
+		if path == "secret" {
+			return perm &^ 0044
+		}
+		return perm
+	}).Apply(c)
+
+	fooDigest := fake.Put([]byte("foo"))
+	ar := &repb.ActionResult{
+		OutputFiles: []*repb.OutputFile{
+			&repb.OutputFile{Path: "foo", Digest: fooDigest.ToProto()},
+			&repb.OutputFile{Path: "exe", Digest: fooDigest.ToProto(), IsExecutable: true},
+			&repb.OutputFile{Path: "secret", Digest: fooDigest.ToProto()},
+		},
+	}
+	execRoot := t.TempDir()
+	// Downloading twice checks that read-only outputs are replaced.
+	for i := 0; i < 2; i++ {
+		if _, err := c.DownloadActionOutputs(ctx, ar, execRoot, filemetadata.NewNoopCache()); err != nil {
+			t.Fatalf("error in DownloadActionOutputs: %s", err)
+		}
+	}
+	wantPerms := map[string]os.FileMode{
+		"foo":    0444,
+		"exe":    0555,
+		"secret": 0400,
+	}
+	for path, want := range wantPerms {
+		fi, err := os.Stat(filepath.Join(execRoot, path))
+		if err != nil {
+			t.Fatalf("expected output %s is missing: %v", path, err)
+		}
+		if fi.Mode().Perm() != want {
+			t.Errorf("output %s has permissions %v, want %v", path, fi.Mode().Perm(), want)
+		}
+	}
+}
+
 func TestDownloadDirectory(t *testing.T) {
 	t.Parallel()
 	ctx := context.Background()
@@ -1406,27 +1912,273 @@ func TestDownloadDirectory(t *testing.T) {
 	}
 }
 
-func TestDownloadActionOutputsErrors(t *testing.T) {
-	ar := &repb.ActionResult{}
-	ar.OutputFiles = append(ar.OutputFiles, &repb.OutputFile{Path: "foo", Digest: digest.NewFromBlob([]byte("foo")).ToProto()})
-	ar.OutputFiles = append(ar.OutputFiles, &repb.OutputFile{Path: "bar", Digest: digest.NewFromBlob([]byte("bar")).ToProto()})
-	execRoot := t.TempDir()
-
-	for _, ub := range []client.UseBatchOps{false, true} {
-		ub := ub
-		t.Run(fmt.Sprintf("%sUsingBatch:%t", t.Name(), ub), func(t *testing.T) {
-			t.Parallel()
-			ctx := context.Background()
+func TestGetDirectoryTreeProto(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	for _, bytestreamOnly := range []bool{false, true} {
+		t.Run(fmt.Sprintf("BytestreamOnly=%t", bytestreamOnly), func(t *testing.T) {
 			e, cleanup := fakes.NewTestEnv(t)
 			defer cleanup()
+			fake := e.Server.CAS
 			c := e.Client.GrpcClient
-			ub.Apply(c)
+			client.BytestreamOnly(bytestreamOnly).Apply(c)
 
-			_, err := c.DownloadActionOutputs(ctx, ar, execRoot, filemetadata.NewSingleFlightCache())
-			if status.Code(err) != codes.NotFound && !strings.Contains(err.Error(), "not found") {
-				t.Errorf("expected 'not found' error in DownloadActionOutputs, got: %v", err)
+			shared := &repb.Directory{Files: []*repb.FileNode{{Name: "foo", Digest: fake.Put([]byte("foo")).ToProto()}}}
+			sharedBlob, err := proto.Marshal(shared)
+			if err != nil {
+				t.Fatalf("failed to marshal Directory: %v", err)
 			}
-		})
+			sharedDg := fake.Put(sharedBlob).ToProto()
+			root := &repb.Directory{Directories: []*repb.DirectoryNode{{Name: "a", Digest: sharedDg}, {Name: "b", Digest: sharedDg}}}
+			rootBlob, err := proto.Marshal(root)
+			if err != nil {
+				t.Fatalf("failed to marshal Directory: %v", err)
+			}
+
+			got, err := c.GetDirectoryTreeProto(ctx, fake.Put(rootBlob).ToProto())
+			if err != nil {
+				t.Fatalf("GetDirectoryTreeProto() failed: %v", err)
+			}
+			want := &repb.Tree{Root: root, Children: []*repb.Directory{shared}}
+			if diff := cmp.Diff(want, got, protocmp.Transform()); diff != "" {
+				t.Errorf("GetDirectoryTreeProto() gave diff (-want +got):\n%s", diff)
+			}
+
+			got, err = c.GetDirectoryTreeProto(ctx, digest.Empty.ToProto())
+			if err != nil {
+				t.Fatalf("GetDirectoryTreeProto() of the empty directory failed: %v", err)
+			}
+			if diff := cmp.Diff(&repb.Tree{Root: &repb.Directory{}}, got, protocmp.Transform()); diff != "" {
+				t.Errorf("GetDirectoryTreeProto() of the empty directory gave diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestDownloadTreeFiltered(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	e, cleanup := fakes.NewTestEnv(t)
+	defer cleanup()
+	fake := e.Server.CAS
+	c := e.Client.GrpcClient
+	cache := filemetadata.NewSingleFlightCache()
+
+	fooDigest := fake.Put([]byte("foo"))
+	barDigest := fake.Put([]byte("bar"))
+	logDigest := fake.Put([]byte("log"))
+	sub := &repb.Directory{
+		Files: []*repb.FileNode{{Name: "bar", Digest: barDigest.ToProto()}},
+	}
+	logs := &repb.Directory{
+		Files: []*repb.FileNode{{Name: "out.log", Digest: logDigest.ToProto()}},
+	}
+	root := &repb.Directory{
+		Files: []*repb.FileNode{
+			{Name: "foo", Digest: fooDigest.ToProto(), IsExecutable: true},
+		},
+		Directories: []*repb.DirectoryNode{
+			{Name: "logs", Digest: digest.TestNewFromMessage(logs).ToProto()},
+			{Name: "sub", Digest: digest.TestNewFromMessage(sub).ToProto()},
+		},
+		Symlinks: []*repb.SymlinkNode{{Name: "link", Target: "sub/bar"}},
+	}
+	treeBlob, err := proto.Marshal(&repb.Tree{Root: root, Children: []*repb.Directory{sub, logs}})
+	if err != nil {
+		t.Fatalf("failed marshalling Tree: %s", err)
+	}
+	treeDigest := fake.Put(treeBlob)
+	execRoot := t.TempDir()
+
+	skipLogs := func(out *client.TreeOutput) bool { return !strings.HasPrefix(out.Path, "logs/") }
+	outputs, _, err := c.DownloadTree(ctx, treeDigest, execRoot, cache, skipLogs)
+	if err != nil {
+		t.Fatalf("c.DownloadTree() failed: %v", err)
+	}
+	if diff := cmp.Diff(map[string]*client.TreeOutput{
+		"foo":     {Digest: fooDigest, Path: "foo", IsExecutable: true},
+		"sub/bar": {Digest: barDigest, Path: "sub/bar"},
+		"link":    {Path: "link", SymlinkTarget: "sub/bar"},
+	}, outputs); diff != "" {
+		t.Errorf("c.DownloadTree() mismatch (-want +got):\n%s", diff)
+	}
+
+	if b, err := os.ReadFile(filepath.Join(execRoot, "link")); err != nil || string(b) != "bar" {
+		t.Errorf("reading link gave %q, %v, want %q", b, err, "bar")
+	}
+	if fi, err := os.Stat(filepath.Join(execRoot, "foo")); err != nil || fi.Mode()&0100 == 0 {
+		t.Errorf("foo is not executable: %v, %v", fi, err)
+	}
+	if _, err := os.Stat(filepath.Join(execRoot, "logs")); !os.IsNotExist(err) {
+		t.Errorf("logs was downloaded, want it filtered out: %v", err)
+	}
+}
+
+func TestDownloadDirectoryLocalCAS(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name        string
+		materialize client.Materialization
+		mtime       time.Time
+		linked      bool
+	}{
+		{name: "hardlink", materialize: client.HardlinkMaterialization, linked: true},
+		{name: "hardlink with output mtime", materialize: client.HardlinkMaterialization, mtime: time.Unix(1e9, 0)},
+		{name: "copy", materialize: client.CopyMaterialization},
+		{name: "clone", materialize: client.CloneMaterialization},
+	}
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			ctx := context.Background()
+			e, cleanup := fakes.NewTestEnv(t)
+			defer cleanup()
+			fake := e.Server.CAS
+			c := e.Client.GrpcClient
+			casDir := t.TempDir()
+			lc := &client.LocalCAS{Dir: casDir, Materialize: tc.materialize}
+			lc.Apply(c)
+			client.OutputMtime(tc.mtime).Apply(c)
+
+			fooDigest := fake.Put([]byte("foo"))
+			dir := &repb.Directory{
+				Files: []*repb.FileNode{
+					{Name: "foo", Digest: fooDigest.ToProto(), IsExecutable: true},
+					{Name: "foo2", Digest: fooDigest.ToProto()},
+				},
+			}
+			dirBlob, err := proto.Marshal(dir)
+			if err != nil {
+				t.Fatalf("failed marshalling Directory: %s", err)
+			}
+			dirDigest := fake.Put(dirBlob)
+
+			for i := 0; i < 2; i++ {
+				execRoot := t.TempDir()
+				_, stats, err := c.DownloadDirectory(ctx, dirDigest, execRoot, filemetadata.NewNoopCache())
+				if err != nil {
+					t.Fatalf("c.DownloadDirectory() #%d failed: %v", i, err)
+				}
+				if i == 1 && stats.Cached != 2*fooDigest.Size {
+					t.Errorf("c.DownloadDirectory() #%d cached %d bytes, want %d", i, stats.Cached, 2*fooDigest.Size)
+				}
+				for _, name := range []string{"foo", "foo2"} {
+					if b, err := os.ReadFile(filepath.Join(execRoot, name)); err != nil || string(b) != "foo" {
+						t.Errorf("reading %s gave %q, %v, want %q", name, b, err, "foo")
+					}
+				}
+				fi, err := os.Stat(filepath.Join(execRoot, "foo"))
+				if err != nil {
+					t.Fatalf("os.Stat(foo) failed: %v", err)
+				}
+				if fi.Mode()&0100 == 0 {
+					t.Errorf("foo mode = %v, want executable", fi.Mode())
+				}
+				fi2, err := os.Stat(filepath.Join(execRoot, "foo2"))
+				if err != nil {
+					t.Fatalf("os.Stat(foo2) failed: %v", err)
+				}
+				if fi2.Mode()&0100 != 0 {
+					t.Errorf("foo2 mode = %v, want not executable", fi2.Mode())
+				}
+				blob, err := os.Stat(filepath.Join(casDir, fooDigest.Hash[:2], fmt.Sprintf("%s-%d-%04o", fooDigest.Hash, fooDigest.Size, fi.Mode().Perm())))
+				if err != nil {
+					t.Fatalf("blob of foo is not in the local CAS: %v", err)
+				}
+				if got := os.SameFile(fi, blob); got != tc.linked {
+					t.Errorf("foo is the local CAS blob: %v, want %v", got, tc.linked)
+				}
+				if !tc.mtime.IsZero() {
+					if !fi.ModTime().Equal(tc.mtime) {
+						t.Errorf("foo mtime = %v, want %v", fi.ModTime(), tc.mtime)
+					}
+					if blob.ModTime().Equal(tc.mtime) {
+						t.Errorf("the local CAS blob of foo got the output mtime %v", tc.mtime)
+					}
+				}
+			}
+			if got := fake.BlobReads(fooDigest); got != 1 {
+				t.Errorf("fake.BlobReads(foo) = %d, want 1", got)
+			}
+		})
+	}
+}
+
+func TestDownloadActionOutputsAbsoluteSymlinks(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name    string
+		policy  client.AbsoluteOutputSymlinks
+		wantErr bool
+	}{
+		{name: "default"},
+		// The fake server's capabilities disallow absolute symlinks.
+		{name: "per capabilities", policy: client.AbsoluteOutputSymlinksPerCapabilities, wantErr: true},
+		{name: "allow", policy: client.AllowAbsoluteOutputSymlinks},
+		{name: "reject", policy: client.RejectAbsoluteOutputSymlinks, wantErr: true},
+	}
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			ctx := context.Background()
+			e, cleanup := fakes.NewTestEnv(t)
+			defer cleanup()
+			c := e.Client.GrpcClient
+			tc.policy.Apply(c)
+			ar := &repb.ActionResult{
+				OutputSymlinks: []*repb.OutputSymlink{
+					{Path: "rel", Target: "foo"},
+					{Path: "abs", Target: "/etc/passwd"},
+				},
+			}
+			execRoot := t.TempDir()
+
+			_, err := c.DownloadActionOutputs(ctx, ar, execRoot, filemetadata.NewNoopCache())
+
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("DownloadActionOutputs() succeeded, want an error for the absolute symlink")
+				}
+				if _, err := os.Lstat(filepath.Join(execRoot, "abs")); !os.IsNotExist(err) {
+					t.Errorf("DownloadActionOutputs() materialized the absolute symlink")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("DownloadActionOutputs() failed: %v", err)
+			}
+			for path, want := range map[string]string{"rel": "foo", "abs": "/etc/passwd"} {
+				if got, err := os.Readlink(filepath.Join(execRoot, path)); err != nil || got != want {
+					t.Errorf("output symlink %s points to %q, %v, want %q", path, got, err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestDownloadActionOutputsErrors(t *testing.T) {
+	ar := &repb.ActionResult{}
+	ar.OutputFiles = append(ar.OutputFiles, &repb.OutputFile{Path: "foo", Digest: digest.NewFromBlob([]byte("foo")).ToProto()})
+	ar.OutputFiles = append(ar.OutputFiles, &repb.OutputFile{Path: "bar", Digest: digest.NewFromBlob([]byte("bar")).ToProto()})
+	execRoot := t.TempDir()
+
+	for _, ub := range []client.UseBatchOps{false, true} {
+		ub := ub
+		t.Run(fmt.Sprintf("%sUsingBatch:%t", t.Name(), ub), func(t *testing.T) {
+			t.Parallel()
+			ctx := context.Background()
+			e, cleanup := fakes.NewTestEnv(t)
+			defer cleanup()
+			c := e.Client.GrpcClient
+			ub.Apply(c)
+
+			_, err := c.DownloadActionOutputs(ctx, ar, execRoot, filemetadata.NewSingleFlightCache())
+			if status.Code(err) != codes.NotFound && !strings.Contains(err.Error(), "not found") {
+				t.Errorf("expected 'not found' error in DownloadActionOutputs, got: %v", err)
+			}
+		})
 	}
 }
 
@@ -1826,6 +2578,60 @@ func TestDownloadFiles(t *testing.T) {
 	}
 }
 
+func TestDownloadFilesMemoryBudget(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name      string
+		budget    int64
+		unified   bool
+		batchReqs int
+	}{
+		{name: "within budget", budget: 6, batchReqs: 1},
+		{name: "over budget", budget: 5},
+		{name: "within budget unified", budget: 6, unified: true, batchReqs: 1},
+		{name: "over budget unified", budget: 5, unified: true},
+	}
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			ctx := context.Background()
+			e, cleanup := fakes.NewTestEnv(t)
+			defer cleanup()
+			fake := e.Server.CAS
+			c := e.Client.GrpcClient
+			client.UnifiedDownloads(tc.unified).Apply(c)
+			client.DownloadMemoryBudget(tc.budget).Apply(c)
+			c.RunBackgroundTasks(ctx)
+
+			fooDigest := fake.Put([]byte("foo"))
+			barDigest := fake.Put([]byte("bar"))
+			execRoot := t.TempDir()
+			stats, err := c.DownloadFiles(ctx, execRoot, map[digest.Digest]*client.TreeOutput{
+				fooDigest: {Digest: fooDigest, Path: "foo", IsExecutable: true},
+				barDigest: {Digest: barDigest, Path: "bar"},
+			})
+			if err != nil {
+				t.Fatalf("c.DownloadFiles() failed: %v", err)
+			}
+			if want := fooDigest.Size + barDigest.Size; stats.LogicalMoved != want {
+				t.Errorf("c.DownloadFiles() moved %d logical bytes, want %d", stats.LogicalMoved, want)
+			}
+			if n := fake.BatchReqs(); n != tc.batchReqs {
+				t.Errorf("%d requests were made to BatchReadBlobs, want %d", n, tc.batchReqs)
+			}
+			for name, want := range map[string]string{"foo": "foo", "bar": "bar"} {
+				if b, err := os.ReadFile(filepath.Join(execRoot, name)); err != nil || string(b) != want {
+					t.Errorf("reading %s gave %q, %v, want %q", name, b, err, want)
+				}
+			}
+			if fi, err := os.Stat(filepath.Join(execRoot, "foo")); err != nil || fi.Mode()&0100 == 0 {
+				t.Errorf("foo is not executable: %v", err)
+			}
+		})
+	}
+}
+
 func TestDownloadFilesCancel(t *testing.T) {
 	t.Parallel()
 	for _, uo := range []client.UnifiedDownloads{false, true} {
@@ -1914,3 +2720,351 @@ func TestBatchDownloadBlobsCompressed(t *testing.T) {
 		t.Errorf("client.BatchDownloadBlobs(ctx, digests) had diff (want -> got):\n%s", diff)
 	}
 }
+
+type memUploaded struct {
+	mu  sync.Mutex
+	dgs map[digest.Digest]bool
+}
+
+func (m *memUploaded) Uploaded(_ context.Context, dgs []digest.Digest) ([]digest.Digest, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var res []digest.Digest
+	for _, dg := range dgs {
+		if m.dgs[dg] {
+			res = append(res, dg)
+		}
+	}
+	return res, nil
+}
+
+func (m *memUploaded) Add(_ context.Context, dgs []digest.Digest) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, dg := range dgs {
+		m.dgs[dg] = true
+	}
+	return nil
+}
+
+func TestUploadDedup(t *testing.T) {
+	t.Parallel()
+	for _, unified := range []bool{false, true} {
+		unified := unified
+		t.Run(fmt.Sprintf("unified=%t", unified), func(t *testing.T) {
+			t.Parallel()
+			ctx := context.Background()
+			e, cleanup := fakes.NewTestEnv(t)
+			defer cleanup()
+			fake := e.Server.CAS
+			c := e.Client.GrpcClient
+			client.UnifiedUploads(unified).Apply(c)
+			c.RunBackgroundTasks(ctx)
+			foo, bar, baz := []byte("foo"), []byte("bar"), []byte("baz")
+			fooDg, barDg, bazDg := digest.NewFromBlob(foo), digest.NewFromBlob(bar), digest.NewFromBlob(baz)
+			uploaded := &memUploaded{dgs: map[digest.Digest]bool{fooDg: true}}
+			client.UploadDedup{UploadedDigests: uploaded}.Apply(c)
+			fake.Put(baz)
+
+			missing, _, err := c.UploadIfMissing(ctx, uploadinfo.EntryFromBlob(foo), uploadinfo.EntryFromBlob(bar), uploadinfo.EntryFromBlob(baz))
+			if err != nil {
+				t.Fatalf("UploadIfMissing() failed: %v", err)
+			}
+			if diff := cmp.Diff([]digest.Digest{barDg}, missing); diff != "" {
+				t.Errorf("UploadIfMissing() gave missing diff (-want +got):\n%s", diff)
+			}
+			if n := fake.BlobMissingReqs(fooDg); n != 0 {
+				t.Errorf("FindMissingBlobs was queried %d times for a known uploaded digest, want 0", n)
+			}
+			if n := fake.BlobWrites(fooDg); n != 0 {
+				t.Errorf("Known uploaded digest was written %d times, want 0", n)
+			}
+			if n := fake.BlobWrites(barDg); n != 1 {
+				t.Errorf("Missing digest was written %d times, want 1", n)
+			}
+			for _, dg := range []digest.Digest{fooDg, barDg, bazDg} {
+				if !uploaded.dgs[dg] {
+					t.Errorf("Digest %v was not recorded as uploaded", dg)
+				}
+			}
+		})
+	}
+}
+
+func TestUploadIfMissingStream(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	e, cleanup := fakes.NewTestEnv(t)
+	defer cleanup()
+	fake := e.Server.CAS
+	c := e.Client.GrpcClient
+	client.UnifiedUploadTickDuration(10 * time.Millisecond).Apply(c)
+	foo, bar, baz := []byte("foo"), []byte("bar"), []byte("baz")
+	fooDg, barDg := digest.NewFromBlob(foo), digest.NewFromBlob(bar)
+	fake.Put(baz)
+
+	entries := make(chan *uploadinfo.Entry)
+	errs := make(chan error, 1)
+	go func() {
+		defer close(entries)
+		entries <- uploadinfo.EntryFromBlob(foo)
+		// The first entry is uploaded while the producer is still running.
+		deadline := time.Now().Add(10 * time.Second)
+		for fake.BlobWrites(fooDg) == 0 {
+			if time.Now().After(deadline) {
+				errs <- fmt.Errorf("blob %v was not uploaded before the stream was closed", fooDg)
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+		entries <- uploadinfo.EntryFromBlob(bar)
+		entries <- uploadinfo.EntryFromBlob(foo)
+		entries <- uploadinfo.EntryFromBlob(baz)
+		errs <- nil
+	}()
+	missing, _, err := c.UploadIfMissingStream(ctx, entries)
+	if err != nil {
+		t.Fatalf("UploadIfMissingStream() failed: %v", err)
+	}
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+	sort.Slice(missing, func(i, j int) bool { return missing[i].Hash < missing[j].Hash })
+	want := []digest.Digest{fooDg, barDg}
+	sort.Slice(want, func(i, j int) bool { return want[i].Hash < want[j].Hash })
+	if diff := cmp.Diff(want, missing); diff != "" {
+		t.Errorf("UploadIfMissingStream() gave missing diff (-want +got):\n%s", diff)
+	}
+	for _, dg := range []digest.Digest{fooDg, barDg} {
+		if n := fake.BlobWrites(dg); n != 1 {
+			t.Errorf("Missing digest %v was written %d times, want 1", dg, n)
+		}
+	}
+}
+
+func TestUploadStream(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	e, cleanup := fakes.NewTestEnv(t)
+	defer cleanup()
+	fake := e.Server.CAS
+	c := e.Client.GrpcClient
+	client.UnifiedUploadTickDuration(10 * time.Millisecond).Apply(c)
+	foo, bar := []byte("foo"), []byte("bar")
+	fooDg, barDg := digest.NewFromBlob(foo), digest.NewFromBlob(bar)
+	fake.Put(bar)
+	path := filepath.Join(t.TempDir(), "baz")
+	if err := os.WriteFile(path, []byte("baz"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	bazDg := digest.NewFromBlob([]byte("baz"))
+
+	entries := make(chan *uploadinfo.Entry)
+	go func() {
+		defer close(entries)
+		entries <- uploadinfo.EntryFromBlob(foo)
+		entries <- uploadinfo.EntryFromBlob(bar)
+		entries <- uploadinfo.EntryFromUndigestedFile(path)
+		entries <- uploadinfo.EntryFromBlob(foo)
+		entries <- uploadinfo.EntryFromBlob(nil)
+		entries <- uploadinfo.EntryFromUndigestedFile(filepath.Join(t.TempDir(), "nonexistent"))
+	}()
+	type result struct {
+		entries, missing int
+		moved            int64
+	}
+	got := make(map[digest.Digest]*result)
+	errs := 0
+	for r := range c.Upload(ctx, entries) {
+		if r.Err != nil {
+			errs++
+			continue
+		}
+		res, ok := got[r.Entry.Digest]
+		if !ok {
+			res = &result{}
+			got[r.Entry.Digest] = res
+		}
+		res.entries++
+		if r.Missing {
+			res.missing++
+		}
+		res.moved += r.BytesMoved
+	}
+	want := map[digest.Digest]*result{
+		fooDg:        {entries: 2, missing: 1, moved: 3},
+		barDg:        {entries: 1},
+		bazDg:        {entries: 1, missing: 1, moved: 3},
+		digest.Empty: {entries: 1},
+	}
+	if diff := cmp.Diff(want, got, cmp.AllowUnexported(result{})); diff != "" {
+		t.Errorf("Upload() gave results diff (-want +got):\n%s", diff)
+	}
+	if errs != 1 {
+		t.Errorf("Upload() gave %d errors, want 1 for the nonexistent file", errs)
+	}
+	for _, dg := range []digest.Digest{fooDg, bazDg} {
+		if n := fake.BlobWrites(dg); n != 1 {
+			t.Errorf("Missing digest %v was written %d times, want 1", dg, n)
+		}
+	}
+	if n := fake.BlobWrites(barDg); n != 0 {
+		t.Errorf("Present digest %v was written %d times, want 0", barDg, n)
+	}
+}
+
+func TestUploadIfMissingFromReader(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	for _, ub := range []client.UseBatchOps{false, true} {
+		ub := ub
+		t.Run(fmt.Sprintf("UseBatchOps:%t", ub), func(t *testing.T) {
+			t.Parallel()
+			e, cleanup := fakes.NewTestEnv(t)
+			defer cleanup()
+			fake := e.Server.CAS
+			c := e.Client.GrpcClient
+			ub.Apply(c)
+			blob := []byte("data from a stream")
+			dg := digest.NewFromBlob(blob)
+			ue := uploadinfo.EntryFromReader(dg, func() (io.ReadCloser, error) {
+				return io.NopCloser(bytes.NewBuffer(blob)), nil
+			})
+			missing, _, err := c.UploadIfMissing(ctx, ue)
+			if err != nil {
+				t.Fatalf("UploadIfMissing() failed: %v", err)
+			}
+			if diff := cmp.Diff([]digest.Digest{dg}, missing); diff != "" {
+				t.Errorf("UploadIfMissing() gave missing diff (-want +got):\n%s", diff)
+			}
+			if got, _ := fake.Get(dg); !bytes.Equal(got, blob) {
+				t.Errorf("CAS has %q, want %q", got, blob)
+			}
+		})
+	}
+}
+
+func TestUploadBackPressure(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	e, cleanup := fakes.NewTestEnv(t)
+	defer cleanup()
+	c := e.Client.GrpcClient
+	client.UploadQueueSize(1).Apply(c)
+	client.MaxConcurrentUploads(2).Apply(c)
+	client.MaxQueryBatchDigests(10).Apply(c)
+
+	entries := make(chan *uploadinfo.Entry)
+	results := c.Upload(ctx, entries)
+	// Without reading the results, the producer is eventually blocked.
+	sent := 0
+	for blocked := false; !blocked; {
+		select {
+		case entries <- uploadinfo.EntryFromBlob([]byte(strconv.Itoa(sent))):
+			sent++
+		case <-time.After(100 * time.Millisecond):
+			blocked = true
+		}
+		if sent > 1000 {
+			t.Fatalf("Upload() took %d entries without its results being read", sent)
+		}
+	}
+	close(entries)
+	got := 0
+	for r := range results {
+		if r.Err != nil {
+			t.Errorf("Upload() failed: %v", r.Err)
+		}
+		got++
+	}
+	if got != sent {
+		t.Errorf("Upload() gave %d results, want %d", got, sent)
+	}
+}
+
+func TestUploadIfMissingCoalescesConcurrentUploads(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	e, cleanup := fakes.NewTestEnv(t)
+	defer cleanup()
+	fake := e.Server.CAS
+	// Slow requests make the uploads overlap.
+	fake.ReqSleepDuration = 50 * time.Millisecond
+	c := e.Client.GrpcClient
+	foo, bar := []byte("foo"), []byte("bar")
+	fooDg, barDg := digest.NewFromBlob(foo), digest.NewFromBlob(bar)
+
+	eg, eCtx := errgroup.WithContext(ctx)
+	var mu sync.Mutex
+	var missing []digest.Digest
+	for i := 0; i < 10; i++ {
+		eg.Go(func() error {
+			m, _, err := c.UploadIfMissing(eCtx, uploadinfo.EntryFromBlob(foo), uploadinfo.EntryFromBlob(bar))
+			mu.Lock()
+			missing = append(missing, m...)
+			mu.Unlock()
+			return err
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		t.Fatalf("UploadIfMissing() failed: %v", err)
+	}
+	if len(missing) != 2 {
+		t.Errorf("UploadIfMissing() calls reported %v missing, want each digest once", missing)
+	}
+	for _, dg := range []digest.Digest{fooDg, barDg} {
+		if n := fake.BlobWrites(dg); n != 1 {
+			t.Errorf("Digest %v was written %d times, want 1", dg, n)
+		}
+		if n := fake.BlobMissingReqs(dg); n != 1 {
+			t.Errorf("Digest %v was queried %d times, want 1", dg, n)
+		}
+	}
+}
+
+func TestSHA256TreeTransfers(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	e, cleanup := fakes.NewTestEnv(t)
+	defer cleanup()
+	fn, err := digest.NewFunction(repb.DigestFunction_SHA256TREE)
+	if err != nil {
+		t.Fatalf("digest.NewFunction(SHA256TREE) failed: %v", err)
+	}
+	// Blobs of up to 1024 bytes have the same digest with SHA256TREE as with SHA256.
+	blob := make([]byte, 2048)
+	rand.Read(blob)
+	dg := fn.NewFromBlob(blob)
+	if dg == digest.NewFromBlob(blob) {
+		t.Fatalf("SHA256TREE digest of the blob is its SHA256 digest %v", dg)
+	}
+
+	for _, useBatchOps := range []bool{true, false} {
+		t.Run(fmt.Sprintf("UsingBatch:%t", useBatchOps), func(t *testing.T) {
+			e.Server.CAS.Clear()
+			conn, err := e.Server.NewClientConn(ctx)
+			if err != nil {
+				t.Fatalf("NewClientConn() failed: %v", err)
+			}
+			c, err := client.NewClientFromConnection(ctx, "instance", conn, conn,
+				client.DigestFunction(repb.DigestFunction_SHA256TREE), client.StartupCapabilities(false), client.UseBatchOps(useBatchOps))
+			if err != nil {
+				t.Fatalf("NewClient() failed: %v", err)
+			}
+			defer c.Close()
+			if _, _, err := c.UploadIfMissing(ctx, uploadinfo.EntryFromBlobWith(fn, blob)); err != nil {
+				t.Fatalf("UploadIfMissing() failed: %v", err)
+			}
+			if got, ok := e.Server.CAS.Get(dg); !ok || !bytes.Equal(got, blob) {
+				t.Errorf("CAS does not have the blob at %v after upload", dg)
+			}
+			got, _, err := c.ReadBlob(ctx, dg)
+			if err != nil {
+				t.Fatalf("ReadBlob(%v) failed: %v", dg, err)
+			}
+			if !bytes.Equal(got, blob) {
+				t.Errorf("ReadBlob(%v) returned a different blob", dg)
+			}
+		})
+	}
+}
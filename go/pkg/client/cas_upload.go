@@ -3,9 +3,7 @@ package client
 import (
 	"context"
 	"fmt"
-	"strconv"
 	"sync"
-	"sync/atomic"
 	"time"
 
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/chunker"
@@ -24,19 +22,28 @@ import (
 )
 
 // MissingBlobs queries the CAS to determine if it has the specified blobs.
-// Returns a slice of missing blobs.
+// Returns a slice of missing blobs. The queries are batched by MaxQueryBatchDigests and retried,
+// and skip the digests known to be present through UploadedDigests, or known to be missing
+// through MissingBlobsCacheTTL.
 func (c *Client) MissingBlobs(ctx context.Context, digests []digest.Digest) ([]digest.Digest, error) {
-	var missing []digest.Digest
+	ctx, done, err := c.beginOp(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	missing, queried := c.missingBlobs.split(c.notUploaded(ctx, digests))
+	cached := len(missing)
 	var resultMutex sync.Mutex
-	batches := c.makeQueryBatches(ctx, digests)
+	batches := c.makeQueryBatches(ctx, queried)
 	eg, eCtx := errgroup.WithContext(ctx)
 	for i, batch := range batches {
 		i, batch := i, batch // https://golang.org/doc/faq#closures_and_goroutines
 		eg.Go(func() error {
-			if err := c.casUploaders.Acquire(eCtx, 1); err != nil {
+			release, err := c.acquireUpload(eCtx, 0)
+			if err != nil {
 				return err
 			}
-			defer c.casUploaders.Release(1)
+			defer release()
 			if i%logInterval == 0 {
 				contextmd.Infof(ctx, log.Level(3), "%d missing batches left to query", len(batches)-i)
 			}
@@ -45,8 +52,9 @@ func (c *Client) MissingBlobs(ctx context.Context, digests []digest.Digest) ([]d
 				batchPb = append(batchPb, dg.ToProto())
 			}
 			req := &repb.FindMissingBlobsRequest{
-				InstanceName: c.InstanceName,
-				BlobDigests:  batchPb,
+				InstanceName:   c.InstanceName,
+				BlobDigests:    batchPb,
+				DigestFunction: c.digestFn.Value(),
 			}
 			resp, err := c.FindMissingBlobs(eCtx, req)
 			if err != nil {
@@ -64,8 +72,24 @@ func (c *Client) MissingBlobs(ctx context.Context, digests []digest.Digest) ([]d
 		})
 	}
 	contextmd.Infof(ctx, log.Level(3), "Waiting for remaining query jobs")
-	err := eg.Wait()
+	err = eg.Wait()
 	contextmd.Infof(ctx, log.Level(3), "Done")
+	if err == nil {
+		c.missingBlobs.add(missing[cached:])
+	}
+	if err == nil && c.UploadedDigests != nil {
+		missingDgs := make(map[digest.Digest]bool, len(missing))
+		for _, dg := range missing {
+			missingDgs[dg] = true
+		}
+		var present []digest.Digest
+		for _, dg := range queried {
+			if !missingDgs[dg] {
+				present = append(present, dg)
+			}
+		}
+		c.addUploaded(ctx, present)
+	}
 	return missing, err
 }
 
@@ -75,6 +99,11 @@ func (c *Client) MissingBlobs(ctx context.Context, digests []digest.Digest) ([]d
 // Returns a slice of missing digests that were written and the sum of total bytes moved, which
 // may be different from logical bytes moved (i.e. sum of digest sizes) due to compression.
 func (c *Client) UploadIfMissing(ctx context.Context, entries ...*uploadinfo.Entry) ([]digest.Digest, int64, error) {
+	ctx, done, err := c.beginOp(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer done()
 	if c.UnifiedUploads {
 		return c.uploadUnified(ctx, entries...)
 	}
@@ -90,7 +119,7 @@ func (c *Client) UploadIfMissing(ctx context.Context, entries ...*uploadinfo.Ent
 func (c *Client) WriteBlobs(ctx context.Context, blobs map[digest.Digest][]byte) error {
 	var uEntries []*uploadinfo.Entry
 	for _, blob := range blobs {
-		uEntries = append(uEntries, uploadinfo.EntryFromBlob(blob))
+		uEntries = append(uEntries, uploadinfo.EntryFromBlobWith(c.digestFn, blob))
 	}
 	_, _, err := c.UploadIfMissing(ctx, uEntries...)
 	return err
@@ -98,12 +127,13 @@ func (c *Client) WriteBlobs(ctx context.Context, blobs map[digest.Digest][]byte)
 
 // WriteBlob (over)writes a blob to the CAS regardless if it already exists.
 func (c *Client) WriteBlob(ctx context.Context, blob []byte) (digest.Digest, error) {
-	ue := uploadinfo.EntryFromBlob(blob)
+	ue := uploadinfo.EntryFromBlobWith(c.digestFn, blob)
 	dg := ue.Digest
 	if dg.IsEmpty() {
 		contextmd.Infof(ctx, log.Level(2), "Skipping upload of empty blob %s", dg)
 		return dg, nil
 	}
+	c.missingBlobs.forget([]digest.Digest{dg})
 	ch, err := chunker.New(ue, c.shouldCompressEntry(ue), int(c.ChunkMaxSize))
 	if err != nil {
 		return dg, err
@@ -130,13 +160,32 @@ var zstdEncoder, _ = zstd.NewWriter(nil)
 // is about 4 MB (see MaxBatchSize).
 // In case multiple errors occur during the blob upload, the last error is returned.
 func (c *Client) BatchWriteBlobs(ctx context.Context, blobs map[digest.Digest][]byte) error {
-	var reqs []*repb.BatchUpdateBlobsRequest_Request
+	_, err := c.batchWriteBlobs(ctx, blobs)
+	return err
+}
+
+// batchWriteBlobs is BatchWriteBlobs, also returning the final error of each blob that failed.
+func (c *Client) batchWriteBlobs(ctx context.Context, blobs map[digest.Digest][]byte) (map[digest.Digest]error, error) {
+	if c.missingBlobs != nil {
+		dgs := make([]digest.Digest, 0, len(blobs))
+		for dg := range blobs {
+			dgs = append(dgs, dg)
+		}
+		c.missingBlobs.forget(dgs)
+	}
+	if c.bytestreamOnly.Load() {
+		return c.writeBlobsIndividually(ctx, blobs)
+	}
+	reqs := make([]*repb.BatchUpdateBlobsRequest_Request, 0, len(blobs))
+	// The requests are kept by digest, so that retries reuse them rather than encoding the blobs again.
+	byDg := make(map[digest.Digest]*repb.BatchUpdateBlobsRequest_Request, len(blobs))
 	var sz int64
 	for k, b := range blobs {
 		r := &repb.BatchUpdateBlobsRequest_Request{
 			Digest: k.ToProto(),
 			Data:   b,
 		}
+		byDg[k] = r
 		if bool(c.useBatchCompression) && c.shouldCompress(k.Size) {
 			r.Data = zstdEncoder.EncodeAll(r.Data, nil)
 			r.Compressor = repb.Compressor_ZSTD
@@ -147,18 +196,20 @@ func (c *Client) BatchWriteBlobs(ctx context.Context, blobs map[digest.Digest][]
 		reqs = append(reqs, r)
 	}
 	if sz > int64(c.MaxBatchSize) {
-		return fmt.Errorf("batch update of %d total bytes exceeds maximum of %d", sz, c.MaxBatchSize)
+		return nil, fmt.Errorf("batch update of %d total bytes exceeds maximum of %d", sz, c.MaxBatchSize)
 	}
 	if len(blobs) > int(c.MaxBatchDigests) {
-		return fmt.Errorf("batch update of %d total blobs exceeds maximum of %d", len(blobs), c.MaxBatchDigests)
+		return nil, fmt.Errorf("batch update of %d total blobs exceeds maximum of %d", len(blobs), c.MaxBatchDigests)
 	}
+	failed := make(map[digest.Digest]error)
 	opts := c.RPCOpts()
 	closure := func() error {
 		var resp *repb.BatchUpdateBlobsResponse
 		err := c.CallWithTimeout(ctx, "BatchUpdateBlobs", func(ctx context.Context) (e error) {
 			resp, e = c.cas.BatchUpdateBlobs(ctx, &repb.BatchUpdateBlobsRequest{
-				InstanceName: c.InstanceName,
-				Requests:     reqs,
+				InstanceName:   c.InstanceName,
+				Requests:       reqs,
+				DigestFunction: c.digestFn.Value(),
 			}, opts...)
 			return e
 		})
@@ -171,14 +222,15 @@ func (c *Client) BatchWriteBlobs(ctx context.Context, blobs map[digest.Digest][]
 		var retriableError error
 		allRetriable := true
 		for _, r := range resp.Responses {
+			dg := digest.NewFromProtoUnvalidated(r.Digest)
 			st := status.FromProto(r.Status)
 			if st.Code() != codes.OK {
 				e := StatusDetailedError(st)
+				failed[dg] = e
 				if c.Retrier.ShouldRetry(e) {
-					failedReqs = append(failedReqs, &repb.BatchUpdateBlobsRequest_Request{
-						Digest: r.Digest,
-						Data:   blobs[digest.NewFromProtoUnvalidated(r.Digest)],
-					})
+					if req, ok := byDg[dg]; ok {
+						failedReqs = append(failedReqs, req)
+					}
 					retriableError = e
 				} else {
 					allRetriable = false
@@ -186,6 +238,8 @@ func (c *Client) BatchWriteBlobs(ctx context.Context, blobs map[digest.Digest][]
 				numErrs++
 				errDg = r.Digest
 				errMsg = e.Error()
+			} else {
+				delete(failed, dg)
 			}
 		}
 		reqs = failedReqs
@@ -197,12 +251,53 @@ func (c *Client) BatchWriteBlobs(ctx context.Context, blobs map[digest.Digest][]
 		}
 		return nil
 	}
-	return c.Retrier.Do(ctx, closure)
+	err := c.retryRPC(ctx, closure)
+	if c.fallBackToBytestream("BatchUpdateBlobs", err) {
+		return c.writeBlobsIndividually(ctx, blobs)
+	}
+	if err != nil {
+		// The blobs still to be written failed with the last error, unless they failed themselves.
+		for _, r := range reqs {
+			if dg := digest.NewFromProtoUnvalidated(r.Digest); failed[dg] == nil {
+				failed[dg] = err
+			}
+		}
+	}
+	return failed, err
+}
+
+// WriteBlobsInBatches (over)writes blobs to the CAS regardless of whether they already exist, like
+// BatchWriteBlobs but without its size limits: the blobs are packed into as few BatchUpdateBlobs
+// requests as MaxBatchSize and MaxBatchDigests allow, and the blobs too large for a batch are
+// written with ByteStream. All the blobs are attempted, and if any of them failed, the error is a
+// *BatchError.
+func (c *Client) WriteBlobsInBatches(ctx context.Context, blobs map[digest.Digest][]byte) error {
+	dgs := make([]digest.Digest, 0, len(blobs))
+	for dg := range blobs {
+		if dg.Size > 0 {
+			dgs = append(dgs, dg)
+		}
+	}
+	c.missingBlobs.forget(dgs)
+	failed := c.inBatches(ctx, dgs, c.acquireUpload, func(batch []digest.Digest) (map[digest.Digest]error, error) {
+		bchMap := make(map[digest.Digest][]byte, len(batch))
+		for _, dg := range batch {
+			bchMap[dg] = blobs[dg]
+		}
+		if len(batch) > 1 {
+			return c.batchWriteBlobs(ctx, bchMap)
+		}
+		return c.writeBlobsIndividually(ctx, bchMap)
+	})
+	if len(failed) > 0 {
+		return &BatchError{Op: "write", Blobs: failed}
+	}
+	return nil
 }
 
 // ResourceNameWrite generates a valid write resource name.
 func (c *Client) ResourceNameWrite(hash string, sizeBytes int64) string {
-	rname, _ := c.ResourceName("uploads", uuid.New(), "blobs", hash, strconv.FormatInt(sizeBytes, 10))
+	rname, _ := c.ResourceName(append([]string{"uploads", uuid.New(), "blobs"}, c.blobSegments(hash, sizeBytes)...)...)
 	return rname
 }
 
@@ -210,7 +305,7 @@ func (c *Client) ResourceNameWrite(hash string, sizeBytes int64) string {
 // TODO(rubensf): Converge compressor to proto in https://github.com/bazelbuild/remote-apis/pull/168 once
 // that gets merged in.
 func (c *Client) ResourceNameCompressedWrite(hash string, sizeBytes int64) string {
-	rname, _ := c.ResourceName("uploads", uuid.New(), "compressed-blobs", "zstd", hash, strconv.FormatInt(sizeBytes, 10))
+	rname, _ := c.ResourceName(append([]string{"uploads", uuid.New(), "compressed-blobs", "zstd"}, c.blobSegments(hash, sizeBytes)...)...)
 	return rname
 }
 
@@ -320,6 +415,11 @@ func (c *Client) uploadUnified(ctx context.Context, entries ...*uploadinfo.Entry
 			totalBytesMoved += resp.bytesMoved
 		}
 	}
+	var uploaded []digest.Digest
+	for _, req := range reqs {
+		uploaded = append(uploaded, req.ue.Digest)
+	}
+	c.addUploaded(ctx, uploaded)
 	return finalMissing, totalBytesMoved, nil
 }
 
@@ -388,6 +488,9 @@ func (c *Client) upload(ctx context.Context, reqs []*uploadRequest) {
 	newStates := make(map[digest.Digest]*uploadState)
 	var newUploads []digest.Digest
 	var metas []*contextmd.Metadata
+	// actions are the actions that first requested each new upload, which the transfers are
+	// scheduled for.
+	actions := make(map[digest.Digest]string)
 	log.V(2).Infof("Upload is processing %d requests", len(reqs))
 	for _, req := range reqs {
 		dg := req.ue.Digest
@@ -408,6 +511,7 @@ func (c *Client) upload(ctx context.Context, reqs []*uploadRequest) {
 			c.casUploads[dg] = st
 			newUploads = append(newUploads, dg)
 			metas = append(metas, req.meta)
+			actions[dg] = req.meta.ActionID
 			newStates[dg] = st
 		}
 	}
@@ -426,7 +530,7 @@ func (c *Client) upload(ctx context.Context, reqs []*uploadRequest) {
 
 	contextmd.Infof(ctx, log.Level(2), "%d new items to store", len(newUploads))
 	var batches [][]digest.Digest
-	if c.useBatchOps {
+	if c.batchOps() {
 		batches = c.makeBatches(ctx, newUploads, true)
 	} else {
 		contextmd.Infof(ctx, log.Level(2), "Uploading them individually")
@@ -439,8 +543,8 @@ func (c *Client) upload(ctx context.Context, reqs []*uploadRequest) {
 	for i, batch := range batches {
 		i, batch := i, batch // https://golang.org/doc/faq#closures_and_goroutines
 		go func() {
-			if c.casUploaders.Acquire(ctx, 1) == nil {
-				defer c.casUploaders.Release(1)
+			if release, err := c.acquireUploadFor(ctx, actions[batch[0]], batchSize(batch)); err == nil {
+				defer release()
 			}
 			if i%logInterval == 0 {
 				contextmd.Infof(ctx, log.Level(2), "%d batches left to store", len(batches)-i)
@@ -495,27 +599,20 @@ func (c *Client) upload(ctx context.Context, reqs []*uploadRequest) {
 // This function is only used when UnifiedUploads is false. It will be removed
 // once UnifiedUploads=true is stable.
 func (c *Client) uploadNonUnified(ctx context.Context, data ...*uploadinfo.Entry) ([]digest.Digest, int64, error) {
-	var dgs []digest.Digest
-	ueList := make(map[digest.Digest]*uploadinfo.Entry)
+	entries := make(chan *uploadinfo.Entry, len(data))
 	for _, ue := range data {
-		dg := ue.Digest
-		if dg.IsEmpty() {
-			contextmd.Infof(ctx, log.Level(2), "Skipping upload of empty blob %s", dg)
-			continue
-		}
-		if _, ok := ueList[dg]; !ok {
-			dgs = append(dgs, dg)
-			ueList[dg] = ue
-		}
+		entries <- ue
 	}
+	close(entries)
+	return c.uploadPipelined(ctx, entries)
+}
 
-	missing, err := c.MissingBlobs(ctx, dgs)
-	if err != nil {
-		return nil, 0, err
-	}
+// uploadMissing uploads the given missing blobs, whose entries are in ueList, and returns the
+// number of bytes transferred for each of them.
+func (c *Client) uploadMissing(ctx context.Context, missing []digest.Digest, ueList map[digest.Digest]*uploadinfo.Entry) (map[digest.Digest]int64, error) {
 	contextmd.Infof(ctx, log.Level(2), "%d items to store", len(missing))
 	var batches [][]digest.Digest
-	if c.useBatchOps {
+	if c.batchOps() {
 		batches = c.makeBatches(ctx, missing, true)
 	} else {
 		contextmd.Infof(ctx, log.Level(2), "Uploading them individually")
@@ -525,16 +622,23 @@ func (c *Client) uploadNonUnified(ctx context.Context, data ...*uploadinfo.Entry
 		}
 	}
 
-	totalBytesTransferred := int64(0)
+	var mu sync.Mutex
+	bytesTransferred := make(map[digest.Digest]int64)
+	transferred := func(dg digest.Digest, n int64) {
+		mu.Lock()
+		bytesTransferred[dg] = n
+		mu.Unlock()
+	}
 
 	eg, eCtx := errgroup.WithContext(ctx)
 	for i, batch := range batches {
 		i, batch := i, batch // https://golang.org/doc/faq#closures_and_goroutines
 		eg.Go(func() error {
-			if err := c.casUploaders.Acquire(eCtx, 1); err != nil {
+			release, err := c.acquireUpload(eCtx, batchSize(batch))
+			if err != nil {
 				return err
 			}
-			defer c.casUploaders.Release(1)
+			defer release()
 			if i%logInterval == 0 {
 				contextmd.Infof(ctx, log.Level(2), "%d batches left to store", len(batches)-i)
 			}
@@ -558,11 +662,13 @@ func (c *Client) uploadNonUnified(ctx context.Context, data ...*uploadinfo.Entry
 					}
 
 					bchMap[dg] = data
-					atomic.AddInt64(&totalBytesTransferred, int64(len(data)))
 				}
 				if err := c.BatchWriteBlobs(eCtx, bchMap); err != nil {
 					return err
 				}
+				for dg, data := range bchMap {
+					transferred(dg, int64(len(data)))
+				}
 			} else {
 				contextmd.Infof(ctx, log.Level(3), "Uploading single blob with digest %s", batch[0])
 				ue := ueList[batch[0]]
@@ -574,7 +680,7 @@ func (c *Client) uploadNonUnified(ctx context.Context, data ...*uploadinfo.Entry
 				if err != nil {
 					return fmt.Errorf("failed to upload %s: %w", ue.Path, err)
 				}
-				atomic.AddInt64(&totalBytesTransferred, written)
+				transferred(batch[0], written)
 			}
 			if eCtx.Err() != nil {
 				return eCtx.Err()
@@ -584,13 +690,12 @@ func (c *Client) uploadNonUnified(ctx context.Context, data ...*uploadinfo.Entry
 	}
 
 	contextmd.Infof(ctx, log.Level(2), "Waiting for remaining jobs")
-	err = eg.Wait()
+	err := eg.Wait()
 	contextmd.Infof(ctx, log.Level(2), "Done")
 	if err != nil {
 		contextmd.Infof(ctx, log.Level(2), "Upload error: %v", err)
 	}
-
-	return missing, totalBytesTransferred, err
+	return bytesTransferred, err
 }
 
 func (c *Client) cancelPendingRequests(reqs []*uploadRequest) {
@@ -11,18 +11,22 @@ import (
 	"os/user"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/actas"
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/balancer"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/cache"
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/chunker"
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/digest"
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/retry"
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/uploadinfo"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/version"
 	"github.com/pkg/errors"
 	"golang.org/x/oauth2"
 	"golang.org/x/sync/semaphore"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/oauth"
 	"google.golang.org/grpc/status"
@@ -72,6 +76,10 @@ const (
 	// GCECredsAuth refers to GCE machine credentials that is
 	// used to connect to the RBE service.
 	GCECredsAuth
+
+	// NetrcAuth refers to the login and password of a .netrc entry used to connect to the RBE
+	// service with basic authentication.
+	NetrcAuth
 )
 
 // String returns a human readable form of authentication used to connect to RBE.
@@ -87,6 +95,8 @@ func (a AuthType) String() string {
 		return "application default credentials"
 	case GCECredsAuth:
 		return "gce credentials"
+	case NetrcAuth:
+		return "netrc credentials"
 	}
 	return "unknown authentication type"
 }
@@ -154,6 +164,14 @@ type Client struct {
 	ExecutableMode os.FileMode
 	// RegularMode is mode used to create non-executable files.
 	RegularMode os.FileMode
+	// DownloadUmask is a set of permission bits cleared on downloaded outputs.
+	DownloadUmask DownloadUmask
+	// ReadOnlyOutputs specifies whether downloaded outputs are made read-only.
+	ReadOnlyOutputs ReadOnlyOutputs
+	// OutputPermissionFunc, if set, is called to decide the final permissions of each downloaded output.
+	OutputPermissionFunc OutputPermissionFunc
+	// AbsoluteOutputSymlinks selects whether output symlinks with absolute targets are materialized.
+	AbsoluteOutputSymlinks AbsoluteOutputSymlinks
 	// UtilizeLocality is to specify whether client downloads files utilizing disk access locality.
 	UtilizeLocality UtilizeLocality
 	// UnifiedUploads specifies whether the client uploads files in the background.
@@ -162,6 +180,8 @@ type Client struct {
 	UnifiedUploadBufferSize UnifiedUploadBufferSize
 	// UnifiedUploadTickDuration specifies how often the unified upload daemon flushes the pending requests.
 	UnifiedUploadTickDuration UnifiedUploadTickDuration
+	// UploadQueueSize is the capacity of the queues between the stages of Upload.
+	UploadQueueSize UploadQueueSize
 	// UnifiedDownloads specifies whether the client downloads files in the background.
 	UnifiedDownloads UnifiedDownloads
 	// UnifiedDownloadBufferSize specifies when the unified download daemon flushes the pending requests.
@@ -170,20 +190,78 @@ type Client struct {
 	UnifiedDownloadTickDuration UnifiedDownloadTickDuration
 	// TreeSymlinkOpts controls how symlinks are handled when constructing a tree.
 	TreeSymlinkOpts *TreeSymlinkOpts
-
-	serverCaps          *repb.ServerCapabilities
-	useBatchOps         UseBatchOps
-	casConcurrency      int64
-	casUploaders        *semaphore.Weighted
-	casUploadRequests   chan *uploadRequest
-	casUploads          map[digest.Digest]*uploadState
-	casDownloaders      *semaphore.Weighted
-	casDownloadRequests chan *downloadRequest
-	rpcTimeouts         RPCTimeouts
-	creds               credentials.PerRPCCredentials
-	uploadOnce          sync.Once
-	downloadOnce        sync.Once
-	useBatchCompression UseBatchCompression
+	// RestoreOutputMtimes specifies whether downloaded outputs get the modification time from their
+	// NodeProperties, if the server provided one.
+	RestoreOutputMtimes RestoreOutputMtimes
+	// OutputMtime, if not zero, is the modification time set on downloaded outputs that did not get
+	// one restored from their NodeProperties.
+	OutputMtime OutputMtime
+	// UploadedDigests, if set, is consulted to skip querying and uploading digests that are known to
+	// be in the CAS already.
+	UploadedDigests UploadedDigests
+	// InlineOutputFiles, if set, selects the output files requested inline on action cache lookups.
+	InlineOutputFiles *InlineOutputFiles
+	// OutputService, if set, is the external store downloaded outputs are delegated to.
+	OutputService OutputService
+	// LocalCAS, if set, is the local content-addressed directory outputs are downloaded to, and
+	// made from.
+	LocalCAS *LocalCAS
+	// TreeSpillDir, if set, makes ComputeMerkleTree bound its memory use by writing the Directory
+	// protos of input trees to this directory.
+	TreeSpillDir TreeSpillDir
+	// TreeMountOpts controls how mount points are handled when constructing a tree.
+	TreeMountOpts *TreeMountOpts
+	// TraversalConcurrency, if set, overrides how many files are digested concurrently while
+	// building trees.
+	TraversalConcurrency *TraversalConcurrency
+	// DirectoryCache, if set, caches the Directory protos of input directories across
+	// ComputeMerkleTree calls.
+	DirectoryCache *DirectoryCache
+	// PreserveEmptyDirs keeps the input directories whose contents are all excluded or skipped.
+	PreserveEmptyDirs PreserveEmptyDirs
+
+	serverCaps              *repb.ServerCapabilities
+	fallbackCaps            *repb.ServerCapabilities
+	digestFnValue           repb.DigestFunction_Value
+	digestFn                digest.Function
+	fileDigests             cache.SingleFlight
+	negotiateDigestFunction bool
+	negotiateCompression    bool
+	useBatchOps             UseBatchOps
+	casConcurrency          int64
+	uploadConcurrency       int64
+	downloadConcurrency     int64
+	rpcLimiter              *semaphore.Weighted
+	uploadLimiter           *bandwidthLimiter
+	downloadLimiter         *bandwidthLimiter
+	downloadMemory          *semaphore.Weighted
+	casUploaders            *semaphore.Weighted
+	casUploadRequests       chan *uploadRequest
+	casUploads              map[digest.Digest]*uploadState
+	uploadFlightsMu         sync.Mutex
+	uploadFlights           map[digest.Digest]*uploadFlight
+	missingBlobs            *missingCache
+	casDownloaders          *semaphore.Weighted
+	casDownloadRequests     chan *downloadRequest
+	fairTransfers           *FairTransfers
+	uploadScheduler         *transferScheduler
+	downloadScheduler       *transferScheduler
+	rpcTimeouts             RPCTimeouts
+	creds                   credentials.PerRPCCredentials
+	uploadOnce              sync.Once
+	downloadOnce            sync.Once
+	useBatchCompression     UseBatchCompression
+	opMu                    sync.Mutex
+	shuttingDown            bool
+	ops                     sync.WaitGroup
+	bytestreamOnly          atomic.Bool
+	writeRetries            atomic.Int64
+	writeResumes            atomic.Int64
+	writeResumedBytes       atomic.Int64
+	readRetries             atomic.Int64
+	readResumes             atomic.Int64
+	readResumedBytes        atomic.Int64
+	resumeWatcher           *resumeWatcher
 }
 
 const (
@@ -213,6 +291,9 @@ func (c *Client) Close() error {
 	// Close the channels & stop background operations.
 	UnifiedUploads(false).Apply(c)
 	UnifiedDownloads(false).Apply(c)
+	if c.resumeWatcher != nil {
+		c.resumeWatcher.close()
+	}
 	err := c.Connection.Close()
 	if err != nil {
 		return err
@@ -223,6 +304,70 @@ func (c *Client) Close() error {
 	return nil
 }
 
+// ErrShutdown is returned by operations started after Shutdown was called. Its code is
+// FailedPrecondition, rather than a transient one, so that retriers do not retry it.
+var ErrShutdown = status.Error(codes.FailedPrecondition, "client is shutting down")
+
+// Shutdown gracefully shuts the client down. It stops accepting new operations, waits for the
+// operations already in progress (such as uploads and executions) to finish, logs the final
+// transfer stats and flushes the logs, and then closes the underlying connections. If ctx is done
+// before all operations finish, the connections are closed anyway and the context error is
+// returned.
+func (c *Client) Shutdown(ctx context.Context) error {
+	c.opMu.Lock()
+	c.shuttingDown = true
+	c.opMu.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		c.ops.Wait()
+		close(drained)
+	}()
+	var err error
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		err = ctx.Err()
+		log.Warningf("Shutdown: closing the client with operations still in progress: %v", err)
+	}
+	log.Infof("Shutdown: ByteStream write stats %+v, read stats %+v", c.ByteStreamWriteStats(), c.ByteStreamReadStats())
+	log.Flush()
+	if cerr := c.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// opKey marks the contexts of the operations registered with a client.
+type opKey struct{ c *Client }
+
+// beginOp registers the start of an operation, so that Shutdown waits for it. It returns
+// ErrShutdown if the client is shutting down, unless ctx belongs to an operation that was already
+// registered with the client. The returned context marks the operation, and the returned function
+// must be called once the operation is done.
+func (c *Client) beginOp(ctx context.Context) (context.Context, func(), error) {
+	if ctx.Value(opKey{c}) != nil {
+		return ctx, func() {}, nil
+	}
+	c.opMu.Lock()
+	defer c.opMu.Unlock()
+	if c.shuttingDown {
+		return ctx, nil, ErrShutdown
+	}
+	c.ops.Add(1)
+	return context.WithValue(ctx, opKey{c}, true), c.ops.Done, nil
+}
+
+// retryRPC runs f with the client's retrier as a single operation, see beginOp.
+func (c *Client) retryRPC(ctx context.Context, f func() error) error {
+	ctx, done, err := c.beginOp(ctx)
+	if err != nil {
+		return err
+	}
+	defer done()
+	return c.Retrier.Do(c.withResumeWakeup(ctx), f)
+}
+
 // Opt is an option that can be passed to Dial in order to configure the behaviour of the client.
 type Opt interface {
 	Apply(*Client)
@@ -245,6 +390,16 @@ func (s CompressedBytestreamThreshold) Apply(c *Client) {
 	c.CompressedBytestreamThreshold = s
 }
 
+// NegotiateCompression can be set to true for CheckCapabilities to disable compression if the
+// server does not support zstd, rather than failing, so that CompressedBytestreamThreshold can be
+// set regardless of the server.
+type NegotiateCompression bool
+
+// Apply sets the NegotiateCompression flag on a client.
+func (n NegotiateCompression) Apply(c *Client) {
+	c.negotiateCompression = bool(n)
+}
+
 // An UploadCompressionPredicate determines whether to compress a blob on upload.
 // Note that the CompressedBytestreamThreshold takes priority over this (i.e. if the blob to be uploaded
 // is smaller than the threshold, this will not be called).
@@ -293,6 +448,18 @@ func (s UnifiedUploadTickDuration) Apply(c *Client) {
 	c.UnifiedUploadTickDuration = s
 }
 
+// UploadQueueSize is the capacity of the queues between the stages of Upload, which bounds how
+// far producing entries can get ahead of uploading them.
+type UploadQueueSize int
+
+// DefaultUploadQueueSize is the default UploadQueueSize.
+const DefaultUploadQueueSize = 1000
+
+// Apply sets the client's UploadQueueSize.
+func (s UploadQueueSize) Apply(c *Client) {
+	c.UploadQueueSize = s
+}
+
 // UnifiedDownloads is to specify whether client uploads files in the background, unifying operations between different actions.
 type UnifiedDownloads bool
 
@@ -329,6 +496,84 @@ func (o *TreeSymlinkOpts) Apply(c *Client) {
 	c.TreeSymlinkOpts = o
 }
 
+// PreserveEmptyDirs is to specify whether input directories whose contents are all excluded or
+// skipped are kept as empty directories, rather than left out of the tree. Directories that are
+// empty on disk are always kept.
+type PreserveEmptyDirs bool
+
+// Apply sets the client's PreserveEmptyDirs.
+func (p PreserveEmptyDirs) Apply(c *Client) {
+	c.PreserveEmptyDirs = p
+}
+
+// DownloadUmask is a set of permission bits cleared from the modes of downloaded outputs.
+type DownloadUmask os.FileMode
+
+// Apply sets the client's DownloadUmask to m.
+func (m DownloadUmask) Apply(c *Client) {
+	c.DownloadUmask = m
+}
+
+// ReadOnlyOutputs specifies whether downloaded outputs are made read-only. This protects outputs
+// hardlinked into or from content-addressed caches from accidental modification.
+type ReadOnlyOutputs bool
+
+// Apply sets the client's ReadOnlyOutputs.
+func (r ReadOnlyOutputs) Apply(c *Client) {
+	c.ReadOnlyOutputs = r
+}
+
+// AbsoluteOutputSymlinks selects whether output symlinks with absolute targets are materialized
+// on download. Such symlinks are not portable across machines, and may point outside of the output
+// directory.
+type AbsoluteOutputSymlinks int
+
+const (
+	// AllowAbsoluteOutputSymlinks always materializes them. This is the default, as it was before
+	// the policy was introduced.
+	AllowAbsoluteOutputSymlinks AbsoluteOutputSymlinks = iota
+	// AbsoluteOutputSymlinksPerCapabilities materializes them unless the server's cache
+	// capabilities disallow absolute symlinks, in which case the server should not have produced
+	// any and the download fails.
+	AbsoluteOutputSymlinksPerCapabilities
+	// RejectAbsoluteOutputSymlinks always fails the download.
+	RejectAbsoluteOutputSymlinks
+)
+
+// Apply sets the client's AbsoluteOutputSymlinks.
+func (a AbsoluteOutputSymlinks) Apply(c *Client) {
+	c.AbsoluteOutputSymlinks = a
+}
+
+// OutputPermissionFunc is called with the path of each downloaded output, relative to the output
+// directory, and the permissions it would get after applying DownloadUmask and ReadOnlyOutputs. It
+// returns the permissions to set on the output instead.
+type OutputPermissionFunc func(path string, perm os.FileMode) os.FileMode
+
+// Apply sets the client's OutputPermissionFunc to f.
+func (f OutputPermissionFunc) Apply(c *Client) {
+	c.OutputPermissionFunc = f
+}
+
+// RestoreOutputMtimes specifies whether downloaded outputs get the modification time from their
+// NodeProperties. Timestamp-based incremental build systems consuming the outputs will otherwise
+// consider all of them to be new.
+type RestoreOutputMtimes bool
+
+// Apply sets the client's RestoreOutputMtimes.
+func (r RestoreOutputMtimes) Apply(c *Client) {
+	c.RestoreOutputMtimes = r
+}
+
+// OutputMtime is a constant modification time to set on downloaded outputs. If RestoreOutputMtimes
+// is also set, it only applies to outputs without an mtime in their NodeProperties.
+type OutputMtime time.Time
+
+// Apply sets the client's OutputMtime.
+func (t OutputMtime) Apply(c *Client) {
+	c.OutputMtime = t
+}
+
 // MaxBatchDigests is maximum amount of digests to batch in upload and download operations.
 type MaxBatchDigests int
 
@@ -377,6 +622,30 @@ func (m RegularMode) Apply(c *Client) {
 	c.RegularMode = os.FileMode(m)
 }
 
+// DigestFunction is the digest function the client hashes blobs with, digest.HashFn by default.
+// Each client has its own, so clients of servers with different digest functions can be used in
+// the same process.
+type DigestFunction repb.DigestFunction_Value
+
+// Apply sets the digest function of a client. NewClient fails if it is not supported.
+func (f DigestFunction) Apply(c *Client) {
+	c.digestFnValue = repb.DigestFunction_Value(f)
+}
+
+// DigestFunction returns the digest function the client hashes blobs with.
+func (c *Client) DigestFunction() digest.Function {
+	return c.digestFn
+}
+
+// NegotiateDigestFunction can be set to true for CheckCapabilities to switch to the digest
+// function the server requires, see digest.Function.Negotiate, rather than failing if it differs.
+type NegotiateDigestFunction bool
+
+// Apply sets the NegotiateDigestFunction flag on a client.
+func (n NegotiateDigestFunction) Apply(c *Client) {
+	c.negotiateDigestFunction = bool(n)
+}
+
 // UseBatchOps can be set to true to use batch CAS operations when uploading multiple blobs, or
 // false to always use individual ByteStream requests.
 type UseBatchOps bool
@@ -413,8 +682,45 @@ const DefaultMaxConcurrentStreams = 25
 // Apply sets the CASConcurrency flag on a client.
 func (cy CASConcurrency) Apply(c *Client) {
 	c.casConcurrency = int64(cy)
-	c.casUploaders = semaphore.NewWeighted(c.casConcurrency)
-	c.casDownloaders = semaphore.NewWeighted(c.casConcurrency)
+	MaxConcurrentUploads(cy).Apply(c)
+	MaxConcurrentDownloads(cy).Apply(c)
+}
+
+// MaxConcurrentUploads is the number of simultaneous requests that will be issued for CAS upload
+// operations. It overrides CASConcurrency for uploads if applied after it.
+type MaxConcurrentUploads int
+
+// Apply sets the maximum number of concurrent uploads on a client.
+func (m MaxConcurrentUploads) Apply(c *Client) {
+	c.uploadConcurrency = int64(m)
+	c.casUploaders = semaphore.NewWeighted(c.uploadConcurrency)
+	c.resetTransferSchedulers()
+}
+
+// MaxConcurrentDownloads is the number of simultaneous requests that will be issued for CAS
+// download operations. It overrides CASConcurrency for downloads if applied after it.
+type MaxConcurrentDownloads int
+
+// Apply sets the maximum number of concurrent downloads on a client.
+func (m MaxConcurrentDownloads) Apply(c *Client) {
+	c.downloadConcurrency = int64(m)
+	c.casDownloaders = semaphore.NewWeighted(c.downloadConcurrency)
+	c.resetTransferSchedulers()
+}
+
+// MaxConcurrentRPCs is the number of calls the client makes through CallWithTimeout at the same
+// time, across all services, on top of the limits of CAS transfers. Each message sent or received
+// on a stream, such as a ByteStream chunk, is a call. Execute and WaitExecution streams only count
+// while they are being opened, since they stay open for as long as the action runs and holding the
+// limit meanwhile could starve the calls the action waits on. Zero, the default, means no limit.
+type MaxConcurrentRPCs int
+
+// Apply sets the maximum number of concurrent RPCs on a client.
+func (m MaxConcurrentRPCs) Apply(c *Client) {
+	c.rpcLimiter = nil
+	if m > 0 {
+		c.rpcLimiter = semaphore.NewWeighted(int64(m))
+	}
 }
 
 // StartupCapabilities controls whether the client should attempt to fetch the remote
@@ -499,6 +805,15 @@ type DialParams struct {
 	// ActAsAccount is the service account to act as when making RPC calls.
 	ActAsAccount string
 
+	// NetrcFile is a .netrc file whose entry for the host of the service holds the login and
+	// password to authenticate with, using basic authentication, for servers that do not use
+	// OAuth. It is used instead of CredFile, UseApplicationDefault and UseComputeEngine.
+	NetrcFile string
+
+	// Headers are static headers attached to every RPC, such as API keys. They are sent in
+	// addition to the credentials of the other parameters; use NoAuth to send only them.
+	Headers map[string]string
+
 	// NoSecurity is true if there is no security: no credentials are configured
 	// (NoAuth is implied) and grpc.WithInsecure() is passed in. Should only be
 	// used in test code.
@@ -522,6 +837,10 @@ type DialParams struct {
 	// DialOpts defines the set of gRPC DialOptions to apply, in addition to any used internally.
 	DialOpts []grpc.DialOption
 
+	// UserAgent is the user agent of the tool embedding the SDK, such as "mytool/1.2". The SDK's
+	// own, with its version, is appended to it.
+	UserAgent string
+
 	// MaxConcurrentRequests specifies the maximum number of concurrent RPCs on a single connection.
 	MaxConcurrentRequests uint32
 
@@ -596,7 +915,8 @@ func createTLSConfig(params DialParams) (*tls.Config, error) {
 func Dial(ctx context.Context, endpoint string, params DialParams) (*grpc.ClientConn, AuthType, error) {
 	var authUsed AuthType
 
-	var opts []grpc.DialOption
+	// The user agent comes first, so that DialOpts may override it.
+	opts := []grpc.DialOption{grpc.WithUserAgent(version.UserAgent(params.UserAgent))}
 	opts = append(opts, params.DialOpts...)
 
 	if params.MaxConcurrentRequests == 0 {
@@ -628,6 +948,26 @@ func Dial(ctx context.Context, endpoint string, params DialParams) (*grpc.Client
 			return nil, authUsed, fmt.Errorf("could not create TLS config: %v", err)
 		}
 		opts = append(opts, grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))
+	} else if params.NetrcFile != "" {
+		authUsed = NetrcAuth
+		netrcFile := params.NetrcFile
+		if strings.Contains(netrcFile, HomeDirMacro) {
+			usr, err := user.Current()
+			if err != nil {
+				return nil, authUsed, fmt.Errorf("could not fetch home directory because of error determining current user: %v", err)
+			}
+			netrcFile = strings.Replace(netrcFile, HomeDirMacro, usr.HomeDir, -1 /* no limit */)
+		}
+		rpcCreds, err := netrcCreds(netrcFile, endpoint)
+		if err != nil {
+			return nil, authUsed, fmt.Errorf("couldn't create RPC creds from %s: %v", netrcFile, err)
+		}
+		opts = append(opts, grpc.WithPerRPCCredentials(rpcCreds))
+		tlsConfig, err := createTLSConfig(params)
+		if err != nil {
+			return nil, authUsed, fmt.Errorf("could not create TLS config: %v", err)
+		}
+		opts = append(opts, grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))
 	} else {
 		credFile := params.CredFile
 		if strings.Contains(credFile, HomeDirMacro) {
@@ -661,6 +1001,9 @@ func Dial(ctx context.Context, endpoint string, params DialParams) (*grpc.Client
 		}
 		opts = append(opts, grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))
 	}
+	if len(params.Headers) > 0 {
+		opts = append(opts, grpc.WithPerRPCCredentials(newHeaderCreds(params.Headers, !params.NoSecurity)))
+	}
 	grpcInt := createGRPCInterceptor(params)
 	opts = append(opts, grpc.WithDisableServiceConfig())
 	opts = append(opts, grpc.WithDefaultServiceConfig(fmt.Sprintf(`{"loadBalancingConfig": [{"%s":{}}]}`, balancer.Name)))
@@ -739,13 +1082,17 @@ func NewClientFromConnection(ctx context.Context, instanceName string, conn, cas
 		RegularMode:                   DefaultRegularMode,
 		useBatchOps:                   true,
 		StartupCapabilities:           true,
+		fallbackCaps:                  DefaultFallbackCapabilities(),
 		LegacyExecRootRelativeOutputs: false,
 		casConcurrency:                DefaultCASConcurrency,
+		uploadConcurrency:             DefaultCASConcurrency,
+		downloadConcurrency:           DefaultCASConcurrency,
 		casUploaders:                  semaphore.NewWeighted(DefaultCASConcurrency),
 		casDownloaders:                semaphore.NewWeighted(DefaultCASConcurrency),
 		casUploads:                    make(map[digest.Digest]*uploadState),
 		UnifiedUploadTickDuration:     DefaultUnifiedUploadTickDuration,
 		UnifiedUploadBufferSize:       DefaultUnifiedUploadBufferSize,
+		UploadQueueSize:               DefaultUploadQueueSize,
 		UnifiedDownloadTickDuration:   DefaultUnifiedDownloadTickDuration,
 		UnifiedDownloadBufferSize:     DefaultUnifiedDownloadBufferSize,
 		Retrier:                       RetryTransient(),
@@ -753,6 +1100,10 @@ func NewClientFromConnection(ctx context.Context, instanceName string, conn, cas
 	for _, o := range opts {
 		o.Apply(client)
 	}
+	var err error
+	if client.digestFn, err = digest.NewFunction(client.digestFnValue); err != nil {
+		return nil, err
+	}
 	if client.StartupCapabilities {
 		if err := client.CheckCapabilities(ctx); err != nil {
 			return nil, statusWrap(err)
@@ -761,12 +1112,20 @@ func NewClientFromConnection(ctx context.Context, instanceName string, conn, cas
 	if client.casConcurrency < 1 {
 		return nil, fmt.Errorf("CASConcurrency should be at least 1")
 	}
+	if client.uploadConcurrency < 1 {
+		return nil, fmt.Errorf("MaxConcurrentUploads should be at least 1")
+	}
+	if client.downloadConcurrency < 1 {
+		return nil, fmt.Errorf("MaxConcurrentDownloads should be at least 1")
+	}
 	client.RunBackgroundTasks(ctx)
 	return client, nil
 }
 
 // RunBackgroundTasks starts background goroutines for the client.
 func (c *Client) RunBackgroundTasks(ctx context.Context) {
+	// The background processors only serve requests of operations that are already registered.
+	ctx = context.WithValue(ctx, opKey{c}, true)
 	if c.UnifiedUploads {
 		c.uploadOnce.Do(func() {
 			c.casUploadRequests = make(chan *uploadRequest, c.UnifiedUploadBufferSize)
@@ -839,6 +1198,28 @@ func (c *Client) RPCOpts() []grpc.CallOption {
 //
 // This method is logically "protected" and is intended for use by extensions of Client.
 func (c *Client) CallWithTimeout(ctx context.Context, rpcName string, f func(ctx context.Context) error) error {
+	release, err := c.acquireRPC(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+	return c.callWithTimeout(ctx, rpcName, f)
+}
+
+// acquireRPC waits for a call to be allowed under MaxConcurrentRPCs, and returns the function
+// ending the call.
+func (c *Client) acquireRPC(ctx context.Context) (func(), error) {
+	if c.rpcLimiter == nil {
+		return func() {}, nil
+	}
+	if err := c.rpcLimiter.Acquire(ctx, 1); err != nil {
+		return nil, err
+	}
+	return func() { c.rpcLimiter.Release(1) }, nil
+}
+
+// callWithTimeout is CallWithTimeout without the limit on concurrent calls.
+func (c *Client) callWithTimeout(ctx context.Context, rpcName string, f func(ctx context.Context) error) error {
 	timeout, ok := c.rpcTimeouts[rpcName]
 	if !ok {
 		if timeout, ok = c.rpcTimeouts["default"]; !ok {
@@ -889,7 +1270,7 @@ func RetryTransient() *Retrier {
 // GetActionResult wraps the underlying call with specific client options.
 func (c *Client) GetActionResult(ctx context.Context, req *repb.GetActionResultRequest) (res *repb.ActionResult, err error) {
 	opts := c.RPCOpts()
-	err = c.Retrier.Do(ctx, func() (e error) {
+	err = c.retryRPC(ctx, func() (e error) {
 		return c.CallWithTimeout(ctx, "GetActionResult", func(ctx context.Context) (e error) {
 			res, e = c.actionCache.GetActionResult(ctx, req, opts...)
 			return e
@@ -904,7 +1285,7 @@ func (c *Client) GetActionResult(ctx context.Context, req *repb.GetActionResultR
 // UpdateActionResult wraps the underlying call with specific client options.
 func (c *Client) UpdateActionResult(ctx context.Context, req *repb.UpdateActionResultRequest) (res *repb.ActionResult, err error) {
 	opts := c.RPCOpts()
-	err = c.Retrier.Do(ctx, func() (e error) {
+	err = c.retryRPC(ctx, func() (e error) {
 		return c.CallWithTimeout(ctx, "UpdateActionResult", func(ctx context.Context) (e error) {
 			res, e = c.actionCache.UpdateActionResult(ctx, req, opts...)
 			return e
@@ -935,7 +1316,7 @@ func (c *Client) Write(ctx context.Context) (res bsgrpc.ByteStream_WriteClient,
 // QueryWriteStatus wraps the underlying call with specific client options.
 func (c *Client) QueryWriteStatus(ctx context.Context, req *bspb.QueryWriteStatusRequest) (res *bspb.QueryWriteStatusResponse, err error) {
 	opts := c.RPCOpts()
-	err = c.Retrier.Do(ctx, func() (e error) {
+	err = c.retryRPC(ctx, func() (e error) {
 		return c.CallWithTimeout(ctx, "QueryWriteStatus", func(ctx context.Context) (e error) {
 			res, e = c.byteStream.QueryWriteStatus(ctx, req, opts...)
 			return e
@@ -950,7 +1331,7 @@ func (c *Client) QueryWriteStatus(ctx context.Context, req *bspb.QueryWriteStatu
 // FindMissingBlobs wraps the underlying call with specific client options.
 func (c *Client) FindMissingBlobs(ctx context.Context, req *repb.FindMissingBlobsRequest) (res *repb.FindMissingBlobsResponse, err error) {
 	opts := c.RPCOpts()
-	err = c.Retrier.Do(ctx, func() (e error) {
+	err = c.retryRPC(ctx, func() (e error) {
 		return c.CallWithTimeout(ctx, "FindMissingBlobs", func(ctx context.Context) (e error) {
 			res, e = c.cas.FindMissingBlobs(ctx, req, opts...)
 			return e
@@ -967,7 +1348,7 @@ func (c *Client) FindMissingBlobs(ctx context.Context, req *repb.FindMissingBlob
 // to use BatchWriteBlobs() instead.
 func (c *Client) BatchUpdateBlobs(ctx context.Context, req *repb.BatchUpdateBlobsRequest) (res *repb.BatchUpdateBlobsResponse, err error) {
 	opts := c.RPCOpts()
-	err = c.Retrier.Do(ctx, func() (e error) {
+	err = c.retryRPC(ctx, func() (e error) {
 		return c.CallWithTimeout(ctx, "BatchUpdateBlobs", func(ctx context.Context) (e error) {
 			res, e = c.cas.BatchUpdateBlobs(ctx, req, opts...)
 			return e
@@ -984,7 +1365,7 @@ func (c *Client) BatchUpdateBlobs(ctx context.Context, req *repb.BatchUpdateBlob
 // It is recommended to use BatchDownloadBlobs instead.
 func (c *Client) BatchReadBlobs(ctx context.Context, req *repb.BatchReadBlobsRequest) (res *repb.BatchReadBlobsResponse, err error) {
 	opts := c.RPCOpts()
-	err = c.Retrier.Do(ctx, func() (e error) {
+	err = c.retryRPC(ctx, func() (e error) {
 		return c.CallWithTimeout(ctx, "BatchReadBlobs", func(ctx context.Context) (e error) {
 			res, e = c.cas.BatchReadBlobs(ctx, req, opts...)
 			return e
@@ -1024,7 +1405,7 @@ func (c *Client) WaitExecution(ctx context.Context, req *repb.WaitExecutionReque
 // (either the main connection or the CAS connection).
 func (c *Client) GetBackendCapabilities(ctx context.Context, conn *grpc.ClientConn, req *repb.GetCapabilitiesRequest) (res *repb.ServerCapabilities, err error) {
 	opts := c.RPCOpts()
-	err = c.Retrier.Do(ctx, func() (e error) {
+	err = c.retryRPC(ctx, func() (e error) {
 		return c.CallWithTimeout(ctx, "GetCapabilities", func(ctx context.Context) (e error) {
 			res, e = regrpc.NewCapabilitiesClient(conn).GetCapabilities(ctx, req, opts...)
 			return e
@@ -1039,7 +1420,7 @@ func (c *Client) GetBackendCapabilities(ctx context.Context, conn *grpc.ClientCo
 // GetOperation wraps the underlying call with specific client options.
 func (c *Client) GetOperation(ctx context.Context, req *oppb.GetOperationRequest) (res *oppb.Operation, err error) {
 	opts := c.RPCOpts()
-	err = c.Retrier.Do(ctx, func() (e error) {
+	err = c.retryRPC(ctx, func() (e error) {
 		return c.CallWithTimeout(ctx, "GetOperation", func(ctx context.Context) (e error) {
 			res, e = c.operations.GetOperation(ctx, req, opts...)
 			return e
@@ -1054,7 +1435,7 @@ func (c *Client) GetOperation(ctx context.Context, req *oppb.GetOperationRequest
 // ListOperations wraps the underlying call with specific client options.
 func (c *Client) ListOperations(ctx context.Context, req *oppb.ListOperationsRequest) (res *oppb.ListOperationsResponse, err error) {
 	opts := c.RPCOpts()
-	err = c.Retrier.Do(ctx, func() (e error) {
+	err = c.retryRPC(ctx, func() (e error) {
 		return c.CallWithTimeout(ctx, "ListOperations", func(ctx context.Context) (e error) {
 			res, e = c.operations.ListOperations(ctx, req, opts...)
 			return e
@@ -1069,7 +1450,7 @@ func (c *Client) ListOperations(ctx context.Context, req *oppb.ListOperationsReq
 // CancelOperation wraps the underlying call with specific client options.
 func (c *Client) CancelOperation(ctx context.Context, req *oppb.CancelOperationRequest) (res *emptypb.Empty, err error) {
 	opts := c.RPCOpts()
-	err = c.Retrier.Do(ctx, func() (e error) {
+	err = c.retryRPC(ctx, func() (e error) {
 		return c.CallWithTimeout(ctx, "CancelOperation", func(ctx context.Context) (e error) {
 			res, e = c.operations.CancelOperation(ctx, req, opts...)
 			return e
@@ -1084,7 +1465,7 @@ func (c *Client) CancelOperation(ctx context.Context, req *oppb.CancelOperationR
 // DeleteOperation wraps the underlying call with specific client options.
 func (c *Client) DeleteOperation(ctx context.Context, req *oppb.DeleteOperationRequest) (res *emptypb.Empty, err error) {
 	opts := c.RPCOpts()
-	err = c.Retrier.Do(ctx, func() (e error) {
+	err = c.retryRPC(ctx, func() (e error) {
 		return c.CallWithTimeout(ctx, "DeleteOperation", func(ctx context.Context) (e error) {
 			res, e = c.operations.DeleteOperation(ctx, req, opts...)
 			return e
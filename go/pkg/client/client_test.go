@@ -6,11 +6,23 @@ import (
 	"net"
 	"os"
 	"path"
+	"reflect"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/command"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/digest"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/filemetadata"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/retry"
 	repb "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
 	svpb "github.com/bazelbuild/remote-apis/build/bazel/semver"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
 )
 
 const (
@@ -204,6 +216,68 @@ func TestNewClient(t *testing.T) {
 	defer c.Close()
 }
 
+func TestDialHeaders(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	l, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatalf("Cannot listen: %v", err)
+	}
+	mdCh := make(chan metadata.MD, 1)
+	srv := grpc.NewServer(grpc.UnknownServiceHandler(func(_ any, stream grpc.ServerStream) error {
+		md, _ := metadata.FromIncomingContext(stream.Context())
+		mdCh <- md
+		return status.Error(codes.Unimplemented, "")
+	}))
+	go srv.Serve(l)
+	defer srv.Stop()
+
+	conn, _, err := Dial(ctx, l.Addr().String(), DialParams{NoSecurity: true, Headers: map[string]string{"X-API-Key": "secret"}, UserAgent: "mytool/1.2"})
+	if err != nil {
+		t.Fatalf("Dial() failed: %v", err)
+	}
+	defer conn.Close()
+	conn.Invoke(ctx, "/test.Service/Method", &emptypb.Empty{}, &emptypb.Empty{})
+	md := <-mdCh
+	if got := md.Get("x-api-key"); len(got) != 1 || got[0] != "secret" {
+		t.Errorf("Dial() with Headers sent x-api-key %v, want [secret]", got)
+	}
+	if got := md.Get("user-agent"); len(got) != 1 || !strings.HasPrefix(got[0], "mytool/1.2 remote-apis-sdks/") {
+		t.Errorf("Dial() with UserAgent sent user-agent %v, want it to start with the tool's and the SDK's", got)
+	}
+}
+
+func TestParseNetrc(t *testing.T) {
+	t.Parallel()
+	const netrc = `machine other.example.com login other password otherpw
+macdef init
+  machine remote.example.com login macro password macropw
+
+machine remote.example.com
+  login user
+  password pw
+default login anon password anonpw
+`
+	tests := []struct {
+		endpoint            string
+		wantLogin, wantPass string
+	}{
+		{"remote.example.com:443", "user", "pw"},
+		{"dns:///remote.example.com:443", "user", "pw"},
+		{"other.example.com", "other", "otherpw"},
+		{"unknown.example.com:443", "anon", "anonpw"},
+	}
+	for _, tc := range tests {
+		login, pass, ok := parseNetrc(netrc, endpointHost(tc.endpoint))
+		if !ok || login != tc.wantLogin || pass != tc.wantPass {
+			t.Errorf("parseNetrc(_, %q) = %q, %q, %v, want %q, %q, true", tc.endpoint, login, pass, ok, tc.wantLogin, tc.wantPass)
+		}
+	}
+	if _, _, ok := parseNetrc("machine a login b password c", "d"); ok {
+		t.Errorf("parseNetrc() without a matching entry = ok, want not found")
+	}
+}
+
 func TestNewClientFromConnection(t *testing.T) {
 	t.Parallel()
 	ctx := context.Background()
@@ -233,6 +307,218 @@ func TestNewClientFromConnection(t *testing.T) {
 	}
 }
 
+func TestNewClientCapabilitiesUnimplemented(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	l, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatalf("Cannot listen: %v", err)
+	}
+	defer l.Close()
+	// The server does not register the Capabilities service, so GetCapabilities is unimplemented.
+	server := grpc.NewServer()
+	go server.Serve(l)
+	defer server.Stop()
+	dialParams := DialParams{
+		Service:    l.Addr().String(),
+		NoSecurity: true,
+	}
+
+	c, err := NewClient(ctx, instance, dialParams, MaxBatchSize(1000))
+	if err != nil {
+		t.Fatalf("NewClient() failed: %v", err)
+	}
+	defer c.Close()
+	if c.MaxBatchSize != 1000 {
+		t.Errorf("MaxBatchSize = %d, want 1000", c.MaxBatchSize)
+	}
+	if c.SupportsCommandOutputPaths() {
+		t.Errorf("SupportsCommandOutputPaths() = true, want false with the default fallback")
+	}
+
+	fallback := &repb.ServerCapabilities{
+		HighApiVersion: &svpb.SemVer{Major: 2, Minor: 1},
+		CacheCapabilities: &repb.CacheCapabilities{
+			DigestFunctions:        []repb.DigestFunction_Value{digest.GetDigestFunction()},
+			MaxBatchTotalSizeBytes: 2000,
+		},
+	}
+	c2, err := NewClient(ctx, instance, dialParams, FallbackCapabilities{fallback})
+	if err != nil {
+		t.Fatalf("NewClient() failed: %v", err)
+	}
+	defer c2.Close()
+	if c2.MaxBatchSize != 2000 {
+		t.Errorf("MaxBatchSize = %d, want 2000", c2.MaxBatchSize)
+	}
+	if !c2.SupportsCommandOutputPaths() {
+		t.Errorf("SupportsCommandOutputPaths() = false, want true with the configured fallback")
+	}
+
+	if _, err := NewClient(ctx, instance, dialParams, FallbackCapabilities{}); err == nil {
+		t.Errorf("NewClient() with the fallback disabled succeeded, want error")
+	}
+}
+
+func TestNegotiateCompression(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	l, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatalf("Cannot listen: %v", err)
+	}
+	defer l.Close()
+	server := grpc.NewServer()
+	go server.Serve(l)
+	defer server.Stop()
+	dialParams := DialParams{
+		Service:    l.Addr().String(),
+		NoSecurity: true,
+	}
+	// The server does not implement GetCapabilities, and the fallback has no compressors.
+	fallback := FallbackCapabilities{&repb.ServerCapabilities{
+		CacheCapabilities: &repb.CacheCapabilities{DigestFunctions: []repb.DigestFunction_Value{digest.GetDigestFunction()}},
+	}}
+
+	if _, err := NewClient(ctx, instance, dialParams, fallback, CompressedBytestreamThreshold(0)); err == nil {
+		t.Errorf("NewClient() with compression and a server without compression succeeded, want error")
+	}
+	c, err := NewClient(ctx, instance, dialParams, fallback, CompressedBytestreamThreshold(0), NegotiateCompression(true))
+	if err != nil {
+		t.Fatalf("NewClient() with NegotiateCompression failed: %v", err)
+	}
+	defer c.Close()
+	if c.CompressedBytestreamThreshold != -1 {
+		t.Errorf("CompressedBytestreamThreshold = %d, want -1 after negotiation", c.CompressedBytestreamThreshold)
+	}
+}
+
+func TestDigestFunctionPerClient(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	l, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatalf("Cannot listen: %v", err)
+	}
+	defer l.Close()
+	server := grpc.NewServer()
+	go server.Serve(l)
+	defer server.Stop()
+	dialParams := DialParams{
+		Service:    l.Addr().String(),
+		NoSecurity: true,
+	}
+	cacheCaps := &repb.CacheCapabilities{DigestFunctions: []repb.DigestFunction_Value{repb.DigestFunction_SHA256, repb.DigestFunction_SHA1}}
+	sha1Server := FallbackCapabilities{&repb.ServerCapabilities{
+		ExecutionCapabilities: &repb.ExecutionCapabilities{DigestFunction: repb.DigestFunction_SHA1},
+		CacheCapabilities:     cacheCaps,
+	}}
+	sha256Server := FallbackCapabilities{&repb.ServerCapabilities{CacheCapabilities: cacheCaps}}
+
+	if _, err := NewClient(ctx, instance, dialParams, sha1Server); err == nil {
+		t.Errorf("NewClient() with a server requiring SHA1 succeeded, want error")
+	}
+	c1, err := NewClient(ctx, instance, dialParams, sha1Server, NegotiateDigestFunction(true))
+	if err != nil {
+		t.Fatalf("NewClient() with NegotiateDigestFunction failed: %v", err)
+	}
+	defer c1.Close()
+	c2, err := NewClient(ctx, instance, dialParams, sha256Server)
+	if err != nil {
+		t.Fatalf("NewClient() failed: %v", err)
+	}
+	defer c2.Close()
+	c3, err := NewClient(ctx, instance, dialParams, sha256Server, DigestFunction(repb.DigestFunction_SHA1))
+	if err != nil {
+		t.Fatalf("NewClient() with DigestFunction(SHA1) failed: %v", err)
+	}
+	defer c3.Close()
+	if _, err := NewClient(ctx, instance, dialParams, sha256Server, DigestFunction(repb.DigestFunction_VSO)); err == nil {
+		t.Errorf("NewClient() with DigestFunction(VSO) succeeded, want error")
+	}
+
+	for _, tc := range []struct {
+		name string
+		c    *Client
+		want repb.DigestFunction_Value
+	}{
+		{name: "negotiated", c: c1, want: repb.DigestFunction_SHA1},
+		{name: "default", c: c2, want: repb.DigestFunction_SHA256},
+		{name: "configured", c: c3, want: repb.DigestFunction_SHA1},
+	} {
+		if got := tc.c.DigestFunction().Value(); got != tc.want {
+			t.Errorf("%s: DigestFunction() = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+	if got := digest.GetDigestFunction(); got != repb.DigestFunction_SHA256 {
+		t.Errorf("GetDigestFunction() = %v, want SHA256: clients must not change the default", got)
+	}
+
+	// Both clients digest the same input with their own digest function, through a shared cache.
+	execRoot := t.TempDir()
+	if err := os.WriteFile(path.Join(execRoot, "foo"), []byte("foo"), 0644); err != nil {
+		t.Fatalf("os.WriteFile() failed: %v", err)
+	}
+	fmc := filemetadata.NewLRUCache(0)
+	is := &command.InputSpec{Inputs: []string{"foo"}}
+	for _, c := range []*Client{c1, c2, c1} {
+		fn := c.DigestFunction()
+		_, inputs, _, err := c.ComputeMerkleTree(ctx, execRoot, "", "", is, fmc)
+		if err != nil {
+			t.Fatalf("ComputeMerkleTree() with %v failed: %v", fn, err)
+		}
+		want := fn.NewFromBlob([]byte("foo"))
+		found := false
+		for _, ue := range inputs {
+			if err := fn.Validate(ue.Digest); err != nil {
+				t.Errorf("ComputeMerkleTree() with %v gave input %v: %v", fn, ue.Digest, err)
+			}
+			found = found || ue.Digest == want
+		}
+		if !found {
+			t.Errorf("ComputeMerkleTree() with %v gave inputs without the file digest %v", fn, want)
+		}
+	}
+}
+
+func TestMakeQueryBatches(t *testing.T) {
+	ctx := context.Background()
+	var dgs []digest.Digest
+	for i := 0; i < 10; i++ {
+		dgs = append(dgs, digest.NewFromBlob([]byte{byte(i)}))
+	}
+	// Each digest of a 1-byte blob takes 70 bytes in the request, on top of 12 bytes for the
+	// instance name and the digest function.
+	tests := []struct {
+		name       string
+		maxDigests int
+		maxSize    int
+		want       []int
+	}{
+		{name: "digest limit", maxDigests: 4, maxSize: DefaultMaxBatchSize, want: []int{4, 4, 2}},
+		{name: "size limit", maxDigests: 100, maxSize: 12 + 3*70, want: []int{3, 3, 3, 1}},
+		{name: "both limits", maxDigests: 2, maxSize: 12 + 3*70, want: []int{2, 2, 2, 2, 2}},
+		{name: "single oversized digest", maxDigests: 100, maxSize: 10, want: []int{1, 1, 1, 1, 1, 1, 1, 1, 1, 1}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			c := &Client{InstanceName: "instance", MaxQueryBatchDigests: MaxQueryBatchDigests(tc.maxDigests), MaxBatchSize: MaxBatchSize(tc.maxSize)}
+			var got []int
+			var all []digest.Digest
+			for _, b := range c.makeQueryBatches(ctx, dgs) {
+				got = append(got, len(b))
+				all = append(all, b...)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("makeQueryBatches() gave batches of sizes %v, want %v", got, tc.want)
+			}
+			if !reflect.DeepEqual(all, dgs) {
+				t.Errorf("makeQueryBatches() gave digests %v, want %v", all, dgs)
+			}
+		})
+	}
+}
+
 func TestResourceName(t *testing.T) {
 	t.Parallel()
 
@@ -283,3 +569,172 @@ func TestResourceName(t *testing.T) {
 		})
 	}
 }
+
+func TestShutdown(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	c, err := NewClient(ctx, instance, DialParams{
+		Service:    "server",
+		NoSecurity: true,
+	}, StartupCapabilities(false))
+	if err != nil {
+		t.Fatalf("Error creating client: %v", err)
+	}
+
+	opCtx, done, err := c.beginOp(ctx)
+	if err != nil {
+		t.Fatalf("beginOp() failed: %v", err)
+	}
+	shutdownErr := make(chan error, 1)
+	go func() { shutdownErr <- c.Shutdown(ctx) }()
+
+	// Wait until the client stops accepting new operations.
+	for {
+		c.opMu.Lock()
+		shuttingDown := c.shuttingDown
+		c.opMu.Unlock()
+		if shuttingDown {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if _, _, err := c.beginOp(ctx); !errors.Is(err, ErrShutdown) {
+		t.Errorf("beginOp() after Shutdown gave error %v, want %v", err, ErrShutdown)
+	}
+	if retry.TransientOnly(ErrShutdown) || status.Code(ErrShutdown) != codes.FailedPrecondition {
+		t.Errorf("ErrShutdown has code %v, want a non-retriable %v", status.Code(ErrShutdown), codes.FailedPrecondition)
+	}
+	if _, err := c.WriteBlob(ctx, []byte("foo")); !errors.Is(err, ErrShutdown) {
+		t.Errorf("WriteBlob() after Shutdown gave error %v, want %v", err, ErrShutdown)
+	}
+	// Nested calls of the operation in progress are still accepted.
+	if _, nestedDone, err := c.beginOp(opCtx); err != nil {
+		t.Errorf("beginOp() within an operation in progress failed: %v", err)
+	} else {
+		nestedDone()
+	}
+	select {
+	case err := <-shutdownErr:
+		t.Fatalf("Shutdown() returned %v before the operation in progress was done", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	done()
+	if err := <-shutdownErr; err != nil {
+		t.Errorf("Shutdown() failed: %v", err)
+	}
+}
+
+func TestShutdownSharedContext(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	newClient := func() *Client {
+		t.Helper()
+		c, err := NewClient(ctx, instance, DialParams{
+			Service:    "server",
+			NoSecurity: true,
+		}, StartupCapabilities(false))
+		if err != nil {
+			t.Fatalf("Error creating client: %v", err)
+		}
+		return c
+	}
+	a, b := newClient(), newClient()
+	defer a.Close()
+	opCtx, done, err := a.beginOp(ctx)
+	if err != nil {
+		t.Fatalf("beginOp() failed: %v", err)
+	}
+	defer done()
+	// An operation of another client does not count as one of b.
+	_, bDone, err := b.beginOp(opCtx)
+	if err != nil {
+		t.Fatalf("beginOp() failed: %v", err)
+	}
+	defer bDone()
+	sCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if err := b.Shutdown(sCtx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Shutdown() gave error %v, want %v as it waits for the operation", err, context.DeadlineExceeded)
+	}
+}
+
+func TestShutdownDeadline(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	c, err := NewClient(ctx, instance, DialParams{
+		Service:    "server",
+		NoSecurity: true,
+	}, StartupCapabilities(false))
+	if err != nil {
+		t.Fatalf("Error creating client: %v", err)
+	}
+	_, done, err := c.beginOp(ctx)
+	if err != nil {
+		t.Fatalf("beginOp() failed: %v", err)
+	}
+	defer done()
+	sCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if err := c.Shutdown(sCtx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Shutdown() gave error %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestResumeDetectionCutsBackoffShort(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	c, err := NewClient(ctx, instance, DialParams{
+		Service:    "server",
+		NoSecurity: true,
+	}, StartupCapabilities(false), &ResumeDetection{Interval: time.Hour}, &Retrier{
+		Backoff:     retry.ExponentialBackoff(time.Hour, time.Hour, retry.Attempts(2)),
+		ShouldRetry: retry.Always,
+	})
+	if err != nil {
+		t.Fatalf("Error creating client: %v", err)
+	}
+	defer c.Close()
+
+	attempts := make(chan struct{}, 2)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- c.retryRPC(ctx, func() error {
+			attempts <- struct{}{}
+			return status.Error(codes.Unavailable, "network is down")
+		})
+	}()
+	<-attempts
+	w := c.resumeWatcher
+	// The network did not change.
+	w.check(0, w.lastNet)
+	select {
+	case <-attempts:
+		t.Fatalf("retryRPC() retried without a resume event")
+	case <-time.After(50 * time.Millisecond):
+	}
+	w.check(2*time.Hour, w.lastNet)
+	select {
+	case <-attempts:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("retryRPC() did not retry after a resume event")
+	}
+	if err := <-errc; status.Code(err) != codes.Unavailable {
+		t.Errorf("retryRPC() gave error %v, want %v", err, codes.Unavailable)
+	}
+}
+
+func TestMissingCacheSweepsExpired(t *testing.T) {
+	c := &Client{}
+	MissingBlobsCacheTTL(time.Millisecond).Apply(c)
+	m := c.missingBlobs
+	var dgs []digest.Digest
+	for i := 0; i < minMissingCacheSweep; i++ {
+		dgs = append(dgs, digest.NewFromBlob([]byte(strconv.Itoa(i))))
+	}
+	m.add(dgs[:minMissingCacheSweep/2])
+	time.Sleep(10 * time.Millisecond)
+	m.add(dgs[minMissingCacheSweep/2:])
+	if got := len(m.expires); got != minMissingCacheSweep/2 {
+		t.Errorf("missing cache has %d entries after the first half expired, want %d", got, minMissingCacheSweep/2)
+	}
+}
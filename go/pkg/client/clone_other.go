@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package client
+
+import (
+	"errors"
+	"os"
+)
+
+// cloneFile is not supported on this platform.
+func cloneFile(src, dst string, perm os.FileMode) error {
+	return errors.New("cloning files is not supported on this platform")
+}
@@ -0,0 +1,372 @@
+package client
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/command"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/digest"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/uploadinfo"
+
+	cpb "github.com/bazelbuild/remote-apis-sdks/go/api/command"
+)
+
+// DirectoryCache caches the Directory protos of input directories across ComputeMerkleTree calls,
+// so that consecutive actions sharing large immutable subtrees, such as SDKs or node_modules, do
+// not read, digest and encode them again. Entries are keyed by the absolute path of the directory,
+// and are only stored if the cache was not invalidated while they were computed.
+//
+// Entries are validated against the state of the directories and files of their subtree, as
+// reported by lstat, when they are used: an entry is dropped if any of them was added, removed or
+// modified since it was read. Invalidating the cache along with the file metadata cache, e.g. by
+// using filemetadata.WithInvalidationHook(fmc, dc.Invalidate) as the cache of ComputeMerkleTree and
+// of the downloads, drops the entries early. Directories with node properties or virtual inputs or
+// materialized symlinks under them are not cached, nor are trees built with a TreeSpillDir or
+// following the targets of preserved symlinks.
+type DirectoryCache struct {
+	mu      sync.Mutex
+	entries map[string]*dirCacheEntry
+	// children indexes the cached directories by parent: it maps the absolute path of every
+	// directory containing cached directories to the paths of its subdirectories that are cached
+	// or contain cached directories, so that invalidations only visit the affected entries.
+	children map[string]map[string]bool
+	// lookups are the lookups in progress, which are told about invalidations.
+	lookups map[*dirCacheLookup]bool
+}
+
+type dirCacheEntry struct {
+	// opts are the options the directory was packed with.
+	opts string
+	dir  *cachedDir
+}
+
+// cachedDir is a packed input directory.
+type cachedDir struct {
+	dir     *uploadinfo.Entry
+	files   []*uploadinfo.Entry
+	subdirs []*cachedDir
+	// stats are the stats of the whole subtree.
+	stats TreeStats
+	// stamps are the states of the directory and its files when they were read, by absolute path.
+	stamps map[string]os.FileInfo
+}
+
+// valid returns whether the directories and files of the subtree are in the state they were read
+// in.
+func (cd *cachedDir) valid() bool {
+	for path, fi := range cd.stamps {
+		cur, err := os.Lstat(path)
+		if err != nil || !sameState(fi, cur) {
+			return false
+		}
+	}
+	for _, sub := range cd.subdirs {
+		if !sub.valid() {
+			return false
+		}
+	}
+	return true
+}
+
+func sameState(a, b os.FileInfo) bool {
+	return os.SameFile(a, b) && a.ModTime().Equal(b.ModTime()) && a.Size() == b.Size() && a.Mode() == b.Mode()
+}
+
+// NewDirectoryCache returns an empty DirectoryCache.
+func NewDirectoryCache() *DirectoryCache {
+	return &DirectoryCache{
+		entries:  make(map[string]*dirCacheEntry),
+		children: make(map[string]map[string]bool),
+		lookups:  make(map[*dirCacheLookup]bool),
+	}
+}
+
+// Apply sets the client's DirectoryCache.
+func (dc *DirectoryCache) Apply(c *Client) {
+	c.DirectoryCache = dc
+}
+
+// Invalidate deletes the entries of the file or directory at the absolute path, of the directories
+// containing it and of everything under it. An empty path deletes all the entries, so that it can
+// be used as a filemetadata.InvalidationHook.
+func (dc *DirectoryCache) Invalidate(path string) {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	if path != "" {
+		path = filepath.Clean(path)
+	}
+	for l := range dc.lookups {
+		l.invalidated = append(l.invalidated, path)
+	}
+	if path == "" {
+		dc.entries = make(map[string]*dirCacheEntry)
+		dc.children = make(map[string]map[string]bool)
+		return
+	}
+	for p := filepath.Dir(path); ; p = filepath.Dir(p) {
+		delete(dc.entries, p)
+		if filepath.Dir(p) == p {
+			break
+		}
+	}
+	dc.removeTree(path)
+	// Unlink the path from the directories containing it, up to one still leading to other entries.
+	for p := path; filepath.Dir(p) != p; p = filepath.Dir(p) {
+		parent := filepath.Dir(p)
+		delete(dc.children[parent], p)
+		if len(dc.children[parent]) > 0 {
+			break
+		}
+		delete(dc.children, parent)
+	}
+}
+
+// add stores the entry of the directory at the absolute path, and indexes it under the directories
+// containing it. It must be called with mu held.
+func (dc *DirectoryCache) add(abs string, e *dirCacheEntry) {
+	dc.entries[abs] = e
+	for p := abs; filepath.Dir(p) != p; p = filepath.Dir(p) {
+		parent := filepath.Dir(p)
+		kids := dc.children[parent]
+		if kids == nil {
+			kids = make(map[string]bool)
+			dc.children[parent] = kids
+		}
+		if kids[p] {
+			break
+		}
+		kids[p] = true
+	}
+}
+
+// removeTree deletes the entries of the directory at the absolute path and of everything under it.
+// It must be called with mu held.
+func (dc *DirectoryCache) removeTree(abs string) {
+	delete(dc.entries, abs)
+	for kid := range dc.children[abs] {
+		dc.removeTree(kid)
+	}
+	delete(dc.children, abs)
+}
+
+// Len returns the number of cached directories.
+func (dc *DirectoryCache) Len() int {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	return len(dc.entries)
+}
+
+// dirCacheLookup is the use of a DirectoryCache by a ComputeMerkleTree call.
+type dirCacheLookup struct {
+	dc   *DirectoryCache
+	opts string
+	// invalidated are the absolute paths invalidated since the lookup started, guarded by dc.mu. An
+	// empty path invalidates everything.
+	invalidated []string
+	// ineligible holds the remote paths of the directories with node properties, virtual inputs or
+	// materialized symlinks under them.
+	ineligible map[string]bool
+	// walked maps the remote paths of the directories read whole to their absolute paths.
+	walked map[string]string
+	// packed holds the packed directories that were read whole, by absolute path.
+	packed map[string]*cachedDir
+	// stamps holds the states of the directories read whole and of their files when they were
+	// read, by absolute path.
+	stamps map[string]os.FileInfo
+}
+
+// newLookup returns the lookup for a tree of execRoot built with the given options, whose virtual inputs are already in fs, or
+// nil if the tree cannot use the cache. The lookup must be ended with done.
+func (dc *DirectoryCache) newLookup(execRoot string, opts *TreeSymlinkOpts, preserveEmpty bool, excl []*command.InputExclusion, fs map[string]*fileSysNode, nodeProperties map[string]*cpb.NodeProperties) *dirCacheLookup {
+	if dc == nil || opts.Preserved && opts.FollowsTarget {
+		return nil
+	}
+	key := fmt.Sprintf("%s|%+v|%t", execRoot, *opts, preserveEmpty)
+	for _, e := range excl {
+		key += fmt.Sprintf("|%v:%s", e.Type, e.Pattern())
+	}
+	l := &dirCacheLookup{
+		dc:         dc,
+		opts:       key,
+		ineligible: make(map[string]bool),
+		walked:     make(map[string]string),
+		packed:     make(map[string]*cachedDir),
+		stamps:     make(map[string]os.FileInfo),
+	}
+	for p := range fs {
+		l.exclude(p)
+	}
+	for p := range nodeProperties {
+		l.exclude(filepath.Clean(p))
+	}
+	dc.mu.Lock()
+	dc.lookups[l] = true
+	dc.mu.Unlock()
+	return l
+}
+
+// done ends the lookup.
+func (l *dirCacheLookup) done() {
+	if l == nil {
+		return
+	}
+	l.dc.mu.Lock()
+	defer l.dc.mu.Unlock()
+	delete(l.dc.lookups, l)
+}
+
+// exclude makes the directory at the remote path, and the directories containing it, ineligible.
+func (l *dirCacheLookup) exclude(remote string) {
+	if l == nil {
+		return
+	}
+	for p := remote; p != "." && p != string(filepath.Separator); p = filepath.Dir(p) {
+		l.ineligible[p] = true
+		delete(l.walked, p)
+	}
+	l.ineligible["."] = true
+	delete(l.walked, ".")
+}
+
+// has returns whether the directory at the absolute path is cached, so that it need not be read
+// ahead.
+func (l *dirCacheLookup) has(abs string) bool {
+	if l == nil {
+		return false
+	}
+	l.dc.mu.Lock()
+	defer l.dc.mu.Unlock()
+	e, ok := l.dc.entries[abs]
+	return ok && e.opts == l.opts
+}
+
+// get returns the cached directory at the absolute path, if there is one usable at the remote
+// path. Entries whose subtree changed since it was read are deleted.
+func (l *dirCacheLookup) get(remote, abs string) *cachedDir {
+	if l == nil || l.ineligible[remote] {
+		return nil
+	}
+	l.dc.mu.Lock()
+	e, ok := l.dc.entries[abs]
+	l.dc.mu.Unlock()
+	if !ok || e.opts != l.opts {
+		return nil
+	}
+	if e.dir.valid() {
+		return e.dir
+	}
+	l.dc.mu.Lock()
+	defer l.dc.mu.Unlock()
+	if l.dc.entries[abs] == e {
+		delete(l.dc.entries, abs)
+	}
+	return nil
+}
+
+// walk records that the directory at the absolute path is read whole, at the remote path.
+func (l *dirCacheLookup) walk(remote, abs string) {
+	if l == nil || l.ineligible[remote] {
+		return
+	}
+	if prev, ok := l.walked[remote]; ok && prev != abs {
+		l.exclude(remote)
+		return
+	}
+	l.walked[remote] = abs
+}
+
+// stamp records the state of the directory at the absolute path before it was read.
+func (l *dirCacheLookup) stamp(abs string, fi os.FileInfo) {
+	if l == nil || fi == nil {
+		return
+	}
+	l.stamps[abs] = fi
+}
+
+// stampFile records the state of the file at the remote and absolute paths, whose metadata was
+// computed for the given modification time. Files modified since are not recorded, so that the
+// directory containing them is not cached.
+func (l *dirCacheLookup) stampFile(remote, abs string, mtime time.Time) {
+	if l == nil {
+		return
+	}
+	if _, ok := l.walked[filepath.Dir(remote)]; !ok {
+		return
+	}
+	if fi, err := os.Lstat(abs); err == nil && fi.ModTime().Equal(mtime) {
+		l.stamps[abs] = fi
+	}
+}
+
+// addStamp adds the recorded state of the absolute path to the packed directory, and returns
+// whether there was one.
+func (l *dirCacheLookup) addStamp(cd *cachedDir, abs string) bool {
+	fi, ok := l.stamps[abs]
+	if ok {
+		cd.stamps[abs] = fi
+	}
+	return ok
+}
+
+// store adds the packed directories to the cache, except those that paths invalidated since the
+// lookup started are in or under.
+func (l *dirCacheLookup) store() {
+	if l == nil || len(l.packed) == 0 {
+		return
+	}
+	l.dc.mu.Lock()
+	defer l.dc.mu.Unlock()
+	for abs, cd := range l.packed {
+		if !l.isInvalidated(abs) {
+			l.dc.add(abs, &dirCacheEntry{opts: l.opts, dir: cd})
+		}
+	}
+}
+
+// isInvalidated returns whether the directory at the absolute path was invalidated since the
+// lookup started, through itself, a directory containing it or a path under it. It must be called
+// with dc.mu held.
+func (l *dirCacheLookup) isInvalidated(abs string) bool {
+	for _, p := range l.invalidated {
+		if p == "" || p == abs || isUnder(abs, p) || isUnder(p, abs) {
+			return true
+		}
+	}
+	return false
+}
+
+// isUnder returns whether the absolute path is strictly under the directory dir.
+func isUnder(path, dir string) bool {
+	return strings.HasPrefix(path, strings.TrimSuffix(dir, string(filepath.Separator))+string(filepath.Separator))
+}
+
+// addBlobs adds the blobs of the subtree to blobs.
+func (cd *cachedDir) addBlobs(blobs map[digest.Digest]*uploadinfo.Entry) {
+	blobs[cd.dir.Digest] = cd.dir
+	for _, ue := range cd.files {
+		blobs[ue.Digest] = ue
+	}
+	for _, sub := range cd.subdirs {
+		sub.addBlobs(blobs)
+	}
+}
+
+func (s *TreeStats) add(o *TreeStats) {
+	s.InputFiles += o.InputFiles
+	s.InputDirectories += o.InputDirectories
+	s.InputSymlinks += o.InputSymlinks
+	s.TotalInputBytes += o.TotalInputBytes
+}
+
+func (s *TreeStats) sub(o *TreeStats) TreeStats {
+	return TreeStats{
+		InputFiles:       s.InputFiles - o.InputFiles,
+		InputDirectories: s.InputDirectories - o.InputDirectories,
+		InputSymlinks:    s.InputSymlinks - o.InputSymlinks,
+		TotalInputBytes:  s.TotalInputBytes - o.TotalInputBytes,
+	}
+}
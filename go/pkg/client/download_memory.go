@@ -0,0 +1,29 @@
+package client
+
+import "golang.org/x/sync/semaphore"
+
+// DownloadMemoryBudget is the maximum number of bytes of blobs that downloads of files hold in
+// memory at once. Batches of blobs that would go over it are streamed to their files one blob at a
+// time instead, so that downloading many or large outputs does not exhaust memory. Non-positive
+// values, the default, mean no limit.
+type DownloadMemoryBudget int64
+
+// Apply sets the download memory budget of a client.
+func (b DownloadMemoryBudget) Apply(c *Client) {
+	c.downloadMemory = nil
+	if b > 0 {
+		c.downloadMemory = semaphore.NewWeighted(int64(b))
+	}
+}
+
+// reserveDownloadMemory reserves size bytes of the download memory budget, and returns the
+// function releasing them, or false if the budget is exhausted.
+func (c *Client) reserveDownloadMemory(size int64) (func(), bool) {
+	if c.downloadMemory == nil {
+		return func() {}, true
+	}
+	if !c.downloadMemory.TryAcquire(size) {
+		return nil, false
+	}
+	return func() { c.downloadMemory.Release(size) }, true
+}
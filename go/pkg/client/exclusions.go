@@ -0,0 +1,105 @@
+package client
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/command"
+)
+
+// inputExcluder matches inputs against the InputExclusions of a spec, compiled once per tree rather
+// than for every input.
+type inputExcluder struct {
+	// execRoot is the root of the inputs, which glob rules are matched relative to.
+	execRoot string
+	rules    []*exclusionRule
+	// skipped is the number of inputs excluded by skip.
+	skipped int
+}
+
+type exclusionRule struct {
+	re *regexp.Regexp
+	// glob is whether the rule is a glob, which is matched against slash-separated paths relative
+	// to the exec root. Regex rules are matched against absolute paths.
+	glob bool
+	typ  command.InputType
+}
+
+// newInputExcluder compiles the exclusions of the inputs of execRoot. It returns nil if there are
+// none.
+func newInputExcluder(execRoot string, excl []*command.InputExclusion) (*inputExcluder, error) {
+	if len(excl) == 0 {
+		return nil, nil
+	}
+	ex := &inputExcluder{execRoot: execRoot}
+	for _, e := range excl {
+		re, err := regexp.Compile(e.Pattern())
+		if err != nil {
+			return nil, fmt.Errorf("invalid input exclusion %v: %w", e, err)
+		}
+		ex.rules = append(ex.rules, &exclusionRule{re: re, glob: e.Glob != "", typ: e.Type})
+	}
+	return ex, nil
+}
+
+// excludes returns whether the input at the absolute path, of the given type, is excluded. It is
+// safe for concurrent use.
+func (ex *inputExcluder) excludes(path string, t command.InputType) bool {
+	if ex == nil {
+		return false
+	}
+	// rel is computed on the first glob rule that applies.
+	var rel string
+	relDone, relOK := false, false
+	for _, r := range ex.rules {
+		if r.typ != command.UnspecifiedInputType && r.typ != t {
+			continue
+		}
+		p := path
+		if r.glob {
+			if !relDone {
+				rel, relOK = ex.relPath(path)
+				relDone = true
+			}
+			if !relOK {
+				continue
+			}
+			p = rel
+		}
+		if r.re.MatchString(p) {
+			return true
+		}
+	}
+	return false
+}
+
+// relPath returns the slash-separated path of the input relative to the exec root, or false if it
+// is not under the exec root. Globs must not match the directories above the exec root, e.g. a
+// "build/**" glob with an exec root under a directory named build.
+func (ex *inputExcluder) relPath(path string) (string, bool) {
+	rel, err := filepath.Rel(ex.execRoot, path)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return filepath.ToSlash(rel), true
+}
+
+// skip is like excludes, but counts the excluded inputs. Excluded directories are not descended
+// into, so they count once.
+func (ex *inputExcluder) skip(path string, t command.InputType) bool {
+	if !ex.excludes(path, t) {
+		return false
+	}
+	ex.skipped++
+	return true
+}
+
+// count returns the number of inputs excluded by skip.
+func (ex *inputExcluder) count() int {
+	if ex == nil {
+		return 0
+	}
+	return ex.skipped
+}
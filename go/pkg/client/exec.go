@@ -23,6 +23,10 @@ import (
 
 const (
 	containerImagePropertyName = "container-image"
+
+	// cancelOperationTimeout bounds the CancelOperation call made for an execution abandoned by the
+	// caller.
+	cancelOperationTimeout = 10 * time.Second
 )
 
 // Action encodes the full details of an action to be sent to the remote execution service for
@@ -56,6 +60,9 @@ type Action struct {
 	// SkipCache, if true, indicates that this action should be executed even if there is a copy of
 	// its result in the action cache that could be used instead.
 	SkipCache bool
+	// Salt, if set, is mixed into the Action digest so that actions with different salts do not
+	// share cached results.
+	Salt []byte
 }
 
 // ExecuteAction performs all of the steps necessary to execute an action, including checking the
@@ -99,18 +106,7 @@ func (c *Client) ExecuteAction(ctx context.Context, ac *Action) (*repb.ActionRes
 
 // CheckActionCache queries remote action cache, returning an ActionResult or nil if it doesn't exist.
 func (c *Client) CheckActionCache(ctx context.Context, acDg *repb.Digest) (*repb.ActionResult, error) {
-	res, err := c.GetActionResult(ctx, &repb.GetActionResultRequest{
-		InstanceName: c.InstanceName,
-		ActionDigest: acDg,
-	})
-	switch st, _ := status.FromError(err); st.Code() {
-	case codes.OK:
-		return res, nil
-	case codes.NotFound:
-		return nil, nil
-	default:
-		return nil, gerrors.WithMessage(err, "checking the action cache")
-	}
+	return c.CheckActionCacheInline(ctx, acDg, nil)
 }
 
 func (c *Client) executeJob(ctx context.Context, skipCache bool, acDg *repb.Digest) (*repb.ActionResult, error) {
@@ -118,6 +114,7 @@ func (c *Client) executeJob(ctx context.Context, skipCache bool, acDg *repb.Dige
 		InstanceName:    c.InstanceName,
 		SkipCacheLookup: skipCache,
 		ActionDigest:    acDg,
+		DigestFunction:  c.digestFn.Value(),
 	}
 	op, err := c.ExecuteAndWait(ctx, execReq)
 	if err != nil {
@@ -155,6 +152,7 @@ func (c *Client) PrepAction(ctx context.Context, ac *Action) (*repb.Digest, *rep
 		CommandDigest:   comDg.ToProto(),
 		InputRootDigest: ac.InputRoot.ToProto(),
 		DoNotCache:      ac.DoNotCache,
+		Salt:            ac.Salt,
 	}
 	// Only set timeout if it's non-zero, because Timeout needs to be nil for the server to use a
 	// default.
@@ -166,7 +164,7 @@ func (c *Client) PrepAction(ctx context.Context, ac *Action) (*repb.Digest, *rep
 	if err != nil {
 		return nil, nil, gerrors.WithMessage(err, "marshalling Action proto")
 	}
-	acDg := digest.NewFromBlob(acBlob).ToProto()
+	acDg := c.digestFn.NewFromBlob(acBlob).ToProto()
 
 	// If the result is cacheable, check if it's already in the cache.
 	if !ac.DoNotCache || !ac.SkipCache {
@@ -234,17 +232,30 @@ func (c *Client) ExecuteAndWait(ctx context.Context, req *repb.ExecuteRequest) (
 // the completed operation or an error.
 // The supplied callback function is called for each message received to update the state of
 // the remote action.
+// If ctx is cancelled before the execution completes, the remote operation is cancelled as well.
 func (c *Client) ExecuteAndWaitProgress(ctx context.Context, req *repb.ExecuteRequest, progress func(metadata *repb.ExecuteOperationMetadata)) (op *oppb.Operation, err error) {
+	ctx, done, err := c.beginOp(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
 	wait := false    // Should we retry by calling WaitExecution instead of Execute?
 	opError := false // Are we propagating an Operation status as an error for the retrier's benefit?
 	lastOp := &oppb.Operation{}
 	closure := func(ctx context.Context) (e error) {
+		// Only opening the stream counts towards MaxConcurrentRPCs, as the stream stays open until the
+		// action completes.
+		release, e := c.acquireRPC(ctx)
+		if e != nil {
+			return e
+		}
 		var res regrpc.Execution_ExecuteClient
 		if wait {
 			res, e = c.WaitExecution(ctx, &repb.WaitExecutionRequest{Name: lastOp.Name})
 		} else {
 			res, e = c.Execute(ctx, req)
 		}
+		release()
 		if e != nil {
 			return e
 		}
@@ -275,7 +286,12 @@ func (c *Client) ExecuteAndWaitProgress(ctx context.Context, req *repb.ExecuteRe
 		}
 		return nil
 	}
-	err = c.Retrier.Do(ctx, func() error { return c.CallWithTimeout(ctx, "Execute", closure) })
+	err = c.retryRPC(ctx, func() error { return c.callWithTimeout(ctx, "Execute", closure) })
+	if err != nil && errors.Is(ctx.Err(), context.Canceled) && lastOp.Name != "" && !lastOp.Done {
+		// Dropping the stream does not stop the execution, which would keep a worker busy with an
+		// action nobody waits for anymore.
+		c.cancelExecution(ctx, lastOp.Name)
+	}
 	if err != nil && !opError {
 		if st, ok := status.FromError(err); ok {
 			err = StatusDetailedError(st)
@@ -294,6 +310,29 @@ func (c *Client) ExecuteAndWaitProgress(ctx context.Context, req *repb.ExecuteRe
 	return lastOp, nil
 }
 
+// cancelExecution cancels the remote operation of an execution abandoned by the caller of ctx.
+// Failures are only logged: the server eventually cleans up the operation anyway.
+func (c *Client) cancelExecution(ctx context.Context, name string) {
+	ctx, cancel := context.WithTimeout(detachedContext{ctx}, cancelOperationTimeout)
+	defer cancel()
+	if _, err := c.CancelOperation(ctx, &oppb.CancelOperationRequest{Name: name}); err != nil {
+		log.Warningf("Failed to cancel abandoned operation %s: %v", name, err)
+		return
+	}
+	log.V(1).Infof("Cancelled abandoned operation %s", name)
+}
+
+// detachedContext keeps the values of a context, such as the request metadata, but not its
+// cancellation or deadline.
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool)         { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}               { return nil }
+func (detachedContext) Err() error                          { return nil }
+func (d detachedContext) Value(key interface{}) interface{} { return d.parent.Value(key) }
+
 // OperationStatus returns an operation error status, if it is present, and nil otherwise.
 func OperationStatus(op *oppb.Operation) *status.Status {
 	var r *oppb.Operation_Response
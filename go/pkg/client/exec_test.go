@@ -1,10 +1,19 @@
 package client_test
 
 import (
+	"context"
+	"net"
 	"testing"
+	"time"
 
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/client"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/digest"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 
+	// Redundant imports are required for the google3 mirror. Aliases should not be changed.
+	regrpc "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
 	repb "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
 	oppb "google.golang.org/genproto/googleapis/longrunning"
 	spb "google.golang.org/genproto/googleapis/rpc/status"
@@ -67,3 +76,61 @@ func TestOperationStatus(t *testing.T) {
 		})
 	}
 }
+
+// blockingExecServer keeps Execute streams open until release is closed.
+type blockingExecServer struct {
+	regrpc.UnimplementedExecutionServer
+	started chan struct{}
+	release chan struct{}
+}
+
+func (s *blockingExecServer) Execute(req *repb.ExecuteRequest, stream regrpc.Execution_ExecuteServer) error {
+	close(s.started)
+	<-s.release
+	resp, err := anypb.New(&repb.ExecuteResponse{Result: &repb.ActionResult{}})
+	if err != nil {
+		return err
+	}
+	return stream.Send(&oppb.Operation{Name: "op", Done: true, Result: &oppb.Operation_Response{Response: resp}})
+}
+
+func TestExecuteDoesNotHoldRPCLimit(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	listener, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatalf("Cannot listen: %v", err)
+	}
+	defer listener.Close()
+	server := grpc.NewServer()
+	fake := &blockingExecServer{started: make(chan struct{}), release: make(chan struct{})}
+	regrpc.RegisterExecutionServer(server, fake)
+	go server.Serve(listener)
+	defer server.Stop()
+	c, err := client.NewClient(ctx, instance, client.DialParams{
+		Service:    listener.Addr().String(),
+		NoSecurity: true,
+	}, client.StartupCapabilities(false), client.MaxConcurrentRPCs(1))
+	if err != nil {
+		t.Fatalf("Error connecting to server: %v", err)
+	}
+	defer c.Close()
+
+	execErr := make(chan error, 1)
+	go func() {
+		_, err := c.ExecuteAndWait(ctx, &repb.ExecuteRequest{InstanceName: instance, ActionDigest: digest.Empty.ToProto()})
+		execErr <- err
+	}()
+	<-fake.started
+	// The action cache is not served, but the call must be made while the execution is running.
+	callCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	_, err = c.GetActionResult(callCtx, &repb.GetActionResultRequest{InstanceName: instance, ActionDigest: digest.Empty.ToProto()})
+	if status.Code(err) != codes.Unimplemented {
+		t.Errorf("GetActionResult() during an execution = %v, want Unimplemented", err)
+	}
+	close(fake.release)
+	if err := <-execErr; err != nil {
+		t.Errorf("ExecuteAndWait() failed: %v", err)
+	}
+}
@@ -0,0 +1,52 @@
+package client
+
+import (
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/cache"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/digest"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/filemetadata"
+)
+
+// fileDigestCache serves the metadata of files from the underlying cache, which digests them with
+// the default digest function, with the digests of the files recomputed with the digest function
+// of the client. The recomputed digests are kept by the default digest, which identifies the
+// contents of the file, so that every file contents is only digested once more.
+type fileDigestCache struct {
+	filemetadata.Cache
+	fn      digest.Function
+	digests *cache.SingleFlight
+}
+
+// Get returns the metadata of the file at path.
+func (c *fileDigestCache) Get(path string) *filemetadata.Metadata {
+	md := c.Cache.Get(path)
+	if md.Err != nil || md.IsDirectory {
+		return md
+	}
+	val, err := c.digests.LoadOrStore(md.Digest, func() (interface{}, error) {
+		return c.fn.NewFromFile(path)
+	})
+	res := *md
+	if err != nil {
+		res.Err = &filemetadata.FileError{Err: err}
+		return &res
+	}
+	res.Digest = val.(digest.Digest)
+	return &res
+}
+
+// Update deletes the entry of the file from the underlying cache instead, since the metadata has
+// the digest of the client rather than the default one. The file is digested again on the next
+// Get.
+func (c *fileDigestCache) Update(path string, _ *filemetadata.Metadata) error {
+	return c.Cache.Delete(path)
+}
+
+// FileMetadataCache returns the view of fmc the client uses, in which files are digested with the
+// digest function of the client if it is not the default one. Metadata computed by the client,
+// such as that of downloaded outputs, must be stored through it.
+func (c *Client) FileMetadataCache(fmc filemetadata.Cache) filemetadata.Cache {
+	if c.digestFn.Value() == digest.GetDigestFunction() {
+		return fmc
+	}
+	return &fileDigestCache{Cache: fmc, fn: c.digestFn, digests: &c.fileDigests}
+}
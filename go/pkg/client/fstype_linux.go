@@ -0,0 +1,64 @@
+//go:build linux
+// +build linux
+
+package client
+
+import "syscall"
+
+// networkFSTypes are the statfs magic numbers of network file systems.
+var networkFSTypes = map[uint32]bool{
+	0x6969:     true, // NFS
+	0x517b:     true, // SMB
+	0xff534d42: true, // CIFS
+	0xfe534d42: true, // SMB2
+	0x564c:     true, // NCP
+	0x00c36400: true, // Ceph
+	0x0bd00bd0: true, // Lustre
+	0x47504653: true, // GPFS
+	0x65735546: true, // FUSE, e.g. sshfs or gcsfuse
+}
+
+// virtualFSTypes are the statfs magic numbers of virtual file systems.
+var virtualFSTypes = map[uint32]bool{
+	0x9fa0:     true, // proc
+	0x62656572: true, // sysfs
+	0x1cd1:     true, // devpts
+	0x64626720: true, // debugfs
+	0x74726163: true, // tracefs
+	0x73636673: true, // securityfs
+	0x27e0eb:   true, // cgroup
+	0x63677270: true, // cgroup2
+	0x6165676c: true, // pstore
+	0xcafe4a11: true, // bpf
+	0x62656570: true, // configfs
+	0x65735543: true, // fusectl
+	0x19800202: true, // mqueue
+	0x42494e4d: true, // binfmt_misc
+}
+
+// isNetworkFS returns whether path is on a network file system.
+func isNetworkFS(path string) bool {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return false
+	}
+	return networkFSTypes[uint32(st.Type)]
+}
+
+// isVirtualFS returns whether path is on a virtual file system.
+func isVirtualFS(path string) bool {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return false
+	}
+	return virtualFSTypes[uint32(st.Type)]
+}
+
+// deviceID returns the ID of the device containing path.
+func deviceID(path string) (uint64, bool) {
+	var st syscall.Stat_t
+	if err := syscall.Stat(path, &st); err != nil {
+		return 0, false
+	}
+	return uint64(st.Dev), true
+}
@@ -0,0 +1,21 @@
+//go:build !linux
+// +build !linux
+
+package client
+
+// File system types and devices are only detected on Linux.
+
+// isNetworkFS returns whether path is on a network file system.
+func isNetworkFS(path string) bool {
+	return false
+}
+
+// isVirtualFS returns whether path is on a virtual file system.
+func isVirtualFS(path string) bool {
+	return false
+}
+
+// deviceID returns the ID of the device containing path.
+func deviceID(path string) (uint64, bool) {
+	return 0, false
+}
@@ -0,0 +1,123 @@
+package client
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"google.golang.org/grpc/credentials"
+)
+
+// headerCreds are per-RPC credentials that attach a fixed set of headers to every RPC, such as
+// an API key or basic authentication.
+type headerCreds struct {
+	md     map[string]string
+	secure bool
+}
+
+func newHeaderCreds(headers map[string]string, secure bool) *headerCreds {
+	md := make(map[string]string, len(headers))
+	for k, v := range headers {
+		// HTTP/2 header names are lowercase.
+		md[strings.ToLower(k)] = v
+	}
+	return &headerCreds{md: md, secure: secure}
+}
+
+// GetRequestMetadata returns the headers.
+func (h *headerCreds) GetRequestMetadata(context.Context, ...string) (map[string]string, error) {
+	return h.md, nil
+}
+
+// RequireTransportSecurity returns whether the headers may only be sent over TLS.
+func (h *headerCreds) RequireTransportSecurity() bool {
+	return h.secure
+}
+
+// netrcCreds returns per-RPC credentials authenticating with the login and password of the
+// entry of the netrc file at path for the host of endpoint, using basic authentication.
+func netrcCreds(path, endpoint string) (credentials.PerRPCCredentials, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	host := endpointHost(endpoint)
+	login, password, ok := parseNetrc(string(data), host)
+	if !ok {
+		return nil, fmt.Errorf("no entry for %s in %s", host, path)
+	}
+	auth := base64.StdEncoding.EncodeToString([]byte(login + ":" + password))
+	return newHeaderCreds(map[string]string{"authorization": "Basic " + auth}, true), nil
+}
+
+// endpointHost returns the host of a gRPC endpoint, such as "dns:///host:443".
+func endpointHost(endpoint string) string {
+	if i := strings.Index(endpoint, "://"); i >= 0 {
+		endpoint = strings.TrimLeft(endpoint[i+len("://"):], "/")
+	}
+	if host, _, err := net.SplitHostPort(endpoint); err == nil {
+		return host
+	}
+	return endpoint
+}
+
+// parseNetrc returns the login and password of the netrc entry for host, or of the default entry
+// if there is none for host.
+func parseNetrc(data, host string) (login, password string, ok bool) {
+	type entry struct{ login, password string }
+	var (
+		matched, def *entry
+		cur          *entry
+	)
+	lines := strings.Split(data, "\n")
+	for i := 0; i < len(lines); i++ {
+		fields := strings.Fields(lines[i])
+		for j := 0; j < len(fields); j++ {
+			next := func() string {
+				if j+1 < len(fields) {
+					j++
+					return fields[j]
+				}
+				return ""
+			}
+			switch fields[j] {
+			case "machine":
+				cur = &entry{}
+				if next() == host && matched == nil {
+					matched = cur
+				}
+			case "default":
+				cur = &entry{}
+				if def == nil {
+					def = cur
+				}
+			case "login":
+				if v := next(); cur != nil {
+					cur.login = v
+				}
+			case "password":
+				if v := next(); cur != nil {
+					cur.password = v
+				}
+			case "account":
+				next()
+			case "macdef":
+				// Macro definitions run until the next empty line.
+				for i+1 < len(lines) && strings.TrimSpace(lines[i+1]) != "" {
+					i++
+				}
+				j = len(fields)
+			}
+		}
+	}
+	if matched == nil {
+		matched = def
+	}
+	if matched == nil {
+		return "", "", false
+	}
+	return matched.login, matched.password, true
+}
@@ -0,0 +1,105 @@
+package client
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/digest"
+	gerrors "github.com/pkg/errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	repb "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+)
+
+// InlineOutputFiles selects the output files whose contents are requested inline in
+// GetActionResult replies, so that cache hits for small outputs, such as depfiles or stamp files,
+// complete without any CAS reads. The server may decline to inline any file, in which case it is
+// read from the CAS as usual.
+type InlineOutputFiles struct {
+	// Patterns are the filepath.Match patterns of the output file paths, relative to the working
+	// directory, to request inline. A pattern without a path separator is matched against the base
+	// name of the path as well, so that "*.d" matches all depfiles.
+	Patterns []string
+	// MaxBytes, if positive, is the total size of the inlined contents kept from a reply. The
+	// contents of the files beyond it are dropped, and the files are read from the CAS instead.
+	MaxBytes int64
+}
+
+// Apply sets the InlineOutputFiles of a client.
+func (o *InlineOutputFiles) Apply(c *Client) {
+	c.InlineOutputFiles = o
+}
+
+// matches returns whether the output file path should be requested inline.
+func (o *InlineOutputFiles) matches(path string) bool {
+	for _, p := range o.Patterns {
+		if ok, _ := filepath.Match(p, path); ok {
+			return true
+		}
+		if !strings.Contains(p, "/") {
+			if ok, _ := filepath.Match(p, filepath.Base(path)); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// trim drops the inlined contents of ar beyond the size budget.
+func (o *InlineOutputFiles) trim(ar *repb.ActionResult) {
+	if o.MaxBytes <= 0 {
+		return
+	}
+	var total int64
+	for _, f := range ar.OutputFiles {
+		if len(f.Contents) == 0 {
+			continue
+		}
+		if total+int64(len(f.Contents)) > o.MaxBytes {
+			f.Contents = nil
+			continue
+		}
+		total += int64(len(f.Contents))
+	}
+}
+
+// CheckActionCacheInline is like CheckActionCache, but also requests the contents of the given
+// output files that match the client's InlineOutputFiles to be inlined in the result. The paths
+// are relative to the working directory, as in the Command.
+func (c *Client) CheckActionCacheInline(ctx context.Context, acDg *repb.Digest, outputFiles []string) (*repb.ActionResult, error) {
+	req := &repb.GetActionResultRequest{
+		InstanceName:   c.InstanceName,
+		ActionDigest:   acDg,
+		DigestFunction: c.digestFn.Value(),
+	}
+	if c.InlineOutputFiles != nil {
+		for _, path := range outputFiles {
+			if c.InlineOutputFiles.matches(path) {
+				req.InlineOutputFiles = append(req.InlineOutputFiles, path)
+			}
+		}
+	}
+	res, err := c.GetActionResult(ctx, req)
+	switch st, _ := status.FromError(err); st.Code() {
+	case codes.OK:
+		if len(req.InlineOutputFiles) > 0 {
+			c.InlineOutputFiles.trim(res)
+		}
+		return res, nil
+	case codes.NotFound:
+		return nil, nil
+	default:
+		return nil, gerrors.WithMessage(err, "checking the action cache")
+	}
+}
+
+// inlined returns whether the output has valid inlined contents, with the digest function fn,
+// which need not be read from the CAS.
+func (out *TreeOutput) inlined(fn digest.Function) bool {
+	if len(out.Contents) == 0 || int64(len(out.Contents)) != out.Digest.Size {
+		return false
+	}
+	return fn.NewFromBlob(out.Contents) == out.Digest
+}
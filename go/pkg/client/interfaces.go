@@ -0,0 +1,79 @@
+package client
+
+import (
+	"context"
+	"io"
+
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/digest"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/filemetadata"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/uploadinfo"
+	"google.golang.org/protobuf/proto"
+
+	repb "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	oppb "google.golang.org/genproto/googleapis/longrunning"
+)
+
+// The interfaces below cover the operations of Client that integrations typically use, so that
+// code depending on the client can take the narrowest one it needs and be unit tested with a mock
+// of it rather than with fake servers. Client implements all of them.
+
+// Execution executes actions remotely.
+type Execution interface {
+	// ExecuteAndWait executes an action remotely and waits for its completion.
+	ExecuteAndWait(ctx context.Context, req *repb.ExecuteRequest) (*oppb.Operation, error)
+	// ExecuteAndWaitProgress is like ExecuteAndWait, and reports the progress of the execution.
+	ExecuteAndWaitProgress(ctx context.Context, req *repb.ExecuteRequest, progress func(metadata *repb.ExecuteOperationMetadata)) (*oppb.Operation, error)
+}
+
+// CASUploader uploads blobs to the CAS.
+type CASUploader interface {
+	// MissingBlobs returns the digests that are not in the CAS.
+	MissingBlobs(ctx context.Context, digests []digest.Digest) ([]digest.Digest, error)
+	// UploadIfMissing uploads the entries that are not in the CAS, and returns the digests of the
+	// missing ones and the number of bytes moved.
+	UploadIfMissing(ctx context.Context, entries ...*uploadinfo.Entry) ([]digest.Digest, int64, error)
+	// WriteBlob uploads a blob and returns its digest.
+	WriteBlob(ctx context.Context, blob []byte) (digest.Digest, error)
+	// WriteProto uploads a serialized proto and returns its digest.
+	WriteProto(ctx context.Context, msg proto.Message) (digest.Digest, error)
+	// WriteBlobs uploads the blobs.
+	WriteBlobs(ctx context.Context, blobs map[digest.Digest][]byte) error
+}
+
+// CASDownloader downloads blobs and action outputs from the CAS.
+type CASDownloader interface {
+	// ReadBlob returns the contents of a blob.
+	ReadBlob(ctx context.Context, d digest.Digest) ([]byte, *MovedBytesMetadata, error)
+	// ReadProto reads a blob and unmarshals it into msg.
+	ReadProto(ctx context.Context, d digest.Digest, msg proto.Message) (*MovedBytesMetadata, error)
+	// ReadBlobTo streams the contents of a blob into w.
+	ReadBlobTo(ctx context.Context, d digest.Digest, w io.Writer) (*MovedBytesMetadata, error)
+	// ReadBlobToFile writes the contents of a blob to the file at fpath.
+	ReadBlobToFile(ctx context.Context, d digest.Digest, fpath string) (*MovedBytesMetadata, error)
+	// DownloadDirectory downloads the directory with the given digest to outDir.
+	DownloadDirectory(ctx context.Context, d digest.Digest, outDir string, cache filemetadata.Cache) (map[string]*TreeOutput, *MovedBytesMetadata, error)
+	// DownloadOutputs downloads the outputs, whose paths are relative to outDir.
+	DownloadOutputs(ctx context.Context, outs map[string]*TreeOutput, outDir string, cache filemetadata.Cache) (*MovedBytesMetadata, error)
+	// DownloadActionOutputs downloads the outputs of the action result to outDir.
+	DownloadActionOutputs(ctx context.Context, resPb *repb.ActionResult, outDir string, cache filemetadata.Cache) (*MovedBytesMetadata, error)
+	// FlattenActionOutputs returns the outputs of the action result by their paths.
+	FlattenActionOutputs(ctx context.Context, ar *repb.ActionResult) (map[string]*TreeOutput, error)
+}
+
+// ActionCache looks up and stores action results.
+type ActionCache interface {
+	// CheckActionCache returns the cached result of the action, or nil if there is none.
+	CheckActionCache(ctx context.Context, acDg *repb.Digest) (*repb.ActionResult, error)
+	// UpdateActionResult stores the result of an action.
+	UpdateActionResult(ctx context.Context, req *repb.UpdateActionResultRequest) (*repb.ActionResult, error)
+}
+
+// Interface is the union of the execution, CAS and action cache operations of Client.
+type Interface interface {
+	Execution
+	CASUploader
+	CASDownloader
+	ActionCache
+}
+
+var _ Interface = (*Client)(nil)
@@ -0,0 +1,162 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/digest"
+)
+
+// Materialization is how outputs are made from the blobs of a LocalCAS.
+type Materialization int
+
+const (
+	// HardlinkMaterialization hard links outputs to the blobs, so that outputs take no disk space
+	// of their own. Modifying an output in place modifies the blob, so it is best used with
+	// ReadOnlyOutputs. Outputs are copied where hard links fail, e.g. across file systems.
+	//
+	// Hard links share the modification time of their blob, which is that of its download. Since
+	// setting the time of one output would set it for the blob and all other outputs linked to it,
+	// outputs are cloned or copied instead when the client sets output modification times with
+	// RestoreOutputMtimes or OutputMtime.
+	HardlinkMaterialization Materialization = iota
+	// CopyMaterialization copies the blobs to the outputs.
+	CopyMaterialization
+	// CloneMaterialization clones the blobs to the outputs, sharing their data until either is
+	// modified, on file systems with reflinks, such as Btrfs and XFS. Outputs are copied where
+	// cloning fails.
+	CloneMaterialization
+)
+
+// LocalCAS is an Opt that makes DownloadOutputs keep each downloaded blob once in a local
+// content-addressed directory, and make the output files from it, so that the outputs shared by
+// builds are not downloaded again nor, with hard links or clones, duplicated on disk. The
+// directory can be shared by concurrent processes. Nothing is ever removed from it.
+type LocalCAS struct {
+	// Dir is the directory of the blobs.
+	Dir string
+	// Materialize is how outputs are made from the blobs. Defaults to HardlinkMaterialization.
+	Materialize Materialization
+}
+
+// Apply sets the client's local CAS.
+func (l *LocalCAS) Apply(c *Client) {
+	c.LocalCAS = l
+}
+
+// blobPath returns the path of the copy of the blob of dg with the permissions perm. Copies
+// differ by permissions, since hard links to a copy share them.
+func (l *LocalCAS) blobPath(dg digest.Digest, perm os.FileMode) string {
+	return filepath.Join(l.Dir, dg.Hash[:2], fmt.Sprintf("%s-%d-%04o", dg.Hash, dg.Size, perm))
+}
+
+// materialize makes the file outputs in outDir from the blobs of the local CAS, first downloading
+// the blobs it misses.
+func (l *LocalCAS) materialize(ctx context.Context, c *Client, outDir string, outs []*TreeOutput) (*MovedBytesMetadata, error) {
+	stats := &MovedBytesMetadata{}
+	paths := make(map[*TreeOutput]string, len(outs))
+	// The missing copies by path, and one missing copy of each blob to download.
+	missing := make(map[string]*TreeOutput)
+	downloads := make(map[digest.Digest]*TreeOutput)
+	for _, out := range outs {
+		p := l.blobPath(out.Digest, c.OutputPerm(out))
+		paths[out] = p
+		stats.Requested += out.Digest.Size
+		if _, ok := missing[p]; ok {
+			stats.Cached += out.Digest.Size
+			continue
+		}
+		if _, err := os.Stat(p); err == nil {
+			stats.Cached += out.Digest.Size
+			continue
+		} else if !os.IsNotExist(err) {
+			return stats, err
+		}
+		missing[p] = out
+		if _, ok := downloads[out.Digest]; !ok {
+			downloads[out.Digest] = &TreeOutput{Digest: out.Digest, Path: filepath.Base(p), IsExecutable: out.IsExecutable}
+		} else {
+			stats.Cached += out.Digest.Size
+		}
+	}
+
+	if len(missing) > 0 {
+		// Blobs are downloaded to a temporary directory and renamed into place, so that other
+		// processes never see partial blobs.
+		if err := os.MkdirAll(l.Dir, c.DirMode); err != nil {
+			return stats, err
+		}
+		tmp, err := os.MkdirTemp(l.Dir, "tmp-")
+		if err != nil {
+			return stats, err
+		}
+		defer os.RemoveAll(tmp)
+		dlStats, err := c.DownloadFiles(ctx, tmp, downloads)
+		if dlStats != nil {
+			stats.LogicalMoved += dlStats.LogicalMoved
+			stats.RealMoved += dlStats.RealMoved
+		}
+		if err != nil {
+			return stats, err
+		}
+		// The other copies of the downloaded blobs are made before any blob is moved.
+		for p, out := range missing {
+			if src := downloads[out.Digest].Path; src != filepath.Base(p) {
+				if err := copyFile(tmp, tmp, src, filepath.Base(p), c.OutputPerm(out)); err != nil {
+					return stats, err
+				}
+			}
+		}
+		for p, out := range missing {
+			staged := filepath.Join(tmp, filepath.Base(p))
+			if err := os.Chmod(staged, c.OutputPerm(out)); err != nil {
+				return stats, err
+			}
+			if err := os.MkdirAll(filepath.Dir(p), c.DirMode); err != nil {
+				return stats, err
+			}
+			if err := os.Rename(staged, p); err != nil {
+				return stats, err
+			}
+		}
+	}
+
+	mode := l.Materialize
+	if mode == HardlinkMaterialization && c.setsOutputMtimes() {
+		mode = CloneMaterialization
+	}
+	for _, out := range outs {
+		if err := materializeFile(mode, paths[out], filepath.Join(outDir, out.Path), c.OutputPerm(out)); err != nil {
+			return stats, fmt.Errorf("failed to materialize %s: %v", out.Path, err)
+		}
+	}
+	return stats, nil
+}
+
+// materializeFile makes the file at dst from the blob at src with the given materialization and
+// permissions perm, replacing any file at dst. Like hard links, copies get exactly perm, regardless
+// of the process umask.
+func materializeFile(mode Materialization, src, dst string, perm os.FileMode) error {
+	if err := removeNonDir(dst); err != nil {
+		return err
+	}
+	switch mode {
+	case HardlinkMaterialization:
+		if os.Link(src, dst) == nil {
+			return nil
+		}
+	case CloneMaterialization:
+		if cloneFile(src, dst, perm) == nil {
+			return nil
+		}
+		if err := removeNonDir(dst); err != nil {
+			return err
+		}
+	}
+	if err := copyFile("", "", src, dst, perm); err != nil {
+		return err
+	}
+	return os.Chmod(dst, perm)
+}
@@ -0,0 +1,112 @@
+package client
+
+import (
+	"context"
+	"sync"
+
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/digest"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	repb "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+)
+
+// MemoryStore is an in-memory CAS and action cache storage backend, e.g. for tests of code built
+// on the client that do not need a server:
+//
+//	store := client.NewMemoryStore()
+//	(&client.Storage{CAS: store, ActionCache: store}).Apply(c)
+type MemoryStore struct {
+	mu      sync.Mutex
+	blobs   map[digest.Digest][]byte
+	results map[digest.Digest]*repb.ActionResult
+}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		blobs:   make(map[digest.Digest][]byte),
+		results: make(map[digest.Digest]*repb.ActionResult),
+	}
+}
+
+// FindMissing returns the digests of the blobs that are not in the store.
+func (m *MemoryStore) FindMissing(_ context.Context, dgs []digest.Digest) ([]digest.Digest, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var missing []digest.Digest
+	for _, dg := range dgs {
+		if _, ok := m.blobs[dg]; !ok {
+			missing = append(missing, dg)
+		}
+	}
+	return missing, nil
+}
+
+// Get returns the contents of a blob, or a NotFound status error if it is missing.
+func (m *MemoryStore) Get(_ context.Context, dg digest.Digest) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	blob, ok := m.blobs[dg]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "blob %v not found", dg)
+	}
+	return blob, nil
+}
+
+// Put stores a blob.
+func (m *MemoryStore) Put(_ context.Context, dg digest.Digest, blob []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.blobs[dg] = blob
+	return nil
+}
+
+// BatchGet returns the contents of the blobs in the store.
+func (m *MemoryStore) BatchGet(_ context.Context, dgs []digest.Digest) (map[digest.Digest][]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	blobs := make(map[digest.Digest][]byte)
+	for _, dg := range dgs {
+		if blob, ok := m.blobs[dg]; ok {
+			blobs[dg] = blob
+		}
+	}
+	return blobs, nil
+}
+
+// BatchPut stores the blobs.
+func (m *MemoryStore) BatchPut(_ context.Context, blobs map[digest.Digest][]byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for dg, blob := range blobs {
+		m.blobs[dg] = blob
+	}
+	return nil
+}
+
+// GetActionResult returns the stored result of an action, or a NotFound status error if there is
+// none.
+func (m *MemoryStore) GetActionResult(_ context.Context, acDg digest.Digest) (*repb.ActionResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ar, ok := m.results[acDg]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "action %v not found", acDg)
+	}
+	return proto.Clone(ar).(*repb.ActionResult), nil
+}
+
+// UpdateActionResult stores the result of an action.
+func (m *MemoryStore) UpdateActionResult(_ context.Context, acDg digest.Digest, ar *repb.ActionResult) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.results[acDg] = proto.Clone(ar).(*repb.ActionResult)
+	return nil
+}
+
+var (
+	_ BatchBlobStore   = (*MemoryStore)(nil)
+	_ ActionCacheStore = (*MemoryStore)(nil)
+)
@@ -0,0 +1,119 @@
+package client
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/digest"
+)
+
+// MissingBlobsCacheTTL is an Opt that makes MissingBlobs remember the digests it found missing
+// for this long, and return them as missing without querying the CAS again. It suits callers that
+// query the presence of the same blobs repeatedly, e.g. to decide whether to prefetch them. Blobs
+// uploaded through the client are forgotten, but blobs uploaded by others are only seen once their
+// entries expire. Zero, the default, disables the cache.
+type MissingBlobsCacheTTL time.Duration
+
+// Apply sets the client's cache of missing blobs.
+func (t MissingBlobsCacheTTL) Apply(c *Client) {
+	if t <= 0 {
+		c.missingBlobs = nil
+		return
+	}
+	c.missingBlobs = &missingCache{
+		ttl:     time.Duration(t),
+		expires: make(map[digest.Digest]time.Time),
+	}
+}
+
+// minMissingCacheSweep is the number of entries of a missingCache below which expired entries are
+// not swept.
+const minMissingCacheSweep = 1024
+
+// missingCache is a record of the digests recently found missing from the CAS.
+type missingCache struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	expires map[digest.Digest]time.Time
+	// sweepAt is the number of entries at which add removes the expired entries, which split only
+	// removes for the digests it is asked about. It is twice the number of entries left by the last
+	// sweep, so that sweeping takes amortized constant time per entry.
+	sweepAt int
+}
+
+// split returns the digests of dgs known to be missing, and the rest.
+func (m *missingCache) split(dgs []digest.Digest) (missing, rest []digest.Digest) {
+	if m == nil {
+		return nil, dgs
+	}
+	now := time.Now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, dg := range dgs {
+		exp, ok := m.expires[dg]
+		switch {
+		case ok && now.Before(exp):
+			missing = append(missing, dg)
+		case ok:
+			delete(m.expires, dg)
+			rest = append(rest, dg)
+		default:
+			rest = append(rest, dg)
+		}
+	}
+	return missing, rest
+}
+
+// add records that dgs are missing.
+func (m *missingCache) add(dgs []digest.Digest) {
+	if m == nil || len(dgs) == 0 {
+		return
+	}
+	exp := time.Now().Add(m.ttl)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, dg := range dgs {
+		m.expires[dg] = exp
+	}
+	if len(m.expires) < m.sweepAt || len(m.expires) < minMissingCacheSweep {
+		return
+	}
+	now := time.Now()
+	for dg, exp := range m.expires {
+		if !now.Before(exp) {
+			delete(m.expires, dg)
+		}
+	}
+	m.sweepAt = 2 * len(m.expires)
+}
+
+// forget removes dgs, which are being uploaded, from the record.
+func (m *missingCache) forget(dgs []digest.Digest) {
+	if m == nil || len(dgs) == 0 {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, dg := range dgs {
+		delete(m.expires, dg)
+	}
+}
+
+// ContainsBlobs queries the CAS for the specified blobs like MissingBlobs, and returns whether
+// each of them is present.
+func (c *Client) ContainsBlobs(ctx context.Context, dgs []digest.Digest) (map[digest.Digest]bool, error) {
+	missing, err := c.MissingBlobs(ctx, dgs)
+	if err != nil {
+		return nil, err
+	}
+	res := make(map[digest.Digest]bool, len(dgs))
+	for _, dg := range dgs {
+		res[dg] = true
+	}
+	for _, dg := range missing {
+		res[dg] = false
+	}
+	return res, nil
+}
@@ -0,0 +1,53 @@
+package client
+
+import (
+	log "github.com/golang/glog"
+)
+
+// TreeMountOpts controls how mount points under the root of a tree are handled during traversal,
+// to prevent accidentally uploading a network mount or container overlay nested under the exec root.
+type TreeMountOpts struct {
+	// OneFileSystem skips directories on a different file system than the root of the tree, like
+	// find -xdev.
+	OneFileSystem bool
+	// SkipVirtual skips directories on well-known virtual file systems, such as proc and sysfs.
+	SkipVirtual bool
+}
+
+// Apply sets the client's TreeMountOpts.
+func (o *TreeMountOpts) Apply(c *Client) {
+	c.TreeMountOpts = o
+}
+
+// mountFilter decides which directories are skipped during a traversal.
+type mountFilter struct {
+	opts    *TreeMountOpts
+	rootDev uint64
+	hasRoot bool
+}
+
+func newMountFilter(opts *TreeMountOpts, root string) *mountFilter {
+	f := &mountFilter{opts: opts}
+	if opts != nil && opts.OneFileSystem {
+		f.rootDev, f.hasRoot = deviceID(root)
+	}
+	return f
+}
+
+// skip returns whether the directory at the absolute path should not be descended into.
+func (f *mountFilter) skip(dir string) bool {
+	if f.opts == nil {
+		return false
+	}
+	if f.opts.SkipVirtual && isVirtualFS(dir) {
+		log.V(2).Infof("Skipping directory %q on a virtual file system", dir)
+		return true
+	}
+	if f.opts.OneFileSystem && f.hasRoot {
+		if dev, ok := deviceID(dir); ok && dev != f.rootDev {
+			log.V(2).Infof("Skipping directory %q on another file system", dir)
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,65 @@
+package client
+
+import (
+	"context"
+
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/filemetadata"
+)
+
+// OutputService is an external store that downloaded outputs are delegated to, such as a
+// FUSE-backed file system that fetches the contents of files lazily, when they are read. Instead
+// of writing outputs, DownloadOutputs reports them, with their digests, to the service. When those
+// paths later appear as inputs, the client consults the service for their metadata instead of
+// reading the files.
+type OutputService interface {
+	// PutOutputs takes over the outputs, whose paths are relative to outDir.
+	PutOutputs(ctx context.Context, outDir string, outs map[string]*TreeOutput) error
+	// Metadata returns the metadata of the output at the absolute path, or nil if the service does
+	// not hold it.
+	Metadata(path string) *filemetadata.Metadata
+}
+
+// DelegateOutputs is an Opt that sets the OutputService downloaded outputs are delegated to.
+type DelegateOutputs struct {
+	OutputService
+}
+
+// Apply sets the client's output service.
+func (d DelegateOutputs) Apply(c *Client) {
+	c.OutputService = d.OutputService
+}
+
+// putOutputs hands the outputs over to the client's output service.
+func (c *Client) putOutputs(ctx context.Context, outs map[string]*TreeOutput, outDir string) (*MovedBytesMetadata, error) {
+	stats := &MovedBytesMetadata{}
+	for _, out := range outs {
+		stats.Requested += out.Digest.Size
+	}
+	if err := c.OutputService.PutOutputs(ctx, outDir, outs); err != nil {
+		return stats, err
+	}
+	return stats, nil
+}
+
+// outputServiceCache serves the metadata of the files held by an output service from the
+// service, and that of the other files from the underlying cache.
+type outputServiceCache struct {
+	filemetadata.Cache
+	svc OutputService
+}
+
+// Get returns the metadata of the file at path.
+func (c *outputServiceCache) Get(path string) *filemetadata.Metadata {
+	if md := c.svc.Metadata(path); md != nil {
+		return md
+	}
+	return c.Cache.Get(path)
+}
+
+// withOutputService returns a cache consulting the client's output service, if there is one.
+func (c *Client) withOutputService(cache filemetadata.Cache) filemetadata.Cache {
+	if c.OutputService == nil {
+		return cache
+	}
+	return &outputServiceCache{Cache: cache, svc: c.OutputService}
+}
@@ -0,0 +1,201 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/retry"
+	"google.golang.org/grpc"
+
+	repb "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	log "github.com/golang/glog"
+)
+
+// DefaultResumeCheckInterval is how often the client checks for a suspend or a network change by
+// default.
+const DefaultResumeCheckInterval = 5 * time.Second
+
+// ResumeDetection is an Opt that makes the client detect that the machine resumed from suspend
+// or that its network changed, as happens with laptops. The connections are typically broken then,
+// and gRPC may wait for minutes of reconnection backoff accumulated while the network was down. On
+// such an event the client reconnects right away, re-validates its credentials with a cheap RPC,
+// and cuts short the retry backoffs in progress, so that the interrupted transfers resume (from
+// their committed offsets for ByteStream) without delay.
+type ResumeDetection struct {
+	// Interval is how often the clock and the network interfaces are checked. Defaults to
+	// DefaultResumeCheckInterval.
+	Interval time.Duration
+	// MinSuspend is the shortest time the machine must have been suspended to be considered resumed.
+	// Defaults to Interval.
+	MinSuspend time.Duration
+}
+
+// Apply starts the detection for the client. It is stopped by Close.
+func (r *ResumeDetection) Apply(c *Client) {
+	if c.resumeWatcher != nil {
+		c.resumeWatcher.close()
+	}
+	interval := r.Interval
+	if interval <= 0 {
+		interval = DefaultResumeCheckInterval
+	}
+	minSuspend := r.MinSuspend
+	if minSuspend <= 0 {
+		minSuspend = interval
+	}
+	c.resumeWatcher = newResumeWatcher(interval, minSuspend, c.onResume)
+}
+
+// resumeWatcher periodically checks for a suspend or a network change.
+type resumeWatcher struct {
+	interval   time.Duration
+	minSuspend time.Duration
+	onResume   func(reason string)
+	stop       chan struct{}
+	stopOnce   sync.Once
+	done       chan struct{}
+	lastNet    string
+
+	mu sync.Mutex
+	// wake is closed on each event, and replaced.
+	wake chan struct{}
+}
+
+func newResumeWatcher(interval, minSuspend time.Duration, onResume func(string)) *resumeWatcher {
+	w := &resumeWatcher{
+		interval:   interval,
+		minSuspend: minSuspend,
+		onResume:   onResume,
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+		lastNet:    networkFingerprint(),
+		wake:       make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+func (w *resumeWatcher) run() {
+	defer close(w.done)
+	t := time.NewTicker(w.interval)
+	defer t.Stop()
+	last := time.Now()
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-t.C:
+			now := time.Now()
+			// The monotonic clock does not advance while the machine is suspended, unlike the wall
+			// clock, so the difference of the elapsed times is the time spent suspended.
+			suspended := now.Round(0).Sub(last.Round(0)) - now.Sub(last)
+			last = now
+			w.check(suspended, networkFingerprint())
+		}
+	}
+}
+
+// check handles the state observed by a periodic check.
+func (w *resumeWatcher) check(suspended time.Duration, network string) {
+	var reason string
+	switch {
+	case suspended >= w.minSuspend:
+		reason = fmt.Sprintf("resumed after being suspended for %v", suspended.Round(time.Second))
+	case network != w.lastNet:
+		reason = "network changed"
+	}
+	w.lastNet = network
+	if reason == "" {
+		return
+	}
+	w.mu.Lock()
+	close(w.wake)
+	w.wake = make(chan struct{})
+	w.mu.Unlock()
+	w.onResume(reason)
+}
+
+// wakeup returns a channel closed on the next event.
+func (w *resumeWatcher) wakeup() <-chan struct{} {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.wake
+}
+
+// timeAfter is like time.After, except that the channel also fires on the next event.
+func (w *resumeWatcher) timeAfter(d time.Duration) <-chan time.Time {
+	ch := make(chan time.Time, 1)
+	wake := w.wakeup()
+	go func() {
+		t := time.NewTimer(d)
+		defer t.Stop()
+		select {
+		case now := <-t.C:
+			ch <- now
+		case <-wake:
+			ch <- time.Now()
+		case <-w.stop:
+			ch <- time.Now()
+		}
+	}()
+	return ch
+}
+
+func (w *resumeWatcher) close() {
+	w.stopOnce.Do(func() { close(w.stop) })
+	<-w.done
+}
+
+// networkFingerprint returns a summary of the addresses of the network interfaces, which changes
+// when the machine joins another network.
+func networkFingerprint() string {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return ""
+	}
+	s := make([]string, len(addrs))
+	for i, a := range addrs {
+		s[i] = a.String()
+	}
+	sort.Strings(s)
+	return strings.Join(s, ",")
+}
+
+// withResumeWakeup makes the retry backoffs of ctx end early on a resume event, if the client
+// detects them and no other backoff timer is set.
+func (c *Client) withResumeWakeup(ctx context.Context) context.Context {
+	if c.resumeWatcher == nil || ctx.Value(retry.TimeAfterContextKey) != nil {
+		return ctx
+	}
+	return context.WithValue(ctx, retry.TimeAfterContextKey, c.resumeWatcher.timeAfter)
+}
+
+// onResume reconnects after a resume event and re-validates the credentials in the background.
+func (c *Client) onResume(reason string) {
+	log.Infof("Machine %s, reconnecting to the remote execution service", reason)
+	conns := []*grpc.ClientConn{c.Connection}
+	if c.CASConnection != nil && c.CASConnection != c.Connection {
+		conns = append(conns, c.CASConnection)
+	}
+	for _, conn := range conns {
+		if conn == nil {
+			continue
+		}
+		conn.ResetConnectBackoff()
+		conn := conn
+		go func() {
+			// Any RPC fetches fresh credentials if the old ones expired while suspended, and warms
+			// up the connection for the first real RPC.
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			defer cancel()
+			if _, err := c.GetBackendCapabilities(ctx, conn, &repb.GetCapabilitiesRequest{InstanceName: c.InstanceName}); err != nil {
+				log.Warningf("Reconnecting to %s after the machine %s failed: %v", conn.Target(), reason, err)
+			}
+		}()
+	}
+}
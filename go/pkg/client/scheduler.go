@@ -0,0 +1,271 @@
+package client
+
+import (
+	"container/heap"
+	"context"
+	"sync"
+
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/contextmd"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/digest"
+)
+
+const (
+	// DefaultLargeTransferBytes is the default size from which a transfer is scheduled as large.
+	DefaultLargeTransferBytes = 4 * 1024 * 1024
+	// DefaultMaxLargeTransferShare is the default fraction of the concurrent transfers that large
+	// transfers may use.
+	DefaultMaxLargeTransferShare = 0.5
+)
+
+// FairTransfers is an Opt that makes the client schedule its CAS transfers with fair queuing
+// rather than first come, first served, so that a handful of giant blobs do not monopolize
+// the transfer slots. Transfers are queued per action, identified by the ActionID of their context
+// metadata, and size class. Each action gets an equal share of the bytes transferred, and large
+// transfers may only use part of the slots, so that the small transfers of critical path actions
+// finish promptly during a large cold upload.
+type FairTransfers struct {
+	// LargeBytes is the size from which a transfer is large. Defaults to DefaultLargeTransferBytes.
+	LargeBytes int64
+	// MaxLargeShare is the fraction of the upload or download slots that large transfers may use. At
+	// least one slot is always usable. Defaults to DefaultMaxLargeTransferShare.
+	MaxLargeShare float64
+}
+
+// Apply makes the client schedule its transfers fairly.
+func (f *FairTransfers) Apply(c *Client) {
+	c.fairTransfers = f
+	c.resetTransferSchedulers()
+}
+
+func (c *Client) resetTransferSchedulers() {
+	if c.fairTransfers == nil {
+		c.uploadScheduler, c.downloadScheduler = nil, nil
+		return
+	}
+	c.uploadScheduler = newTransferScheduler(c.uploadConcurrency, c.fairTransfers)
+	c.downloadScheduler = newTransferScheduler(c.downloadConcurrency, c.fairTransfers)
+}
+
+// actionID returns the ActionID of the context metadata, which identifies the flow of the
+// transfers made with the context.
+func actionID(ctx context.Context) string {
+	if m, err := contextmd.ExtractMetadata(ctx); err == nil {
+		return m.ActionID
+	}
+	return ""
+}
+
+// acquireUpload waits for an upload slot for a transfer of size bytes by the action of the context
+// metadata, and returns the function releasing it.
+func (c *Client) acquireUpload(ctx context.Context, size int64) (func(), error) {
+	return c.acquireUploadFor(ctx, actionID(ctx), size)
+}
+
+// acquireUploadFor waits for an upload slot for a transfer of size bytes by the given action, and
+// returns the function releasing it. It is used by the batched uploads, whose context metadata
+// merges the actions of all the blobs.
+func (c *Client) acquireUploadFor(ctx context.Context, action string, size int64) (func(), error) {
+	if c.uploadScheduler != nil {
+		return c.uploadScheduler.acquire(ctx, action, size)
+	}
+	if err := c.casUploaders.Acquire(ctx, 1); err != nil {
+		return nil, err
+	}
+	return func() { c.casUploaders.Release(1) }, nil
+}
+
+// acquireDownload waits for a download slot for a transfer of size bytes by the action of the
+// context metadata, and returns the function releasing it.
+func (c *Client) acquireDownload(ctx context.Context, size int64) (func(), error) {
+	return c.acquireDownloadFor(ctx, actionID(ctx), size)
+}
+
+// acquireDownloadFor waits for a download slot for a transfer of size bytes by the given action,
+// and returns the function releasing it.
+func (c *Client) acquireDownloadFor(ctx context.Context, action string, size int64) (func(), error) {
+	if c.downloadScheduler != nil {
+		return c.downloadScheduler.acquire(ctx, action, size)
+	}
+	if err := c.casDownloaders.Acquire(ctx, 1); err != nil {
+		return nil, err
+	}
+	return func() { c.casDownloaders.Release(1) }, nil
+}
+
+// batchSize returns the total size of the digests.
+func batchSize(dgs []digest.Digest) int64 {
+	var size int64
+	for _, dg := range dgs {
+		size += dg.Size
+	}
+	return size
+}
+
+// flowKey identifies a queue of transfers.
+type flowKey struct {
+	action string
+	large  bool
+}
+
+type transferWaiter struct {
+	start float64
+	seq   uint64
+	large bool
+	ready chan struct{}
+	index int
+}
+
+// before returns whether w is served before v.
+func (w *transferWaiter) before(v *transferWaiter) bool {
+	if w.start != v.start {
+		return w.start < v.start
+	}
+	return w.seq < v.seq
+}
+
+// waiterQueue is a heap of waiters ordered by virtual start time.
+type waiterQueue []*transferWaiter
+
+func (q waiterQueue) Len() int           { return len(q) }
+func (q waiterQueue) Less(i, j int) bool { return q[i].before(q[j]) }
+func (q waiterQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index, q[j].index = i, j
+}
+func (q *waiterQueue) Push(x any) {
+	w := x.(*transferWaiter)
+	w.index = len(*q)
+	*q = append(*q, w)
+}
+func (q *waiterQueue) Pop() any {
+	old := *q
+	w := old[len(old)-1]
+	*q = old[:len(old)-1]
+	w.index = -1
+	return w
+}
+
+// transferScheduler hands out a fixed number of transfer slots with start-time fair queuing: every
+// transfer gets a virtual start time, the later of the current virtual time and the finish time of
+// the previous transfer of its flow, which is its start time plus its size. The free slots go to
+// the waiting transfers with the earliest start times, and the virtual time advances to them.
+type transferScheduler struct {
+	largeBytes int64
+
+	mu         sync.Mutex
+	free       int64
+	largeFree  int64
+	vtime      float64
+	seq        uint64
+	lastFinish map[flowKey]float64
+	// small and large are the waiting transfers of each size class.
+	small, large waiterQueue
+}
+
+func (s *transferScheduler) queue(large bool) *waiterQueue {
+	if large {
+		return &s.large
+	}
+	return &s.small
+}
+
+func newTransferScheduler(slots int64, f *FairTransfers) *transferScheduler {
+	largeBytes := f.LargeBytes
+	if largeBytes <= 0 {
+		largeBytes = DefaultLargeTransferBytes
+	}
+	share := f.MaxLargeShare
+	if share <= 0 || share > 1 {
+		share = DefaultMaxLargeTransferShare
+	}
+	largeSlots := int64(float64(slots) * share)
+	if largeSlots < 1 {
+		largeSlots = 1
+	}
+	return &transferScheduler{
+		largeBytes: largeBytes,
+		free:       slots,
+		largeFree:  largeSlots,
+		lastFinish: make(map[flowKey]float64),
+	}
+}
+
+// acquire waits for a slot for a transfer of size bytes by the action, and returns the function
+// releasing it.
+func (s *transferScheduler) acquire(ctx context.Context, action string, size int64) (func(), error) {
+	key := flowKey{action: action, large: size >= s.largeBytes}
+	s.mu.Lock()
+	start := s.vtime
+	if last := s.lastFinish[key]; last > start {
+		start = last
+	}
+	w := &transferWaiter{start: start, seq: s.seq, large: key.large, ready: make(chan struct{})}
+	s.seq++
+	s.lastFinish[key] = start + float64(size) + 1
+	heap.Push(s.queue(w.large), w)
+	s.dispatch()
+	s.mu.Unlock()
+
+	release := func() {
+		s.mu.Lock()
+		s.free++
+		if w.large {
+			s.largeFree++
+		}
+		s.dispatch()
+		s.mu.Unlock()
+	}
+	select {
+	case <-w.ready:
+		return release, nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		select {
+		case <-w.ready:
+			// The slot was granted concurrently; hand it on.
+			s.mu.Unlock()
+			release()
+		default:
+			heap.Remove(s.queue(w.large), w.index)
+			s.mu.Unlock()
+		}
+		return nil, ctx.Err()
+	}
+}
+
+// dispatch grants the free slots to the waiters with the earliest start times. s.mu must be held.
+func (s *transferScheduler) dispatch() {
+	for s.free > 0 {
+		small := len(s.small) > 0
+		large := len(s.large) > 0 && s.largeFree > 0
+		if !small && !large {
+			break
+		}
+		q := &s.small
+		if large && (!small || s.large[0].before(s.small[0])) {
+			q = &s.large
+		}
+		w := heap.Pop(q).(*transferWaiter)
+		s.free--
+		if w.large {
+			s.largeFree--
+		}
+		if w.start > s.vtime {
+			s.vtime = w.start
+		}
+		close(w.ready)
+	}
+	s.prune()
+}
+
+// prune forgets the flows that are not ahead of the virtual time, which start from it anyway.
+func (s *transferScheduler) prune() {
+	if len(s.lastFinish) < 1024 {
+		return
+	}
+	for k, f := range s.lastFinish {
+		if f <= s.vtime {
+			delete(s.lastFinish, k)
+		}
+	}
+}
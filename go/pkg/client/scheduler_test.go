@@ -0,0 +1,108 @@
+package client
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/contextmd"
+	"github.com/google/go-cmp/cmp"
+)
+
+func actionContext(t *testing.T, actionID string) context.Context {
+	t.Helper()
+	ctx, err := contextmd.WithMetadata(context.Background(), &contextmd.Metadata{ActionID: actionID})
+	if err != nil {
+		t.Fatalf("contextmd.WithMetadata() failed: %v", err)
+	}
+	return ctx
+}
+
+func TestActionID(t *testing.T) {
+	if got := actionID(actionContext(t, "action")); got != "action" {
+		t.Errorf("actionID() = %q, want %q", got, "action")
+	}
+	if got := actionID(context.Background()); got != "" {
+		t.Errorf("actionID() without metadata = %q, want empty", got)
+	}
+}
+
+func TestTransferSchedulerFairness(t *testing.T) {
+	s := newTransferScheduler(1, &FairTransfers{LargeBytes: 1000})
+	release, err := s.acquire(context.Background(), "holder", 0)
+	if err != nil {
+		t.Fatalf("acquire() failed: %v", err)
+	}
+
+	type grant struct {
+		name    string
+		release func()
+	}
+	grants := make(chan grant)
+	// enqueue requests a transfer and waits until it is queued, so that the queuing order is
+	// deterministic.
+	enqueue := func(action, name string, size int64) {
+		s.mu.Lock()
+		want := s.seq + 1
+		s.mu.Unlock()
+		go func() {
+			r, err := s.acquire(context.Background(), action, size)
+			if err != nil {
+				t.Errorf("acquire() failed: %v", err)
+				return
+			}
+			grants <- grant{name, r}
+		}()
+		for {
+			s.mu.Lock()
+			seq := s.seq
+			s.mu.Unlock()
+			if seq >= want {
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	enqueue("big", "big1", 100)
+	enqueue("big", "big2", 100)
+	enqueue("big", "big3", 100)
+	enqueue("small", "small", 1)
+
+	release()
+	var got []string
+	for i := 0; i < 4; i++ {
+		g := <-grants
+		got = append(got, g.name)
+		g.release()
+	}
+	// The transfer of the small action is not queued behind all those of the big one.
+	if diff := cmp.Diff([]string{"big1", "small", "big2", "big3"}, got); diff != "" {
+		t.Errorf("transfers were granted in the wrong order (-want +got):\n%s", diff)
+	}
+}
+
+func TestTransferSchedulerLargeShare(t *testing.T) {
+	ctx := context.Background()
+	s := newTransferScheduler(2, &FairTransfers{LargeBytes: 1000, MaxLargeShare: 0.5})
+	releaseLarge, err := s.acquire(ctx, "", 1000)
+	if err != nil {
+		t.Fatalf("acquire() of a large transfer failed: %v", err)
+	}
+	// The second large transfer waits for the large slot, while a small one proceeds.
+	cCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if _, err := s.acquire(cCtx, "", 2000); err == nil {
+		t.Errorf("acquire() of a second large transfer succeeded, want it to wait")
+	}
+	releaseSmall, err := s.acquire(ctx, "", 10)
+	if err != nil {
+		t.Fatalf("acquire() of a small transfer failed: %v", err)
+	}
+	releaseSmall()
+	releaseLarge()
+	releaseLarge, err = s.acquire(ctx, "", 2000)
+	if err != nil {
+		t.Fatalf("acquire() of a large transfer after the release failed: %v", err)
+	}
+	releaseLarge()
+}
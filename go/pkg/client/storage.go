@@ -0,0 +1,439 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/digest"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	regrpc "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	repb "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	bsgrpc "google.golang.org/genproto/googleapis/bytestream"
+	bspb "google.golang.org/genproto/googleapis/bytestream"
+	rpcstatus "google.golang.org/genproto/googleapis/rpc/status"
+)
+
+// BlobStore is a storage backend for the CAS, e.g. backed by S3 or GCS.
+//
+// Blobs are passed to and from the store whole, so every blob read or written through it, including
+// large files uploaded or downloaded over the ByteStream API, is held in memory in full. Custom
+// storage is therefore only suited to builds whose blobs comfortably fit in memory.
+type BlobStore interface {
+	// FindMissing returns the digests of the blobs that are not in the store.
+	FindMissing(ctx context.Context, dgs []digest.Digest) ([]digest.Digest, error)
+	// Get returns the contents of a blob. It returns a NotFound status error if the blob is missing.
+	Get(ctx context.Context, dg digest.Digest) ([]byte, error)
+	// Put stores a blob. Its contents have already been verified to match dg.
+	Put(ctx context.Context, dg digest.Digest, blob []byte) error
+}
+
+// BatchBlobStore is a BlobStore that also reads and writes several blobs in one call, e.g. in a
+// single request to its backend. The client uses the batch methods for batch reads and writes.
+type BatchBlobStore interface {
+	BlobStore
+	// BatchGet returns the contents of the blobs in the store. Missing blobs are omitted.
+	BatchGet(ctx context.Context, dgs []digest.Digest) (map[digest.Digest][]byte, error)
+	// BatchPut stores the blobs. Their contents have already been verified to match their digests.
+	BatchPut(ctx context.Context, blobs map[digest.Digest][]byte) error
+}
+
+// ActionCacheStore is a storage backend for the action cache.
+type ActionCacheStore interface {
+	// GetActionResult returns the cached result of an action. It returns a NotFound status error if
+	// there is none.
+	GetActionResult(ctx context.Context, acDg digest.Digest) (*repb.ActionResult, error)
+	// UpdateActionResult stores the result of an action.
+	UpdateActionResult(ctx context.Context, acDg digest.Digest, ar *repb.ActionResult) error
+}
+
+// Storage replaces the CAS and action cache services of the client with custom storage backends.
+// All other client logic, such as batching, retries, tree handling and execution, is unchanged.
+// The client's connections are still used for the services that are not replaced.
+type Storage struct {
+	// CAS, if set, stores the blobs instead of the remote CAS.
+	CAS BlobStore
+	// ActionCache, if set, stores action results instead of the remote action cache.
+	ActionCache ActionCacheStore
+}
+
+// Apply sets the client's storage backends.
+func (s *Storage) Apply(c *Client) {
+	if s.CAS != nil {
+		c.cas = &storageCAS{store: s.CAS}
+		c.byteStream = &storageByteStream{store: s.CAS, partial: make(map[string][]byte)}
+	}
+	if s.ActionCache != nil {
+		c.actionCache = &storageActionCache{store: s.ActionCache}
+	}
+}
+
+// storageCAS implements the CAS service client on top of a BlobStore.
+type storageCAS struct {
+	store BlobStore
+}
+
+func (s *storageCAS) FindMissingBlobs(ctx context.Context, req *repb.FindMissingBlobsRequest, _ ...grpc.CallOption) (*repb.FindMissingBlobsResponse, error) {
+	var dgs []digest.Digest
+	for _, d := range req.BlobDigests {
+		dgs = append(dgs, digest.NewFromProtoUnvalidated(d))
+	}
+	missing, err := s.store.FindMissing(ctx, dgs)
+	if err != nil {
+		return nil, err
+	}
+	resp := &repb.FindMissingBlobsResponse{}
+	for _, dg := range missing {
+		resp.MissingBlobDigests = append(resp.MissingBlobDigests, dg.ToProto())
+	}
+	return resp, nil
+}
+
+func (s *storageCAS) BatchUpdateBlobs(ctx context.Context, req *repb.BatchUpdateBlobsRequest, _ ...grpc.CallOption) (*repb.BatchUpdateBlobsResponse, error) {
+	fn, err := digest.NewFunction(req.DigestFunction)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	resp := &repb.BatchUpdateBlobsResponse{}
+	batch, _ := s.store.(BatchBlobStore)
+	// The verified blobs to store in one call, and their responses.
+	verified := make(map[digest.Digest][]byte)
+	var pending []*repb.BatchUpdateBlobsResponse_Response
+	for _, r := range req.Requests {
+		dg := digest.NewFromProtoUnvalidated(r.Digest)
+		data := r.Data
+		var err error
+		if r.Compressor == repb.Compressor_ZSTD {
+			data, err = zstdDecoder.DecodeAll(data, nil)
+		}
+		if err == nil {
+			err = verifyBlob(fn, dg, data)
+		}
+		rr := &repb.BatchUpdateBlobsResponse_Response{Digest: r.Digest}
+		resp.Responses = append(resp.Responses, rr)
+		if err == nil && batch != nil {
+			verified[dg] = data
+			pending = append(pending, rr)
+			continue
+		}
+		if err == nil {
+			err = s.store.Put(ctx, dg, data)
+		}
+		rr.Status = statusProto(err)
+	}
+	if len(verified) > 0 {
+		st := statusProto(batch.BatchPut(ctx, verified))
+		for _, rr := range pending {
+			rr.Status = st
+		}
+	}
+	return resp, nil
+}
+
+func (s *storageCAS) BatchReadBlobs(ctx context.Context, req *repb.BatchReadBlobsRequest, _ ...grpc.CallOption) (*repb.BatchReadBlobsResponse, error) {
+	compress := false
+	for _, c := range req.AcceptableCompressors {
+		if c == repb.Compressor_ZSTD {
+			compress = true
+		}
+	}
+	get := s.store.Get
+	if batch, ok := s.store.(BatchBlobStore); ok {
+		dgs := make([]digest.Digest, len(req.Digests))
+		for i, d := range req.Digests {
+			dgs[i] = digest.NewFromProtoUnvalidated(d)
+		}
+		blobs, err := batch.BatchGet(ctx, dgs)
+		if err != nil {
+			return nil, err
+		}
+		get = func(_ context.Context, dg digest.Digest) ([]byte, error) {
+			blob, ok := blobs[dg]
+			if !ok {
+				return nil, status.Errorf(codes.NotFound, "blob %v not found", dg)
+			}
+			return blob, nil
+		}
+	}
+	resp := &repb.BatchReadBlobsResponse{}
+	for _, d := range req.Digests {
+		r := &repb.BatchReadBlobsResponse_Response{Digest: d}
+		data, err := get(ctx, digest.NewFromProtoUnvalidated(d))
+		if err == nil && compress {
+			data = zstdEncoder.EncodeAll(data, nil)
+			r.Compressor = repb.Compressor_ZSTD
+		}
+		r.Data = data
+		r.Status = statusProto(err)
+		resp.Responses = append(resp.Responses, r)
+	}
+	return resp, nil
+}
+
+func (s *storageCAS) GetTree(ctx context.Context, req *repb.GetTreeRequest, _ ...grpc.CallOption) (regrpc.ContentAddressableStorage_GetTreeClient, error) {
+	var dirs []*repb.Directory
+	queue := []*repb.Digest{req.RootDigest}
+	seen := make(map[digest.Digest]bool)
+	for len(queue) > 0 {
+		dg := digest.NewFromProtoUnvalidated(queue[0])
+		queue = queue[1:]
+		if seen[dg] {
+			continue
+		}
+		seen[dg] = true
+		blob, err := s.store.Get(ctx, dg)
+		if err != nil {
+			return nil, err
+		}
+		dir := &repb.Directory{}
+		if err := proto.Unmarshal(blob, dir); err != nil {
+			return nil, err
+		}
+		dirs = append(dirs, dir)
+		for _, sub := range dir.Directories {
+			queue = append(queue, sub.Digest)
+		}
+	}
+	return &getTreeStream{resps: []*repb.GetTreeResponse{{Directories: dirs}}}, nil
+}
+
+// putVerified stores the blob after checking that it matches its digest with the digest function.
+func putVerified(ctx context.Context, store BlobStore, fn digest.Function, dg digest.Digest, blob []byte) error {
+	if err := verifyBlob(fn, dg, blob); err != nil {
+		return err
+	}
+	return store.Put(ctx, dg, blob)
+}
+
+// verifyBlob checks that the blob matches its digest with the digest function.
+func verifyBlob(fn digest.Function, dg digest.Digest, blob []byte) error {
+	if got := fn.NewFromBlob(blob); got != dg {
+		return status.Errorf(codes.InvalidArgument, "blob has digest %v, expected %v", got, dg)
+	}
+	return nil
+}
+
+func statusProto(err error) *rpcstatus.Status {
+	st, _ := status.FromError(err)
+	return st.Proto()
+}
+
+type getTreeStream struct {
+	grpc.ClientStream
+	resps []*repb.GetTreeResponse
+}
+
+func (s *getTreeStream) Recv() (*repb.GetTreeResponse, error) {
+	if len(s.resps) == 0 {
+		return nil, io.EOF
+	}
+	resp := s.resps[0]
+	s.resps = s.resps[1:]
+	return resp, nil
+}
+
+// parseBlobResource returns the digest of a ByteStream resource name for a blob, the digest
+// function it names, or the default one, and whether the blob is zstd-compressed.
+func parseBlobResource(name string) (digest.Digest, digest.Function, bool, error) {
+	segs := strings.Split(name, "/")
+	for i, seg := range segs {
+		compressed := false
+		switch {
+		case seg == "blobs":
+		case seg == "compressed-blobs" && i+1 < len(segs) && segs[i+1] == "zstd":
+			compressed = true
+			i++
+		default:
+			continue
+		}
+		var fn digest.Function
+		if i+1 < len(segs) {
+			if f, ok := digest.FunctionFromResourceNameSegment(segs[i+1]); ok {
+				fn = f
+				i++
+			}
+		}
+		if i+2 >= len(segs) {
+			break
+		}
+		size, err := strconv.ParseInt(segs[i+2], 10, 64)
+		if err != nil {
+			break
+		}
+		dg, err := digest.New(segs[i+1], size)
+		if err != nil {
+			return digest.Empty, fn, false, status.Errorf(codes.InvalidArgument, "invalid resource name %q: %v", name, err)
+		}
+		return dg, fn, compressed, nil
+	}
+	return digest.Empty, digest.Function{}, false, status.Errorf(codes.InvalidArgument, "invalid resource name %q", name)
+}
+
+// storageByteStream implements the ByteStream service client on top of a BlobStore. Since the store
+// only holds whole blobs, the data of a write is buffered in memory until it is finished.
+type storageByteStream struct {
+	store BlobStore
+
+	mu sync.Mutex
+	// partial holds the data committed so far by unfinished writes, by resource name, so that they
+	// can be queried and resumed.
+	partial map[string][]byte
+}
+
+// readChunkSize is the maximum size of the data in a single read response.
+const readChunkSize = 2 * 1024 * 1024
+
+func (s *storageByteStream) Read(ctx context.Context, req *bspb.ReadRequest, _ ...grpc.CallOption) (bsgrpc.ByteStream_ReadClient, error) {
+	dg, _, compressed, err := parseBlobResource(req.ResourceName)
+	if err != nil {
+		return nil, err
+	}
+	data, err := s.store.Get(ctx, dg)
+	if err != nil {
+		return nil, err
+	}
+	if compressed {
+		data = zstdEncoder.EncodeAll(data, nil)
+	}
+	if req.ReadOffset < 0 || req.ReadOffset > int64(len(data)) {
+		return nil, status.Errorf(codes.OutOfRange, "read offset %d is out of range for length %d", req.ReadOffset, len(data))
+	}
+	data = data[req.ReadOffset:]
+	if req.ReadLimit > 0 && req.ReadLimit < int64(len(data)) {
+		data = data[:req.ReadLimit]
+	}
+	return &readStream{data: data}, nil
+}
+
+func (s *storageByteStream) Write(ctx context.Context, _ ...grpc.CallOption) (bsgrpc.ByteStream_WriteClient, error) {
+	return &writeStream{ctx: ctx, bs: s}, nil
+}
+
+// QueryWriteStatus reports the size committed by an unfinished write of the resource, or the full
+// size of the blob if it is already in the store. Complete writes report the uncompressed size of
+// the blob, also for compressed resources.
+func (s *storageByteStream) QueryWriteStatus(ctx context.Context, req *bspb.QueryWriteStatusRequest, _ ...grpc.CallOption) (*bspb.QueryWriteStatusResponse, error) {
+	dg, _, _, err := parseBlobResource(req.ResourceName)
+	if err != nil {
+		return nil, err
+	}
+	missing, err := s.store.FindMissing(ctx, []digest.Digest{dg})
+	if err != nil {
+		return nil, err
+	}
+	if len(missing) == 0 {
+		return &bspb.QueryWriteStatusResponse{CommittedSize: dg.Size, Complete: true}, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.partial[req.ResourceName]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "no write of %q in progress", req.ResourceName)
+	}
+	return &bspb.QueryWriteStatusResponse{CommittedSize: int64(len(data))}, nil
+}
+
+type readStream struct {
+	grpc.ClientStream
+	data []byte
+	sent bool
+}
+
+func (s *readStream) Recv() (*bspb.ReadResponse, error) {
+	if len(s.data) == 0 && s.sent {
+		return nil, io.EOF
+	}
+	n := len(s.data)
+	if n > readChunkSize {
+		n = readChunkSize
+	}
+	resp := &bspb.ReadResponse{Data: s.data[:n]}
+	s.data = s.data[n:]
+	s.sent = true
+	return resp, nil
+}
+
+type writeStream struct {
+	grpc.ClientStream
+	ctx      context.Context
+	bs       *storageByteStream
+	name     string
+	buf      bytes.Buffer
+	finished bool
+}
+
+func (s *writeStream) Send(req *bspb.WriteRequest) error {
+	if s.name == "" {
+		s.name = req.ResourceName
+		// A write starting at a non-zero offset resumes an unfinished write of the resource.
+		if req.WriteOffset > 0 {
+			s.bs.mu.Lock()
+			s.buf.Write(s.bs.partial[s.name])
+			s.bs.mu.Unlock()
+		}
+	}
+	if req.WriteOffset != int64(s.buf.Len()) {
+		return status.Errorf(codes.InvalidArgument, "write offset %d does not match committed size %d", req.WriteOffset, s.buf.Len())
+	}
+	s.buf.Write(req.Data)
+	s.finished = s.finished || req.FinishWrite
+	return nil
+}
+
+func (s *writeStream) CloseAndRecv() (*bspb.WriteResponse, error) {
+	if !s.finished {
+		if s.name == "" {
+			return &bspb.WriteResponse{}, nil
+		}
+		s.bs.mu.Lock()
+		s.bs.partial[s.name] = s.buf.Bytes()
+		s.bs.mu.Unlock()
+		return &bspb.WriteResponse{CommittedSize: int64(s.buf.Len())}, nil
+	}
+	s.bs.mu.Lock()
+	delete(s.bs.partial, s.name)
+	s.bs.mu.Unlock()
+	dg, fn, compressed, err := parseBlobResource(s.name)
+	if err != nil {
+		return nil, err
+	}
+	data := s.buf.Bytes()
+	if compressed {
+		if data, err = zstdDecoder.DecodeAll(data, nil); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "failed to decompress blob %v: %v", dg, err)
+		}
+	}
+	if err := putVerified(s.ctx, s.bs.store, fn, dg, data); err != nil {
+		return nil, err
+	}
+	return &bspb.WriteResponse{CommittedSize: int64(s.buf.Len())}, nil
+}
+
+// storageActionCache implements the action cache service client on top of an ActionCacheStore.
+type storageActionCache struct {
+	store ActionCacheStore
+}
+
+func (s *storageActionCache) GetActionResult(ctx context.Context, req *repb.GetActionResultRequest, _ ...grpc.CallOption) (*repb.ActionResult, error) {
+	return s.store.GetActionResult(ctx, digest.NewFromProtoUnvalidated(req.ActionDigest))
+}
+
+func (s *storageActionCache) UpdateActionResult(ctx context.Context, req *repb.UpdateActionResultRequest, _ ...grpc.CallOption) (*repb.ActionResult, error) {
+	if err := s.store.UpdateActionResult(ctx, digest.NewFromProtoUnvalidated(req.ActionDigest), req.ActionResult); err != nil {
+		return nil, err
+	}
+	return req.ActionResult, nil
+}
+
+var (
+	_ regrpc.ContentAddressableStorageClient = (*storageCAS)(nil)
+	_ regrpc.ActionCacheClient               = (*storageActionCache)(nil)
+	_ bsgrpc.ByteStreamClient                = (*storageByteStream)(nil)
+)
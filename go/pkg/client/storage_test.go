@@ -0,0 +1,186 @@
+package client_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/client"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/digest"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/fakes"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/uploadinfo"
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/testing/protocmp"
+
+	repb "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	bspb "google.golang.org/genproto/googleapis/bytestream"
+)
+
+// countingStore counts the batch calls to a MemoryStore.
+type countingStore struct {
+	*client.MemoryStore
+	mu                   sync.Mutex
+	batchGets, batchPuts int
+}
+
+func (s *countingStore) BatchGet(ctx context.Context, dgs []digest.Digest) (map[digest.Digest][]byte, error) {
+	s.mu.Lock()
+	s.batchGets++
+	s.mu.Unlock()
+	return s.MemoryStore.BatchGet(ctx, dgs)
+}
+
+func (s *countingStore) BatchPut(ctx context.Context, blobs map[digest.Digest][]byte) error {
+	s.mu.Lock()
+	s.batchPuts++
+	s.mu.Unlock()
+	return s.MemoryStore.BatchPut(ctx, blobs)
+}
+
+// plainStore hides the batch methods of a BatchBlobStore.
+type plainStore struct {
+	client.BlobStore
+}
+
+func TestStorage(t *testing.T) {
+	tests := []struct {
+		name       string
+		opts       []client.Opt
+		plainStore bool
+		wantBatch  bool
+	}{
+		{name: "batch", wantBatch: true},
+		{name: "batch with a plain store", plainStore: true},
+		{name: "bytestream", opts: []client.Opt{client.UseBatchOps(false)}},
+		{name: "compressed bytestream", opts: []client.Opt{client.UseBatchOps(false), client.CompressedBytestreamThreshold(0)}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			e, cleanup := fakes.NewTestEnv(t)
+			defer cleanup()
+			c := e.Client.GrpcClient
+			for _, o := range tc.opts {
+				o.Apply(c)
+			}
+			store := &countingStore{MemoryStore: client.NewMemoryStore()}
+			var cas client.BlobStore = store
+			if tc.plainStore {
+				cas = plainStore{store}
+			}
+			(&client.Storage{CAS: cas, ActionCache: store}).Apply(c)
+
+			fooDg, err := c.WriteBlob(ctx, []byte("foo"))
+			if err != nil {
+				t.Fatalf("WriteBlob() failed: %v", err)
+			}
+			leaf := &repb.Directory{Files: []*repb.FileNode{{Name: "foo", Digest: fooDg.ToProto()}}}
+			leafUe, err := uploadinfo.EntryFromProto(leaf)
+			if err != nil {
+				t.Fatalf("EntryFromProto() failed: %v", err)
+			}
+			root := &repb.Directory{Directories: []*repb.DirectoryNode{{Name: "leaf", Digest: leafUe.Digest.ToProto()}}}
+			rootUe, err := uploadinfo.EntryFromProto(root)
+			if err != nil {
+				t.Fatalf("EntryFromProto() failed: %v", err)
+			}
+			missing, _, err := c.UploadIfMissing(ctx, leafUe, rootUe, uploadinfo.EntryFromBlob([]byte("foo")))
+			if err != nil {
+				t.Fatalf("UploadIfMissing() failed: %v", err)
+			}
+			if len(missing) != 2 {
+				t.Errorf("UploadIfMissing() uploaded %v, want the two directories", missing)
+			}
+			if _, ok := e.Server.CAS.Get(fooDg); ok {
+				t.Errorf("blob %v was written to the remote CAS", fooDg)
+			}
+
+			blobs, err := c.BatchDownloadBlobs(ctx, []digest.Digest{fooDg, leafUe.Digest})
+			if err != nil {
+				t.Fatalf("BatchDownloadBlobs() failed: %v", err)
+			}
+			if string(blobs[fooDg]) != "foo" || len(blobs) != 2 {
+				t.Errorf("BatchDownloadBlobs() = %v, want foo and the leaf directory", blobs)
+			}
+			if gotBatch := store.batchGets > 0 && store.batchPuts > 0; gotBatch != tc.wantBatch {
+				t.Errorf("store got %d batch gets and %d batch puts, want batch calls: %v", store.batchGets, store.batchPuts, tc.wantBatch)
+			}
+
+			got, _, err := c.ReadBlob(ctx, fooDg)
+			if err != nil {
+				t.Fatalf("ReadBlob() failed: %v", err)
+			}
+			if string(got) != "foo" {
+				t.Errorf("ReadBlob() = %q, want %q", got, "foo")
+			}
+			dirs, err := c.GetDirectoryTree(ctx, rootUe.Digest.ToProto())
+			if err != nil {
+				t.Fatalf("GetDirectoryTree() failed: %v", err)
+			}
+			if diff := cmp.Diff([]*repb.Directory{root, leaf}, dirs, protocmp.Transform()); diff != "" {
+				t.Errorf("GetDirectoryTree() gave diff (-want +got):\n%s", diff)
+			}
+
+			acDg := digest.NewFromBlob([]byte("action"))
+			if ar, err := c.CheckActionCache(ctx, acDg.ToProto()); ar != nil || err != nil {
+				t.Errorf("CheckActionCache() = %v, %v before update, want nil, nil", ar, err)
+			}
+			ar := &repb.ActionResult{ExitCode: 1}
+			if _, err := c.UpdateActionResult(ctx, &repb.UpdateActionResultRequest{ActionDigest: acDg.ToProto(), ActionResult: ar}); err != nil {
+				t.Fatalf("UpdateActionResult() failed: %v", err)
+			}
+			gotAr, err := c.CheckActionCache(ctx, acDg.ToProto())
+			if err != nil {
+				t.Fatalf("CheckActionCache() failed: %v", err)
+			}
+			if !proto.Equal(ar, gotAr) {
+				t.Errorf("CheckActionCache() = %v, want %v", gotAr, ar)
+			}
+		})
+	}
+}
+
+func TestStorageResumedWrite(t *testing.T) {
+	ctx := context.Background()
+	e, cleanup := fakes.NewTestEnv(t)
+	defer cleanup()
+	c := e.Client.GrpcClient
+	client.UseBatchOps(false).Apply(c)
+	(&client.Storage{CAS: client.NewMemoryStore()}).Apply(c)
+
+	dg := digest.NewFromBlob([]byte("foobar"))
+	name := c.ResourceNameWrite(dg.Hash, dg.Size)
+	if _, err := c.QueryWriteStatus(ctx, &bspb.QueryWriteStatusRequest{ResourceName: name}); status.Code(err) != codes.NotFound {
+		t.Errorf("QueryWriteStatus() before the write gave error %v, want NotFound", err)
+	}
+	if _, err := c.WriteBytesAtRemoteOffset(ctx, name, []byte("foo"), true, 0); err != nil {
+		t.Fatalf("WriteBytesAtRemoteOffset() failed: %v", err)
+	}
+	res, err := c.QueryWriteStatus(ctx, &bspb.QueryWriteStatusRequest{ResourceName: name})
+	if err != nil {
+		t.Fatalf("QueryWriteStatus() failed: %v", err)
+	}
+	if res.CommittedSize != 3 || res.Complete {
+		t.Errorf("QueryWriteStatus() of the unfinished write = %v, want 3 bytes committed and incomplete", res)
+	}
+	if _, err := c.WriteBytesAtRemoteOffset(ctx, name, []byte("bar"), false, 3); err != nil {
+		t.Fatalf("WriteBytesAtRemoteOffset() failed: %v", err)
+	}
+	res, err = c.QueryWriteStatus(ctx, &bspb.QueryWriteStatusRequest{ResourceName: name})
+	if err != nil {
+		t.Fatalf("QueryWriteStatus() failed: %v", err)
+	}
+	if res.CommittedSize != dg.Size || !res.Complete {
+		t.Errorf("QueryWriteStatus() of the finished write = %v, want %d bytes committed and complete", res, dg.Size)
+	}
+	got, _, err := c.ReadBlob(ctx, dg)
+	if err != nil {
+		t.Fatalf("ReadBlob() failed: %v", err)
+	}
+	if string(got) != "foobar" {
+		t.Errorf("ReadBlob() = %q, want %q", got, "foobar")
+	}
+}
@@ -0,0 +1,82 @@
+package client
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// UploadBandwidth is the maximum number of bytes per second the client sends on ByteStream
+// Write streams, across all of them, so that it leaves room on the network for other workloads.
+// Non-positive values, the default, mean no limit. Batch RPCs are not limited.
+type UploadBandwidth int64
+
+// Apply sets the upload bandwidth limit of a client.
+func (b UploadBandwidth) Apply(c *Client) {
+	c.uploadLimiter = newBandwidthLimiter(int64(b), time.Now)
+}
+
+// DownloadBandwidth is the maximum number of bytes per second the client receives on ByteStream
+// Read streams, across all of them. Non-positive values, the default, mean no limit. Batch RPCs
+// are not limited.
+type DownloadBandwidth int64
+
+// Apply sets the download bandwidth limit of a client.
+func (b DownloadBandwidth) Apply(c *Client) {
+	c.downloadLimiter = newBandwidthLimiter(int64(b), time.Now)
+}
+
+// bandwidthLimiter is a token bucket of bytes, refilled at a constant rate up to one second worth
+// of bytes. A transfer larger than the bucket, such as a chunk larger than the rate, goes into
+// debt, which delays the following transfers.
+type bandwidthLimiter struct {
+	rate float64
+	now  func() time.Time
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// newBandwidthLimiter returns a limiter of bytesPerSec, or nil, which does not limit, if it is not
+// positive.
+func newBandwidthLimiter(bytesPerSec int64, now func() time.Time) *bandwidthLimiter {
+	if bytesPerSec <= 0 {
+		return nil
+	}
+	return &bandwidthLimiter{rate: float64(bytesPerSec), now: now, tokens: float64(bytesPerSec), last: now()}
+}
+
+// reserve takes n bytes from the bucket and returns how long to wait before transferring them.
+func (l *bandwidthLimiter) reserve(n int) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	l.tokens = math.Min(l.rate, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	l.tokens -= float64(n)
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+// wait blocks until n bytes may be transferred, or ctx is done.
+func (l *bandwidthLimiter) wait(ctx context.Context, n int) error {
+	if l == nil {
+		return nil
+	}
+	d := l.reserve(n)
+	if d <= 0 {
+		return nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,49 @@
+package client
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestBandwidthLimiterReserve(t *testing.T) {
+	now := time.Unix(1000, 0)
+	l := newBandwidthLimiter(1000, func() time.Time { return now })
+	steps := []struct {
+		elapsed time.Duration
+		n       int
+		want    time.Duration
+	}{
+		// The bucket starts full.
+		{n: 600, want: 0},
+		{n: 400, want: 0},
+		{n: 500, want: 500 * time.Millisecond},
+		// The debt is paid off after half a second.
+		{elapsed: 500 * time.Millisecond, n: 100, want: 100 * time.Millisecond},
+		// The bucket holds at most a second worth of bytes.
+		{elapsed: 10 * time.Second, n: 1500, want: 500 * time.Millisecond},
+	}
+	for i, s := range steps {
+		now = now.Add(s.elapsed)
+		if got := l.reserve(s.n); got != s.want {
+			t.Errorf("step %d: reserve(%d) = %v, want %v", i, s.n, got, s.want)
+		}
+	}
+}
+
+func TestBandwidthLimiterWait(t *testing.T) {
+	if l := newBandwidthLimiter(0, time.Now); l != nil {
+		t.Errorf("newBandwidthLimiter(0) = %v, want nil", l)
+	}
+	var l *bandwidthLimiter
+	if err := l.wait(context.Background(), 1<<30); err != nil {
+		t.Errorf("wait() on a nil limiter failed: %v", err)
+	}
+
+	l = newBandwidthLimiter(1, time.Now)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := l.wait(ctx, 100); err != context.Canceled {
+		t.Errorf("wait() with a canceled context = %v, want %v", err, context.Canceled)
+	}
+}
@@ -0,0 +1,260 @@
+package client
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/filemetadata"
+)
+
+const (
+	// DefaultLocalTraversalConcurrency is the default number of files digested concurrently when
+	// building trees on local file systems.
+	DefaultLocalTraversalConcurrency = 32
+	// DefaultNetworkTraversalConcurrency is the default number of files digested concurrently when
+	// building trees on network file systems, where high parallelism collapses throughput.
+	DefaultNetworkTraversalConcurrency = 4
+)
+
+// TraversalConcurrency controls how many files are stat'ed and digested concurrently while
+// building input and output trees, and how many directories are read concurrently ahead of the
+// traversal. Files are still added to the tree in the same order.
+type TraversalConcurrency struct {
+	// Default is the concurrency for files not under any of the Overrides prefixes, and the number
+	// of directories read concurrently. If it is not positive, it is chosen by the type of the file
+	// system of the tree: DefaultNetworkTraversalConcurrency for network file systems, and
+	// DefaultLocalTraversalConcurrency otherwise.
+	Default int
+	// Overrides maps absolute path prefixes to the concurrency for the files under them, for trees
+	// mixing local and network file systems. The longest matching prefix applies.
+	Overrides map[string]int
+}
+
+// Apply sets the client's TraversalConcurrency.
+func (tc *TraversalConcurrency) Apply(c *Client) {
+	c.TraversalConcurrency = tc
+}
+
+// forDir returns the concurrency for the files in the directory at the absolute path dir, given the
+// default for the root of the tree.
+func (tc *TraversalConcurrency) forDir(dir string, rootDefault int) int {
+	best, n := -1, rootDefault
+	if tc != nil {
+		if tc.Default > 0 {
+			n = tc.Default
+		}
+		for prefix, c := range tc.Overrides {
+			prefix = filepath.Clean(prefix)
+			if (dir == prefix || strings.HasPrefix(dir, prefix+string(filepath.Separator))) && len(prefix) > best {
+				best, n = len(prefix), c
+			}
+		}
+	}
+	if n < 1 {
+		return 1
+	}
+	return n
+}
+
+// defaultTraversalConcurrency returns the file system aware default concurrency for the tree at root.
+func defaultTraversalConcurrency(root string) int {
+	if isNetworkFS(root) {
+		return DefaultNetworkTraversalConcurrency
+	}
+	return DefaultLocalTraversalConcurrency
+}
+
+// metadataPrefetcher reads the directories of a tree and computes the metadata of their files
+// concurrently, ahead of the sequential traversal consuming them. Once a directory is read, its
+// subdirectories are queued to a bounded pool of walkers, so that a large tree is read in parallel
+// rather than one directory at a time. Each listing is only written by the goroutine reading it,
+// and published through its ready flag, so that the traversal reads it without locking.
+type metadataPrefetcher struct {
+	cache       filemetadata.Cache
+	conc        *TraversalConcurrency
+	rootDefault int
+	// walkers is the number of directories read concurrently ahead of the traversal.
+	walkers int
+	// skipDir, if set, selects the directories the traversal skips, which are not read ahead.
+	skipDir func(path string) bool
+
+	// listings holds a *dirListing by absolute path.
+	listings sync.Map
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	queue   []*dirListing
+	started bool
+	closed  bool
+	wg      sync.WaitGroup
+}
+
+// dirListing is a directory read by the metadataPrefetcher.
+type dirListing struct {
+	path  string
+	once  sync.Once
+	ready atomic.Bool
+	names []string
+	// info is the state of the directory before it was read.
+	info os.FileInfo
+	// metas has the metadata of the files by name, if it was computed ahead.
+	metas map[string]*filemetadata.Metadata
+	err   error
+}
+
+func (c *Client) newMetadataPrefetcher(root string, cache filemetadata.Cache) *metadataPrefetcher {
+	rootDefault := 1
+	if c.TraversalConcurrency == nil || c.TraversalConcurrency.Default <= 0 {
+		rootDefault = defaultTraversalConcurrency(root)
+	}
+	p := &metadataPrefetcher{
+		cache:       cache,
+		conc:        c.TraversalConcurrency,
+		rootDefault: rootDefault,
+		walkers:     c.TraversalConcurrency.forDir(root, rootDefault),
+	}
+	if c.TraversalConcurrency != nil && c.TraversalConcurrency.Default > 0 {
+		p.walkers = c.TraversalConcurrency.Default
+	}
+	p.cond = sync.NewCond(&p.mu)
+	return p
+}
+
+// list returns the names of the files in the directory at the absolute path dir, and the state
+// of the directory before they were read, reading it unless it was read ahead already.
+func (p *metadataPrefetcher) list(dir string) ([]string, os.FileInfo, error) {
+	l := p.listing(dir)
+	l.once.Do(func() { p.read(l) })
+	return l.names, l.info, l.err
+}
+
+func (p *metadataPrefetcher) listing(dir string) *dirListing {
+	l, _ := p.listings.LoadOrStore(dir, &dirListing{path: dir})
+	return l.(*dirListing)
+}
+
+// read reads the directory of l and the metadata of its files, and queues its subdirectories.
+func (p *metadataPrefetcher) read(l *dirListing) {
+	defer l.ready.Store(true)
+	f, err := os.Open(l.path)
+	if err != nil {
+		l.err = err
+		return
+	}
+	if l.info, l.err = f.Stat(); l.err != nil {
+		f.Close()
+		return
+	}
+	l.names, l.err = f.Readdirnames(-1)
+	f.Close()
+	if l.err != nil {
+		return
+	}
+	l.metas = p.stat(l.path, l.names)
+	if p.walkers <= 1 {
+		return
+	}
+	var subdirs []*dirListing
+	for name, md := range l.metas {
+		path := filepath.Join(l.path, name)
+		if md.IsDirectory && md.Symlink == nil && md.Err == nil && (p.skipDir == nil || !p.skipDir(path)) {
+			subdirs = append(subdirs, p.listing(path))
+		}
+	}
+	p.enqueue(subdirs)
+}
+
+// stat computes the metadata of the given files in the directory at the absolute path dir.
+func (p *metadataPrefetcher) stat(dir string, names []string) map[string]*filemetadata.Metadata {
+	n := p.conc.forDir(dir, p.rootDefault)
+	if p.walkers <= 1 && (n <= 1 || len(names) <= 1) {
+		return nil
+	}
+	metas := make([]*filemetadata.Metadata, len(names))
+	sem := make(chan struct{}, n)
+	var wg sync.WaitGroup
+	for i, name := range names {
+		i, name := i, name
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() { <-sem; wg.Done() }()
+			metas[i] = p.cache.Get(filepath.Join(dir, name))
+		}()
+	}
+	wg.Wait()
+	res := make(map[string]*filemetadata.Metadata, len(names))
+	for i, name := range names {
+		res[name] = metas[i]
+	}
+	return res
+}
+
+// enqueue queues the directories to be read by the walkers, starting them if needed.
+func (p *metadataPrefetcher) enqueue(ls []*dirListing) {
+	if len(ls) == 0 {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return
+	}
+	p.queue = append(p.queue, ls...)
+	if !p.started {
+		p.started = true
+		for i := 0; i < p.walkers; i++ {
+			p.wg.Add(1)
+			go p.walk()
+		}
+	}
+	p.cond.Broadcast()
+}
+
+// walk reads the queued directories until the prefetcher is closed.
+func (p *metadataPrefetcher) walk() {
+	defer p.wg.Done()
+	for {
+		p.mu.Lock()
+		for len(p.queue) == 0 && !p.closed {
+			p.cond.Wait()
+		}
+		if p.closed {
+			p.mu.Unlock()
+			return
+		}
+		l := p.queue[0]
+		p.queue = p.queue[1:]
+		p.mu.Unlock()
+		l.once.Do(func() { p.read(l) })
+	}
+}
+
+// close stops reading ahead, and waits for the walkers to finish.
+func (p *metadataPrefetcher) close() {
+	p.mu.Lock()
+	p.closed = true
+	p.queue = nil
+	p.cond.Broadcast()
+	p.mu.Unlock()
+	p.wg.Wait()
+}
+
+// get returns the metadata of the file at the absolute path, prefetched if possible. Prefetched
+// metadata is only used once. Only the traversal reads the listings once they are ready, so it
+// can consume them.
+func (p *metadataPrefetcher) get(path string) *filemetadata.Metadata {
+	if l, ok := p.listings.Load(filepath.Dir(path)); ok {
+		if l := l.(*dirListing); l.ready.Load() {
+			name := filepath.Base(path)
+			if md, ok := l.metas[name]; ok {
+				delete(l.metas, name)
+				return md
+			}
+		}
+	}
+	return p.cache.Get(path)
+}
@@ -6,7 +6,6 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
-	"regexp"
 	"sort"
 	"strings"
 
@@ -16,9 +15,13 @@ import (
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/filemetadata"
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/uploadinfo"
 	"github.com/pkg/errors"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
 
 	repb "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
 	log "github.com/golang/glog"
+	tspb "google.golang.org/protobuf/types/known/timestamppb"
+	wrapperspb "google.golang.org/protobuf/types/known/wrapperspb"
 )
 
 // treeNode represents a file tree, which is an intermediate representation used to encode a Merkle
@@ -27,6 +30,8 @@ import (
 type treeNode struct {
 	leaves   map[string]*fileSysNode
 	children map[string]*treeNode
+	// cached, if set, is the packed directory from the DirectoryCache, which replaces the contents.
+	cached *cachedDir
 }
 
 type fileNode struct {
@@ -43,6 +48,8 @@ type fileSysNode struct {
 	emptyDirectoryMarker bool
 	symlink              *symlinkNode
 	nodeProperties       *cpb.NodeProperties
+	// cachedDir, if set, is a whole directory from the DirectoryCache.
+	cachedDir *cachedDir
 }
 
 // TreeStats contains various stats/metadata of the constructed Merkle tree.
@@ -58,6 +65,9 @@ type TreeStats struct {
 	InputSymlinks int
 	// The overall number of bytes from all the inputs.
 	TotalInputBytes int64
+	// The number of inputs skipped by InputExclusions. An excluded directory counts once, and the
+	// exclusions in directories reused from a DirectoryCache are not counted.
+	ExcludedInputs int
 	// TODO(olaola): number of FileMetadata cache hits/misses go here.
 }
 
@@ -73,6 +83,14 @@ type TreeSymlinkOpts struct {
 	// symlinks that point to files within the exec root.  Has no effect if
 	// Preserved=false, as all symlinks are materialized.
 	MaterializeOutsideExecRoot bool
+	// If true, dangling symlinks are an error, rather than being skipped when
+	// materialized, or kept when preserved.
+	NoDangling bool
+	// If true, preserved symlinks with absolute targets outside the exec root
+	// keep their targets as they are, rather than being an error or being
+	// materialized. Has no effect unless the server allows absolute targets,
+	// see Client.SupportsAbsoluteSymlinks.
+	AbsoluteTargets bool
 }
 
 // DefaultTreeSymlinkOpts returns a default DefaultTreeSymlinkOpts object.
@@ -82,10 +100,17 @@ func DefaultTreeSymlinkOpts() *TreeSymlinkOpts {
 	}
 }
 
-// treeSymlinkOpts returns a TreeSymlinkOpts object based on the given SymlinkBehaviorType.
-func treeSymlinkOpts(opts *TreeSymlinkOpts, sb command.SymlinkBehaviorType) *TreeSymlinkOpts {
-	if opts == nil {
-		opts = DefaultTreeSymlinkOpts()
+// treeSymlinkOpts returns the client's TreeSymlinkOpts, adjusted to the given SymlinkBehaviorType
+// and to the capabilities of the server.
+func (c *Client) treeSymlinkOpts(sb command.SymlinkBehaviorType) *TreeSymlinkOpts {
+	opts := DefaultTreeSymlinkOpts()
+	if c.TreeSymlinkOpts != nil {
+		o := *c.TreeSymlinkOpts
+		opts = &o
+	}
+	if opts.AbsoluteTargets && !c.SupportsAbsoluteSymlinks() {
+		log.V(2).Infof("The server does not allow absolute symlink targets, ignoring TreeSymlinkOpts.AbsoluteTargets")
+		opts.AbsoluteTargets = false
 	}
 	switch sb {
 	case command.ResolveSymlink:
@@ -96,19 +121,6 @@ func treeSymlinkOpts(opts *TreeSymlinkOpts, sb command.SymlinkBehaviorType) *Tre
 	return opts
 }
 
-// shouldIgnore returns whether a given input should be excluded based on the given InputExclusions,
-func shouldIgnore(inp string, t command.InputType, excl []*command.InputExclusion) bool {
-	for _, r := range excl {
-		if r.Type != command.UnspecifiedInputType && r.Type != t {
-			continue
-		}
-		if m, _ := regexp.MatchString(r.Regex, inp); m {
-			return true
-		}
-	}
-	return false
-}
-
 // shouldIgnoreErr returns whether a given error should be ignored.
 func shouldIgnoreErr(err error) bool {
 	// We should skip files without read permissions. If the user doesn't have read permissions,
@@ -277,14 +289,35 @@ func loadIntermediateSymlinks(symlinks []string, execRoot, workingDir, remoteWor
 
 // loadFiles reads all files specified by the given InputSpec (descending into subdirectories
 // recursively), and loads their contents into the provided map.
-func loadFiles(execRoot, localWorkingDir, remoteWorkingDir string, excl []*command.InputExclusion, filesToProcess []string, fs map[string]*fileSysNode, cache filemetadata.Cache, opts *TreeSymlinkOpts, nodeProperties map[string]*cpb.NodeProperties) error {
+// The directories are read and the metadata of their files is computed concurrently by pf, ahead
+// of the traversal, and the directories selected by mf are skipped. If sp is set, the tree is
+// read depth-first, and sp packs the directories as soon as they are read.
+func loadFiles(execRoot, localWorkingDir, remoteWorkingDir string, ex *inputExcluder, filesToProcess []string, fs map[string]*fileSysNode, pf *metadataPrefetcher, mf *mountFilter, opts *TreeSymlinkOpts, nodeProperties map[string]*cpb.NodeProperties, preserveEmpty bool, dirs *dirCacheLookup, sp *spillTraversal, emit func(*uploadinfo.Entry)) error {
 	if opts == nil {
 		opts = DefaultTreeSymlinkOpts()
 	}
+	cache := pf.cache
+	pf.skipDir = func(path string) bool {
+		return ex.excludes(path, command.DirectoryInputType) || mf.skip(path) || dirs.has(path)
+	}
+	defer pf.close()
+	add := func(remote string, n *fileSysNode) {
+		fs[remote] = n
+		sp.log(remote)
+		if n.file != nil && emit != nil {
+			emit(n.file.ue)
+		}
+	}
 
 	for len(filesToProcess) != 0 {
-		relPath := filesToProcess[0]
-		filesToProcess = filesToProcess[1:]
+		var relPath string
+		if sp != nil {
+			relPath = filesToProcess[len(filesToProcess)-1]
+			filesToProcess = filesToProcess[:len(filesToProcess)-1]
+		} else {
+			relPath = filesToProcess[0]
+			filesToProcess = filesToProcess[1:]
+		}
 
 		if relPath == "" {
 			return errors.New("empty Input, use \".\" for entire exec root")
@@ -305,25 +338,44 @@ func loadFiles(execRoot, localWorkingDir, remoteWorkingDir string, excl []*comma
 		if err != nil {
 			return err
 		}
+		if skip, err := sp.enter(normPath); err != nil {
+			return err
+		} else if skip {
+			continue
+		}
 		np := nodeProperties[remoteNormPath]
-		meta := cache.Get(absPath)
+		meta := pf.get(absPath)
 
 		// An implication of this is that, if a path is a symlink to a
 		// directory, then the symlink attribute takes precedence.
 		if meta.Symlink != nil && meta.Symlink.IsDangling && !opts.Preserved {
-			// For now, we do not treat a dangling symlink as an error. In the case
-			// where the symlink is not preserved (i.e. needs to be converted to a
-			// file), we simply ignore this path in the finalized tree.
+			// Unless NoDangling is set, we do not treat a dangling symlink as an
+			// error. In the case where the symlink is not preserved (i.e. needs to be
+			// converted to a file), we simply ignore this path in the finalized tree.
+			if opts.NoDangling {
+				return errors.Errorf("failed to materialize dangling symlink %q with target %q", normPath, meta.Symlink.Target)
+			}
 			continue
 		} else if meta.Symlink != nil && opts.Preserved {
-			if shouldIgnore(absPath, command.SymlinkInputType, excl) {
+			if ex.skip(absPath, command.SymlinkInputType) {
 				continue
 			}
+			if meta.Symlink.IsDangling && opts.NoDangling {
+				return errors.Errorf("dangling symlink %q with target %q is not allowed", normPath, meta.Symlink.Target)
+			}
 			targetExecRoot, targetSymDir, err := getTargetRelPath(execRoot, normPath, meta.Symlink.Target)
 			if err != nil {
-				// The symlink points to a file outside the exec root. This is an
+				// The symlink points to a file outside the exec root. Its absolute
+				// target is kept if allowed by the server. Otherwise, this is an
 				// error unless materialization of symlinks pointing outside the
 				// exec root is enabled.
+				if opts.AbsoluteTargets && filepath.IsAbs(meta.Symlink.Target) {
+					add(remoteNormPath, &fileSysNode{
+						symlink:        &symlinkNode{target: meta.Symlink.Target},
+						nodeProperties: np,
+					})
+					continue
+				}
 				if !opts.MaterializeOutsideExecRoot {
 					return errors.Wrapf(err, "failed to determine the target of symlink %q as a child of %q", normPath, execRoot)
 				}
@@ -333,14 +385,14 @@ func loadFiles(execRoot, localWorkingDir, remoteWorkingDir string, excl []*comma
 				goto processNonSymlink
 			}
 
-			fs[remoteNormPath] = &fileSysNode{
+			add(remoteNormPath, &fileSysNode{
 				// We cannot directly use meta.Symlink.Target, because it could be
 				// an absolute path. Since the remote worker will map the exec root
 				// to a different directory, we must strip away the local exec root.
 				// See https://github.com/bazelbuild/remote-apis-sdks/pull/229#discussion_r524830458
 				symlink:        &symlinkNode{target: targetSymDir},
 				nodeProperties: np,
-			}
+			})
 
 			if !meta.Symlink.IsDangling && opts.FollowsTarget {
 				// getTargetRelPath validates this target is under execRoot,
@@ -355,8 +407,12 @@ func loadFiles(execRoot, localWorkingDir, remoteWorkingDir string, excl []*comma
 
 	processNonSymlink:
 		log.V(3).Infof("loadFiles.non-sl: path=%s", relPath)
+		if meta.Symlink != nil {
+			// The contents of materialized symlinks are not invalidated along with their directory.
+			dirs.exclude(filepath.Dir(remoteNormPath))
+		}
 		if meta.IsDirectory {
-			if shouldIgnore(absPath, command.DirectoryInputType, excl) {
+			if ex.skip(absPath, command.DirectoryInputType) || mf.skip(absPath) {
 				continue
 			} else if meta.Err != nil {
 				if shouldIgnoreErr(meta.Err) {
@@ -364,29 +420,28 @@ func loadFiles(execRoot, localWorkingDir, remoteWorkingDir string, excl []*comma
 				}
 				return meta.Err
 			}
-
-			f, err := os.Open(absPath)
-			if err != nil {
-				return err
+			if cd := dirs.get(remoteNormPath, absPath); cd != nil {
+				fs[remoteNormPath] = &fileSysNode{cachedDir: cd}
+				continue
 			}
+			dirs.walk(remoteNormPath, absPath)
+			sp.walk(normPath, remoteNormPath)
 
-			files, err := f.Readdirnames(-1)
-			f.Close()
+			files, info, err := pf.list(absPath)
 			if err != nil {
 				return err
 			}
+			dirs.stamp(absPath, info)
 
-			if len(files) == 0 {
-				if normPath != "." {
-					fs[remoteNormPath] = &fileSysNode{emptyDirectoryMarker: true, nodeProperties: np}
-				}
-				continue
+			if normPath != "." && (len(files) == 0 || preserveEmpty) {
+				// The marker keeps the directory even if all its contents are skipped.
+				add(remoteNormPath, &fileSysNode{emptyDirectoryMarker: true, nodeProperties: np})
 			}
 			for _, f := range files {
 				filesToProcess = append(filesToProcess, filepath.Join(normPath, f))
 			}
 		} else {
-			if shouldIgnore(absPath, command.FileInputType, excl) {
+			if ex.skip(absPath, command.FileInputType) {
 				continue
 			} else if meta.Err != nil {
 				if shouldIgnoreErr(meta.Err) {
@@ -395,10 +450,64 @@ func loadFiles(execRoot, localWorkingDir, remoteWorkingDir string, excl []*comma
 				return meta.Err
 			}
 
-			fs[remoteNormPath] = &fileSysNode{
+			dirs.stampFile(remoteNormPath, absPath, meta.MTime)
+			add(remoteNormPath, &fileSysNode{
 				file: &fileNode{
 					ue:           uploadinfo.EntryFromFile(meta.Digest, absPath),
-					isExecutable: meta.IsExecutable,
+					isExecutable: isExecutable(np, meta.IsExecutable),
+				},
+				nodeProperties: np,
+			})
+		}
+	}
+	return sp.finish()
+}
+
+// isExecutable returns whether an input file is executable. The UNIX mode in its node properties,
+// if set, overrides the executability reported by the file system, which some virtual file
+// systems do not expose correctly.
+func isExecutable(np *cpb.NodeProperties, executable bool) bool {
+	if mode := np.GetUnixMode(); mode != nil {
+		return mode.GetValue()&0111 != 0
+	}
+	return executable
+}
+
+// loadTreeInput adds the contents of the Tree with the given digest to fs, as the directory at
+// normPath. Only the Tree is read from the CAS: its files are referenced by digest. The node
+// properties in the Tree are kept, unless overridden by those in nodeProperties.
+func (c *Client) loadTreeInput(ctx context.Context, treeDigest, execRoot, normPath, remoteNormPath string, nodeProperties map[string]*cpb.NodeProperties, fs map[string]*fileSysNode) error {
+	dg, err := digest.NewFromString(treeDigest)
+	if err != nil {
+		return err
+	}
+	tree := &repb.Tree{}
+	if _, err := c.ReadProto(ctx, dg, tree); err != nil {
+		return fmt.Errorf("failed to read tree %s: %w", dg, err)
+	}
+	outs, err := c.FlattenTree(tree, "")
+	if err != nil {
+		return err
+	}
+	for _, out := range outs {
+		remotePath := filepath.Join(remoteNormPath, out.Path)
+		np, ok := nodeProperties[remotePath]
+		if !ok {
+			np = command.NodePropertiesFromAPI(out.NodeProperties)
+		}
+		switch {
+		case out.IsEmptyDirectory:
+			if remotePath != "." {
+				fs[remotePath] = &fileSysNode{emptyDirectoryMarker: true, nodeProperties: np}
+			}
+		case out.SymlinkTarget != "":
+			fs[remotePath] = &fileSysNode{symlink: &symlinkNode{target: out.SymlinkTarget}, nodeProperties: np}
+		default:
+			absPath := filepath.Join(execRoot, normPath, out.Path)
+			fs[remotePath] = &fileSysNode{
+				file: &fileNode{
+					ue:           uploadinfo.EntryFromVirtualFile(out.Digest, absPath),
+					isExecutable: isExecutable(np, out.IsExecutable),
 				},
 				nodeProperties: np,
 			}
@@ -407,11 +516,22 @@ func loadFiles(execRoot, localWorkingDir, remoteWorkingDir string, excl []*comma
 	return nil
 }
 
-// ComputeMerkleTree packages an InputSpec into uploadable inputs, returned as uploadinfo.Entrys
+// ComputeMerkleTree packages an InputSpec into uploadable inputs, returned as uploadinfo.Entrys.
+// It walks the inputs of the spec under execRoot, skipping the InputExclusions, adds its
+// VirtualInputs, and builds the Directory protos of the tree bottom-up. The inputs are keyed by
+// digest, so that identical files and subtrees are only returned once. The stats count the
+// files, directories and symlinks of the tree, and their total bytes.
 func (c *Client) ComputeMerkleTree(ctx context.Context, execRoot, workingDir, remoteWorkingDir string, is *command.InputSpec, cache filemetadata.Cache) (root digest.Digest, inputs []*uploadinfo.Entry, stats *TreeStats, err error) {
+	return c.computeMerkleTree(ctx, execRoot, workingDir, remoteWorkingDir, is, cache, nil)
+}
+
+// computeMerkleTree implements ComputeMerkleTree. If emit is set, the entries of the input files
+// are passed to it as the traversal finds them, ahead of the returned inputs.
+func (c *Client) computeMerkleTree(ctx context.Context, execRoot, workingDir, remoteWorkingDir string, is *command.InputSpec, cache filemetadata.Cache, emit func(*uploadinfo.Entry)) (root digest.Digest, inputs []*uploadinfo.Entry, stats *TreeStats, err error) {
 	stats = &TreeStats{}
 	fs := make(map[string]*fileSysNode)
-	slOpts := treeSymlinkOpts(c.TreeSymlinkOpts, is.SymlinkBehavior)
+	cache = c.FileMetadataCache(c.withOutputService(cache))
+	slOpts := c.treeSymlinkOpts(is.SymlinkBehavior)
 	for _, i := range is.VirtualInputs {
 		if i.Path == "" {
 			return digest.Empty, nil, nil, errors.New("empty Path in VirtualInputs")
@@ -439,6 +559,15 @@ func (c *Client) ComputeMerkleTree(ctx context.Context, execRoot, workingDir, re
 			}
 			continue
 		}
+		if i.TreeDigest != "" {
+			if i.Digest != "" || len(i.Contents) > 0 {
+				return digest.Empty, nil, nil, errors.New("tree digest cannot be provided together with a digest or file content for the same virtual input")
+			}
+			if err := c.loadTreeInput(ctx, i.TreeDigest, execRoot, normPath, remoteNormPath, is.InputNodeProperties, fs); err != nil {
+				return digest.Empty, nil, nil, err
+			}
+			continue
+		}
 		if i.Digest != "" && len(i.Contents) > 0 {
 			return digest.Empty, nil, nil, errors.New("digest and file content cannot be provided for the same virtual input")
 		}
@@ -451,28 +580,52 @@ func (c *Client) ComputeMerkleTree(ctx context.Context, execRoot, workingDir, re
 			absPath := filepath.Join(execRoot, normPath)
 			entry = uploadinfo.EntryFromVirtualFile(dg, absPath)
 		} else {
-			entry = uploadinfo.EntryFromBlob(i.Contents)
+			entry = uploadinfo.EntryFromBlobWith(c.digestFn, i.Contents)
 		}
 		fs[remoteNormPath] = &fileSysNode{
 			file: &fileNode{
 				ue:           entry,
-				isExecutable: i.IsExecutable,
+				isExecutable: isExecutable(np, i.IsExecutable),
 			},
 			nodeProperties: np,
 		}
+		if emit != nil {
+			emit(entry)
+		}
 	}
-	if err := loadFiles(execRoot, workingDir, remoteWorkingDir, is.InputExclusions, is.Inputs, fs, cache, slOpts, is.InputNodeProperties); err != nil {
+	ex, err := newInputExcluder(execRoot, is.InputExclusions)
+	if err != nil {
+		return digest.Empty, nil, nil, err
+	}
+	blobs := make(map[digest.Digest]*uploadinfo.Entry)
+	p := &treePackager{digestFn: c.digestFn, stats: stats, blobs: blobs, spillDir: string(c.TreeSpillDir)}
+	var sp *spillTraversal
+	if c.TreeSpillDir == "" {
+		p.dirs = c.DirectoryCache.newLookup(execRoot, slOpts, bool(c.PreserveEmptyDirs), is.InputExclusions, fs, is.InputNodeProperties)
+		defer p.dirs.done()
+	} else {
+		defer func() {
+			if err != nil {
+				p.removeSpilled()
+			}
+		}()
+		if !slOpts.Preserved || !slOpts.FollowsTarget {
+			sp = newSpillTraversal(p, fs)
+		}
+	}
+	if err := loadFiles(execRoot, workingDir, remoteWorkingDir, ex, is.Inputs, fs, c.newMetadataPrefetcher(execRoot, cache), newMountFilter(c.TreeMountOpts, execRoot), slOpts, is.InputNodeProperties, bool(c.PreserveEmptyDirs), p.dirs, sp, emit); err != nil {
 		return digest.Empty, nil, nil, err
 	}
 	ft, err := buildTree(fs)
 	if err != nil {
 		return digest.Empty, nil, nil, err
 	}
-	var blobs map[digest.Digest]*uploadinfo.Entry
-	root, blobs, err = packageTree(ft, stats)
+	root, err = p.pack(ft, ".")
 	if err != nil {
 		return digest.Empty, nil, nil, err
 	}
+	p.dirs.store()
+	stats.ExcludedInputs = ex.count()
 	for _, ue := range blobs {
 		inputs = append(inputs, ue)
 	}
@@ -482,6 +635,11 @@ func (c *Client) ComputeMerkleTree(ctx context.Context, execRoot, workingDir, re
 func buildTree(files map[string]*fileSysNode) (*treeNode, error) {
 	root := &treeNode{}
 	for name, fn := range files {
+		if name == "." && fn.cachedDir != nil {
+			root.cached = fn.cachedDir
+			delete(files, name)
+			continue
+		}
 		segs := strings.Split(name, string(filepath.Separator))
 		// The last segment is the filename, so split it off.
 		segs, base := segs[0:len(segs)-1], segs[len(segs)-1]
@@ -499,38 +657,61 @@ func buildTree(files map[string]*fileSysNode) (*treeNode, error) {
 			node = child
 		}
 
-		if fn.emptyDirectoryMarker {
+		if fn.emptyDirectoryMarker || fn.cachedDir != nil {
 			if node.children == nil {
 				node.children = make(map[string]*treeNode)
 			}
 			if node.children[base] == nil {
 				node.children[base] = &treeNode{}
 			}
+			if fn.cachedDir != nil {
+				node.children[base].cached = fn.cachedDir
+				delete(files, name)
+			}
 			continue
 		}
 		if node.leaves == nil {
 			node.leaves = make(map[string]*fileSysNode)
 		}
 		node.leaves[base] = fn
+		// The flat map is consumed, so that it is not held alongside the whole tree.
+		delete(files, name)
 	}
 	return root, nil
 }
 
-// If tree is not nil, it will be populated with a flattened tree of path->digest.
-// prefix should always be provided as an empty string which will be used to accumolate path prefixes during recursion.
-func packageTree(t *treeNode, stats *TreeStats) (root digest.Digest, blobs map[digest.Digest]*uploadinfo.Entry, err error) {
-	dir := &repb.Directory{}
-	blobs = make(map[digest.Digest]*uploadinfo.Entry)
+// treePackager encodes a tree into Directory protos, collecting the blobs of the tree.
+type treePackager struct {
+	// digestFn is the digest function the Directory protos are digested with.
+	digestFn digest.Function
+	stats    *TreeStats
+	blobs    map[digest.Digest]*uploadinfo.Entry
+	// spillDir, if set, is the directory the Directory protos are written to, rather than kept in
+	// memory. The packaged subtrees are then released as well.
+	spillDir string
+	// spilled are the files the packager created in spillDir.
+	spilled []string
+	// dirs, if set, is the lookup of the DirectoryCache the directories read whole are added to.
+	dirs *dirCacheLookup
+}
 
+// pack encodes the tree at the remote path, and returns the digest of its Directory proto.
+func (p *treePackager) pack(t *treeNode, path string) (digest.Digest, error) {
+	if cd := t.cached; cd != nil {
+		cd.addBlobs(p.blobs)
+		p.stats.add(&cd.stats)
+		return cd.dir.Digest, nil
+	}
+	before := *p.stats
+	dir := &repb.Directory{}
 	for name, child := range t.children {
-		dg, childBlobs, err := packageTree(child, stats)
+		dg, err := p.pack(child, filepath.Join(path, name))
 		if err != nil {
-			return digest.Empty, nil, err
+			return digest.Empty, err
 		}
-
 		dir.Directories = append(dir.Directories, &repb.DirectoryNode{Name: name, Digest: dg.ToProto()})
-		for d, b := range childBlobs {
-			blobs[d] = b
+		if p.spillDir != "" {
+			delete(t.children, name)
 		}
 	}
 	sort.Slice(dir.Directories, func(i, j int) bool { return dir.Directories[i].Name < dir.Directories[j].Name })
@@ -540,29 +721,71 @@ func packageTree(t *treeNode, stats *TreeStats) (root digest.Digest, blobs map[d
 		if n.file != nil {
 			dg := n.file.ue.Digest
 			dir.Files = append(dir.Files, &repb.FileNode{Name: name, Digest: dg.ToProto(), IsExecutable: n.file.isExecutable, NodeProperties: command.NodePropertiesToAPI(n.nodeProperties)})
-			blobs[dg] = n.file.ue
-			stats.InputFiles++
-			stats.TotalInputBytes += dg.Size
+			p.blobs[dg] = n.file.ue
+			p.stats.InputFiles++
+			p.stats.TotalInputBytes += dg.Size
 			continue
 		}
 		if n.symlink != nil {
 			dir.Symlinks = append(dir.Symlinks, &repb.SymlinkNode{Name: name, Target: n.symlink.target, NodeProperties: command.NodePropertiesToAPI(n.nodeProperties)})
-			stats.InputSymlinks++
+			p.stats.InputSymlinks++
 		}
 	}
 
 	sort.Slice(dir.Files, func(i, j int) bool { return dir.Files[i].Name < dir.Files[j].Name })
 	sort.Slice(dir.Symlinks, func(i, j int) bool { return dir.Symlinks[i].Name < dir.Symlinks[j].Name })
 
-	ue, err := uploadinfo.EntryFromProto(dir)
+	ue, err := uploadinfo.EntryFromProtoWith(p.digestFn, dir)
 	if err != nil {
-		return digest.Empty, nil, err
+		return digest.Empty, err
+	}
+	if p.spillDir != "" {
+		var created bool
+		if ue, created, err = spillEntry(ue, p.spillDir); err != nil {
+			return digest.Empty, err
+		}
+		if created {
+			p.spilled = append(p.spilled, ue.Path)
+		}
 	}
 	dg := ue.Digest
-	blobs[dg] = ue
-	stats.TotalInputBytes += dg.Size
-	stats.InputDirectories++
-	return dg, blobs, nil
+	p.blobs[dg] = ue
+	p.stats.TotalInputBytes += dg.Size
+	p.stats.InputDirectories++
+	p.cache(t, path, ue, before)
+	return dg, nil
+}
+
+// cache records the packed directory at the remote path in the DirectoryCache lookup, if it was
+// read whole and so were all its subdirectories.
+func (p *treePackager) cache(t *treeNode, path string, ue *uploadinfo.Entry, before TreeStats) {
+	if p.dirs == nil {
+		return
+	}
+	abs, ok := p.dirs.walked[path]
+	if !ok {
+		return
+	}
+	cd := &cachedDir{dir: ue, stats: p.stats.sub(&before), stamps: make(map[string]os.FileInfo)}
+	for _, child := range t.children {
+		if child.cached == nil {
+			return
+		}
+		cd.subdirs = append(cd.subdirs, child.cached)
+	}
+	if !p.dirs.addStamp(cd, abs) {
+		return
+	}
+	for _, n := range t.leaves {
+		if n.file != nil {
+			if !p.dirs.addStamp(cd, n.file.ue.Path) {
+				return
+			}
+			cd.files = append(cd.files, n.file.ue)
+		}
+	}
+	t.cached = cd
+	p.dirs.packed[abs] = cd
 }
 
 // TreeOutput represents a leaf output node in a nested directory structure (a file, a symlink, or an empty directory).
@@ -573,29 +796,31 @@ type TreeOutput struct {
 	IsEmptyDirectory bool
 	SymlinkTarget    string
 	NodeProperties   *repb.NodeProperties
+	// Contents, if set, are the contents of the file inlined by the server.
+	Contents []byte
 }
 
 // FlattenTree takes a Tree message and calculates the relative paths of all the files to
 // the tree root. Note that only files/symlinks/empty directories are included in the returned slice,
 // not the intermediate directories. Directories containing only other directories will be omitted.
 func (c *Client) FlattenTree(tree *repb.Tree, rootPath string) (map[string]*TreeOutput, error) {
-	root, err := digest.NewFromMessage(tree.Root)
+	root, err := c.digestFn.NewFromMessage(tree.Root)
 	if err != nil {
 		return nil, err
 	}
 	dirs := make(map[digest.Digest]*repb.Directory)
 	dirs[root] = tree.Root
 	for _, ue := range tree.Children {
-		dg, e := digest.NewFromMessage(ue)
+		dg, e := c.digestFn.NewFromMessage(ue)
 		if e != nil {
 			return nil, e
 		}
 		dirs[dg] = ue
 	}
-	return flattenTree(root, rootPath, dirs)
+	return flattenTree(c.digestFn, root, rootPath, dirs)
 }
 
-func flattenTree(root digest.Digest, rootPath string, dirs map[digest.Digest]*repb.Directory) (map[string]*TreeOutput, error) {
+func flattenTree(fn digest.Function, root digest.Digest, rootPath string, dirs map[digest.Digest]*repb.Directory) (map[string]*TreeOutput, error) {
 	// Create a queue of unprocessed directories, along with their flattened
 	// path names.
 	type queueElem struct {
@@ -620,7 +845,7 @@ func flattenTree(root digest.Digest, rootPath string, dirs map[digest.Digest]*re
 		if len(dir.Files)+len(dir.Directories)+len(dir.Symlinks) == 0 {
 			flatFiles[flatDir.p] = &TreeOutput{
 				Path:             flatDir.p,
-				Digest:           digest.Empty,
+				Digest:           fn.Empty(),
 				IsEmptyDirectory: true,
 				NodeProperties:   dir.NodeProperties,
 			}
@@ -657,11 +882,13 @@ func flattenTree(root digest.Digest, rootPath string, dirs map[digest.Digest]*re
 	return flatFiles, nil
 }
 
-func packageDirectories(t *treeNode) (root *repb.Directory, files map[digest.Digest]*uploadinfo.Entry, treePb *repb.Tree, err error) {
+// packageDirectories encodes the tree into its root Directory, the files in it, and its descendant
+// directories, returned as entries digested with fn so that their serialized bytes are reused
+// rather than marshaled again.
+func packageDirectories(fn digest.Function, t *treeNode) (root *repb.Directory, files map[digest.Digest]*uploadinfo.Entry, children []*uploadinfo.Entry, err error) {
 	root = &repb.Directory{}
 	files = make(map[digest.Digest]*uploadinfo.Entry)
 	childDirs := make([]string, 0, len(t.children))
-	treePb = &repb.Tree{}
 
 	for name := range t.children {
 		childDirs = append(childDirs, name)
@@ -670,11 +897,11 @@ func packageDirectories(t *treeNode) (root *repb.Directory, files map[digest.Dig
 
 	for _, name := range childDirs {
 		child := t.children[name]
-		chRoot, childFiles, chTree, err := packageDirectories(child)
+		chRoot, childFiles, chChildren, err := packageDirectories(fn, child)
 		if err != nil {
 			return nil, nil, nil, err
 		}
-		ue, err := uploadinfo.EntryFromProto(chRoot)
+		ue, err := uploadinfo.EntryFromProtoWith(fn, chRoot)
 		if err != nil {
 			return nil, nil, nil, err
 		}
@@ -683,8 +910,8 @@ func packageDirectories(t *treeNode) (root *repb.Directory, files map[digest.Dig
 		for d, b := range childFiles {
 			files[d] = b
 		}
-		treePb.Children = append(treePb.Children, chRoot)
-		treePb.Children = append(treePb.Children, chTree.Children...)
+		children = append(children, ue)
+		children = append(children, chChildren...)
 	}
 	sort.Slice(root.Directories, func(i, j int) bool { return root.Directories[i].Name < root.Directories[j].Name })
 
@@ -703,13 +930,41 @@ func packageDirectories(t *treeNode) (root *repb.Directory, files map[digest.Dig
 	sort.Slice(root.Files, func(i, j int) bool { return root.Files[i].Name < root.Files[j].Name })
 	sort.Slice(root.Symlinks, func(i, j int) bool { return root.Symlinks[i].Name < root.Symlinks[j].Name })
 
-	return root, files, treePb, nil
+	return root, files, children, nil
+}
+
+// marshalTree returns the serialized Tree proto with the given serialized root and children
+// Directory protos. It is equivalent to marshaling the Tree, without serializing every Directory
+// once more.
+func marshalTree(root *uploadinfo.Entry, children []*uploadinfo.Entry) []byte {
+	size := protowire.SizeTag(1) + protowire.SizeBytes(len(root.Contents))
+	for _, ch := range children {
+		size += protowire.SizeTag(2) + protowire.SizeBytes(len(ch.Contents))
+	}
+	b := make([]byte, 0, size)
+	b = protowire.AppendTag(b, 1, protowire.BytesType)
+	b = protowire.AppendBytes(b, root.Contents)
+	for _, ch := range children {
+		b = protowire.AppendTag(b, 2, protowire.BytesType)
+		b = protowire.AppendBytes(b, ch.Contents)
+	}
+	return b
 }
 
 // ComputeOutputsToUpload transforms the provided local output paths into uploadable Chunkers.
 // The paths have to be relative to execRoot.
 // It also populates the remote ActionResult, packaging output directories as trees where required.
 func (c *Client) ComputeOutputsToUpload(execRoot, workingDir string, paths []string, cache filemetadata.Cache, sb command.SymlinkBehaviorType, nodeProperties map[string]*cpb.NodeProperties) (map[digest.Digest]*uploadinfo.Entry, *repb.ActionResult, error) {
+	return c.ComputeOutputsToUploadWithNodeProperties(execRoot, workingDir, paths, cache, sb, nodeProperties, nil)
+}
+
+// ComputeOutputsToUploadWithNodeProperties is like ComputeOutputsToUpload, but it additionally
+// captures the requested output node properties (see command.Command.OutputNodeProperties) of all
+// the output files and symlinks from the local file system, so that the ActionResult is faithful to
+// what a remote worker would have produced. Properties explicitly set in nodeProperties take
+// precedence over the captured ones. Unknown node properties are ignored.
+func (c *Client) ComputeOutputsToUploadWithNodeProperties(execRoot, workingDir string, paths []string, cache filemetadata.Cache, sb command.SymlinkBehaviorType, nodeProperties map[string]*cpb.NodeProperties, outputNodeProperties []string) (map[digest.Digest]*uploadinfo.Entry, *repb.ActionResult, error) {
+	cache = c.FileMetadataCache(cache)
 	outs := make(map[digest.Digest]*uploadinfo.Entry)
 	resPb := &repb.ActionResult{}
 	for _, path := range paths {
@@ -735,45 +990,83 @@ func (c *Client) ComputeOutputsToUpload(execRoot, workingDir string, paths []str
 			// A regular file.
 			ue := uploadinfo.EntryFromFile(meta.Digest, absPath)
 			outs[meta.Digest] = ue
-			resPb.OutputFiles = append(resPb.OutputFiles, &repb.OutputFile{Path: normPath, Digest: meta.Digest.ToProto(), IsExecutable: meta.IsExecutable, NodeProperties: command.NodePropertiesToAPI(nodeProperties[normPath])})
+			np, err := captureNodeProperties(absPath, false, nodeProperties[normPath], outputNodeProperties)
+			if err != nil {
+				return nil, nil, err
+			}
+			resPb.OutputFiles = append(resPb.OutputFiles, &repb.OutputFile{Path: normPath, Digest: meta.Digest.ToProto(), IsExecutable: meta.IsExecutable, NodeProperties: command.NodePropertiesToAPI(np)})
 			continue
 		}
 		// A directory.
 		fs := make(map[string]*fileSysNode)
-		if e := loadFiles(absPath, "", "", nil, []string{"."}, fs, cache, treeSymlinkOpts(c.TreeSymlinkOpts, sb), nodeProperties); e != nil {
+		if e := loadFiles(absPath, "", "", nil, []string{"."}, fs, c.newMetadataPrefetcher(absPath, cache), newMountFilter(c.TreeMountOpts, absPath), c.treeSymlinkOpts(sb), nodeProperties, bool(c.PreserveEmptyDirs), nil, nil, nil); e != nil {
 			return nil, nil, e
 		}
+		for p, n := range fs {
+			if n.emptyDirectoryMarker {
+				continue
+			}
+			if n.nodeProperties, err = captureNodeProperties(filepath.Join(absPath, p), n.symlink != nil, n.nodeProperties, outputNodeProperties); err != nil {
+				return nil, nil, err
+			}
+		}
 		ft, err := buildTree(fs)
 		if err != nil {
 			return nil, nil, err
 		}
 
-		rootDir, files, treePb, err := packageDirectories(ft)
-		if err != nil {
-			return nil, nil, err
-		}
-		ue, err := uploadinfo.EntryFromProto(rootDir)
+		rootDir, files, children, err := packageDirectories(c.digestFn, ft)
 		if err != nil {
 			return nil, nil, err
 		}
-		outs[ue.Digest] = ue
-		treePb.Root = rootDir
-		ue, err = uploadinfo.EntryFromProto(treePb)
+		ueRoot, err := uploadinfo.EntryFromProtoWith(c.digestFn, rootDir)
 		if err != nil {
 			return nil, nil, err
 		}
+		ue := uploadinfo.EntryFromBlobWith(c.digestFn, marshalTree(ueRoot, children))
 		outs[ue.Digest] = ue
 		for _, ue := range files {
 			outs[ue.Digest] = ue
 		}
 		resPb.OutputDirectories = append(resPb.OutputDirectories, &repb.OutputDirectory{Path: normPath, TreeDigest: ue.Digest.ToProto()})
-		// Upload the child directories individually as well
-		ueRoot, _ := uploadinfo.EntryFromProto(treePb.Root)
+		// Upload the root and child directories individually as well
 		outs[ueRoot.Digest] = ueRoot
-		for _, child := range treePb.Children {
-			ueChild, _ := uploadinfo.EntryFromProto(child)
+		for _, ueChild := range children {
 			outs[ueChild.Digest] = ueChild
 		}
 	}
 	return outs, resPb, nil
 }
+
+// captureNodeProperties returns a copy of np amended with the requested node properties of the
+// file at absPath. If the node is a symlink, the properties of the symlink itself are captured.
+func captureNodeProperties(absPath string, isSymlink bool, np *cpb.NodeProperties, requested []string) (*cpb.NodeProperties, error) {
+	if len(requested) == 0 {
+		return np, nil
+	}
+	stat := os.Stat
+	if isSymlink {
+		stat = os.Lstat
+	}
+	fi, err := stat(absPath)
+	if err != nil {
+		return nil, err
+	}
+	res := &cpb.NodeProperties{}
+	if np != nil {
+		res = proto.Clone(np).(*cpb.NodeProperties)
+	}
+	for _, p := range requested {
+		switch p {
+		case command.MtimeNodeProperty:
+			if res.Mtime == nil {
+				res.Mtime = tspb.New(fi.ModTime())
+			}
+		case command.UnixModeNodeProperty:
+			if res.UnixMode == nil {
+				res.UnixMode = wrapperspb.UInt32(uint32(fi.Mode().Perm()))
+			}
+		}
+	}
+	return res, nil
+}
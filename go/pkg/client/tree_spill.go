@@ -0,0 +1,219 @@
+package client
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/uploadinfo"
+)
+
+// TreeSpillDir is a directory that ComputeMerkleTree writes the Directory protos of input trees to,
+// rather than keeping them in memory. The tree is then read depth-first, and every directory is
+// encoded and released as soon as its whole subtree is read, which bounds the memory used for exec
+// roots with millions of files, at the cost of some disk I/O. Trees following the targets of
+// preserved symlinks are still read whole before being encoded, since the targets can be anywhere
+// in the tree.
+//
+// The entries returned for the Directory protos refer to the files, which must be kept until the
+// inputs are uploaded. The files are named after their digests, so the directory can be shared;
+// the files a failed ComputeMerkleTree call wrote are removed. The DirectoryCache of the client is
+// not used for trees built with a TreeSpillDir, as its entries keep the Directory protos and the
+// file entries of the cached directories in memory.
+type TreeSpillDir string
+
+// Apply sets the client's TreeSpillDir.
+func (d TreeSpillDir) Apply(c *Client) {
+	c.TreeSpillDir = d
+}
+
+// spillEntry writes the contents of the in-memory entry to a file in dir, named after its digest,
+// and returns an entry for the file instead, and whether the file was created.
+func spillEntry(ue *uploadinfo.Entry, dir string) (*uploadinfo.Entry, bool, error) {
+	path := filepath.Join(dir, fmt.Sprintf("%s_%d", ue.Digest.Hash, ue.Digest.Size))
+	created := false
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		f, err := os.CreateTemp(dir, "spill-")
+		if err != nil {
+			return nil, false, err
+		}
+		_, err = f.Write(ue.Contents)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err == nil {
+			err = os.Rename(f.Name(), path)
+		}
+		if err != nil {
+			os.Remove(f.Name())
+			return nil, false, err
+		}
+		created = true
+	} else if err != nil {
+		return nil, false, err
+	}
+	return uploadinfo.EntryFromFile(ue.Digest, path), created, nil
+}
+
+// removeSpilled removes the files the packager spilled, after a failure.
+func (p *treePackager) removeSpilled() {
+	for _, path := range p.spilled {
+		os.Remove(path)
+	}
+	p.spilled = nil
+}
+
+// spillTraversal packs the directories read by a depth-first traversal as soon as their whole
+// subtrees are read, so that their nodes are released during the traversal. A packed directory
+// replaces its subtree in fs by a node whose cachedDir only holds its Directory proto, as its
+// blobs and stats are already recorded by the packager.
+type spillTraversal struct {
+	p  *treePackager
+	fs map[string]*fileSysNode
+	// ineligible holds the remote paths of the directories with nodes in fs before the traversal,
+	// which are packed along with the rest of the tree instead.
+	ineligible map[string]bool
+	// open are the directories being read, innermost last.
+	open []spillDir
+	// added logs the remote paths added to fs while directories are open.
+	added []string
+	// packed holds the local paths of the packed directories. Paths in them are not read again.
+	packed map[string]bool
+	// roots maps the local paths of the packed directories that are not in any open directory to
+	// their remote paths.
+	roots map[string]string
+}
+
+// spillDir is a directory being read by a spillTraversal.
+type spillDir struct {
+	local, remote string
+	// start is the length of the log of added paths when the directory was opened.
+	start int
+}
+
+// newSpillTraversal returns the spillTraversal packing the tree of fs with p.
+func newSpillTraversal(p *treePackager, fs map[string]*fileSysNode) *spillTraversal {
+	s := &spillTraversal{
+		p:          p,
+		fs:         fs,
+		ineligible: make(map[string]bool),
+		packed:     make(map[string]bool),
+		roots:      make(map[string]string),
+	}
+	for path := range fs {
+		for d := filepath.Dir(path); !s.ineligible[d]; d = filepath.Dir(d) {
+			s.ineligible[d] = true
+			if d == "." || d == string(filepath.Separator) {
+				break
+			}
+		}
+	}
+	return s
+}
+
+// enter packs the open directories the local path is not in, and returns whether the path is in a
+// packed directory, and so must be skipped.
+func (s *spillTraversal) enter(local string) (bool, error) {
+	if s == nil {
+		return false, nil
+	}
+	for len(s.open) > 0 && !isUnder(local, s.open[len(s.open)-1].local) {
+		if err := s.close(); err != nil {
+			return false, err
+		}
+	}
+	for p := local; ; p = filepath.Dir(p) {
+		if s.packed[p] {
+			return true, nil
+		}
+		if filepath.Dir(p) == p || p == "." {
+			return false, nil
+		}
+	}
+}
+
+// walk records that the directory at the local and remote paths is read whole, so that it is
+// packed once its subtree is read.
+func (s *spillTraversal) walk(local, remote string) {
+	if s == nil || remote == "." || s.ineligible[remote] {
+		return
+	}
+	s.open = append(s.open, spillDir{local: local, remote: remote, start: len(s.added)})
+}
+
+// log records that the remote path was added to fs.
+func (s *spillTraversal) log(remote string) {
+	if s != nil && len(s.open) > 0 {
+		s.added = append(s.added, remote)
+	}
+}
+
+// close packs the innermost open directory.
+func (s *spillTraversal) close() error {
+	d := s.open[len(s.open)-1]
+	s.open = s.open[:len(s.open)-1]
+	keys := s.added[d.start:]
+	s.added = s.added[:d.start]
+
+	sub := make(map[string]*fileSysNode)
+	marker := false
+	move := func(remote, local string) {
+		n := s.fs[remote]
+		if n == nil {
+			return
+		}
+		delete(s.fs, remote)
+		if remote == d.remote {
+			marker = marker || n.emptyDirectoryMarker
+			return
+		}
+		rel, _ := filepath.Rel(d.remote, remote)
+		sub[rel] = n
+		if n.cachedDir != nil {
+			delete(s.packed, local)
+		}
+	}
+	for _, remote := range keys {
+		rel, _ := filepath.Rel(d.remote, remote)
+		move(remote, filepath.Join(d.local, rel))
+	}
+	for local, remote := range s.roots {
+		if isUnder(local, d.local) {
+			delete(s.roots, local)
+			move(remote, local)
+		}
+	}
+	if len(sub) == 0 && !marker {
+		// Everything in the directory was skipped.
+		return nil
+	}
+	t, err := buildTree(sub)
+	if err != nil {
+		return err
+	}
+	dg, err := s.p.pack(t, d.remote)
+	if err != nil {
+		return err
+	}
+	s.packed[d.local] = true
+	s.fs[d.remote] = &fileSysNode{cachedDir: &cachedDir{dir: s.p.blobs[dg]}}
+	if len(s.open) > 0 {
+		s.added = append(s.added, d.remote)
+	} else {
+		s.roots[d.local] = d.remote
+	}
+	return nil
+}
+
+// finish packs the directories still open.
+func (s *spillTraversal) finish() error {
+	if s == nil {
+		return nil
+	}
+	for len(s.open) > 0 {
+		if err := s.close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -2,10 +2,14 @@ package client_test
 
 import (
 	"context"
+	"fmt"
 	"math/rand"
 	"os"
 	"path/filepath"
+	"runtime"
+	"sync"
 	"testing"
+	"time"
 
 	cpb "github.com/bazelbuild/remote-apis-sdks/go/api/command"
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/chunker"
@@ -21,6 +25,8 @@ import (
 	"google.golang.org/protobuf/testing/protocmp"
 
 	repb "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	wrapperspb "google.golang.org/protobuf/types/known/wrapperspb"
 )
 
 var (
@@ -104,6 +110,7 @@ func construct(dir string, ips []*inputPath) error {
 }
 
 type callCountingMetadataCache struct {
+	mu       sync.Mutex
 	calls    map[string]int
 	cache    filemetadata.Cache
 	execRoot string
@@ -125,7 +132,9 @@ func (c *callCountingMetadataCache) Get(path string) *filemetadata.Metadata {
 	if err != nil {
 		c.t.Errorf("expected %v to be under %v", path, c.execRoot)
 	}
+	c.mu.Lock()
 	c.calls[p]++
+	c.mu.Unlock()
 	return c.cache.Get(path)
 }
 
@@ -135,7 +144,9 @@ func (c *callCountingMetadataCache) Delete(path string) error {
 	if err != nil {
 		c.t.Errorf("expected %v to be under %v", path, c.execRoot)
 	}
+	c.mu.Lock()
 	c.calls[p]++
+	c.mu.Unlock()
 	return c.cache.Delete(path)
 }
 
@@ -145,7 +156,9 @@ func (c *callCountingMetadataCache) Update(path string, ce *filemetadata.Metadat
 	if err != nil {
 		c.t.Errorf("expected %v to be under %v", path, c.execRoot)
 	}
+	c.mu.Lock()
 	c.calls[p]++
+	c.mu.Unlock()
 	return c.cache.Update(path, ce)
 }
 
@@ -328,6 +341,562 @@ func TestComputeMerkleTreeEmptySubdirs(t *testing.T) {
 	}
 }
 
+func TestComputeMerkleTreeSpill(t *testing.T) {
+	ips := []*inputPath{
+		{path: "a/b/foo", fileContents: []byte("foo")},
+		{path: "a/b/bar", fileContents: []byte("bar")},
+		{path: "a/c/baz", fileContents: []byte("baz"), isExecutable: true},
+		{path: "a/empty", emptyDir: true},
+	}
+	root := t.TempDir()
+	if err := construct(root, ips); err != nil {
+		t.Fatalf("failed to construct input dir structure: %v", err)
+	}
+	inputSpec := &command.InputSpec{Inputs: []string{"a"}}
+	e, cleanup := fakes.NewTestEnv(t)
+	defer cleanup()
+	c := e.Client.GrpcClient
+	readBlobs := func(inputs []*uploadinfo.Entry) map[digest.Digest][]byte {
+		blobs := make(map[digest.Digest][]byte)
+		for _, ue := range inputs {
+			ch, err := chunker.New(ue, false, int(c.ChunkMaxSize))
+			if err != nil {
+				t.Fatalf("chunker.New(ue): failed to create chunker from UploadEntry: %v", err)
+			}
+			blob, err := ch.FullData()
+			if err != nil {
+				t.Fatalf("chunker %v FullData() returned error %v", ch, err)
+			}
+			blobs[ue.Digest] = blob
+		}
+		return blobs
+	}
+
+	wantRoot, wantInputs, wantStats, err := c.ComputeMerkleTree(context.Background(), root, "", "", inputSpec, newCallCountingMetadataCache(root, t))
+	if err != nil {
+		t.Fatalf("ComputeMerkleTree(...) = gave error %v, want success", err)
+	}
+	spillDir := t.TempDir()
+	client.TreeSpillDir(spillDir).Apply(c)
+	gotRoot, gotInputs, gotStats, err := c.ComputeMerkleTree(context.Background(), root, "", "", inputSpec, newCallCountingMetadataCache(root, t))
+	if err != nil {
+		t.Fatalf("ComputeMerkleTree(...) with TreeSpillDir = gave error %v, want success", err)
+	}
+	if gotRoot != wantRoot {
+		t.Errorf("ComputeMerkleTree(...) with TreeSpillDir gave root %v, want %v", gotRoot, wantRoot)
+	}
+	if diff := cmp.Diff(wantStats, gotStats); diff != "" {
+		t.Errorf("ComputeMerkleTree(...) with TreeSpillDir gave diff on stats (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(readBlobs(wantInputs), readBlobs(gotInputs)); diff != "" {
+		t.Errorf("ComputeMerkleTree(...) with TreeSpillDir gave diff on blobs (-want +got):\n%s", diff)
+	}
+	spilled, err := os.ReadDir(spillDir)
+	if err != nil {
+		t.Fatalf("os.ReadDir(%q) failed: %v", spillDir, err)
+	}
+	// The root, a, a/b, a/c and the empty directory.
+	if len(spilled) != 5 {
+		t.Errorf("ComputeMerkleTree(...) with TreeSpillDir spilled %d Directory protos, want 5", len(spilled))
+	}
+	for _, ue := range gotInputs {
+		if ue.IsBlob() && len(ue.Contents) > 0 {
+			t.Errorf("ComputeMerkleTree(...) with TreeSpillDir kept blob %v in memory", ue.Digest)
+		}
+	}
+}
+
+func TestComputeMerkleTreeSpillDuringTraversal(t *testing.T) {
+	ips := []*inputPath{
+		{path: "a/b/foo", fileContents: []byte("foo")},
+		{path: "a/b/c/bar", fileContents: []byte("bar")},
+		{path: "a/d/baz.txt", fileContents: []byte("baz")},
+		{path: "a/e/qux", fileContents: []byte("qux"), isExecutable: true},
+		{path: "a/e/link", isSymlink: true, relSymlinkTarget: "qux"},
+		{path: "a/empty", emptyDir: true},
+		{path: "top", fileContents: []byte("top")},
+	}
+	root := t.TempDir()
+	if err := construct(root, ips); err != nil {
+		t.Fatalf("failed to construct input dir structure: %v", err)
+	}
+	tests := []struct {
+		desc string
+		spec *command.InputSpec
+	}{
+		{
+			desc: "whole tree",
+			spec: &command.InputSpec{Inputs: []string{"."}},
+		},
+		{
+			desc: "overlapping inputs",
+			spec: &command.InputSpec{Inputs: []string{"a/b/c", "top", "a", "a/b", "a/e/qux"}},
+		},
+		{
+			desc: "excluded directory contents",
+			spec: &command.InputSpec{
+				Inputs:          []string{"a", "top"},
+				InputExclusions: []*command.InputExclusion{{Glob: "**/*.txt"}},
+			},
+		},
+		{
+			desc: "virtual inputs",
+			spec: &command.InputSpec{
+				Inputs: []string{"a"},
+				VirtualInputs: []*command.VirtualInput{
+					{Path: "a/b/c/virtual", Contents: []byte("virtual")},
+					{Path: "a/e/empty", IsEmptyDirectory: true},
+				},
+			},
+		},
+		{
+			desc: "preserved symlinks",
+			spec: &command.InputSpec{Inputs: []string{"a"}, SymlinkBehavior: command.PreserveSymlink},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			e, cleanup := fakes.NewTestEnv(t)
+			defer cleanup()
+			c := e.Client.GrpcClient
+			wantRoot, wantInputs, wantStats, err := c.ComputeMerkleTree(context.Background(), root, "", "", tc.spec, filemetadata.NewNoopCache())
+			if err != nil {
+				t.Fatalf("ComputeMerkleTree(...) = gave error %v, want success", err)
+			}
+			client.TreeSpillDir(t.TempDir()).Apply(c)
+			gotRoot, gotInputs, gotStats, err := c.ComputeMerkleTree(context.Background(), root, "", "", tc.spec, filemetadata.NewNoopCache())
+			if err != nil {
+				t.Fatalf("ComputeMerkleTree(...) with TreeSpillDir = gave error %v, want success", err)
+			}
+			if gotRoot != wantRoot {
+				t.Errorf("ComputeMerkleTree(...) with TreeSpillDir gave root %v, want %v", gotRoot, wantRoot)
+			}
+			if diff := cmp.Diff(wantStats, gotStats); diff != "" {
+				t.Errorf("ComputeMerkleTree(...) with TreeSpillDir gave diff on stats (-want +got):\n%s", diff)
+			}
+			digests := func(inputs []*uploadinfo.Entry) map[digest.Digest]bool {
+				dgs := make(map[digest.Digest]bool)
+				for _, ue := range inputs {
+					dgs[ue.Digest] = true
+				}
+				return dgs
+			}
+			if diff := cmp.Diff(digests(wantInputs), digests(gotInputs)); diff != "" {
+				t.Errorf("ComputeMerkleTree(...) with TreeSpillDir gave diff on inputs (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestComputeMerkleTreeSpillRemovedOnError(t *testing.T) {
+	ips := []*inputPath{
+		{path: "a/b/foo", fileContents: []byte("foo")},
+		{path: "a/c/bar", fileContents: []byte("bar")},
+	}
+	root := t.TempDir()
+	if err := construct(root, ips); err != nil {
+		t.Fatalf("failed to construct input dir structure: %v", err)
+	}
+	e, cleanup := fakes.NewTestEnv(t)
+	defer cleanup()
+	c := e.Client.GrpcClient
+	spillDir := t.TempDir()
+	client.TreeSpillDir(spillDir).Apply(c)
+	// The tree is read depth-first from the last input, so a/b and a/c are spilled before the
+	// empty input fails.
+	inputSpec := &command.InputSpec{Inputs: []string{"", "a"}}
+	if _, _, _, err := c.ComputeMerkleTree(context.Background(), root, "", "", inputSpec, filemetadata.NewNoopCache()); err == nil {
+		t.Fatalf("ComputeMerkleTree(...) with an empty input succeeded, want error")
+	}
+	spilled, err := os.ReadDir(spillDir)
+	if err != nil {
+		t.Fatalf("os.ReadDir(%q) failed: %v", spillDir, err)
+	}
+	if len(spilled) != 0 {
+		t.Errorf("ComputeMerkleTree(...) left %d spilled files after failing, want 0", len(spilled))
+	}
+}
+
+func TestComputeMerkleTreeDirectoryCache(t *testing.T) {
+	ips := []*inputPath{
+		{path: "a/b/foo", fileContents: []byte("foo")},
+		{path: "a/c/baz", fileContents: []byte("baz"), isExecutable: true},
+		{path: "a/empty", emptyDir: true},
+		{path: "bar", fileContents: []byte("bar")},
+	}
+	root := t.TempDir()
+	if err := construct(root, ips); err != nil {
+		t.Fatalf("failed to construct input dir structure: %v", err)
+	}
+	inputSpec := &command.InputSpec{Inputs: []string{"a", "bar"}}
+	e, cleanup := fakes.NewTestEnv(t)
+	defer cleanup()
+	c := e.Client.GrpcClient
+	digests := func(inputs []*uploadinfo.Entry) map[digest.Digest]bool {
+		dgs := make(map[digest.Digest]bool)
+		for _, ue := range inputs {
+			dgs[ue.Digest] = true
+		}
+		return dgs
+	}
+	compute := func() (digest.Digest, []*uploadinfo.Entry, *client.TreeStats, map[string]int) {
+		t.Helper()
+		cache := newCallCountingMetadataCache(root, t)
+		gotRoot, gotInputs, gotStats, err := c.ComputeMerkleTree(context.Background(), root, "", "", inputSpec, cache)
+		if err != nil {
+			t.Fatalf("ComputeMerkleTree(...) = gave error %v, want success", err)
+		}
+		return gotRoot, gotInputs, gotStats, cache.calls
+	}
+
+	wantRoot, wantInputs, wantStats, _ := compute()
+	dc := client.NewDirectoryCache()
+	dc.Apply(c)
+	compute()
+	// a, a/b, a/c and the empty directory, but not the root, which is not read whole.
+	if dc.Len() != 4 {
+		t.Errorf("DirectoryCache has %d directories, want 4", dc.Len())
+	}
+	gotRoot, gotInputs, gotStats, calls := compute()
+	if gotRoot != wantRoot {
+		t.Errorf("ComputeMerkleTree(...) with a DirectoryCache gave root %v, want %v", gotRoot, wantRoot)
+	}
+	if diff := cmp.Diff(wantStats, gotStats); diff != "" {
+		t.Errorf("ComputeMerkleTree(...) with a DirectoryCache gave diff on stats (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(digests(wantInputs), digests(gotInputs)); diff != "" {
+		t.Errorf("ComputeMerkleTree(...) with a DirectoryCache gave diff on inputs (-want +got):\n%s", diff)
+	}
+	if calls["a/b/foo"] != 0 || calls["a/c/baz"] != 0 {
+		t.Errorf("ComputeMerkleTree(...) with a cached directory read metadata %v, want no reads under a", calls)
+	}
+
+	if err := os.WriteFile(filepath.Join(root, "a/b/foo"), []byte("foo2"), 0666); err != nil {
+		t.Fatalf("os.WriteFile failed: %v", err)
+	}
+	dc.Invalidate(filepath.Join(root, "a/b/foo"))
+	gotRoot, _, _, calls = compute()
+	if gotRoot == wantRoot {
+		t.Errorf("ComputeMerkleTree(...) after an invalidation gave the stale root %v", gotRoot)
+	}
+	if calls["a/b/foo"] == 0 || calls["a/c/baz"] != 0 {
+		t.Errorf("ComputeMerkleTree(...) after an invalidation read metadata %v, want reads of a/b only", calls)
+	}
+
+	// Changes are noticed without invalidations.
+	wantRoot, _, _, _ = compute()
+	if err := os.WriteFile(filepath.Join(root, "a/c/new"), []byte("new"), 0666); err != nil {
+		t.Fatalf("os.WriteFile failed: %v", err)
+	}
+	gotRoot, _, _, calls = compute()
+	if gotRoot == wantRoot {
+		t.Errorf("ComputeMerkleTree(...) after adding a file gave the stale root %v", gotRoot)
+	}
+	if calls["a/c/new"] == 0 || calls["a/b/foo"] != 0 {
+		t.Errorf("ComputeMerkleTree(...) after adding a file read metadata %v, want reads of a/c only", calls)
+	}
+	wantRoot, _, _, _ = compute()
+	if err := os.WriteFile(filepath.Join(root, "a/b/foo"), []byte("foo3"), 0666); err != nil {
+		t.Fatalf("os.WriteFile failed: %v", err)
+	}
+	// Make sure the modification time changes on file systems with a coarse one.
+	future := time.Now().Add(time.Hour)
+	if err := os.Chtimes(filepath.Join(root, "a/b/foo"), future, future); err != nil {
+		t.Fatalf("os.Chtimes failed: %v", err)
+	}
+	if gotRoot, _, _, _ = compute(); gotRoot == wantRoot {
+		t.Errorf("ComputeMerkleTree(...) after modifying a file gave the stale root %v", gotRoot)
+	}
+
+	dc.Invalidate("")
+	if dc.Len() != 0 {
+		t.Errorf("DirectoryCache has %d directories after a reset, want 0", dc.Len())
+	}
+}
+
+func TestComputeMerkleTreePreserveEmptyDirs(t *testing.T) {
+	ips := []*inputPath{
+		{path: "a/empty", emptyDir: true},
+		{path: "a/gen/out.txt", fileContents: []byte("out")},
+	}
+	root := t.TempDir()
+	if err := construct(root, ips); err != nil {
+		t.Fatalf("failed to construct input dir structure: %v", err)
+	}
+	inputSpec := &command.InputSpec{
+		Inputs:          []string{"a"},
+		InputExclusions: []*command.InputExclusion{{Glob: "*.txt"}},
+	}
+	dirWith := func(names ...string) *repb.Directory {
+		dir := &repb.Directory{}
+		for _, name := range names {
+			dir.Directories = append(dir.Directories, &repb.DirectoryNode{Name: name, Digest: digest.Empty.ToProto()})
+		}
+		return dir
+	}
+	tests := []struct {
+		desc     string
+		preserve bool
+		aDir     *repb.Directory
+	}{
+		{
+			desc: "empty on disk only",
+			aDir: dirWith("empty"),
+		},
+		{
+			desc:     "emptied by exclusions",
+			preserve: true,
+			aDir:     dirWith("empty", "gen"),
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			e, cleanup := fakes.NewTestEnv(t)
+			defer cleanup()
+			c := e.Client.GrpcClient
+			client.PreserveEmptyDirs(tc.preserve).Apply(c)
+			gotRoot, _, _, err := c.ComputeMerkleTree(context.Background(), root, "", "", inputSpec, filemetadata.NewNoopCache())
+			if err != nil {
+				t.Fatalf("ComputeMerkleTree(...) = gave error %v, want success", err)
+			}
+			aDg := digest.TestNewFromMessage(tc.aDir)
+			wantRoot := digest.TestNewFromMessage(&repb.Directory{Directories: []*repb.DirectoryNode{{Name: "a", Digest: aDg.ToProto()}}})
+			if gotRoot != wantRoot {
+				t.Errorf("ComputeMerkleTree(...) with PreserveEmptyDirs(%t) gave root %v, want %v", tc.preserve, gotRoot, wantRoot)
+			}
+		})
+	}
+}
+
+func TestComputeMerkleTreeGlobExclusionsRelativeToExecRoot(t *testing.T) {
+	ips := []*inputPath{
+		{path: "a.txt", fileContents: []byte("a")},
+		{path: "src/b", fileContents: []byte("b")},
+		{path: "src/c.o", fileContents: []byte("c")},
+	}
+	// The globs below match the ancestors of the exec root, but must not match the inputs.
+	root := filepath.Join(t.TempDir(), "build", "out")
+	if err := construct(root, ips); err != nil {
+		t.Fatalf("failed to construct input dir structure: %v", err)
+	}
+	inputSpec := &command.InputSpec{
+		Inputs: []string{"a.txt", "src"},
+		InputExclusions: []*command.InputExclusion{
+			{Glob: "build/**"},
+			{Glob: "out/*"},
+			{Glob: "src/*.o"},
+		},
+	}
+	e, cleanup := fakes.NewTestEnv(t)
+	defer cleanup()
+	gotRoot, _, stats, err := e.Client.GrpcClient.ComputeMerkleTree(context.Background(), root, "", "", inputSpec, filemetadata.NewNoopCache())
+	if err != nil {
+		t.Fatalf("ComputeMerkleTree(...) = gave error %v, want success", err)
+	}
+	srcDir := &repb.Directory{Files: []*repb.FileNode{{Name: "b", Digest: digest.NewFromBlob([]byte("b")).ToProto()}}}
+	wantRoot := digest.TestNewFromMessage(&repb.Directory{
+		Files:       []*repb.FileNode{{Name: "a.txt", Digest: digest.NewFromBlob([]byte("a")).ToProto()}},
+		Directories: []*repb.DirectoryNode{{Name: "src", Digest: digest.TestNewFromMessage(srcDir).ToProto()}},
+	})
+	if gotRoot != wantRoot {
+		t.Errorf("ComputeMerkleTree(...) gave root %v, want %v", gotRoot, wantRoot)
+	}
+	if stats.ExcludedInputs != 1 {
+		t.Errorf("ComputeMerkleTree(...) excluded %d inputs, want 1", stats.ExcludedInputs)
+	}
+}
+
+func TestComputeMerkleTreeTreeDigestInput(t *testing.T) {
+	subDir := &repb.Directory{Files: []*repb.FileNode{{Name: "bar", Digest: barDgPb}}}
+	outDir := &repb.Directory{
+		Files:       []*repb.FileNode{{Name: "foo", Digest: fooDgPb, IsExecutable: true}},
+		Directories: []*repb.DirectoryNode{{Name: "sub", Digest: digest.TestNewFromMessage(subDir).ToProto()}},
+	}
+	tree := &repb.Tree{Root: outDir, Children: []*repb.Directory{subDir}}
+	e, cleanup := fakes.NewTestEnv(t)
+	defer cleanup()
+	treeDg := e.Server.CAS.Put(mustMarshal(tree))
+	c := e.Client.GrpcClient
+
+	// The same inputs, with the outputs of the previous action present locally.
+	local := t.TempDir()
+	ips := []*inputPath{
+		{path: "baz", fileContents: bazBlob},
+		{path: "out/foo", fileContents: fooBlob, isExecutable: true},
+		{path: "out/sub/bar", fileContents: barBlob},
+	}
+	if err := construct(local, ips); err != nil {
+		t.Fatalf("failed to construct input dir structure: %v", err)
+	}
+	wantRoot, _, wantStats, err := c.ComputeMerkleTree(context.Background(), local, "", "", &command.InputSpec{Inputs: []string{"baz", "out"}}, filemetadata.NewNoopCache())
+	if err != nil {
+		t.Fatalf("ComputeMerkleTree(...) = gave error %v, want success", err)
+	}
+
+	root := t.TempDir()
+	if err := construct(root, ips[:1]); err != nil {
+		t.Fatalf("failed to construct input dir structure: %v", err)
+	}
+	inputSpec := &command.InputSpec{
+		Inputs:        []string{"baz"},
+		VirtualInputs: []*command.VirtualInput{{Path: "out", TreeDigest: treeDg.String()}},
+	}
+	gotRoot, inputs, gotStats, err := c.ComputeMerkleTree(context.Background(), root, "", "", inputSpec, filemetadata.NewNoopCache())
+	if err != nil {
+		t.Fatalf("ComputeMerkleTree(...) with a tree digest input = gave error %v, want success", err)
+	}
+	if gotRoot != wantRoot {
+		t.Errorf("ComputeMerkleTree(...) with a tree digest input gave root %v, want %v", gotRoot, wantRoot)
+	}
+	if diff := cmp.Diff(wantStats, gotStats); diff != "" {
+		t.Errorf("ComputeMerkleTree(...) with a tree digest input gave diff on stats (-want +got):\n%s", diff)
+	}
+	for _, ue := range inputs {
+		if (ue.Digest == fooDg || ue.Digest == barDg) && !ue.IsVirtualFile() {
+			t.Errorf("ComputeMerkleTree(...) with a tree digest input gave non-virtual entry %v for a tree file", ue.Digest)
+		}
+	}
+
+	inputSpec.VirtualInputs[0].Digest = fooDg.String()
+	if _, _, _, err := c.ComputeMerkleTree(context.Background(), root, "", "", inputSpec, filemetadata.NewNoopCache()); err == nil {
+		t.Errorf("ComputeMerkleTree(...) with both a digest and a tree digest = succeeded, want error")
+	}
+}
+
+func TestComputeMerkleTreeTreeDigestInputNodeProperties(t *testing.T) {
+	np := &repb.NodeProperties{Properties: []*repb.NodeProperty{{Name: "owner", Value: "root"}}}
+	outDir := &repb.Directory{
+		Files:    []*repb.FileNode{{Name: "foo", Digest: fooDgPb, NodeProperties: np}},
+		Symlinks: []*repb.SymlinkNode{{Name: "link", Target: "foo", NodeProperties: np}},
+	}
+	e, cleanup := fakes.NewTestEnv(t)
+	defer cleanup()
+	treeDg := e.Server.CAS.Put(mustMarshal(&repb.Tree{Root: outDir}))
+	c := e.Client.GrpcClient
+	inputSpec := &command.InputSpec{VirtualInputs: []*command.VirtualInput{{Path: "out", TreeDigest: treeDg.String()}}}
+
+	gotRoot, _, _, err := c.ComputeMerkleTree(context.Background(), t.TempDir(), "", "", inputSpec, filemetadata.NewNoopCache())
+	if err != nil {
+		t.Fatalf("ComputeMerkleTree(...) = gave error %v, want success", err)
+	}
+
+	// The directory is packaged as it is in the Tree, node properties included.
+	wantRoot := digest.TestNewFromMessage(&repb.Directory{
+		Directories: []*repb.DirectoryNode{{Name: "out", Digest: digest.TestNewFromMessage(outDir).ToProto()}},
+	})
+	if gotRoot != wantRoot {
+		t.Errorf("ComputeMerkleTree(...) gave root %v, want %v", gotRoot, wantRoot)
+	}
+}
+
+// gatedMetadataCache holds the metadata of a file until a condition is met.
+type gatedMetadataCache struct {
+	filemetadata.Cache
+	gated string
+	open  func() bool
+	// timedOut is set if the condition was not met in time.
+	timedOut bool
+}
+
+func (c *gatedMetadataCache) Get(path string) *filemetadata.Metadata {
+	if filepath.Base(path) == c.gated {
+		deadline := time.Now().Add(10 * time.Second)
+		for !c.open() {
+			if time.Now().After(deadline) {
+				c.timedOut = true
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	return c.Cache.Get(path)
+}
+
+func TestComputeMerkleTreeAndUpload(t *testing.T) {
+	e, cleanup := fakes.NewTestEnv(t)
+	defer cleanup()
+	fake := e.Server.CAS
+	c := e.Client.GrpcClient
+	client.UnifiedUploadTickDuration(10 * time.Millisecond).Apply(c)
+	root := t.TempDir()
+	if err := construct(root, []*inputPath{
+		{path: "foo", fileContents: fooBlob},
+		{path: "dir/bar", fileContents: barBlob},
+		{path: "dir/foo", fileContents: fooBlob},
+		{path: "last", fileContents: bazBlob},
+	}); err != nil {
+		t.Fatalf("failed to construct input dir structure: %v", err)
+	}
+	is := &command.InputSpec{Inputs: []string{"foo", "dir", "last"}}
+	wantRoot, inputs, wantStats, err := c.ComputeMerkleTree(context.Background(), root, "", "", is, filemetadata.NewNoopCache())
+	if err != nil {
+		t.Fatalf("ComputeMerkleTree(...) = gave error %v, want success", err)
+	}
+	// The last input is only digested once the first one was uploaded.
+	cache := &gatedMetadataCache{Cache: filemetadata.NewNoopCache(), gated: "last", open: func() bool { return fake.BlobWrites(fooDg) > 0 }}
+
+	gotRoot, gotStats, missing, _, err := c.ComputeMerkleTreeAndUpload(context.Background(), root, "", "", is, cache)
+	if err != nil {
+		t.Fatalf("ComputeMerkleTreeAndUpload(...) = gave error %v, want success", err)
+	}
+	if cache.timedOut {
+		t.Errorf("ComputeMerkleTreeAndUpload(...) did not upload the inputs during the traversal")
+	}
+	if gotRoot != wantRoot {
+		t.Errorf("ComputeMerkleTreeAndUpload(...) gave root %v, want %v", gotRoot, wantRoot)
+	}
+	if diff := cmp.Diff(wantStats, gotStats); diff != "" {
+		t.Errorf("ComputeMerkleTreeAndUpload(...) gave diff on stats (-want +got):\n%s", diff)
+	}
+	if len(missing) != len(inputs) {
+		t.Errorf("ComputeMerkleTreeAndUpload(...) gave %d missing digests, want %d", len(missing), len(inputs))
+	}
+	for _, ue := range inputs {
+		if n := fake.BlobWrites(ue.Digest); n != 1 {
+			t.Errorf("input %v was written %d times, want 1", ue.Digest, n)
+		}
+	}
+}
+
+func TestComputeMerkleTreeMountOpts(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("file systems are only detected on Linux")
+	}
+	tests := []struct {
+		desc string
+		opts *client.TreeMountOpts
+	}{
+		{desc: "skip virtual", opts: &client.TreeMountOpts{SkipVirtual: true}},
+		{desc: "one file system", opts: &client.TreeMountOpts{OneFileSystem: true}},
+	}
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.desc, func(t *testing.T) {
+			root := t.TempDir()
+			if err := construct(root, []*inputPath{{path: "a/foo", fileContents: []byte("foo")}}); err != nil {
+				t.Fatalf("failed to construct input dir structure: %v", err)
+			}
+			if err := os.Symlink("/proc/self/fdinfo", filepath.Join(root, "a", "proc")); err != nil {
+				t.Fatalf("failed to create symlink: %v", err)
+			}
+			e, cleanup := fakes.NewTestEnv(t)
+			defer cleanup()
+			c := e.Client.GrpcClient
+			tc.opts.Apply(c)
+			inputSpec := &command.InputSpec{Inputs: []string{"a"}}
+			_, _, stats, err := c.ComputeMerkleTree(context.Background(), root, "", "", inputSpec, filemetadata.NewNoopCache())
+			if err != nil {
+				t.Fatalf("ComputeMerkleTree(...) = gave error %v, want success", err)
+			}
+			// Only a/foo remains; the directory on /proc is skipped.
+			wantStats := &client.TreeStats{InputFiles: 1, InputDirectories: 2, TotalInputBytes: stats.TotalInputBytes}
+			if diff := cmp.Diff(wantStats, stats); diff != "" {
+				t.Errorf("ComputeMerkleTree(...) gave diff on stats (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
 func TestComputeMerkleTreeEmptyStructureVirtualInputs(t *testing.T) {
 	emptyDirDgPb := digest.Empty.ToProto()
 	cDir := &repb.Directory{
@@ -499,6 +1068,34 @@ func TestComputeMerkleTree(t *testing.T) {
 				TotalInputBytes:  fooDg.Size + barDg.Size,
 			},
 		},
+		{
+			desc: "Unix mode overrides executability",
+			input: []*inputPath{
+				{path: "foo", fileContents: fooBlob, isExecutable: true},
+				{path: "bar", fileContents: barBlob},
+			},
+			spec: &command.InputSpec{
+				Inputs: []string{"foo", "bar"},
+				InputNodeProperties: map[string]*cpb.NodeProperties{
+					"foo": {UnixMode: wrapperspb.UInt32(0644)},
+					"bar": {UnixMode: wrapperspb.UInt32(0755)},
+				},
+			},
+			rootDir: &repb.Directory{Files: []*repb.FileNode{
+				{Name: "bar", Digest: barDgPb, IsExecutable: true, NodeProperties: &repb.NodeProperties{UnixMode: wrapperspb.UInt32(0755)}},
+				{Name: "foo", Digest: fooDgPb, NodeProperties: &repb.NodeProperties{UnixMode: wrapperspb.UInt32(0644)}},
+			}},
+			additionalBlobs: [][]byte{fooBlob, barBlob},
+			wantCacheCalls: map[string]int{
+				"foo": 1,
+				"bar": 1,
+			},
+			wantStats: &client.TreeStats{
+				InputDirectories: 1,
+				InputFiles:       2,
+				TotalInputBytes:  fooDg.Size + barDg.Size,
+			},
+		},
 		{
 			desc: "File below root",
 			input: []*inputPath{
@@ -1235,6 +1832,75 @@ func TestComputeMerkleTree(t *testing.T) {
 				InputDirectories: 3,
 				InputFiles:       2,
 				TotalInputBytes:  fooDg.Size + fooDirDg.Size + barDg.Size + barDirDg.Size,
+				ExcludedInputs:   2,
+			},
+		},
+		{
+			desc: "File glob exclusions",
+			input: []*inputPath{
+				{path: "fooDir/foo", fileContents: fooBlob, isExecutable: true},
+				{path: "fooDir/foo.txt", fileContents: fooBlob, isExecutable: true},
+				{path: "barDir/bar", fileContents: barBlob},
+				{path: "barDir/bar.txt", fileContents: barBlob},
+			},
+			spec: &command.InputSpec{
+				Inputs: []string{"fooDir", "barDir"},
+				InputExclusions: []*command.InputExclusion{
+					&command.InputExclusion{Glob: `*.txt`, Type: command.FileInputType},
+				},
+				InputNodeProperties: map[string]*cpb.NodeProperties{"fooDir/foo": fooProperties},
+			},
+			rootDir: &repb.Directory{Directories: []*repb.DirectoryNode{
+				{Name: "barDir", Digest: barDirDgPb},
+				{Name: "fooDir", Digest: fooDirDgPb},
+			}},
+			additionalBlobs: [][]byte{fooBlob, barBlob, fooDirBlob, barDirBlob},
+			wantCacheCalls: map[string]int{
+				"fooDir":         1,
+				"fooDir/foo":     1,
+				"fooDir/foo.txt": 1,
+				"barDir":         1,
+				"barDir/bar":     1,
+				"barDir/bar.txt": 1,
+			},
+			wantStats: &client.TreeStats{
+				InputDirectories: 3,
+				InputFiles:       2,
+				TotalInputBytes:  fooDg.Size + fooDirDg.Size + barDg.Size + barDirDg.Size,
+				ExcludedInputs:   2,
+			},
+		},
+		{
+			desc: "Directory glob exclusions",
+			input: []*inputPath{
+				{path: "foo", fileContents: fooBlob, isExecutable: true},
+				{path: "fooDir/foo", fileContents: fooBlob, isExecutable: true},
+				{path: "fooDir/sub/foo", fileContents: fooBlob, isExecutable: true},
+				{path: "barDir/bar", fileContents: barBlob},
+			},
+			spec: &command.InputSpec{
+				Inputs: []string{"foo", "fooDir", "barDir"},
+				InputExclusions: []*command.InputExclusion{
+					&command.InputExclusion{Glob: `fooDir/**`},
+				},
+			},
+			rootDir: &repb.Directory{
+				Directories: []*repb.DirectoryNode{{Name: "barDir", Digest: barDirDgPb}},
+				Files:       []*repb.FileNode{{Name: "foo", Digest: fooDgPb, IsExecutable: true}},
+			},
+			additionalBlobs: [][]byte{fooBlob, barBlob, barDirBlob},
+			// The excluded directory is not descended into.
+			wantCacheCalls: map[string]int{
+				"foo":        1,
+				"fooDir":     1,
+				"barDir":     1,
+				"barDir/bar": 1,
+			},
+			wantStats: &client.TreeStats{
+				InputDirectories: 2,
+				InputFiles:       2,
+				TotalInputBytes:  fooDg.Size + barDg.Size + barDirDg.Size,
+				ExcludedInputs:   1,
 			},
 		},
 		{
@@ -1265,6 +1931,7 @@ func TestComputeMerkleTree(t *testing.T) {
 				InputDirectories: 2,
 				InputFiles:       2,
 				TotalInputBytes:  fooDg.Size + barDg.Size + barDirDg.Size,
+				ExcludedInputs:   1,
 			},
 		},
 		{
@@ -1294,6 +1961,7 @@ func TestComputeMerkleTree(t *testing.T) {
 				InputDirectories: 2,
 				InputFiles:       1,
 				TotalInputBytes:  barDg.Size + barDirDg.Size,
+				ExcludedInputs:   2,
 			},
 		},
 		{
@@ -1672,6 +2340,16 @@ func TestComputeMerkleTreeErrors(t *testing.T) {
 			},
 			spec: &command.InputSpec{Inputs: []string{"a", "dir", "dir/b"}},
 		},
+		{
+			desc: "invalid exclusion regex",
+			input: []*inputPath{
+				{path: "foo", fileContents: fooBlob},
+			},
+			spec: &command.InputSpec{
+				Inputs:          []string{"foo"},
+				InputExclusions: []*command.InputExclusion{{Regex: `foo(`}},
+			},
+		},
 		{
 			desc: "Preserved symlink escaping exec root",
 			input: []*inputPath{
@@ -1698,6 +2376,45 @@ func TestComputeMerkleTreeErrors(t *testing.T) {
 				Preserved:                  true,
 			},
 		},
+		{
+			desc: "Dangling symlink materialized with NoDangling",
+			input: []*inputPath{
+				{path: "danglingSym", isSymlink: true, relSymlinkTarget: "doesNotExist"},
+			},
+			spec: &command.InputSpec{
+				Inputs: []string{"danglingSym"},
+			},
+			treeOpts: &client.TreeSymlinkOpts{
+				NoDangling: true,
+			},
+		},
+		{
+			desc: "Dangling symlink preserved with NoDangling",
+			input: []*inputPath{
+				{path: "danglingSym", isSymlink: true, relSymlinkTarget: "doesNotExist"},
+			},
+			spec: &command.InputSpec{
+				Inputs: []string{"danglingSym"},
+			},
+			treeOpts: &client.TreeSymlinkOpts{
+				Preserved:  true,
+				NoDangling: true,
+			},
+		},
+		{
+			desc: "Absolute symlink target not allowed by the server",
+			input: []*inputPath{
+				{path: "../foo", fileContents: fooBlob},
+				{path: "absSym", isSymlink: true, isAbsolute: true, relSymlinkTarget: "../foo"},
+			},
+			spec: &command.InputSpec{
+				Inputs: []string{"absSym"},
+			},
+			treeOpts: &client.TreeSymlinkOpts{
+				Preserved:       true,
+				AbsoluteTargets: true,
+			},
+		},
 	}
 
 	for _, tc := range tests {
@@ -1717,6 +2434,42 @@ func TestComputeMerkleTreeErrors(t *testing.T) {
 	}
 }
 
+func TestComputeMerkleTreeAbsoluteSymlinks(t *testing.T) {
+	root := t.TempDir()
+	target := filepath.Join(t.TempDir(), "foo")
+	if err := os.WriteFile(target, fooBlob, 0666); err != nil {
+		t.Fatalf("os.WriteFile(%q) failed: %v", target, err)
+	}
+	if err := os.Symlink(target, filepath.Join(root, "absSym")); err != nil {
+		t.Fatalf("os.Symlink(%q) failed: %v", target, err)
+	}
+	e, cleanup := fakes.NewTestEnv(t)
+	defer cleanup()
+	e.Server.Exec.AllowAbsoluteSymlinks = true
+	c, err := e.Server.NewTestClient(context.Background())
+	if err != nil {
+		t.Fatalf("NewTestClient() failed: %v", err)
+	}
+	defer c.Close()
+	if !c.SupportsAbsoluteSymlinks() {
+		t.Fatalf("SupportsAbsoluteSymlinks() = false, want true")
+	}
+	(&client.TreeSymlinkOpts{Preserved: true, AbsoluteTargets: true}).Apply(c)
+
+	spec := &command.InputSpec{Inputs: []string{"absSym"}}
+	gotRoot, _, stats, err := c.ComputeMerkleTree(context.Background(), root, "", "", spec, filemetadata.NewNoopCache())
+	if err != nil {
+		t.Fatalf("ComputeMerkleTree(%v) failed: %v", spec, err)
+	}
+	wantRoot := digest.TestNewFromMessage(&repb.Directory{Symlinks: []*repb.SymlinkNode{{Name: "absSym", Target: target}}})
+	if gotRoot != wantRoot {
+		t.Errorf("ComputeMerkleTree(%v) gave root %v, want %v", spec, gotRoot, wantRoot)
+	}
+	if stats.InputSymlinks != 1 || stats.InputFiles != 0 {
+		t.Errorf("ComputeMerkleTree(%v) gave stats %+v, want a single symlink", spec, stats)
+	}
+}
+
 func TestFlattenTreeRepeated(t *testing.T) {
 	// Directory structure:
 	// <root>
@@ -2191,6 +2944,75 @@ func TestComputeOutputsToUploadDirectories(t *testing.T) {
 	}
 }
 
+func TestComputeOutputsToUploadWithNodeProperties(t *testing.T) {
+	input := []*inputPath{
+		{path: "foo", fileContents: fooBlob, isExecutable: true},
+		{path: "dir/bar", fileContents: barBlob},
+		{path: "dir/sl", isSymlink: true, relSymlinkTarget: "bar"},
+	}
+	root := t.TempDir()
+	if err := construct(root, input); err != nil {
+		t.Fatalf("failed to construct input dir structure: %v", err)
+	}
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, p := range []string{"foo", "dir/bar"} {
+		if err := os.Chtimes(filepath.Join(root, p), mtime, mtime); err != nil {
+			t.Fatalf("failed to set mtime of %v: %v", p, err)
+		}
+	}
+	if err := os.Chmod(filepath.Join(root, "dir/bar"), 0640); err != nil {
+		t.Fatalf("failed to set permissions of dir/bar: %v", err)
+	}
+	e, cleanup := fakes.NewTestEnv(t)
+	defer cleanup()
+	c := e.Client.GrpcClient
+	c.TreeSymlinkOpts = &client.TreeSymlinkOpts{Preserved: true}
+	// Explicitly provided properties take precedence over the captured ones.
+	nodeProperties := map[string]*cpb.NodeProperties{"foo": {UnixMode: wrapperspb.UInt32(0700)}}
+
+	blobs, gotResult, err := c.ComputeOutputsToUploadWithNodeProperties(root, "", []string{"foo", "dir"}, filemetadata.NewNoopCache(), command.UnspecifiedSymlinkBehavior, nodeProperties, []string{command.MtimeNodeProperty, command.UnixModeNodeProperty, "unknown"})
+	if err != nil {
+		t.Fatalf("ComputeOutputsToUploadWithNodeProperties(...) = gave error %v, want success", err)
+	}
+	wantFile := &repb.OutputFile{
+		Path:           "foo",
+		Digest:         fooDgPb,
+		IsExecutable:   true,
+		NodeProperties: &repb.NodeProperties{Mtime: timestamppb.New(mtime), UnixMode: wrapperspb.UInt32(0700)},
+	}
+	if len(gotResult.OutputFiles) != 1 {
+		t.Fatalf("ComputeOutputsToUploadWithNodeProperties(...) gave %d output files, want 1", len(gotResult.OutputFiles))
+	}
+	if diff := cmp.Diff(wantFile, gotResult.OutputFiles[0], protocmp.Transform()); diff != "" {
+		t.Errorf("ComputeOutputsToUploadWithNodeProperties(...) gave diff (-want +got) on output file:\n%s", diff)
+	}
+	if len(gotResult.OutputDirectories) != 1 {
+		t.Fatalf("ComputeOutputsToUploadWithNodeProperties(...) gave %d output directories, want 1", len(gotResult.OutputDirectories))
+	}
+	ue, ok := blobs[digest.NewFromProtoUnvalidated(gotResult.OutputDirectories[0].TreeDigest)]
+	if !ok {
+		t.Fatalf("ComputeOutputsToUploadWithNodeProperties(...) did not return the output tree blob")
+	}
+	tree := &repb.Tree{}
+	if err := proto.Unmarshal(ue.Contents, tree); err != nil {
+		t.Fatalf("failed unmarshalling tree: %v", err)
+	}
+	wantBar := &repb.FileNode{
+		Name:           "bar",
+		Digest:         barDgPb,
+		NodeProperties: &repb.NodeProperties{Mtime: timestamppb.New(mtime), UnixMode: wrapperspb.UInt32(0640)},
+	}
+	if len(tree.Root.Files) != 1 || len(tree.Root.Symlinks) != 1 {
+		t.Fatalf("ComputeOutputsToUploadWithNodeProperties(...) gave unexpected tree root %v", tree.Root)
+	}
+	if diff := cmp.Diff(wantBar, tree.Root.Files[0], protocmp.Transform()); diff != "" {
+		t.Errorf("ComputeOutputsToUploadWithNodeProperties(...) gave diff (-want +got) on output tree file:\n%s", diff)
+	}
+	if got := tree.Root.Symlinks[0].GetNodeProperties().GetUnixMode().GetValue(); got != 0777 {
+		t.Errorf("ComputeOutputsToUploadWithNodeProperties(...) gave symlink mode %o, want 0777", got)
+	}
+}
+
 func TestComputeOutputsToUploadFileNoPermissions(t *testing.T) {
 	input := []*inputPath{
 		{path: "wd/foo", fileContents: fooBlob, isExecutable: true},
@@ -2292,3 +3114,62 @@ func BenchmarkComputeMerkleTree(b *testing.B) {
 		}
 	}
 }
+
+// BenchmarkComputeMerkleTreeWideTree compares reading a tree of many directories one at a time
+// with reading them concurrently ahead of the traversal.
+func BenchmarkComputeMerkleTreeWideTree(b *testing.B) {
+	e, cleanup := fakes.NewTestEnv(b)
+	defer cleanup()
+
+	randGen := rand.New(rand.NewSource(0))
+	var ips []*inputPath
+	for i := 0; i < 20; i++ {
+		for j := 0; j < 20; j++ {
+			for k := 0; k < 10; k++ {
+				ips = append(ips, &inputPath{path: fmt.Sprintf("d%d/d%d/f%d", i, j, k), fileContents: randomBytes(randGen, 256)})
+			}
+		}
+	}
+	if err := construct(e.ExecRoot, ips); err != nil {
+		b.Fatalf("failed to construct input dir structure: %v", err)
+	}
+	inputSpec := &command.InputSpec{Inputs: []string{"."}}
+
+	for _, conc := range []int{1, client.DefaultLocalTraversalConcurrency} {
+		b.Run(fmt.Sprintf("concurrency=%d", conc), func(b *testing.B) {
+			(&client.TraversalConcurrency{Default: conc}).Apply(e.Client.GrpcClient)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				fmc := filemetadata.NewSingleFlightCache()
+				if _, _, _, err := e.Client.GrpcClient.ComputeMerkleTree(context.Background(), e.ExecRoot, "", "", inputSpec, fmc); err != nil {
+					b.Errorf("Failed to compute merkle tree: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkComputeOutputsToUpload(b *testing.B) {
+	e, cleanup := fakes.NewTestEnv(b)
+	defer cleanup()
+
+	randGen := rand.New(rand.NewSource(0))
+	var ips []*inputPath
+	for i := 0; i < 10; i++ {
+		for j := 0; j < 10; j++ {
+			ips = append(ips, &inputPath{path: fmt.Sprintf("out/d%d/d%d/f", i, j), fileContents: randomBytes(randGen, 64)})
+		}
+	}
+	construct(e.ExecRoot, ips)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		fmc := filemetadata.NewSingleFlightCache()
+		_, _, err := e.Client.GrpcClient.ComputeOutputsToUpload(e.ExecRoot, "", []string{"out"}, fmc, command.UnspecifiedSymlinkBehavior, nil)
+		if err != nil {
+			b.Errorf("Failed to compute outputs to upload: %v", err)
+		}
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"sort"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/filemetadata"
 	"github.com/google/go-cmp/cmp"
@@ -295,3 +296,126 @@ func TestEvalParentSymlinks(t *testing.T) {
 		})
 	}
 }
+
+func TestTraversalConcurrencyForDir(t *testing.T) {
+	tc := &TraversalConcurrency{
+		Overrides: map[string]int{
+			"/root/nfs":        2,
+			"/root/nfs/cached": 16,
+		},
+	}
+	tests := []struct {
+		desc string
+		tc   *TraversalConcurrency
+		dir  string
+		want int
+	}{
+		{desc: "unset", tc: nil, dir: "/root/nfs", want: 8},
+		{desc: "root default", tc: tc, dir: "/root/src", want: 8},
+		{desc: "explicit default", tc: &TraversalConcurrency{Default: 3}, dir: "/root/src", want: 3},
+		{desc: "override", tc: tc, dir: "/root/nfs/a", want: 2},
+		{desc: "override of prefix itself", tc: tc, dir: "/root/nfs", want: 2},
+		{desc: "longest override", tc: tc, dir: "/root/nfs/cached/a", want: 16},
+		{desc: "not a path prefix", tc: tc, dir: "/root/nfs2", want: 8},
+		{desc: "non-positive default", tc: &TraversalConcurrency{Default: -1}, dir: "/root/src", want: 8},
+	}
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.desc, func(t *testing.T) {
+			if got := tc.tc.forDir(tc.dir, 8); got != tc.want {
+				t.Errorf("forDir(%q, 8) = %d, want %d", tc.dir, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestMetadataPrefetcherReadsAhead(t *testing.T) {
+	root := t.TempDir()
+	for _, path := range []string{"a/b/c/f", "a/g", "skipped/d/f"} {
+		path = filepath.Join(root, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("f"), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c := &Client{TraversalConcurrency: &TraversalConcurrency{Default: 4}}
+	pf := c.newMetadataPrefetcher(root, filemetadata.NewNoopCache())
+	pf.skipDir = func(path string) bool { return filepath.Base(path) == "skipped" }
+	defer pf.close()
+
+	names, info, err := pf.list(root)
+	if err != nil {
+		t.Fatalf("list(%q) failed: %v", root, err)
+	}
+	if !info.IsDir() {
+		t.Errorf("list(%q) gave state %v, want a directory", root, info.Mode())
+	}
+	sort.Strings(names)
+	if diff := cmp.Diff([]string{"a", "skipped"}, names); diff != "" {
+		t.Errorf("list(%q) gave diff (-want +got):\n%s", root, diff)
+	}
+	// The subdirectories are read without being listed by the traversal.
+	for _, dir := range []string{"a", "a/b", "a/b/c"} {
+		dir = filepath.Join(root, dir)
+		deadline := time.Now().Add(10 * time.Second)
+		for {
+			if l, ok := pf.listings.Load(dir); ok && l.(*dirListing).ready.Load() {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("directory %q was not read ahead", dir)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	if md := pf.get(filepath.Join(root, "a/b/c/f")); md.Digest.Size != 1 || md.IsDirectory {
+		t.Errorf("get() of a prefetched file = %+v, want a 1 byte file", md)
+	}
+	if _, ok := pf.listings.Load(filepath.Join(root, "skipped")); ok {
+		t.Errorf("skipped directory was read ahead")
+	}
+}
+
+func TestDirectoryCacheInvalidate(t *testing.T) {
+	dc := NewDirectoryCache()
+	abs := func(p string) string { return filepath.Join(string(filepath.Separator), "root", p) }
+	cached := func() []string {
+		var got []string
+		for p := range dc.entries {
+			got = append(got, p)
+		}
+		sort.Strings(got)
+		return got
+	}
+
+	l := dc.newLookup(abs(""), &TreeSymlinkOpts{}, false, nil, nil, nil)
+	for _, p := range []string{"a", "a/b", "a/b/c", "d", "e", "e/f"} {
+		l.packed[abs(p)] = &cachedDir{}
+	}
+	// Only the directories containing the invalidated file are not stored.
+	dc.Invalidate(abs("a/b/file"))
+	l.store()
+	l.done()
+	if len(dc.lookups) != 0 {
+		t.Errorf("DirectoryCache has %d lookups after they are done, want 0", len(dc.lookups))
+	}
+	want := []string{abs("a/b/c"), abs("d"), abs("e"), abs("e/f")}
+	if diff := cmp.Diff(want, cached()); diff != "" {
+		t.Errorf("DirectoryCache after a concurrent invalidation gave diff (-want +got):\n%s", diff)
+	}
+
+	dc.Invalidate(abs("e"))
+	if diff := cmp.Diff([]string{abs("a/b/c"), abs("d")}, cached()); diff != "" {
+		t.Errorf("DirectoryCache after invalidating a directory gave diff (-want +got):\n%s", diff)
+	}
+	if _, ok := dc.children[abs("e")]; ok {
+		t.Errorf("DirectoryCache still indexes the children of the invalidated directory %s", abs("e"))
+	}
+	dc.Invalidate(abs("d"))
+	dc.Invalidate(abs("a"))
+	if len(dc.entries) != 0 || len(dc.children) != 0 {
+		t.Errorf("DirectoryCache has entries %v and index %v after invalidating every directory, want none", dc.entries, dc.children)
+	}
+}
@@ -0,0 +1,359 @@
+package client
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/command"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/contextmd"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/digest"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/filemetadata"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/uploadinfo"
+	log "github.com/golang/glog"
+)
+
+// UploadResult is the result of an entry passed to Upload.
+type UploadResult struct {
+	// Entry is the entry. For entries created with uploadinfo.EntryFromUndigestedFile, it is a new
+	// entry with the computed digest, unless digesting failed.
+	Entry *uploadinfo.Entry
+	// Missing is whether the blob was missing from the CAS, and uploaded. Like BytesMoved, it is
+	// only set in the result of the first entry of each blob, to prevent double accounting.
+	Missing bool
+	// BytesMoved is the number of bytes transferred to upload the blob, after compression.
+	BytesMoved int64
+	// Err is the error digesting or uploading the entry, if any.
+	Err error
+}
+
+// Upload uploads the missing blobs among the entries received from the channel, and sends the
+// result of each entry on the returned channel, in no particular order. It does not block: the
+// entries go through streaming stages, which digest the entries created with
+// uploadinfo.EntryFromUndigestedFile, dedup the blobs, query them with FindMissingBlobs in batches
+// of up to MaxQueryBatchDigests and upload the missing ones. A partial batch is queried at least
+// every UnifiedUploadTickDuration. The stages are connected by queues of UploadQueueSize entries,
+// so that a slow upload eventually blocks the producer of entries rather than buffering them all.
+//
+// The caller must read the results until the channel is closed, which happens once entries is
+// closed and all its entries have results. After ctx is cancelled, the remaining entries get
+// errors.
+func (c *Client) Upload(ctx context.Context, entries <-chan *uploadinfo.Entry) <-chan *UploadResult {
+	results := make(chan *UploadResult, c.uploadQueueSize())
+	ctx, done, err := c.beginOp(ctx)
+	if err != nil {
+		go func() {
+			defer close(results)
+			for ue := range entries {
+				results <- &UploadResult{Entry: ue, Err: err}
+			}
+		}()
+		return results
+	}
+	go func() {
+		defer done()
+		defer close(results)
+		c.uploadStream(ctx, entries, results)
+	}()
+	return results
+}
+
+// UploadIfMissingStream is like UploadIfMissing, but takes the entries from a channel, so that
+// the upload overlaps with producing them. It runs the stages of Upload and returns once entries is
+// closed and all uploads are done; after a failure, the remaining entries are drained, but not
+// uploaded. ComputeMerkleTreeAndUpload uses it to upload the inputs of an action while walking them.
+func (c *Client) UploadIfMissingStream(ctx context.Context, entries <-chan *uploadinfo.Entry) ([]digest.Digest, int64, error) {
+	ctx, done, err := c.beginOp(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer done()
+	return c.uploadPipelined(ctx, entries)
+}
+
+// ComputeMerkleTreeAndUpload is like ComputeMerkleTree, but also uploads the missing inputs, like
+// UploadIfMissingStream. The input files are fed to the upload as the traversal finds them, so that
+// querying and uploading them overlaps with walking and digesting the rest of the tree; the
+// Directory protos follow once the tree is packaged. It returns the missing digests and the bytes
+// moved along with the root digest and the stats of the tree.
+func (c *Client) ComputeMerkleTreeAndUpload(ctx context.Context, execRoot, workingDir, remoteWorkingDir string, is *command.InputSpec, cache filemetadata.Cache) (root digest.Digest, stats *TreeStats, missing []digest.Digest, bytesMoved int64, err error) {
+	ctx, done, err := c.beginOp(ctx)
+	if err != nil {
+		return digest.Empty, nil, nil, 0, err
+	}
+	defer done()
+	uCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	entries := make(chan *uploadinfo.Entry, c.uploadQueueSize())
+	uploaded := make(chan struct{})
+	go func() {
+		defer close(uploaded)
+		missing, bytesMoved, err = c.uploadPipelined(uCtx, entries)
+	}()
+	// The traversal may find the same blob many times: it is only queued once.
+	queued := make(map[digest.Digest]bool)
+	emit := func(ue *uploadinfo.Entry) {
+		if !queued[ue.Digest] {
+			queued[ue.Digest] = true
+			entries <- ue
+		}
+	}
+	root, inputs, stats, tErr := c.computeMerkleTree(ctx, execRoot, workingDir, remoteWorkingDir, is, cache, emit)
+	if tErr != nil {
+		cancel()
+	} else {
+		for _, ue := range inputs {
+			emit(ue)
+		}
+	}
+	close(entries)
+	<-uploaded
+	if tErr != nil {
+		return digest.Empty, nil, nil, 0, tErr
+	}
+	return root, stats, missing, bytesMoved, err
+}
+
+// uploadPipelined uploads the missing blobs among the entries received from the channel through
+// uploadStream, and returns the missing digests, the bytes moved and the first error.
+func (c *Client) uploadPipelined(ctx context.Context, entries <-chan *uploadinfo.Entry) ([]digest.Digest, int64, error) {
+	sCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make(chan *UploadResult, c.uploadQueueSize())
+	go func() {
+		defer close(results)
+		c.uploadStream(sCtx, entries, results)
+	}()
+	var (
+		missing []digest.Digest
+		total   int64
+		err     error
+	)
+	for r := range results {
+		if r.Missing {
+			missing = append(missing, r.Entry.Digest)
+		}
+		total += r.BytesMoved
+		if r.Err != nil && err == nil {
+			err = r.Err
+			cancel()
+		}
+	}
+	if err == nil {
+		err = ctx.Err()
+	}
+	return missing, total, err
+}
+
+func (c *Client) uploadQueueSize() int {
+	if c.UploadQueueSize <= 0 {
+		return DefaultUploadQueueSize
+	}
+	return int(c.UploadQueueSize)
+}
+
+// uploadBlob is a blob deduped by uploadStream, with the entries waiting for its result.
+type uploadBlob struct {
+	ue      *uploadinfo.Entry
+	done    bool
+	err     error
+	waiting []*uploadinfo.Entry
+}
+
+// uploadStream runs the stages of Upload, sending the results to the channel, and returns once
+// entries is closed and all the results are sent. The stages are:
+//   - digesting, by runtime.NumCPU() workers;
+//   - dedup and batching, by a single goroutine;
+//   - presence checking and uploading, by up to the CAS upload concurrency workers.
+//
+// Blobs that concurrent calls are already uploading are not queried nor uploaded again, but waited
+// for.
+func (c *Client) uploadStream(ctx context.Context, entries <-chan *uploadinfo.Entry, results chan<- *UploadResult) {
+	queueSize := c.uploadQueueSize()
+	digested := make(chan *uploadinfo.Entry, queueSize)
+	var digesters sync.WaitGroup
+	for i := 0; i < runtime.NumCPU(); i++ {
+		digesters.Add(1)
+		go func() {
+			defer digesters.Done()
+			for ue := range entries {
+				if ue.IsDigested() {
+					digested <- ue
+					continue
+				}
+				if err := ctx.Err(); err != nil {
+					results <- &UploadResult{Entry: ue, Err: err}
+					continue
+				}
+				dg, err := c.digestFn.NewFromFile(ue.Path)
+				if err != nil {
+					results <- &UploadResult{Entry: ue, Err: err}
+					continue
+				}
+				digested <- uploadinfo.EntryFromFile(dg, ue.Path)
+			}
+		}()
+	}
+	go func() {
+		digesters.Wait()
+		close(digested)
+	}()
+
+	var mu sync.Mutex
+	blobs := make(map[digest.Digest]*uploadBlob)
+	// finish records the result of the blobs and sends it to all their entries.
+	finish := func(batch []*uploadBlob, missing map[digest.Digest]bool, moved map[digest.Digest]int64, err error) {
+		for _, b := range batch {
+			dg := b.ue.Digest
+			mu.Lock()
+			b.done, b.err = true, err
+			waiting := b.waiting
+			b.waiting = nil
+			mu.Unlock()
+			results <- &UploadResult{Entry: b.ue, Missing: missing[dg], BytesMoved: moved[dg], Err: err}
+			for _, ue := range waiting {
+				results <- &UploadResult{Entry: ue, Err: err}
+			}
+		}
+	}
+
+	batches := make(chan []*uploadBlob)
+	workers := int(c.uploadConcurrency)
+	if workers < 1 {
+		workers = 1
+	}
+	var uploaders sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		uploaders.Add(1)
+		go func() {
+			defer uploaders.Done()
+			for batch := range batches {
+				c.uploadBatch(ctx, batch, finish)
+			}
+		}()
+	}
+
+	batchSize := int(c.MaxQueryBatchDigests)
+	if batchSize <= 0 {
+		batchSize = DefaultMaxQueryBatchDigests
+	}
+	tick := time.Duration(c.UnifiedUploadTickDuration)
+	if tick <= 0 {
+		tick = time.Duration(DefaultUnifiedUploadTickDuration)
+	}
+	var batch []*uploadBlob
+	timer := time.NewTimer(tick)
+	defer timer.Stop()
+	for open := true; open; {
+		var ue *uploadinfo.Entry
+		select {
+		case ue, open = <-digested:
+		case <-timer.C:
+			if len(batch) > 0 {
+				batches <- batch
+				batch = nil
+			}
+			timer.Reset(tick)
+			continue
+		}
+		if ue != nil {
+			dg := ue.Digest
+			if dg.IsEmpty() {
+				contextmd.Infof(ctx, log.Level(2), "Skipping upload of empty blob %s", dg)
+				results <- &UploadResult{Entry: ue}
+				continue
+			}
+			mu.Lock()
+			b, ok := blobs[dg]
+			done, err := ok && b.done, error(nil)
+			switch {
+			case !ok:
+				b = &uploadBlob{ue: ue}
+				blobs[dg] = b
+				batch = append(batch, b)
+			case done:
+				err = b.err
+			default:
+				b.waiting = append(b.waiting, ue)
+			}
+			mu.Unlock()
+			if done {
+				results <- &UploadResult{Entry: ue, Err: err}
+			}
+		}
+		if len(batch) >= batchSize || !open && len(batch) > 0 {
+			batches <- batch
+			batch = nil
+		}
+	}
+	close(batches)
+	uploaders.Wait()
+}
+
+// uploadBatch queries and uploads the missing blobs of the batch, and passes their results to
+// finish.
+func (c *Client) uploadBatch(ctx context.Context, batch []*uploadBlob, finish func([]*uploadBlob, map[digest.Digest]bool, map[digest.Digest]int64, error)) {
+	byDigest := make(map[digest.Digest]*uploadBlob, len(batch))
+	ueList := make(map[digest.Digest]*uploadinfo.Entry, len(batch))
+	dgs := make([]digest.Digest, 0, len(batch))
+	for _, b := range batch {
+		byDigest[b.ue.Digest] = b
+		ueList[b.ue.Digest] = b.ue
+		dgs = append(dgs, b.ue.Digest)
+	}
+	blobsOf := func(dgs []digest.Digest) []*uploadBlob {
+		bs := make([]*uploadBlob, 0, len(dgs))
+		for _, dg := range dgs {
+			bs = append(bs, byDigest[dg])
+		}
+		return bs
+	}
+
+	owned, joined := c.claimUploads(dgs)
+	missing, moved, err := c.queryAndUpload(ctx, owned, ueList)
+	c.releaseUploads(owned, err)
+	finish(blobsOf(owned), missing, moved, err)
+
+	// The blobs being uploaded by concurrent calls are only uploaded again if that failed.
+	var retry []digest.Digest
+	for dg, f := range joined {
+		select {
+		case <-f.done:
+		case <-ctx.Done():
+			finish(blobsOf([]digest.Digest{dg}), nil, nil, ctx.Err())
+			continue
+		}
+		if f.err != nil {
+			retry = append(retry, dg)
+		} else {
+			finish(blobsOf([]digest.Digest{dg}), nil, nil, nil)
+		}
+	}
+	if len(retry) > 0 {
+		missing, moved, err := c.queryAndUpload(ctx, retry, ueList)
+		finish(blobsOf(retry), missing, moved, err)
+	}
+}
+
+// queryAndUpload uploads the blobs of dgs that are missing from the CAS, and returns which were
+// missing and the bytes transferred for each.
+func (c *Client) queryAndUpload(ctx context.Context, dgs []digest.Digest, ueList map[digest.Digest]*uploadinfo.Entry) (map[digest.Digest]bool, map[digest.Digest]int64, error) {
+	if len(dgs) == 0 {
+		return nil, nil, nil
+	}
+	missing, err := c.MissingBlobs(ctx, dgs)
+	if err != nil {
+		return nil, nil, err
+	}
+	isMissing := make(map[digest.Digest]bool, len(missing))
+	for _, dg := range missing {
+		isMissing[dg] = true
+	}
+	moved, err := c.uploadMissing(ctx, missing, ueList)
+	if err != nil {
+		return isMissing, moved, err
+	}
+	c.addUploaded(ctx, missing)
+	return isMissing, moved, nil
+}
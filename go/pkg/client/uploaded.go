@@ -0,0 +1,108 @@
+package client
+
+import (
+	"context"
+
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/digest"
+
+	log "github.com/golang/glog"
+)
+
+// UploadedDigests is a record of digests recently uploaded to, or found in, the CAS. The client
+// consults it to skip FindMissingBlobs queries and uploads of those digests. The uploaddedup
+// package provides one that is shared by all processes of a machine.
+type UploadedDigests interface {
+	// Uploaded returns the subset of dgs known to be in the CAS.
+	Uploaded(ctx context.Context, dgs []digest.Digest) ([]digest.Digest, error)
+	// Add records that dgs are in the CAS.
+	Add(ctx context.Context, dgs []digest.Digest) error
+}
+
+// UploadDedup is an Opt that sets the record of uploaded digests the client consults. Errors of
+// the record are logged and otherwise ignored, so the client keeps working if it is unavailable.
+type UploadDedup struct {
+	UploadedDigests
+}
+
+// Apply sets the client's record of uploaded digests.
+func (u UploadDedup) Apply(c *Client) {
+	c.UploadedDigests = u.UploadedDigests
+}
+
+// notUploaded returns the digests of dgs that are not known to be in the CAS.
+func (c *Client) notUploaded(ctx context.Context, dgs []digest.Digest) []digest.Digest {
+	if c.UploadedDigests == nil || len(dgs) == 0 {
+		return dgs
+	}
+	uploaded, err := c.UploadedDigests.Uploaded(ctx, dgs)
+	if err != nil {
+		log.Warningf("Failed to look up uploaded digests: %v", err)
+		return dgs
+	}
+	if len(uploaded) == 0 {
+		return dgs
+	}
+	known := make(map[digest.Digest]bool, len(uploaded))
+	for _, dg := range uploaded {
+		known[dg] = true
+	}
+	var res []digest.Digest
+	for _, dg := range dgs {
+		if !known[dg] {
+			res = append(res, dg)
+		}
+	}
+	return res
+}
+
+// uploadFlight is an upload of a blob in progress, which concurrent uploads of the same blob wait
+// for instead of querying and uploading it again.
+type uploadFlight struct {
+	done chan struct{}
+	err  error
+}
+
+// claimUploads splits dgs into the digests the caller is to query and upload, which it must then
+// release with releaseUploads, and the flights of those already being uploaded by other callers.
+func (c *Client) claimUploads(dgs []digest.Digest) (owned []digest.Digest, joined map[digest.Digest]*uploadFlight) {
+	c.uploadFlightsMu.Lock()
+	defer c.uploadFlightsMu.Unlock()
+	if c.uploadFlights == nil {
+		c.uploadFlights = make(map[digest.Digest]*uploadFlight)
+	}
+	for _, dg := range dgs {
+		if f, ok := c.uploadFlights[dg]; ok {
+			if joined == nil {
+				joined = make(map[digest.Digest]*uploadFlight)
+			}
+			joined[dg] = f
+			continue
+		}
+		c.uploadFlights[dg] = &uploadFlight{done: make(chan struct{})}
+		owned = append(owned, dg)
+	}
+	return owned, joined
+}
+
+// releaseUploads ends the flights of dgs claimed with claimUploads, with the error of their upload.
+func (c *Client) releaseUploads(dgs []digest.Digest, err error) {
+	c.uploadFlightsMu.Lock()
+	defer c.uploadFlightsMu.Unlock()
+	for _, dg := range dgs {
+		f := c.uploadFlights[dg]
+		delete(c.uploadFlights, dg)
+		f.err = err
+		close(f.done)
+	}
+}
+
+// addUploaded records that dgs are in the CAS.
+func (c *Client) addUploaded(ctx context.Context, dgs []digest.Digest) {
+	c.missingBlobs.forget(dgs)
+	if c.UploadedDigests == nil || len(dgs) == 0 {
+		return
+	}
+	if err := c.UploadedDigests.Add(ctx, dgs); err != nil {
+		log.Warningf("Failed to record uploaded digests: %v", err)
+	}
+}
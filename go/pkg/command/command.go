@@ -2,21 +2,26 @@
 package command
 
 import (
-	"crypto/sha256"
-	"encoding/hex"
 	"errors"
 	"fmt"
 	"os"
+	"path"
+	"path/filepath"
 	"sort"
 	"strings"
 	"time"
 
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/digest"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/version"
 	"github.com/pborman/uuid"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoregistry"
 
 	cpb "github.com/bazelbuild/remote-apis-sdks/go/api/command"
 	repb "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
 	anypb "google.golang.org/protobuf/types/known/anypb"
+	dpb "google.golang.org/protobuf/types/known/durationpb"
 	tspb "google.golang.org/protobuf/types/known/timestamppb"
 )
 
@@ -37,15 +42,53 @@ const (
 	SymlinkInputType
 )
 
-var inputTypes = [...]string{"UnspecifiedInputType", "DirectoryInputType", "FileInputType"}
+var inputTypes = [...]string{"UnspecifiedInputType", "DirectoryInputType", "FileInputType", "SymlinkInputType"}
 
 func (s InputType) String() string {
-	if UnspecifiedInputType <= s && s <= FileInputType {
+	if UnspecifiedInputType <= s && s <= SymlinkInputType {
 		return inputTypes[s]
 	}
 	return fmt.Sprintf("InvalidInputType(%d)", s)
 }
 
+// OutputType is the type of a command output.
+type OutputType int
+
+const (
+	// FileOutputType means the output is a file.
+	FileOutputType OutputType = iota
+
+	// DirectoryOutputType means the output is a directory.
+	DirectoryOutputType
+
+	// WildcardOutputType means the output may be a file or a directory, which is only known once
+	// the command ran. Servers only supporting the RE API before v2.1, which requires the type of
+	// outputs, are asked for a file.
+	WildcardOutputType
+
+	// GlobOutputType means the path is a pattern, see MatchOutputGlob, and the outputs are the
+	// files matching it. The pattern must have a directory without wildcards, see OutputGlobRoot.
+	GlobOutputType
+)
+
+var outputTypes = [...]string{"FileOutputType", "DirectoryOutputType", "WildcardOutputType", "GlobOutputType"}
+
+func (s OutputType) String() string {
+	if FileOutputType <= s && s <= GlobOutputType {
+		return outputTypes[s]
+	}
+	return fmt.Sprintf("InvalidOutputType(%d)", s)
+}
+
+// OutputSpec is an output of a command.
+type OutputSpec struct {
+	// Path is the path of the output, relative to the working directory.
+	Path string
+
+	// Type is the type of the output.
+	Type OutputType
+}
+
 // SymlinkBehaviorType represents how symlinks are handled.
 type SymlinkBehaviorType int
 
@@ -72,9 +115,15 @@ func (s SymlinkBehaviorType) String() string {
 
 // InputExclusion represents inputs to be excluded from being considered for command execution.
 type InputExclusion struct {
-	// Required: the path regular expression to match for exclusion.
+	// The path regular expression to match for exclusion, against absolute paths. Exactly one of
+	// Regex and Glob is required.
 	Regex string
 
+	// The glob pattern to match for exclusion, e.g. "*.txt" or "node_modules/**". It matches the
+	// last segments of the slash-separated paths relative to the exec root, as in MatchOutputGlob,
+	// so it never matches the directories above the exec root.
+	Glob string
+
 	// The input type to match for exclusion.
 	Type InputType
 }
@@ -92,6 +141,12 @@ type VirtualInput struct {
 	// Should not be used together with Contents.
 	Digest string
 
+	// The digest of a Tree in the CAS, such as the TreeDigest of an output directory of a previous
+	// action, to be staged as the directory at Path. Its files are referenced by digest rather than
+	// downloaded, so that the outputs of chained actions never reach the client. Should not be used
+	// together with Contents or Digest.
+	TreeDigest string
+
 	// Whether the file should be staged as executable.
 	IsExecutable bool
 
@@ -121,10 +176,22 @@ type InputSpec struct {
 	// Environment variables the command relies on.
 	EnvironmentVariables map[string]string
 
+	// EnvironmentPassthrough are the names of variables of the local environment that are added to
+	// EnvironmentVariables when the command is prepared, see FillDefaultFieldValues. Variables set
+	// in EnvironmentVariables take precedence, and variables unset locally are skipped.
+	EnvironmentPassthrough []string
+
+	// EnvironmentAllowlist, if set, are the names of the only environment variables kept in
+	// EnvironmentVariables when the command is prepared, after EnvironmentPassthrough. The others
+	// are dropped, so that volatile variables do not cause cache misses by changing the Action.
+	EnvironmentAllowlist []string
+
 	// SymlinkBehavior represents the way symlinks will be handled.
 	SymlinkBehavior SymlinkBehaviorType
 
-	// Node properties of inputs.
+	// Node properties of inputs, by path relative to the exec root, such as their mtime or UNIX
+	// mode, overriding what the file system reports. The UNIX mode also sets whether a file is
+	// executable.
 	InputNodeProperties map[string]*cpb.NodeProperties
 }
 
@@ -138,6 +205,15 @@ func (s *InputExclusion) String() string {
 	return fmt.Sprintf("%+v", *s)
 }
 
+// Pattern returns the regular expression matching the excluded paths: the Regex, or the Glob
+// translated to a regular expression.
+func (s *InputExclusion) Pattern() string {
+	if s.Glob == "" {
+		return s.Regex
+	}
+	return globToRegex(s.Glob)
+}
+
 // Identifiers is a group of identifiers of a command.
 type Identifiers struct {
 	// CommandID is an optional id to use to identify a command.
@@ -157,6 +233,22 @@ type Identifiers struct {
 
 	// ExecutionID is a UUID generated for a particular execution of this command.
 	ExecutionID string
+
+	// ParentInvocationID is an optional id of the invocation the command's invocation is nested
+	// in, such as the build that started a sub-build.
+	ParentInvocationID string
+
+	// Attempt is an optional attempt number of the invocation, for invocations that are retried,
+	// such as CI builds. The first attempt is 1, and 0 means unknown.
+	Attempt int
+
+	// BuildPhase is an optional name of the build phase the command runs in, such as "test".
+	BuildPhase string
+
+	// Labels are optional annotations of the command, such as its target, mnemonic or team, sent to
+	// the remote server so that its logs can be sliced by them. Keys are lowercase letters, digits,
+	// '_', '.' and '-', starting with a letter or a digit.
+	Labels map[string]string
 }
 
 // Command encompasses the complete information required to execute a command remotely.
@@ -186,113 +278,259 @@ type Command struct {
 	// InputSpec: the command inputs.
 	InputSpec *InputSpec
 
-	// OutputFiles are the command output files.
+	// Outputs are the command outputs, with their types.
+	Outputs []*OutputSpec
+
+	// OutputFiles are the command output files, in addition to Outputs.
+	//
+	// Deprecated: use Outputs with FileOutputType.
 	OutputFiles []string
 
-	// OutputDirs are the command output directories.
-	// The files and directories will likely be merged into a single Outputs field in the future.
+	// OutputDirs are the command output directories, in addition to Outputs.
+	//
+	// Deprecated: use Outputs with DirectoryOutputType.
 	OutputDirs []string
 
-	// Timeout is an optional duration to wait for command execution before timing out.
+	// Timeout is an optional execution timeout for the command. Remotely, it is the Action's
+	// timeout, enforced by the worker on the command run only, and its expiry results in a
+	// TimeoutResultStatus. It does not bound the time spent queued on the server; see
+	// ExecutionOptions.ClientDeadline for that.
 	Timeout time.Duration
 
 	// Platform is the platform to use for the execution.
 	Platform map[string]string
+
+	// OutputNodeProperties are the node properties, e.g. MtimeNodeProperty, that should be
+	// captured for each output. They are requested from the server, and also captured locally when
+	// updating the remote cache with local results.
+	OutputNodeProperties []string
 }
 
-func marshallMap(m map[string]string, buf *[]byte) {
-	var pkeys []string
-	for k := range m {
-		pkeys = append(pkeys, k)
-	}
-	sort.Strings(pkeys)
-	for _, k := range pkeys {
-		*buf = append(*buf, []byte(k)...)
-		*buf = append(*buf, []byte(m[k])...)
-	}
+const (
+	// MtimeNodeProperty is the output node property for the modification time of an output.
+	MtimeNodeProperty = "mtime"
+
+	// UnixModeNodeProperty is the output node property for the UNIX file mode of an output.
+	UnixModeNodeProperty = "unix_mode"
+)
+
+// ValidationError is returned by Validate with all the problems found in a command.
+type ValidationError struct {
+	// Problems are the problems found, in the order of the fields of the command.
+	Problems []error
 }
 
-func marshallSlice(s []string, buf *[]byte) {
-	for _, i := range s {
-		*buf = append(*buf, []byte(i)...)
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Problems))
+	for i, p := range e.Problems {
+		msgs[i] = p.Error()
 	}
+	return strings.Join(msgs, "; ")
 }
 
-func marshallSortedSlice(s []string, buf *[]byte) {
-	ss := make([]string, len(s))
-	copy(ss, s)
-	sort.Strings(ss)
-	marshallSlice(ss, buf)
+// Unwrap returns the problems, for errors.Is and errors.As.
+func (e *ValidationError) Unwrap() []error {
+	return e.Problems
 }
 
-// Validate checks whether all required command fields have been specified.
+// Validate checks whether all required command fields have been specified, and that the paths of
+// the command stay within the exec root. All the problems found are reported in a
+// *ValidationError.
 func (c *Command) Validate() error {
 	if c == nil {
 		return nil
 	}
+	var errs []error
 	if len(c.Args) == 0 {
-		return errors.New("missing command arguments")
+		errs = append(errs, errors.New("missing command arguments"))
 	}
 	if c.ExecRoot == "" {
-		return errors.New("missing command exec root")
+		errs = append(errs, errors.New("missing command exec root"))
 	}
 	if c.InputSpec == nil {
-		return errors.New("missing command input spec")
+		errs = append(errs, errors.New("missing command input spec"))
 	}
 	if c.Identifiers == nil {
-		return errors.New("missing command identifiers")
+		errs = append(errs, errors.New("missing command identifiers"))
+	}
+	if c.WorkingDir != "" && !filepath.IsLocal(c.WorkingDir) {
+		errs = append(errs, fmt.Errorf("WorkingDir=%q is not under the exec root", c.WorkingDir))
+	}
+	if c.RemoteWorkingDir != "" && !filepath.IsLocal(c.RemoteWorkingDir) {
+		errs = append(errs, fmt.Errorf("RemoteWorkingDir=%q is not under the exec root", c.RemoteWorkingDir))
 	}
 	if c.RemoteWorkingDir != "" && levels(c.RemoteWorkingDir) != levels(c.WorkingDir) {
-		return fmt.Errorf("invalid RemoteWorkingDir=%q[%v level(s)], it's expected to have the same depth as WorkingDir=%q[%v level(s)]",
-			c.RemoteWorkingDir, levels(c.RemoteWorkingDir), c.WorkingDir, levels(c.WorkingDir))
+		errs = append(errs, fmt.Errorf("invalid RemoteWorkingDir=%q[%v level(s)], it's expected to have the same depth as WorkingDir=%q[%v level(s)]",
+			c.RemoteWorkingDir, levels(c.RemoteWorkingDir), c.WorkingDir, levels(c.WorkingDir)))
+	}
+	if is := c.InputSpec; is != nil {
+		for _, i := range is.Inputs {
+			if i != "" && !filepath.IsAbs(i) && !filepath.IsLocal(i) {
+				errs = append(errs, fmt.Errorf("input %q escapes the exec root", i))
+			}
+		}
+		for _, vi := range is.VirtualInputs {
+			if vi.Path != "" && !filepath.IsLocal(vi.Path) {
+				errs = append(errs, fmt.Errorf("virtual input %q is not under the exec root", vi.Path))
+			}
+		}
 	}
+	for _, o := range c.Outputs {
+		if o == nil || o.Path == "" {
+			errs = append(errs, errors.New("missing output path"))
+			continue
+		}
+		if o.Type < FileOutputType || o.Type > GlobOutputType {
+			errs = append(errs, fmt.Errorf("invalid type %v of output %q", o.Type, o.Path))
+		}
+		if o.Type == GlobOutputType {
+			if _, err := path.Match(o.Path, ""); err != nil {
+				errs = append(errs, fmt.Errorf("invalid output glob %q: %v", o.Path, err))
+			} else if OutputGlobRoot(o.Path) == "" {
+				errs = append(errs, fmt.Errorf("output glob %q has no directory without wildcards", o.Path))
+			}
+		}
+	}
+	errs = append(errs, c.validateOutputPaths()...)
 	// TODO(olaola): make Platform required?
+	if len(errs) > 0 {
+		return &ValidationError{Problems: errs}
+	}
 	return nil
 }
 
-// Generates a stable id for the command.
-func (c *Command) stableID() string {
-	var buf []byte
-	marshallSlice(c.Args, &buf)
-	buf = append(buf, []byte(c.ExecRoot)...)
-	buf = append(buf, []byte(c.WorkingDir)...)
-	marshallSortedSlice(c.OutputFiles, &buf)
-	marshallSortedSlice(c.OutputDirs, &buf)
-	buf = append(buf, []byte(c.Timeout.String())...)
-	marshallMap(c.Platform, &buf)
-	if c.InputSpec != nil {
-		marshallMap(c.InputSpec.EnvironmentVariables, &buf)
-		marshallSortedSlice(c.InputSpec.Inputs, &buf)
-		inputExclusions := make([]*InputExclusion, len(c.InputSpec.InputExclusions))
-		copy(inputExclusions, c.InputSpec.InputExclusions)
-		sort.Slice(inputExclusions, func(i, j int) bool {
-			e1 := inputExclusions[i]
-			e2 := inputExclusions[j]
-			return e1.Regex > e2.Regex || e1.Regex == e2.Regex && e1.Type > e2.Type
-		})
-		for _, e := range inputExclusions {
-			buf = append(buf, []byte(e.Regex)...)
-			buf = append(buf, []byte(e.Type.String())...)
+// validateOutputPaths checks that the outputs are relative paths under the exec root, and that
+// they do not overlap: an output may not be declared twice, and an output file may not be the
+// parent of another output.
+func (c *Command) validateOutputPaths() []error {
+	var errs []error
+	var files, all []string
+	seen := make(map[string]bool)
+	add := func(p string, file bool) {
+		if p == "" {
+			return
+		}
+		if filepath.IsAbs(p) {
+			errs = append(errs, fmt.Errorf("output %q is absolute, it must be relative to the working directory", p))
+			return
+		}
+		if !filepath.IsLocal(filepath.Join(c.WorkingDir, p)) {
+			errs = append(errs, fmt.Errorf("output %q escapes the exec root", p))
+			return
+		}
+		p = filepath.Clean(p)
+		if seen[p] {
+			errs = append(errs, fmt.Errorf("output %q is declared more than once", p))
+			return
+		}
+		seen[p] = true
+		all = append(all, p)
+		if file {
+			files = append(files, p)
+		}
+	}
+	for _, f := range c.OutputFiles {
+		add(f, true)
+	}
+	for _, d := range c.OutputDirs {
+		add(d, false)
+	}
+	for _, o := range c.Outputs {
+		if o != nil {
+			add(o.Path, o.Type == FileOutputType)
+		}
+	}
+	for _, f := range files {
+		for _, p := range all {
+			if strings.HasPrefix(p, f+string(filepath.Separator)) {
+				errs = append(errs, fmt.Errorf("output file %q is a parent of output %q", f, p))
+			}
 		}
 	}
-	sha256Arr := sha256.Sum256(buf)
-	return hex.EncodeToString(sha256Arr[:])[:8]
+	return errs
 }
 
+// OutputPaths returns the paths of the outputs of the command by type, from both Outputs and the
+// deprecated OutputFiles and OutputDirs. Output globs are replaced by their root directories.
+func (c *Command) OutputPaths() (files, dirs, wildcards []string) {
+	files = append(files, c.OutputFiles...)
+	dirs = append(dirs, c.OutputDirs...)
+	var globs []string
+	for _, o := range c.Outputs {
+		switch o.Type {
+		case FileOutputType:
+			files = append(files, o.Path)
+		case DirectoryOutputType:
+			dirs = append(dirs, o.Path)
+		case WildcardOutputType:
+			wildcards = append(wildcards, o.Path)
+		case GlobOutputType:
+			globs = append(globs, o.Path)
+		}
+	}
+	// Output directories may not be duplicated.
+	seen := make(map[string]bool)
+	for _, d := range dirs {
+		seen[d] = true
+	}
+	for _, g := range globs {
+		if root := OutputGlobRoot(g); !seen[root] {
+			seen[root] = true
+			dirs = append(dirs, root)
+		}
+	}
+	return files, dirs, wildcards
+}
+
+// OutputGlobs returns the output glob patterns of the command.
+func (c *Command) OutputGlobs() []string {
+	var globs []string
+	for _, o := range c.Outputs {
+		if o.Type == GlobOutputType {
+			globs = append(globs, o.Path)
+		}
+	}
+	return globs
+}
+
+// AllOutputPaths returns the paths of all the outputs of the command, whatever their type.
+func (c *Command) AllOutputPaths() []string {
+	files, dirs, wildcards := c.OutputPaths()
+	return append(append(files, dirs...), wildcards...)
+}
+
+// DefaultToolName and DefaultToolVersion identify the tool to the remote server, in the
+// RequestMetadata of the commands whose Identifiers do not name a tool. Tools embedding the SDK
+// may set them once at startup, so that server operators can tell the versions in the fleet apart.
+var (
+	DefaultToolName    = "remote-client"
+	DefaultToolVersion = version.SDK()
+)
+
 // FillDefaultFieldValues initializes valid default values to inner Command fields.
-// This function should be called on every new Command object before use.
+// This function should be called on every new Command object before use. The variables of
+// InputSpec.EnvironmentPassthrough are looked up at that point and kept in the command, so commands
+// that are run again should be filled as a Clone, as the executors do, to see the current
+// environment.
 func (c *Command) FillDefaultFieldValues() {
 	if c == nil {
 		return
 	}
+	if c.InputSpec == nil {
+		c.InputSpec = &InputSpec{}
+	}
+	c.InputSpec = c.InputSpec.resolveEnvironment(os.LookupEnv)
 	if c.Identifiers == nil {
 		c.Identifiers = &Identifiers{}
 	}
 	if c.Identifiers.CommandID == "" {
-		c.Identifiers.CommandID = c.stableID()
+		c.Identifiers.CommandID = c.StableID()[:8]
 	}
 	if c.Identifiers.ToolName == "" {
-		c.Identifiers.ToolName = "remote-client"
+		c.Identifiers.ToolName = DefaultToolName
+		if c.Identifiers.ToolVersion == "" {
+			c.Identifiers.ToolVersion = DefaultToolVersion
+		}
 	}
 	if c.Identifiers.InvocationID == "" {
 		c.Identifiers.InvocationID = uuid.New()
@@ -300,9 +538,145 @@ func (c *Command) FillDefaultFieldValues() {
 	if c.Identifiers.ExecutionID == "" {
 		c.Identifiers.ExecutionID = uuid.New()
 	}
-	if c.InputSpec == nil {
-		c.InputSpec = &InputSpec{}
+}
+
+// resolveEnvironment returns the InputSpec with EnvironmentPassthrough and EnvironmentAllowlist
+// applied to EnvironmentVariables. The InputSpec is not modified, since it may be shared with other
+// commands: a copy is returned, or the InputSpec itself if there is nothing to apply.
+func (s *InputSpec) resolveEnvironment(lookup func(string) (string, bool)) *InputSpec {
+	if len(s.EnvironmentPassthrough) == 0 && len(s.EnvironmentAllowlist) == 0 {
+		return s
+	}
+	env := make(map[string]string, len(s.EnvironmentVariables)+len(s.EnvironmentPassthrough))
+	for _, name := range s.EnvironmentPassthrough {
+		if val, ok := lookup(name); ok {
+			env[name] = val
+		}
+	}
+	for name, val := range s.EnvironmentVariables {
+		env[name] = val
 	}
+	if len(s.EnvironmentAllowlist) > 0 {
+		allowed := make(map[string]bool, len(s.EnvironmentAllowlist))
+		for _, name := range s.EnvironmentAllowlist {
+			allowed[name] = true
+		}
+		for name := range env {
+			if !allowed[name] {
+				delete(env, name)
+			}
+		}
+	}
+	sc := *s
+	sc.EnvironmentVariables = env
+	return &sc
+}
+
+// WithDefaultPlatform returns the Command with the properties in defaults that are not already set
+// in its Platform added. Properties set on the Command always take precedence over defaults. The
+// Command is not modified: a shallow copy with a new Platform map is returned, or the Command
+// itself if there are no defaults.
+func (c *Command) WithDefaultPlatform(defaults map[string]string) *Command {
+	if c == nil || len(defaults) == 0 {
+		return c
+	}
+	merged := make(map[string]string, len(defaults)+len(c.Platform))
+	for name, val := range defaults {
+		merged[name] = val
+	}
+	for name, val := range c.Platform {
+		merged[name] = val
+	}
+	cc := *c
+	cc.Platform = merged
+	return &cc
+}
+
+// Clone returns a deep copy of the Command, which can be modified, e.g. by FillDefaultFieldValues,
+// without affecting the original.
+func (c *Command) Clone() *Command {
+	if c == nil {
+		return nil
+	}
+	cc := *c
+	if c.Identifiers != nil {
+		ids := *c.Identifiers
+		ids.Labels = cloneStringMap(c.Identifiers.Labels)
+		cc.Identifiers = &ids
+	}
+	cc.Args = cloneStrings(c.Args)
+	cc.InputSpec = c.InputSpec.clone()
+	if c.Outputs != nil {
+		cc.Outputs = make([]*OutputSpec, len(c.Outputs))
+		for i, o := range c.Outputs {
+			if o != nil {
+				oc := *o
+				cc.Outputs[i] = &oc
+			}
+		}
+	}
+	cc.OutputFiles = cloneStrings(c.OutputFiles)
+	cc.OutputDirs = cloneStrings(c.OutputDirs)
+	cc.Platform = cloneStringMap(c.Platform)
+	cc.OutputNodeProperties = cloneStrings(c.OutputNodeProperties)
+	return &cc
+}
+
+func (s *InputSpec) clone() *InputSpec {
+	if s == nil {
+		return nil
+	}
+	sc := *s
+	sc.Inputs = cloneStrings(s.Inputs)
+	if s.VirtualInputs != nil {
+		sc.VirtualInputs = make([]*VirtualInput, len(s.VirtualInputs))
+		for i, vi := range s.VirtualInputs {
+			if vi != nil {
+				vc := *vi
+				if vi.Contents != nil {
+					vc.Contents = append([]byte{}, vi.Contents...)
+				}
+				sc.VirtualInputs[i] = &vc
+			}
+		}
+	}
+	if s.InputExclusions != nil {
+		sc.InputExclusions = make([]*InputExclusion, len(s.InputExclusions))
+		for i, ie := range s.InputExclusions {
+			if ie != nil {
+				ec := *ie
+				sc.InputExclusions[i] = &ec
+			}
+		}
+	}
+	sc.EnvironmentVariables = cloneStringMap(s.EnvironmentVariables)
+	sc.EnvironmentPassthrough = cloneStrings(s.EnvironmentPassthrough)
+	sc.EnvironmentAllowlist = cloneStrings(s.EnvironmentAllowlist)
+	if s.InputNodeProperties != nil {
+		sc.InputNodeProperties = make(map[string]*cpb.NodeProperties, len(s.InputNodeProperties))
+		for path, np := range s.InputNodeProperties {
+			sc.InputNodeProperties[path] = proto.Clone(np).(*cpb.NodeProperties)
+		}
+	}
+	return &sc
+}
+
+func cloneStrings(s []string) []string {
+	if s == nil {
+		return nil
+	}
+	return append([]string{}, s...)
+}
+
+func cloneStringMap(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+	mc := make(map[string]string, len(m))
+	for k, v := range m {
+		mc[k] = v
+	}
+	return mc
 }
 
 func levels(path string) int {
@@ -335,6 +709,48 @@ type ExecutionOptions struct {
 	// is also set. The client may expect a delay in this scenario as the streams are downloaded after
 	// the fact.
 	StreamOutErr bool
+
+	// Salt is mixed into the Action digest without affecting execution, so that actions with
+	// different salts do not share cached results. It can be used to partition or invalidate action
+	// cache namespaces without changing the actual inputs.
+	Salt []byte
+
+	// Priority is the execution priority requested from the server. Lower values mean higher
+	// priority, and 0 is the server default.
+	Priority int32
+
+	// CachePriority is the priority of the results of the command in the remote cache, requested
+	// both when executing remotely and when updating the cache with a local result. Lower values
+	// mean the results are less likely to be evicted, and 0 is the server default. Background
+	// actions may use a high value to leave the cache to interactive builds.
+	CachePriority int32
+
+	// ClientDeadline, if positive, bounds the whole time the client waits for a remote execution,
+	// including the time the action is queued on the server. When it expires the client stops
+	// waiting, whether or not the action is still running remotely, and the result has a
+	// ClientDeadlineResultStatus. It is independent of the Command's Timeout.
+	ClientDeadline time.Duration
+
+	// MaterializeOutputs, if set, restricts the outputs downloaded when DownloadOutputs is set to
+	// those that are, or are under, one of these paths, relative to the working directory. The
+	// other outputs stay in the CAS, and are recorded in the output manifest if OutputManifestPath
+	// is set.
+	MaterializeOutputs []string
+
+	// DownloadOutputRegex, if set, restricts the outputs downloaded when DownloadOutputs is set to
+	// the files, symlinks and empty directories whose slash-separated path, relative to the working
+	// directory, matches this regular expression. Like the other outputs left in the CAS, the others
+	// are in the output digests of the Metadata and in the output manifest, if any.
+	DownloadOutputRegex string
+
+	// DownloadExclusions are regular expressions matched like DownloadOutputRegex. The outputs
+	// matching any of them are not downloaded, e.g. `\.pdb$` to skip large debug symbols.
+	DownloadExclusions []string
+
+	// OutputManifestPath, if set, is the file the outputs that were not downloaded are recorded in,
+	// with their digests and sizes, so that they can be fetched on demand later. It is written
+	// when outputs are downloaded.
+	OutputManifestPath string
 }
 
 // DefaultExecutionOptions returns the recommended ExecutionOptions.
@@ -376,6 +792,14 @@ const (
 
 	// LocalErrorResultStatus indicates that an error occurred locally.
 	LocalErrorResultStatus
+
+	// ClientDeadlineResultStatus indicates that the client stopped waiting for the command after its
+	// ExecutionOptions.ClientDeadline expired.
+	ClientDeadlineResultStatus
+
+	// CancelledResultStatus indicates that the caller cancelled the execution before it completed.
+	// The client cancels the remote operation as well.
+	CancelledResultStatus
 )
 
 var resultStatuses = [...]string{
@@ -387,6 +811,8 @@ var resultStatuses = [...]string{
 	"InterruptedResultStatus",
 	"RemoteErrorResultStatus",
 	"LocalErrorResultStatus",
+	"ClientDeadlineResultStatus",
+	"CancelledResultStatus",
 }
 
 // IsOk returns whether the status indicates a successful action.
@@ -395,7 +821,7 @@ func (s ResultStatus) IsOk() bool {
 }
 
 func (s ResultStatus) String() string {
-	if UnspecifiedResultStatus <= s && s <= LocalErrorResultStatus {
+	if UnspecifiedResultStatus <= s && s <= CancelledResultStatus {
 		return resultStatuses[s]
 	}
 	return fmt.Sprintf("InvalidResultStatus(%d)", s)
@@ -422,14 +848,24 @@ const LocalErrorExitCode = 35
 // TimeoutExitCode is an exit code corresponding to the command timing out remotely.
 const TimeoutExitCode = /*SIGNAL_BASE=*/ 128 + /*SIGALRM=*/ 14
 
+// ClientDeadlineExitCode is an exit code corresponding to the client deadline expiring.
+const ClientDeadlineExitCode = 46
+
+// CancelledExitCode is an exit code corresponding to the execution being cancelled by the caller.
+const CancelledExitCode = /*SIGNAL_BASE=*/ 128 + /*SIGINT=*/ 2
+
 // RemoteErrorExitCode is an exit code corresponding to a remote server error.
 const RemoteErrorExitCode = 45
 
 // InterruptedExitCode is an exit code corresponding to an execution interruption by the user.
 const InterruptedExitCode = 8
 
-// NewLocalErrorResult constructs a Result from a local error.
+// NewLocalErrorResult constructs a Result from a local error, wrapped in a LocalError.
 func NewLocalErrorResult(err error) *Result {
+	var le *LocalError
+	if err != nil && !errors.As(err, &le) {
+		err = &LocalError{Cause: err}
+	}
 	return &Result{
 		ExitCode: LocalErrorExitCode,
 		Status:   LocalErrorResultStatus,
@@ -437,8 +873,13 @@ func NewLocalErrorResult(err error) *Result {
 	}
 }
 
-// NewRemoteErrorResult constructs a Result from a remote error.
+// NewRemoteErrorResult constructs a Result from a remote error, wrapped in a RemoteError with the
+// gRPC status it carries.
 func NewRemoteErrorResult(err error) *Result {
+	var re *RemoteError
+	if err != nil && !errors.As(err, &re) {
+		err = &RemoteError{Status: status.Convert(err), Err: err}
+	}
 	return &Result{
 		ExitCode: RemoteErrorExitCode,
 		Status:   RemoteErrorResultStatus,
@@ -466,6 +907,24 @@ func NewTimeoutResult() *Result {
 	}
 }
 
+// NewClientDeadlineResult constructs a new result for a command the client stopped waiting for.
+func NewClientDeadlineResult(err error) *Result {
+	return &Result{
+		ExitCode: ClientDeadlineExitCode,
+		Status:   ClientDeadlineResultStatus,
+		Err:      err,
+	}
+}
+
+// NewCancelledResult constructs a new result for a command cancelled by the caller.
+func NewCancelledResult(err error) *Result {
+	return &Result{
+		ExitCode: CancelledExitCode,
+		Status:   CancelledResultStatus,
+		Err:      err,
+	}
+}
+
 // TimeInterval is a time window for an event.
 type TimeInterval struct {
 	From, To time.Time
@@ -524,7 +983,28 @@ type Metadata struct {
 	TotalInputBytes int64
 	// Event times for remote events, by event name.
 	EventTimes map[string]*TimeInterval
-
+	// CachedResult is whether the result was served from a cache, either the action cache or the
+	// server's execution cache, rather than produced by an execution for this command.
+	CachedResult bool
+	// CachedEventTimes are the server event times of the execution which originally produced a
+	// cached result, by event name. They are kept apart from EventTimes because they did not happen
+	// for this command, possibly long before it ran.
+	CachedEventTimes map[string]*TimeInterval
+	// Worker is the name of the remote worker which executed the action, as reported by the server.
+	// For a cached result, it is the worker of the original execution.
+	Worker string
+	// ExecutionDuration is the real time the action ran for on the worker, between the start and
+	// completion of its EventServerWorkerExecution event. For a cached result, it is that of the
+	// original execution.
+	ExecutionDuration time.Duration
+	// VirtualExecutionDuration is the time the action was reported to run for when the worker uses a
+	// virtual clock, for example in emulated environments. Compare to ExecutionDuration for the real
+	// duration.
+	VirtualExecutionDuration time.Duration
+	// AuxiliaryMetadata is implementation-specific metadata attached by the server to the result, such
+	// as detailed resource usage like CPU time and peak memory, which the API has no standard fields
+	// for. Use UnmarshalAuxiliaryMetadata or DecodeAuxiliaryMetadata to decode
+	// it.
 	AuxiliaryMetadata []*anypb.Any
 	// The total number of output files (incl symlinks).
 	OutputFiles int
@@ -552,11 +1032,59 @@ type Metadata struct {
 	// RealBytesDownloaded is the number of bytes that were put on the wire for download (exclusing metadata).
 	// It may differ from LogicalBytesDownloaded due to compression.
 	RealBytesDownloaded int64
-	// StderrDigest is a digest of the standard error after being executed.
+	// StderrDigest is a digest of the standard error after being executed. It is set whenever the
+	// server stored the standard error in the CAS, even if it was not downloaded, so that it can be
+	// fetched later, e.g. with rexec.Client.FetchOutErr.
 	StderrDigest digest.Digest
-	// StdoutDigest is a digest of the standard output after being executed.
+	// StdoutDigest is a digest of the standard output after being executed. Like StderrDigest, it
+	// is set even if the standard output was not downloaded.
 	StdoutDigest digest.Digest
-	// TODO(olaola): Add a lot of other fields.
+	// FailureArtifactsDir is the directory the artifacts of the failed execution were retained in
+	// for debugging, if they were all retained.
+	FailureArtifactsDir string
+	// Reexecutions are the failed executions of the command that were classified as transient and
+	// retried, in order.
+	Reexecutions []*Reexecution
+}
+
+// Reexecution is a failed execution of a command that was executed again.
+type Reexecution struct {
+	// Result is the result of the failed execution.
+	Result *Result
+	// Reason is why the failure was classified as transient.
+	Reason string
+}
+
+// UnmarshalAuxiliaryMetadata unmarshals the first auxiliary metadata message of the same type as msg
+// into msg. It returns false if there is no auxiliary metadata of that type.
+func (m *Metadata) UnmarshalAuxiliaryMetadata(msg proto.Message) (bool, error) {
+	for _, a := range m.AuxiliaryMetadata {
+		if a.MessageIs(msg) {
+			if err := a.UnmarshalTo(msg); err != nil {
+				return false, err
+			}
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// DecodeAuxiliaryMetadata unmarshals all auxiliary metadata messages whose types are known, i.e.
+// linked into the binary. Messages of unknown types are skipped; they remain available as raw Any
+// messages in AuxiliaryMetadata.
+func (m *Metadata) DecodeAuxiliaryMetadata() ([]proto.Message, error) {
+	var res []proto.Message
+	for _, a := range m.AuxiliaryMetadata {
+		msg, err := a.UnmarshalNew()
+		if errors.Is(err, protoregistry.NotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode auxiliary metadata of type %v: %v", a.GetTypeUrl(), err)
+		}
+		res = append(res, msg)
+	}
+	return res, nil
 }
 
 // ToREProto converts the Command to an RE API Command proto.
@@ -574,19 +1102,26 @@ func (c *Command) ToREProto(useOutputPathsField bool) *repb.Command {
 
 	// In v2.1 of the RE API the `output_{files, directories}` fields were
 	// replaced by a single field: `output_paths`.
+	files, dirs, wildcards := c.OutputPaths()
 	if useOutputPathsField {
-		cmdPb.OutputPaths = append(c.OutputFiles, c.OutputDirs...)
+		cmdPb.OutputPaths = append(append(files, dirs...), wildcards...)
 		sort.Strings(cmdPb.OutputPaths)
 	} else {
-		cmdPb.OutputFiles = make([]string, len(c.OutputFiles))
-		copy(cmdPb.OutputFiles, c.OutputFiles)
+		cmdPb.OutputFiles = make([]string, 0, len(files)+len(wildcards))
+		cmdPb.OutputFiles = append(append(cmdPb.OutputFiles, files...), wildcards...)
 		sort.Strings(cmdPb.OutputFiles)
 
-		cmdPb.OutputDirectories = make([]string, len(c.OutputDirs))
-		copy(cmdPb.OutputDirectories, c.OutputDirs)
+		cmdPb.OutputDirectories = make([]string, len(dirs))
+		copy(cmdPb.OutputDirectories, dirs)
 		sort.Strings(cmdPb.OutputDirectories)
 	}
 
+	if len(c.OutputNodeProperties) > 0 {
+		cmdPb.OutputNodeProperties = make([]string, len(c.OutputNodeProperties))
+		copy(cmdPb.OutputNodeProperties, c.OutputNodeProperties)
+		sort.Strings(cmdPb.OutputNodeProperties)
+	}
+
 	for name, val := range c.InputSpec.EnvironmentVariables {
 		cmdPb.EnvironmentVariables = append(cmdPb.EnvironmentVariables, &repb.Command_EnvironmentVariable{Name: name, Value: val})
 	}
@@ -613,6 +1148,8 @@ func FromREProto(cmdPb *repb.Command) *Command {
 		OutputDirs:  cmdPb.OutputDirectories,
 		Platform:    make(map[string]string),
 		Args:        cmdPb.Arguments,
+
+		OutputNodeProperties: cmdPb.OutputNodeProperties,
 	}
 
 	// In v2.1 of the RE API the `output_{files, directories}` fields were
@@ -630,38 +1167,58 @@ func FromREProto(cmdPb *repb.Command) *Command {
 	return cmd
 }
 
-// FromProto parses a Command struct from a proto message.
+// FromProto parses a Command struct from a proto message. It is the inverse of ToProto: commands
+// survive the round-trip with all of their fields.
 func FromProto(p *cpb.Command) *Command {
-	ids := &Identifiers{
-		CommandID:              p.GetIdentifiers().GetCommandId(),
-		InvocationID:           p.GetIdentifiers().GetInvocationId(),
-		CorrelatedInvocationID: p.GetIdentifiers().GetCorrelatedInvocationsId(),
-		ToolName:               p.GetIdentifiers().GetToolName(),
-		ToolVersion:            p.GetIdentifiers().GetToolVersion(),
-		ExecutionID:            p.GetIdentifiers().GetExecutionId(),
-	}
-	is := inputSpecFromProto(p.GetInput())
-	return &Command{
-		Identifiers:      ids,
-		ExecRoot:         p.ExecRoot,
-		Args:             p.Args,
-		WorkingDir:       p.WorkingDirectory,
-		RemoteWorkingDir: p.RemoteWorkingDirectory,
-		InputSpec:        is,
-		OutputFiles:      p.GetOutput().GetOutputFiles(),
-		OutputDirs:       p.GetOutput().GetOutputDirectories(),
-		Timeout:          time.Duration(p.ExecutionTimeout) * time.Second,
-		Platform:         p.Platform,
+	cmd := &Command{
+		ExecRoot:             p.ExecRoot,
+		Args:                 p.Args,
+		WorkingDir:           p.WorkingDirectory,
+		RemoteWorkingDir:     p.RemoteWorkingDirectory,
+		Timeout:              time.Duration(p.ExecutionTimeout) * time.Second,
+		Platform:             p.Platform,
+		OutputNodeProperties: p.OutputNodeProperties,
+	}
+	if p.Timeout != nil {
+		cmd.Timeout = p.Timeout.AsDuration()
+	}
+	if ids := p.GetIdentifiers(); ids != nil {
+		cmd.Identifiers = &Identifiers{
+			CommandID:              ids.CommandId,
+			InvocationID:           ids.InvocationId,
+			CorrelatedInvocationID: ids.CorrelatedInvocationsId,
+			ToolName:               ids.ToolName,
+			ToolVersion:            ids.ToolVersion,
+			ExecutionID:            ids.ExecutionId,
+			ParentInvocationID:     ids.ParentInvocationId,
+			Attempt:                int(ids.Attempt),
+			BuildPhase:             ids.BuildPhase,
+			Labels:                 ids.Labels,
+		}
+	}
+	if p.Input != nil {
+		cmd.InputSpec = inputSpecFromProto(p.Input)
 	}
+	outputSpecFromProto(cmd, p.GetOutput())
+	return cmd
+}
+
+// CommandFromProto parses a Command struct from a proto message, see FromProto.
+func CommandFromProto(p *cpb.Command) *Command {
+	return FromProto(p)
 }
 
 func inputSpecFromProto(is *cpb.InputSpec) *InputSpec {
 	var excl []*InputExclusion
 	for _, ex := range is.GetExcludeInputs() {
-		excl = append(excl, &InputExclusion{
-			Regex: ex.Regex,
-			Type:  inputTypeFromProto(ex.Type),
-		})
+		e := &InputExclusion{Type: inputTypeFromProto(ex.Type)}
+		// The regex of a glob exclusion is the one it compiles to, see inputSpecToProto.
+		if ex.Glob != "" {
+			e.Glob = ex.Glob
+		} else {
+			e.Regex = ex.Regex
+		}
+		excl = append(excl, e)
 	}
 	var vis []*VirtualInput
 	for _, vi := range is.GetVirtualInputs() {
@@ -673,17 +1230,20 @@ func inputSpecFromProto(is *cpb.InputSpec) *InputSpec {
 			IsExecutable:     vi.IsExecutable,
 			IsEmptyDirectory: vi.IsEmptyDirectory,
 			Digest:           vi.Digest,
-			Mtime:            vi.Mtime.AsTime(),
+			TreeDigest:       vi.TreeDigest,
+			Mtime:            TimeFromProto(vi.Mtime),
 			FileMode:         os.FileMode(vi.Filemode),
 		})
 	}
 	return &InputSpec{
-		Inputs:               is.GetInputs(),
-		VirtualInputs:        vis,
-		InputExclusions:      excl,
-		EnvironmentVariables: is.GetEnvironmentVariables(),
-		SymlinkBehavior:      symlinkBehaviorFromProto(is.GetSymlinkBehavior()),
-		InputNodeProperties:  is.GetInputNodeProperties(),
+		Inputs:                 is.GetInputs(),
+		VirtualInputs:          vis,
+		InputExclusions:        excl,
+		EnvironmentVariables:   is.GetEnvironmentVariables(),
+		EnvironmentPassthrough: is.GetEnvironmentPassthrough(),
+		EnvironmentAllowlist:   is.GetEnvironmentAllowlist(),
+		SymlinkBehavior:        symlinkBehaviorFromProto(is.GetSymlinkBehavior()),
+		InputNodeProperties:    is.GetInputNodeProperties(),
 	}
 }
 
@@ -727,11 +1287,56 @@ func NodePropertiesFromAPI(np *repb.NodeProperties) *cpb.NodeProperties {
 	return res
 }
 
+// outputSpecToProto returns the outputs of cmd as a proto message. The typed outputs are also
+// listed as files and as the glob root directories, as for servers before v2.1 of the RE API, for
+// readers not aware of their types.
+func outputSpecToProto(cmd *Command) *cpb.OutputSpec {
+	files, dirs, wildcards := cmd.OutputPaths()
+	spec := &cpb.OutputSpec{OutputFiles: append(files, wildcards...), OutputDirectories: dirs}
+	if len(cmd.Outputs) == 0 {
+		return spec
+	}
+	spec.UntypedOutputFiles = int32(len(cmd.OutputFiles))
+	spec.UntypedOutputDirectories = int32(len(cmd.OutputDirs))
+	for _, o := range cmd.Outputs {
+		spec.Outputs = append(spec.Outputs, &cpb.OutputPath{Path: o.Path, Type: outputTypeToProto(o.Type)})
+	}
+	return spec
+}
+
+// outputSpecFromProto sets the outputs of cmd from a proto message made by outputSpecToProto.
+func outputSpecFromProto(cmd *Command, spec *cpb.OutputSpec) {
+	cmd.OutputFiles = spec.GetOutputFiles()
+	cmd.OutputDirs = spec.GetOutputDirectories()
+	if len(spec.GetOutputs()) == 0 {
+		return
+	}
+	cmd.OutputFiles = untypedOutputs(cmd.OutputFiles, spec.UntypedOutputFiles)
+	cmd.OutputDirs = untypedOutputs(cmd.OutputDirs, spec.UntypedOutputDirectories)
+	for _, o := range spec.Outputs {
+		cmd.Outputs = append(cmd.Outputs, &OutputSpec{Path: o.Path, Type: outputTypeFromProto(o.Type)})
+	}
+}
+
+// untypedOutputs returns the first n paths, which are not listed for typed outputs.
+func untypedOutputs(paths []string, n int32) []string {
+	if n <= 0 {
+		return nil
+	}
+	if int(n) > len(paths) {
+		return paths
+	}
+	return paths[:n]
+}
+
 func inputSpecToProto(is *InputSpec) *cpb.InputSpec {
 	var excl []*cpb.ExcludeInput
 	for _, ex := range is.InputExclusions {
+		// Globs are also sent as their regular expressions, for readers not aware of globs. The regular
+		// expressions are matched against absolute paths, so they may also match above the exec root.
 		excl = append(excl, &cpb.ExcludeInput{
-			Regex: ex.Regex,
+			Regex: ex.Pattern(),
+			Glob:  ex.Glob,
 			Type:  inputTypeToProto(ex.Type),
 		})
 	}
@@ -745,17 +1350,20 @@ func inputSpecToProto(is *InputSpec) *cpb.InputSpec {
 			IsExecutable:     vi.IsExecutable,
 			IsEmptyDirectory: vi.IsEmptyDirectory,
 			Digest:           vi.Digest,
-			Mtime:            tspb.New(vi.Mtime),
+			TreeDigest:       vi.TreeDigest,
+			Mtime:            TimeToProto(vi.Mtime),
 			Filemode:         uint32(vi.FileMode),
 		})
 	}
 	return &cpb.InputSpec{
-		Inputs:               is.Inputs,
-		VirtualInputs:        vis,
-		ExcludeInputs:        excl,
-		EnvironmentVariables: is.EnvironmentVariables,
-		SymlinkBehavior:      symlinkBehaviorToProto(is.SymlinkBehavior),
-		InputNodeProperties:  is.InputNodeProperties,
+		Inputs:                 is.Inputs,
+		VirtualInputs:          vis,
+		ExcludeInputs:          excl,
+		EnvironmentVariables:   is.EnvironmentVariables,
+		EnvironmentPassthrough: is.EnvironmentPassthrough,
+		EnvironmentAllowlist:   is.EnvironmentAllowlist,
+		SymlinkBehavior:        symlinkBehaviorToProto(is.SymlinkBehavior),
+		InputNodeProperties:    is.InputNodeProperties,
 	}
 }
 
@@ -765,6 +1373,8 @@ func inputTypeFromProto(t cpb.InputType_Value) InputType {
 		return DirectoryInputType
 	case cpb.InputType_FILE:
 		return FileInputType
+	case cpb.InputType_SYMLINK:
+		return SymlinkInputType
 	default:
 		return UnspecifiedInputType
 	}
@@ -776,11 +1386,39 @@ func inputTypeToProto(t InputType) cpb.InputType_Value {
 		return cpb.InputType_DIRECTORY
 	case FileInputType:
 		return cpb.InputType_FILE
+	case SymlinkInputType:
+		return cpb.InputType_SYMLINK
 	default:
 		return cpb.InputType_UNSPECIFIED
 	}
 }
 
+func outputTypeFromProto(t cpb.OutputType_Value) OutputType {
+	switch t {
+	case cpb.OutputType_DIRECTORY:
+		return DirectoryOutputType
+	case cpb.OutputType_WILDCARD:
+		return WildcardOutputType
+	case cpb.OutputType_GLOB:
+		return GlobOutputType
+	default:
+		return FileOutputType
+	}
+}
+
+func outputTypeToProto(t OutputType) cpb.OutputType_Value {
+	switch t {
+	case DirectoryOutputType:
+		return cpb.OutputType_DIRECTORY
+	case WildcardOutputType:
+		return cpb.OutputType_WILDCARD
+	case GlobOutputType:
+		return cpb.OutputType_GLOB
+	default:
+		return cpb.OutputType_FILE
+	}
+}
+
 func symlinkBehaviorFromProto(t cpb.SymlinkBehaviorType_Value) SymlinkBehaviorType {
 	switch t {
 	case cpb.SymlinkBehaviorType_RESOLVE:
@@ -819,6 +1457,10 @@ func protoStatusFromResultStatus(s ResultStatus) cpb.CommandResultStatus_Value {
 		return cpb.CommandResultStatus_REMOTE_ERROR
 	case LocalErrorResultStatus:
 		return cpb.CommandResultStatus_LOCAL_ERROR
+	case ClientDeadlineResultStatus:
+		return cpb.CommandResultStatus_CLIENT_DEADLINE
+	case CancelledResultStatus:
+		return cpb.CommandResultStatus_CANCELLED
 	default:
 		return cpb.CommandResultStatus_UNKNOWN
 	}
@@ -840,37 +1482,58 @@ func protoStatusToResultStatus(s cpb.CommandResultStatus_Value) ResultStatus {
 		return RemoteErrorResultStatus
 	case cpb.CommandResultStatus_LOCAL_ERROR:
 		return LocalErrorResultStatus
+	case cpb.CommandResultStatus_CLIENT_DEADLINE:
+		return ClientDeadlineResultStatus
+	case cpb.CommandResultStatus_CANCELLED:
+		return CancelledResultStatus
 	default:
 		return UnspecifiedResultStatus
 	}
 }
 
-// ToProto serializes a Command struct into a proto message.
+// ToProto serializes a Command struct into a proto message, keeping all of its fields.
 func ToProto(cmd *Command) *cpb.Command {
 	if cmd == nil {
 		return nil
 	}
 	cPb := &cpb.Command{
 		ExecRoot:               cmd.ExecRoot,
-		Input:                  inputSpecToProto(cmd.InputSpec),
-		Output:                 &cpb.OutputSpec{OutputFiles: cmd.OutputFiles, OutputDirectories: cmd.OutputDirs},
+		Output:                 outputSpecToProto(cmd),
 		Args:                   cmd.Args,
 		ExecutionTimeout:       int32(cmd.Timeout.Seconds()),
 		WorkingDirectory:       cmd.WorkingDir,
 		RemoteWorkingDirectory: cmd.RemoteWorkingDir,
 		Platform:               cmd.Platform,
+		OutputNodeProperties:   cmd.OutputNodeProperties,
+	}
+	if cmd.Timeout != 0 {
+		cPb.Timeout = dpb.New(cmd.Timeout)
+	}
+	if cmd.InputSpec != nil {
+		cPb.Input = inputSpecToProto(cmd.InputSpec)
 	}
-	if cmd.Identifiers != nil {
+	if ids := cmd.Identifiers; ids != nil {
 		cPb.Identifiers = &cpb.Identifiers{
-			CommandId:    cmd.Identifiers.CommandID,
-			InvocationId: cmd.Identifiers.InvocationID,
-			ToolName:     cmd.Identifiers.ToolName,
-			ExecutionId:  cmd.Identifiers.ExecutionID,
+			CommandId:               ids.CommandID,
+			InvocationId:            ids.InvocationID,
+			CorrelatedInvocationsId: ids.CorrelatedInvocationID,
+			ToolName:                ids.ToolName,
+			ToolVersion:             ids.ToolVersion,
+			ExecutionId:             ids.ExecutionID,
+			ParentInvocationId:      ids.ParentInvocationID,
+			Attempt:                 int32(ids.Attempt),
+			BuildPhase:              ids.BuildPhase,
+			Labels:                  ids.Labels,
 		}
 	}
 	return cPb
 }
 
+// ToProto serializes the command into a proto message, see ToProto.
+func (c *Command) ToProto() *cpb.Command {
+	return ToProto(c)
+}
+
 // ResultToProto serializes a command.Result struct into a proto message.
 func ResultToProto(res *Result) *cpb.CommandResult {
 	if res == nil {
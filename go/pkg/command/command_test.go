@@ -1,15 +1,27 @@
 package command
 
 import (
+	"encoding/json"
 	"errors"
+	"path/filepath"
+	"regexp"
 	"testing"
 	"time"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/testing/protocmp"
 
+	cpb "github.com/bazelbuild/remote-apis-sdks/go/api/command"
 	repb "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	errdetails "google.golang.org/genproto/googleapis/rpc/errdetails"
+	anypb "google.golang.org/protobuf/types/known/anypb"
+	dpb "google.golang.org/protobuf/types/known/durationpb"
+	tspb "google.golang.org/protobuf/types/known/timestamppb"
+	wpb "google.golang.org/protobuf/types/known/wrapperspb"
 )
 
 func TestStableId_SameCommands(t *testing.T) {
@@ -92,12 +104,52 @@ func TestStableId_SameCommands(t *testing.T) {
 				},
 			},
 		},
+		{
+			label: "deprecated outputs",
+			A: &Command{
+				OutputFiles: []string{"a"},
+				OutputDirs:  []string{"b"},
+			},
+			B: &Command{
+				Outputs: []*OutputSpec{{Path: "b", Type: DirectoryOutputType}, {Path: "a", Type: FileOutputType}},
+			},
+		},
+		{
+			label: "nil input spec",
+			A:     &Command{},
+			B:     &Command{InputSpec: &InputSpec{}},
+		},
+		{
+			label: "identifiers",
+			A:     &Command{Identifiers: &Identifiers{CommandID: "a"}},
+			B:     &Command{Identifiers: &Identifiers{CommandID: "b"}},
+		},
+		{
+			label: "nil entries",
+			A: &Command{InputSpec: &InputSpec{
+				VirtualInputs:   []*VirtualInput{nil, {Path: "v"}},
+				InputExclusions: []*InputExclusion{{Regex: "r"}, nil},
+			}},
+			B: &Command{InputSpec: &InputSpec{
+				VirtualInputs:   []*VirtualInput{{Path: "v"}},
+				InputExclusions: []*InputExclusion{{Regex: "r"}},
+			}},
+		},
+		{
+			label: "node properties",
+			A: &Command{InputSpec: &InputSpec{InputNodeProperties: map[string]*cpb.NodeProperties{
+				"in": {Properties: []*cpb.NodeProperty{{Name: "b", Value: "2"}, {Name: "a", Value: "1"}}},
+			}}},
+			B: &Command{InputSpec: &InputSpec{InputNodeProperties: map[string]*cpb.NodeProperties{
+				"in": {Properties: []*cpb.NodeProperty{{Name: "a", Value: "1"}, {Name: "b", Value: "2"}}},
+			}}},
+		},
 	}
 	for _, tc := range testcases {
-		aID := tc.A.stableID()
-		bID := tc.B.stableID()
+		aID := tc.A.StableID()
+		bID := tc.B.StableID()
 		if aID != bID {
-			t.Errorf("%s: stableID of %v = %s different from %v = %s", tc.label, tc.A, aID, tc.B, bID)
+			t.Errorf("%s: StableID() of %v = %s different from %v = %s", tc.label, tc.A, aID, tc.B, bID)
 		}
 	}
 }
@@ -185,16 +237,64 @@ func TestStableId_DifferentCommands(t *testing.T) {
 				},
 			},
 		},
+		{
+			label: "output types",
+			A:     &Command{Outputs: []*OutputSpec{{Path: "a", Type: FileOutputType}}},
+			B:     &Command{Outputs: []*OutputSpec{{Path: "a", Type: WildcardOutputType}}},
+		},
+		{
+			label: "argument boundaries",
+			A:     &Command{Args: []string{"ab"}},
+			B:     &Command{Args: []string{"a", "b"}},
+		},
+		{
+			label: "virtual inputs",
+			A:     &Command{InputSpec: &InputSpec{VirtualInputs: []*VirtualInput{{Path: "a", Contents: []byte("1")}}}},
+			B:     &Command{InputSpec: &InputSpec{VirtualInputs: []*VirtualInput{{Path: "a", Contents: []byte("2")}}}},
+		},
+		{
+			label: "symlink behavior",
+			A:     &Command{InputSpec: &InputSpec{SymlinkBehavior: ResolveSymlink}},
+			B:     &Command{InputSpec: &InputSpec{SymlinkBehavior: PreserveSymlink}},
+		},
 	}
 	for _, tc := range testcases {
-		aID := tc.A.stableID()
-		bID := tc.B.stableID()
+		aID := tc.A.StableID()
+		bID := tc.B.StableID()
 		if aID == bID {
-			t.Errorf("%s: stableID of %v = %s is same as %v", tc.label, tc.A, aID, tc.B)
+			t.Errorf("%s: StableID() of %v = %s is same as %v", tc.label, tc.A, aID, tc.B)
 		}
 	}
 }
 
+func TestStableIDGolden(t *testing.T) {
+	t.Parallel()
+	// The ID of a command must only change with StableIDVersion.
+	c := &Command{
+		Args:       []string{"tool", "-c"},
+		ExecRoot:   "/root",
+		WorkingDir: "wd",
+		InputSpec: &InputSpec{
+			Inputs:               []string{"in"},
+			EnvironmentVariables: map[string]string{"k": "v"},
+			InputNodeProperties: map[string]*cpb.NodeProperties{
+				"in": {
+					Properties: []*cpb.NodeProperty{{Name: "n", Value: "v"}},
+					Mtime:      &tspb.Timestamp{Seconds: 1, Nanos: 2},
+					UnixMode:   wpb.UInt32(0755),
+				},
+			},
+		},
+		OutputFiles: []string{"out"},
+		Timeout:     time.Minute,
+		Platform:    map[string]string{"OSFamily": "linux"},
+	}
+	const want = "3a5f0ceef01878ba78efa7245ba36ac398aa6393f692683ce0efe6c84621604c"
+	if got := c.StableID(); got != want {
+		t.Errorf("StableID() = %q, want %q", got, want)
+	}
+}
+
 func TestFillDefaultFieldValues_Empty(t *testing.T) {
 	t.Parallel()
 	c := &Command{}
@@ -206,8 +306,11 @@ func TestFillDefaultFieldValues_Empty(t *testing.T) {
 	if c.Identifiers.CommandID == "" {
 		t.Errorf("did not fill command id for empty command")
 	}
-	if c.Identifiers.ToolName == "" {
-		t.Errorf("did not fill tool name for empty command, expected \"remote-client\"")
+	if c.Identifiers.ToolName != DefaultToolName {
+		t.Errorf("did not fill tool name for empty command, got %q, expected %q", c.Identifiers.ToolName, DefaultToolName)
+	}
+	if c.Identifiers.ToolVersion != DefaultToolVersion {
+		t.Errorf("did not fill tool version for empty command, got %q, expected %q", c.Identifiers.ToolVersion, DefaultToolVersion)
 	}
 	if c.Identifiers.InvocationID == "" {
 		t.Errorf("did not generate invocation id for empty command")
@@ -237,6 +340,9 @@ func TestFillDefaultFieldValues_PreserveExisting(t *testing.T) {
 	if c.Identifiers.ToolName != "foo" {
 		t.Errorf("did not preserve CommandID: got %s, expected foo", c.Identifiers.ToolName)
 	}
+	if c.Identifiers.ToolVersion != "" {
+		t.Errorf("filled the default tool version %q for tool foo, expected none", c.Identifiers.ToolVersion)
+	}
 	if c.Identifiers.InvocationID != "bar" {
 		t.Errorf("did not preserve CommandID: got %s, expected bar", c.Identifiers.InvocationID)
 	}
@@ -245,6 +351,102 @@ func TestFillDefaultFieldValues_PreserveExisting(t *testing.T) {
 	}
 }
 
+func TestWithDefaultPlatform(t *testing.T) {
+	t.Parallel()
+	platform := map[string]string{"OSFamily": "Windows", "Pool": "gpu"}
+	c := &Command{Platform: platform}
+	got := c.WithDefaultPlatform(map[string]string{"OSFamily": "Linux", "container-image": "docker://foo"})
+	want := map[string]string{"OSFamily": "Windows", "Pool": "gpu", "container-image": "docker://foo"}
+	if diff := cmp.Diff(want, got.Platform); diff != "" {
+		t.Errorf("WithDefaultPlatform() gave diff in platform: (-want +got)\n%s", diff)
+	}
+	if _, ok := platform["container-image"]; ok {
+		t.Errorf("WithDefaultPlatform() modified the original platform map: %v", platform)
+	}
+	if _, ok := c.Platform["container-image"]; ok {
+		t.Errorf("WithDefaultPlatform() modified the platform of the original command: %v", c.Platform)
+	}
+
+	c = &Command{}
+	if got := c.WithDefaultPlatform(nil); got != c || got.Platform != nil {
+		t.Errorf("WithDefaultPlatform(nil) gave %+v, want the command unchanged", got)
+	}
+}
+
+func TestClone(t *testing.T) {
+	t.Parallel()
+	newCmd := func() *Command {
+		return &Command{
+			Identifiers: &Identifiers{CommandID: "a", ToolName: "b"},
+			Args:        []string{"tool", "arg"},
+			ExecRoot:    "/exec/root",
+			InputSpec: &InputSpec{
+				Inputs:                 []string{"in"},
+				VirtualInputs:          []*VirtualInput{{Path: "v", Contents: []byte("c")}},
+				InputExclusions:        []*InputExclusion{{Regex: "r", Type: FileInputType}},
+				EnvironmentVariables:   map[string]string{"k": "v"},
+				EnvironmentPassthrough: []string{"HOME"},
+				EnvironmentAllowlist:   []string{"k"},
+				InputNodeProperties:    map[string]*cpb.NodeProperties{"in": {Properties: []*cpb.NodeProperty{{Name: "n", Value: "v"}}}},
+			},
+			Outputs:              []*OutputSpec{{Path: "o", Type: FileOutputType}},
+			OutputFiles:          []string{"out"},
+			OutputDirs:           []string{"dir"},
+			Timeout:              time.Minute,
+			Platform:             map[string]string{"p": "v"},
+			OutputNodeProperties: []string{MtimeNodeProperty},
+		}
+	}
+	orig := newCmd()
+	c := orig.Clone()
+	if diff := cmp.Diff(orig, c, protocmp.Transform()); diff != "" {
+		t.Fatalf("Clone() gave diff (-want +got):\n%s", diff)
+	}
+	c.Identifiers.CommandID = "x"
+	c.Args[0] = "x"
+	c.InputSpec.Inputs[0] = "x"
+	c.InputSpec.VirtualInputs[0].Contents[0] = 'x'
+	c.InputSpec.InputExclusions[0].Regex = "x"
+	c.InputSpec.EnvironmentVariables["k"] = "x"
+	c.InputSpec.EnvironmentPassthrough[0] = "x"
+	c.InputSpec.EnvironmentAllowlist[0] = "x"
+	c.InputSpec.InputNodeProperties["in"].Properties[0].Value = "x"
+	c.Outputs[0].Path = "x"
+	c.OutputFiles[0] = "x"
+	c.OutputDirs[0] = "x"
+	c.Platform["p"] = "x"
+	c.OutputNodeProperties[0] = "x"
+	if diff := cmp.Diff(newCmd(), orig, protocmp.Transform()); diff != "" {
+		t.Errorf("modifying the clone modified the original (-want +got):\n%s", diff)
+	}
+	if (*Command)(nil).Clone() != nil {
+		t.Errorf("Clone() of nil gave non-nil")
+	}
+}
+
+func TestResolveEnvironment(t *testing.T) {
+	t.Parallel()
+	local := map[string]string{"HOME": "/home/u", "USER": "u", "RANDOM_SEED": "42"}
+	lookup := func(name string) (string, bool) {
+		v, ok := local[name]
+		return v, ok
+	}
+	env := map[string]string{"USER": "override", "TMPDIR": "/tmp/x", "CC": "clang"}
+	is := &InputSpec{
+		EnvironmentVariables:   env,
+		EnvironmentPassthrough: []string{"HOME", "USER", "UNSET", "RANDOM_SEED"},
+		EnvironmentAllowlist:   []string{"HOME", "USER", "UNSET", "CC"},
+	}
+	got := is.resolveEnvironment(lookup)
+	want := map[string]string{"HOME": "/home/u", "USER": "override", "CC": "clang"}
+	if diff := cmp.Diff(want, got.EnvironmentVariables); diff != "" {
+		t.Errorf("resolveEnvironment() gave diff in environment: (-want +got)\n%s", diff)
+	}
+	if len(env) != 3 || len(is.EnvironmentVariables) != 3 {
+		t.Errorf("resolveEnvironment() modified the original environment: %v", is.EnvironmentVariables)
+	}
+}
+
 func TestValidate_Errors(t *testing.T) {
 	t.Parallel()
 	testcases := []struct {
@@ -259,6 +461,16 @@ func TestValidate_Errors(t *testing.T) {
 				InputSpec:   &InputSpec{},
 			},
 		},
+		{
+			label: "missing output path",
+			Command: &Command{
+				Identifiers: &Identifiers{},
+				Args:        []string{"a"},
+				ExecRoot:    "a",
+				InputSpec:   &InputSpec{},
+				Outputs:     []*OutputSpec{{Type: FileOutputType}},
+			},
+		},
 		{
 			label: "missing input spec",
 			Command: &Command{
@@ -294,6 +506,67 @@ func TestValidate_Errors(t *testing.T) {
 				RemoteWorkingDir: "bar/baz",
 			},
 		},
+		{
+			label: "input escaping the exec root",
+			Command: &Command{
+				Identifiers: &Identifiers{},
+				Args:        []string{"a"},
+				ExecRoot:    "a",
+				InputSpec:   &InputSpec{Inputs: []string{"../a"}},
+			},
+		},
+		{
+			label: "working dir escaping the exec root",
+			Command: &Command{
+				Identifiers: &Identifiers{},
+				Args:        []string{"a"},
+				ExecRoot:    "a",
+				InputSpec:   &InputSpec{},
+				WorkingDir:  "../wd",
+			},
+		},
+		{
+			label: "absolute output",
+			Command: &Command{
+				Identifiers: &Identifiers{},
+				Args:        []string{"a"},
+				ExecRoot:    "a",
+				InputSpec:   &InputSpec{},
+				OutputFiles: []string{"/a"},
+			},
+		},
+		{
+			label: "output escaping the exec root",
+			Command: &Command{
+				Identifiers: &Identifiers{},
+				Args:        []string{"a"},
+				ExecRoot:    "a",
+				InputSpec:   &InputSpec{},
+				WorkingDir:  "wd",
+				OutputDirs:  []string{"../../a"},
+			},
+		},
+		{
+			label: "duplicate outputs",
+			Command: &Command{
+				Identifiers: &Identifiers{},
+				Args:        []string{"a"},
+				ExecRoot:    "a",
+				InputSpec:   &InputSpec{},
+				OutputFiles: []string{"a"},
+				OutputDirs:  []string{"./a"},
+			},
+		},
+		{
+			label: "output file parent of another output",
+			Command: &Command{
+				Identifiers: &Identifiers{},
+				Args:        []string{"a"},
+				ExecRoot:    "a",
+				InputSpec:   &InputSpec{},
+				OutputFiles: []string{"a", "a/b"},
+			},
+		},
 	}
 	for _, tc := range testcases {
 		if err := tc.Command.Validate(); err == nil {
@@ -302,6 +575,24 @@ func TestValidate_Errors(t *testing.T) {
 	}
 }
 
+func TestValidate_AllProblems(t *testing.T) {
+	t.Parallel()
+	c := &Command{
+		ExecRoot:    "a",
+		InputSpec:   &InputSpec{Inputs: []string{"../in"}},
+		OutputFiles: []string{"/out", "out", "out"},
+	}
+	err := c.Validate()
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("Validate() = %v, want a *ValidationError", err)
+	}
+	// Missing arguments and identifiers, escaping input, absolute and duplicate outputs.
+	if len(verr.Problems) != 5 {
+		t.Errorf("Validate() reported %d problems, want 5: %v", len(verr.Problems), err)
+	}
+}
+
 func TestValidate_NilSuccess(t *testing.T) {
 	t.Parallel()
 	var c *Command
@@ -349,6 +640,35 @@ func TestToREProto(t *testing.T) {
 			cmd:     &Command{OutputDirs: []string{"foo", "bar", "abc"}},
 			wantCmd: &repb.Command{OutputDirectories: []string{"abc", "bar", "foo"}},
 		},
+		{
+			name: "typed outputs",
+			cmd: &Command{
+				Outputs: []*OutputSpec{
+					{Path: "foo", Type: FileOutputType},
+					{Path: "bar", Type: DirectoryOutputType},
+					{Path: "abc", Type: WildcardOutputType},
+				},
+				OutputFiles: []string{"def"},
+			},
+			wantCmd: &repb.Command{OutputFiles: []string{"abc", "def", "foo"}, OutputDirectories: []string{"bar"}},
+		},
+		{
+			name: "output globs",
+			cmd: &Command{
+				Outputs: []*OutputSpec{
+					{Path: "obj/**/*.o", Type: GlobOutputType},
+					{Path: "obj/*.d", Type: GlobOutputType},
+					{Path: "gen/*.h", Type: GlobOutputType},
+				},
+				OutputDirs: []string{"gen"},
+			},
+			wantCmd: &repb.Command{OutputFiles: []string{}, OutputDirectories: []string{"gen", "obj"}},
+		},
+		{
+			name:    "sort output node properties",
+			cmd:     &Command{OutputNodeProperties: []string{UnixModeNodeProperty, MtimeNodeProperty}},
+			wantCmd: &repb.Command{OutputNodeProperties: []string{MtimeNodeProperty, UnixModeNodeProperty}},
+		},
 		{
 			name: "sort environment variables",
 			cmd: &Command{
@@ -415,6 +735,18 @@ func TestToREProtoWithOutputPaths(t *testing.T) {
 			cmd:     &Command{OutputDirs: []string{"foo", "bar", "abc"}},
 			wantCmd: &repb.Command{OutputPaths: []string{"abc", "bar", "foo"}},
 		},
+		{
+			name: "typed outputs",
+			cmd: &Command{
+				Outputs: []*OutputSpec{
+					{Path: "foo", Type: FileOutputType},
+					{Path: "bar", Type: DirectoryOutputType},
+					{Path: "abc", Type: WildcardOutputType},
+				},
+				OutputDirs: []string{"def"},
+			},
+			wantCmd: &repb.Command{OutputPaths: []string{"abc", "bar", "def", "foo"}},
+		},
 		{
 			name: "sort environment variables",
 			cmd: &Command{
@@ -522,6 +854,14 @@ func TestResultToFromProto(t *testing.T) {
 	}
 }
 
+func TestResultToFromProtoStatuses(t *testing.T) {
+	for _, res := range []*Result{NewTimeoutResult(), NewClientDeadlineResult(errors.New("deadline")), NewCancelledResult(errors.New("cancelled"))} {
+		if got := ResultFromProto(ResultToProto(res)).Status; got != res.Status {
+			t.Errorf("ResultFromProto(ResultToProto(%v)) gave status %v, want %v", res, got, res.Status)
+		}
+	}
+}
+
 func TestTimeIntervalToFromProto(t *testing.T) {
 	ti := &TimeInterval{
 		From: time.Now(),
@@ -551,3 +891,429 @@ func TestTimeIntervalToFromProto(t *testing.T) {
 		t.Errorf("TimeIntervalFromProto(TimeIntervalToProto()) returned %v, wanted nil", gotTi)
 	}
 }
+
+func TestAuxiliaryMetadata(t *testing.T) {
+	dur, err := anypb.New(dpb.New(time.Second))
+	if err != nil {
+		t.Fatalf("anypb.New() failed: %v", err)
+	}
+	str, err := anypb.New(wpb.String("usage"))
+	if err != nil {
+		t.Fatalf("anypb.New() failed: %v", err)
+	}
+	unknown := &anypb.Any{TypeUrl: "type.googleapis.com/unknown.Usage", Value: []byte("x")}
+	md := &Metadata{AuxiliaryMetadata: []*anypb.Any{unknown, dur, str}}
+
+	gotDur := &dpb.Duration{}
+	if ok, err := md.UnmarshalAuxiliaryMetadata(gotDur); !ok || err != nil {
+		t.Errorf("UnmarshalAuxiliaryMetadata(Duration) = %v, %v, want true, nil", ok, err)
+	}
+	if gotDur.AsDuration() != time.Second {
+		t.Errorf("UnmarshalAuxiliaryMetadata(Duration) decoded %v, want %v", gotDur.AsDuration(), time.Second)
+	}
+	if ok, err := md.UnmarshalAuxiliaryMetadata(&wpb.Int64Value{}); ok || err != nil {
+		t.Errorf("UnmarshalAuxiliaryMetadata(Int64Value) = %v, %v, want false, nil", ok, err)
+	}
+
+	got, err := md.DecodeAuxiliaryMetadata()
+	if err != nil {
+		t.Fatalf("DecodeAuxiliaryMetadata() failed: %v", err)
+	}
+	want := []proto.Message{dpb.New(time.Second), wpb.String("usage")}
+	if diff := cmp.Diff(want, got, protocmp.Transform()); diff != "" {
+		t.Errorf("DecodeAuxiliaryMetadata() returned diff in result: (-want +got)\n%s", diff)
+	}
+}
+
+func TestMatchOutputGlob(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		pattern, name string
+		want          bool
+	}{
+		{"obj/*.o", "obj/a.o", true},
+		{"obj/*.o", "obj/x/a.o", false},
+		{"obj/**/*.o", "obj/a.o", true},
+		{"obj/**/*.o", "obj/x/y/a.o", true},
+		{"obj/**/*.o", "obj/x/a.d", false},
+		{"obj/**", "obj/x/a.d", true},
+		{"obj/a?.[ch]", "obj/ab.c", true},
+		{"obj/*.o", "lib/a.o", false},
+	}
+	for _, tc := range tests {
+		if got := MatchOutputGlob(tc.pattern, tc.name); got != tc.want {
+			t.Errorf("MatchOutputGlob(%q, %q) = %v, want %v", tc.pattern, tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestInputExclusionGlob(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		glob, path string
+		want       bool
+	}{
+		{"*.txt", "/root/a.txt", true},
+		{"*.txt", "/root/dir/a.txt", true},
+		{"*.txt", "/root/a.txt.bak", false},
+		{"node_modules/**", "/root/node_modules", true},
+		{"node_modules/**", "/root/app/node_modules/x/y", true},
+		{"node_modules/**", "/root/my_node_modules", false},
+		{"out/**/*.o", "/root/out/a.o", true},
+		{"out/**/*.o", "/root/out/x/y/a.o", true},
+		{"out/**/*.o", "/root/out/x/a.d", false},
+		{"a?.[ch]", "/root/ab.c", true},
+		{"a?.[^ch]", "/root/ab.c", false},
+		{`a\*`, "/root/a*", true},
+		{`a\*`, "/root/ab", false},
+		{"**", "/root/anything", true},
+	}
+	for _, tc := range tests {
+		ex := &InputExclusion{Glob: tc.glob}
+		re, err := regexp.Compile(ex.Pattern())
+		if err != nil {
+			t.Fatalf("Pattern() of glob %q gave invalid regexp %q: %v", tc.glob, ex.Pattern(), err)
+		}
+		if got := re.MatchString(tc.path); got != tc.want {
+			t.Errorf("Pattern() of glob %q = %q, matches %q: %v, want %v", tc.glob, ex.Pattern(), tc.path, got, tc.want)
+		}
+	}
+}
+
+func TestOutputGlobRoot(t *testing.T) {
+	t.Parallel()
+	tests := map[string]string{
+		"obj/**/*.o":    "obj",
+		"out/gen/*.h":   "out/gen",
+		"out/*/lib.a":   "out",
+		"*.o":           "",
+		"out/gen/lib.a": "out/gen",
+	}
+	for pattern, want := range tests {
+		if got := OutputGlobRoot(pattern); got != want {
+			t.Errorf("OutputGlobRoot(%q) = %q, want %q", pattern, got, want)
+		}
+	}
+}
+
+// fullCommand returns a command with all of its fields set.
+func fullCommand() *Command {
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	return &Command{
+		Identifiers: &Identifiers{
+			CommandID:          "a",
+			InvocationID:       "b",
+			ToolName:           "c",
+			ExecutionID:        "d",
+			ParentInvocationID: "e",
+			Attempt:            2,
+			BuildPhase:         "test",
+			Labels:             map[string]string{"target": "//a:b"},
+		},
+		Args:             []string{"tool", "-v"},
+		ExecRoot:         "/exec/root",
+		WorkingDir:       "wd",
+		RemoteWorkingDir: "rwd",
+		InputSpec: &InputSpec{
+			Inputs: []string{"in"},
+			VirtualInputs: []*VirtualInput{
+				{Path: "v", Contents: []byte("foo"), IsExecutable: true, Mtime: mtime, FileMode: 0755},
+				{Path: "t", TreeDigest: "abc/1"},
+			},
+			InputExclusions: []*InputExclusion{
+				{Regex: `\.bak$`, Type: FileInputType},
+				{Regex: "tmp"},
+				{Glob: "node_modules/**", Type: DirectoryInputType},
+			},
+			EnvironmentVariables:   map[string]string{"k": "v"},
+			EnvironmentPassthrough: []string{"HOME"},
+			EnvironmentAllowlist:   []string{"k", "HOME"},
+			SymlinkBehavior:        PreserveSymlink,
+			InputNodeProperties: map[string]*cpb.NodeProperties{
+				"in": {
+					Properties: []*cpb.NodeProperty{{Name: "p", Value: "q"}},
+					Mtime:      tspb.New(mtime),
+					UnixMode:   wpb.UInt32(0644),
+				},
+			},
+		},
+		Outputs:              []*OutputSpec{{Path: "obj/*.o", Type: GlobOutputType}},
+		OutputFiles:          []string{"out"},
+		OutputDirs:           []string{"dir"},
+		Timeout:              90 * time.Second,
+		Platform:             map[string]string{"OSFamily": "linux"},
+		OutputNodeProperties: []string{MtimeNodeProperty},
+	}
+}
+
+func TestProtoRoundTrip(t *testing.T) {
+	t.Parallel()
+	cmd := fullCommand()
+	cmd.Timeout = 1500 * time.Millisecond
+	cmd.InputSpec.InputExclusions = append(cmd.InputSpec.InputExclusions, &InputExclusion{Regex: "link", Type: SymlinkInputType})
+	cmd.Outputs = append(cmd.Outputs, &OutputSpec{Path: "log", Type: WildcardOutputType})
+	blob, err := proto.Marshal(cmd.ToProto())
+	if err != nil {
+		t.Fatalf("proto.Marshal() failed: %v", err)
+	}
+	cPb := &cpb.Command{}
+	if err := proto.Unmarshal(blob, cPb); err != nil {
+		t.Fatalf("proto.Unmarshal() failed: %v", err)
+	}
+	if diff := cmp.Diff(cmd, CommandFromProto(cPb), protocmp.Transform(), cmpopts.EquateEmpty()); diff != "" {
+		t.Errorf("CommandFromProto(ToProto()) gave diff (-want +got):\n%s", diff)
+	}
+	// Readers not aware of typed outputs see them as files and glob roots.
+	if diff := cmp.Diff([]string{"out", "log"}, cPb.Output.OutputFiles); diff != "" {
+		t.Errorf("ToProto() gave output files diff (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"dir", "obj"}, cPb.Output.OutputDirectories); diff != "" {
+		t.Errorf("ToProto() gave output directories diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	t.Parallel()
+	cmd := fullCommand()
+	golden := `{
+  "identifiers": {
+    "command_id": "a",
+    "invocation_id": "b",
+    "tool_name": "c",
+    "execution_id": "d",
+    "parent_invocation_id": "e",
+    "attempt": 2,
+    "build_phase": "test",
+    "labels": {
+      "target": "//a:b"
+    }
+  },
+  "args": [
+    "tool",
+    "-v"
+  ],
+  "exec_root": "/exec/root",
+  "working_directory": "wd",
+  "remote_working_directory": "rwd",
+  "input_spec": {
+    "inputs": [
+      "in"
+    ],
+    "virtual_inputs": [
+      {
+        "path": "v",
+        "contents": "Zm9v",
+        "is_executable": true,
+        "mtime": "2020-01-02T03:04:05Z",
+        "file_mode": 493
+      },
+      {
+        "path": "t",
+        "tree_digest": "abc/1"
+      }
+    ],
+    "input_exclusions": [
+      {
+        "regex": "\\.bak$",
+        "type": "FileInputType"
+      },
+      {
+        "regex": "tmp"
+      },
+      {
+        "glob": "node_modules/**",
+        "type": "DirectoryInputType"
+      }
+    ],
+    "environment_variables": {
+      "k": "v"
+    },
+    "environment_passthrough": [
+      "HOME"
+    ],
+    "environment_allowlist": [
+      "k",
+      "HOME"
+    ],
+    "symlink_behavior": "PreserveSymlink",
+    "input_node_properties": {
+      "in": {
+        "properties": [
+          {
+            "name": "p",
+            "value": "q"
+          }
+        ],
+        "mtime": "2020-01-02T03:04:05Z",
+        "unix_mode": 420
+      }
+    }
+  },
+  "outputs": [
+    {
+      "path": "obj/*.o",
+      "type": "GlobOutputType"
+    }
+  ],
+  "output_files": [
+    "out"
+  ],
+  "output_directories": [
+    "dir"
+  ],
+  "timeout": "1m30s",
+  "platform": {
+    "OSFamily": "linux"
+  },
+  "output_node_properties": [
+    "mtime"
+  ]
+}`
+	got, err := json.MarshalIndent(cmd, "", "  ")
+	if err != nil {
+		t.Fatalf("json.MarshalIndent(%v) failed: %v", cmd, err)
+	}
+	if diff := cmp.Diff(golden, string(got)); diff != "" {
+		t.Errorf("json.MarshalIndent(%v) gave diff (-want +got):\n%s", cmd, diff)
+	}
+	back := &Command{}
+	if err := json.Unmarshal(got, back); err != nil {
+		t.Fatalf("json.Unmarshal() failed: %v", err)
+	}
+	if diff := cmp.Diff(cmd, back, protocmp.Transform()); diff != "" {
+		t.Errorf("json.Unmarshal() gave diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestJSONUnknownEnum(t *testing.T) {
+	t.Parallel()
+	if err := json.Unmarshal([]byte(`{"outputs": [{"path": "a", "type": "Bogus"}]}`), &Command{}); err == nil {
+		t.Errorf("json.Unmarshal() of an unknown output type succeeded, want error")
+	}
+}
+
+func TestResultPredicates(t *testing.T) {
+	missing, err := status.New(codes.FailedPrecondition, "missing inputs").WithDetails(&errdetails.PreconditionFailure{
+		Violations: []*errdetails.PreconditionFailure_Violation{{Type: "MISSING", Subject: "blobs/abc/3"}},
+	})
+	if err != nil {
+		t.Fatalf("WithDetails() failed: %v", err)
+	}
+	tests := []struct {
+		name                                 string
+		res                                  *Result
+		wantRetriable, wantMissing, wantTime bool
+	}{
+		{name: "success", res: NewResultFromExitCode(0)},
+		{name: "non zero exit", res: NewResultFromExitCode(1)},
+		{name: "unavailable", res: NewRemoteErrorResult(status.Error(codes.Unavailable, "down")), wantRetriable: true},
+		{name: "invalid argument", res: NewRemoteErrorResult(status.Error(codes.InvalidArgument, "bad"))},
+		{name: "missing inputs", res: NewRemoteErrorResult(missing.Err()), wantMissing: true},
+		{name: "timeout", res: NewTimeoutResult(), wantTime: true},
+		{name: "client deadline", res: NewClientDeadlineResult(errors.New("deadline")), wantRetriable: true},
+		{name: "local error", res: NewLocalErrorResult(errors.New("no such file"))},
+	}
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.res.IsRetriable(); got != tc.wantRetriable {
+				t.Errorf("IsRetriable() = %v, want %v", got, tc.wantRetriable)
+			}
+			if got := tc.res.IsMissingInputs(); got != tc.wantMissing {
+				t.Errorf("IsMissingInputs() = %v, want %v", got, tc.wantMissing)
+			}
+			if got := tc.res.IsTimeout(); got != tc.wantTime {
+				t.Errorf("IsTimeout() = %v, want %v", got, tc.wantTime)
+			}
+		})
+	}
+}
+
+func TestResultErrorTypes(t *testing.T) {
+	cause := errors.New("no such file")
+	var le *LocalError
+	if res := NewLocalErrorResult(cause); !errors.As(res.Err, &le) || !errors.Is(res.Err, cause) {
+		t.Errorf("NewLocalErrorResult(%v).Err = %#v, want a LocalError wrapping it", cause, res.Err)
+	}
+	var re *RemoteError
+	res := NewRemoteErrorResult(status.Error(codes.Internal, "problem"))
+	if !errors.As(res.Err, &re) || re.Status.Code() != codes.Internal {
+		t.Errorf("NewRemoteErrorResult().Err = %#v, want a RemoteError with code Internal", res.Err)
+	}
+	if st, _ := status.FromError(res.Err); st.Message() != "problem" {
+		t.Errorf("status.FromError(%v) gave message %q, want %q", res.Err, st.Message(), "problem")
+	}
+}
+
+func TestParseDepfile(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want []string
+	}{
+		{
+			name: "gcc",
+			data: "foo.o: foo.c foo.h \\\n  ../include/bar.h\n",
+			want: []string{"foo.c", "foo.h", "../include/bar.h"},
+		},
+		{
+			name: "multiple targets and rules with duplicates",
+			data: "a.o b.o: a.h\nb.o: b.h a.h\n",
+			want: []string{"a.h", "b.h"},
+		},
+		{
+			name: "phony targets",
+			data: "foo.o: foo.c foo.h\n\nfoo.h:\n",
+			want: []string{"foo.c", "foo.h"},
+		},
+		{
+			name: "escapes",
+			data: `foo.o: with\ space.h hash\#.h dollar$$.h trailing\\ back\\\ slash.h` + "\n",
+			want: []string{"with space.h", "hash#.h", "dollar$.h", `trailing\`, `back\ slash.h`},
+		},
+		{
+			name: "comments and CRLF",
+			data: "# generated\r\nfoo.o: foo.c \\\r\n foo.h # trailing comment\r\n",
+			want: []string{"foo.c", "foo.h"},
+		},
+		{
+			name: "windows paths",
+			data: `foo.obj: C:\src\foo.c C:\src\foo.h` + "\n",
+			want: []string{`C:\src\foo.c`, `C:\src\foo.h`},
+		},
+	}
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ParseDepfile([]byte(tc.data))
+			if err != nil {
+				t.Fatalf("ParseDepfile(%q) failed: %v", tc.data, err)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("ParseDepfile(%q) gave diff (-want +got):\n%s", tc.data, diff)
+			}
+		})
+	}
+}
+
+func TestParseDepfileErrors(t *testing.T) {
+	for _, data := range []string{"foo.o foo.c\n", ": foo.c\n"} {
+		if _, err := ParseDepfile([]byte(data)); err == nil {
+			t.Errorf("ParseDepfile(%q) succeeded, want an error", data)
+		}
+	}
+}
+
+func TestDepfileInputs(t *testing.T) {
+	execRoot := filepath.Join(string(filepath.Separator), "root")
+	data := "obj/foo.o: ../src/foo.c ./gen/foo.h /usr/include/stdio.h " + filepath.Join(execRoot, "include", "bar.h") + " ../../outside.h\n"
+
+	got, err := DepfileInputs([]byte(data), execRoot, "out")
+	if err != nil {
+		t.Fatalf("DepfileInputs() failed: %v", err)
+	}
+	want := []string{filepath.Join("src", "foo.c"), filepath.Join("out", "gen", "foo.h"), filepath.Join("include", "bar.h")}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("DepfileInputs() gave diff (-want +got):\n%s", diff)
+	}
+}
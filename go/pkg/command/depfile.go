@@ -0,0 +1,133 @@
+package command
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// ParseDepfile returns the prerequisites of all the rules of a Makefile-style dependency file, such
+// as written by gcc -MD or clang -MD, in order and without duplicates. Lines are continued by a
+// trailing backslash, and comments start with an unescaped #. In file names, a space or a # is
+// escaped by a backslash, a backslash before an escaped space is itself escaped by a backslash, and
+// $$ stands for $. A colon is a rule separator only when it is followed by a space or the end of
+// the line, so that Windows paths such as C:\src\a.h are read as file names.
+func ParseDepfile(data []byte) ([]string, error) {
+	text := strings.ReplaceAll(string(data), "\r\n", "\n")
+	text = strings.ReplaceAll(text, "\\\n", " ")
+	var deps []string
+	seen := make(map[string]bool)
+	for i, line := range strings.Split(text, "\n") {
+		words, colon := splitDepfileLine(line)
+		if len(words) == 0 {
+			continue
+		}
+		if colon < 0 {
+			return nil, fmt.Errorf("line %d: missing ':' after the targets", i+1)
+		}
+		if colon == 0 {
+			return nil, fmt.Errorf("line %d: rule without a target", i+1)
+		}
+		for _, w := range words[colon:] {
+			if !seen[w] {
+				seen[w] = true
+				deps = append(deps, w)
+			}
+		}
+	}
+	return deps, nil
+}
+
+// splitDepfileLine splits a logical line of a depfile into unescaped words, and returns the index
+// of the first prerequisite, which is the number of targets, or -1 if the line has no rule
+// separator.
+func splitDepfileLine(line string) (words []string, colon int) {
+	colon = -1
+	var cur strings.Builder
+	inWord := false
+	flush := func() {
+		if inWord {
+			words = append(words, cur.String())
+			cur.Reset()
+			inWord = false
+		}
+	}
+	isSpace := func(i int) bool { return i >= len(line) || line[i] == ' ' || line[i] == '\t' }
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case c == ' ' || c == '\t':
+			flush()
+		case c == '#':
+			flush()
+			return words, colon
+		case c == '\\':
+			n := 1
+			for i+n < len(line) && line[i+n] == '\\' {
+				n++
+			}
+			next := i + n
+			switch {
+			case next < len(line) && (line[next] == ' ' || line[next] == '\t'):
+				// 2N backslashes before a space are N backslashes ending the word, 2N+1 are N
+				// backslashes and an escaped space.
+				cur.WriteString(strings.Repeat("\\", n/2))
+				inWord = inWord || n >= 2
+				if n%2 == 1 {
+					cur.WriteByte(line[next])
+					inWord = true
+					next++
+				}
+			case next < len(line) && line[next] == '#':
+				cur.WriteString(strings.Repeat("\\", n-1))
+				cur.WriteByte('#')
+				inWord = true
+				next++
+			default:
+				cur.WriteString(strings.Repeat("\\", n))
+				inWord = true
+			}
+			i = next - 1
+		case c == '$' && i+1 < len(line) && line[i+1] == '$':
+			cur.WriteByte('$')
+			inWord = true
+			i++
+		case c == ':' && colon < 0 && isSpace(i+1):
+			flush()
+			colon = len(words)
+		default:
+			cur.WriteByte(c)
+			inWord = true
+		}
+	}
+	flush()
+	return words, colon
+}
+
+// DepfileInputs returns the prerequisites of a dependency file, see ParseDepfile, as input paths
+// for InputSpec.Inputs: cleaned and relative to execRoot. Relative prerequisites are taken as
+// relative to workingDir, the directory the depfile was written from, relative to execRoot.
+// Prerequisites outside of execRoot, such as system headers, are skipped, as they are expected to
+// be provided by the remote platform.
+func DepfileInputs(data []byte, execRoot, workingDir string) ([]string, error) {
+	deps, err := ParseDepfile(data)
+	if err != nil {
+		return nil, err
+	}
+	var inputs []string
+	seen := make(map[string]bool)
+	for _, d := range deps {
+		p := d
+		if !filepath.IsAbs(p) {
+			p = filepath.Join(execRoot, workingDir, p)
+		}
+		rel, err := filepath.Rel(execRoot, p)
+		if err != nil || !filepath.IsLocal(rel) {
+			continue
+		}
+		if !seen[rel] {
+			seen[rel] = true
+			inputs = append(inputs, rel)
+		}
+	}
+	return inputs, nil
+}
@@ -0,0 +1,85 @@
+package command
+
+import (
+	"errors"
+
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/retry"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	errdetails "google.golang.org/genproto/googleapis/rpc/errdetails"
+)
+
+// RemoteError is the error of a Result with RemoteErrorResultStatus.
+type RemoteError struct {
+	// Status is the gRPC status of the failure. It is Unknown if the error carried no status.
+	Status *status.Status
+	// Err is the underlying error.
+	Err error
+}
+
+func (e *RemoteError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *RemoteError) Unwrap() error {
+	return e.Err
+}
+
+// GRPCStatus returns the gRPC status of the failure, so that status.FromError works on e.
+func (e *RemoteError) GRPCStatus() *status.Status {
+	return e.Status
+}
+
+// LocalError is the error of a Result with LocalErrorResultStatus.
+type LocalError struct {
+	// Cause is the underlying error.
+	Cause error
+}
+
+func (e *LocalError) Error() string {
+	return e.Cause.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *LocalError) Unwrap() error {
+	return e.Cause
+}
+
+// IsRetriable returns whether the failure is likely transient, so that running the command again
+// may succeed: a remote error with a transient gRPC code, or the client deadline expiring.
+func (r *Result) IsRetriable() bool {
+	if r.Status == ClientDeadlineResultStatus {
+		return true
+	}
+	var re *RemoteError
+	return r.Status == RemoteErrorResultStatus && errors.As(r.Err, &re) && retry.TransientOnly(re.Status.Err())
+}
+
+// IsMissingInputs returns whether the server failed the execution because inputs were missing
+// from the CAS, e.g. evicted after they were uploaded. Executing the command again uploads them.
+func (r *Result) IsMissingInputs() bool {
+	var re *RemoteError
+	if r.Status != RemoteErrorResultStatus || !errors.As(r.Err, &re) || re.Status.Code() != codes.FailedPrecondition {
+		return false
+	}
+	for _, d := range re.Status.Details() {
+		pf, ok := d.(*errdetails.PreconditionFailure)
+		if !ok {
+			continue
+		}
+		for _, v := range pf.GetViolations() {
+			if v.GetType() == "MISSING" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// IsTimeout returns whether the command timed out on the remote worker. The client deadline
+// expiring is not a timeout of the command, see ClientDeadlineResultStatus.
+func (r *Result) IsTimeout() bool {
+	return r.Status == TimeoutResultStatus
+}
@@ -0,0 +1,106 @@
+package command
+
+import (
+	"path"
+	"regexp"
+	"strings"
+)
+
+// OutputGlobRoot returns the directory of the outputs matched by an output glob pattern: its
+// leading path segments without wildcards, other than the last segment. For example, the root of
+// "obj/**/*.o" is "obj". The RE API has no output globs, so the root is requested from the server
+// as an output directory, and filtered with the pattern on download.
+func OutputGlobRoot(pattern string) string {
+	segs := strings.Split(pattern, "/")
+	i := 0
+	for i < len(segs)-1 && !hasGlobMeta(segs[i]) {
+		i++
+	}
+	return strings.Join(segs[:i], "/")
+}
+
+// MatchOutputGlob returns whether the slash-separated output path matches the pattern. Path
+// segments are matched as in path.Match, and a "**" segment matches any number of segments,
+// including none.
+func MatchOutputGlob(pattern, name string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, err := path.Match(pattern[0], name[0]); err != nil || !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+func hasGlobMeta(seg string) bool {
+	return strings.ContainsAny(seg, `*?[\`)
+}
+
+// globToRegex translates a glob pattern, matched as in MatchOutputGlob, to a regular expression
+// matching the paths whose last segments match the pattern.
+func globToRegex(pattern string) string {
+	segs := strings.Split(pattern, "/")
+	re := "(^|/)"
+	for i, seg := range segs {
+		last := i == len(segs)-1
+		switch {
+		case seg == "**" && last && i > 0:
+			re = strings.TrimSuffix(re, "/") + "(/.*)?"
+		case seg == "**" && last:
+			re += ".*"
+		case seg == "**":
+			re += "(.*/)?"
+		default:
+			re += segmentToRegex(seg)
+			if !last {
+				re += "/"
+			}
+		}
+	}
+	return re + "$"
+}
+
+// segmentToRegex translates a path segment pattern, in the syntax of path.Match, to a regular
+// expression.
+func segmentToRegex(seg string) string {
+	var b strings.Builder
+	for i := 0; i < len(seg); i++ {
+		switch c := seg[i]; {
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		case c == '[':
+			// Character classes have the same syntax. An unterminated one is left for the regular
+			// expression to reject.
+			j := strings.IndexByte(seg[i+1:], ']')
+			if j < 0 {
+				b.WriteString(seg[i:])
+				return b.String()
+			}
+			b.WriteString(seg[i : i+j+2])
+			i += j + 1
+		case c == '\\' && i+1 < len(seg):
+			b.WriteString(regexp.QuoteMeta(seg[i+1 : i+2]))
+			i++
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,297 @@
+package command
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	cpb "github.com/bazelbuild/remote-apis-sdks/go/api/command"
+	tspb "google.golang.org/protobuf/types/known/timestamppb"
+	wpb "google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// The JSON encoding of a Command keeps all of its fields, like the Command proto, in a readable
+// form, so that commands can be persisted, replayed and diffed across builds. The field names below
+// are part of the format and must not change; enums are encoded by name.
+
+type jsonCommand struct {
+	Identifiers          *jsonIdentifiers  `json:"identifiers,omitempty"`
+	Args                 []string          `json:"args,omitempty"`
+	ExecRoot             string            `json:"exec_root,omitempty"`
+	WorkingDir           string            `json:"working_directory,omitempty"`
+	RemoteWorkingDir     string            `json:"remote_working_directory,omitempty"`
+	InputSpec            *jsonInputSpec    `json:"input_spec,omitempty"`
+	Outputs              []*jsonOutput     `json:"outputs,omitempty"`
+	OutputFiles          []string          `json:"output_files,omitempty"`
+	OutputDirs           []string          `json:"output_directories,omitempty"`
+	Timeout              string            `json:"timeout,omitempty"`
+	Platform             map[string]string `json:"platform,omitempty"`
+	OutputNodeProperties []string          `json:"output_node_properties,omitempty"`
+}
+
+type jsonIdentifiers struct {
+	CommandID              string            `json:"command_id,omitempty"`
+	InvocationID           string            `json:"invocation_id,omitempty"`
+	CorrelatedInvocationID string            `json:"correlated_invocation_id,omitempty"`
+	ToolName               string            `json:"tool_name,omitempty"`
+	ToolVersion            string            `json:"tool_version,omitempty"`
+	ExecutionID            string            `json:"execution_id,omitempty"`
+	ParentInvocationID     string            `json:"parent_invocation_id,omitempty"`
+	Attempt                int               `json:"attempt,omitempty"`
+	BuildPhase             string            `json:"build_phase,omitempty"`
+	Labels                 map[string]string `json:"labels,omitempty"`
+}
+
+type jsonInputSpec struct {
+	Inputs                 []string                       `json:"inputs,omitempty"`
+	VirtualInputs          []*jsonVirtualInput            `json:"virtual_inputs,omitempty"`
+	InputExclusions        []*jsonInputExclusion          `json:"input_exclusions,omitempty"`
+	EnvironmentVariables   map[string]string              `json:"environment_variables,omitempty"`
+	EnvironmentPassthrough []string                       `json:"environment_passthrough,omitempty"`
+	EnvironmentAllowlist   []string                       `json:"environment_allowlist,omitempty"`
+	SymlinkBehavior        string                         `json:"symlink_behavior,omitempty"`
+	InputNodeProperties    map[string]*jsonNodeProperties `json:"input_node_properties,omitempty"`
+}
+
+type jsonVirtualInput struct {
+	Path             string     `json:"path"`
+	Contents         []byte     `json:"contents,omitempty"`
+	Digest           string     `json:"digest,omitempty"`
+	TreeDigest       string     `json:"tree_digest,omitempty"`
+	IsExecutable     bool       `json:"is_executable,omitempty"`
+	IsEmptyDirectory bool       `json:"is_empty_directory,omitempty"`
+	Mtime            *time.Time `json:"mtime,omitempty"`
+	FileMode         uint32     `json:"file_mode,omitempty"`
+}
+
+type jsonInputExclusion struct {
+	Regex string `json:"regex,omitempty"`
+	Glob  string `json:"glob,omitempty"`
+	Type  string `json:"type,omitempty"`
+}
+
+type jsonOutput struct {
+	Path string `json:"path"`
+	Type string `json:"type"`
+}
+
+type jsonNodeProperties struct {
+	Properties []*jsonNodeProperty `json:"properties,omitempty"`
+	Mtime      *time.Time          `json:"mtime,omitempty"`
+	UnixMode   *uint32             `json:"unix_mode,omitempty"`
+}
+
+type jsonNodeProperty struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// MarshalJSON encodes the command as JSON, keeping all of its fields.
+func (c *Command) MarshalJSON() ([]byte, error) {
+	jc := &jsonCommand{
+		Args:                 c.Args,
+		ExecRoot:             c.ExecRoot,
+		WorkingDir:           c.WorkingDir,
+		RemoteWorkingDir:     c.RemoteWorkingDir,
+		OutputFiles:          c.OutputFiles,
+		OutputDirs:           c.OutputDirs,
+		Platform:             c.Platform,
+		OutputNodeProperties: c.OutputNodeProperties,
+	}
+	if c.Timeout != 0 {
+		jc.Timeout = c.Timeout.String()
+	}
+	if id := c.Identifiers; id != nil {
+		jc.Identifiers = &jsonIdentifiers{
+			CommandID:              id.CommandID,
+			InvocationID:           id.InvocationID,
+			CorrelatedInvocationID: id.CorrelatedInvocationID,
+			ToolName:               id.ToolName,
+			ToolVersion:            id.ToolVersion,
+			ExecutionID:            id.ExecutionID,
+			ParentInvocationID:     id.ParentInvocationID,
+			Attempt:                id.Attempt,
+			BuildPhase:             id.BuildPhase,
+			Labels:                 id.Labels,
+		}
+	}
+	for _, o := range c.Outputs {
+		jc.Outputs = append(jc.Outputs, &jsonOutput{Path: o.Path, Type: o.Type.String()})
+	}
+	if is := c.InputSpec; is != nil {
+		js := &jsonInputSpec{
+			Inputs:                 is.Inputs,
+			EnvironmentVariables:   is.EnvironmentVariables,
+			EnvironmentPassthrough: is.EnvironmentPassthrough,
+			EnvironmentAllowlist:   is.EnvironmentAllowlist,
+		}
+		if is.SymlinkBehavior != UnspecifiedSymlinkBehavior {
+			js.SymlinkBehavior = is.SymlinkBehavior.String()
+		}
+		for _, vi := range is.VirtualInputs {
+			jvi := &jsonVirtualInput{
+				Path:             vi.Path,
+				Contents:         vi.Contents,
+				Digest:           vi.Digest,
+				TreeDigest:       vi.TreeDigest,
+				IsExecutable:     vi.IsExecutable,
+				IsEmptyDirectory: vi.IsEmptyDirectory,
+				FileMode:         uint32(vi.FileMode),
+			}
+			if !vi.Mtime.IsZero() {
+				mtime := vi.Mtime
+				jvi.Mtime = &mtime
+			}
+			js.VirtualInputs = append(js.VirtualInputs, jvi)
+		}
+		for _, ex := range is.InputExclusions {
+			je := &jsonInputExclusion{Regex: ex.Regex, Glob: ex.Glob}
+			if ex.Type != UnspecifiedInputType {
+				je.Type = ex.Type.String()
+			}
+			js.InputExclusions = append(js.InputExclusions, je)
+		}
+		for path, np := range is.InputNodeProperties {
+			if js.InputNodeProperties == nil {
+				js.InputNodeProperties = make(map[string]*jsonNodeProperties)
+			}
+			js.InputNodeProperties[path] = nodePropertiesToJSON(np)
+		}
+		jc.InputSpec = js
+	}
+	return json.Marshal(jc)
+}
+
+// UnmarshalJSON decodes a command encoded by MarshalJSON.
+func (c *Command) UnmarshalJSON(data []byte) error {
+	jc := &jsonCommand{}
+	if err := json.Unmarshal(data, jc); err != nil {
+		return err
+	}
+	cmd := Command{
+		Args:                 jc.Args,
+		ExecRoot:             jc.ExecRoot,
+		WorkingDir:           jc.WorkingDir,
+		RemoteWorkingDir:     jc.RemoteWorkingDir,
+		OutputFiles:          jc.OutputFiles,
+		OutputDirs:           jc.OutputDirs,
+		Platform:             jc.Platform,
+		OutputNodeProperties: jc.OutputNodeProperties,
+	}
+	if jc.Timeout != "" {
+		t, err := time.ParseDuration(jc.Timeout)
+		if err != nil {
+			return fmt.Errorf("invalid timeout: %v", err)
+		}
+		cmd.Timeout = t
+	}
+	if id := jc.Identifiers; id != nil {
+		cmd.Identifiers = &Identifiers{
+			CommandID:              id.CommandID,
+			InvocationID:           id.InvocationID,
+			CorrelatedInvocationID: id.CorrelatedInvocationID,
+			ToolName:               id.ToolName,
+			ToolVersion:            id.ToolVersion,
+			ExecutionID:            id.ExecutionID,
+			ParentInvocationID:     id.ParentInvocationID,
+			Attempt:                id.Attempt,
+			BuildPhase:             id.BuildPhase,
+			Labels:                 id.Labels,
+		}
+	}
+	for _, o := range jc.Outputs {
+		t, err := parseEnum(o.Type, outputTypes[:], FileOutputType)
+		if err != nil {
+			return fmt.Errorf("invalid type of output %q: %v", o.Path, err)
+		}
+		cmd.Outputs = append(cmd.Outputs, &OutputSpec{Path: o.Path, Type: t})
+	}
+	if js := jc.InputSpec; js != nil {
+		is := &InputSpec{
+			Inputs:                 js.Inputs,
+			EnvironmentVariables:   js.EnvironmentVariables,
+			EnvironmentPassthrough: js.EnvironmentPassthrough,
+			EnvironmentAllowlist:   js.EnvironmentAllowlist,
+		}
+		sb, err := parseEnum(js.SymlinkBehavior, symlinkBehaviorType[:], UnspecifiedSymlinkBehavior)
+		if err != nil {
+			return fmt.Errorf("invalid symlink behavior: %v", err)
+		}
+		is.SymlinkBehavior = sb
+		for _, jvi := range js.VirtualInputs {
+			vi := &VirtualInput{
+				Path:             jvi.Path,
+				Contents:         jvi.Contents,
+				Digest:           jvi.Digest,
+				TreeDigest:       jvi.TreeDigest,
+				IsExecutable:     jvi.IsExecutable,
+				IsEmptyDirectory: jvi.IsEmptyDirectory,
+				FileMode:         os.FileMode(jvi.FileMode),
+			}
+			if jvi.Mtime != nil {
+				vi.Mtime = *jvi.Mtime
+			}
+			is.VirtualInputs = append(is.VirtualInputs, vi)
+		}
+		for _, je := range js.InputExclusions {
+			t, err := parseEnum(je.Type, inputTypes[:], UnspecifiedInputType)
+			if err != nil {
+				return fmt.Errorf("invalid type of input exclusion %q: %v", je.Regex+je.Glob, err)
+			}
+			is.InputExclusions = append(is.InputExclusions, &InputExclusion{Regex: je.Regex, Glob: je.Glob, Type: t})
+		}
+		for path, np := range js.InputNodeProperties {
+			if is.InputNodeProperties == nil {
+				is.InputNodeProperties = make(map[string]*cpb.NodeProperties)
+			}
+			is.InputNodeProperties[path] = nodePropertiesFromJSON(np)
+		}
+		cmd.InputSpec = is
+	}
+	*c = cmd
+	return nil
+}
+
+// parseEnum returns the value of an enum from its name, or def if the name is empty.
+func parseEnum[T ~int](name string, names []string, def T) (T, error) {
+	if name == "" {
+		return def, nil
+	}
+	for i, n := range names {
+		if n == name {
+			return T(i), nil
+		}
+	}
+	return def, fmt.Errorf("unknown value %q", name)
+}
+
+func nodePropertiesToJSON(np *cpb.NodeProperties) *jsonNodeProperties {
+	res := &jsonNodeProperties{}
+	for _, p := range np.GetProperties() {
+		res.Properties = append(res.Properties, &jsonNodeProperty{Name: p.Name, Value: p.Value})
+	}
+	if np.GetMtime() != nil {
+		mtime := np.GetMtime().AsTime()
+		res.Mtime = &mtime
+	}
+	if np.GetUnixMode() != nil {
+		mode := np.GetUnixMode().GetValue()
+		res.UnixMode = &mode
+	}
+	return res
+}
+
+func nodePropertiesFromJSON(np *jsonNodeProperties) *cpb.NodeProperties {
+	res := &cpb.NodeProperties{}
+	for _, p := range np.Properties {
+		res.Properties = append(res.Properties, &cpb.NodeProperty{Name: p.Name, Value: p.Value})
+	}
+	if np.Mtime != nil {
+		res.Mtime = tspb.New(*np.Mtime)
+	}
+	if np.UnixMode != nil {
+		res.UnixMode = wpb.UInt32(*np.UnixMode)
+	}
+	return res
+}
@@ -0,0 +1,226 @@
+package command
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"sort"
+	"strconv"
+
+	cpb "github.com/bazelbuild/remote-apis-sdks/go/api/command"
+)
+
+// StableIDVersion is the version of the canonical serialization of commands hashed by StableID.
+// It changes whenever the serialization does, so stable IDs are only comparable within a version.
+const StableIDVersion = 3
+
+// StableID returns an ID identifying what the command does, for deduplication: the hex-encoded
+// SHA-256 of its canonical serialization. The identifiers of the command are not part of it, and
+// neither is the order of the fields that are sets, such as the inputs, the outputs or the
+// environment variables. The deprecated OutputFiles and OutputDirs are serialized as the
+// equivalent Outputs. InputSpec.EnvironmentPassthrough and InputSpec.EnvironmentAllowlist are
+// not serialized: they take effect on the environment variables in FillDefaultFieldValues, before
+// the ID is computed.
+//
+// The canonical serialization is the string "remote-apis-sdks/command/v" followed by
+// StableIDVersion in decimal, then the following fields in this order. Strings are encoded as
+// their length as a uvarint followed by their bytes, integers as varints, booleans as a byte,
+// lists as their length as a uvarint followed by their elements, and maps as lists of key-value
+// pairs sorted by key. Nil entries of InputSpec.VirtualInputs and InputSpec.InputExclusions are
+// skipped.
+//
+//   - Args, as a list.
+//   - ExecRoot, WorkingDir and RemoteWorkingDir.
+//   - The outputs, as a list of pairs of the name of their type, see OutputType.String, and their
+//     path, sorted.
+//   - Timeout, in nanoseconds.
+//   - Platform.
+//   - OutputNodeProperties, sorted.
+//   - The fields of InputSpec, which is taken as empty if nil:
+//   - InputSpec.Inputs, sorted.
+//   - InputSpec.VirtualInputs, sorted by path, each as its path, contents, digest, tree digest,
+//     executability, being an empty directory, mtime in Unix nanoseconds (0 if unset) and mode.
+//   - InputSpec.InputExclusions, sorted, each as its regular expression, which globs are translated
+//     to, and the name of its type.
+//   - InputSpec.EnvironmentVariables.
+//   - The name of InputSpec.SymlinkBehavior.
+//   - InputSpec.InputNodeProperties, as a map from paths to node properties. Node properties are
+//     encoded as a boolean telling whether they are set, then if they are, their properties as a
+//     list of name-value pairs sorted by name then value, then their mtime and their Unix mode,
+//     each as a boolean telling whether it is set followed, if it is, by its seconds and nanos or
+//     by the mode.
+func (c *Command) StableID() string {
+	e := &canonicalEncoder{}
+	e.buf = append(e.buf, "remote-apis-sdks/command/v"+strconv.Itoa(StableIDVersion)...)
+	e.strings(c.Args)
+	e.string(c.ExecRoot)
+	e.string(c.WorkingDir)
+	e.string(c.RemoteWorkingDir)
+
+	var outputs [][2]string
+	for _, f := range c.OutputFiles {
+		outputs = append(outputs, [2]string{FileOutputType.String(), f})
+	}
+	for _, d := range c.OutputDirs {
+		outputs = append(outputs, [2]string{DirectoryOutputType.String(), d})
+	}
+	for _, o := range c.Outputs {
+		outputs = append(outputs, [2]string{o.Type.String(), o.Path})
+	}
+	sort.Slice(outputs, func(i, j int) bool {
+		return outputs[i][1] < outputs[j][1] || outputs[i][1] == outputs[j][1] && outputs[i][0] < outputs[j][0]
+	})
+	e.uvarint(uint64(len(outputs)))
+	for _, o := range outputs {
+		e.string(o[0])
+		e.string(o[1])
+	}
+
+	e.varint(int64(c.Timeout))
+	e.stringMap(c.Platform)
+	e.sortedStrings(c.OutputNodeProperties)
+
+	is := c.InputSpec
+	if is == nil {
+		is = &InputSpec{}
+	}
+	e.sortedStrings(is.Inputs)
+
+	var vis []*VirtualInput
+	for _, vi := range is.VirtualInputs {
+		if vi != nil {
+			vis = append(vis, vi)
+		}
+	}
+	sort.SliceStable(vis, func(i, j int) bool { return vis[i].Path < vis[j].Path })
+	e.uvarint(uint64(len(vis)))
+	for _, vi := range vis {
+		e.string(vi.Path)
+		e.string(string(vi.Contents))
+		e.string(vi.Digest)
+		e.string(vi.TreeDigest)
+		e.bool(vi.IsExecutable)
+		e.bool(vi.IsEmptyDirectory)
+		var mtime int64
+		if !vi.Mtime.IsZero() {
+			mtime = vi.Mtime.UnixNano()
+		}
+		e.varint(mtime)
+		e.uvarint(uint64(vi.FileMode))
+	}
+
+	var excl []*InputExclusion
+	for _, ex := range is.InputExclusions {
+		if ex != nil {
+			excl = append(excl, ex)
+		}
+	}
+	sort.Slice(excl, func(i, j int) bool {
+		pi, pj := excl[i].Pattern(), excl[j].Pattern()
+		return pi < pj || pi == pj && excl[i].Type < excl[j].Type
+	})
+	e.uvarint(uint64(len(excl)))
+	for _, ex := range excl {
+		e.string(ex.Pattern())
+		e.string(ex.Type.String())
+	}
+
+	e.stringMap(is.EnvironmentVariables)
+	e.string(is.SymlinkBehavior.String())
+
+	paths := make([]string, 0, len(is.InputNodeProperties))
+	for p := range is.InputNodeProperties {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	e.uvarint(uint64(len(paths)))
+	for _, p := range paths {
+		e.string(p)
+		e.nodeProperties(is.InputNodeProperties[p])
+	}
+
+	sum := sha256.Sum256(e.buf)
+	return hex.EncodeToString(sum[:])
+}
+
+// canonicalEncoder builds the canonical serialization of a command, see StableID.
+type canonicalEncoder struct {
+	buf []byte
+}
+
+func (e *canonicalEncoder) uvarint(v uint64) {
+	e.buf = binary.AppendUvarint(e.buf, v)
+}
+
+func (e *canonicalEncoder) varint(v int64) {
+	e.buf = binary.AppendVarint(e.buf, v)
+}
+
+func (e *canonicalEncoder) bool(b bool) {
+	if b {
+		e.buf = append(e.buf, 1)
+	} else {
+		e.buf = append(e.buf, 0)
+	}
+}
+
+func (e *canonicalEncoder) string(s string) {
+	e.uvarint(uint64(len(s)))
+	e.buf = append(e.buf, s...)
+}
+
+func (e *canonicalEncoder) strings(s []string) {
+	e.uvarint(uint64(len(s)))
+	for _, v := range s {
+		e.string(v)
+	}
+}
+
+func (e *canonicalEncoder) sortedStrings(s []string) {
+	sorted := append([]string{}, s...)
+	sort.Strings(sorted)
+	e.strings(sorted)
+}
+
+func (e *canonicalEncoder) stringMap(m map[string]string) {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	e.uvarint(uint64(len(keys)))
+	for _, k := range keys {
+		e.string(k)
+		e.string(m[k])
+	}
+}
+
+func (e *canonicalEncoder) nodeProperties(np *cpb.NodeProperties) {
+	e.bool(np != nil)
+	if np == nil {
+		return
+	}
+	props := make([][2]string, 0, len(np.Properties))
+	for _, p := range np.Properties {
+		if p != nil {
+			props = append(props, [2]string{p.Name, p.Value})
+		}
+	}
+	sort.Slice(props, func(i, j int) bool {
+		return props[i][0] < props[j][0] || props[i][0] == props[j][0] && props[i][1] < props[j][1]
+	})
+	e.uvarint(uint64(len(props)))
+	for _, p := range props {
+		e.string(p[0])
+		e.string(p[1])
+	}
+	e.bool(np.Mtime != nil)
+	if np.Mtime != nil {
+		e.varint(np.Mtime.Seconds)
+		e.varint(int64(np.Mtime.Nanos))
+	}
+	e.bool(np.UnixMode != nil)
+	if np.UnixMode != nil {
+		e.uvarint(uint64(np.UnixMode.Value))
+	}
+}
@@ -4,7 +4,9 @@ package contextmd
 import (
 	"context"
 	"fmt"
+	"regexp"
 	"sort"
+	"strconv"
 	"strings"
 
 	log "github.com/golang/glog"
@@ -19,8 +21,22 @@ const (
 	// The headers key of our RequestMetadata.
 	remoteHeadersKey     = "build.bazel.remote.execution.v2.requestmetadata-bin"
 	defaultMaxHeaderSize = 8 * 1024
+
+	// The headers key of the quality of service class.
+	qosClassKey = "x-remote-qos-class"
+
+	// The headers keys of the invocation hierarchy, which RequestMetadata has no fields for.
+	parentInvocationIDKey = "x-remote-parent-invocation-id"
+	attemptKey            = "x-remote-attempt"
+	buildPhaseKey         = "x-remote-build-phase"
+
+	// The prefix of the headers keys of the labels, which RequestMetadata has no field for either.
+	labelKeyPrefix = "x-remote-label-"
 )
 
+// labelKeyRE matches the valid label keys, which are part of header keys.
+var labelKeyRE = regexp.MustCompile(`^[a-z0-9][a-z0-9_.-]*$`)
+
 // Metadata is optionally attached to RPC requests.
 type Metadata struct {
 	// ActionID is an optional id to use to identify an action.
@@ -33,6 +49,31 @@ type Metadata struct {
 	ToolName string
 	// ToolVersion is an optional tool version to pass to the remote server for logging.
 	ToolVersion string
+	// QoSClass is an optional quality of service class, such as "interactive" or "batch", that the
+	// remote server may use to prioritize requests.
+	QoSClass string
+	// ParentInvocationID is an optional id of the invocation this invocation is nested in, such as
+	// the build that started a sub-build.
+	ParentInvocationID string
+	// Attempt is an optional attempt number of the invocation, for invocations that are retried,
+	// such as CI builds. The first attempt is 1, and 0 means unknown.
+	Attempt int
+	// BuildPhase is an optional name of the phase of the build the request is made in, such as
+	// "analysis" or "test".
+	BuildPhase string
+	// Labels are optional key-value annotations, such as the target or the team, that server-side
+	// logs can slice requests by. Keys are lowercase letters, digits, '_', '.' and '-', starting with
+	// a letter or a digit.
+	Labels map[string]string
+}
+
+type qosClassCtxKey struct{}
+
+// WithQoSClass returns a context whose RPCs are tagged with the given quality of service class,
+// including those of contexts later derived from it with WithMetadata.
+func WithQoSClass(ctx context.Context, class string) context.Context {
+	ctx = context.WithValue(ctx, qosClassCtxKey{}, class)
+	return metadata.AppendToOutgoingContext(ctx, qosClassKey, class)
 }
 
 // Infof is equivalent to log.V(x).Infof(...) except it
@@ -54,22 +95,45 @@ func ExtractMetadata(ctx context.Context) (m *Metadata, err error) {
 	if !ok {
 		return &Metadata{}, nil
 	}
+	first := func(key string) string {
+		if vs := md.Get(key); len(vs) > 0 {
+			return vs[0]
+		}
+		return ""
+	}
+	m = &Metadata{
+		QoSClass:           first(qosClassKey),
+		ParentInvocationID: first(parentInvocationIDKey),
+		BuildPhase:         first(buildPhaseKey),
+	}
+	if a := first(attemptKey); a != "" {
+		if m.Attempt, err = strconv.Atoi(a); err != nil {
+			return nil, fmt.Errorf("invalid %s header %q: %w", attemptKey, a, err)
+		}
+	}
+	for k, vs := range md {
+		if key := strings.TrimPrefix(k, labelKeyPrefix); key != k && len(vs) > 0 {
+			if m.Labels == nil {
+				m.Labels = make(map[string]string)
+			}
+			m.Labels[key] = vs[0]
+		}
+	}
 	vs := md.Get(remoteHeadersKey)
 	if len(vs) == 0 {
-		return &Metadata{}, nil
+		return m, nil
 	}
 	buf := []byte(vs[0])
 	meta := &repb.RequestMetadata{}
 	if err := proto.Unmarshal(buf, meta); err != nil {
 		return nil, err
 	}
-	return &Metadata{
-		ToolName:               meta.ToolDetails.GetToolName(),
-		ToolVersion:            meta.ToolDetails.GetToolVersion(),
-		ActionID:               meta.ActionId,
-		InvocationID:           meta.ToolInvocationId,
-		CorrelatedInvocationID: meta.CorrelatedInvocationsId,
-	}, nil
+	m.ToolName = meta.ToolDetails.GetToolName()
+	m.ToolVersion = meta.ToolDetails.GetToolVersion()
+	m.ActionID = meta.ActionId
+	m.InvocationID = meta.ToolInvocationId
+	m.CorrelatedInvocationID = meta.CorrelatedInvocationsId
+	return m, nil
 }
 
 // WithMetadata attaches metadata to the passed-in context, returning a new
@@ -77,6 +141,11 @@ func ExtractMetadata(ctx context.Context) (m *Metadata, err error) {
 // the already created context to generate a new one containing the metadata header.
 func WithMetadata(ctx context.Context, ms ...*Metadata) (context.Context, error) {
 	m := MergeMetadata(ms...)
+	for key := range m.Labels {
+		if !labelKeyRE.MatchString(key) {
+			return nil, fmt.Errorf("invalid label key %q: keys must match %s", key, labelKeyRE)
+		}
+	}
 	actionID := m.ActionID
 	if actionID == "" {
 		actionID = uuid.New()
@@ -89,8 +158,9 @@ func WithMetadata(ctx context.Context, ms ...*Metadata) (context.Context, error)
 	}
 
 	meta := &repb.RequestMetadata{
-		ActionId:         actionID,
-		ToolInvocationId: invocationID,
+		ActionId:                actionID,
+		ToolInvocationId:        invocationID,
+		CorrelatedInvocationsId: m.CorrelatedInvocationID,
 		ToolDetails: &repb.ToolDetails{
 			ToolName:    m.ToolName,
 			ToolVersion: m.ToolVersion,
@@ -106,6 +176,25 @@ func WithMetadata(ctx context.Context, ms ...*Metadata) (context.Context, error)
 	// metadata package converts the binary buffer to a base64 string, so no need to encode before
 	// sending.
 	mdPair := metadata.Pairs(remoteHeadersKey, string(buf))
+	qosClass := m.QoSClass
+	if qosClass == "" {
+		qosClass, _ = ctx.Value(qosClassCtxKey{}).(string)
+	}
+	if qosClass != "" {
+		mdPair.Set(qosClassKey, qosClass)
+	}
+	if m.ParentInvocationID != "" {
+		mdPair.Set(parentInvocationIDKey, m.ParentInvocationID)
+	}
+	if m.Attempt != 0 {
+		mdPair.Set(attemptKey, strconv.Itoa(m.Attempt))
+	}
+	if m.BuildPhase != "" {
+		mdPair.Set(buildPhaseKey, m.BuildPhase)
+	}
+	for key, val := range m.Labels {
+		mdPair.Set(labelKeyPrefix+key, val)
+	}
 	return metadata.NewOutgoingContext(ctx, mdPair), nil
 }
 
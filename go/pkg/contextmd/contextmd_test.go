@@ -1,6 +1,8 @@
 package contextmd
 
 import (
+	"context"
+	"reflect"
 	"testing"
 )
 
@@ -102,9 +104,74 @@ func TestCapToLimit(t *testing.T) {
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
 			capToLimit(tc.input, tc.limit)
-			if *tc.input != *tc.want {
+			if !reflect.DeepEqual(tc.input, tc.want) {
 				t.Errorf("Got %+v, want %+v", tc.input, tc.want)
 			}
 		})
 	}
 }
+
+func TestQoSClass(t *testing.T) {
+	ctx := WithQoSClass(context.Background(), "interactive")
+	m, err := ExtractMetadata(ctx)
+	if err != nil {
+		t.Fatalf("ExtractMetadata() failed: %v", err)
+	}
+	if m.QoSClass != "interactive" {
+		t.Errorf("ExtractMetadata() gave QoS class %q, want interactive", m.QoSClass)
+	}
+
+	// WithMetadata replaces the outgoing metadata, but keeps the QoS class of the context.
+	ctx, err = WithMetadata(ctx, &Metadata{ToolName: "tool", ActionID: "action", InvocationID: "invocation"})
+	if err != nil {
+		t.Fatalf("WithMetadata() failed: %v", err)
+	}
+	m, err = ExtractMetadata(ctx)
+	if err != nil {
+		t.Fatalf("ExtractMetadata() failed: %v", err)
+	}
+	if m.QoSClass != "interactive" || m.InvocationID != "invocation" {
+		t.Errorf("ExtractMetadata() gave QoS class %q and invocation ID %q, want interactive and invocation", m.QoSClass, m.InvocationID)
+	}
+
+	ctx, err = WithMetadata(ctx, &Metadata{ToolName: "tool", QoSClass: "batch"})
+	if err != nil {
+		t.Fatalf("WithMetadata() failed: %v", err)
+	}
+	if m, err = ExtractMetadata(ctx); err != nil || m.QoSClass != "batch" {
+		t.Errorf("ExtractMetadata() gave QoS class %q, %v, want batch", m.QoSClass, err)
+	}
+}
+
+func TestInvocationHierarchy(t *testing.T) {
+	want := &Metadata{
+		ActionID:               "action",
+		InvocationID:           "invocation",
+		CorrelatedInvocationID: "ci",
+		ToolName:               "tool",
+		ParentInvocationID:     "parent",
+		Attempt:                3,
+		BuildPhase:             "test",
+		Labels:                 map[string]string{"target": "//foo:bar", "team": "infra"},
+	}
+	m := *want
+	ctx, err := WithMetadata(context.Background(), &m)
+	if err != nil {
+		t.Fatalf("WithMetadata() failed: %v", err)
+	}
+	got, err := ExtractMetadata(ctx)
+	if err != nil {
+		t.Fatalf("ExtractMetadata() failed: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ExtractMetadata() = %+v, want %+v", got, want)
+	}
+}
+
+func TestInvalidLabelKey(t *testing.T) {
+	for _, key := range []string{"", "Target", "team name", "-x"} {
+		if _, err := WithMetadata(context.Background(), &Metadata{Labels: map[string]string{key: "v"}}); err == nil {
+			t.Errorf("WithMetadata() with label key %q succeeded, want an error", key)
+		}
+	}
+}
@@ -3,15 +3,20 @@ package digest
 
 import (
 	"crypto"
-	"encoding/hex"
+	// Register the hashes of the supported digest functions.
+	_ "crypto/md5"
+	_ "crypto/sha1"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
 	"fmt"
 	"io"
-	"os"
 	"regexp"
+	"runtime"
 	"strconv"
 	"strings"
 	"sync"
 
+	"golang.org/x/sync/errgroup"
 	"google.golang.org/protobuf/proto"
 
 	repb "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
@@ -21,10 +26,20 @@ var (
 	// hexStringRegex doesn't contain the size because that's checked separately.
 	hexStringRegex = regexp.MustCompile("^[a-f0-9]+$")
 
-	// HashFn is the digest function used.
+	// sizeRegex matches the sizes of digest strings: decimal, without a sign or spaces.
+	sizeRegex = regexp.MustCompile("^[0-9]+$")
+
+	// HashFn is the default digest function, used by the package-level functions. Clients hash with
+	// their own Function, see NewFunction.
 	HashFn crypto.Hash = crypto.SHA256
 
-	// Empty is the digest of the empty blob.
+	// MmapThreshold is the size in bytes from which NewFromFile digests files by memory-mapping them
+	// rather than reading them, which saves syscalls for large files. Files are only mapped on
+	// Linux, and are read if they cannot be mapped. 0 disables mapping. A mapped file must not be
+	// truncated while it is digested.
+	MmapThreshold int64
+
+	// Empty is the digest of the empty blob with the default digest function.
 	Empty = NewFromBlob([]byte{})
 
 	// copyBufs is a pool of 32KiB []byte slices, used to compute hashes.
@@ -42,7 +57,7 @@ type Digest struct {
 	Size int64
 }
 
-// GetDigestFunction returns the digest function used by the client.
+// GetDigestFunction returns the default digest function, that of HashFn.
 func GetDigestFunction() repb.DigestFunction_Value {
 	name := strings.ReplaceAll(HashFn.String(), "-", "")
 	if val, ok := repb.DigestFunction_Value_value[name]; ok {
@@ -61,20 +76,22 @@ func (d Digest) String() string {
 	return fmt.Sprintf("%s/%d", d.Hash, d.Size)
 }
 
-// IsEmpty returns true iff digest is of an empty blob.
+// IsEmpty returns true iff digest is of an empty blob, with any supported digest function.
 func (d Digest) IsEmpty() bool {
-	return d.Size == 0 && d.Hash == Empty.Hash
+	return d.Size == 0 && (d.Hash == Empty.Hash || emptyHashes[d.Hash])
 }
 
 // Validate returns nil if a digest appears to be valid, or a descriptive error
 // if it is not. All functions accepting digests directly from clients should
 // call this function, whether it's via an RPC call or by reading a serialized
 // proto message that contains digests that was uploaded directly from the
-// client.
+// client. Digests do not record their digest function, so the hash may have the
+// length of any supported digest function; see Function.Validate to check it
+// against one.
 func (d Digest) Validate() error {
 	length := len(d.Hash)
-	if length != HashFn.Size()*2 {
-		return fmt.Errorf("valid hash length is %d, got length %d (%s)", HashFn.Size()*2, length, d.Hash)
+	if length != HashFn.Size()*2 && !hashLengths[length] {
+		return fmt.Errorf("hash length %d is not that of a supported digest function (%s)", length, d.Hash)
 	}
 	if !hexStringRegex.MatchString(d.Hash) {
 		return fmt.Errorf("hash is not a lowercase hex string (%s)", d.Hash)
@@ -101,20 +118,13 @@ func New(hash string, size int64) (Digest, error) {
 // invalidations (execution cache and potentially others).
 // This cannot return an error, since the result is valid by definition.
 func NewFromBlob(blob []byte) Digest {
-	h := HashFn.New()
-	h.Write(blob)
-	arr := h.Sum(nil)
-	return Digest{Hash: hex.EncodeToString(arr[:]), Size: int64(len(blob))}
+	return Function{}.NewFromBlob(blob)
 }
 
 // NewFromMessage calculates the digest of a protobuf in SHA-256 mode.
 // It returns an error if the proto marshalling failed.
 func NewFromMessage(msg proto.Message) (Digest, error) {
-	blob, err := proto.Marshal(msg)
-	if err != nil {
-		return Empty, err
-	}
-	return NewFromBlob(blob), nil
+	return Function{}.NewFromMessage(msg)
 }
 
 // NewFromProto converts a proto digest to a Digest.
@@ -137,68 +147,68 @@ func NewFromProtoUnvalidated(dg *repb.Digest) Digest {
 func NewFromString(s string) (Digest, error) {
 	pair := strings.Split(s, "/")
 	if len(pair) != 2 {
-		return Empty, fmt.Errorf("expected digest in the form hash/size, got %s", s)
+		return Empty, fmt.Errorf("expected digest in the form hash/size, got %q", s)
+	}
+	if !sizeRegex.MatchString(pair[1]) {
+		return Empty, fmt.Errorf("invalid size in digest %q: expected a non-negative decimal integer", s)
 	}
 	size, err := strconv.ParseInt(pair[1], 10, 64)
 	if err != nil {
-		return Empty, fmt.Errorf("invalid size in digest %s: %s", s, err)
+		return Empty, fmt.Errorf("invalid size in digest %q: %v", s, err)
+	}
+	dg, err := New(pair[0], size)
+	if err != nil {
+		return Empty, fmt.Errorf("invalid digest %q: %v", s, err)
 	}
-	return New(pair[0], size)
+	return dg, nil
 }
 
 // NewFromFile computes a file digest from a path.
 // It returns an error if there was a problem accessing the file.
 func NewFromFile(path string) (Digest, error) {
-	f, err := os.Open(path)
-	if err != nil {
-		return Empty, err
+	return Function{}.NewFromFile(path)
+}
+
+// ComputeAll computes the digests of files in parallel, with at most concurrency files read at a
+// time, or runtime.NumCPU() if concurrency is not positive. It returns the digests by path, or the
+// first error encountered.
+func ComputeAll(paths []string, concurrency int) (map[string]Digest, error) {
+	if concurrency <= 0 {
+		concurrency = runtime.NumCPU()
+	}
+	var mu sync.Mutex
+	res := make(map[string]Digest, len(paths))
+	var eg errgroup.Group
+	eg.SetLimit(concurrency)
+	for _, p := range paths {
+		p := p
+		eg.Go(func() error {
+			dg, err := NewFromFile(p)
+			if err != nil {
+				return fmt.Errorf("failed to digest %s: %w", p, err)
+			}
+			mu.Lock()
+			res[p] = dg
+			mu.Unlock()
+			return nil
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return nil, err
 	}
-	defer f.Close()
-	return NewFromReader(f)
+	return res, nil
 }
 
 // NewFromReader computes a file digest from a reader.
 // It returns an error if there was a problem reading the file.
 func NewFromReader(r io.Reader) (Digest, error) {
-	h := HashFn.New()
-	buf := copyBufs.Get().(*[]byte)
-	defer copyBufs.Put(buf)
-	size, err := io.CopyBuffer(h, r, *buf)
-	if err != nil {
-		return Empty, err
-	}
-	return Digest{
-		Hash: hex.EncodeToString(h.Sum(nil)),
-		Size: size,
-	}, nil
+	return Function{}.NewFromReader(r)
 }
 
-// CheckCapabilities returns an error if the digest function is not supported
-// by the server.
+// CheckCapabilities returns an error if the default digest function is not supported by the
+// server.
 func CheckCapabilities(caps *repb.ServerCapabilities) error {
-	fn := GetDigestFunction()
-
-	if caps.ExecutionCapabilities != nil {
-		if serverFn := caps.ExecutionCapabilities.DigestFunction; serverFn != fn {
-			return fmt.Errorf("server requires %v, client uses %v", serverFn, fn)
-		}
-	}
-
-	if caps.CacheCapabilities != nil {
-		cc := caps.CacheCapabilities
-		found := false
-		for _, serverFn := range cc.DigestFunctions {
-			if serverFn == fn {
-				found = true
-				break
-			}
-		}
-		if !found {
-			return fmt.Errorf("server requires one of %v, client uses %v", cc.DigestFunctions, fn)
-		}
-	}
-
-	return nil
+	return Function{}.CheckCapabilities(caps)
 }
 
 // TestNew is like New but also pads your hash with zeros if it is shorter than the required length,
@@ -217,6 +227,16 @@ func TestNew(hash string, size int64) Digest {
 	return digest
 }
 
+// TestNewFromString is like NewFromString but panics on error.
+// ONLY USE FOR TESTS.
+func TestNewFromString(s string) Digest {
+	digest, err := NewFromString(s)
+	if err != nil {
+		panic(err.Error())
+	}
+	return digest
+}
+
 // TestNewFromMessage is only suitable for testing and panics on error.
 // ONLY USE FOR TESTS.
 func TestNewFromMessage(msg proto.Message) Digest {
@@ -8,6 +8,8 @@ import (
 	"testing"
 
 	"google.golang.org/protobuf/proto"
+
+	repb "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
 )
 
 var (
@@ -213,6 +215,26 @@ func TestNewFromFile(t *testing.T) {
 	}
 }
 
+func TestNewFromFileMmap(t *testing.T) {
+	defer func(old int64) { MmapThreshold = old }(MmapThreshold)
+	MmapThreshold = 1024
+	dir := t.TempDir()
+	for _, size := range []int{0, 1023, 1024, 100000} {
+		path := filepath.Join(dir, fmt.Sprintf("f%d", size))
+		blob := []byte(strings.Repeat("a", size))
+		if err := os.WriteFile(path, blob, 0644); err != nil {
+			t.Fatalf("os.WriteFile(%v, _, _) = %v, want nil", path, err)
+		}
+		got, err := NewFromFile(path)
+		if err != nil {
+			t.Fatalf("NewFromFile(%v) = (_, %v), want (_, nil)", path, err)
+		}
+		if want := NewFromBlob(blob); got != want {
+			t.Errorf("NewFromFile(%v) = (%v, _), want (%v, _)", path, got, want)
+		}
+	}
+}
+
 func TestString(t *testing.T) {
 	t.Parallel()
 	if sGot := dSHA256.String(); sGot != sGood {
@@ -223,7 +245,39 @@ func TestString(t *testing.T) {
 	}
 }
 
-func TestNewFromString(t *testing.T) {
+func TestComputeAll(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	want := make(map[string]Digest)
+	var paths []string
+	for i := 0; i < 20; i++ {
+		path := filepath.Join(dir, fmt.Sprintf("f%d", i))
+		blob := []byte(strings.Repeat("x", i))
+		if err := os.WriteFile(path, blob, 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", path, err)
+		}
+		paths = append(paths, path)
+		want[path] = NewFromBlob(blob)
+	}
+	got, err := ComputeAll(paths, 4)
+	if err != nil {
+		t.Fatalf("ComputeAll() failed: %v", err)
+	}
+	if len(got) != len(want) {
+		t.Errorf("ComputeAll() returned %d digests, want %d", len(got), len(want))
+	}
+	for p, dg := range want {
+		if got[p] != dg {
+			t.Errorf("ComputeAll()[%s] = %v, want %v", p, got[p], dg)
+		}
+	}
+
+	if _, err := ComputeAll(append(paths, filepath.Join(dir, "missing")), 0); err == nil {
+		t.Errorf("ComputeAll() with a missing file succeeded, want error")
+	}
+}
+
+func Test_NewFromString(t *testing.T) {
 	t.Parallel()
 	if dGot, err := NewFromString(sGood); err != nil || dGot != dSHA256 {
 		t.Errorf("FromString(%s) = (%v, %v), want (%v, nil)", sGood, dGot, err, dSHA256)
@@ -240,4 +294,162 @@ func TestNewFromString(t *testing.T) {
 	if _, err := NewFromString(sInvalid3); err == nil {
 		t.Errorf("FromString(%s) = (_, nil), want (_, error)", sInvalid3)
 	}
+	for _, s := range []string{
+		dSHA256.Hash + "/+321",
+		dSHA256.Hash + "/ 321",
+		dSHA256.Hash + "/0x10",
+		dSHA256.Hash + "/",
+		strings.ToUpper(dSHA256.Hash) + "/321",
+		dSHA256.Hash[1:] + "/321",
+		"/321",
+		"",
+	} {
+		if _, err := NewFromString(s); err == nil {
+			t.Errorf("FromString(%q) = (_, nil), want (_, error)", s)
+		}
+	}
+}
+
+func TestTestNewFromString(t *testing.T) {
+	t.Parallel()
+	if got := TestNewFromString(sGood); got != dSHA256 {
+		t.Errorf("TestNewFromString(%s) = %v, want %v", sGood, got, dSHA256)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Errorf("TestNewFromString(%s) did not panic", sInvalid1)
+		}
+	}()
+	TestNewFromString(sInvalid1)
+}
+
+func TestNegotiateDigestFunction(t *testing.T) {
+	tests := []struct {
+		name    string
+		caps    *repb.ServerCapabilities
+		want    repb.DigestFunction_Value
+		wantErr bool
+	}{
+		{
+			name: "no capabilities",
+			caps: &repb.ServerCapabilities{},
+			want: repb.DigestFunction_SHA256,
+		},
+		{
+			name: "execution",
+			caps: &repb.ServerCapabilities{
+				ExecutionCapabilities: &repb.ExecutionCapabilities{DigestFunction: repb.DigestFunction_SHA512},
+				CacheCapabilities:     &repb.CacheCapabilities{DigestFunctions: []repb.DigestFunction_Value{repb.DigestFunction_SHA256, repb.DigestFunction_SHA512}},
+			},
+			want: repb.DigestFunction_SHA512,
+		},
+		{
+			name: "cache with the current function",
+			caps: &repb.ServerCapabilities{
+				CacheCapabilities: &repb.CacheCapabilities{DigestFunctions: []repb.DigestFunction_Value{repb.DigestFunction_SHA1, repb.DigestFunction_SHA256}},
+			},
+			want: repb.DigestFunction_SHA256,
+		},
+		{
+			name: "cache without the current function",
+			caps: &repb.ServerCapabilities{
+				CacheCapabilities: &repb.CacheCapabilities{DigestFunctions: []repb.DigestFunction_Value{repb.DigestFunction_VSO, repb.DigestFunction_SHA1}},
+			},
+			want: repb.DigestFunction_SHA1,
+		},
+		{
+			name: "unsupported execution",
+			caps: &repb.ServerCapabilities{
+				ExecutionCapabilities: &repb.ExecutionCapabilities{DigestFunction: repb.DigestFunction_MURMUR3},
+			},
+			wantErr: true,
+		},
+		{
+			name: "unsupported cache",
+			caps: &repb.ServerCapabilities{
+				CacheCapabilities: &repb.CacheCapabilities{DigestFunctions: []repb.DigestFunction_Value{repb.DigestFunction_VSO}},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := Function{}.Negotiate(tc.caps)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("Negotiate() error = %v, want error %v", err, tc.wantErr)
+			}
+			if err == nil && got.Value() != tc.want {
+				t.Errorf("Negotiate() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestFunction(t *testing.T) {
+	sha1, err := NewFunction(repb.DigestFunction_SHA1)
+	if err != nil {
+		t.Fatalf("NewFunction(SHA1) = %v, want nil", err)
+	}
+	blob := []byte("foo")
+	dg := sha1.NewFromBlob(blob)
+	if want := "0beec7b5ea3f0fdbc95d0dd47f3c5bc275da8a33"; dg.Hash != want {
+		t.Errorf("NewFromBlob(%q) with SHA1 = %s, want %s", blob, dg.Hash, want)
+	}
+	if err := sha1.Validate(dg); err != nil {
+		t.Errorf("Validate(%v) with SHA1 = %v, want nil", dg, err)
+	}
+	if err := sha1.Validate(NewFromBlob(blob)); err == nil {
+		t.Errorf("Validate() of a SHA-256 digest with SHA1 = nil, want error")
+	}
+	if err := dg.Validate(); err != nil {
+		t.Errorf("Validate() of a SHA-1 digest = %v, want nil", err)
+	}
+	if empty := sha1.Empty(); !empty.IsEmpty() || empty == Empty {
+		t.Errorf("Empty() with SHA1 = %v, want an empty SHA-1 digest", empty)
+	}
+
+	path := filepath.Join(t.TempDir(), "foo")
+	if err := os.WriteFile(path, blob, 0644); err != nil {
+		t.Fatalf("os.WriteFile(%s) failed: %v", path, err)
+	}
+	got, err := sha1.NewFromFile(path)
+	if err != nil {
+		t.Fatalf("NewFromFile(%s) with SHA1 failed: %v", path, err)
+	}
+	if got != dg {
+		t.Errorf("NewFromFile(%s) with SHA1 = %v, want %v", path, got, dg)
+	}
+}
+
+func TestResourceNameSegment(t *testing.T) {
+	tests := []struct {
+		fn   repb.DigestFunction_Value
+		want string
+	}{
+		{fn: repb.DigestFunction_UNKNOWN, want: ""},
+		{fn: repb.DigestFunction_SHA256, want: ""},
+		{fn: repb.DigestFunction_SHA1, want: ""},
+		{fn: repb.DigestFunction_SHA256TREE, want: "sha256tree"},
+	}
+	for _, tc := range tests {
+		f, err := NewFunction(tc.fn)
+		if err != nil {
+			t.Fatalf("NewFunction(%v) = %v, want nil", tc.fn, err)
+		}
+		got := f.ResourceNameSegment()
+		if got != tc.want {
+			t.Errorf("ResourceNameSegment() with %v = %q, want %q", tc.fn, got, tc.want)
+		}
+		if got == "" {
+			continue
+		}
+		if parsed, ok := FunctionFromResourceNameSegment(got); !ok || parsed != f {
+			t.Errorf("FunctionFromResourceNameSegment(%q) = %v, %t, want %v, true", got, parsed, ok, f)
+		}
+	}
+	for _, seg := range []string{"sha256", "SHA256TREE", "blobs", Empty.Hash} {
+		if f, ok := FunctionFromResourceNameSegment(seg); ok {
+			t.Errorf("FunctionFromResourceNameSegment(%q) = %v, true, want false", seg, f)
+		}
+	}
 }
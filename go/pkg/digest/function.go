@@ -0,0 +1,268 @@
+package digest
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+
+	repb "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+)
+
+// Function is a digest function. Digests do not record the function that computed them, so each
+// client hashes everything with a single Function of its own. The zero Function is the default
+// digest function, that of HashFn, which the package-level functions such as NewFromBlob use.
+type Function struct {
+	value repb.DigestFunction_Value
+}
+
+// digestFunctions are the digest functions that can be used, other than SHA256TREE.
+var digestFunctions = map[repb.DigestFunction_Value]crypto.Hash{
+	repb.DigestFunction_SHA256: crypto.SHA256,
+	repb.DigestFunction_SHA1:   crypto.SHA1,
+	repb.DigestFunction_MD5:    crypto.MD5,
+	repb.DigestFunction_SHA384: crypto.SHA384,
+	repb.DigestFunction_SHA512: crypto.SHA512,
+}
+
+var (
+	// emptyHashes are the hashes of the empty blob with every supported digest function.
+	emptyHashes = map[string]bool{}
+	// hashLengths are the lengths of the hex hashes of every supported digest function.
+	hashLengths = map[int]bool{}
+)
+
+func init() {
+	for fn := range digestFunctions {
+		if supported(fn) {
+			f := Function{value: fn}
+			emptyHashes[f.NewFromBlob(nil).Hash] = true
+			hashLengths[f.size()*2] = true
+		}
+	}
+}
+
+// supported returns whether the digest function can be used.
+func supported(fn repb.DigestFunction_Value) bool {
+	if fn == repb.DigestFunction_SHA256TREE {
+		return true
+	}
+	h, ok := digestFunctions[fn]
+	return ok && h.Available()
+}
+
+// NewFunction returns the digest function fn, or an error if it is not supported. Besides the
+// functions of HashFn, it supports SHA256TREE, which digests large blobs as a Merkle tree of
+// chunks, so that servers can store and verify them by chunk. UNKNOWN is the default digest
+// function.
+//
+// SHA256TREE blobs are still transferred whole: the SplitBlob and SpliceBlob RPCs, which transfer
+// only the chunks missing on either side, are not in the version of the remote APIs this module
+// depends on, and are out of scope until it is updated.
+func NewFunction(fn repb.DigestFunction_Value) (Function, error) {
+	if fn == repb.DigestFunction_UNKNOWN {
+		return Function{}, nil
+	}
+	if !supported(fn) {
+		return Function{}, fmt.Errorf("unsupported digest function %v", fn)
+	}
+	return Function{value: fn}, nil
+}
+
+// Value returns the digest function as a proto enum value.
+func (f Function) Value() repb.DigestFunction_Value {
+	if f.value == repb.DigestFunction_UNKNOWN {
+		return GetDigestFunction()
+	}
+	return f.value
+}
+
+// String returns the name of the digest function.
+func (f Function) String() string {
+	return f.Value().String()
+}
+
+// newHash returns a new hash.Hash computing the digest function.
+func (f Function) newHash() hash.Hash {
+	switch f.value {
+	case repb.DigestFunction_UNKNOWN:
+		return HashFn.New()
+	case repb.DigestFunction_SHA256TREE:
+		return NewSHA256Tree()
+	default:
+		return digestFunctions[f.value].New()
+	}
+}
+
+// size returns the size in bytes of the hashes of the digest function.
+func (f Function) size() int {
+	switch f.value {
+	case repb.DigestFunction_UNKNOWN:
+		return HashFn.Size()
+	case repb.DigestFunction_SHA256TREE:
+		return sha256.Size
+	default:
+		return digestFunctions[f.value].Size()
+	}
+}
+
+// legacyFunctions are the digest functions that servers infer from the length of the hashes, and
+// which are therefore never named in ByteStream resource names.
+var legacyFunctions = map[repb.DigestFunction_Value]bool{
+	repb.DigestFunction_SHA256:  true,
+	repb.DigestFunction_SHA1:    true,
+	repb.DigestFunction_MD5:     true,
+	repb.DigestFunction_VSO:     true,
+	repb.DigestFunction_SHA384:  true,
+	repb.DigestFunction_SHA512:  true,
+	repb.DigestFunction_MURMUR3: true,
+}
+
+// ResourceNameSegment returns the segment naming the digest function in ByteStream resource names,
+// which precedes the hash, or "" if the digest function must not be named, as for SHA256.
+func (f Function) ResourceNameSegment() string {
+	fn := f.Value()
+	if legacyFunctions[fn] {
+		return ""
+	}
+	return strings.ToLower(fn.String())
+}
+
+// FunctionFromResourceNameSegment returns the digest function named by a segment of a ByteStream
+// resource name, and false if the segment does not name a supported digest function.
+func FunctionFromResourceNameSegment(seg string) (Function, bool) {
+	if seg != strings.ToLower(seg) {
+		return Function{}, false
+	}
+	fn, ok := repb.DigestFunction_Value_value[strings.ToUpper(seg)]
+	if !ok || legacyFunctions[repb.DigestFunction_Value(fn)] || !supported(repb.DigestFunction_Value(fn)) {
+		return Function{}, false
+	}
+	return Function{value: repb.DigestFunction_Value(fn)}, true
+}
+
+// Empty returns the digest of the empty blob.
+func (f Function) Empty() Digest {
+	if f.value == repb.DigestFunction_UNKNOWN {
+		return Empty
+	}
+	return f.NewFromBlob(nil)
+}
+
+// Validate is like Digest.Validate, but also checks that the hash has the length of the hashes of
+// the digest function.
+func (f Function) Validate(d Digest) error {
+	if len(d.Hash) != f.size()*2 {
+		return fmt.Errorf("valid hash length for %v is %d, got length %d (%s)", f, f.size()*2, len(d.Hash), d.Hash)
+	}
+	return d.Validate()
+}
+
+// NewFromBlob returns the digest of the blob.
+func (f Function) NewFromBlob(blob []byte) Digest {
+	h := f.newHash()
+	h.Write(blob)
+	return Digest{Hash: hex.EncodeToString(h.Sum(nil)), Size: int64(len(blob))}
+}
+
+// NewFromMessage returns the digest of the wire format of the proto message.
+func (f Function) NewFromMessage(msg proto.Message) (Digest, error) {
+	blob, err := proto.Marshal(msg)
+	if err != nil {
+		return Empty, err
+	}
+	return f.NewFromBlob(blob), nil
+}
+
+// NewFromFile returns the digest of the file at path. Files from MmapThreshold bytes are
+// memory-mapped rather than read.
+func (f Function) NewFromFile(path string) (Digest, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return Empty, err
+	}
+	defer file.Close()
+	if MmapThreshold > 0 {
+		if info, err := file.Stat(); err == nil && info.Mode().IsRegular() && info.Size() >= MmapThreshold {
+			if dg, ok := newFromMmap(f.NewWriter(), file, info.Size()); ok {
+				return dg, nil
+			}
+		}
+	}
+	return f.NewFromReader(file)
+}
+
+// NewFromReader returns the digest of the data read from r.
+func (f Function) NewFromReader(r io.Reader) (Digest, error) {
+	w := f.NewWriter()
+	buf := copyBufs.Get().(*[]byte)
+	defer copyBufs.Put(buf)
+	if _, err := io.CopyBuffer(w, r, *buf); err != nil {
+		return Empty, err
+	}
+	return w.Digest(), nil
+}
+
+// NewWriter returns a Writer computing digests with the digest function.
+func (f Function) NewWriter() *Writer {
+	return &Writer{h: f.newHash()}
+}
+
+// NewTeeReader returns a TeeReader reading from r, computing the digest with the digest function.
+func (f Function) NewTeeReader(r io.Reader) *TeeReader {
+	return &TeeReader{r: r, w: f.NewWriter()}
+}
+
+// CheckCapabilities returns an error if the digest function is not supported by the server.
+func (f Function) CheckCapabilities(caps *repb.ServerCapabilities) error {
+	fn := f.Value()
+	if caps.ExecutionCapabilities != nil {
+		if serverFn := caps.ExecutionCapabilities.DigestFunction; serverFn != fn {
+			return fmt.Errorf("server requires %v, client uses %v", serverFn, fn)
+		}
+	}
+	if cc := caps.CacheCapabilities; cc != nil {
+		for _, serverFn := range cc.DigestFunctions {
+			if serverFn == fn {
+				return nil
+			}
+		}
+		return fmt.Errorf("server requires one of %v, client uses %v", cc.DigestFunctions, fn)
+	}
+	return nil
+}
+
+// Negotiate returns the digest function to use with a server: the one its execution capabilities
+// require, if any, or else f if the cache supports it, or else the first function supported by
+// both the cache and the client. It returns an error if there is no such function.
+func (f Function) Negotiate(caps *repb.ServerCapabilities) (Function, error) {
+	if ec := caps.GetExecutionCapabilities(); ec != nil {
+		fn := ec.GetDigestFunction()
+		if !supported(fn) {
+			return f, fmt.Errorf("server requires unsupported digest function %v", fn)
+		}
+		return Function{value: fn}, nil
+	}
+	cc := caps.GetCacheCapabilities()
+	if cc == nil {
+		return f, nil
+	}
+	cur := f.Value()
+	for _, fn := range cc.GetDigestFunctions() {
+		if fn == cur {
+			return f, nil
+		}
+	}
+	for _, fn := range cc.GetDigestFunctions() {
+		if supported(fn) {
+			return Function{value: fn}, nil
+		}
+	}
+	return f, fmt.Errorf("server requires one of unsupported digest functions %v", cc.GetDigestFunctions())
+}
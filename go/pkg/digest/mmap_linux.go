@@ -0,0 +1,24 @@
+//go:build linux
+// +build linux
+
+package digest
+
+import (
+	"os"
+	"syscall"
+)
+
+// newFromMmap computes the digest of the size bytes of f with w by memory-mapping it. It returns
+// false if f cannot be mapped, for the caller to fall back to reading it.
+func newFromMmap(w *Writer, f *os.File, size int64) (Digest, bool) {
+	if int64(int(size)) != size {
+		return Empty, false
+	}
+	data, err := syscall.Mmap(int(f.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return Empty, false
+	}
+	defer syscall.Munmap(data)
+	w.Write(data)
+	return w.Digest(), true
+}
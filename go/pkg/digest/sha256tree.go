@@ -0,0 +1,133 @@
+package digest
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"hash"
+	"math/bits"
+)
+
+// sha256TreeChunkSize is the size of the leaves of the SHA256TREE Merkle tree. Blobs up to this
+// size are hashed with plain SHA-256.
+const sha256TreeChunkSize = 1024
+
+// sha256TreeIV is the initial hash value of the SHA-256 block cipher invocations combining two
+// subtree hashes: the fractional parts of the square roots of the 9th to the 16th primes.
+var sha256TreeIV = [8]uint32{
+	0xcbbb9d5d, 0x629a292a, 0x9159015a, 0x152fecd8, 0x67332667, 0x8eb44a87, 0xdb0c2e0d, 0x47b5481d,
+}
+
+var sha256K = [64]uint32{
+	0x428a2f98, 0x71374491, 0xb5c0fbcf, 0xe9b5dba5, 0x3956c25b, 0x59f111f1, 0x923f82a4, 0xab1c5ed5,
+	0xd807aa98, 0x12835b01, 0x243185be, 0x550c7dc3, 0x72be5d74, 0x80deb1fe, 0x9bdc06a7, 0xc19bf174,
+	0xe49b69c1, 0xefbe4786, 0x0fc19dc6, 0x240ca1cc, 0x2de92c6f, 0x4a7484aa, 0x5cb0a9dc, 0x76f988da,
+	0x983e5152, 0xa831c66d, 0xb00327c8, 0xbf597fc7, 0xc6e00bf3, 0xd5a79147, 0x06ca6351, 0x14292967,
+	0x27b70a85, 0x2e1b2138, 0x4d2c6dfc, 0x53380d13, 0x650a7354, 0x766a0abb, 0x81c2c92e, 0x92722c85,
+	0xa2bfe8a1, 0xa81a664b, 0xc24b8b70, 0xc76c51a3, 0xd192e819, 0xd6990624, 0xf40e3585, 0x106aa070,
+	0x19a4c116, 0x1e376c08, 0x2748774c, 0x34b0bcb5, 0x391c0cb3, 0x4ed8aa4a, 0x5b9cca4f, 0x682e6ff3,
+	0x748f82ee, 0x78a5636f, 0x84c87814, 0x8cc70208, 0x90befffa, 0xa4506ceb, 0xbef9a3f7, 0xc67178f2,
+}
+
+// sha256TreeParent returns the hash of a blob whose left and right parts have the given hashes:
+// a single invocation of the SHA-256 block cipher on their concatenation, without the final
+// addition of the initial hash value.
+func sha256TreeParent(left, right [sha256.Size]byte) [sha256.Size]byte {
+	var w [64]uint32
+	for i := 0; i < 8; i++ {
+		w[i] = binary.BigEndian.Uint32(left[4*i:])
+		w[i+8] = binary.BigEndian.Uint32(right[4*i:])
+	}
+	for i := 16; i < 64; i++ {
+		s0 := bits.RotateLeft32(w[i-15], -7) ^ bits.RotateLeft32(w[i-15], -18) ^ (w[i-15] >> 3)
+		s1 := bits.RotateLeft32(w[i-2], -17) ^ bits.RotateLeft32(w[i-2], -19) ^ (w[i-2] >> 10)
+		w[i] = w[i-16] + s0 + w[i-7] + s1
+	}
+	a, b, c, d, e, f, g, h := sha256TreeIV[0], sha256TreeIV[1], sha256TreeIV[2], sha256TreeIV[3], sha256TreeIV[4], sha256TreeIV[5], sha256TreeIV[6], sha256TreeIV[7]
+	for i := 0; i < 64; i++ {
+		s1 := bits.RotateLeft32(e, -6) ^ bits.RotateLeft32(e, -11) ^ bits.RotateLeft32(e, -25)
+		ch := (e & f) ^ (^e & g)
+		t1 := h + s1 + ch + sha256K[i] + w[i]
+		s0 := bits.RotateLeft32(a, -2) ^ bits.RotateLeft32(a, -13) ^ bits.RotateLeft32(a, -22)
+		maj := (a & b) ^ (a & c) ^ (b & c)
+		t2 := s0 + maj
+		a, b, c, d, e, f, g, h = t1+t2, a, b, c, d+t1, e, f, g
+	}
+	var out [sha256.Size]byte
+	for i, v := range [8]uint32{a, b, c, d, e, f, g, h} {
+		binary.BigEndian.PutUint32(out[4*i:], v)
+	}
+	return out
+}
+
+// sha256TreeNode is the hash of a complete subtree of 2^level chunks.
+type sha256TreeNode struct {
+	sum   [sha256.Size]byte
+	level int
+}
+
+// sha256Tree computes SHA256TREE hashes incrementally. The blob is split into chunks, and the
+// hashes of the complete subtrees seen so far are kept on a stack, merging equal subtrees as they
+// complete. The left part of a blob is the largest power of two chunks shorter than it, so the
+// final hash folds the stack from the right.
+type sha256Tree struct {
+	chunk    hash.Hash
+	chunkLen int
+	stack    []sha256TreeNode
+}
+
+// NewSHA256Tree returns a hash.Hash computing the SHA256TREE digest function.
+func NewSHA256Tree() hash.Hash {
+	return &sha256Tree{chunk: sha256.New()}
+}
+
+func (t *sha256Tree) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		if t.chunkLen == sha256TreeChunkSize {
+			// The chunk is only pushed once more data follows, since a blob of a single chunk is
+			// hashed as is.
+			var sum [sha256.Size]byte
+			t.chunk.Sum(sum[:0])
+			t.push(sum)
+			t.chunk.Reset()
+			t.chunkLen = 0
+		}
+		k := sha256TreeChunkSize - t.chunkLen
+		if k > len(p) {
+			k = len(p)
+		}
+		t.chunk.Write(p[:k])
+		t.chunkLen += k
+		p = p[k:]
+	}
+	return n, nil
+}
+
+func (t *sha256Tree) push(sum [sha256.Size]byte) {
+	level := 0
+	for len(t.stack) > 0 && t.stack[len(t.stack)-1].level == level {
+		sum = sha256TreeParent(t.stack[len(t.stack)-1].sum, sum)
+		t.stack = t.stack[:len(t.stack)-1]
+		level++
+	}
+	t.stack = append(t.stack, sha256TreeNode{sum: sum, level: level})
+}
+
+func (t *sha256Tree) Sum(b []byte) []byte {
+	var sum [sha256.Size]byte
+	t.chunk.Sum(sum[:0])
+	for i := len(t.stack) - 1; i >= 0; i-- {
+		sum = sha256TreeParent(t.stack[i].sum, sum)
+	}
+	return append(b, sum[:]...)
+}
+
+func (t *sha256Tree) Reset() {
+	t.chunk.Reset()
+	t.chunkLen = 0
+	t.stack = t.stack[:0]
+}
+
+func (t *sha256Tree) Size() int { return sha256.Size }
+
+func (t *sha256Tree) BlockSize() int { return sha256.BlockSize }
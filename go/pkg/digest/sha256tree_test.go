@@ -0,0 +1,100 @@
+package digest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	repb "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+)
+
+// sha256TreeInput returns the repeating sequence 0, 1, ..., 250 of the SHA256TREE test vectors.
+func sha256TreeInput(n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte(i % 251)
+	}
+	return b
+}
+
+func TestSHA256Tree(t *testing.T) {
+	t.Parallel()
+	// From sha256tree_test_vectors.txt in the remote APIs.
+	tests := []struct {
+		size int
+		want string
+	}{
+		{1025, "36c0998b21839ef74300b9de47d96d1f62323dc81f2b4231e98ce70cd6ffe750"},
+		{2048, "b584996386f01793751c5cf0c39561f51b7e9924b818943b3cb2f6928cea0fa9"},
+		{2049, "7318d2029b0392edf4cf109edb5a086b4bdadbb7950f710a1483eb881d9e5d44"},
+		{3072, "dfc61c0a041f79d55d53bfe31c6cda7df77fdc8e6fbac1143d70b7144fdf6937"},
+		{3073, "517d20c0e5835f060a1bd6388ed68574f63424bdac2a2c3a35a5c2ef859d8fe2"},
+		{4096, "2f72bb93880012168c027f6781527ff08177c7c8dccb443f4d2c6389c186633d"},
+		{4097, "c3ec942c1b8f4580320d3a06bcf4f8fe1f5db2be797ab67061ea4c2a95f208f2"},
+		{5120, "a76924f6535b4b473377c285ec27acc84cc58e95ab1e9e29b1bb6a4a3fb9d0b3"},
+		{6145, "6dc4b78efd770453417b2ffdc74b27054793efe6122ecd7ee098670ed7c4651c"},
+		{8193, "113c6e3a2452f388b6fad13dfab66ee0bff597a0a9a517ad8d0165f7190b603e"},
+		{16384, "a7a10149a8cb00be537000560edb83b196306b780b72fad8af218f369f75fc19"},
+		{31744, "2cdf7662636c173d4b236f6ea03bf84c65e7f6487b53b2a61c420e26cf8a98c7"},
+		{102400, "0668d69e5331840d2f1823d717b7b3f5d1fdc8a09504cddb692b87ff83d50e5f"},
+	}
+	for _, tc := range tests {
+		in := sha256TreeInput(tc.size)
+		h := NewSHA256Tree()
+		h.Write(in)
+		if got := hex.EncodeToString(h.Sum(nil)); got != tc.want {
+			t.Errorf("SHA256TREE of %d bytes = %s, want %s", tc.size, got, tc.want)
+		}
+		// Writes that do not align with the chunks give the same hash.
+		h.Reset()
+		for b := in; len(b) > 0; {
+			n := 333
+			if n > len(b) {
+				n = len(b)
+			}
+			h.Write(b[:n])
+			b = b[n:]
+		}
+		if got := hex.EncodeToString(h.Sum(nil)); got != tc.want {
+			t.Errorf("SHA256TREE of %d bytes written in pieces = %s, want %s", tc.size, got, tc.want)
+		}
+	}
+}
+
+func TestSHA256TreeSmallBlobs(t *testing.T) {
+	t.Parallel()
+	for _, size := range []int{0, 1, 1024} {
+		in := sha256TreeInput(size)
+		h := NewSHA256Tree()
+		h.Write(in)
+		want := sha256.Sum256(in)
+		if got := h.Sum(nil); string(got) != string(want[:]) {
+			t.Errorf("SHA256TREE of %d bytes = %x, want the SHA-256 %x", size, got, want)
+		}
+	}
+}
+
+func TestNewFunction(t *testing.T) {
+	fn, err := NewFunction(repb.DigestFunction_SHA256TREE)
+	if err != nil {
+		t.Fatalf("NewFunction(SHA256TREE) = %v, want nil", err)
+	}
+	if got := fn.Value(); got != repb.DigestFunction_SHA256TREE {
+		t.Errorf("Value() = %v, want SHA256TREE", got)
+	}
+	in := sha256TreeInput(2048)
+	want := "b584996386f01793751c5cf0c39561f51b7e9924b818943b3cb2f6928cea0fa9"
+	if got := fn.NewFromBlob(in).Hash; got != want {
+		t.Errorf("NewFromBlob(...) with SHA256TREE = %s, want %s", got, want)
+	}
+	// The default digest function is unaffected.
+	if got, want := NewFromBlob(in), (Function{}).NewFromBlob(in); got != want || got.Hash == fn.NewFromBlob(in).Hash {
+		t.Errorf("NewFromBlob(...) = %v, want the SHA-256 digest %v", got, want)
+	}
+	if got := GetDigestFunction(); got != repb.DigestFunction_SHA256 {
+		t.Errorf("GetDigestFunction() = %v, want SHA256", got)
+	}
+	if _, err := NewFunction(repb.DigestFunction_VSO); err == nil {
+		t.Errorf("NewFunction(VSO) = nil, want error")
+	}
+}
@@ -0,0 +1,55 @@
+package digest
+
+import (
+	"encoding/hex"
+	"hash"
+	"io"
+)
+
+// Writer computes the digest of the bytes written to it incrementally, without buffering them.
+type Writer struct {
+	h    hash.Hash
+	size int64
+}
+
+// NewWriter returns a Writer computing the digest with the default digest function.
+func NewWriter() *Writer {
+	return Function{}.NewWriter()
+}
+
+// Write adds p to the digested bytes. It never returns an error.
+func (w *Writer) Write(p []byte) (int, error) {
+	n, _ := w.h.Write(p)
+	w.size += int64(n)
+	return n, nil
+}
+
+// Digest returns the digest of the bytes written so far.
+func (w *Writer) Digest() Digest {
+	return Digest{Hash: hex.EncodeToString(w.h.Sum(nil)), Size: w.size}
+}
+
+// TeeReader is a reader computing the digest of the bytes read through it, so that a stream can
+// be digested while it is consumed, e.g. by an upload, rather than read twice.
+type TeeReader struct {
+	r io.Reader
+	w *Writer
+}
+
+// NewTeeReader returns a TeeReader reading from r, computing the digest with the default digest
+// function.
+func NewTeeReader(r io.Reader) *TeeReader {
+	return Function{}.NewTeeReader(r)
+}
+
+// Read reads from the underlying reader, digesting the bytes read.
+func (t *TeeReader) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	t.w.Write(p[:n])
+	return n, err
+}
+
+// Digest returns the digest of the bytes read so far.
+func (t *TeeReader) Digest() Digest {
+	return t.w.Digest()
+}
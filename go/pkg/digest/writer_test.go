@@ -0,0 +1,43 @@
+package digest
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestWriter(t *testing.T) {
+	blob := []byte(strings.Repeat("abcdefgh", 10000))
+	w := NewWriter()
+	if got := w.Digest(); got != Empty {
+		t.Errorf("Digest() of nothing = %v, want %v", got, Empty)
+	}
+	for i := 0; i < len(blob); i += 999 {
+		end := i + 999
+		if end > len(blob) {
+			end = len(blob)
+		}
+		if _, err := w.Write(blob[i:end]); err != nil {
+			t.Fatalf("Write() failed: %v", err)
+		}
+	}
+	if got, want := w.Digest(), NewFromBlob(blob); got != want {
+		t.Errorf("Digest() = %v, want %v", got, want)
+	}
+}
+
+func TestTeeReader(t *testing.T) {
+	blob := []byte(strings.Repeat("abcdefgh", 10000))
+	tr := NewTeeReader(bytes.NewReader(blob))
+	got, err := io.ReadAll(tr)
+	if err != nil {
+		t.Fatalf("ReadAll() failed: %v", err)
+	}
+	if !bytes.Equal(got, blob) {
+		t.Errorf("ReadAll() returned %d different bytes, want the %d bytes of the blob", len(got), len(blob))
+	}
+	if got, want := tr.Digest(), NewFromBlob(blob); got != want {
+		t.Errorf("Digest() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,380 @@
+// Package diskcache implements a persistent local cache of CAS blobs and ActionResults, which
+// evicts the least recently used entries to stay under a maximum size. It lets clients skip the
+// remote cache for the results and outputs of actions they already ran or fetched, e.g. on
+// developer machines building the same targets repeatedly.
+//
+// Blobs are stored as cas/<hash[:2]>/<hash>-<size> and serialized ActionResults as
+// ac/<hash[:2]>/<hash>-<size>, keyed by the action digest, under the root directory. Entries are
+// written through temporary files and renamed into place, so that readers never see partial
+// entries. The recency of entries is kept in their modification times, so that it survives
+// restarts.
+package diskcache
+
+import (
+	"bytes"
+	"container/list"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/digest"
+	"google.golang.org/protobuf/proto"
+
+	repb "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	log "github.com/golang/glog"
+)
+
+const (
+	casDir = "cas"
+	acDir  = "ac"
+)
+
+// key identifies a cache entry.
+type key struct {
+	dg    digest.Digest
+	isCas bool
+}
+
+// entry is a cache entry in the LRU list.
+type entry struct {
+	key  key
+	size int64
+}
+
+// Stats are the counters of a DiskCache.
+type Stats struct {
+	// CasHits and CasMisses count the blob loads.
+	CasHits, CasMisses int64
+	// ActionCacheHits and ActionCacheMisses count the ActionResult loads.
+	ActionCacheHits, ActionCacheMisses int64
+	// Evictions counts the entries evicted.
+	Evictions int64
+	// SizeBytes is the total size of the entries.
+	SizeBytes int64
+}
+
+// DiskCache is a CAS and action cache on local disk. It is safe for concurrent use, but only one
+// DiskCache should use a root directory at a time.
+type DiskCache struct {
+	root     string
+	maxBytes int64
+
+	mu sync.Mutex
+	// The entries, most recently used first.
+	lru     *list.List
+	entries map[key]*list.Element
+	stats   Stats
+}
+
+// New returns a DiskCache in root, creating the directory if needed and loading the entries
+// already in it. Entries are evicted when the total size goes above maxCapacityBytes.
+func New(root string, maxCapacityBytes int64) (*DiskCache, error) {
+	if maxCapacityBytes <= 0 {
+		return nil, fmt.Errorf("invalid disk cache capacity %d", maxCapacityBytes)
+	}
+	d := &DiskCache{
+		root:     root,
+		maxBytes: maxCapacityBytes,
+		lru:      list.New(),
+		entries:  make(map[key]*list.Element),
+	}
+	for _, sub := range []string{casDir, acDir} {
+		if err := os.MkdirAll(filepath.Join(root, sub), 0755); err != nil {
+			return nil, err
+		}
+	}
+	if err := d.load(); err != nil {
+		return nil, err
+	}
+	d.mu.Lock()
+	d.evict()
+	d.mu.Unlock()
+	return d, nil
+}
+
+// load adds the entries found on disk to the LRU list, by their modification times. Leftover
+// temporary files are removed.
+func (d *DiskCache) load() error {
+	type found struct {
+		entry
+		mtime time.Time
+	}
+	var all []found
+	for _, sub := range []string{casDir, acDir} {
+		err := filepath.WalkDir(filepath.Join(d.root, sub), func(path string, de os.DirEntry, err error) error {
+			if err != nil || de.IsDir() {
+				return err
+			}
+			k, ok := parseName(de.Name(), sub == casDir)
+			if !ok {
+				log.Warningf("Removing unknown disk cache file %s", path)
+				return os.Remove(path)
+			}
+			fi, err := de.Info()
+			if err != nil {
+				return err
+			}
+			all = append(all, found{entry{key: k, size: fi.Size()}, fi.ModTime()})
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].mtime.After(all[j].mtime) })
+	for _, f := range all {
+		e := f.entry
+		d.entries[e.key] = d.lru.PushBack(&e)
+		d.stats.SizeBytes += e.size
+	}
+	return nil
+}
+
+// parseName returns the key of the entry file name, or false if it is not one.
+func parseName(name string, isCas bool) (key, bool) {
+	hash, sizeStr, ok := strings.Cut(name, "-")
+	if !ok {
+		return key{}, false
+	}
+	size, err := strconv.ParseInt(sizeStr, 10, 64)
+	if err != nil {
+		return key{}, false
+	}
+	dg, err := digest.New(hash, size)
+	if err != nil {
+		return key{}, false
+	}
+	return key{dg: dg, isCas: isCas}, true
+}
+
+func (d *DiskCache) path(k key) string {
+	sub := acDir
+	if k.isCas {
+		sub = casDir
+	}
+	return filepath.Join(d.root, sub, k.dg.Hash[:2], fmt.Sprintf("%s-%d", k.dg.Hash, k.dg.Size))
+}
+
+// use marks the entry of k as the most recently used, and returns whether there is one.
+func (d *DiskCache) use(k key) bool {
+	d.mu.Lock()
+	el, ok := d.entries[k]
+	if ok {
+		d.lru.MoveToFront(el)
+	}
+	d.mu.Unlock()
+	if ok {
+		// Errors only make the recency less accurate after a restart.
+		now := time.Now()
+		os.Chtimes(d.path(k), now, now)
+	}
+	return ok
+}
+
+// add records the entry of k, written to disk, and evicts the least recently used entries if
+// the cache is over capacity.
+func (d *DiskCache) add(k key, size int64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if el, ok := d.entries[k]; ok {
+		// Action results may be replaced with results of another size.
+		e := el.Value.(*entry)
+		d.stats.SizeBytes += size - e.size
+		e.size = size
+		d.lru.MoveToFront(el)
+		d.evict()
+		return
+	}
+	d.entries[k] = d.lru.PushFront(&entry{key: k, size: size})
+	d.stats.SizeBytes += size
+	d.evict()
+}
+
+// evict removes the least recently used entries until the cache is within capacity. It must be
+// called with the mutex held.
+func (d *DiskCache) evict() {
+	for d.stats.SizeBytes > d.maxBytes && d.lru.Len() > 0 {
+		e := d.lru.Remove(d.lru.Back()).(*entry)
+		delete(d.entries, e.key)
+		d.stats.SizeBytes -= e.size
+		d.stats.Evictions++
+		if err := os.Remove(d.path(e.key)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Warningf("Failed to evict disk cache entry: %v", err)
+		}
+	}
+}
+
+// write writes the contents of r to the entry of k through a temporary file.
+func (d *DiskCache) write(k key, r io.Reader) error {
+	path := d.path(k)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), ".tmp*")
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(f, r)
+	if err == nil && k.isCas && n != k.dg.Size {
+		err = fmt.Errorf("blob %v has %d bytes", k.dg, n)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	d.add(k, n)
+	return nil
+}
+
+// LoadCas copies the blob of dg to a new file at path, replacing any file there, and returns
+// whether the blob was in the cache. The file is created with mode 0644, before the umask.
+func (d *DiskCache) LoadCas(dg digest.Digest, path string) bool {
+	k := key{dg: dg, isCas: true}
+	ok := d.use(k) && d.copyTo(k, path) == nil
+	d.countCas(ok)
+	return ok
+}
+
+// LoadCasBlob returns the blob of dg, and whether it was in the cache.
+func (d *DiskCache) LoadCasBlob(dg digest.Digest) ([]byte, bool) {
+	k := key{dg: dg, isCas: true}
+	var blob []byte
+	ok := d.use(k)
+	if ok {
+		var err error
+		blob, err = os.ReadFile(d.path(k))
+		ok = err == nil
+	}
+	d.countCas(ok)
+	return blob, ok
+}
+
+func (d *DiskCache) countCas(hit bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if hit {
+		d.stats.CasHits++
+	} else {
+		d.stats.CasMisses++
+	}
+}
+
+func (d *DiskCache) copyTo(k key, path string) error {
+	in, err := os.Open(d.path(k))
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	out, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// StoreCas stores the contents of the file at path as the blob of dg, unless it is already in
+// the cache.
+func (d *DiskCache) StoreCas(dg digest.Digest, path string) error {
+	k := key{dg: dg, isCas: true}
+	if d.use(k) {
+		return nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return d.write(k, f)
+}
+
+// HasCas returns whether the blob of dg is in the cache.
+func (d *DiskCache) HasCas(dg digest.Digest) bool {
+	return d.use(key{dg: dg, isCas: true})
+}
+
+// StoreCasBlob stores blob as the blob of dg, unless it is already in the cache.
+func (d *DiskCache) StoreCasBlob(dg digest.Digest, blob []byte) error {
+	k := key{dg: dg, isCas: true}
+	if d.use(k) {
+		return nil
+	}
+	return d.write(k, bytes.NewReader(blob))
+}
+
+// LoadActionCache returns the cached ActionResult of the action with digest dg, and whether
+// there was one.
+func (d *DiskCache) LoadActionCache(dg digest.Digest) (*repb.ActionResult, bool) {
+	k := key{dg: dg}
+	var ar *repb.ActionResult
+	if d.use(k) {
+		if blob, err := os.ReadFile(d.path(k)); err == nil {
+			ar = &repb.ActionResult{}
+			if err := proto.Unmarshal(blob, ar); err != nil {
+				log.Warningf("Failed to read disk cache ActionResult of %v: %v", dg, err)
+				ar = nil
+			}
+		}
+	}
+	d.mu.Lock()
+	if ar != nil {
+		d.stats.ActionCacheHits++
+	} else {
+		d.stats.ActionCacheMisses++
+	}
+	d.mu.Unlock()
+	return ar, ar != nil
+}
+
+// StoreActionCache stores ar as the result of the action with digest dg, replacing any cached
+// one.
+func (d *DiskCache) StoreActionCache(dg digest.Digest, ar *repb.ActionResult) error {
+	blob, err := proto.Marshal(ar)
+	if err != nil {
+		return err
+	}
+	return d.write(key{dg: dg}, bytes.NewReader(blob))
+}
+
+// DeleteActionCache removes the cached ActionResult of the action with digest dg, if there is one,
+// e.g. because the blobs it refers to can no longer be fetched.
+func (d *DiskCache) DeleteActionCache(dg digest.Digest) error {
+	k := key{dg: dg}
+	d.mu.Lock()
+	if el, ok := d.entries[k]; ok {
+		e := d.lru.Remove(el).(*entry)
+		delete(d.entries, k)
+		d.stats.SizeBytes -= e.size
+	}
+	d.mu.Unlock()
+	if err := os.Remove(d.path(k)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// GetStats returns the counters of the cache.
+func (d *DiskCache) GetStats() Stats {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.stats
+}
@@ -0,0 +1,209 @@
+package diskcache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/digest"
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/protobuf/testing/protocmp"
+
+	repb "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+)
+
+// storeFile stores the contents in the cache through a file in dir.
+func storeFile(t *testing.T, d *DiskCache, dir, contents string) digest.Digest {
+	t.Helper()
+	path := filepath.Join(dir, "in")
+	if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	dg := digest.NewFromBlob([]byte(contents))
+	if err := d.StoreCas(dg, path); err != nil {
+		t.Fatalf("StoreCas(%v) failed: %v", dg, err)
+	}
+	return dg
+}
+
+func TestCas(t *testing.T) {
+	root, tmp := t.TempDir(), t.TempDir()
+	d, err := New(root, 100)
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	dg := storeFile(t, d, tmp, "foo")
+	out := filepath.Join(tmp, "out")
+	if !d.LoadCas(dg, out) {
+		t.Fatalf("LoadCas(%v) = false, want true", dg)
+	}
+	if b, err := os.ReadFile(out); err != nil || string(b) != "foo" {
+		t.Errorf("loaded blob = %q, %v, want %q", b, err, "foo")
+	}
+	missing := digest.NewFromBlob([]byte("bar"))
+	if d.LoadCas(missing, out) {
+		t.Errorf("LoadCas(%v) = true, want false", missing)
+	}
+	if diff := cmp.Diff(Stats{CasHits: 1, CasMisses: 1, SizeBytes: 3}, d.GetStats()); diff != "" {
+		t.Errorf("GetStats() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestCasBlob(t *testing.T) {
+	d, err := New(t.TempDir(), 100)
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	dg := digest.NewFromBlob([]byte("foo"))
+	if _, ok := d.LoadCasBlob(dg); ok {
+		t.Errorf("LoadCasBlob(%v) found a blob in an empty cache", dg)
+	}
+	if d.HasCas(dg) {
+		t.Errorf("HasCas(%v) = true in an empty cache", dg)
+	}
+	if err := d.StoreCasBlob(dg, []byte("foo")); err != nil {
+		t.Fatalf("StoreCasBlob(%v) failed: %v", dg, err)
+	}
+	if !d.HasCas(dg) {
+		t.Errorf("HasCas(%v) = false, want true", dg)
+	}
+	if b, ok := d.LoadCasBlob(dg); !ok || string(b) != "foo" {
+		t.Errorf("LoadCasBlob(%v) = %q, %v, want %q, true", dg, b, ok, "foo")
+	}
+	if diff := cmp.Diff(Stats{CasHits: 1, CasMisses: 1, SizeBytes: 3}, d.GetStats()); diff != "" {
+		t.Errorf("GetStats() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestStoreCasWrongSize(t *testing.T) {
+	root, tmp := t.TempDir(), t.TempDir()
+	d, err := New(root, 100)
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	path := filepath.Join(tmp, "in")
+	if err := os.WriteFile(path, []byte("foo"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.StoreCas(digest.NewFromBlob([]byte("foobar")), path); err == nil {
+		t.Errorf("StoreCas() with the wrong size succeeded, want error")
+	}
+	if got := d.GetStats().SizeBytes; got != 0 {
+		t.Errorf("SizeBytes = %d, want 0", got)
+	}
+}
+
+func TestActionCache(t *testing.T) {
+	d, err := New(t.TempDir(), 100)
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	acDg := digest.NewFromBlob([]byte("action"))
+	if _, ok := d.LoadActionCache(acDg); ok {
+		t.Errorf("LoadActionCache(%v) found a result in an empty cache", acDg)
+	}
+	ar := &repb.ActionResult{ExitCode: 1, StdoutRaw: []byte("out")}
+	if err := d.StoreActionCache(acDg, ar); err != nil {
+		t.Fatalf("StoreActionCache() failed: %v", err)
+	}
+	got, ok := d.LoadActionCache(acDg)
+	if !ok {
+		t.Fatalf("LoadActionCache(%v) = false, want true", acDg)
+	}
+	if diff := cmp.Diff(ar, got, protocmp.Transform()); diff != "" {
+		t.Errorf("LoadActionCache() mismatch (-want +got):\n%s", diff)
+	}
+
+	// Replacing the result updates the size.
+	ar2 := &repb.ActionResult{ExitCode: 2}
+	if err := d.StoreActionCache(acDg, ar2); err != nil {
+		t.Fatalf("StoreActionCache() failed: %v", err)
+	}
+	if got, want := d.GetStats().SizeBytes, int64(2); got != want {
+		t.Errorf("SizeBytes = %d, want %d", got, want)
+	}
+
+	if err := d.DeleteActionCache(acDg); err != nil {
+		t.Fatalf("DeleteActionCache() failed: %v", err)
+	}
+	if _, ok := d.LoadActionCache(acDg); ok {
+		t.Errorf("LoadActionCache(%v) found a deleted result", acDg)
+	}
+	if got := d.GetStats().SizeBytes; got != 0 {
+		t.Errorf("SizeBytes after deletion = %d, want 0", got)
+	}
+	if err := d.DeleteActionCache(acDg); err != nil {
+		t.Errorf("DeleteActionCache() of a missing result failed: %v", err)
+	}
+}
+
+func TestEviction(t *testing.T) {
+	root, tmp := t.TempDir(), t.TempDir()
+	d, err := New(root, 10)
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	foo := storeFile(t, d, tmp, "foo")
+	bar := storeFile(t, d, tmp, "bar")
+	// Using foo makes bar the least recently used blob.
+	if !d.LoadCas(foo, filepath.Join(tmp, "out")) {
+		t.Fatalf("LoadCas(foo) = false, want true")
+	}
+	baz := storeFile(t, d, tmp, "bazbaz")
+	for dg, want := range map[digest.Digest]bool{foo: true, bar: false, baz: true} {
+		if got := d.LoadCas(dg, filepath.Join(tmp, "out")); got != want {
+			t.Errorf("LoadCas(%v) = %v, want %v", dg, got, want)
+		}
+	}
+	st := d.GetStats()
+	if st.Evictions != 1 || st.SizeBytes != 9 {
+		t.Errorf("GetStats() = %+v, want 1 eviction and 9 bytes", st)
+	}
+	if _, err := os.Stat(d.path(key{dg: bar, isCas: true})); !os.IsNotExist(err) {
+		t.Errorf("evicted blob is still on disk: %v", err)
+	}
+}
+
+func TestReload(t *testing.T) {
+	root, tmp := t.TempDir(), t.TempDir()
+	d, err := New(root, 100)
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	foo := storeFile(t, d, tmp, "foo")
+	acDg := digest.NewFromBlob([]byte("action"))
+	if err := d.StoreActionCache(acDg, &repb.ActionResult{ExitCode: 1}); err != nil {
+		t.Fatalf("StoreActionCache() failed: %v", err)
+	}
+	// Leftover temporary files are removed.
+	leftover := filepath.Join(root, casDir, ".tmp123")
+	if err := os.WriteFile(leftover, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	d2, err := New(root, 100)
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	if got, want := d2.GetStats().SizeBytes, d.GetStats().SizeBytes; got != want {
+		t.Errorf("SizeBytes after reload = %d, want %d", got, want)
+	}
+	if !d2.LoadCas(foo, filepath.Join(tmp, "out")) {
+		t.Errorf("LoadCas(foo) after reload = false, want true")
+	}
+	if _, ok := d2.LoadActionCache(acDg); !ok {
+		t.Errorf("LoadActionCache() after reload = false, want true")
+	}
+	if _, err := os.Stat(leftover); !os.IsNotExist(err) {
+		t.Errorf("leftover temporary file was not removed: %v", err)
+	}
+
+	// Reloading with a lower capacity evicts entries.
+	d3, err := New(root, 3)
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	if got := d3.GetStats().SizeBytes; got > 3 {
+		t.Errorf("SizeBytes after reload = %d, want at most 3", got)
+	}
+}
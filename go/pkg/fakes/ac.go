@@ -9,6 +9,7 @@ import (
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/digest"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
 
 	repb "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
 )
@@ -19,6 +20,10 @@ type ActionCache struct {
 	results map[digest.Digest]*repb.ActionResult
 	reads   map[digest.Digest]int
 	writes  map[digest.Digest]int
+	// InlineFrom, if set, is the CAS the contents of output files requested inline are read from.
+	InlineFrom *CAS
+	// LastUpdateRequest is the last UpdateActionResultRequest received.
+	LastUpdateRequest *repb.UpdateActionResultRequest
 }
 
 // NewActionCache returns a new empty ActionCache.
@@ -82,10 +87,27 @@ func (c *ActionCache) GetActionResult(ctx context.Context, req *repb.GetActionRe
 		return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("invalid digest received: %v", req.ActionDigest))
 	}
 	c.reads[dg]++
-	if res, ok := c.results[dg]; ok {
+	res, ok := c.results[dg]
+	if !ok {
+		return nil, status.Error(codes.NotFound, "")
+	}
+	if len(req.InlineOutputFiles) == 0 || c.InlineFrom == nil {
 		return res, nil
 	}
-	return nil, status.Error(codes.NotFound, "")
+	inline := make(map[string]bool)
+	for _, path := range req.InlineOutputFiles {
+		inline[path] = true
+	}
+	res = proto.Clone(res).(*repb.ActionResult)
+	for _, f := range res.OutputFiles {
+		if !inline[f.Path] {
+			continue
+		}
+		if blob, ok := c.InlineFrom.Get(digest.NewFromProtoUnvalidated(f.Digest)); ok {
+			f.Contents = blob
+		}
+	}
+	return res, nil
 }
 
 // UpdateActionResult sets/updates a given result.
@@ -101,5 +123,6 @@ func (c *ActionCache) UpdateActionResult(ctx context.Context, req *repb.UpdateAc
 	}
 	c.results[dg] = req.ActionResult
 	c.writes[dg]++
+	c.LastUpdateRequest = req
 	return req.ActionResult, nil
 }
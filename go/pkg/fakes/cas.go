@@ -12,10 +12,8 @@ import (
 	"testing"
 	"time"
 
-	"github.com/bazelbuild/remote-apis-sdks/go/pkg/chunker"
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/client"
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/digest"
-	"github.com/bazelbuild/remote-apis-sdks/go/pkg/uploadinfo"
 	"github.com/klauspost/compress/zstd"
 	"github.com/pborman/uuid"
 	"google.golang.org/grpc/codes"
@@ -60,9 +58,20 @@ func (f *Reader) Validate(t *testing.T) {
 	}
 }
 
+// splitDigestFunction removes the segment naming the digest function, if any, from the path of a
+// blob resource name, and returns the digest function, which is the default one if it is not named.
+func splitDigestFunction(path []string) ([]string, digest.Function) {
+	for i := 1; i < len(path)-2; i++ {
+		if fn, ok := digest.FunctionFromResourceNameSegment(path[i]); ok {
+			return append(append([]string{}, path[:i]...), path[i+1:]...), fn
+		}
+	}
+	return path, digest.Function{}
+}
+
 // Read implements the corresponding RE API function.
 func (f *Reader) Read(req *bspb.ReadRequest, stream bsgrpc.ByteStream_ReadServer) error {
-	path := strings.Split(req.ResourceName, "/")
+	path, fn := splitDigestFunction(strings.Split(req.ResourceName, "/"))
 	if (len(path) != 4 && len(path) != 5) || path[0] != "instance" || (path[1] != "blobs" && path[1] != "compressed-blobs") {
 		return status.Error(codes.InvalidArgument, "test fake expected resource name of the form \"instance/blobs|compressed-blobs/<compressor?>/<hash>/<size>\"")
 	}
@@ -72,7 +81,7 @@ func (f *Reader) Read(req *bspb.ReadRequest, stream bsgrpc.ByteStream_ReadServer
 		indexOffset = 1
 	}
 
-	dg := digest.NewFromBlob(f.Blob)
+	dg := fn.NewFromBlob(f.Blob)
 	if path[2+indexOffset] != dg.Hash || path[3+indexOffset] != strconv.FormatInt(dg.Size, 10) {
 		return status.Errorf(codes.NotFound, "test fake only has blob with digest %s, but %s/%s was requested", dg, path[2+indexOffset], path[3+indexOffset])
 	}
@@ -161,7 +170,7 @@ func (f *Writer) Write(stream bsgrpc.ByteStream_WriteServer) (err error) {
 		return err
 	}
 
-	path := strings.Split(req.ResourceName, "/")
+	path, fn := splitDigestFunction(strings.Split(req.ResourceName, "/"))
 	if (len(path) != 6 && len(path) != 7) || path[0] != "instance" || path[1] != "uploads" || (path[3] != "blobs" && path[3] != "compressed-blobs") {
 		return status.Error(codes.InvalidArgument, "test fake expected resource name of the form \"instance/uploads/<uuid>/blobs|compressed-blobs/<compressor?>/<hash>/<size>\"")
 	}
@@ -242,7 +251,7 @@ func (f *Writer) Write(stream bsgrpc.ByteStream_WriteServer) (err error) {
 		f.Buf = buf.Bytes()
 	}
 
-	cDg := digest.NewFromBlob(f.Buf)
+	cDg := fn.NewFromBlob(f.Buf)
 	if dg != cDg {
 		return status.Errorf(codes.InvalidArgument, "mismatched digest: received %s, computed %s", dg, cDg)
 	}
@@ -262,12 +271,23 @@ func (f *Writer) QueryWriteStatus(context.Context, *bspb.QueryWriteStatusRequest
 // CAS is a fake CAS that implements FindMissingBlobs, Read and Write, storing stored blobs
 // in a map. It also counts the number of requests to store received, for validating batching logic.
 type CAS struct {
+	// WriteInterrupt, if set, is called after each chunk a Write receives with the resource name
+	// and the size received. If it returns true, the Write fails with Unavailable, and the data
+	// received so far is committed, for QueryWriteStatus and for a resumed Write.
+	WriteInterrupt func(resource string, size int64) bool
+	// ReadInterrupt, if set, is called after each chunk a Read sends with the resource name and the
+	// offset of the end of the chunk. If it returns true, the Read fails with Unavailable.
+	ReadInterrupt func(resource string, offset int64) bool
+	// ReadChunkSize is the size of the chunks sent by Read. Defaults to 2MB, the protocol maximum.
+	ReadChunkSize int
+
 	// Maximum batch byte size to verify requests against.
 	BatchSize         int
 	ReqSleepDuration  time.Duration
 	ReqSleepRandomize bool
 	PerDigestBlockFn  map[digest.Digest]func()
 	blobs             map[digest.Digest][]byte
+	partialWrites     map[string][]byte
 	reads             map[digest.Digest]int
 	writes            map[digest.Digest]int
 	missingReqs       map[digest.Digest]int
@@ -298,6 +318,7 @@ func (f *CAS) Clear() {
 		digest.Empty: {},
 	}
 	f.reads = make(map[digest.Digest]int)
+	f.partialWrites = make(map[string][]byte)
 	f.writes = make(map[digest.Digest]int)
 	f.missingReqs = make(map[digest.Digest]int)
 	f.batchReqs = 0
@@ -403,6 +424,10 @@ func (f *CAS) maybeSleep() {
 
 // BatchUpdateBlobs implements the corresponding RE API function.
 func (f *CAS) BatchUpdateBlobs(ctx context.Context, req *repb.BatchUpdateBlobsRequest) (*repb.BatchUpdateBlobsResponse, error) {
+	fn, err := digest.NewFunction(req.DigestFunction)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
 	f.maybeSleep()
 	f.mu.Lock()
 	f.batchReqs++
@@ -441,7 +466,7 @@ func (f *CAS) BatchUpdateBlobs(ctx context.Context, req *repb.BatchUpdateBlobsRe
 			r.Data = d
 		}
 
-		dg := digest.NewFromBlob(r.Data)
+		dg := fn.NewFromBlob(r.Data)
 		rdg := digest.NewFromProtoUnvalidated(r.Digest)
 		if dg != rdg {
 			resps = append(resps, &repb.BatchUpdateBlobsResponse_Response{
@@ -583,7 +608,7 @@ func (f *CAS) Write(stream bsgrpc.ByteStream_WriteServer) (err error) {
 		return err
 	}
 
-	path := strings.Split(req.ResourceName, "/")
+	path, fn := splitDigestFunction(strings.Split(req.ResourceName, "/"))
 	if (len(path) != 6 && len(path) != 7) || path[0] != "instance" || path[1] != "uploads" || (path[3] != "blobs" && path[3] != "compressed-blobs") {
 		return status.Error(codes.InvalidArgument, "test fake expected resource name of the form \"instance/uploads/<uuid>/blobs|compressed-blobs/<compressor?>/<hash>/<size>\"")
 	}
@@ -623,6 +648,13 @@ func (f *CAS) Write(stream bsgrpc.ByteStream_WriteServer) (err error) {
 	}
 	f.mu.Unlock()
 	res := req.ResourceName
+	if req.WriteOffset != 0 {
+		// A resumed write, which continues from the data committed by the interrupted one.
+		f.mu.Lock()
+		buf.Write(f.partialWrites[res])
+		f.mu.Unlock()
+		off = int64(buf.Len())
+	}
 	done := false
 	for {
 		if req.ResourceName != res && req.ResourceName != "" {
@@ -645,6 +677,12 @@ func (f *CAS) Write(stream bsgrpc.ByteStream_WriteServer) (err error) {
 		if req.FinishWrite {
 			done = true
 		}
+		if f.WriteInterrupt != nil && f.WriteInterrupt(res, off) {
+			f.mu.Lock()
+			f.partialWrites[res] = append([]byte(nil), buf.Bytes()...)
+			f.mu.Unlock()
+			return status.Error(codes.Unavailable, "test fake interrupted the write")
+		}
 
 		req, err = stream.Recv()
 		if err == io.EOF {
@@ -674,8 +712,9 @@ func (f *CAS) Write(stream bsgrpc.ByteStream_WriteServer) (err error) {
 	f.mu.Lock()
 	f.blobs[dg] = uncompressedBuf
 	f.writes[dg]++
+	delete(f.partialWrites, res)
 	f.mu.Unlock()
-	cDg := digest.NewFromBlob(uncompressedBuf)
+	cDg := fn.NewFromBlob(uncompressedBuf)
 	if dg != cDg {
 		return status.Errorf(codes.InvalidArgument, "mismatched digest: received %s, computed %s", dg, cDg)
 	}
@@ -687,11 +726,11 @@ func (f *CAS) Read(req *bspb.ReadRequest, stream bsgrpc.ByteStream_ReadServer) e
 	if req.ReadOffset < 0 {
 		return status.Error(codes.InvalidArgument, "test fake expected a positive value for offset")
 	}
-	if req.ReadLimit != 0 {
-		return status.Error(codes.Unimplemented, "test fake does not implement limit")
+	if req.ReadLimit < 0 {
+		return status.Error(codes.InvalidArgument, "test fake expected a non-negative value for limit")
 	}
 
-	path := strings.Split(req.ResourceName, "/")
+	path, _ := splitDigestFunction(strings.Split(req.ResourceName, "/"))
 	if (len(path) != 4 && len(path) != 5) || path[0] != "instance" || (path[1] != "blobs" && path[1] != "compressed-blobs") {
 		return status.Error(codes.InvalidArgument, "test fake expected resource name of the form \"instance/blobs|compressed-blobs/<compressor?>/<hash>/<size>\"")
 	}
@@ -717,46 +756,50 @@ func (f *CAS) Read(req *bspb.ReadRequest, stream bsgrpc.ByteStream_ReadServer) e
 	}
 
 	if path[1] == "compressed-blobs" {
+		if req.ReadLimit != 0 {
+			return status.Error(codes.Unimplemented, "test fake does not implement limit for compressed blobs")
+		}
 		if path[2] != "zstd" {
 			return status.Error(codes.InvalidArgument, "test fake expected valid compressor, eg zstd")
 		}
 		blob = zstdEncoder.EncodeAll(blob, nil)
 	}
-	ue := uploadinfo.EntryFromBlob(blob)
-	ch, err := chunker.New(ue, false, 2*1024*1024)
-	if err != nil {
-		return status.Errorf(codes.Internal, "test fake failed to create chunker: %v", err)
+	if end := req.ReadOffset + req.ReadLimit; req.ReadLimit > 0 && end < int64(len(blob)) {
+		blob = blob[:end]
 	}
-
-	resp := &bspb.ReadResponse{}
-	var offset int64
-	for ch.HasNext() {
-		chunk, err := ch.Next()
-		if err != nil {
-			return err
+	if req.ReadOffset > int64(len(blob)) {
+		return status.Errorf(codes.OutOfRange, "test fake got offset %d past the end of blob %s", req.ReadOffset, dg)
+	}
+	chunkSize := f.ReadChunkSize
+	if chunkSize <= 0 {
+		chunkSize = 2 * 1024 * 1024
+	}
+	// At least one, possibly empty, chunk is sent.
+	for off := req.ReadOffset; ; {
+		end := off + int64(chunkSize)
+		if end > int64(len(blob)) {
+			end = int64(len(blob))
 		}
-		// Seek to req.ReadOffset.
-		offset += int64(len(chunk.Data))
-		if offset < req.ReadOffset {
-			continue
+		if err := stream.Send(&bspb.ReadResponse{Data: blob[off:end]}); err != nil {
+			return err
 		}
-		// Scale the offset to the chunk.
-		offset = offset - req.ReadOffset         // The chunk tail that we want.
-		offset = int64(len(chunk.Data)) - offset // The chunk head that we don't want.
-		if offset < 0 {
-			// The chunk is past the offset.
-			offset = 0
+		off = end
+		if f.ReadInterrupt != nil && f.ReadInterrupt(req.ResourceName, off) {
+			return status.Error(codes.Unavailable, "test fake interrupted the read")
 		}
-		resp.Data = chunk.Data[int(offset):]
-		err = stream.Send(resp)
-		if err != nil {
-			return err
+		if off == int64(len(blob)) {
+			return nil
 		}
 	}
-	return nil
 }
 
 // QueryWriteStatus implements the corresponding RE API function.
-func (f *CAS) QueryWriteStatus(context.Context, *bspb.QueryWriteStatusRequest) (*bspb.QueryWriteStatusResponse, error) {
-	return nil, status.Error(codes.Unimplemented, "test fake does not implement method")
+func (f *CAS) QueryWriteStatus(_ context.Context, req *bspb.QueryWriteStatusRequest) (*bspb.QueryWriteStatusResponse, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	data, ok := f.partialWrites[req.ResourceName]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "test fake has no interrupted write of %q", req.ResourceName)
+	}
+	return &bspb.QueryWriteStatusResponse{CommittedSize: int64(len(data))}, nil
 }
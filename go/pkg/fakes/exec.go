@@ -3,25 +3,31 @@ package fakes
 import (
 	"context"
 	"fmt"
+	"sync"
 	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/client"
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/digest"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/proto"
 
 	// Redundant imports are required for the google3 mirror. Aliases should not be changed.
 	regrpc "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
 	repb "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	opgrpc "google.golang.org/genproto/googleapis/longrunning"
 	oppb "google.golang.org/genproto/googleapis/longrunning"
 	anypb "google.golang.org/protobuf/types/known/anypb"
+	emptypb "google.golang.org/protobuf/types/known/emptypb"
 )
 
 // Exec implements the complete RE execution interface for a single execution, returning a fixed
 // result or an error.
 type Exec struct {
+	opgrpc.UnimplementedOperationsServer
 	// Execution will check the action cache first, and update the action cache upon completion.
 	ac *ActionCache
 	// The action will be fetched from the CAS at start of execution, and outputs will be put in the
@@ -41,8 +47,18 @@ type Exec struct {
 	StdOutStreamName string
 	// Name of the logstream to write stderr to.
 	StdErrStreamName string
+	// Time Execute waits before executing, to simulate the action being queued.
+	QueueDelay time.Duration
+	// The last ExecuteRequest received, and the gRPC metadata it was sent with.
+	LastExecuteRequest *repb.ExecuteRequest
+	LastExecuteHeaders metadata.MD
+	// Whether GetCapabilities reports that symlinks may have absolute targets.
+	AllowAbsoluteSymlinks bool
 	// Number of Execute calls.
 	numExecCalls int32
+	// Names of the operations cancelled with CancelOperation.
+	mu        sync.Mutex
+	cancelled []string
 	// Used for errors.
 	t testing.TB
 	// The digest of the fake action.
@@ -62,7 +78,11 @@ func (s *Exec) Clear() {
 	s.Status = nil
 	s.Cached = false
 	s.OutputBlobs = nil
+	s.QueueDelay = 0
 	atomic.StoreInt32(&s.numExecCalls, 0)
+	s.mu.Lock()
+	s.cancelled = nil
+	s.mu.Unlock()
 }
 
 // ExecuteCalls returns the total number of Execute calls.
@@ -135,6 +155,9 @@ func (s *Exec) GetCapabilities(ctx context.Context, req *repb.GetCapabilitiesReq
 			SymlinkAbsolutePathStrategy: repb.SymlinkAbsolutePathStrategy_DISALLOWED,
 		},
 	}
+	if s.AllowAbsoluteSymlinks {
+		res.CacheCapabilities.SymlinkAbsolutePathStrategy = repb.SymlinkAbsolutePathStrategy_ALLOWED
+	}
 	return res, nil
 }
 
@@ -149,6 +172,22 @@ func (s *Exec) Execute(req *repb.ExecuteRequest, stream regrpc.Execution_Execute
 		s.t.Errorf("unexpected action digest received by fake: expected %v, got %v", s.adg, dg)
 		return status.Error(codes.InvalidArgument, fmt.Sprintf("unexpected digest received: %v", req.ActionDigest))
 	}
+	s.LastExecuteRequest = req
+	s.LastExecuteHeaders, _ = metadata.FromIncomingContext(stream.Context())
+	if s.QueueDelay > 0 {
+		md, err := anypb.New(&repb.ExecuteOperationMetadata{Stage: repb.ExecutionStage_QUEUED})
+		if err != nil {
+			return err
+		}
+		if err := stream.Send(&oppb.Operation{Name: fakeOPName(dg), Metadata: md}); err != nil {
+			return err
+		}
+		select {
+		case <-time.After(s.QueueDelay):
+		case <-stream.Context().Done():
+			return status.FromContextError(stream.Context().Err()).Err()
+		}
+	}
 	if s.StdOutStreamName != "" || s.StdErrStreamName != "" {
 		md, err := anypb.New(&repb.ExecuteOperationMetadata{
 			StdoutStreamName: s.StdOutStreamName,
@@ -170,6 +209,24 @@ func (s *Exec) Execute(req *repb.ExecuteRequest, stream regrpc.Execution_Execute
 	return nil
 }
 
+// CancelOperation records the cancellation of the fake operation.
+func (s *Exec) CancelOperation(ctx context.Context, req *oppb.CancelOperationRequest) (*emptypb.Empty, error) {
+	if req.Name != fakeOPName(s.adg) {
+		return nil, status.Errorf(codes.NotFound, "requested operation %v not found", req.Name)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cancelled = append(s.cancelled, req.Name)
+	return &emptypb.Empty{}, nil
+}
+
+// CancelledOperations returns the names of the operations cancelled with CancelOperation.
+func (s *Exec) CancelledOperations() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.cancelled...)
+}
+
 func (s *Exec) WaitExecution(req *repb.WaitExecutionRequest, stream regrpc.Execution_WaitExecutionServer) (err error) {
 	if req.Name != fakeOPName(s.adg) {
 		return status.Errorf(codes.NotFound, "requested operation %v not found", req.Name)
@@ -28,6 +28,7 @@ import (
 	repb "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
 	bsgrpc "google.golang.org/genproto/googleapis/bytestream"
 	bspb "google.golang.org/genproto/googleapis/bytestream"
+	opgrpc "google.golang.org/genproto/googleapis/longrunning"
 	anypb "google.golang.org/protobuf/types/known/anypb"
 	dpb "google.golang.org/protobuf/types/known/durationpb"
 	tspb "google.golang.org/protobuf/types/known/timestamppb"
@@ -48,6 +49,7 @@ func NewServer(t testing.TB) (s *Server, err error) {
 	cas := NewCAS()
 	ls := NewLogStreams()
 	ac := NewActionCache()
+	ac.InlineFrom = cas
 	s = &Server{Exec: NewExec(t, ac, cas), CAS: cas, LogStreams: ls, ActionCache: ac}
 	s.listener, err = net.Listen("tcp", ":0")
 	if err != nil {
@@ -59,6 +61,7 @@ func NewServer(t testing.TB) (s *Server, err error) {
 	regrpc.RegisterActionCacheServer(s.srv, s.ActionCache)
 	regrpc.RegisterCapabilitiesServer(s.srv, s.Exec)
 	regrpc.RegisterExecutionServer(s.srv, s.Exec)
+	opgrpc.RegisterOperationsServer(s.srv, s.Exec)
 	go s.srv.Serve(s.listener)
 	return s, nil
 }
@@ -116,9 +119,9 @@ func (s *Server) Write(stream bsgrpc.ByteStream_WriteServer) error {
 	return s.CAS.Write(stream)
 }
 
-// QueryWriteStatus implements the corresponding RE API function.
-func (*Server) QueryWriteStatus(context.Context, *bspb.QueryWriteStatusRequest) (*bspb.QueryWriteStatusResponse, error) {
-	return nil, status.Error(codes.Unimplemented, "test fake does not implement method")
+// QueryWriteStatus queries the status of a write to CAS.
+func (s *Server) QueryWriteStatus(ctx context.Context, req *bspb.QueryWriteStatusRequest) (*bspb.QueryWriteStatusResponse, error) {
+	return s.CAS.QueryWriteStatus(ctx, req)
 }
 
 // TestEnv is a wrapper for convenient integration tests of remote execution.
@@ -171,9 +174,9 @@ func timeToProto(t time.Time) *tspb.Timestamp {
 // It is not possible to make the fake result in a LocalErrorResultStatus or an InterruptedResultStatus.
 func (e *TestEnv) Set(cmd *command.Command, opt *command.ExecutionOptions, res *command.Result, opts ...Option) (cmdDg, acDg, stderrDg, stdoutDg digest.Digest) {
 	e.t.Helper()
-	cmd.FillDefaultFieldValues()
-	if err := cmd.Validate(); err != nil {
-		e.t.Fatalf("command validation failed: %v", err)
+	cmd, opt, err := e.Client.PrepareCommand(context.Background(), cmd, opt)
+	if err != nil {
+		e.t.Fatalf("command preparation failed: %v", err)
 	}
 
 	auxMeta := &apb.AuxiliaryMetadata{FakeMemoryPercentagePeak: 50.0}
@@ -221,7 +224,7 @@ func (e *TestEnv) Set(cmd *command.Command, opt *command.ExecutionOptions, res *
 		e.Server.CAS.Put(bytes)
 	}
 
-	cmdPb := cmd.ToREProto(false)
+	cmdPb := cmd.ToREProto(e.Client.GrpcClient.SupportsCommandOutputPaths())
 	bytes, err := proto.Marshal(cmdPb)
 	if err != nil {
 		e.t.Fatalf("error inserting command digest blob into CAS %v", err)
@@ -233,6 +236,7 @@ func (e *TestEnv) Set(cmd *command.Command, opt *command.ExecutionOptions, res *
 		CommandDigest:   cmdDg.ToProto(),
 		InputRootDigest: root.ToProto(),
 		DoNotCache:      opt.DoNotCache,
+		Salt:            opt.Salt,
 	}
 	if cmd.Timeout > 0 {
 		ac.Timeout = dpb.New(cmd.Timeout)
@@ -349,9 +353,11 @@ type OutputSymlink struct {
 	Target string
 }
 
-// Apply puts the file in the fake CAS and the given ActionResult.
+// Apply puts the symlink in the given ActionResult. Like a v2.1 server that is compatible with
+// older clients, the fake reports it both as a file symlink and in the unified symlinks field.
 func (l *OutputSymlink) apply(ac *repb.ActionResult, s *Server, execRoot string) error {
 	ac.OutputFileSymlinks = append(ac.OutputFileSymlinks, &repb.OutputSymlink{Path: l.Path, Target: l.Target})
+	ac.OutputSymlinks = append(ac.OutputSymlinks, &repb.OutputSymlink{Path: l.Path, Target: l.Target})
 	return nil
 }
 
@@ -23,7 +23,7 @@ type fmCache struct {
 }
 
 // NewSingleFlightCache returns a singleton-backed in-memory cache, with no validation.
-func NewSingleFlightCache() Cache {
+func NewSingleFlightCache() InvalidatingCache {
 	return &fmCache{Backend: &globalCache}
 }
 
@@ -52,6 +52,24 @@ func (c *fmCache) Delete(filename string) error {
 	return nil
 }
 
+// DeletePrefix deletes the entries of a directory and of the files under it.
+func (c *fmCache) DeletePrefix(dir string) error {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	c.Backend.DeleteIf(func(key interface{}) bool {
+		path, ok := key.(string)
+		return ok && underDir(path, abs)
+	})
+	return nil
+}
+
+// Reset deletes all the entries. As the entries are global, this is ResetGlobalCache.
+func (c *fmCache) Reset() {
+	c.Backend.Reset()
+}
+
 // Update updates the cache entry for the filename with the given value.
 func (c *fmCache) Update(filename string, cacheEntry *Metadata) error {
 	abs, err := filepath.Abs(filename)
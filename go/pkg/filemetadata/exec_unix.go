@@ -0,0 +1,11 @@
+//go:build !windows
+// +build !windows
+
+package filemetadata
+
+import "os"
+
+// isExecutable returns whether the file with the given mode is executable by its owner.
+func isExecutable(filename string, mode os.FileMode) bool {
+	return mode&0100 != 0
+}
@@ -0,0 +1,29 @@
+package filemetadata
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// executableExts are the extensions of the files considered executable on Windows, which has no
+// executable permission bit: the native executables and scripts, and shell scripts, which are
+// usually meant for Linux workers. Other files can be made executable with the unix_mode node
+// property.
+var executableExts = map[string]bool{
+	".bat": true,
+	".cmd": true,
+	".com": true,
+	".exe": true,
+	".ps1": true,
+	".sh":  true,
+}
+
+// isExecutable returns whether the file with the given mode is executable. Directories are, and
+// files are if their extension is one of executableExts.
+func isExecutable(filename string, mode os.FileMode) bool {
+	if mode.IsDir() {
+		return mode&0100 != 0
+	}
+	return executableExts[strings.ToLower(filepath.Ext(filename))]
+}
@@ -0,0 +1,27 @@
+package filemetadata
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestComputeExecutableByExtension(t *testing.T) {
+	dir := t.TempDir()
+	tests := map[string]bool{
+		"tool.exe":  true,
+		"build.CMD": true,
+		"run.sh":    true,
+		"data.txt":  false,
+		"noext":     false,
+	}
+	for name, want := range tests {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte("x"), 0666); err != nil {
+			t.Fatalf("os.WriteFile(%q) failed: %v", path, err)
+		}
+		if got := Compute(path).IsExecutable; got != want {
+			t.Errorf("Compute(%q).IsExecutable = %v, want %v", name, got, want)
+		}
+	}
+}
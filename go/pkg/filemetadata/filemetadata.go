@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
 	"time"
 
@@ -51,7 +52,9 @@ func (x xattributeAccessor) getXAttr(path string, name string) ([]byte, error) {
 }
 
 var (
-	// XattrDigestName is the xattr name for the object digest.
+	// XattrDigestName is the xattr name for the object digest. If set, the digests of files are read
+	// from it, as a hash or as hash/size, rather than computed from their contents, and files
+	// without it are hashed.
 	XattrDigestName string
 	// XattrAccess is the object to control access of XattrDigestName.
 	XattrAccess xattributeAccessorInterface = xattributeAccessor{}
@@ -101,7 +104,7 @@ func Compute(filename string) *Metadata {
 	}
 	mode := file.Mode()
 	md.MTime = file.ModTime()
-	md.IsExecutable = (mode & 0100) != 0
+	md.IsExecutable = isExecutable(filename, mode)
 	if mode.IsDir() {
 		md.IsDirectory = true
 		return md
@@ -141,6 +144,27 @@ type Cache interface {
 	GetCacheMisses() uint64
 }
 
+// InvalidatingCache is a Cache whose entries can also be deleted in bulk, for incremental build
+// tools that know which files changed.
+type InvalidatingCache interface {
+	Cache
+	// DeletePrefix deletes the entries of dir and of all the files under it.
+	DeletePrefix(dir string) error
+	// Reset deletes all the entries.
+	Reset()
+}
+
+// underDir returns whether the absolute path is dir or under it.
+func underDir(path, dir string) bool {
+	if path == dir {
+		return true
+	}
+	if !strings.HasSuffix(dir, string(filepath.Separator)) {
+		dir += string(filepath.Separator)
+	}
+	return strings.HasPrefix(path, dir)
+}
+
 type noopCache struct{}
 
 // Get computes the metadata from the file contents.
@@ -159,6 +183,14 @@ func (c *noopCache) Update(string, *Metadata) error {
 	return nil
 }
 
+// DeletePrefix deletes the entries under a directory. It is a noop for Noop cache.
+func (c *noopCache) DeletePrefix(string) error {
+	return nil
+}
+
+// Reset deletes all the entries. It is a noop for Noop cache.
+func (c *noopCache) Reset() {}
+
 // GetCacheHits returns the number of cache hits. It returns 0 for Noop cache.
 func (c *noopCache) GetCacheHits() uint64 {
 	return 0
@@ -171,6 +203,6 @@ func (c *noopCache) GetCacheMisses() uint64 {
 }
 
 // NewNoopCache returns a cache that doesn't cache (evaluates on every Get).
-func NewNoopCache() Cache {
+func NewNoopCache() InvalidatingCache {
 	return &noopCache{}
 }
@@ -0,0 +1,60 @@
+package filemetadata
+
+import (
+	"path/filepath"
+)
+
+// InvalidationHook is called with the absolute path of the entries a Cache deletes or updates: the
+// path of the file, or with DeletePrefix, of the directory. Reset calls it with an empty path.
+type InvalidationHook func(path string)
+
+type hookedCache struct {
+	InvalidatingCache
+	hook InvalidationHook
+}
+
+// WithInvalidationHook returns a Cache of the entries of c, which calls hook whenever entries are
+// deleted or updated through it, so that values derived from the metadata, such as the Directory
+// protos of input trees, are invalidated along with it.
+func WithInvalidationHook(c InvalidatingCache, hook InvalidationHook) InvalidatingCache {
+	return &hookedCache{InvalidatingCache: c, hook: hook}
+}
+
+// Delete deletes an entry from the cache, and calls the hook.
+func (c *hookedCache) Delete(filename string) error {
+	if err := c.InvalidatingCache.Delete(filename); err != nil {
+		return err
+	}
+	return c.invalidate(filename)
+}
+
+// DeletePrefix deletes the entries of a directory and of the files under it, and calls the hook.
+func (c *hookedCache) DeletePrefix(dir string) error {
+	if err := c.InvalidatingCache.DeletePrefix(dir); err != nil {
+		return err
+	}
+	return c.invalidate(dir)
+}
+
+// Update updates the cache entry for the filename with the given value, and calls the hook.
+func (c *hookedCache) Update(filename string, cacheEntry *Metadata) error {
+	if err := c.InvalidatingCache.Update(filename, cacheEntry); err != nil {
+		return err
+	}
+	return c.invalidate(filename)
+}
+
+// Reset deletes all the entries, and calls the hook with an empty path.
+func (c *hookedCache) Reset() {
+	c.InvalidatingCache.Reset()
+	c.hook("")
+}
+
+func (c *hookedCache) invalidate(path string) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	c.hook(abs)
+	return nil
+}
@@ -0,0 +1,34 @@
+package filemetadata
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestWithInvalidationHook(t *testing.T) {
+	dir := t.TempDir()
+	var got []string
+	c := WithInvalidationHook(NewLRUCache(10), func(path string) { got = append(got, path) })
+	foo, bar := filepath.Join(dir, "foo"), filepath.Join(dir, "bar")
+	if err := os.WriteFile(foo, contents, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Update(foo, &Metadata{}); err != nil {
+		t.Fatalf("Update(%q) failed: %v", foo, err)
+	}
+	if err := c.Delete(bar); err != nil {
+		t.Fatalf("Delete(%q) failed: %v", bar, err)
+	}
+	if err := c.DeletePrefix(dir); err != nil {
+		t.Fatalf("DeletePrefix(%q) failed: %v", dir, err)
+	}
+	c.Reset()
+	// Reading entries does not invalidate them.
+	c.Get(foo)
+	if diff := cmp.Diff([]string{foo, bar, dir, ""}, got); diff != "" {
+		t.Errorf("hook calls gave diff (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,11 @@
+package filemetadata
+
+import "os"
+
+// File IDs are not available from os.FileInfo on Windows, so only the other fields of fileState
+// detect changes.
+
+// inode returns 0.
+func inode(fi os.FileInfo) uint64 {
+	return 0
+}
@@ -0,0 +1,193 @@
+package filemetadata
+
+import (
+	"container/list"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// fileState is the state of a file that its metadata is valid for. If any of it changes, the
+// file is taken as modified.
+type fileState struct {
+	mtime time.Time
+	size  int64
+	mode  os.FileMode
+	inode uint64
+	// target is the state of the target of a symlink, if any.
+	target *fileState
+}
+
+// statFile returns the state of a file, and of its target if it is a symlink.
+func statFile(path string) (*fileState, error) {
+	fi, err := os.Lstat(path)
+	if err != nil {
+		return nil, err
+	}
+	st := newFileState(fi)
+	if fi.Mode()&os.ModeSymlink != 0 {
+		tfi, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		st.target = newFileState(tfi)
+	}
+	return st, nil
+}
+
+func newFileState(fi os.FileInfo) *fileState {
+	return &fileState{mtime: fi.ModTime(), size: fi.Size(), mode: fi.Mode(), inode: inode(fi)}
+}
+
+func (s *fileState) equal(o *fileState) bool {
+	if s == nil || o == nil {
+		return s == o
+	}
+	return s.mtime.Equal(o.mtime) && s.size == o.size && s.mode == o.mode && s.inode == o.inode && s.target.equal(o.target)
+}
+
+type lruEntry struct {
+	path  string
+	state *fileState
+	md    *Metadata
+}
+
+// lruCache is a Cache of a bounded number of entries, which are validated against the state of
+// their file on every Get.
+type lruCache struct {
+	maxEntries int
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	// order has the most recently used entry first.
+	order *list.List
+
+	cacheHits   uint64
+	cacheMisses uint64
+}
+
+// NewLRUCache returns an in-memory cache of the metadata of at most maxEntries files, evicting the
+// least recently used ones, or of any number of files if maxEntries is not positive. Unlike the
+// cache of NewSingleFlightCache, it recomputes the metadata of a file whose modification time,
+// size, mode or inode changed, following symlinks, so that it can be kept across builds. Build
+// systems that already track the state of files can implement Cache instead.
+func NewLRUCache(maxEntries int) InvalidatingCache {
+	return &lruCache{
+		maxEntries: maxEntries,
+		entries:    make(map[string]*list.Element),
+		order:      list.New(),
+	}
+}
+
+// Get retrieves the metadata of the file with the given filename, from cache if the file did not
+// change, or else by computing it. Errors are not cached.
+func (c *lruCache) Get(filename string) *Metadata {
+	abs, err := filepath.Abs(filename)
+	if err != nil {
+		return &Metadata{Err: err}
+	}
+	st, err := statFile(abs)
+	if err == nil {
+		c.mu.Lock()
+		if el, ok := c.entries[abs]; ok {
+			if e := el.Value.(*lruEntry); e.state.equal(st) {
+				c.order.MoveToFront(el)
+				c.mu.Unlock()
+				atomic.AddUint64(&c.cacheHits, 1)
+				return e.md
+			}
+		}
+		c.mu.Unlock()
+	}
+	atomic.AddUint64(&c.cacheMisses, 1)
+	md := Compute(abs)
+	if err != nil || md.Err != nil {
+		c.Delete(abs)
+		return md
+	}
+	c.store(abs, st, md)
+	return md
+}
+
+// Delete deletes an entry from cache.
+func (c *lruCache) Delete(filename string) error {
+	abs, err := filepath.Abs(filename)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[abs]; ok {
+		c.order.Remove(el)
+		delete(c.entries, abs)
+	}
+	return nil
+}
+
+// DeletePrefix deletes the entries of a directory and of the files under it.
+func (c *lruCache) DeletePrefix(dir string) error {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for path, el := range c.entries {
+		if underDir(path, abs) {
+			c.order.Remove(el)
+			delete(c.entries, path)
+		}
+	}
+	return nil
+}
+
+// Reset deletes all the entries.
+func (c *lruCache) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[string]*list.Element)
+	c.order.Init()
+}
+
+// Update updates the cache entry for the filename with the given value, valid for the current
+// state of the file.
+func (c *lruCache) Update(filename string, cacheEntry *Metadata) error {
+	abs, err := filepath.Abs(filename)
+	if err != nil {
+		return err
+	}
+	st, err := statFile(abs)
+	if err != nil {
+		return err
+	}
+	c.store(abs, st, cacheEntry)
+	return nil
+}
+
+// GetCacheHits returns the number of cache hits.
+func (c *lruCache) GetCacheHits() uint64 {
+	return atomic.LoadUint64(&c.cacheHits)
+}
+
+// GetCacheMisses returns the number of cache misses.
+func (c *lruCache) GetCacheMisses() uint64 {
+	return atomic.LoadUint64(&c.cacheMisses)
+}
+
+func (c *lruCache) store(path string, st *fileState, md *Metadata) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[path]; ok {
+		el.Value = &lruEntry{path: path, state: st, md: md}
+		c.order.MoveToFront(el)
+		return
+	}
+	c.entries[path] = c.order.PushFront(&lruEntry{path: path, state: st, md: md})
+	if c.maxEntries > 0 && c.order.Len() > c.maxEntries {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*lruEntry).path)
+	}
+}
@@ -0,0 +1,158 @@
+package filemetadata
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/digest"
+)
+
+func TestLRUCacheValidation(t *testing.T) {
+	c := NewLRUCache(0)
+	filename := filepath.Join(t.TempDir(), "f")
+	if err := os.WriteFile(filename, contents, 0644); err != nil {
+		t.Fatalf("Failed to write to tmp file for testing digests: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if got := c.Get(filename); got.Err != nil || got.Digest != wantDg {
+			t.Errorf("Get(%v) = %v, want digest %v", filename, got, wantDg)
+		}
+	}
+	if c.GetCacheHits() != 1 || c.GetCacheMisses() != 1 {
+		t.Errorf("Cache has %d hits and %d misses, want 1 and 1", c.GetCacheHits(), c.GetCacheMisses())
+	}
+
+	// Same size, different modification time.
+	changed := []byte("Example")
+	if err := os.WriteFile(filename, changed, 0644); err != nil {
+		t.Fatalf("Failed to write to tmp file for testing digests: %v", err)
+	}
+	mtime := time.Now().Add(time.Hour)
+	if err := os.Chtimes(filename, mtime, mtime); err != nil {
+		t.Fatalf("Failed to change the times of the tmp file: %v", err)
+	}
+	if got, want := c.Get(filename), digest.NewFromBlob(changed); got.Err != nil || got.Digest != want {
+		t.Errorf("Get(%v) after a change = %v, want digest %v", filename, got, want)
+	}
+
+	// The file is replaced by a symlink to another file.
+	target := filepath.Join(t.TempDir(), "target")
+	if err := os.WriteFile(target, []byte("target contents"), 0644); err != nil {
+		t.Fatalf("Failed to write to tmp file for testing digests: %v", err)
+	}
+	if err := os.Remove(filename); err != nil {
+		t.Fatalf("Failed to remove the tmp file: %v", err)
+	}
+	if err := os.Symlink(target, filename); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+	if got, want := c.Get(filename), digest.NewFromBlob([]byte("target contents")); got.Err != nil || got.Digest != want {
+		t.Errorf("Get(%v) after replacing it by a symlink = %v, want digest %v", filename, got, want)
+	}
+
+	if err := os.Remove(filename); err != nil {
+		t.Fatalf("Failed to remove the symlink: %v", err)
+	}
+	if got := c.Get(filename); got.Err == nil {
+		t.Errorf("Get(%v) of a deleted file = %v, want error", filename, got)
+	}
+}
+
+func TestLRUCacheEviction(t *testing.T) {
+	c := NewLRUCache(2)
+	dir := t.TempDir()
+	var files []string
+	for _, name := range []string{"a", "b", "c"} {
+		filename := filepath.Join(dir, name)
+		if err := os.WriteFile(filename, contents, 0644); err != nil {
+			t.Fatalf("Failed to write to tmp file for testing digests: %v", err)
+		}
+		files = append(files, filename)
+	}
+	// a and b are cached, then a is used, so that c evicts b.
+	c.Get(files[0])
+	c.Get(files[1])
+	c.Get(files[0])
+	c.Get(files[2])
+	if c.GetCacheHits() != 1 || c.GetCacheMisses() != 3 {
+		t.Fatalf("Cache has %d hits and %d misses, want 1 and 3", c.GetCacheHits(), c.GetCacheMisses())
+	}
+	c.Get(files[0])
+	if c.GetCacheHits() != 2 {
+		t.Errorf("Get(%v) was not a cache hit after evicting the least recently used entry", files[0])
+	}
+	c.Get(files[1])
+	if c.GetCacheMisses() != 4 {
+		t.Errorf("Get(%v) was a cache hit, want it evicted", files[1])
+	}
+}
+
+func TestLRUCacheUpdate(t *testing.T) {
+	c := NewLRUCache(0)
+	filename := filepath.Join(t.TempDir(), "f")
+	if err := os.WriteFile(filename, contents, 0644); err != nil {
+		t.Fatalf("Failed to write to tmp file for testing digests: %v", err)
+	}
+	want := &Metadata{Digest: digest.NewFromBlob([]byte("tracked by the build system"))}
+	if err := c.Update(filename, want); err != nil {
+		t.Fatalf("Update(%v) failed: %v", filename, err)
+	}
+	if got := c.Get(filename); got != want {
+		t.Errorf("Get(%v) = %v, want the updated %v", filename, got, want)
+	}
+	if err := c.Delete(filename); err != nil {
+		t.Fatalf("Delete(%v) failed: %v", filename, err)
+	}
+	if got := c.Get(filename); got.Digest != wantDg {
+		t.Errorf("Get(%v) after Delete = %v, want digest %v", filename, got, wantDg)
+	}
+}
+
+func TestCacheDeletePrefix(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"a":      filepath.Join(dir, "a"),
+		"sub/b":  filepath.Join(dir, "sub", "b"),
+		"sub2/c": filepath.Join(dir, "sub2", "c"),
+	}
+	for _, f := range files {
+		if err := os.MkdirAll(filepath.Dir(f), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(f, contents, 0644); err != nil {
+			t.Fatalf("Failed to write to tmp file for testing digests: %v", err)
+		}
+	}
+	marker := &Metadata{Digest: digest.NewFromBlob([]byte("marker"))}
+	for name, c := range map[string]InvalidatingCache{
+		"singleflight": NewSingleFlightCache(),
+		"lru":          NewLRUCache(0),
+	} {
+		t.Run(name, func(t *testing.T) {
+			for _, f := range files {
+				if err := c.Update(f, marker); err != nil {
+					t.Fatalf("Update(%v) failed: %v", f, err)
+				}
+			}
+			if err := c.DeletePrefix(filepath.Join(dir, "sub")); err != nil {
+				t.Fatalf("DeletePrefix() failed: %v", err)
+			}
+			// Deleted entries are recomputed, the others are the markers.
+			wantDeleted := map[string]bool{"sub/b": true}
+			for name, f := range files {
+				if got := c.Get(f); (got != marker) != wantDeleted[name] {
+					t.Errorf("Get(%v) = %v, want deleted %v", f, got, wantDeleted[name])
+				}
+			}
+			c.Reset()
+			for _, f := range files {
+				if got := c.Get(f); got == marker {
+					t.Errorf("Get(%v) after Reset() returned the stale entry", f)
+				}
+			}
+		})
+	}
+	ResetGlobalCache()
+}
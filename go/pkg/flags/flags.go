@@ -4,14 +4,18 @@ package flags
 import (
 	"context"
 	"flag"
+	"fmt"
 	"time"
 
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/balancer"
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/client"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/digest"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/filemetadata"
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/moreflag"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/keepalive"
 
+	repb "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
 	log "github.com/golang/glog"
 )
 
@@ -52,6 +56,24 @@ var (
 	Instance = flag.String("instance", "", "The instance ID to target when calling remote execution via gRPC (e.g., projects/$PROJECT/instances/default_instance for Google RBE).")
 	// CASConcurrency specifies the maximum number of concurrent upload & download RPCs that can be in flight.
 	CASConcurrency = flag.Int("cas_concurrency", client.DefaultCASConcurrency, "Num concurrent upload / download RPCs that the SDK is allowed to do.")
+	// MaxConcurrentUploads overrides CASConcurrency for uploads, if positive.
+	MaxConcurrentUploads = flag.Int("max_concurrent_uploads", 0, "Num concurrent upload RPCs that the SDK is allowed to do. Defaults to --cas_concurrency.")
+	// MaxConcurrentDownloads overrides CASConcurrency for downloads, if positive.
+	MaxConcurrentDownloads = flag.Int("max_concurrent_downloads", 0, "Num concurrent download RPCs that the SDK is allowed to do. Defaults to --cas_concurrency.")
+	// MaxConcurrentRPCs limits the number of concurrent RPCs of all services, if positive.
+	MaxConcurrentRPCs = flag.Int("max_concurrent_rpcs", 0, "Num concurrent RPCs of all services that the SDK is allowed to do. 0 means no limit.")
+	// UploadBandwidth limits the bytes per second sent on ByteStream uploads, if positive.
+	UploadBandwidth = flag.Int64("upload_bandwidth", 0, "Maximum bytes per second sent on ByteStream uploads. 0 means no limit.")
+	// DownloadBandwidth limits the bytes per second received on ByteStream downloads, if positive.
+	DownloadBandwidth = flag.Int64("download_bandwidth", 0, "Maximum bytes per second received on ByteStream downloads. 0 means no limit.")
+	// DownloadMemoryBudget limits the bytes of blobs held in memory by file downloads, if positive.
+	DownloadMemoryBudget = flag.Int64("download_memory_budget", 0, "Maximum bytes of blobs held in memory by file downloads, beyond which blobs are streamed to their files. 0 means no limit.")
+	// MissingBlobsCacheTTL is how long blobs found missing from the CAS are remembered, if positive.
+	MissingBlobsCacheTTL = flag.Duration("missing_blobs_cache_ttl", 0, "How long blobs found missing from the CAS are reported as missing without querying it again. 0 disables the cache.")
+	// LocalCASDir is the local content-addressed directory outputs are downloaded to, if set.
+	LocalCASDir = flag.String("local_cas_dir", "", "Local directory to keep downloaded blobs in, and make output files from. Outputs shared by downloads are then downloaded once.")
+	// LocalCASMaterialization is how outputs are made from the blobs of --local_cas_dir.
+	LocalCASMaterialization = flag.String("local_cas_materialization", "hardlink", "How outputs are made from the blobs of --local_cas_dir: hardlink, copy or clone.")
 	// MaxConcurrentRequests denotes the maximum number of concurrent RPCs on a single gRPC connection.
 	MaxConcurrentRequests = flag.Uint("max_concurrent_requests_per_conn", client.DefaultMaxConcurrentRequests, "Maximum number of concurrent RPCs on a single gRPC connection.")
 	// MaxConcurrentStreams denotes the maximum number of concurrent stream RPCs on a single gRPC connection.
@@ -66,8 +88,26 @@ var (
 	TLSClientAuthKey = flag.String("tls_client_auth_key", "", "Key to use when using mTLS to connect to the RBE service.")
 	// StartupCapabilities specifies whether to self-configure based on remote server capabilities on startup.
 	StartupCapabilities = flag.Bool("startup_capabilities", true, "Whether to self-configure based on remote server capabilities on startup.")
+	// BytestreamOnly specifies whether to avoid the batch CAS RPCs and GetTree, for servers that do not implement them.
+	BytestreamOnly = flag.Bool("bytestream_only", false, "If true, transfer blobs only with the ByteStream API and do not call the batch CAS RPCs or GetTree, for servers that do not implement them. The client also falls back to this mode when the server reports them as unimplemented.")
+	// DigestFunction is the name of the digest function to use, such as SHA256 or SHA256TREE.
+	DigestFunction = flag.String("digest_function", "SHA256", "The digest function to use, one of SHA256, SHA256TREE, SHA1, MD5, SHA384 and SHA512. The server must support it.")
+	// NegotiateDigestFunction is whether to switch to the digest function the server requires.
+	NegotiateDigestFunction = flag.Bool("negotiate_digest_function", false, "Whether to switch from --digest_function to the digest function the server requires, if it differs, rather than failing.")
+	// DigestMmapThreshold is the size from which files are digested by memory-mapping them.
+	DigestMmapThreshold = flag.Int64("digest_mmap_threshold", 0, "If positive, the size in bytes from which files are digested by memory-mapping them rather than reading them, on Linux.")
+	// XattrDigestName is the name of the extended attribute to read file digests from.
+	XattrDigestName = flag.String("xattr_digest_name", "", "If set, the name of the extended attribute holding the digests of files, such as user.digest.sha256, for file systems that already know them. Files without it are hashed.")
+	// CompressedBytestreamThreshold is the minimum size of the blobs compressed with zstd.
+	CompressedBytestreamThreshold = flag.Int64("compressed_bytestream_threshold", client.DefaultCompressedBytestreamThreshold, "The minimum size in bytes of the blobs read and written compressed with zstd over ByteStream. -1 disables compression, 0 compresses all blobs. The server must support zstd, unless --negotiate_compression is set.")
+	// NegotiateCompression is whether to disable compression if the server does not support it.
+	NegotiateCompression = flag.Bool("negotiate_compression", false, "Whether to disable compression if the server does not support zstd, rather than failing.")
+	// NetrcFile is a .netrc file with the login and password for the service host.
+	NetrcFile = flag.String("netrc_file", "", "A .netrc file whose entry for the service host holds the login and password to authenticate with, using basic authentication. Used instead of --credential_file, --use_application_default_credentials and --use_gce_credentials.")
 	// RPCTimeouts stores the per-RPC timeout values.
 	RPCTimeouts map[string]string
+	// RPCHeaders stores the static headers attached to every RPC.
+	RPCHeaders map[string]string
 	// KeepAliveTime specifies gRPCs keepalive time parameter.
 	KeepAliveTime = flag.Duration("grpc_keepalive_time", 0*time.Second, "After a duration of this time if the client doesn't see any activity it pings the server to see if the transport is still alive. If zero or not set, the mechanism is off.")
 	// KeepAliveTimeout specifies gRPCs keepalive timeout parameter.
@@ -84,12 +124,69 @@ func init() {
 	// themselves with every RPC, otherwise it is easy to accidentally enforce a timeout on
 	// WaitExecution, for example.
 	flag.Var((*moreflag.StringMapValue)(&RPCTimeouts), "rpc_timeouts", "Comma-separated key value pairs in the form rpc_name=timeout. The key for default RPC is named default. 0 indicates no timeout. Example: GetActionResult=500ms,Execute=0,default=10s.")
+	// RPCHeaders stores the static headers attached to every RPC, for servers that authenticate
+	// with API keys rather than OAuth or mTLS.
+	flag.Var((*moreflag.StringMapValue)(&RPCHeaders), "rpc_headers", "Comma-separated key value pairs in the form header=value, attached to every RPC, such as API keys. Use with --service_no_auth to authenticate with the headers only. Example: x-api-key=secret.")
+}
+
+var localCASMaterializations = map[string]client.Materialization{
+	"hardlink": client.HardlinkMaterialization,
+	"copy":     client.CopyMaterialization,
+	"clone":    client.CloneMaterialization,
 }
 
 // NewClientFromFlags connects to a remote execution service and returns a client suitable for higher-level
 // functionality. It uses the flags from above to configure the connection to remote execution.
 func NewClientFromFlags(ctx context.Context, opts ...client.Opt) (*client.Client, error) {
-	opts = append(opts, []client.Opt{client.CASConcurrency(*CASConcurrency), client.StartupCapabilities(*StartupCapabilities)}...)
+	fn, ok := repb.DigestFunction_Value_value[*DigestFunction]
+	if !ok {
+		return nil, fmt.Errorf("unknown digest function %q", *DigestFunction)
+	}
+	opts = append(opts, []client.Opt{client.DigestFunction(fn), client.CASConcurrency(*CASConcurrency), client.StartupCapabilities(*StartupCapabilities)}...)
+	if *MaxConcurrentUploads > 0 {
+		opts = append(opts, client.MaxConcurrentUploads(*MaxConcurrentUploads))
+	}
+	if *MaxConcurrentDownloads > 0 {
+		opts = append(opts, client.MaxConcurrentDownloads(*MaxConcurrentDownloads))
+	}
+	if *MaxConcurrentRPCs > 0 {
+		opts = append(opts, client.MaxConcurrentRPCs(*MaxConcurrentRPCs))
+	}
+	if *UploadBandwidth > 0 {
+		opts = append(opts, client.UploadBandwidth(*UploadBandwidth))
+	}
+	if *DownloadBandwidth > 0 {
+		opts = append(opts, client.DownloadBandwidth(*DownloadBandwidth))
+	}
+	if *DownloadMemoryBudget > 0 {
+		opts = append(opts, client.DownloadMemoryBudget(*DownloadMemoryBudget))
+	}
+	if *MissingBlobsCacheTTL > 0 {
+		opts = append(opts, client.MissingBlobsCacheTTL(*MissingBlobsCacheTTL))
+	}
+	if *LocalCASDir != "" {
+		m, ok := localCASMaterializations[*LocalCASMaterialization]
+		if !ok {
+			return nil, fmt.Errorf("unknown local CAS materialization %q", *LocalCASMaterialization)
+		}
+		opts = append(opts, &client.LocalCAS{Dir: *LocalCASDir, Materialize: m})
+	}
+	digest.MmapThreshold = *DigestMmapThreshold
+	if *XattrDigestName != "" {
+		filemetadata.XattrDigestName = *XattrDigestName
+	}
+	if *CompressedBytestreamThreshold != client.DefaultCompressedBytestreamThreshold {
+		opts = append(opts, client.CompressedBytestreamThreshold(*CompressedBytestreamThreshold))
+	}
+	if *NegotiateCompression {
+		opts = append(opts, client.NegotiateCompression(true))
+	}
+	if *NegotiateDigestFunction {
+		opts = append(opts, client.NegotiateDigestFunction(true))
+	}
+	if *BytestreamOnly {
+		opts = append(opts, client.BytestreamOnly(true))
+	}
 	if len(RPCTimeouts) > 0 {
 		timeouts := make(map[string]time.Duration)
 		for rpc, d := range client.DefaultRPCTimeouts {
@@ -133,6 +230,8 @@ func NewClientFromFlags(ctx context.Context, opts ...client.Opt) (*client.Client
 		NoAuth:                *ServiceNoAuth,
 		CASService:            *CASService,
 		CredFile:              *CredFile,
+		NetrcFile:             *NetrcFile,
+		Headers:               RPCHeaders,
 		DialOpts:              dialOpts,
 		UseApplicationDefault: *UseApplicationDefaultCreds,
 		UseComputeEngine:      *UseGCECredentials,
@@ -0,0 +1,348 @@
+// Package httpcache provides a client for remote caches speaking Bazel's HTTP cache protocol, in
+// which blobs are stored as /cas/<hash> and serialized ActionResults as /ac/<hash> entries that are
+// read with GET and written with PUT.
+//
+// The Client methods mirror the blob and action cache methods of the gRPC client, so that users with
+// only an HTTP cache can still build trees and handle results with the rest of the SDK.
+package httpcache
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/client"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/digest"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/uploadinfo"
+	"golang.org/x/sync/errgroup"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	repb "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+)
+
+const (
+	// DefaultConcurrency is the default maximum number of concurrent requests.
+	DefaultConcurrency = 50
+
+	casPrefix = "cas"
+	acPrefix  = "ac"
+)
+
+// Client is a client of a remote cache speaking Bazel's HTTP cache protocol.
+type Client struct {
+	// BaseURL is the URL under which the cache's /ac/ and /cas/ entries are located.
+	BaseURL string
+	// HTTPClient is used to make the requests. It is responsible for authentication, if the cache
+	// requires any.
+	HTTPClient *http.Client
+	// Concurrency is the maximum number of concurrent requests made by a single method call.
+	Concurrency int
+	// DigestFunction is the digest function the blobs are digested with, the default one if zero.
+	// With Storage, it must be that of the gRPC client, see client.Client.DigestFunction.
+	DigestFunction digest.Function
+}
+
+// New returns a client for the HTTP cache at baseURL, using the default HTTP client.
+func New(baseURL string) *Client {
+	return &Client{
+		BaseURL:     strings.TrimSuffix(baseURL, "/"),
+		HTTPClient:  http.DefaultClient,
+		Concurrency: DefaultConcurrency,
+	}
+}
+
+func (c *Client) url(prefix string, dg digest.Digest) string {
+	return fmt.Sprintf("%s/%s/%s", c.BaseURL, prefix, dg.Hash)
+}
+
+// statusError converts an unsuccessful HTTP response to a gRPC status error, so that callers can
+// handle errors the same way as with the gRPC client.
+func statusError(method, url string, resp *http.Response) error {
+	code := codes.Unknown
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		code = codes.NotFound
+	case resp.StatusCode == http.StatusUnauthorized:
+		code = codes.Unauthenticated
+	case resp.StatusCode == http.StatusForbidden:
+		code = codes.PermissionDenied
+	case resp.StatusCode == http.StatusRequestEntityTooLarge:
+		code = codes.ResourceExhausted
+	case resp.StatusCode >= 500:
+		code = codes.Unavailable
+	}
+	return status.Errorf(code, "%s %s: %s", method, url, resp.Status)
+}
+
+func (c *Client) do(ctx context.Context, method, url string, body io.Reader, size int64) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, status.Errorf(codes.Unavailable, "%s %s: %v", method, url, err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		resp.Body.Close()
+		return nil, statusError(method, url, resp)
+	}
+	return resp, nil
+}
+
+func (c *Client) get(ctx context.Context, prefix string, dg digest.Digest) ([]byte, error) {
+	resp, err := c.do(ctx, http.MethodGet, c.url(prefix, dg), nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(resp.Body)
+}
+
+func (c *Client) put(ctx context.Context, prefix string, dg digest.Digest, body io.Reader) error {
+	resp, err := c.do(ctx, http.MethodPut, c.url(prefix, dg), body, dg.Size)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (c *Client) newGroup(ctx context.Context) (*errgroup.Group, context.Context) {
+	eg, eCtx := errgroup.WithContext(ctx)
+	if c.Concurrency > 0 {
+		eg.SetLimit(c.Concurrency)
+	}
+	return eg, eCtx
+}
+
+// MissingBlobs queries the cache to determine if it has the specified blobs.
+// Returns a slice of missing blobs.
+func (c *Client) MissingBlobs(ctx context.Context, digests []digest.Digest) ([]digest.Digest, error) {
+	var missing []digest.Digest
+	var mu sync.Mutex
+	eg, eCtx := c.newGroup(ctx)
+	for _, dg := range digests {
+		dg := dg
+		if dg.IsEmpty() {
+			continue
+		}
+		eg.Go(func() error {
+			resp, err := c.do(eCtx, http.MethodHead, c.url(casPrefix, dg), nil, 0)
+			if status.Code(err) == codes.NotFound {
+				mu.Lock()
+				missing = append(missing, dg)
+				mu.Unlock()
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			resp.Body.Close()
+			return nil
+		})
+	}
+	err := eg.Wait()
+	return missing, err
+}
+
+// UploadIfMissing writes the missing blobs from those specified to the cache.
+//
+// The blobs are first matched against existing ones and only the missing blobs are written.
+// Returns a slice of missing digests that were written and the sum of total bytes moved.
+func (c *Client) UploadIfMissing(ctx context.Context, entries ...*uploadinfo.Entry) ([]digest.Digest, int64, error) {
+	byDigest := make(map[digest.Digest]*uploadinfo.Entry)
+	var dgs []digest.Digest
+	for _, ue := range entries {
+		if _, ok := byDigest[ue.Digest]; ok {
+			continue
+		}
+		byDigest[ue.Digest] = ue
+		dgs = append(dgs, ue.Digest)
+	}
+	missing, err := c.MissingBlobs(ctx, dgs)
+	if err != nil {
+		return nil, 0, err
+	}
+	var moved int64
+	var mu sync.Mutex
+	eg, eCtx := c.newGroup(ctx)
+	for _, dg := range missing {
+		ue := byDigest[dg]
+		eg.Go(func() error {
+			if err := c.writeEntry(eCtx, ue); err != nil {
+				return err
+			}
+			mu.Lock()
+			moved += ue.Digest.Size
+			mu.Unlock()
+			return nil
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return nil, moved, err
+	}
+	return missing, moved, nil
+}
+
+func (c *Client) writeEntry(ctx context.Context, ue *uploadinfo.Entry) error {
+	if ue.IsBlob() {
+		return c.put(ctx, casPrefix, ue.Digest, bytes.NewReader(ue.Contents))
+	}
+	if ue.IsVirtualFile() {
+		return fmt.Errorf("virtual file %s with digest %v is missing from the cache", ue.Path, ue.Digest)
+	}
+	f, err := ue.Open()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return c.put(ctx, casPrefix, ue.Digest, f)
+}
+
+// WriteBlob (over)writes a blob to the cache regardless if it already exists.
+func (c *Client) WriteBlob(ctx context.Context, blob []byte) (digest.Digest, error) {
+	dg := c.DigestFunction.NewFromBlob(blob)
+	if dg.IsEmpty() {
+		return dg, nil
+	}
+	return dg, c.put(ctx, casPrefix, dg, bytes.NewReader(blob))
+}
+
+// WriteProto is a proxy method for WriteBlob that allows specifying a proto to write.
+func (c *Client) WriteProto(ctx context.Context, msg proto.Message) (digest.Digest, error) {
+	bytes, err := proto.Marshal(msg)
+	if err != nil {
+		return digest.Empty, err
+	}
+	return c.WriteBlob(ctx, bytes)
+}
+
+// BatchWriteBlobs (over)writes specified blobs to the cache, regardless if they already exist.
+// The HTTP protocol has no batch operations, so the blobs are written concurrently.
+func (c *Client) BatchWriteBlobs(ctx context.Context, blobs map[digest.Digest][]byte) error {
+	eg, eCtx := c.newGroup(ctx)
+	for dg, blob := range blobs {
+		dg, blob := dg, blob
+		if dg.IsEmpty() {
+			continue
+		}
+		eg.Go(func() error {
+			return c.put(eCtx, casPrefix, dg, bytes.NewReader(blob))
+		})
+	}
+	return eg.Wait()
+}
+
+// ReadBlob fetches a blob from the cache into a byte slice, and verifies its digest.
+// Returns the size of the blob and the amount of bytes moved through the wire.
+func (c *Client) ReadBlob(ctx context.Context, d digest.Digest) ([]byte, *client.MovedBytesMetadata, error) {
+	stats := &client.MovedBytesMetadata{Requested: d.Size}
+	if d.IsEmpty() {
+		return nil, stats, nil
+	}
+	blob, err := c.get(ctx, casPrefix, d)
+	if err != nil {
+		return nil, stats, err
+	}
+	stats.LogicalMoved = int64(len(blob))
+	stats.RealMoved = int64(len(blob))
+	if int64(len(blob)) != d.Size {
+		return nil, stats, fmt.Errorf("blob %v has unexpected size %d", d, len(blob))
+	}
+	if dg := c.DigestFunction.NewFromBlob(blob); dg != d {
+		return nil, stats, fmt.Errorf("calculated digest %s != expected digest %s", dg, d)
+	}
+	return blob, stats, nil
+}
+
+// ReadProto reads a blob from the cache and unmarshals it into the given message.
+// Returns the size of the proto and the amount of bytes moved through the wire.
+func (c *Client) ReadProto(ctx context.Context, d digest.Digest, msg proto.Message) (*client.MovedBytesMetadata, error) {
+	bytes, stats, err := c.ReadBlob(ctx, d)
+	if err != nil {
+		return stats, err
+	}
+	return stats, proto.Unmarshal(bytes, msg)
+}
+
+// BatchDownloadBlobs downloads a number of blobs from the cache to memory. The HTTP protocol has no
+// batch operations, so the blobs are read concurrently.
+func (c *Client) BatchDownloadBlobs(ctx context.Context, dgs []digest.Digest) (map[digest.Digest][]byte, error) {
+	res := make(map[digest.Digest][]byte)
+	var mu sync.Mutex
+	eg, eCtx := c.newGroup(ctx)
+	for _, dg := range dgs {
+		dg := dg
+		eg.Go(func() error {
+			blob, _, err := c.ReadBlob(eCtx, dg)
+			if err != nil {
+				return err
+			}
+			mu.Lock()
+			res[dg] = blob
+			mu.Unlock()
+			return nil
+		})
+	}
+	err := eg.Wait()
+	return res, err
+}
+
+// GetActionResult reads the result of an action from the action cache. It returns a NotFound error
+// if the cache has no result for the action.
+func (c *Client) GetActionResult(ctx context.Context, req *repb.GetActionResultRequest) (*repb.ActionResult, error) {
+	dg, err := digest.NewFromProto(req.GetActionDigest())
+	if err != nil {
+		return nil, err
+	}
+	blob, err := c.get(ctx, acPrefix, dg)
+	if err != nil {
+		return nil, err
+	}
+	res := &repb.ActionResult{}
+	if err := proto.Unmarshal(blob, res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// CheckActionCache queries the action cache, returning an ActionResult or nil if it doesn't exist.
+func (c *Client) CheckActionCache(ctx context.Context, acDg *repb.Digest) (*repb.ActionResult, error) {
+	res, err := c.GetActionResult(ctx, &repb.GetActionResultRequest{ActionDigest: acDg})
+	switch status.Code(err) {
+	case codes.OK:
+		return res, nil
+	case codes.NotFound:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("checking the action cache: %w", err)
+	}
+}
+
+// UpdateActionResult writes the result of an action to the action cache.
+func (c *Client) UpdateActionResult(ctx context.Context, req *repb.UpdateActionResultRequest) (*repb.ActionResult, error) {
+	dg, err := digest.NewFromProto(req.GetActionDigest())
+	if err != nil {
+		return nil, err
+	}
+	blob, err := proto.Marshal(req.GetActionResult())
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.do(ctx, http.MethodPut, c.url(acPrefix, dg), bytes.NewReader(blob), int64(len(blob)))
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return req.GetActionResult(), nil
+}
@@ -0,0 +1,206 @@
+package httpcache
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/digest"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/fakes"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/uploadinfo"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/testing/protocmp"
+
+	repb "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+)
+
+// fakeCache is an in-memory HTTP cache server.
+type fakeCache struct {
+	mu      sync.Mutex
+	entries map[string][]byte
+	puts    int
+}
+
+func newFakeCache(t *testing.T) (*fakeCache, *Client) {
+	t.Helper()
+	f := &fakeCache{entries: make(map[string][]byte)}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	return f, New(srv.URL + "/")
+}
+
+func (f *fakeCache) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key := strings.TrimPrefix(r.URL.Path, "/")
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		blob, ok := f.entries[key]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Method == http.MethodGet {
+			w.Write(blob)
+		}
+	case http.MethodPut:
+		blob, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.entries[key] = blob
+		f.puts++
+	default:
+		http.Error(w, "bad method", http.StatusMethodNotAllowed)
+	}
+}
+
+func TestBlobs(t *testing.T) {
+	ctx := context.Background()
+	f, c := newFakeCache(t)
+	fooDg, err := c.WriteBlob(ctx, []byte("foo"))
+	if err != nil {
+		t.Fatalf("WriteBlob() failed: %v", err)
+	}
+	if got := string(f.entries["cas/"+fooDg.Hash]); got != "foo" {
+		t.Errorf("WriteBlob() stored %q, want %q", got, "foo")
+	}
+	barPath := filepath.Join(t.TempDir(), "bar")
+	if err := os.WriteFile(barPath, []byte("bar"), 0644); err != nil {
+		t.Fatalf("failed to write %s: %v", barPath, err)
+	}
+	barDg := digest.NewFromBlob([]byte("bar"))
+	entries := []*uploadinfo.Entry{
+		uploadinfo.EntryFromBlob([]byte("foo")),
+		uploadinfo.EntryFromFile(barDg, barPath),
+		uploadinfo.EntryFromFile(barDg, barPath),
+	}
+
+	missing, moved, err := c.UploadIfMissing(ctx, entries...)
+
+	if err != nil {
+		t.Fatalf("UploadIfMissing() failed: %v", err)
+	}
+	if diff := cmp.Diff([]digest.Digest{barDg}, missing); diff != "" {
+		t.Errorf("UploadIfMissing() gave missing diff (-want +got):\n%s", diff)
+	}
+	if moved != 3 {
+		t.Errorf("UploadIfMissing() moved %d bytes, want 3", moved)
+	}
+	if f.puts != 2 {
+		t.Errorf("cache got %d writes, want 2", f.puts)
+	}
+	got, err := c.BatchDownloadBlobs(ctx, []digest.Digest{fooDg, barDg, digest.Empty})
+	if err != nil {
+		t.Fatalf("BatchDownloadBlobs() failed: %v", err)
+	}
+	want := map[digest.Digest][]byte{fooDg: []byte("foo"), barDg: []byte("bar"), digest.Empty: nil}
+	if diff := cmp.Diff(want, got, cmpopts.EquateEmpty()); diff != "" {
+		t.Errorf("BatchDownloadBlobs() gave diff (-want +got):\n%s", diff)
+	}
+	if _, _, err := c.ReadBlob(ctx, digest.NewFromBlob([]byte("baz"))); status.Code(err) != codes.NotFound {
+		t.Errorf("ReadBlob() of a missing blob gave error %v, want NotFound", err)
+	}
+}
+
+func TestReadBlobVerifiesDigest(t *testing.T) {
+	ctx := context.Background()
+	f, c := newFakeCache(t)
+	fooDg := digest.NewFromBlob([]byte("foo"))
+	// A corrupt blob of the right size.
+	f.entries["cas/"+fooDg.Hash] = []byte("bar")
+	if _, _, err := c.ReadBlob(ctx, fooDg); err == nil {
+		t.Errorf("ReadBlob() of a corrupt blob succeeded, want error")
+	}
+
+	df, err := digest.NewFunction(repb.DigestFunction_SHA512)
+	if err != nil {
+		t.Fatalf("NewFunction(SHA512) failed: %v", err)
+	}
+	c.DigestFunction = df
+	dg, err := c.WriteBlob(ctx, []byte("foo"))
+	if err != nil {
+		t.Fatalf("WriteBlob() failed: %v", err)
+	}
+	if dg != df.NewFromBlob([]byte("foo")) {
+		t.Errorf("WriteBlob() gave digest %v, want one with %v", dg, df)
+	}
+	if got, _, err := c.ReadBlob(ctx, dg); err != nil || string(got) != "foo" {
+		t.Errorf("ReadBlob(%v) = %q, %v, want %q", dg, got, err, "foo")
+	}
+}
+
+func TestActionCache(t *testing.T) {
+	ctx := context.Background()
+	_, c := newFakeCache(t)
+	acDg := digest.NewFromBlob([]byte("action")).ToProto()
+	res, err := c.CheckActionCache(ctx, acDg)
+	if err != nil || res != nil {
+		t.Fatalf("CheckActionCache() of a missing action = %v, %v, want nil, nil", res, err)
+	}
+	ar := &repb.ActionResult{ExitCode: 3, StdoutRaw: []byte("out")}
+	if _, err := c.UpdateActionResult(ctx, &repb.UpdateActionResultRequest{ActionDigest: acDg, ActionResult: ar}); err != nil {
+		t.Fatalf("UpdateActionResult() failed: %v", err)
+	}
+	res, err = c.CheckActionCache(ctx, acDg)
+	if err != nil {
+		t.Fatalf("CheckActionCache() failed: %v", err)
+	}
+	if diff := cmp.Diff(ar, res, protocmp.Transform()); diff != "" {
+		t.Errorf("CheckActionCache() gave diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestStorage(t *testing.T) {
+	ctx := context.Background()
+	f, hc := newFakeCache(t)
+	e, cleanup := fakes.NewTestEnv(t)
+	defer cleanup()
+	c := e.Client.GrpcClient
+	hc.Storage().Apply(c)
+
+	fooDg, err := c.WriteBlob(ctx, []byte("foo"))
+	if err != nil {
+		t.Fatalf("WriteBlob() failed: %v", err)
+	}
+	if got := string(f.entries["cas/"+fooDg.Hash]); got != "foo" {
+		t.Errorf("WriteBlob() stored %q in the HTTP cache, want %q", got, "foo")
+	}
+	if _, ok := e.Server.CAS.Get(fooDg); ok {
+		t.Errorf("blob %v was written to the remote CAS", fooDg)
+	}
+	barDg := digest.NewFromBlob([]byte("bar"))
+	got, err := c.BatchDownloadBlobs(ctx, []digest.Digest{fooDg})
+	if err != nil {
+		t.Fatalf("BatchDownloadBlobs() failed: %v", err)
+	}
+	if diff := cmp.Diff(map[digest.Digest][]byte{fooDg: []byte("foo")}, got); diff != "" {
+		t.Errorf("BatchDownloadBlobs() gave diff (-want +got):\n%s", diff)
+	}
+	if _, err := c.BatchDownloadBlobs(ctx, []digest.Digest{barDg}); err == nil {
+		t.Errorf("BatchDownloadBlobs() of a missing blob succeeded, want error")
+	}
+
+	acDg := digest.NewFromBlob([]byte("action"))
+	ar := &repb.ActionResult{ExitCode: 3}
+	if _, err := c.UpdateActionResult(ctx, &repb.UpdateActionResultRequest{ActionDigest: acDg.ToProto(), ActionResult: ar}); err != nil {
+		t.Fatalf("UpdateActionResult() failed: %v", err)
+	}
+	res, err := c.CheckActionCache(ctx, acDg.ToProto())
+	if err != nil {
+		t.Fatalf("CheckActionCache() failed: %v", err)
+	}
+	if diff := cmp.Diff(ar, res, protocmp.Transform()); diff != "" {
+		t.Errorf("CheckActionCache() gave diff (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,92 @@
+package httpcache
+
+import (
+	"bytes"
+	"context"
+	"sync"
+
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/client"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/digest"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	repb "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+)
+
+// Storage returns the cache as the storage backends of a gRPC client, so that the client builds
+// trees, uploads inputs and downloads outputs with the HTTP cache. Only execution then needs a
+// remote execution service:
+//
+//	hc.Storage().Apply(grpcClient)
+func (c *Client) Storage() *client.Storage {
+	return &client.Storage{CAS: blobStore{c}, ActionCache: actionCacheStore{c}}
+}
+
+// blobStore implements client.BatchBlobStore with the /cas/ entries of the cache.
+type blobStore struct {
+	c *Client
+}
+
+func (s blobStore) FindMissing(ctx context.Context, dgs []digest.Digest) ([]digest.Digest, error) {
+	return s.c.MissingBlobs(ctx, dgs)
+}
+
+func (s blobStore) Get(ctx context.Context, dg digest.Digest) ([]byte, error) {
+	blob, _, err := s.c.ReadBlob(ctx, dg)
+	return blob, err
+}
+
+func (s blobStore) Put(ctx context.Context, dg digest.Digest, blob []byte) error {
+	if dg.IsEmpty() {
+		return nil
+	}
+	return s.c.put(ctx, casPrefix, dg, bytes.NewReader(blob))
+}
+
+// BatchGet reads the blobs concurrently, omitting the ones the cache does not have.
+func (s blobStore) BatchGet(ctx context.Context, dgs []digest.Digest) (map[digest.Digest][]byte, error) {
+	res := make(map[digest.Digest][]byte)
+	var mu sync.Mutex
+	eg, eCtx := s.c.newGroup(ctx)
+	for _, dg := range dgs {
+		dg := dg
+		eg.Go(func() error {
+			blob, _, err := s.c.ReadBlob(eCtx, dg)
+			if status.Code(err) == codes.NotFound {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			mu.Lock()
+			res[dg] = blob
+			mu.Unlock()
+			return nil
+		})
+	}
+	err := eg.Wait()
+	return res, err
+}
+
+func (s blobStore) BatchPut(ctx context.Context, blobs map[digest.Digest][]byte) error {
+	return s.c.BatchWriteBlobs(ctx, blobs)
+}
+
+// actionCacheStore implements client.ActionCacheStore with the /ac/ entries of the cache.
+type actionCacheStore struct {
+	c *Client
+}
+
+func (s actionCacheStore) GetActionResult(ctx context.Context, acDg digest.Digest) (*repb.ActionResult, error) {
+	return s.c.GetActionResult(ctx, &repb.GetActionResultRequest{ActionDigest: acDg.ToProto()})
+}
+
+func (s actionCacheStore) UpdateActionResult(ctx context.Context, acDg digest.Digest, ar *repb.ActionResult) error {
+	_, err := s.c.UpdateActionResult(ctx, &repb.UpdateActionResultRequest{ActionDigest: acDg.ToProto(), ActionResult: ar})
+	return err
+}
+
+var (
+	_ client.BatchBlobStore   = blobStore{}
+	_ client.ActionCacheStore = actionCacheStore{}
+)
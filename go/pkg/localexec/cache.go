@@ -0,0 +1,164 @@
+package localexec
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/digest"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/uploadinfo"
+	"google.golang.org/protobuf/proto"
+
+	repb "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+)
+
+// diskCache is a CAS and action cache stored on local disk, with blobs at cas/<hash> and
+// serialized ActionResults at ac/<hash>.
+type diskCache struct {
+	dir string
+}
+
+func newDiskCache(dir string) (*diskCache, error) {
+	for _, sub := range []string{"cas", "ac"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			return nil, err
+		}
+	}
+	return &diskCache{dir: dir}, nil
+}
+
+func (d *diskCache) casPath(dg digest.Digest) string {
+	return filepath.Join(d.dir, "cas", dg.Hash)
+}
+
+func (d *diskCache) acPath(dg digest.Digest) string {
+	return filepath.Join(d.dir, "ac", dg.Hash)
+}
+
+// writeAtomically writes the contents of r to path through a temporary file, so that concurrent
+// readers never see partial entries.
+func writeAtomically(path string, r io.Reader) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// has returns whether the CAS contains the blob.
+func (d *diskCache) has(dg digest.Digest) bool {
+	if dg.IsEmpty() {
+		return true
+	}
+	_, err := os.Stat(d.casPath(dg))
+	return err == nil
+}
+
+// put stores the entry in the CAS, unless it is already present.
+func (d *diskCache) put(ue *uploadinfo.Entry) error {
+	if d.has(ue.Digest) {
+		return nil
+	}
+	if ue.IsBlob() {
+		return d.putBlob(ue.Contents)
+	}
+	if ue.IsVirtualFile() {
+		return fmt.Errorf("virtual input %s with digest %v is missing from the local cache", ue.Path, ue.Digest)
+	}
+	f, err := ue.Open()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return writeAtomically(d.casPath(ue.Digest), f)
+}
+
+func (d *diskCache) putBlob(blob []byte) error {
+	dg := digest.NewFromBlob(blob)
+	if d.has(dg) {
+		return nil
+	}
+	return writeAtomically(d.casPath(dg), bytes.NewReader(blob))
+}
+
+func (d *diskCache) putProto(msg proto.Message) (digest.Digest, error) {
+	blob, err := proto.Marshal(msg)
+	if err != nil {
+		return digest.Empty, err
+	}
+	return digest.NewFromBlob(blob), d.putBlob(blob)
+}
+
+// get reads a blob from the CAS.
+func (d *diskCache) get(dg digest.Digest) ([]byte, error) {
+	if dg.IsEmpty() {
+		return nil, nil
+	}
+	return os.ReadFile(d.casPath(dg))
+}
+
+func (d *diskCache) getProto(dg digest.Digest, msg proto.Message) error {
+	blob, err := d.get(dg)
+	if err != nil {
+		return err
+	}
+	return proto.Unmarshal(blob, msg)
+}
+
+// copyTo writes the blob to a new file at path with the given permissions.
+func (d *diskCache) copyTo(dg digest.Digest, path string, perm os.FileMode) error {
+	out, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if dg.IsEmpty() {
+		return out.Close()
+	}
+	in, err := os.Open(d.casPath(dg))
+	if err != nil {
+		out.Close()
+		return err
+	}
+	defer in.Close()
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// getActionResult returns the cached result of the action, or nil if there is none.
+func (d *diskCache) getActionResult(acDg digest.Digest) (*repb.ActionResult, error) {
+	blob, err := os.ReadFile(d.acPath(acDg))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	ar := &repb.ActionResult{}
+	if err := proto.Unmarshal(blob, ar); err != nil {
+		return nil, err
+	}
+	return ar, nil
+}
+
+func (d *diskCache) updateActionResult(acDg digest.Digest, ar *repb.ActionResult) error {
+	blob, err := proto.Marshal(ar)
+	if err != nil {
+		return err
+	}
+	return writeAtomically(d.acPath(acDg), bytes.NewReader(blob))
+}
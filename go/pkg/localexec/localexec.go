@@ -0,0 +1,398 @@
+// Package localexec provides an execution backend that runs commands entirely locally. Inputs are
+// stored in a local disk CAS and staged into a fresh sandbox directory for every execution, and
+// results are cached in a local disk action cache. It lets tools built on the SDK offer an offline
+// mode, and lets integration tests run without a server.
+package localexec
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/client"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/command"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/digest"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/filemetadata"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/outerr"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/rexec"
+	log "github.com/golang/glog"
+
+	repb "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	dpb "google.golang.org/protobuf/types/known/durationpb"
+)
+
+const (
+	regularMode    = 0644
+	executableMode = 0755
+	dirMode        = 0755
+
+	// waitDelay is how long to wait for the outputs of a command to be closed after it is killed.
+	waitDelay = time.Second
+)
+
+// Executor executes commands locally, with a local disk CAS and action cache.
+type Executor struct {
+	// CacheDir is the directory holding the local CAS and action cache.
+	CacheDir string
+	// SandboxDir is the directory under which a sandbox is created for every execution. The default
+	// directory for temporary files is used if empty.
+	SandboxDir string
+	// FileMetadataCache is used to digest inputs. A no-op cache is used if nil.
+	FileMetadataCache filemetadata.Cache
+	// TreeSymlinkOpts controls how symlinks are handled in inputs and outputs.
+	TreeSymlinkOpts *client.TreeSymlinkOpts
+}
+
+var _ rexec.Executor = (*Executor)(nil)
+
+// New returns an Executor storing its CAS and action cache in cacheDir.
+func New(cacheDir string) *Executor {
+	return &Executor{CacheDir: cacheDir}
+}
+
+// Run executes a command locally, or returns its result from the local action cache.
+func (e *Executor) Run(ctx context.Context, cmd *command.Command, opt *command.ExecutionOptions, oe outerr.OutErr) (*command.Result, *command.Metadata) {
+	meta := &command.Metadata{EventTimes: make(map[string]*command.TimeInterval)}
+	res, err := e.run(ctx, cmd, opt, oe, meta)
+	if err != nil {
+		return command.NewLocalErrorResult(err), meta
+	}
+	return res, meta
+}
+
+func (e *Executor) run(ctx context.Context, cmd *command.Command, opt *command.ExecutionOptions, oe outerr.OutErr, meta *command.Metadata) (*command.Result, error) {
+	cmd = cmd.Clone()
+	cmd.FillDefaultFieldValues()
+	if err := cmd.Validate(); err != nil {
+		return nil, err
+	}
+	dc, err := newDiskCache(e.CacheDir)
+	if err != nil {
+		return nil, err
+	}
+	tc := &client.Client{TreeSymlinkOpts: e.TreeSymlinkOpts}
+	fmc := e.FileMetadataCache
+	if fmc == nil {
+		fmc = filemetadata.NewNoopCache()
+	}
+
+	meta.EventTimes[command.EventComputeMerkleTree] = &command.TimeInterval{From: time.Now()}
+	root, inputs, stats, err := tc.ComputeMerkleTree(ctx, cmd.ExecRoot, cmd.WorkingDir, cmd.RemoteWorkingDir, cmd.InputSpec, fmc)
+	meta.EventTimes[command.EventComputeMerkleTree].To = time.Now()
+	if err != nil {
+		return nil, err
+	}
+	meta.InputFiles = stats.InputFiles
+	meta.InputDirectories = stats.InputDirectories
+	meta.TotalInputBytes = stats.TotalInputBytes
+	for _, ue := range inputs {
+		if err := dc.put(ue); err != nil {
+			return nil, err
+		}
+	}
+	cmdDg, err := dc.putProto(cmd.ToREProto(false))
+	if err != nil {
+		return nil, err
+	}
+	acPb := &repb.Action{
+		CommandDigest:   cmdDg.ToProto(),
+		InputRootDigest: root.ToProto(),
+		DoNotCache:      opt.DoNotCache,
+		Salt:            opt.Salt,
+	}
+	if cmd.Timeout > 0 {
+		acPb.Timeout = dpb.New(cmd.Timeout)
+	}
+	acDg, err := dc.putProto(acPb)
+	if err != nil {
+		return nil, err
+	}
+	meta.CommandDigest = cmdDg
+	meta.ActionDigest = acDg
+	outDir := filepath.Join(cmd.ExecRoot, cmd.WorkingDir)
+
+	if opt.AcceptCached && !opt.DoNotCache {
+		meta.EventTimes[command.EventCheckActionCache] = &command.TimeInterval{From: time.Now()}
+		ar, err := dc.getActionResult(acDg)
+		meta.EventTimes[command.EventCheckActionCache].To = time.Now()
+		if err != nil {
+			return nil, err
+		}
+		if ar != nil {
+			log.V(1).Infof("%s> Found locally cached result", cmd.Identifiers.CommandID)
+			if opt.DownloadOutErr {
+				if err := writeOutErr(dc, ar, oe); err != nil {
+					return nil, err
+				}
+			}
+			if err := e.handleOutputs(dc, tc, ar, outDir, opt, meta); err != nil {
+				return nil, err
+			}
+			res := command.NewResultFromExitCode(int(ar.ExitCode))
+			res.Status = command.CacheHitResultStatus
+			return res, nil
+		}
+	}
+
+	ar, res, err := e.execute(ctx, dc, tc, cmd, root, oe)
+	if err != nil || ar == nil {
+		return res, err
+	}
+	if res.IsOk() && !opt.DoNotCache {
+		if err := dc.updateActionResult(acDg, ar); err != nil {
+			return nil, err
+		}
+	}
+	if err := e.handleOutputs(dc, tc, ar, outDir, opt, meta); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// execute runs the command in a new sandbox staged with the input root, and stores its outputs in
+// the CAS. It returns a nil ActionResult if the command timed out.
+func (e *Executor) execute(ctx context.Context, dc *diskCache, tc *client.Client, cmd *command.Command, root digest.Digest, oe outerr.OutErr) (*repb.ActionResult, *command.Result, error) {
+	sandbox, err := os.MkdirTemp(e.SandboxDir, "localexec")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer os.RemoveAll(sandbox)
+	if err := stage(dc, root, sandbox); err != nil {
+		return nil, nil, err
+	}
+	wd := cmd.RemoteWorkingDir
+	if wd == "" {
+		wd = cmd.WorkingDir
+	}
+	outPaths := cmd.AllOutputPaths()
+	// As in remote execution, the parent directories of outputs are created before running.
+	for _, p := range outPaths {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(sandbox, wd, p)), dirMode); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	runCtx := ctx
+	if cmd.Timeout > 0 {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeout(ctx, cmd.Timeout)
+		defer cancel()
+	}
+	c := exec.CommandContext(runCtx, cmd.Args[0], cmd.Args[1:]...)
+	c.Dir = filepath.Join(sandbox, wd)
+	c.Env = environ(cmd.InputSpec.EnvironmentVariables)
+	var stdout, stderr bytes.Buffer
+	c.Stdout = io.MultiWriter(&stdout, outerr.NewOutWriter(oe))
+	c.Stderr = io.MultiWriter(&stderr, outerr.NewErrWriter(oe))
+	// Subprocesses of a killed command may keep its output open; don't wait for them indefinitely.
+	c.WaitDelay = waitDelay
+	err = c.Run()
+	if cmd.Timeout > 0 && errors.Is(runCtx.Err(), context.DeadlineExceeded) && ctx.Err() == nil {
+		return nil, command.NewTimeoutResult(), nil
+	}
+	exitCode := 0
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		exitCode = exitErr.ExitCode()
+	} else if err != nil {
+		return nil, nil, fmt.Errorf("failed to run %v: %v", cmd.Args, err)
+	}
+
+	blobs, ar, err := tc.ComputeOutputsToUploadWithNodeProperties(sandbox, wd, outPaths, filemetadata.NewNoopCache(), cmd.InputSpec.SymlinkBehavior, nil, cmd.OutputNodeProperties)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, ue := range blobs {
+		if err := dc.put(ue); err != nil {
+			return nil, nil, err
+		}
+	}
+	ar.ExitCode = int32(exitCode)
+	if stdout.Len() > 0 {
+		if err := dc.putBlob(stdout.Bytes()); err != nil {
+			return nil, nil, err
+		}
+		ar.StdoutDigest = digest.NewFromBlob(stdout.Bytes()).ToProto()
+	}
+	if stderr.Len() > 0 {
+		if err := dc.putBlob(stderr.Bytes()); err != nil {
+			return nil, nil, err
+		}
+		ar.StderrDigest = digest.NewFromBlob(stderr.Bytes()).ToProto()
+	}
+	return ar, command.NewResultFromExitCode(exitCode), nil
+}
+
+// environ returns the environment variables in the form expected by exec.Cmd. As in remote
+// execution, only the variables specified by the command are set.
+func environ(vars map[string]string) []string {
+	env := make([]string, 0, len(vars))
+	for k, v := range vars {
+		env = append(env, k+"="+v)
+	}
+	sort.Strings(env)
+	return env
+}
+
+// stage materializes the directory with digest dg from the CAS into dir.
+func stage(dc *diskCache, dg digest.Digest, dir string) error {
+	d := &repb.Directory{}
+	if err := dc.getProto(dg, d); err != nil {
+		return err
+	}
+	for _, f := range d.Files {
+		perm := os.FileMode(regularMode)
+		if f.IsExecutable {
+			perm = executableMode
+		}
+		if err := dc.copyTo(digest.NewFromProtoUnvalidated(f.Digest), filepath.Join(dir, f.Name), perm); err != nil {
+			return err
+		}
+	}
+	for _, sub := range d.Directories {
+		path := filepath.Join(dir, sub.Name)
+		if err := os.MkdirAll(path, dirMode); err != nil {
+			return err
+		}
+		if err := stage(dc, digest.NewFromProtoUnvalidated(sub.Digest), path); err != nil {
+			return err
+		}
+	}
+	for _, sl := range d.Symlinks {
+		if err := os.Symlink(sl.Target, filepath.Join(dir, sl.Name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeOutErr(dc *diskCache, ar *repb.ActionResult, oe outerr.OutErr) error {
+	if ar.StdoutDigest != nil {
+		out, err := dc.get(digest.NewFromProtoUnvalidated(ar.StdoutDigest))
+		if err != nil {
+			return err
+		}
+		oe.WriteOut(out)
+	}
+	if ar.StderrDigest != nil {
+		errOut, err := dc.get(digest.NewFromProtoUnvalidated(ar.StderrDigest))
+		if err != nil {
+			return err
+		}
+		oe.WriteErr(errOut)
+	}
+	return nil
+}
+
+// handleOutputs records the outputs of the action result in the metadata and, if requested,
+// materializes them from the CAS into outDir.
+func (e *Executor) handleOutputs(dc *diskCache, tc *client.Client, ar *repb.ActionResult, outDir string, opt *command.ExecutionOptions, meta *command.Metadata) error {
+	meta.OutputFiles = len(ar.OutputFiles) + len(ar.OutputFileSymlinks)
+	meta.OutputDirectories = len(ar.OutputDirectories) + len(ar.OutputDirectorySymlinks)
+	meta.OutputFileDigests = make(map[string]digest.Digest)
+	meta.OutputDirectoryDigests = make(map[string]digest.Digest)
+	meta.OutputSymlinks = make(map[string]string)
+	for _, f := range ar.OutputFiles {
+		dg := digest.NewFromProtoUnvalidated(f.Digest)
+		meta.OutputFileDigests[f.Path] = dg
+		meta.TotalOutputBytes += dg.Size
+	}
+	for _, d := range ar.OutputDirectories {
+		meta.OutputDirectoryDigests[d.Path] = digest.NewFromProtoUnvalidated(d.TreeDigest)
+	}
+	// As in rexec, symlinks only reported in OutputSymlinks are counted as output files.
+	dirSymlinks := make(map[string]bool)
+	for _, sl := range ar.OutputDirectorySymlinks {
+		dirSymlinks[sl.Path] = true
+	}
+	for _, sl := range ar.OutputFileSymlinks {
+		meta.OutputSymlinks[sl.Path] = sl.Target
+	}
+	for _, sl := range ar.OutputSymlinks {
+		if _, ok := meta.OutputSymlinks[sl.Path]; !ok && !dirSymlinks[sl.Path] {
+			meta.OutputFiles++
+		}
+		meta.OutputSymlinks[sl.Path] = sl.Target
+	}
+	for _, sl := range ar.OutputDirectorySymlinks {
+		meta.OutputSymlinks[sl.Path] = sl.Target
+	}
+	if ar.StdoutDigest != nil {
+		meta.StdoutDigest = digest.NewFromProtoUnvalidated(ar.StdoutDigest)
+		meta.TotalOutputBytes += meta.StdoutDigest.Size
+	}
+	if ar.StderrDigest != nil {
+		meta.StderrDigest = digest.NewFromProtoUnvalidated(ar.StderrDigest)
+		meta.TotalOutputBytes += meta.StderrDigest.Size
+	}
+	if !opt.DownloadOutputs {
+		return nil
+	}
+	meta.EventTimes[command.EventDownloadResults] = &command.TimeInterval{From: time.Now()}
+	defer func() { meta.EventTimes[command.EventDownloadResults].To = time.Now() }()
+
+	outs := make(map[string]*client.TreeOutput)
+	for _, f := range ar.OutputFiles {
+		outs[f.Path] = &client.TreeOutput{
+			Path:         f.Path,
+			Digest:       digest.NewFromProtoUnvalidated(f.Digest),
+			IsExecutable: f.IsExecutable,
+		}
+	}
+	for p, target := range meta.OutputSymlinks {
+		outs[p] = &client.TreeOutput{Path: p, SymlinkTarget: target}
+	}
+	for _, d := range ar.OutputDirectories {
+		tree := &repb.Tree{}
+		if err := dc.getProto(digest.NewFromProtoUnvalidated(d.TreeDigest), tree); err != nil {
+			return err
+		}
+		dirOuts, err := tc.FlattenTree(tree, d.Path)
+		if err != nil {
+			return err
+		}
+		// Outputs directories are replaced as a whole, not merged with existing contents.
+		if err := os.RemoveAll(filepath.Join(outDir, d.Path)); err != nil {
+			return err
+		}
+		for p, out := range dirOuts {
+			outs[p] = out
+		}
+	}
+	for _, out := range outs {
+		path := filepath.Join(outDir, out.Path)
+		if out.IsEmptyDirectory {
+			if err := os.MkdirAll(path, dirMode); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(path), dirMode); err != nil {
+			return err
+		}
+		if err := os.RemoveAll(path); err != nil {
+			return err
+		}
+		if out.SymlinkTarget != "" {
+			if err := os.Symlink(out.SymlinkTarget, path); err != nil {
+				return err
+			}
+			continue
+		}
+		perm := os.FileMode(regularMode)
+		if out.IsExecutable {
+			perm = executableMode
+		}
+		if err := dc.copyTo(out.Digest, path, perm); err != nil {
+			return err
+		}
+	}
+	return nil
+}
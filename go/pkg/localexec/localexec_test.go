@@ -0,0 +1,217 @@
+package localexec
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/command"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/digest"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/outerr"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/uploadinfo"
+	"github.com/google/go-cmp/cmp"
+
+	repb "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+)
+
+func newCommand(t *testing.T, execRoot, script string) *command.Command {
+	t.Helper()
+	return &command.Command{
+		Args:     []string{"/bin/sh", "-c", script},
+		ExecRoot: execRoot,
+		InputSpec: &command.InputSpec{
+			Inputs:               []string{"in"},
+			EnvironmentVariables: map[string]string{"PATH": os.Getenv("PATH")},
+		},
+		OutputFiles: []string{"out"},
+		OutputDirs:  []string{"dir"},
+	}
+}
+
+func TestRun(t *testing.T) {
+	ctx := context.Background()
+	execRoot := t.TempDir()
+	if err := os.WriteFile(filepath.Join(execRoot, "in"), []byte("input"), 0644); err != nil {
+		t.Fatalf("failed to write input: %v", err)
+	}
+	e := New(t.TempDir())
+	opt := command.DefaultExecutionOptions()
+	script := "cat in > out && mkdir -p dir/sub && echo nested > dir/sub/f && echo hi && echo err >&2"
+	wantOutputs := map[string]string{"out": "input", "dir/sub/f": "nested\n"}
+	checkOutputs := func() {
+		t.Helper()
+		for path, want := range wantOutputs {
+			got, err := os.ReadFile(filepath.Join(execRoot, path))
+			if err != nil {
+				t.Errorf("failed to read output %s: %v", path, err)
+			} else if string(got) != want {
+				t.Errorf("output %s = %q, want %q", path, got, want)
+			}
+		}
+	}
+
+	oe := outerr.NewRecordingOutErr()
+	res, meta := e.Run(ctx, newCommand(t, execRoot, script), opt, oe)
+	if res.Status != command.SuccessResultStatus {
+		t.Fatalf("Run() gave result %+v, want success", res)
+	}
+	if string(oe.Stdout()) != "hi\n" || string(oe.Stderr()) != "err\n" {
+		t.Errorf("Run() gave stdout %q and stderr %q, want %q and %q", oe.Stdout(), oe.Stderr(), "hi\n", "err\n")
+	}
+	checkOutputs()
+
+	for path := range wantOutputs {
+		if err := os.Remove(filepath.Join(execRoot, path)); err != nil {
+			t.Fatalf("failed to remove output %s: %v", path, err)
+		}
+	}
+	oe = outerr.NewRecordingOutErr()
+	res, cachedMeta := e.Run(ctx, newCommand(t, execRoot, script), opt, oe)
+	if res.Status != command.CacheHitResultStatus {
+		t.Fatalf("Run() gave result %+v, want a cache hit", res)
+	}
+	if cachedMeta.ActionDigest != meta.ActionDigest {
+		t.Errorf("Run() gave action digest %v for the cache hit, want %v", cachedMeta.ActionDigest, meta.ActionDigest)
+	}
+	if string(oe.Stdout()) != "hi\n" || string(oe.Stderr()) != "err\n" {
+		t.Errorf("Run() gave cached stdout %q and stderr %q, want %q and %q", oe.Stdout(), oe.Stderr(), "hi\n", "err\n")
+	}
+	checkOutputs()
+}
+
+func TestRunEnvironmentPassthrough(t *testing.T) {
+	ctx := context.Background()
+	execRoot := t.TempDir()
+	if err := os.WriteFile(filepath.Join(execRoot, "in"), []byte("input"), 0644); err != nil {
+		t.Fatalf("failed to write input: %v", err)
+	}
+	e := New(t.TempDir())
+	cmd := newCommand(t, execRoot, "echo $LOCALEXEC_TEST_VAR")
+	cmd.InputSpec.EnvironmentPassthrough = []string{"LOCALEXEC_TEST_VAR"}
+	for _, val := range []string{"first", "second"} {
+		t.Setenv("LOCALEXEC_TEST_VAR", val)
+		oe := outerr.NewRecordingOutErr()
+		if res, _ := e.Run(ctx, cmd, command.DefaultExecutionOptions(), oe); res.Status != command.SuccessResultStatus {
+			t.Fatalf("Run() gave result %+v, want success", res)
+		}
+		if got := string(oe.Stdout()); got != val+"\n" {
+			t.Errorf("Run() gave stdout %q, want %q", got, val+"\n")
+		}
+	}
+	if _, ok := cmd.InputSpec.EnvironmentVariables["LOCALEXEC_TEST_VAR"]; ok {
+		t.Errorf("Run() added the passed through variable to the environment of the command")
+	}
+}
+
+func TestRunSandboxed(t *testing.T) {
+	execRoot := t.TempDir()
+	for _, name := range []string{"in", "undeclared"} {
+		if err := os.WriteFile(filepath.Join(execRoot, name), nil, 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+	e := New(t.TempDir())
+
+	res, _ := e.Run(context.Background(), newCommand(t, execRoot, "cat undeclared"), command.DefaultExecutionOptions(), outerr.NewRecordingOutErr())
+
+	if res.Status != command.NonZeroExitResultStatus {
+		t.Errorf("Run() gave result %+v, want a non-zero exit reading an undeclared input", res)
+	}
+}
+
+func TestRunNonZeroExitNotCached(t *testing.T) {
+	execRoot := t.TempDir()
+	if err := os.WriteFile(filepath.Join(execRoot, "in"), nil, 0644); err != nil {
+		t.Fatalf("failed to write input: %v", err)
+	}
+	e := New(t.TempDir())
+	for i := 0; i < 2; i++ {
+		res, _ := e.Run(context.Background(), newCommand(t, execRoot, "exit 3"), command.DefaultExecutionOptions(), outerr.NewRecordingOutErr())
+		if res.Status != command.NonZeroExitResultStatus || res.ExitCode != 3 {
+			t.Errorf("Run() #%d gave result %+v, want exit code 3", i, res)
+		}
+	}
+}
+
+func TestRunTimeout(t *testing.T) {
+	execRoot := t.TempDir()
+	if err := os.WriteFile(filepath.Join(execRoot, "in"), nil, 0644); err != nil {
+		t.Fatalf("failed to write input: %v", err)
+	}
+	e := New(t.TempDir())
+	cmd := newCommand(t, execRoot, "sleep 10")
+	cmd.Timeout = 100 * time.Millisecond
+
+	res, _ := e.Run(context.Background(), cmd, command.DefaultExecutionOptions(), outerr.NewRecordingOutErr())
+
+	if res.Status != command.TimeoutResultStatus {
+		t.Errorf("Run() gave result %+v, want a timeout", res)
+	}
+}
+
+func TestDiskCachePutReader(t *testing.T) {
+	dc, err := newDiskCache(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to open the cache: %v", err)
+	}
+	blob := []byte("from a reader")
+	dg := digest.NewFromBlob(blob)
+	ue := uploadinfo.EntryFromReader(dg, func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(blob)), nil
+	})
+	if err := dc.put(ue); err != nil {
+		t.Fatalf("put() of a reader entry failed: %v", err)
+	}
+	got, err := dc.get(dg)
+	if err != nil {
+		t.Fatalf("get(%v) failed: %v", dg, err)
+	}
+	if string(got) != string(blob) {
+		t.Errorf("get(%v) = %q, want %q", dg, got, blob)
+	}
+}
+
+func TestRunOutputSymlinks(t *testing.T) {
+	ctx := context.Background()
+	execRoot := t.TempDir()
+	if err := os.WriteFile(filepath.Join(execRoot, "in"), nil, 0644); err != nil {
+		t.Fatalf("failed to write input: %v", err)
+	}
+	e := New(t.TempDir())
+	cmd := newCommand(t, execRoot, "true")
+	_, meta := e.Run(ctx, cmd, command.DefaultExecutionOptions(), outerr.NewRecordingOutErr())
+	dc, err := newDiskCache(e.CacheDir)
+	if err != nil {
+		t.Fatalf("failed to open the cache: %v", err)
+	}
+	// Symlinks are reported in both the deprecated and the current fields, as by v2.1 servers.
+	ar := &repb.ActionResult{
+		OutputFileSymlinks:      []*repb.OutputSymlink{{Path: "out", Target: "in"}},
+		OutputDirectorySymlinks: []*repb.OutputSymlink{{Path: "dir", Target: "."}},
+		OutputSymlinks:          []*repb.OutputSymlink{{Path: "out", Target: "in"}, {Path: "dir", Target: "."}},
+	}
+	if err := dc.updateActionResult(meta.ActionDigest, ar); err != nil {
+		t.Fatalf("failed to store the action result: %v", err)
+	}
+
+	res, meta := e.Run(ctx, newCommand(t, execRoot, "true"), command.DefaultExecutionOptions(), outerr.NewRecordingOutErr())
+
+	if res.Status != command.CacheHitResultStatus {
+		t.Fatalf("Run() gave result %+v, want a cache hit", res)
+	}
+	if diff := cmp.Diff(map[string]string{"out": "in", "dir": "."}, meta.OutputSymlinks); diff != "" {
+		t.Errorf("Run() gave output symlinks diff (-want +got):\n%s", diff)
+	}
+	if meta.OutputFiles != 1 || meta.OutputDirectories != 1 {
+		t.Errorf("Run() gave %d output files and %d directories, want 1 and 1", meta.OutputFiles, meta.OutputDirectories)
+	}
+	for path, want := range meta.OutputSymlinks {
+		if got, err := os.Readlink(filepath.Join(execRoot, path)); err != nil || got != want {
+			t.Errorf("os.Readlink(%s) = %q, %v, want %q", path, got, err, want)
+		}
+	}
+}
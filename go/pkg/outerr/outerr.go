@@ -3,8 +3,13 @@ package outerr
 
 import (
 	"bytes"
+	"fmt"
 	"io"
 	"os"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
 
 	log "github.com/golang/glog"
 )
@@ -98,3 +103,380 @@ func (e *errWriter) Write(p []byte) (int, error) {
 	e.WriteErr(p)
 	return len(p), nil
 }
+
+// Stream identifies one of the two streams of an OutErr.
+type Stream int
+
+const (
+	// Stdout is the standard output stream.
+	Stdout Stream = iota
+	// Stderr is the standard error stream.
+	Stderr
+)
+
+// String returns the tag used for the stream in interleaved logs.
+func (s Stream) String() string {
+	switch s {
+	case Stdout:
+		return "stdout"
+	case Stderr:
+		return "stderr"
+	default:
+		return fmt.Sprintf("stream(%d)", int(s))
+	}
+}
+
+// Chunk is a single write recorded by an InterleavedOutErr.
+type Chunk struct {
+	// Stream is the stream the chunk was written to.
+	Stream Stream
+	// Offset is the time elapsed between the creation of the InterleavedOutErr and the write.
+	// It is measured with the monotonic clock, so offsets never decrease across chunks.
+	Offset time.Duration
+	// Data holds the written bytes.
+	Data []byte
+}
+
+// InterleavedOutErr is an OutErr that records stdout and stderr into a single log, preserving
+// the order in which writes to either stream happened. It is safe for concurrent use.
+type InterleavedOutErr struct {
+	mu     sync.Mutex
+	start  time.Time
+	chunks []Chunk
+}
+
+// NewInterleavedOutErr initializes a new InterleavedOutErr. Offsets of recorded chunks are
+// relative to the time of this call.
+func NewInterleavedOutErr() *InterleavedOutErr {
+	return &InterleavedOutErr{start: time.Now()}
+}
+
+// WriteOut records the given bytes as a stdout chunk.
+func (o *InterleavedOutErr) WriteOut(buf []byte) {
+	o.record(Stdout, buf)
+}
+
+// WriteErr records the given bytes as a stderr chunk.
+func (o *InterleavedOutErr) WriteErr(buf []byte) {
+	o.record(Stderr, buf)
+}
+
+func (o *InterleavedOutErr) record(s Stream, buf []byte) {
+	if len(buf) == 0 {
+		return
+	}
+	// Callers are free to reuse buf after the write returns.
+	data := append([]byte(nil), buf...)
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.chunks = append(o.chunks, Chunk{Stream: s, Offset: time.Since(o.start), Data: data})
+}
+
+// Chunks returns a copy of all the chunks recorded so far, in the order they were written.
+func (o *InterleavedOutErr) Chunks() []Chunk {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return append([]Chunk(nil), o.chunks...)
+}
+
+// Stdout returns the full recorded stdout contents.
+func (o *InterleavedOutErr) Stdout() []byte {
+	return o.contents(Stdout)
+}
+
+// Stderr returns the full recorded stderr contents.
+func (o *InterleavedOutErr) Stderr() []byte {
+	return o.contents(Stderr)
+}
+
+// Combined returns the contents of both streams concatenated in the order they were written.
+func (o *InterleavedOutErr) Combined() []byte {
+	var b bytes.Buffer
+	for _, c := range o.Chunks() {
+		b.Write(c.Data)
+	}
+	return b.Bytes()
+}
+
+func (o *InterleavedOutErr) contents(s Stream) []byte {
+	var b bytes.Buffer
+	for _, c := range o.Chunks() {
+		if c.Stream == s {
+			b.Write(c.Data)
+		}
+	}
+	return b.Bytes()
+}
+
+// WriteTo writes the recorded log to w in a human readable form, one chunk per line, each
+// prefixed with its offset and stream tag, e.g.:
+//
+//	[+0.000153s] stdout: "compiling foo.cc\n"
+//
+// Chunk data is quoted so that partial lines and control characters remain visible.
+func (o *InterleavedOutErr) WriteTo(w io.Writer) (int64, error) {
+	var total int64
+	for _, c := range o.Chunks() {
+		n, err := fmt.Fprintf(w, "[+%.6fs] %v: %q\n", c.Offset.Seconds(), c.Stream, c.Data)
+		total += int64(n)
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// SanitizeMode controls how a SanitizingOutErr treats terminal escape sequences and control
+// characters. Newlines and tabs are always preserved.
+type SanitizeMode int
+
+const (
+	// Passthrough leaves the stream unmodified.
+	Passthrough SanitizeMode = iota
+	// Strip removes ANSI escape sequences, control characters and invalid UTF-8 bytes.
+	Strip
+	// Escape replaces control characters and invalid UTF-8 bytes with printable Go-style escapes,
+	// e.g. ESC is written as `\x1b`. The remainder of an escape sequence is printable and kept.
+	Escape
+)
+
+// SanitizingOutErr wraps an OutErr, removing or escaping terminal escape sequences and control
+// characters before passing the output on, so that archived logs and structured results are not
+// polluted by them. Each stream has its own mode.
+//
+// Escape sequences and UTF-8 characters split across several writes are handled correctly. A
+// UTF-8 character that is still incomplete when the stream ends is never passed on.
+type SanitizingOutErr struct {
+	oe       OutErr
+	out, err *sanitizer
+}
+
+// NewSanitizingOutErr creates a SanitizingOutErr writing to oe, using outMode for stdout and
+// errMode for stderr.
+func NewSanitizingOutErr(oe OutErr, outMode, errMode SanitizeMode) *SanitizingOutErr {
+	return &SanitizingOutErr{
+		oe:  oe,
+		out: &sanitizer{mode: outMode},
+		err: &sanitizer{mode: errMode},
+	}
+}
+
+// WriteOut sanitizes the given bytes and writes them to the stdout of the wrapped OutErr.
+func (s *SanitizingOutErr) WriteOut(buf []byte) {
+	if b := s.out.sanitize(buf); len(b) > 0 {
+		s.oe.WriteOut(b)
+	}
+}
+
+// WriteErr sanitizes the given bytes and writes them to the stderr of the wrapped OutErr.
+func (s *SanitizingOutErr) WriteErr(buf []byte) {
+	if b := s.err.sanitize(buf); len(b) > 0 {
+		s.oe.WriteErr(b)
+	}
+}
+
+// Sanitize returns a sanitized copy of a complete output, e.g. the contents of a
+// RecordingOutErr, before it is stored or serialized.
+func Sanitize(buf []byte, mode SanitizeMode) []byte {
+	s := &sanitizer{mode: mode}
+	res := s.sanitize(buf)
+	// Nothing more will be written, so trailing bytes of an incomplete character are invalid.
+	for _, b := range s.pending {
+		res = s.invalid(res, b)
+	}
+	return res
+}
+
+// Parser states for stripping escape sequences.
+const (
+	ansiText = iota
+	ansiEsc
+	ansiEscIntermediate
+	ansiCSI
+	ansiOSC
+	ansiOSCEsc
+)
+
+// sanitizer holds the sanitizing state of a single stream.
+type sanitizer struct {
+	mode SanitizeMode
+
+	mu    sync.Mutex
+	state int
+	// pending holds the leading bytes of a UTF-8 character split across writes.
+	pending []byte
+}
+
+func (s *sanitizer) sanitize(buf []byte) []byte {
+	if s.mode == Passthrough {
+		return buf
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data := buf
+	if len(s.pending) > 0 {
+		data = append(s.pending, buf...)
+		s.pending = nil
+	}
+	res := make([]byte, 0, len(data))
+	for len(data) > 0 {
+		if !utf8.FullRune(data) {
+			s.pending = append([]byte(nil), data...)
+			break
+		}
+		r, size := utf8.DecodeRune(data)
+		if r == utf8.RuneError && size == 1 {
+			res = s.invalid(res, data[0])
+		} else {
+			res = s.rune(res, r, data[:size])
+		}
+		data = data[size:]
+	}
+	return res
+}
+
+func (s *sanitizer) invalid(res []byte, b byte) []byte {
+	if s.mode == Escape {
+		return append(res, fmt.Sprintf("\\x%02x", b)...)
+	}
+	return res
+}
+
+func (s *sanitizer) rune(res []byte, r rune, raw []byte) []byte {
+	isControl := r != '\n' && r != '\t' && unicode.IsControl(r)
+	if s.mode == Escape {
+		switch {
+		case !isControl:
+			return append(res, raw...)
+		case r < utf8.RuneSelf:
+			return append(res, fmt.Sprintf("\\x%02x", r)...)
+		default:
+			return append(res, fmt.Sprintf("\\u%04x", r)...)
+		}
+	}
+	switch s.state {
+	case ansiText:
+		switch r {
+		case 0x1b:
+			s.state = ansiEsc
+		case 0x9b:
+			s.state = ansiCSI
+		case 0x9d:
+			s.state = ansiOSC
+		default:
+			if !isControl {
+				res = append(res, raw...)
+			}
+		}
+	case ansiEsc:
+		switch {
+		case r == '[':
+			s.state = ansiCSI
+		case r == ']':
+			s.state = ansiOSC
+		case r >= 0x20 && r <= 0x2f:
+			s.state = ansiEscIntermediate
+		default:
+			s.state = ansiText
+		}
+	case ansiEscIntermediate:
+		if r < 0x20 || r > 0x2f {
+			s.state = ansiText
+		}
+	case ansiCSI:
+		// Parameter and intermediate bytes are in 0x20-0x3f; anything else ends the sequence.
+		if r < 0x20 || r > 0x3f {
+			s.state = ansiText
+		}
+	case ansiOSC:
+		switch r {
+		case 0x07, 0x9c:
+			s.state = ansiText
+		case 0x1b:
+			s.state = ansiOSCEsc
+		}
+	case ansiOSCEsc:
+		s.state = ansiText
+	}
+	return res
+}
+
+// LineFunc receives a single line of output from a LineOutErr, without its line terminator.
+type LineFunc func(s Stream, line string)
+
+// LineOutErr is an OutErr that passes the output to a callback one complete line at a time, which
+// allows parsing progress of e.g. compilers or tests while the output is being streamed.
+//
+// Lines may be terminated by "\n" or "\r\n". A "\r" that is not followed by "\n" overwrites the
+// line so far, as it would on a terminal, so only the final version of a progress line is passed
+// on. Callbacks are serialized and happen in the order in which lines are completed; the callback
+// must not write to the LineOutErr itself.
+type LineOutErr struct {
+	fn LineFunc
+
+	mu       sync.Mutex
+	out, err lineBuffer
+}
+
+// lineBuffer holds the incomplete line of a single stream.
+type lineBuffer struct {
+	line bytes.Buffer
+	// cr is set if the last byte written was an unprocessed "\r".
+	cr bool
+}
+
+// NewLineOutErr creates a LineOutErr calling fn for every line.
+func NewLineOutErr(fn LineFunc) *LineOutErr {
+	return &LineOutErr{fn: fn}
+}
+
+// WriteOut passes all lines of stdout completed by the given bytes to the callback.
+func (l *LineOutErr) WriteOut(buf []byte) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.write(Stdout, &l.out, buf)
+}
+
+// WriteErr passes all lines of stderr completed by the given bytes to the callback.
+func (l *LineOutErr) WriteErr(buf []byte) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.write(Stderr, &l.err, buf)
+}
+
+// Flush passes the last, unterminated line of each stream to the callback, if there is one. It
+// should be called once all the output has been written.
+func (l *LineOutErr) Flush() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.flush(Stdout, &l.out)
+	l.flush(Stderr, &l.err)
+}
+
+func (l *LineOutErr) write(s Stream, lb *lineBuffer, buf []byte) {
+	for _, c := range buf {
+		if lb.cr {
+			lb.cr = false
+			if c != '\n' {
+				lb.line.Reset()
+			}
+		}
+		switch c {
+		case '\n':
+			l.fn(s, lb.line.String())
+			lb.line.Reset()
+		case '\r':
+			lb.cr = true
+		default:
+			lb.line.WriteByte(c)
+		}
+	}
+}
+
+func (l *LineOutErr) flush(s Stream, lb *lineBuffer) {
+	if lb.line.Len() > 0 {
+		l.fn(s, lb.line.String())
+	}
+	lb.line.Reset()
+	lb.cr = false
+}
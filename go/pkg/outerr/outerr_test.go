@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"strings"
 	"testing"
 )
 
@@ -76,3 +77,169 @@ func TestWriters(t *testing.T) {
 		t.Errorf("expected oe.Stderr() to return world, got %v", got)
 	}
 }
+
+func TestInterleavedOutErr(t *testing.T) {
+	t.Parallel()
+	o := NewInterleavedOutErr()
+	buf := []byte("out1")
+	o.WriteOut(buf)
+	copy(buf, "XXXX") // Reusing the buffer must not affect the recorded data.
+	o.WriteErr([]byte("err1"))
+	o.WriteOut(nil)
+	o.WriteOut([]byte("out2"))
+
+	chunks := o.Chunks()
+	wantStreams := []Stream{Stdout, Stderr, Stdout}
+	wantData := []string{"out1", "err1", "out2"}
+	if len(chunks) != len(wantStreams) {
+		t.Fatalf("o.Chunks() returned %d chunks, want %d", len(chunks), len(wantStreams))
+	}
+	for i, c := range chunks {
+		if c.Stream != wantStreams[i] || string(c.Data) != wantData[i] {
+			t.Errorf("chunk %d = {%v, %q}, want {%v, %q}", i, c.Stream, c.Data, wantStreams[i], wantData[i])
+		}
+		if i > 0 && c.Offset < chunks[i-1].Offset {
+			t.Errorf("chunk %d has offset %v, before previous chunk's offset %v", i, c.Offset, chunks[i-1].Offset)
+		}
+	}
+	if got := string(o.Stdout()); got != "out1out2" {
+		t.Errorf("o.Stdout() = %q, want %q", got, "out1out2")
+	}
+	if got := string(o.Stderr()); got != "err1" {
+		t.Errorf("o.Stderr() = %q, want %q", got, "err1")
+	}
+	if got := string(o.Combined()); got != "out1err1out2" {
+		t.Errorf("o.Combined() = %q, want %q", got, "out1err1out2")
+	}
+
+	var log bytes.Buffer
+	if _, err := o.WriteTo(&log); err != nil {
+		t.Fatalf("o.WriteTo() failed: %v", err)
+	}
+	lines := strings.Split(strings.TrimSuffix(log.String(), "\n"), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("o.WriteTo() wrote %d lines, want 3:\n%s", len(lines), log.String())
+	}
+	if !strings.HasSuffix(lines[1], `] stderr: "err1"`) {
+		t.Errorf("o.WriteTo() line 1 = %q, want suffix %q", lines[1], `] stderr: "err1"`)
+	}
+}
+
+func TestSanitize(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name   string
+		input  string
+		strip  string
+		escape string
+	}{
+		{
+			name:   "plain",
+			input:  "hello\tworld\n",
+			strip:  "hello\tworld\n",
+			escape: "hello\tworld\n",
+		},
+		{
+			name:   "colors",
+			input:  "\x1b[1;31merror:\x1b[0m bad\n",
+			strip:  "error: bad\n",
+			escape: `\x1b[1;31merror:\x1b[0m bad` + "\n",
+		},
+		{
+			name:   "title",
+			input:  "\x1b]0;my title\x07done\x1b]2;other\x1b\\!",
+			strip:  "done!",
+			escape: `\x1b]0;my title\x07done\x1b]2;other\x1b\!`,
+		},
+		{
+			name:   "charset",
+			input:  "\x1b(Bok",
+			strip:  "ok",
+			escape: `\x1b(Bok`,
+		},
+		{
+			name:   "progress",
+			input:  "10%\r20%\r\x00",
+			strip:  "10%20%",
+			escape: `10%\x0d20%\x0d\x00`,
+		},
+		{
+			name:   "unicode",
+			input:  "héllo \u0085\xff",
+			strip:  "héllo ",
+			escape: `h` + "é" + `llo \u0085\xff`,
+		},
+		{
+			name:   "truncated",
+			input:  "ok\xc3",
+			strip:  "ok",
+			escape: `ok\xc3`,
+		},
+	}
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			if got := string(Sanitize([]byte(tc.input), Strip)); got != tc.strip {
+				t.Errorf("Sanitize(%q, Strip) = %q, want %q", tc.input, got, tc.strip)
+			}
+			if got := string(Sanitize([]byte(tc.input), Escape)); got != tc.escape {
+				t.Errorf("Sanitize(%q, Escape) = %q, want %q", tc.input, got, tc.escape)
+			}
+			if got := string(Sanitize([]byte(tc.input), Passthrough)); got != tc.input {
+				t.Errorf("Sanitize(%q, Passthrough) = %q, want %q", tc.input, got, tc.input)
+			}
+		})
+	}
+}
+
+func TestSanitizingOutErr(t *testing.T) {
+	t.Parallel()
+	rec := NewRecordingOutErr()
+	oe := NewSanitizingOutErr(rec, Strip, Escape)
+	// Escape sequences and UTF-8 characters split across writes.
+	for _, w := range []string{"a\x1b", "[3", "1mb\xc3", "\xa9\x1b[0m"} {
+		oe.WriteOut([]byte(w))
+	}
+	oe.WriteErr([]byte("\x1b[0"))
+	oe.WriteErr([]byte("mc\xc3"))
+	oe.WriteErr([]byte("\xa9"))
+	if got, want := string(rec.Stdout()), "abé"; got != want {
+		t.Errorf("Stdout() = %q, want %q", got, want)
+	}
+	if got, want := string(rec.Stderr()), `\x1b[0mc`+"é"; got != want {
+		t.Errorf("Stderr() = %q, want %q", got, want)
+	}
+}
+
+func TestLineOutErr(t *testing.T) {
+	t.Parallel()
+	var got []string
+	oe := NewLineOutErr(func(s Stream, line string) {
+		got = append(got, s.String()+": "+line)
+	})
+	oe.WriteOut([]byte("compiling"))
+	oe.WriteErr([]byte("warning: x\nwarn"))
+	oe.WriteOut([]byte(" foo\r"))
+	oe.WriteOut([]byte("\n[1/3]\r[2/3]\r"))
+	oe.WriteOut([]byte("[3/3]\n\nlast"))
+	oe.WriteErr([]byte("ing: y\r\n"))
+	oe.Flush()
+	oe.Flush()
+	want := []string{
+		"stderr: warning: x",
+		"stdout: compiling foo",
+		"stdout: [3/3]",
+		"stdout: ",
+		"stderr: warning: y",
+		"stdout: last",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("LineOutErr produced lines %q, want %q", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("LineOutErr line %d = %q, want %q", i, got[i], want[i])
+		}
+	}
+}
@@ -34,6 +34,25 @@ type ReadSeeker interface {
 	SeekOffset(offset int64) error
 }
 
+// bufReaders are pools of *bufio.Reader by buffer size, so that the buffers of the files read one
+// after another, which are large, are reused rather than allocated for each file.
+var bufReaders sync.Map
+
+func getBufReader(r io.Reader, size int) *bufio.Reader {
+	pool, _ := bufReaders.LoadOrStore(size, &sync.Pool{})
+	if br, ok := pool.(*sync.Pool).Get().(*bufio.Reader); ok {
+		br.Reset(r)
+		return br
+	}
+	return bufio.NewReaderSize(r, size)
+}
+
+func putBufReader(br *bufio.Reader, size int) {
+	br.Reset(nil)
+	pool, _ := bufReaders.LoadOrStore(size, &sync.Pool{})
+	pool.(*sync.Pool).Put(br)
+}
+
 type fileSeeker struct {
 	reader *bufio.Reader
 
@@ -64,10 +83,18 @@ func (fio *fileSeeker) Close() (err error) {
 		err = fio.f.Close()
 	}
 	fio.f = nil
-	fio.reader = nil
+	fio.releaseReader()
 	return err
 }
 
+// releaseReader returns the buffered reader to its pool.
+func (fio *fileSeeker) releaseReader() {
+	if fio.reader != nil {
+		putBufReader(fio.reader, fio.buffSize)
+		fio.reader = nil
+	}
+}
+
 // Read implements io.Reader.
 func (fio *fileSeeker) Read(p []byte) (int, error) {
 	if !fio.IsInitialized() {
@@ -82,7 +109,7 @@ func (fio *fileSeeker) Read(p []byte) (int, error) {
 func (fio *fileSeeker) SeekOffset(offset int64) error {
 	fio.seekOffset = offset
 	fio.initialized = false
-	fio.reader = nil
+	fio.releaseReader()
 	return nil
 }
 
@@ -115,7 +142,7 @@ func (fio *fileSeeker) Initialize() error {
 	}
 
 	if fio.reader == nil {
-		fio.reader = bufio.NewReaderSize(fio.f, fio.buffSize)
+		fio.reader = getBufReader(fio.f, fio.buffSize)
 	} else {
 		fio.reader.Reset(fio.f)
 	}
@@ -123,6 +150,90 @@ func (fio *fileSeeker) Initialize() error {
 	return nil
 }
 
+type openerSeeker struct {
+	reader *bufio.Reader
+
+	rc          io.ReadCloser
+	open        func() (io.ReadCloser, error)
+	buffSize    int
+	seekOffset  int64
+	initialized bool
+}
+
+// NewReadSeeker wraps a buffered reader of the data returned by open with Seeking functionality,
+// for data that is not in a file, e.g. in an archive or produced by a process. Seeking reopens the
+// data with the next Initialize, and skips it up to the offset unless it implements io.Seeker.
+func NewReadSeeker(open func() (io.ReadCloser, error), buffsize int) ReadSeeker {
+	return &openerSeeker{
+		open:     open,
+		buffSize: buffsize,
+	}
+}
+
+// Close closes the reader. It still can be reopened with Initialize().
+func (ors *openerSeeker) Close() (err error) {
+	ors.initialized = false
+	if ors.rc != nil {
+		err = ors.rc.Close()
+	}
+	ors.rc = nil
+	if ors.reader != nil {
+		putBufReader(ors.reader, ors.buffSize)
+		ors.reader = nil
+	}
+	return err
+}
+
+// Read implements io.Reader.
+func (ors *openerSeeker) Read(p []byte) (int, error) {
+	if !ors.IsInitialized() {
+		return 0, errNotInitialized
+	}
+	return ors.reader.Read(p)
+}
+
+// SeekOffset is a simplified version of io.Seeker. It only supports offsets from the beginning of
+// the data, and it errors lazily at the next Initialize.
+func (ors *openerSeeker) SeekOffset(offset int64) error {
+	err := ors.Close()
+	ors.seekOffset = offset
+	return err
+}
+
+// IsInitialized indicates whether this reader is ready. If false, Read calls
+// will fail.
+func (ors *openerSeeker) IsInitialized() bool {
+	return ors.initialized
+}
+
+// Initialize opens the data and skips it up to the offset.
+func (ors *openerSeeker) Initialize() error {
+	if ors.initialized {
+		return errors.New("Already initialized")
+	}
+	rc, err := ors.open()
+	if err != nil {
+		return err
+	}
+	if s, ok := rc.(io.Seeker); ok {
+		_, err = s.Seek(ors.seekOffset, io.SeekStart)
+	} else if n, cerr := io.CopyN(io.Discard, rc, ors.seekOffset); cerr != nil {
+		err = fmt.Errorf("skipping to offset %d ended at %d: %w", ors.seekOffset, n, cerr)
+	}
+	if err != nil {
+		rc.Close()
+		return err
+	}
+	ors.rc = rc
+	if ors.reader == nil {
+		ors.reader = getBufReader(rc, ors.buffSize)
+	} else {
+		ors.reader.Reset(rc)
+	}
+	ors.initialized = true
+	return nil
+}
+
 // The zstd encoder lib will async write to the buffer, so we need
 // to lock access to actually check for contents.
 type syncedBuffer struct {
@@ -116,6 +116,48 @@ func TestFileReaderSeeksPastOffset(t *testing.T) {
 	}
 }
 
+func TestReaderSeeks(t *testing.T) {
+	t.Parallel()
+	blob := "1234567"
+	tests := []struct {
+		name string
+		open func() (io.ReadCloser, error)
+	}{
+		{
+			name: "seekable",
+			open: func() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader([]byte(blob))), nil },
+		},
+		{
+			name: "not seekable",
+			open: func() (io.ReadCloser, error) { return io.NopCloser(bytes.NewBufferString(blob)), nil },
+		},
+	}
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			r := NewReadSeeker(tc.open, 3)
+			defer r.Close()
+			if err := r.Initialize(); err != nil {
+				t.Fatalf("Failed to initialize reader: %v", err)
+			}
+			data := make([]byte, 3)
+			if _, err := io.ReadFull(r, data); err != nil || string(data) != "123" {
+				t.Errorf("Read() = %q, %v, want %q", data, err, "123")
+			}
+			if err := r.SeekOffset(2); err != nil {
+				t.Fatalf("SeekOffset(2) failed: %v", err)
+			}
+			if err := r.Initialize(); err != nil {
+				t.Fatalf("Failed to initialize reader: %v", err)
+			}
+			if got, err := io.ReadAll(r); err != nil || string(got) != "34567" {
+				t.Errorf("Read() after SeekOffset(2) = %q, %v, want %q", got, err, "34567")
+			}
+		})
+	}
+}
+
 func TestCompressedReader(t *testing.T) {
 	t.Parallel()
 	tests := []struct {
@@ -0,0 +1,139 @@
+package rexec
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/digest"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/filemetadata"
+	"google.golang.org/protobuf/encoding/prototext"
+
+	repb "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	log "github.com/golang/glog"
+)
+
+// FailureArtifacts configures retaining the artifacts of failed remote executions, so that
+// developers get the full context of the worker rather than only the declared outputs. For each
+// failed action, a directory named after the action digest hash is created, containing:
+//
+//	action_result.textproto  the ActionResult, if the server returned one
+//	stdout, stderr           the standard output and error of the command
+//	outputs/                 all outputs present in the ActionResult
+//	logs/                    the server logs of the execution
+type FailureArtifacts struct {
+	// Dir is the directory the per-action debug directories are created in.
+	Dir string
+	// MaxActions, if positive, is the maximum number of debug directories retained in Dir. The
+	// oldest ones are removed first.
+	MaxActions int
+}
+
+func (ec *Context) retainFailureArtifacts(resp *repb.ExecuteResponse) {
+	fa := ec.client.FailureArtifacts
+	if fa == nil || fa.Dir == "" || ec.Result == nil || ec.Result.IsOk() {
+		return
+	}
+	dir := filepath.Join(fa.Dir, ec.Metadata.ActionDigest.Hash)
+	if err := ec.saveFailureArtifacts(dir, resp); err != nil {
+		log.Warningf("%s> failed to retain failure artifacts in %s: %v", ec.cmd.Identifiers.CommandID, dir, err)
+	} else {
+		ec.Metadata.FailureArtifactsDir = dir
+	}
+	if fa.MaxActions > 0 {
+		if err := pruneDirs(fa.Dir, fa.MaxActions); err != nil {
+			log.Warningf("failed to prune failure artifacts in %s: %v", fa.Dir, err)
+		}
+	}
+}
+
+func (ec *Context) saveFailureArtifacts(dir string, resp *repb.ExecuteResponse) error {
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return err
+	}
+	if ar := resp.GetResult(); ar != nil {
+		blob, err := prototext.MarshalOptions{Multiline: true}.Marshal(ar)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(dir, "action_result.textproto"), blob, 0666); err != nil {
+			return err
+		}
+		var stdout, stderr bytes.Buffer
+		if err := ec.downloadStream(ar.StdoutRaw, ar.StdoutDigest, 0, func(b []byte) { stdout.Write(b) }); err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(dir, "stdout"), stdout.Bytes(), 0666); err != nil {
+			return err
+		}
+		if err := ec.downloadStream(ar.StderrRaw, ar.StderrDigest, 0, func(b []byte) { stderr.Write(b) }); err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(dir, "stderr"), stderr.Bytes(), 0666); err != nil {
+			return err
+		}
+		outs, err := ec.flattenActionOutputs(ar)
+		if err != nil {
+			return err
+		}
+		if _, err := ec.client.GrpcClient.DownloadOutputs(ec.ctx, outs, filepath.Join(dir, "outputs"), filemetadata.NewNoopCache()); err != nil {
+			return err
+		}
+	}
+	for name, lf := range resp.GetServerLogs() {
+		dg, err := digest.NewFromProto(lf.GetDigest())
+		if err != nil {
+			return err
+		}
+		blob, _, err := ec.client.GrpcClient.ReadBlob(ec.ctx, dg)
+		if err != nil {
+			return err
+		}
+		// Server log names are not trusted to stay within the debug directory.
+		path := filepath.Join(dir, "logs", filepath.Clean("/"+name))
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, blob, 0666); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pruneDirs removes the oldest directories in dir, so that at most max remain.
+func pruneDirs(dir string, max int) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	type dirInfo struct {
+		path string
+		info os.FileInfo
+	}
+	var dirs []dirInfo
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		dirs = append(dirs, dirInfo{filepath.Join(dir, e.Name()), info})
+	}
+	if len(dirs) <= max {
+		return nil
+	}
+	sort.Slice(dirs, func(i, j int) bool { return dirs[i].info.ModTime().After(dirs[j].info.ModTime()) })
+	for _, d := range dirs[max:] {
+		if err := os.RemoveAll(d.path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,190 @@
+package rexec
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/digest"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/filemetadata"
+	"google.golang.org/protobuf/proto"
+
+	rc "github.com/bazelbuild/remote-apis-sdks/go/pkg/client"
+	repb "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	log "github.com/golang/glog"
+)
+
+// loadDiskCachedResult returns the result of the action from the client's disk cache, if there is
+// one.
+func (ec *Context) loadDiskCachedResult() *repb.ActionResult {
+	if ec.client.DiskCache == nil {
+		return nil
+	}
+	ar, _ := ec.client.DiskCache.LoadActionCache(ec.Metadata.ActionDigest)
+	return ar
+}
+
+// dropDiskCachedResult removes the result of the action from the client's disk cache, after its
+// outputs could not be fetched, typically because the remote CAS evicted blobs that the disk cache
+// does not hold.
+func (ec *Context) dropDiskCachedResult(err error) {
+	cmdID, executionID := ec.cmd.Identifiers.ExecutionID, ec.cmd.Identifiers.CommandID
+	log.Warningf("%s %s> Dropping the disk cached result, whose outputs could not be fetched: %v", cmdID, executionID, err)
+	if err := ec.client.DiskCache.DeleteActionCache(ec.Metadata.ActionDigest); err != nil {
+		log.Warningf("%s %s> Failed to delete the result from the disk cache: %v", cmdID, executionID, err)
+	}
+}
+
+// storeDiskCachedResult stores the successful result of the action in the client's disk cache.
+// Failures are only logged, since the result was already obtained.
+func (ec *Context) storeDiskCachedResult() {
+	if ec.client.DiskCache == nil || ec.opt.DoNotCache || ec.resPb == nil || ec.resPb.ExitCode != 0 {
+		return
+	}
+	if err := ec.client.DiskCache.StoreActionCache(ec.Metadata.ActionDigest, ec.resPb); err != nil {
+		log.Warningf("%s %s> Failed to store the result in the disk cache: %v", ec.cmd.Identifiers.ExecutionID, ec.cmd.Identifiers.CommandID, err)
+	}
+}
+
+// readBlob reads the blob dg from offset, from the disk cache if it is there. Blobs read from the
+// remote CAS whole are added to the disk cache, so that the stdout, stderr and output directory
+// Trees of disk cached results remain available after the remote CAS evicts them.
+func (ec *Context) readBlob(dg digest.Digest, offset int64) ([]byte, error) {
+	dc := ec.client.DiskCache
+	if dc != nil {
+		if blob, ok := dc.LoadCasBlob(dg); ok && offset <= int64(len(blob)) {
+			return blob[offset:], nil
+		}
+	}
+	blob, stats, err := ec.client.GrpcClient.ReadBlobRange(ec.ctx, dg, offset, 0)
+	if err != nil {
+		return nil, err
+	}
+	ec.Metadata.LogicalBytesDownloaded += stats.LogicalMoved
+	ec.Metadata.RealBytesDownloaded += stats.RealMoved
+	if dc != nil && offset == 0 {
+		if err := dc.StoreCasBlob(dg, blob); err != nil {
+			log.Warningf("Failed to store blob %v in the disk cache: %v", dg, err)
+		}
+	}
+	return blob, nil
+}
+
+// flattenActionOutputs is FlattenActionOutputs of the client, with the Trees of the output
+// directories read through the disk cache.
+func (ec *Context) flattenActionOutputs(ar *repb.ActionResult) (map[string]*rc.TreeOutput, error) {
+	gc := ec.client.GrpcClient
+	if ec.client.DiskCache == nil {
+		return gc.FlattenActionOutputs(ec.ctx, ar)
+	}
+	outs, err := gc.FlattenActionOutputs(ec.ctx, &repb.ActionResult{
+		OutputFiles:             ar.OutputFiles,
+		OutputFileSymlinks:      ar.OutputFileSymlinks,
+		OutputDirectorySymlinks: ar.OutputDirectorySymlinks,
+		OutputSymlinks:          ar.OutputSymlinks,
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, dir := range ar.OutputDirectories {
+		dg := digest.NewFromProtoUnvalidated(dir.TreeDigest)
+		blob, err := ec.readBlob(dg, 0)
+		if err != nil {
+			return nil, err
+		}
+		t := &repb.Tree{}
+		if err := proto.Unmarshal(blob, t); err != nil {
+			return nil, fmt.Errorf("invalid Tree %v of output directory %s: %w", dg, dir.Path, err)
+		}
+		dirOuts, err := gc.FlattenTree(t, dir.Path)
+		if err != nil {
+			return nil, err
+		}
+		for _, out := range dirOuts {
+			outs[out.Path] = out
+		}
+	}
+	return outs, nil
+}
+
+// downloadActionOutputs downloads all the outputs of the result to outDir, through the disk cache.
+func (ec *Context) downloadActionOutputs(outDir string) (*rc.MovedBytesMetadata, error) {
+	if ec.client.DiskCache == nil {
+		return ec.client.GrpcClient.DownloadActionOutputs(ec.ctx, ec.resPb, outDir, ec.client.FileMetadataCache)
+	}
+	outs, err := ec.flattenActionOutputs(ec.resPb)
+	if err != nil {
+		return nil, err
+	}
+	if err := ec.removeOutputDirs(outDir); err != nil {
+		return nil, err
+	}
+	return ec.downloadOutputFiles(outs, outDir)
+}
+
+// removeOutputDirs removes the existing output directories of the result from outDir before a
+// download, as DownloadActionOutputs does, so that no stale files are left in them.
+func (ec *Context) removeOutputDirs(outDir string) error {
+	for _, dir := range ec.resPb.OutputDirectories {
+		if err := os.RemoveAll(filepath.Join(outDir, dir.Path)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// downloadOutputFiles downloads the outputs to outDir. With a disk cache, the output files are
+// copied from it where possible, and the ones downloaded from the remote CAS are added to it.
+func (ec *Context) downloadOutputFiles(outs map[string]*rc.TreeOutput, outDir string) (*rc.MovedBytesMetadata, error) {
+	dc, gc := ec.client.DiskCache, ec.client.GrpcClient
+	if dc == nil {
+		return gc.DownloadOutputs(ec.ctx, outs, outDir, ec.client.FileMetadataCache)
+	}
+	stats := &rc.MovedBytesMetadata{}
+	remote := make(map[string]*rc.TreeOutput)
+	for path, out := range outs {
+		if out.IsEmptyDirectory || out.SymlinkTarget != "" {
+			remote[path] = out
+			continue
+		}
+		p := filepath.Join(outDir, out.Path)
+		if err := os.MkdirAll(filepath.Dir(p), gc.DirMode); err != nil {
+			return stats, err
+		}
+		if !dc.LoadCas(out.Digest, p) {
+			remote[path] = out
+			continue
+		}
+		if err := os.Chmod(p, gc.OutputPerm(out)); err != nil {
+			return stats, err
+		}
+		md := &filemetadata.Metadata{Digest: out.Digest, IsExecutable: out.IsExecutable}
+		if err := gc.FileMetadataCache(ec.client.FileMetadataCache).Update(p, md); err != nil {
+			return stats, err
+		}
+		stats.Requested += out.Digest.Size
+		stats.Cached += out.Digest.Size
+	}
+	if len(remote) == 0 {
+		return stats, nil
+	}
+	remoteStats, err := gc.DownloadOutputs(ec.ctx, remote, outDir, ec.client.FileMetadataCache)
+	if remoteStats != nil {
+		stats.Requested += remoteStats.Requested
+		stats.LogicalMoved += remoteStats.LogicalMoved
+		stats.RealMoved += remoteStats.RealMoved
+		stats.Cached += remoteStats.Cached
+	}
+	if err != nil {
+		return stats, err
+	}
+	for _, out := range remote {
+		if out.IsEmptyDirectory || out.SymlinkTarget != "" {
+			continue
+		}
+		if err := dc.StoreCas(out.Digest, filepath.Join(outDir, out.Path)); err != nil {
+			log.Warningf("Failed to store %s in the disk cache: %v", out.Path, err)
+		}
+	}
+	return stats, nil
+}
@@ -0,0 +1,226 @@
+package rexec
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/command"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/digest"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/filemetadata"
+
+	rc "github.com/bazelbuild/remote-apis-sdks/go/pkg/client"
+)
+
+// OutputManifest records the outputs of a command that were left in the CAS rather than
+// downloaded, so that they can be fetched on demand later, e.g. by a later build step.
+type OutputManifest struct {
+	// Outputs are the outputs that were not downloaded, sorted by path.
+	Outputs []*ManifestOutput `json:"outputs"`
+}
+
+// ManifestOutput is an output recorded in an OutputManifest.
+type ManifestOutput struct {
+	// Path is the path of the output, relative to the directory outputs are downloaded to, which is
+	// normally the exec root.
+	Path string `json:"path"`
+	// Digest is the digest of the output file, including its size.
+	Digest digest.Digest `json:"digest"`
+	// IsExecutable is whether the output file is executable.
+	IsExecutable bool `json:"is_executable,omitempty"`
+	// IsEmptyDirectory is whether the output is an empty directory.
+	IsEmptyDirectory bool `json:"is_empty_directory,omitempty"`
+	// SymlinkTarget is the target of the output, if it is a symlink.
+	SymlinkTarget string `json:"symlink_target,omitempty"`
+}
+
+// ReadOutputManifest reads an output manifest written by a command execution.
+func ReadOutputManifest(path string) (*OutputManifest, error) {
+	blob, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	m := &OutputManifest{}
+	if err := json.Unmarshal(blob, m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Write writes the manifest to the file at path.
+func (m *OutputManifest) Write(path string) error {
+	blob, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, blob, 0644)
+}
+
+// Fetch downloads the outputs of the manifest that are, or are under, one of the given paths, or
+// all of them if no path is given, into execRoot.
+func (m *OutputManifest) Fetch(ctx context.Context, c *rc.Client, execRoot string, cache filemetadata.Cache, paths ...string) (*rc.MovedBytesMetadata, error) {
+	outs := make(map[string]*rc.TreeOutput)
+	for _, o := range m.Outputs {
+		if len(paths) > 0 && !underAny(o.Path, paths) {
+			continue
+		}
+		outs[o.Path] = &rc.TreeOutput{
+			Path:             o.Path,
+			Digest:           o.Digest,
+			IsExecutable:     o.IsExecutable,
+			IsEmptyDirectory: o.IsEmptyDirectory,
+			SymlinkTarget:    o.SymlinkTarget,
+		}
+	}
+	return c.DownloadOutputs(ctx, outs, execRoot, cache)
+}
+
+// underAny returns whether path is, or is under, one of the given paths.
+func underAny(path string, paths []string) bool {
+	path = filepath.ToSlash(path)
+	for _, p := range paths {
+		p = strings.TrimSuffix(filepath.ToSlash(p), "/")
+		if path == p || strings.HasPrefix(path, p+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// downloadFilter selects the outputs to download according to the MaterializeOutputs,
+// DownloadOutputRegex and DownloadExclusions execution options.
+type downloadFilter struct {
+	paths   []string
+	include *regexp.Regexp
+	exclude []*regexp.Regexp
+}
+
+func newDownloadFilter(opt *command.ExecutionOptions) (*downloadFilter, error) {
+	f := &downloadFilter{}
+	if opt == nil {
+		return f, nil
+	}
+	f.paths = opt.MaterializeOutputs
+	if opt.DownloadOutputRegex != "" {
+		re, err := regexp.Compile(opt.DownloadOutputRegex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid download output regex: %w", err)
+		}
+		f.include = re
+	}
+	for _, ex := range opt.DownloadExclusions {
+		re, err := regexp.Compile(ex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid download exclusion: %w", err)
+		}
+		f.exclude = append(f.exclude, re)
+	}
+	return f, nil
+}
+
+// active returns whether the filter may leave some outputs undownloaded.
+func (f *downloadFilter) active() bool {
+	return len(f.paths) > 0 || f.include != nil || len(f.exclude) > 0
+}
+
+// match returns whether the output at path, relative to the working directory, is downloaded.
+func (f *downloadFilter) match(path string) bool {
+	if len(f.paths) > 0 && !underAny(path, f.paths) {
+		return false
+	}
+	path = filepath.ToSlash(path)
+	if f.include != nil && !f.include.MatchString(path) {
+		return false
+	}
+	for _, re := range f.exclude {
+		if re.MatchString(path) {
+			return false
+		}
+	}
+	return true
+}
+
+// downloadMaterializedOutputs downloads the outputs selected by the download filter of the
+// execution options into outDir, and records the other ones in the output manifest, if one is requested.
+// The output paths in the manifest are relative to execRoot. Only the outputs matching the output
+// globs of the command are kept from the directories requested for them. As with all downloads,
+// the existing output directories are removed first, even if none of their outputs is downloaded.
+func (ec *Context) downloadMaterializedOutputs(execRoot, outDir string) (*rc.MovedBytesMetadata, error) {
+	outs, err := ec.flattenActionOutputs(ec.resPb)
+	if err != nil {
+		return nil, err
+	}
+	ec.filterGlobOutputs(outs)
+	rel, err := filepath.Rel(execRoot, outDir)
+	if err != nil {
+		return nil, err
+	}
+	materialized := make(map[string]*rc.TreeOutput)
+	m := &OutputManifest{}
+	for path, out := range outs {
+		if ec.downloadFilter.match(path) {
+			materialized[path] = out
+			continue
+		}
+		m.Outputs = append(m.Outputs, &ManifestOutput{
+			Path:             filepath.ToSlash(filepath.Join(rel, out.Path)),
+			Digest:           out.Digest,
+			IsExecutable:     out.IsExecutable,
+			IsEmptyDirectory: out.IsEmptyDirectory,
+			SymlinkTarget:    out.SymlinkTarget,
+		})
+	}
+	sort.Slice(m.Outputs, func(i, j int) bool { return m.Outputs[i].Path < m.Outputs[j].Path })
+	if err := ec.removeOutputDirs(outDir); err != nil {
+		return nil, err
+	}
+	stats, err := ec.downloadOutputFiles(materialized, outDir)
+	if err != nil {
+		return stats, err
+	}
+	if ec.opt.OutputManifestPath != "" {
+		if err := m.Write(ec.opt.OutputManifestPath); err != nil {
+			return stats, err
+		}
+	}
+	return stats, nil
+}
+
+// filterGlobOutputs drops the outputs under the root directories of the command's output globs
+// that match none of the globs, unless they are declared as outputs otherwise.
+func (ec *Context) filterGlobOutputs(outs map[string]*rc.TreeOutput) {
+	globs := ec.cmd.OutputGlobs()
+	if len(globs) == 0 {
+		return
+	}
+	roots := make([]string, len(globs))
+	for i, g := range globs {
+		roots[i] = command.OutputGlobRoot(g)
+	}
+	declared := append(append([]string{}, ec.cmd.OutputFiles...), ec.cmd.OutputDirs...)
+	for _, o := range ec.cmd.Outputs {
+		if o.Type != command.GlobOutputType {
+			declared = append(declared, o.Path)
+		}
+	}
+	for path := range outs {
+		if !underAny(path, roots) || underAny(path, declared) {
+			continue
+		}
+		matched := false
+		for _, g := range globs {
+			if command.MatchOutputGlob(g, filepath.ToSlash(path)) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			delete(outs, path)
+		}
+	}
+}
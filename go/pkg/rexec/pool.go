@@ -0,0 +1,260 @@
+package rexec
+
+import (
+	"context"
+	"sync"
+
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/command"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/contextmd"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/outerr"
+	"golang.org/x/sync/semaphore"
+)
+
+// Pool shares one Client, and with it the connections, server capabilities, file metadata cache
+// and CAS concurrency limits, across many logical invocations. It is meant for long-running
+// services that execute commands on behalf of many builds, which would otherwise construct a full
+// client per build.
+type Pool struct {
+	// Client is shared by all invocations of the pool.
+	Client *Client
+
+	actions *semaphore.Weighted
+	mu      sync.Mutex
+	active  map[string]*Invocation
+	qos     map[string]*qosClass
+}
+
+// Well-known quality of service classes.
+const (
+	// InteractiveQoS is the class of builds a developer is waiting for.
+	InteractiveQoS = "interactive"
+	// BatchQoS is the class of background traffic, such as CI builds.
+	BatchQoS = "batch"
+)
+
+// QoSPolicy holds the client-side defaults for the commands of a quality of service class.
+type QoSPolicy struct {
+	// MaxConcurrentActions, if positive, limits the number of commands of the class that are run at
+	// the same time across all invocations of the pool.
+	MaxConcurrentActions int
+	// Priority, if not zero, is the execution priority of commands of the class that do not set
+	// their own.
+	Priority int32
+}
+
+type qosClass struct {
+	policy  QoSPolicy
+	actions *semaphore.Weighted
+}
+
+// NewPool returns a pool sharing the given client. If maxConcurrentActions is positive, at most
+// that many commands are run at the same time across all invocations of the pool.
+func NewPool(c *Client, maxConcurrentActions int) *Pool {
+	p := &Pool{Client: c, active: make(map[string]*Invocation), qos: make(map[string]*qosClass)}
+	if maxConcurrentActions > 0 {
+		p.actions = semaphore.NewWeighted(int64(maxConcurrentActions))
+	}
+	return p
+}
+
+// SetQoSPolicy sets the policy of a quality of service class. It applies to commands started
+// afterwards.
+func (p *Pool) SetQoSPolicy(class string, policy QoSPolicy) {
+	qc := &qosClass{policy: policy}
+	if policy.MaxConcurrentActions > 0 {
+		qc.actions = semaphore.NewWeighted(int64(policy.MaxConcurrentActions))
+	}
+	p.mu.Lock()
+	p.qos[class] = qc
+	p.mu.Unlock()
+}
+
+func (p *Pool) qosClass(class string) *qosClass {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.qos[class]
+}
+
+// NewInvocation starts a logical invocation with the given invocation ID. The invocation stays
+// registered with the pool until it is closed.
+func (p *Pool) NewInvocation(id string) *Invocation {
+	inv := &Invocation{ID: id, pool: p}
+	p.mu.Lock()
+	p.active[id] = inv
+	p.mu.Unlock()
+	return inv
+}
+
+// Invocation returns the open invocation with the given ID, or nil if there is none.
+func (p *Pool) Invocation(id string) *Invocation {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.active[id]
+}
+
+// Invocations returns the IDs of the open invocations.
+func (p *Pool) Invocations() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var ids []string
+	for id := range p.active {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// Shutdown gracefully shuts down the shared client, see client.Client.Shutdown.
+func (p *Pool) Shutdown(ctx context.Context) error {
+	return p.Client.GrpcClient.Shutdown(ctx)
+}
+
+// InvocationStats are the statistics aggregated over the commands of an invocation.
+type InvocationStats struct {
+	// ParentInvocationID and Attempt identify the invocation in the invocation hierarchy, as set on
+	// the Invocation.
+	ParentInvocationID string
+	Attempt            int
+	// ActionsByPhase is the number of commands run in each build phase, for commands that have one.
+	ActionsByPhase map[string]int
+	// Actions is the number of commands run.
+	Actions int
+	// CacheHits is the number of commands whose result was served from the action cache.
+	CacheHits int
+	// Failures is the number of commands that did not succeed.
+	Failures int
+	// LogicalBytesUploaded is the sum of LogicalBytesUploaded of all commands.
+	LogicalBytesUploaded int64
+	// RealBytesUploaded is the sum of RealBytesUploaded of all commands.
+	RealBytesUploaded int64
+	// LogicalBytesDownloaded is the sum of LogicalBytesDownloaded of all commands.
+	LogicalBytesDownloaded int64
+	// RealBytesDownloaded is the sum of RealBytesDownloaded of all commands.
+	RealBytesDownloaded int64
+}
+
+// Invocation is a logical invocation, such as one build, run on the client of a Pool. All its
+// commands are tagged with its invocation ID, and their statistics are aggregated separately
+// from the other invocations.
+type Invocation struct {
+	// ID is the invocation ID commands of this invocation are tagged with.
+	ID string
+	// CorrelatedInvocationID, if set, is the correlated invocation ID commands of this invocation
+	// are tagged with.
+	CorrelatedInvocationID string
+	// QoSClass, if set, is the quality of service class all RPCs of this invocation are tagged
+	// with. The policy of the class, if the pool has one, applies to the commands.
+	QoSClass string
+	// ParentInvocationID, if set, is the ID of the invocation this one is nested in, such as the
+	// build that started this sub-build. Commands of this invocation are tagged with it.
+	ParentInvocationID string
+	// Attempt, if not zero, is the attempt number of this invocation, e.g. of a retried CI build.
+	// Commands of this invocation are tagged with it.
+	Attempt int
+
+	pool  *Pool
+	mu    sync.Mutex
+	stats InvocationStats
+}
+
+var _ Executor = (*Invocation)(nil)
+
+// Run executes a command as part of the invocation. The command is executed with its invocation
+// IDs overridden with those of the invocation; the caller's command is not modified, so that it can
+// be run again, e.g. in another invocation.
+func (inv *Invocation) Run(ctx context.Context, cmd *command.Command, opt *command.ExecutionOptions, oe outerr.OutErr) (*command.Result, *command.Metadata) {
+	if opt == nil {
+		opt = command.DefaultExecutionOptions()
+	}
+	cmd = cmd.Clone()
+	if cmd.Identifiers == nil {
+		cmd.Identifiers = &command.Identifiers{}
+	}
+	ids := cmd.Identifiers
+	ids.InvocationID = inv.ID
+	if inv.CorrelatedInvocationID != "" {
+		ids.CorrelatedInvocationID = inv.CorrelatedInvocationID
+	}
+	if inv.ParentInvocationID != "" {
+		ids.ParentInvocationID = inv.ParentInvocationID
+	}
+	if inv.Attempt != 0 {
+		ids.Attempt = inv.Attempt
+	}
+	var sems []*semaphore.Weighted
+	if inv.QoSClass != "" {
+		ctx = contextmd.WithQoSClass(ctx, inv.QoSClass)
+		if qc := inv.pool.qosClass(inv.QoSClass); qc != nil {
+			if qc.policy.Priority != 0 && opt.Priority == 0 {
+				o := *opt
+				o.Priority = qc.policy.Priority
+				opt = &o
+			}
+			if qc.actions != nil {
+				sems = append(sems, qc.actions)
+			}
+		}
+	}
+	if inv.pool.actions != nil {
+		sems = append(sems, inv.pool.actions)
+	}
+	for _, sem := range sems {
+		if err := sem.Acquire(ctx, 1); err != nil {
+			res := command.NewLocalErrorResult(err)
+			inv.record(cmd, res, &command.Metadata{})
+			return res, &command.Metadata{}
+		}
+		defer sem.Release(1)
+	}
+	res, md := inv.pool.Client.Run(ctx, cmd, opt, oe)
+	inv.record(cmd, res, md)
+	return res, md
+}
+
+func (inv *Invocation) record(cmd *command.Command, res *command.Result, md *command.Metadata) {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	inv.stats.Actions++
+	if phase := cmd.Identifiers.BuildPhase; phase != "" {
+		if inv.stats.ActionsByPhase == nil {
+			inv.stats.ActionsByPhase = make(map[string]int)
+		}
+		inv.stats.ActionsByPhase[phase]++
+	}
+	switch {
+	case res.Status == command.CacheHitResultStatus:
+		inv.stats.CacheHits++
+	case !res.IsOk():
+		inv.stats.Failures++
+	}
+	inv.stats.LogicalBytesUploaded += md.LogicalBytesUploaded
+	inv.stats.RealBytesUploaded += md.RealBytesUploaded
+	inv.stats.LogicalBytesDownloaded += md.LogicalBytesDownloaded
+	inv.stats.RealBytesDownloaded += md.RealBytesDownloaded
+}
+
+// Stats returns the statistics of the invocation so far.
+func (inv *Invocation) Stats() InvocationStats {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	stats := inv.stats
+	stats.ParentInvocationID = inv.ParentInvocationID
+	stats.Attempt = inv.Attempt
+	if inv.stats.ActionsByPhase != nil {
+		stats.ActionsByPhase = make(map[string]int, len(inv.stats.ActionsByPhase))
+		for phase, n := range inv.stats.ActionsByPhase {
+			stats.ActionsByPhase[phase] = n
+		}
+	}
+	return stats
+}
+
+// Close unregisters the invocation from its pool and returns its final statistics. The shared
+// client stays open for the other invocations.
+func (inv *Invocation) Close() InvocationStats {
+	inv.pool.mu.Lock()
+	if inv.pool.active[inv.ID] == inv {
+		delete(inv.pool.active, inv.ID)
+	}
+	inv.pool.mu.Unlock()
+	return inv.Stats()
+}
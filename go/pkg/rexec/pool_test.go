@@ -0,0 +1,154 @@
+package rexec_test
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/command"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/fakes"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/outerr"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/rexec"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestPoolInvocations(t *testing.T) {
+	e, cleanup := fakes.NewTestEnv(t)
+	defer cleanup()
+	p := rexec.NewPool(e.Client, 1)
+	build1 := p.NewInvocation("build1")
+	build2 := p.NewInvocation("build2")
+	build2.CorrelatedInvocationID = "ci"
+	if diff := cmp.Diff([]string{"build1", "build2"}, sortedStrings(p.Invocations())); diff != "" {
+		t.Errorf("Invocations() gave diff (-want +got):\n%s", diff)
+	}
+
+	cached := &command.Command{Args: []string{"cached"}, ExecRoot: e.ExecRoot}
+	cachedOpt := command.DefaultExecutionOptions()
+	e.Set(cached, cachedOpt, &command.Result{Status: command.CacheHitResultStatus}, fakes.StdOut("out"))
+	executed := &command.Command{Args: []string{"executed"}, ExecRoot: e.ExecRoot}
+	executedOpt := &command.ExecutionOptions{AcceptCached: false, DownloadOutputs: true, DownloadOutErr: true}
+	e.Set(executed, executedOpt, &command.Result{Status: command.SuccessResultStatus})
+	cachedWant := cached.Clone()
+	var mu sync.Mutex
+	ran := make(map[string]command.Identifiers)
+	e.Client.Middleware = []rexec.Middleware{func(_ context.Context, cmd *command.Command, _ *command.ExecutionOptions) error {
+		mu.Lock()
+		ran[cmd.Args[0]] = *cmd.Identifiers
+		mu.Unlock()
+		return nil
+	}}
+
+	if res, _ := build1.Run(context.Background(), cached, cachedOpt, outerr.NewRecordingOutErr()); res.Err != nil {
+		t.Fatalf("build1.Run() failed: %v", res.Err)
+	}
+	for i := 0; i < 2; i++ {
+		if res, _ := build2.Run(context.Background(), executed, executedOpt, outerr.NewRecordingOutErr()); res.Err != nil {
+			t.Fatalf("build2.Run() failed: %v", res.Err)
+		}
+	}
+
+	if got := ran["cached"]; got.InvocationID != "build1" {
+		t.Errorf("build1.Run() tagged command with invocation ID %q, want build1", got.InvocationID)
+	}
+	if got := ran["executed"]; got.InvocationID != "build2" || got.CorrelatedInvocationID != "ci" {
+		t.Errorf("build2.Run() tagged command with invocation IDs %q, %q, want build2, ci", got.InvocationID, got.CorrelatedInvocationID)
+	}
+	if diff := cmp.Diff(cachedWant, cached); diff != "" {
+		t.Errorf("build1.Run() modified the command (-want +got):\n%s", diff)
+	}
+	if got := build1.Stats(); got.Actions != 1 || got.CacheHits != 1 || got.Failures != 0 {
+		t.Errorf("build1.Stats() = %+v, want 1 action with 1 cache hit", got)
+	}
+	if got := build2.Close(); got.Actions != 2 || got.CacheHits != 0 || got.Failures != 0 {
+		t.Errorf("build2.Close() = %+v, want 2 actions with no cache hits", got)
+	}
+	if diff := cmp.Diff([]string{"build1"}, p.Invocations()); diff != "" {
+		t.Errorf("Invocations() after closing build2 gave diff (-want +got):\n%s", diff)
+	}
+	if p.Invocation("build1") != build1 {
+		t.Errorf("Invocation(build1) did not return the open invocation")
+	}
+}
+
+func sortedStrings(s []string) []string {
+	sort.Strings(s)
+	return s
+}
+
+func TestPoolQoSPolicy(t *testing.T) {
+	e, cleanup := fakes.NewTestEnv(t)
+	defer cleanup()
+	p := rexec.NewPool(e.Client, 0)
+	p.SetQoSPolicy(rexec.BatchQoS, rexec.QoSPolicy{MaxConcurrentActions: 1, Priority: 10})
+	inv := p.NewInvocation("ci")
+	inv.QoSClass = rexec.BatchQoS
+	defer inv.Close()
+
+	cmd := &command.Command{Args: []string{"tool"}, ExecRoot: e.ExecRoot}
+	opt := &command.ExecutionOptions{AcceptCached: false, DownloadOutputs: true, DownloadOutErr: true}
+	e.Set(cmd, opt, &command.Result{Status: command.SuccessResultStatus})
+	if res, _ := inv.Run(context.Background(), cmd, opt, outerr.NewRecordingOutErr()); res.Err != nil {
+		t.Fatalf("Run() failed: %v", res.Err)
+	}
+
+	if got := e.Server.Exec.LastExecuteRequest.GetExecutionPolicy().GetPriority(); got != 10 {
+		t.Errorf("Run() executed with priority %d, want 10", got)
+	}
+	if opt.Priority != 0 {
+		t.Errorf("Run() modified the passed execution options")
+	}
+	if got := e.Server.Exec.LastExecuteHeaders.Get("x-remote-qos-class"); len(got) != 1 || got[0] != rexec.BatchQoS {
+		t.Errorf("Run() sent QoS class header %v, want [%s]", got, rexec.BatchQoS)
+	}
+}
+
+func TestPoolInvocationHierarchy(t *testing.T) {
+	e, cleanup := fakes.NewTestEnv(t)
+	defer cleanup()
+	p := rexec.NewPool(e.Client, 0)
+	inv := p.NewInvocation("sub-build")
+	inv.ParentInvocationID = "build"
+	inv.Attempt = 2
+	defer inv.Close()
+
+	cmd := &command.Command{Args: []string{"tool"}, ExecRoot: e.ExecRoot, Identifiers: &command.Identifiers{BuildPhase: "test"}}
+	opt := &command.ExecutionOptions{AcceptCached: false, DownloadOutputs: true, DownloadOutErr: true}
+	e.Set(cmd, opt, &command.Result{Status: command.SuccessResultStatus})
+	if res, _ := inv.Run(context.Background(), cmd, opt, outerr.NewRecordingOutErr()); res.Err != nil {
+		t.Fatalf("Run() failed: %v", res.Err)
+	}
+
+	headers := e.Server.Exec.LastExecuteHeaders
+	for key, want := range map[string]string{
+		"x-remote-parent-invocation-id": "build",
+		"x-remote-attempt":              "2",
+		"x-remote-build-phase":          "test",
+	} {
+		if got := headers.Get(key); len(got) != 1 || got[0] != want {
+			t.Errorf("Run() sent %s header %v, want [%s]", key, got, want)
+		}
+	}
+	want := rexec.InvocationStats{ParentInvocationID: "build", Attempt: 2, ActionsByPhase: map[string]int{"test": 1}}
+	if diff := cmp.Diff(want, inv.Stats(), cmpopts.IgnoreFields(rexec.InvocationStats{}, "Actions", "LogicalBytesUploaded", "RealBytesUploaded", "LogicalBytesDownloaded", "RealBytesDownloaded")); diff != "" {
+		t.Errorf("Stats() gave diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestPoolRunDefaultOptions(t *testing.T) {
+	e, cleanup := fakes.NewTestEnv(t)
+	defer cleanup()
+	p := rexec.NewPool(e.Client, 0)
+	p.SetQoSPolicy(rexec.BatchQoS, rexec.QoSPolicy{Priority: 10})
+	inv := p.NewInvocation("ci")
+	inv.QoSClass = rexec.BatchQoS
+	defer inv.Close()
+
+	cmd := &command.Command{Args: []string{"tool"}, ExecRoot: e.ExecRoot}
+	e.Set(cmd, command.DefaultExecutionOptions(), &command.Result{Status: command.SuccessResultStatus})
+	if res, _ := inv.Run(context.Background(), cmd, nil, outerr.NewRecordingOutErr()); res.Err != nil {
+		t.Fatalf("Run() with nil options failed: %v", res.Err)
+	}
+}
@@ -0,0 +1,139 @@
+package rexec
+
+import (
+	"context"
+	"time"
+
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/command"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/outerr"
+
+	log "github.com/golang/glog"
+)
+
+// Racer executes each command on two remote execution backends, such as a primary and an
+// overflow cluster, takes the first completed result and cancels the other execution. This trades
+// the duplicate work for tail latency when a backend is queueing or has slow workers.
+type Racer struct {
+	// Primary is the backend the command is submitted to first.
+	Primary *Client
+	// Secondary is the backend the command is raced on.
+	Secondary *Client
+	// Delay is the time to wait for the primary before also submitting the command to the
+	// secondary, so that only the slow executions are duplicated. The command is submitted to the
+	// secondary right away if the primary fails before then. Zero submits to both at once.
+	Delay time.Duration
+}
+
+// raceRun is the execution of a command on one of the raced backends.
+type raceRun struct {
+	ec     *Context
+	oe     *outerr.RecordingOutErr
+	cancel context.CancelFunc
+	name   string
+}
+
+// completed returns whether the run produced a result that ends the race. Remote and local
+// errors do not, so that the other backend gets the chance to complete the command.
+func (r *raceRun) completed() bool {
+	st := r.ec.Result.Status
+	return st != command.RemoteErrorResultStatus && st != command.LocalErrorResultStatus
+}
+
+// Run executes a command remotely on both backends and returns the first completed result. The
+// outputs and the stdout and stderr of the winning execution only are written.
+func (r *Racer) Run(ctx context.Context, cmd *command.Command, opt *command.ExecutionOptions, oe outerr.OutErr) (*command.Result, *command.Metadata) {
+	if opt == nil {
+		opt = command.DefaultExecutionOptions()
+	}
+	// The losing execution must not write the outputs, so they are downloaded for the winner only.
+	raceOpt := *opt
+	raceOpt.DownloadOutputs = false
+	// Both runs share the identifiers, but not the caller's command.
+	cmd = cmd.Clone()
+	cmd.FillDefaultFieldValues()
+	primary, err := r.newRun(ctx, r.Primary, cmd, &raceOpt, "primary")
+	if err != nil {
+		return command.NewLocalErrorResult(err), &command.Metadata{}
+	}
+	defer primary.cancel()
+	secondary, err := r.newRun(ctx, r.Secondary, cmd, &raceOpt, "secondary")
+	if err != nil {
+		return command.NewLocalErrorResult(err), &command.Metadata{}
+	}
+	defer secondary.cancel()
+
+	done := make(chan *raceRun, 2)
+	go primary.run(done)
+	var timer <-chan time.Time
+	if r.Delay > 0 {
+		t := time.NewTimer(r.Delay)
+		defer t.Stop()
+		timer = t.C
+	} else {
+		go secondary.run(done)
+	}
+
+	var winner *raceRun
+	running := 1
+	if timer == nil {
+		running = 2
+	}
+	for running > 0 && winner == nil {
+		select {
+		case <-timer:
+			timer = nil
+			running++
+			go secondary.run(done)
+		case run := <-done:
+			running--
+			if run.completed() {
+				winner = run
+			} else if run == primary && timer != nil {
+				log.V(1).Infof("%s %s> Primary backend failed, submitting to the secondary: %v", cmd.Identifiers.CommandID, cmd.Identifiers.ExecutionID, run.ec.Result.Err)
+				timer = nil
+				running++
+				go secondary.run(done)
+			}
+		}
+	}
+	if winner == nil {
+		// Neither backend completed the command: report the primary's error.
+		return primary.ec.Result, primary.ec.Metadata
+	}
+	if winner == primary {
+		secondary.cancel()
+	} else {
+		primary.cancel()
+	}
+	log.V(1).Infof("%s %s> The %s backend won the race", cmd.Identifiers.CommandID, cmd.Identifiers.ExecutionID, winner.name)
+	ec := winner.ec
+	oe.WriteOut(winner.oe.Stdout())
+	oe.WriteErr(winner.oe.Stderr())
+	if opt.DownloadOutputs && ec.Result.Err == nil {
+		ec.DownloadOutputs(cmd.ExecRoot)
+	}
+	return ec.Result, ec.Metadata
+}
+
+// newRun prepares the execution of a deep copy of cmd on c, since preparing the command applies the
+// client's defaults to it, e.g. to its Identifiers and Platform.
+func (r *Racer) newRun(ctx context.Context, c *Client, cmd *command.Command, opt *command.ExecutionOptions, name string) (*raceRun, error) {
+	cmdCopy := cmd.Clone()
+	ctx, cancel := context.WithCancel(ctx)
+	oe := outerr.NewRecordingOutErr()
+	ec, err := c.NewContext(ctx, cmdCopy, opt, oe)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	return &raceRun{ec: ec, oe: oe, cancel: cancel, name: name}, nil
+}
+
+// run executes the command, checking the backend's cache first, and reports to done.
+func (r *raceRun) run(done chan<- *raceRun) {
+	r.ec.GetCachedResult()
+	if r.ec.Result == nil {
+		r.ec.ExecuteRemotely()
+	}
+	done <- r
+}
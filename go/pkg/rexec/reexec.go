@@ -0,0 +1,74 @@
+package rexec
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/command"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/outerr"
+
+	log "github.com/golang/glog"
+)
+
+// ReexecPolicy configures executing actions again after failures that signal a transient
+// infrastructure problem rather than a problem with the command, such as a lost worker or a
+// command killed for running out of memory.
+type ReexecPolicy struct {
+	// MaxRetries is the maximum number of times an action is executed again.
+	MaxRetries int
+	// ExitCodes are the exit codes that signal a transient failure, e.g. 137 for a command killed
+	// by the OOM killer.
+	ExitCodes []int
+	// MessagePatterns are matched against the error of failed executions and against the message
+	// the server returned with the result, e.g. `worker lost` or `OOM`.
+	MessagePatterns []*regexp.Regexp
+}
+
+// retryReason returns why the result of an execution should be retried, or "" if it should not.
+func (p *ReexecPolicy) retryReason(res *command.Result, message string) string {
+	if res == nil || res.IsOk() {
+		return ""
+	}
+	if res.Status == command.NonZeroExitResultStatus {
+		for _, code := range p.ExitCodes {
+			if res.ExitCode == code {
+				return fmt.Sprintf("exit code %d", code)
+			}
+		}
+	}
+	if res.Status != command.NonZeroExitResultStatus && res.Status != command.RemoteErrorResultStatus {
+		return ""
+	}
+	for _, re := range p.MessagePatterns {
+		if res.Err != nil && re.MatchString(res.Err.Error()) || message != "" && re.MatchString(message) {
+			return fmt.Sprintf("message matching %q", re)
+		}
+	}
+	return ""
+}
+
+// executeRemotelyWithRetries executes the command remotely, then executes it again, skipping the
+// cache lookup, as long as the client's ReexecPolicy classifies the failure as transient. Only the
+// standard output and error of the last execution are written to the OutErr.
+func (ec *Context) executeRemotelyWithRetries() {
+	p := ec.client.ReexecPolicy
+	oe := ec.oe
+	defer func() { ec.oe = oe }()
+	for attempt := 0; ; attempt++ {
+		rec := outerr.NewRecordingOutErr()
+		ec.oe = rec
+		ec.execMessage = ""
+		ec.ExecuteRemotely()
+		reason := p.retryReason(ec.Result, ec.execMessage)
+		if reason == "" || attempt >= p.MaxRetries || ec.ctx.Err() != nil {
+			oe.WriteOut(rec.Stdout())
+			oe.WriteErr(rec.Stderr())
+			return
+		}
+		log.Warningf("%s %s> Executing again after a transient failure (%s): %+v", ec.cmd.Identifiers.CommandID, ec.cmd.Identifiers.ExecutionID, reason, ec.Result)
+		ec.Metadata.Reexecutions = append(ec.Metadata.Reexecutions, &command.Reexecution{Result: ec.Result, Reason: reason})
+		opt := *ec.opt
+		opt.AcceptCached = false
+		ec.opt = &opt
+	}
+}
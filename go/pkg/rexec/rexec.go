@@ -3,6 +3,7 @@ package rexec
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"path/filepath"
 	"strings"
@@ -11,6 +12,7 @@ import (
 
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/command"
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/digest"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/diskcache"
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/filemetadata"
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/outerr"
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/symlinkopts"
@@ -27,12 +29,56 @@ import (
 	tspb "google.golang.org/protobuf/types/known/timestamppb"
 )
 
+// Executor executes commands. It is implemented by the remote execution Client and Router, as well
+// as by alternative backends, so that tools can switch between them.
+type Executor interface {
+	// Run executes a command and returns its result and metadata.
+	Run(ctx context.Context, cmd *command.Command, opt *command.ExecutionOptions, oe outerr.OutErr) (*command.Result, *command.Metadata)
+}
+
+var (
+	_ Executor = (*Client)(nil)
+	_ Executor = (*Router)(nil)
+	_ Executor = (*Racer)(nil)
+)
+
 // Client is a remote execution client.
 type Client struct {
 	FileMetadataCache filemetadata.Cache
 	GrpcClient        *rc.Client
+	// DefaultPlatform holds platform properties, such as the worker pool or container image, that
+	// are added to every Command executed by this client. Properties set on the Command take
+	// precedence over these.
+	DefaultPlatform map[string]string
+	// OutputValidator, if set, is called for every successful result before it is cached or
+	// returned.
+	OutputValidator OutputValidator
+	// Middleware is applied in order to every command before it is digested and executed.
+	Middleware []Middleware
+	// FailureArtifacts, if set, retains the artifacts of failed remote executions for debugging.
+	FailureArtifacts *FailureArtifacts
+	// ReexecPolicy, if set, executes actions again after transient failures.
+	ReexecPolicy *ReexecPolicy
+	// SpeculativeUpload, if set, uploads the inputs of actions while their cache lookup is in flight.
+	SpeculativeUpload *SpeculativeUpload
+	// DiskCache, if set, is a local cache of action results and output blobs consulted before the
+	// remote cache, and populated with the successful results of remote cache hits and executions.
+	DiskCache *diskcache.DiskCache
 }
 
+// Middleware inspects and may modify a command, including its platform, and its execution
+// options before the command is digested and executed, e.g. to inject organization-wide
+// environment variables or rewrite container images. The command's default field values are
+// already filled. Returning an error fails the command with a local error.
+type Middleware func(ctx context.Context, cmd *command.Command, opt *command.ExecutionOptions) error
+
+// OutputValidator is called after a command ran successfully, with its ActionResult and the exec
+// root the outputs were downloaded to, or "" if they were not downloaded. It may modify the
+// ActionResult and the downloaded outputs, e.g. to strip nondeterministic sections, or return an
+// error to reject the result, e.g. if a required output is empty. Rejected results are not
+// cached, and the command fails with a local error.
+type OutputValidator func(ctx context.Context, cmd *command.Command, ar *repb.ActionResult, execRoot string) error
+
 // Context allows more granular control over various stages of command execution.
 // At any point, any errors that occurred will be stored in the Result.
 type Context struct {
@@ -44,16 +90,44 @@ type Context struct {
 	inputBlobs  []*uploadinfo.Entry
 	cmdUe, acUe *uploadinfo.Entry
 	resPb       *repb.ActionResult
+	// The message the server returned with the last execution result.
+	execMessage string
+	// The input upload started along with the cache lookup, if any.
+	specUpload *speculativeUpload
+	// Selects the outputs to download.
+	downloadFilter *downloadFilter
 	// The metadata of the current execution.
 	Metadata *command.Metadata
 	// The result of the current execution, if available.
 	Result *command.Result
 }
 
-// NewContext starts a new Context for a given command.
-func (c *Client) NewContext(ctx context.Context, cmd *command.Command, opt *command.ExecutionOptions, oe outerr.OutErr) (*Context, error) {
+// PrepareCommand merges the client's default platform into the command, fills its default field
+// values, applies the client's middleware and validates it. All of this happens on copies of the
+// command and execution options, so that the caller's command is not modified and can be prepared
+// again, e.g. when it is re-executed. The command and options to execute are returned.
+func (c *Client) PrepareCommand(ctx context.Context, cmd *command.Command, opt *command.ExecutionOptions) (*command.Command, *command.ExecutionOptions, error) {
+	cmd = cmd.Clone().WithDefaultPlatform(c.DefaultPlatform)
 	cmd.FillDefaultFieldValues()
+	if len(c.Middleware) > 0 && opt != nil {
+		o := *opt
+		opt = &o
+		for _, m := range c.Middleware {
+			if err := m(ctx, cmd, opt); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
 	if err := cmd.Validate(); err != nil {
+		return nil, nil, err
+	}
+	return cmd, opt, nil
+}
+
+// NewContext starts a new Context for a given command.
+func (c *Client) NewContext(ctx context.Context, cmd *command.Command, opt *command.ExecutionOptions, oe outerr.OutErr) (*Context, error) {
+	cmd, opt, err := c.PrepareCommand(ctx, cmd, opt)
+	if err != nil {
 		return nil, err
 	}
 	grpcCtx, err := contextmd.WithMetadata(ctx, &contextmd.Metadata{
@@ -62,17 +136,26 @@ func (c *Client) NewContext(ctx context.Context, cmd *command.Command, opt *comm
 		ActionID:               cmd.Identifiers.CommandID,
 		InvocationID:           cmd.Identifiers.InvocationID,
 		CorrelatedInvocationID: cmd.Identifiers.CorrelatedInvocationID,
+		ParentInvocationID:     cmd.Identifiers.ParentInvocationID,
+		Attempt:                cmd.Identifiers.Attempt,
+		BuildPhase:             cmd.Identifiers.BuildPhase,
+		Labels:                 cmd.Identifiers.Labels,
 	})
 	if err != nil {
 		return nil, err
 	}
+	df, err := newDownloadFilter(opt)
+	if err != nil {
+		return nil, err
+	}
 	return &Context{
-		ctx:      grpcCtx,
-		cmd:      cmd,
-		opt:      opt,
-		oe:       oe,
-		client:   c,
-		Metadata: &command.Metadata{EventTimes: make(map[string]*command.TimeInterval)},
+		ctx:            grpcCtx,
+		cmd:            cmd,
+		opt:            opt,
+		oe:             oe,
+		client:         c,
+		downloadFilter: df,
+		Metadata:       &command.Metadata{EventTimes: make(map[string]*command.TimeInterval)},
 	}, nil
 }
 
@@ -89,12 +172,10 @@ func (ec *Context) downloadStream(raw []byte, dgPb *repb.Digest, offset int64, w
 		if err != nil {
 			return err
 		}
-		bytes, stats, err := ec.client.GrpcClient.ReadBlobRange(ec.ctx, dg, offset, 0)
+		bytes, err := ec.readBlob(dg, offset)
 		if err != nil {
 			return err
 		}
-		ec.Metadata.LogicalBytesDownloaded += stats.LogicalMoved
-		ec.Metadata.RealBytesDownloaded += stats.RealMoved
 		write(bytes)
 	}
 	return nil
@@ -123,6 +204,18 @@ func (ec *Context) setOutputMetadata() {
 	for _, sl := range ec.resPb.OutputFileSymlinks {
 		ec.Metadata.OutputSymlinks[sl.Path] = sl.Target
 	}
+	// Symlinks reported by v2.1+ servers may duplicate the deprecated fields above. Since their
+	// type is not known, symlinks only reported here are counted as output files.
+	dirSymlinks := make(map[string]bool)
+	for _, sl := range ec.resPb.OutputDirectorySymlinks {
+		dirSymlinks[sl.Path] = true
+	}
+	for _, sl := range ec.resPb.OutputSymlinks {
+		if _, ok := ec.Metadata.OutputSymlinks[sl.Path]; !ok && !dirSymlinks[sl.Path] {
+			ec.Metadata.OutputFiles++
+		}
+		ec.Metadata.OutputSymlinks[sl.Path] = sl.Target
+	}
 	if ec.resPb.StdoutRaw != nil {
 		ec.Metadata.TotalOutputBytes += int64(len(ec.resPb.StdoutRaw))
 	} else if ec.resPb.StdoutDigest != nil {
@@ -141,23 +234,39 @@ func (ec *Context) setOutputMetadata() {
 	}
 }
 
+// downloadOutErr downloads the stdout and stderr of the result, and writes them only once both
+// were downloaded, so that nothing is written twice if a failure leads to fetching another result.
 func (ec *Context) downloadOutErr() *command.Result {
-	if err := ec.downloadStream(ec.resPb.StdoutRaw, ec.resPb.StdoutDigest, 0, ec.oe.WriteOut); err != nil {
+	var stdout, stderr []byte
+	if err := ec.downloadStream(ec.resPb.StdoutRaw, ec.resPb.StdoutDigest, 0, func(b []byte) { stdout = b }); err != nil {
 		return command.NewRemoteErrorResult(err)
 	}
-	if err := ec.downloadStream(ec.resPb.StderrRaw, ec.resPb.StderrDigest, 0, ec.oe.WriteErr); err != nil {
+	if err := ec.downloadStream(ec.resPb.StderrRaw, ec.resPb.StderrDigest, 0, func(b []byte) { stderr = b }); err != nil {
 		return command.NewRemoteErrorResult(err)
 	}
+	if stdout != nil {
+		ec.oe.WriteOut(stdout)
+	}
+	if stderr != nil {
+		ec.oe.WriteErr(stderr)
+	}
 	return command.NewResultFromExitCode((int)(ec.resPb.ExitCode))
 }
 
 func (ec *Context) downloadOutputs(outDir string) (*rc.MovedBytesMetadata, *command.Result) {
 	ec.Metadata.EventTimes[command.EventDownloadResults] = &command.TimeInterval{From: time.Now()}
 	defer func() { ec.Metadata.EventTimes[command.EventDownloadResults].To = time.Now() }()
+	root := outDir
 	if !ec.client.GrpcClient.LegacyExecRootRelativeOutputs {
 		outDir = filepath.Join(outDir, ec.cmd.WorkingDir)
 	}
-	stats, err := ec.client.GrpcClient.DownloadActionOutputs(ec.ctx, ec.resPb, outDir, ec.client.FileMetadataCache)
+	var stats *rc.MovedBytesMetadata
+	var err error
+	if ec.downloadFilter.active() || ec.opt.OutputManifestPath != "" || len(ec.cmd.OutputGlobs()) > 0 {
+		stats, err = ec.downloadMaterializedOutputs(root, outDir)
+	} else {
+		stats, err = ec.downloadActionOutputs(outDir)
+	}
 	if err != nil {
 		return &rc.MovedBytesMetadata{}, command.NewRemoteErrorResult(err)
 	}
@@ -170,7 +279,7 @@ func (ec *Context) computeCmdDg() (*repb.Platform, error) {
 	cmdPb := ec.cmd.ToREProto(commandHasOutputPathsField)
 	log.V(2).Infof("%s %s> Command: \n%s\n", cmdID, executionID, prototext.Format(cmdPb))
 	var err error
-	if ec.cmdUe, err = uploadinfo.EntryFromProto(cmdPb); err != nil {
+	if ec.cmdUe, err = uploadinfo.EntryFromProtoWith(ec.client.GrpcClient.DigestFunction(), cmdPb); err != nil {
 		return nil, err
 	}
 	cmdDg := ec.cmdUe.Digest
@@ -184,6 +293,7 @@ func (ec *Context) computeActionDg(rootDg digest.Digest, platform *repb.Platform
 		CommandDigest:   ec.cmdUe.Digest.ToProto(),
 		InputRootDigest: rootDg.ToProto(),
 		DoNotCache:      ec.opt.DoNotCache,
+		Salt:            ec.opt.Salt,
 	}
 	// If supported, we attach a copy of the platform properties list to the Action.
 	if ec.client.GrpcClient.SupportsActionPlatformProperties() {
@@ -194,7 +304,7 @@ func (ec *Context) computeActionDg(rootDg digest.Digest, platform *repb.Platform
 		acPb.Timeout = dpb.New(ec.cmd.Timeout)
 	}
 	var err error
-	if ec.acUe, err = uploadinfo.EntryFromProto(acPb); err != nil {
+	if ec.acUe, err = uploadinfo.EntryFromProtoWith(ec.client.GrpcClient.DigestFunction(), acPb); err != nil {
 		return err
 	}
 	return nil
@@ -266,44 +376,77 @@ func symlinkOpts(treeOpts *rc.TreeSymlinkOpts, cmdOpts command.SymlinkBehaviorTy
 // GetCachedResult tries to get the command result from the cache. The Result will be nil on a
 // cache miss. The Context will be ready to execute the action, or, alternatively, to
 // update the remote cache with a local result. If the ExecutionOptions do not allow to accept
-// remotely cached results, the operation is a noop.
+// remotely cached results, the operation is a noop. A speculative input upload started on a miss
+// runs until ExecuteRemotely, UpdateCachedResult or Close.
 func (ec *Context) GetCachedResult() {
 	if err := ec.computeInputs(); err != nil {
 		ec.Result = command.NewLocalErrorResult(err)
 		return
 	}
 	if ec.opt.AcceptCached && !ec.opt.DoNotCache {
+		if ec.resPb = ec.loadDiskCachedResult(); ec.resPb != nil {
+			if ec.useCachedResult() {
+				return
+			}
+			// The blobs of the disk cached result are gone; fall back to the remote cache.
+			ec.dropDiskCachedResult(ec.Result.Err)
+			ec.resPb = nil
+			ec.Metadata.CachedResult = false
+			ec.Metadata.CachedEventTimes = nil
+		}
+		ec.startSpeculativeUpload()
 		ec.Metadata.EventTimes[command.EventCheckActionCache] = &command.TimeInterval{From: time.Now()}
-		resPb, err := ec.client.GrpcClient.CheckActionCache(ec.ctx, ec.Metadata.ActionDigest.ToProto())
+		// Wildcard outputs may be files, so they may be inlined too.
+		files, _, wildcards := ec.cmd.OutputPaths()
+		resPb, err := ec.client.GrpcClient.CheckActionCacheInline(ec.ctx, ec.Metadata.ActionDigest.ToProto(), append(files, wildcards...))
 		ec.Metadata.EventTimes[command.EventCheckActionCache].To = time.Now()
 		if err != nil {
+			ec.cancelSpeculativeUpload()
 			ec.Result = command.NewRemoteErrorResult(err)
 			return
 		}
 		ec.resPb = resPb
 	}
 	if ec.resPb != nil {
-		ec.Result = command.NewResultFromExitCode((int)(ec.resPb.ExitCode))
-		ec.setOutputMetadata()
-		cmdID, executionID := ec.cmd.Identifiers.ExecutionID, ec.cmd.Identifiers.CommandID
-		log.V(1).Infof("%s %s> Found cached result, downloading outputs...", cmdID, executionID)
-		if ec.opt.DownloadOutErr {
-			ec.Result = ec.downloadOutErr()
-		}
-		if ec.Result.Err == nil && ec.opt.DownloadOutputs {
-			stats, res := ec.downloadOutputs(ec.cmd.ExecRoot)
-			ec.Metadata.LogicalBytesDownloaded += stats.LogicalMoved
-			ec.Metadata.RealBytesDownloaded += stats.RealMoved
-			ec.Result = res
-		}
-		if ec.Result.Err == nil {
-			ec.Result.Status = command.CacheHitResultStatus
-		}
+		ec.useCachedResult()
 		return
 	}
 	ec.Result = nil
 }
 
+// useCachedResult sets the Result of the cached ActionResult in ec.resPb, downloading its outputs
+// as requested by the options. It returns false if the downloads failed, in which case the Result
+// is the error.
+func (ec *Context) useCachedResult() bool {
+	ec.cancelSpeculativeUpload()
+	ec.Result = command.NewResultFromExitCode((int)(ec.resPb.ExitCode))
+	ec.setOutputMetadata()
+	ec.Metadata.CachedResult = true
+	ec.Metadata.CachedEventTimes = make(map[string]*command.TimeInterval)
+	setTimingMetadata(ec.Metadata.CachedEventTimes, ec.resPb.GetExecutionMetadata())
+	setAuxiliaryMetadata(ec.Metadata, ec.resPb.GetExecutionMetadata())
+	setWorkerMetadata(ec.Metadata, ec.resPb.GetExecutionMetadata())
+	cmdID, executionID := ec.cmd.Identifiers.ExecutionID, ec.cmd.Identifiers.CommandID
+	log.V(1).Infof("%s %s> Found cached result, downloading outputs...", cmdID, executionID)
+	if ec.opt.DownloadOutErr {
+		ec.Result = ec.downloadOutErr()
+	}
+	if ec.Result.Err == nil && ec.opt.DownloadOutputs {
+		stats, res := ec.downloadOutputs(ec.cmd.ExecRoot)
+		ec.Metadata.LogicalBytesDownloaded += stats.LogicalMoved
+		ec.Metadata.RealBytesDownloaded += stats.RealMoved
+		ec.Result = res
+	}
+	if ec.Result.Err != nil {
+		return false
+	}
+	if ec.validateOutputs(ec.downloadedTo()) {
+		ec.Result.Status = command.CacheHitResultStatus
+		ec.storeDiskCachedResult()
+	}
+	return true
+}
+
 // UpdateCachedResult tries to write local results of the execution to the remote cache.
 // TODO(olaola): optional arguments to override values of local outputs, and also stdout/err.
 func (ec *Context) UpdateCachedResult() {
@@ -317,19 +460,24 @@ func (ec *Context) UpdateCachedResult() {
 		ec.Result = command.NewLocalErrorResult(err)
 		return
 	}
+	// The action ran locally, so its inputs need not be uploaded.
+	ec.cancelSpeculativeUpload()
 	ec.Metadata.EventTimes[command.EventUpdateCachedResult] = &command.TimeInterval{From: time.Now()}
 	defer func() { ec.Metadata.EventTimes[command.EventUpdateCachedResult].To = time.Now() }()
-	outPaths := append(ec.cmd.OutputFiles, ec.cmd.OutputDirs...)
+	outPaths := ec.cmd.AllOutputPaths()
 	wd := ""
 	if !ec.client.GrpcClient.LegacyExecRootRelativeOutputs {
 		wd = ec.cmd.WorkingDir
 	}
-	blobs, resPb, err := ec.client.GrpcClient.ComputeOutputsToUpload(ec.cmd.ExecRoot, wd, outPaths, ec.client.FileMetadataCache, ec.cmd.InputSpec.SymlinkBehavior, ec.cmd.InputSpec.InputNodeProperties)
+	blobs, resPb, err := ec.client.GrpcClient.ComputeOutputsToUploadWithNodeProperties(ec.cmd.ExecRoot, wd, outPaths, ec.client.FileMetadataCache, ec.cmd.InputSpec.SymlinkBehavior, ec.cmd.InputSpec.InputNodeProperties, ec.cmd.OutputNodeProperties)
 	if err != nil {
 		ec.Result = command.NewLocalErrorResult(err)
 		return
 	}
 	ec.resPb = resPb
+	if !ec.validateOutputs(ec.cmd.ExecRoot) {
+		return
+	}
 	ec.setOutputMetadata()
 	toUpload := []*uploadinfo.Entry{ec.acUe, ec.cmdUe}
 	for _, ch := range blobs {
@@ -349,9 +497,11 @@ func (ec *Context) UpdateCachedResult() {
 	ec.Metadata.RealBytesUploaded = bytesMoved
 	log.V(1).Infof("%s %s> Updating remote cache...", cmdID, executionID)
 	req := &repb.UpdateActionResultRequest{
-		InstanceName: ec.client.GrpcClient.InstanceName,
-		ActionDigest: ec.Metadata.ActionDigest.ToProto(),
-		ActionResult: resPb,
+		InstanceName:       ec.client.GrpcClient.InstanceName,
+		ActionDigest:       ec.Metadata.ActionDigest.ToProto(),
+		ActionResult:       ec.resPb,
+		ResultsCachePolicy: resultsCachePolicy(ec.opt),
+		DigestFunction:     ec.client.GrpcClient.DigestFunction().Value(),
 	}
 	if _, err := ec.client.GrpcClient.UpdateActionResult(ec.ctx, req); err != nil {
 		ec.Result = command.NewRemoteErrorResult(err)
@@ -370,12 +520,16 @@ func (ec *Context) ExecuteRemotely() {
 	cmdID, executionID := ec.cmd.Identifiers.ExecutionID, ec.cmd.Identifiers.CommandID
 	log.V(1).Infof("%s %s> Checking inputs to upload...", cmdID, executionID)
 	// TODO(olaola): compute input cache hit stats.
-	ec.Metadata.EventTimes[command.EventUploadInputs] = &command.TimeInterval{From: time.Now()}
-	missing, bytesMoved, err := ec.client.GrpcClient.UploadIfMissing(ec.ctx, ec.inputBlobs...)
-	ec.Metadata.EventTimes[command.EventUploadInputs].To = time.Now()
-	if err != nil {
-		ec.Result = command.NewRemoteErrorResult(err)
-		return
+	missing, bytesMoved, ok := ec.awaitSpeculativeUpload()
+	if !ok {
+		ec.Metadata.EventTimes[command.EventUploadInputs] = &command.TimeInterval{From: time.Now()}
+		var err error
+		missing, bytesMoved, err = ec.client.GrpcClient.UploadIfMissing(ec.ctx, ec.inputBlobs...)
+		ec.Metadata.EventTimes[command.EventUploadInputs].To = time.Now()
+		if err != nil {
+			ec.Result = command.NewRemoteErrorResult(err)
+			return
+		}
 	}
 	ec.Metadata.MissingDigests = missing
 	for _, d := range missing {
@@ -390,10 +544,20 @@ func (ec *Context) ExecuteRemotely() {
 	var streamWg sync.WaitGroup
 	// These variables are owned by the progress callback (which is async but not concurrent) until the execution returns.
 	var nOutStreamed, nErrStreamed int64
-	op, err := ec.client.GrpcClient.ExecuteAndWaitProgress(ec.ctx, &repb.ExecuteRequest{
-		InstanceName:    ec.client.GrpcClient.InstanceName,
-		SkipCacheLookup: !ec.opt.AcceptCached || ec.opt.DoNotCache,
-		ActionDigest:    ec.Metadata.ActionDigest.ToProto(),
+	// The client deadline bounds the wait, queue time included, and the log streams with it.
+	execCtx := ec.ctx
+	if ec.opt.ClientDeadline > 0 {
+		var cancel context.CancelFunc
+		execCtx, cancel = context.WithTimeout(ec.ctx, ec.opt.ClientDeadline)
+		defer cancel()
+	}
+	op, err := ec.client.GrpcClient.ExecuteAndWaitProgress(execCtx, &repb.ExecuteRequest{
+		InstanceName:       ec.client.GrpcClient.InstanceName,
+		SkipCacheLookup:    !ec.opt.AcceptCached || ec.opt.DoNotCache,
+		ActionDigest:       ec.Metadata.ActionDigest.ToProto(),
+		ExecutionPolicy:    executionPolicy(ec.opt),
+		ResultsCachePolicy: resultsCachePolicy(ec.opt),
+		DigestFunction:     ec.client.GrpcClient.DigestFunction().Value(),
 	}, func(md *repb.ExecuteOperationMetadata) {
 		if !ec.opt.StreamOutErr {
 			return
@@ -408,7 +572,7 @@ func (ec *Context) ExecuteRemotely() {
 					path, _ := ec.client.GrpcClient.ResourceName("logstreams", name)
 					log.V(1).Infof("%s %s> Streaming to stdout from %q", cmdID, executionID, path)
 					// Ignoring the error here since the net result is downloading the full stream after the fact.
-					n, err := ec.client.GrpcClient.ReadResourceTo(ec.ctx, path, outerr.NewOutWriter(ec.oe))
+					n, err := ec.client.GrpcClient.ReadResourceTo(execCtx, path, outerr.NewOutWriter(ec.oe))
 					if err != nil {
 						log.Errorf("%s %s> error streaming stdout: %v", cmdID, executionID, err)
 					}
@@ -422,9 +586,9 @@ func (ec *Context) ExecuteRemotely() {
 				go func() {
 					defer streamWg.Done()
 					path, _ := ec.client.GrpcClient.ResourceName("logstreams", name)
-					log.V(1).Infof("%s %s> Streaming to stdout from %q", cmdID, executionID, path)
+					log.V(1).Infof("%s %s> Streaming to stderr from %q", cmdID, executionID, path)
 					// Ignoring the error here since the net result is downloading the full stream after the fact.
-					n, err := ec.client.GrpcClient.ReadResourceTo(ec.ctx, path, outerr.NewErrWriter(ec.oe))
+					n, err := ec.client.GrpcClient.ReadResourceTo(execCtx, path, outerr.NewErrWriter(ec.oe))
 					if err != nil {
 						log.Errorf("%s %s> error streaming stderr: %v", cmdID, executionID, err)
 					}
@@ -440,6 +604,14 @@ func (ec *Context) ExecuteRemotely() {
 	// will have terminated at this point.
 	streamWg.Wait()
 	if err != nil {
+		if errors.Is(execCtx.Err(), context.DeadlineExceeded) && ec.ctx.Err() == nil {
+			ec.Result = command.NewClientDeadlineResult(fmt.Errorf("client deadline of %v exceeded: %w", ec.opt.ClientDeadline, err))
+			return
+		}
+		if errors.Is(ec.ctx.Err(), context.Canceled) {
+			ec.Result = command.NewCancelledResult(fmt.Errorf("execution cancelled: %w", err))
+			return
+		}
 		ec.Result = command.NewRemoteErrorResult(err)
 		return
 	}
@@ -455,11 +627,20 @@ func (ec *Context) ExecuteRemotely() {
 		ec.Result = command.NewRemoteErrorResult(err)
 		return
 	}
+	defer ec.retainFailureArtifacts(resp)
 	ec.resPb = resp.Result
-	setTimingMetadata(ec.Metadata, resp.Result.GetExecutionMetadata())
+	if resp.CachedResult {
+		ec.Metadata.CachedResult = true
+		ec.Metadata.CachedEventTimes = make(map[string]*command.TimeInterval)
+		setTimingMetadata(ec.Metadata.CachedEventTimes, resp.Result.GetExecutionMetadata())
+	} else {
+		setTimingMetadata(ec.Metadata.EventTimes, resp.Result.GetExecutionMetadata())
+	}
 	setAuxiliaryMetadata(ec.Metadata, resp.Result.GetExecutionMetadata())
+	setWorkerMetadata(ec.Metadata, resp.Result.GetExecutionMetadata())
 	st := status.FromProto(resp.Status)
 	message := resp.Message
+	ec.execMessage = message
 	if message != "" && (st.Code() != codes.OK || ec.resPb != nil && ec.resPb.ExitCode != 0) {
 		ec.oe.WriteErr([]byte(message + "\n"))
 	}
@@ -501,7 +682,33 @@ func (ec *Context) ExecuteRemotely() {
 	}
 	if ec.resPb == nil {
 		ec.Result = command.NewRemoteErrorResult(fmt.Errorf("execute did not return action result"))
+		return
+	}
+	if ec.Result.Err == nil && ec.validateOutputs(ec.downloadedTo()) {
+		ec.storeDiskCachedResult()
+	}
+}
+
+// downloadedTo returns the exec root the outputs were downloaded to, or "" if they were not.
+func (ec *Context) downloadedTo() string {
+	if !ec.opt.DownloadOutputs {
+		return ""
+	}
+	return ec.cmd.ExecRoot
+}
+
+// validateOutputs runs the client's OutputValidator on the result, if there is one. It returns
+// false, and sets the Result to a local error, if the result was rejected.
+func (ec *Context) validateOutputs(execRoot string) bool {
+	if ec.client.OutputValidator == nil {
+		return true
 	}
+	if err := ec.client.OutputValidator(ec.ctx, ec.cmd, ec.resPb, execRoot); err != nil {
+		ec.Result = command.NewLocalErrorResult(fmt.Errorf("output validation failed: %w", err))
+		return false
+	}
+	ec.setOutputMetadata()
+	return true
 }
 
 // DownloadOutErr downloads the stdout and stderr of the command.
@@ -531,7 +738,7 @@ func (ec *Context) DownloadSpecifiedOutputs(outs map[string]*rc.TreeOutput, outD
 	st := ec.Result.Status
 	ec.Metadata.EventTimes[command.EventDownloadResults] = &command.TimeInterval{From: time.Now()}
 	outDir = filepath.Join(outDir, ec.cmd.WorkingDir)
-	stats, err := ec.client.GrpcClient.DownloadOutputs(ec.ctx, outs, outDir, ec.client.FileMetadataCache)
+	stats, err := ec.downloadOutputFiles(outs, outDir)
 	if err != nil {
 		stats = &rc.MovedBytesMetadata{}
 		ec.Result = command.NewRemoteErrorResult(err)
@@ -549,7 +756,7 @@ func (ec *Context) DownloadSpecifiedOutputs(outs map[string]*rc.TreeOutput, outD
 // GetFlattenedOutputs flattens the outputs from the ActionResult of the context and returns
 // a map of output paths relative to the working directory and their corresponding TreeOutput
 func (ec *Context) GetFlattenedOutputs() (map[string]*rc.TreeOutput, error) {
-	out, err := ec.client.GrpcClient.FlattenActionOutputs(ec.ctx, ec.resPb)
+	out, err := ec.flattenActionOutputs(ec.resPb)
 	if err != nil {
 		return nil, fmt.Errorf("Failed to flatten outputs: %v", err)
 	}
@@ -565,7 +772,7 @@ func (ec *Context) GetOutputFileDigests(useAbsPath bool) (map[string]digest.Dige
 		return nil, nil
 	}
 
-	ft, err := ec.client.GrpcClient.FlattenActionOutputs(ec.ctx, ec.resPb)
+	ft, err := ec.flattenActionOutputs(ec.resPb)
 	if err != nil {
 		return nil, err
 	}
@@ -579,6 +786,20 @@ func (ec *Context) GetOutputFileDigests(useAbsPath bool) (map[string]digest.Dige
 	return res, nil
 }
 
+func executionPolicy(opt *command.ExecutionOptions) *repb.ExecutionPolicy {
+	if opt.Priority == 0 {
+		return nil
+	}
+	return &repb.ExecutionPolicy{Priority: opt.Priority}
+}
+
+func resultsCachePolicy(opt *command.ExecutionOptions) *repb.ResultsCachePolicy {
+	if opt.CachePriority == 0 {
+		return nil
+	}
+	return &repb.ResultsCachePolicy{Priority: opt.CachePriority}
+}
+
 func timeFromProto(tPb *tspb.Timestamp) time.Time {
 	if tPb == nil {
 		return time.Time{}
@@ -586,22 +807,22 @@ func timeFromProto(tPb *tspb.Timestamp) time.Time {
 	return tPb.AsTime()
 }
 
-func setEventTimes(cm *command.Metadata, event string, start, end *tspb.Timestamp) {
-	cm.EventTimes[event] = &command.TimeInterval{
+func setEventTimes(times map[string]*command.TimeInterval, event string, start, end *tspb.Timestamp) {
+	times[event] = &command.TimeInterval{
 		From: timeFromProto(start),
 		To:   timeFromProto(end),
 	}
 }
 
-func setTimingMetadata(cm *command.Metadata, em *repb.ExecutedActionMetadata) {
+func setTimingMetadata(times map[string]*command.TimeInterval, em *repb.ExecutedActionMetadata) {
 	if em == nil {
 		return
 	}
-	setEventTimes(cm, command.EventServerQueued, em.QueuedTimestamp, em.WorkerStartTimestamp)
-	setEventTimes(cm, command.EventServerWorker, em.WorkerStartTimestamp, em.WorkerCompletedTimestamp)
-	setEventTimes(cm, command.EventServerWorkerInputFetch, em.InputFetchStartTimestamp, em.InputFetchCompletedTimestamp)
-	setEventTimes(cm, command.EventServerWorkerExecution, em.ExecutionStartTimestamp, em.ExecutionCompletedTimestamp)
-	setEventTimes(cm, command.EventServerWorkerOutputUpload, em.OutputUploadStartTimestamp, em.OutputUploadCompletedTimestamp)
+	setEventTimes(times, command.EventServerQueued, em.QueuedTimestamp, em.WorkerStartTimestamp)
+	setEventTimes(times, command.EventServerWorker, em.WorkerStartTimestamp, em.WorkerCompletedTimestamp)
+	setEventTimes(times, command.EventServerWorkerInputFetch, em.InputFetchStartTimestamp, em.InputFetchCompletedTimestamp)
+	setEventTimes(times, command.EventServerWorkerExecution, em.ExecutionStartTimestamp, em.ExecutionCompletedTimestamp)
+	setEventTimes(times, command.EventServerWorkerOutputUpload, em.OutputUploadStartTimestamp, em.OutputUploadCompletedTimestamp)
 }
 
 func setAuxiliaryMetadata(cm *command.Metadata, em *repb.ExecutedActionMetadata) {
@@ -611,17 +832,61 @@ func setAuxiliaryMetadata(cm *command.Metadata, em *repb.ExecutedActionMetadata)
 	cm.AuxiliaryMetadata = em.GetAuxiliaryMetadata()
 }
 
+func setWorkerMetadata(cm *command.Metadata, em *repb.ExecutedActionMetadata) {
+	if em == nil {
+		return
+	}
+	cm.Worker = em.GetWorker()
+	if start, end := em.GetExecutionStartTimestamp(), em.GetExecutionCompletedTimestamp(); start != nil && end != nil {
+		cm.ExecutionDuration = end.AsTime().Sub(start.AsTime())
+	}
+	if d := em.GetVirtualExecutionDuration(); d != nil {
+		cm.VirtualExecutionDuration = d.AsDuration()
+	}
+}
+
+// FetchOutErr downloads the standard output and error referenced by the metadata of a finished
+// execution and writes them to oe. It allows fetching them lazily, e.g. when a user asks for the
+// log of an action that ran with DownloadOutErr unset.
+func (c *Client) FetchOutErr(ctx context.Context, md *command.Metadata, oe outerr.OutErr) error {
+	if md.StdoutDigest.Size > 0 {
+		if err := c.fetchBlob(ctx, md.StdoutDigest, oe.WriteOut); err != nil {
+			return fmt.Errorf("failed to fetch stdout %v: %w", md.StdoutDigest, err)
+		}
+	}
+	if md.StderrDigest.Size > 0 {
+		if err := c.fetchBlob(ctx, md.StderrDigest, oe.WriteErr); err != nil {
+			return fmt.Errorf("failed to fetch stderr %v: %w", md.StderrDigest, err)
+		}
+	}
+	return nil
+}
+
+func (c *Client) fetchBlob(ctx context.Context, dg digest.Digest, write func([]byte)) error {
+	blob, _, err := c.GrpcClient.ReadBlob(ctx, dg)
+	if err != nil {
+		return err
+	}
+	write(blob)
+	return nil
+}
+
 // Run executes a command remotely.
 func (c *Client) Run(ctx context.Context, cmd *command.Command, opt *command.ExecutionOptions, oe outerr.OutErr) (*command.Result, *command.Metadata) {
 	ec, err := c.NewContext(ctx, cmd, opt, oe)
 	if err != nil {
 		return command.NewLocalErrorResult(err), &command.Metadata{}
 	}
+	defer ec.Close()
 	ec.GetCachedResult()
 	if ec.Result != nil {
 		return ec.Result, ec.Metadata
 	}
-	ec.ExecuteRemotely()
+	if c.ReexecPolicy != nil {
+		ec.executeRemotelyWithRetries()
+	} else {
+		ec.ExecuteRemotely()
+	}
 	// TODO(olaola): implement the cache-miss-retry loop.
 	return ec.Result, ec.Metadata
 }
@@ -5,14 +5,22 @@ package rexec_test
 import (
 	"bytes"
 	"context"
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
 	"testing"
+	"time"
 
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/client"
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/command"
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/digest"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/diskcache"
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/fakes"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/filemetadata"
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/outerr"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/rexec"
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
 	"google.golang.org/grpc/codes"
@@ -20,6 +28,7 @@ import (
 	"google.golang.org/protobuf/proto"
 
 	repb "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	dpb "google.golang.org/protobuf/types/known/durationpb"
 )
 
 func TestExecCacheHit(t *testing.T) {
@@ -90,11 +99,12 @@ func TestExecCacheHit(t *testing.T) {
 					OutputSymlinks:         map[string]string{},
 					StderrDigest:           stderrDg,
 					StdoutDigest:           stdoutDg,
+					CachedResult:           true,
 				}
 				if diff := cmp.Diff(wantRes, res); diff != "" {
 					t.Errorf("Run() gave result diff (-want +got):\n%s", diff)
 				}
-				if diff := cmp.Diff(wantMeta, meta, cmpopts.IgnoreFields(command.Metadata{}, "EventTimes", "AuxiliaryMetadata")); diff != "" {
+				if diff := cmp.Diff(wantMeta, meta, cmpopts.IgnoreFields(command.Metadata{}, "EventTimes", "CachedEventTimes", "ExecutionDuration", "AuxiliaryMetadata")); diff != "" {
 					t.Errorf("Run() gave result diff (-want +got):\n%s", diff)
 				}
 				var eventNames []string
@@ -112,6 +122,10 @@ func TestExecCacheHit(t *testing.T) {
 				if diff := cmp.Diff(wantNames, eventNames, cmpopts.SortSlices(func(a, b string) bool { return a < b })); diff != "" {
 					t.Errorf("Run gave different events: want %v, got %v", wantNames, eventNames)
 				}
+				em := e.Server.ActionCache.Get(acDg).GetExecutionMetadata()
+				if iv := meta.CachedEventTimes[command.EventServerWorkerExecution]; iv == nil || !iv.From.Equal(em.ExecutionStartTimestamp.AsTime()) || !iv.To.Equal(em.ExecutionCompletedTimestamp.AsTime()) {
+					t.Errorf("Run() gave cached execution event times %v, want [%v, %v]", iv, em.ExecutionStartTimestamp.AsTime(), em.ExecutionCompletedTimestamp.AsTime())
+				}
 				if i == 0 {
 					if !bytes.Equal(oe.Stdout(), []byte("stdout")) {
 						t.Errorf("Run() gave stdout diff: want \"stdout\", got: %v", oe.Stdout())
@@ -134,6 +148,39 @@ func TestExecCacheHit(t *testing.T) {
 }
 
 // TestExecNotAcceptCached should skip both client-side and server side action cache lookups.
+func TestExecCacheHitInlineOutputFiles(t *testing.T) {
+	e, cleanup := fakes.NewTestEnv(t)
+	defer cleanup()
+	e.Client.GrpcClient.InlineOutputFiles = &client.InlineOutputFiles{Patterns: []string{"*.d", "stamp"}, MaxBytes: 8}
+	cmd := &command.Command{
+		Args:        []string{"tool"},
+		ExecRoot:    e.ExecRoot,
+		OutputFiles: []string{"out/a.d", "out/b.d", "out/stamp", "out/bin"},
+	}
+	opt := command.DefaultExecutionOptions()
+	e.Set(cmd, opt, &command.Result{Status: command.CacheHitResultStatus},
+		&fakes.OutputFile{Path: "out/a.d", Contents: "a: x"},
+		&fakes.OutputFile{Path: "out/b.d", Contents: "b: x y z"},
+		&fakes.OutputFile{Path: "out/stamp", Contents: "1"},
+		&fakes.OutputFile{Path: "out/bin", Contents: "binary"})
+
+	res, _ := e.Client.Run(context.Background(), cmd, opt, outerr.NewRecordingOutErr())
+
+	if res.Status != command.CacheHitResultStatus {
+		t.Fatalf("Run() gave result %+v, want a cache hit", res)
+	}
+	// a.d and stamp fit in the budget, b.d does not and bin does not match.
+	for path, wantReads := range map[string]int{"out/a.d": 0, "out/stamp": 0, "out/b.d": 1, "out/bin": 1} {
+		blob, err := os.ReadFile(filepath.Join(e.ExecRoot, path))
+		if err != nil {
+			t.Fatalf("error reading output %s: %v", path, err)
+		}
+		if reads := e.Server.CAS.BlobReads(digest.NewFromBlob(blob)); reads != wantReads {
+			t.Errorf("output %s was read %d times from the CAS, want %d", path, reads, wantReads)
+		}
+	}
+}
+
 func TestExecNotAcceptCached(t *testing.T) {
 	e, cleanup := fakes.NewTestEnv(t)
 	defer cleanup()
@@ -156,7 +203,7 @@ func TestExecNotAcceptCached(t *testing.T) {
 	if diff := cmp.Diff(wantRes, res); diff != "" {
 		t.Errorf("Run() gave result diff (-want +got):\n%s", diff)
 	}
-	if diff := cmp.Diff(wantMeta, meta, cmpopts.EquateEmpty(), cmpopts.IgnoreFields(command.Metadata{}, "CommandDigest", "TotalInputBytes", "EventTimes", "MissingDigests", "AuxiliaryMetadata")); diff != "" {
+	if diff := cmp.Diff(wantMeta, meta, cmpopts.EquateEmpty(), cmpopts.IgnoreFields(command.Metadata{}, "CommandDigest", "TotalInputBytes", "EventTimes", "ExecutionDuration", "MissingDigests", "AuxiliaryMetadata")); diff != "" {
 		t.Errorf("Run() gave result diff (-want +got):\n%s", diff)
 	}
 	var eventNames []string
@@ -196,6 +243,109 @@ func TestExecNotAcceptCached(t *testing.T) {
 	}
 }
 
+func TestExecWorkerMetadata(t *testing.T) {
+	e, cleanup := fakes.NewTestEnv(t)
+	defer cleanup()
+	cmd := &command.Command{Args: []string{"tool"}, ExecRoot: e.ExecRoot}
+	opt := &command.ExecutionOptions{AcceptCached: false, DownloadOutputs: true, DownloadOutErr: true}
+	wantRes := &command.Result{Status: command.SuccessResultStatus}
+	e.Set(cmd, opt, wantRes)
+	em := e.Server.Exec.ActionResult.ExecutionMetadata
+	em.Worker = "pool-a/worker-1"
+	em.VirtualExecutionDuration = dpb.New(3 * time.Second)
+
+	res, meta := e.Client.Run(context.Background(), cmd, opt, outerr.NewRecordingOutErr())
+
+	if diff := cmp.Diff(wantRes, res); diff != "" {
+		t.Errorf("Run() gave result diff (-want +got):\n%s", diff)
+	}
+	if meta.Worker != "pool-a/worker-1" {
+		t.Errorf("Run() gave worker %q, want %q", meta.Worker, "pool-a/worker-1")
+	}
+	if meta.VirtualExecutionDuration != 3*time.Second {
+		t.Errorf("Run() gave virtual execution duration %v, want %v", meta.VirtualExecutionDuration, 3*time.Second)
+	}
+	if want := em.ExecutionCompletedTimestamp.AsTime().Sub(em.ExecutionStartTimestamp.AsTime()); meta.ExecutionDuration != want {
+		t.Errorf("Run() gave execution duration %v, want %v", meta.ExecutionDuration, want)
+	}
+	if iv := meta.EventTimes[command.EventServerWorkerExecution]; iv == nil || !iv.From.Equal(em.ExecutionStartTimestamp.AsTime()) || !iv.To.Equal(em.ExecutionCompletedTimestamp.AsTime()) {
+		t.Errorf("Run() gave execution event times %v, want [%v, %v]", iv, em.ExecutionStartTimestamp.AsTime(), em.ExecutionCompletedTimestamp.AsTime())
+	}
+}
+
+func TestExecClientDeadline(t *testing.T) {
+	e, cleanup := fakes.NewTestEnv(t)
+	defer cleanup()
+	cmd := &command.Command{Args: []string{"tool"}, ExecRoot: e.ExecRoot, Timeout: time.Hour}
+	opt := &command.ExecutionOptions{AcceptCached: false, DownloadOutputs: true, DownloadOutErr: true, ClientDeadline: 100 * time.Millisecond}
+	e.Set(cmd, opt, &command.Result{Status: command.SuccessResultStatus})
+	e.Server.Exec.QueueDelay = time.Minute
+
+	res, _ := e.Client.Run(context.Background(), cmd, opt, outerr.NewRecordingOutErr())
+
+	if res.Status != command.ClientDeadlineResultStatus || res.ExitCode != command.ClientDeadlineExitCode {
+		t.Errorf("Run() gave result %+v, want status %v and exit code %d", res, command.ClientDeadlineResultStatus, command.ClientDeadlineExitCode)
+	}
+}
+
+func TestExecCancelled(t *testing.T) {
+	e, cleanup := fakes.NewTestEnv(t)
+	defer cleanup()
+	cmd := &command.Command{Args: []string{"tool"}, ExecRoot: e.ExecRoot}
+	opt := &command.ExecutionOptions{AcceptCached: false, DownloadOutputs: true, DownloadOutErr: true}
+	e.Set(cmd, opt, &command.Result{Status: command.SuccessResultStatus})
+	e.Server.Exec.QueueDelay = time.Minute
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	res, _ := e.Client.Run(ctx, cmd, opt, outerr.NewRecordingOutErr())
+
+	if res.Status != command.CancelledResultStatus || res.ExitCode != command.CancelledExitCode {
+		t.Errorf("Run() gave result %+v, want status %v and exit code %d", res, command.CancelledResultStatus, command.CancelledExitCode)
+	}
+	if got := e.Server.Exec.CancelledOperations(); len(got) != 1 {
+		t.Errorf("Run() cancelled operations %v, want the execution's operation", got)
+	}
+}
+
+func TestExecDefaultPlatform(t *testing.T) {
+	e, cleanup := fakes.NewTestEnv(t)
+	defer cleanup()
+	e.Client.DefaultPlatform = map[string]string{"OSFamily": "Linux", "Pool": "default"}
+	cmd := &command.Command{Args: []string{"tool"}, ExecRoot: e.ExecRoot, Platform: map[string]string{"Pool": "gpu"}}
+	opt := &command.ExecutionOptions{AcceptCached: false, DownloadOutputs: true, DownloadOutErr: true}
+	wantRes := &command.Result{Status: command.SuccessResultStatus}
+	cmdDg, acDg, _, _ := e.Set(cmd, opt, wantRes)
+
+	res, meta := e.Client.Run(context.Background(), cmd, opt, outerr.NewRecordingOutErr())
+
+	if diff := cmp.Diff(wantRes, res); diff != "" {
+		t.Errorf("Run() gave result diff (-want +got):\n%s", diff)
+	}
+	if meta.ActionDigest != acDg {
+		t.Errorf("Run() gave action digest %v, want %v", meta.ActionDigest, acDg)
+	}
+	blob, ok := e.Server.CAS.Get(cmdDg)
+	if !ok {
+		t.Fatalf("Command %v is missing from the CAS", cmdDg)
+	}
+	cmdPb := &repb.Command{}
+	if err := proto.Unmarshal(blob, cmdPb); err != nil {
+		t.Fatalf("failed to unmarshal Command: %v", err)
+	}
+	wantPlatform := &repb.Platform{Properties: []*repb.Platform_Property{
+		{Name: "OSFamily", Value: "Linux"},
+		{Name: "Pool", Value: "gpu"},
+	}}
+	if diff := cmp.Diff(wantPlatform, cmdPb.Platform, cmp.Comparer(proto.Equal)); diff != "" {
+		t.Errorf("Run() executed Command with platform diff (-want +got):\n%s", diff)
+	}
+	// The defaults are not added to the caller's command, so that it can run on other clients.
+	if diff := cmp.Diff(map[string]string{"Pool": "gpu"}, cmd.Platform); diff != "" {
+		t.Errorf("Run() modified the platform of the command (-want +got):\n%s", diff)
+	}
+}
+
 func TestExecManualCacheMiss(t *testing.T) {
 	tests := []struct {
 		name   string
@@ -260,6 +410,35 @@ func TestExecDoNotCache_NotAcceptCached(t *testing.T) {
 	}
 }
 
+func TestExecSaltPartitionsCache(t *testing.T) {
+	e, cleanup := fakes.NewTestEnv(t)
+	defer cleanup()
+	cmd := &command.Command{Args: []string{"tool"}, ExecRoot: e.ExecRoot}
+	unsaltedOpt := &command.ExecutionOptions{AcceptCached: true, DownloadOutputs: true, DownloadOutErr: true}
+	wantRes := &command.Result{Status: command.SuccessResultStatus}
+	_, unsaltedDg, _, _ := e.Set(cmd, unsaltedOpt, wantRes, fakes.StdOutRaw("not cached"))
+	e.Server.ActionCache.Put(unsaltedDg, &repb.ActionResult{StdoutRaw: []byte("cached")})
+	opt := &command.ExecutionOptions{AcceptCached: true, DownloadOutputs: true, DownloadOutErr: true, Salt: []byte("salt")}
+	_, acDg, _, _ := e.Set(cmd, opt, wantRes, fakes.StdOutRaw("not cached"))
+	if acDg == unsaltedDg {
+		t.Fatalf("Set() gave the same action digest %v with and without salt", acDg)
+	}
+	oe := outerr.NewRecordingOutErr()
+
+	res, meta := e.Client.Run(context.Background(), cmd, opt, oe)
+
+	if diff := cmp.Diff(wantRes, res); diff != "" {
+		t.Errorf("Run() gave result diff (-want +got):\n%s", diff)
+	}
+	if meta.ActionDigest != acDg {
+		t.Errorf("Run() gave action digest %v, want %v", meta.ActionDigest, acDg)
+	}
+	// The result cached for the unsalted action must not be used.
+	if !bytes.Equal(oe.Stdout(), []byte("not cached")) {
+		t.Errorf("Run() gave stdout diff: want \"not cached\", got: %v", oe.Stdout())
+	}
+}
+
 func TestExecRemoteFailureDownloadsPartialResults(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -389,6 +568,274 @@ func TestDoNotDownloadOutputs(t *testing.T) {
 	}
 }
 
+func TestMaterializeOutputsWithManifest(t *testing.T) {
+	e, cleanup := fakes.NewTestEnv(t)
+	defer cleanup()
+	manifestPath := filepath.Join(t.TempDir(), "manifest.json")
+	cmd := &command.Command{
+		Args:        []string{"tool"},
+		ExecRoot:    e.ExecRoot,
+		WorkingDir:  "wd",
+		OutputFiles: []string{"out/a", "out/b", "lib/c"},
+	}
+	opt := &command.ExecutionOptions{
+		AcceptCached:       true,
+		DownloadOutputs:    true,
+		MaterializeOutputs: []string{"out/a", "lib"},
+		OutputManifestPath: manifestPath,
+	}
+	e.Set(cmd, opt, &command.Result{Status: command.SuccessResultStatus},
+		&fakes.OutputFile{Path: "out/a", Contents: "a"},
+		&fakes.OutputFile{Path: "out/b", Contents: "bb"},
+		&fakes.OutputFile{Path: "lib/c", Contents: "c"})
+
+	res, _ := e.Client.Run(context.Background(), cmd, opt, outerr.NewRecordingOutErr())
+
+	if res.Err != nil {
+		t.Fatalf("Run() failed: %v", res.Err)
+	}
+	for _, path := range []string{"wd/out/a", "wd/lib/c"} {
+		if _, err := os.Stat(filepath.Join(e.ExecRoot, path)); err != nil {
+			t.Errorf("requested output %s was not materialized: %v", path, err)
+		}
+	}
+	bPath := filepath.Join(e.ExecRoot, "wd/out/b")
+	if _, err := os.Stat(bPath); !os.IsNotExist(err) {
+		t.Errorf("output wd/out/b was materialized, want it only in the manifest")
+	}
+	m, err := rexec.ReadOutputManifest(manifestPath)
+	if err != nil {
+		t.Fatalf("ReadOutputManifest() failed: %v", err)
+	}
+	want := &rexec.OutputManifest{Outputs: []*rexec.ManifestOutput{
+		{Path: "wd/out/b", Digest: digest.NewFromBlob([]byte("bb"))},
+	}}
+	if diff := cmp.Diff(want, m); diff != "" {
+		t.Errorf("ReadOutputManifest() gave diff (-want +got):\n%s", diff)
+	}
+	if _, err := m.Fetch(context.Background(), e.Client.GrpcClient, e.ExecRoot, e.Client.FileMetadataCache, "wd/out"); err != nil {
+		t.Fatalf("Fetch() failed: %v", err)
+	}
+	if blob, err := os.ReadFile(bPath); err != nil || string(blob) != "bb" {
+		t.Errorf("Fetch() gave wd/out/b contents %q, %v, want \"bb\"", blob, err)
+	}
+}
+
+func TestRemoteWorkingDir(t *testing.T) {
+	e, cleanup := fakes.NewTestEnv(t)
+	defer cleanup()
+	if err := os.MkdirAll(filepath.Join(e.ExecRoot, "wd"), 0777); err != nil {
+		t.Fatalf("failed to create the working directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(e.ExecRoot, "wd", "in"), []byte("in"), 0644); err != nil {
+		t.Fatalf("failed to write input: %v", err)
+	}
+	cmd := &command.Command{
+		Args:             []string{"tool"},
+		ExecRoot:         e.ExecRoot,
+		WorkingDir:       "wd",
+		RemoteWorkingDir: "canonical",
+		InputSpec:        &command.InputSpec{Inputs: []string{"wd/in"}},
+		OutputFiles:      []string{"out/a"},
+	}
+	opt := &command.ExecutionOptions{AcceptCached: false, DownloadOutputs: true}
+	cmdDg, _, _, _ := e.Set(cmd, opt, &command.Result{Status: command.SuccessResultStatus}, &fakes.OutputFile{Path: "out/a", Contents: "a"})
+
+	res, _ := e.Client.Run(context.Background(), cmd, opt, outerr.NewRecordingOutErr())
+
+	if res.Err != nil {
+		t.Fatalf("Run() failed: %v", res.Err)
+	}
+	blob, ok := e.Server.CAS.Get(cmdDg)
+	if !ok {
+		t.Fatalf("Command %v was not uploaded", cmdDg)
+	}
+	cmdPb := &repb.Command{}
+	if err := proto.Unmarshal(blob, cmdPb); err != nil {
+		t.Fatalf("failed to unmarshal Command: %v", err)
+	}
+	if cmdPb.WorkingDirectory != "canonical" {
+		t.Errorf("Run() executed in working directory %q, want %q", cmdPb.WorkingDirectory, "canonical")
+	}
+	if got, err := os.ReadFile(filepath.Join(e.ExecRoot, "wd", "out", "a")); err != nil || string(got) != "a" {
+		t.Errorf("Run() gave local output wd/out/a contents %q, %v, want \"a\"", got, err)
+	}
+	if _, err := os.Stat(filepath.Join(e.ExecRoot, "canonical")); !os.IsNotExist(err) {
+		t.Errorf("Run() created the remote working directory locally")
+	}
+}
+
+func TestDownloadOutputFilters(t *testing.T) {
+	e, cleanup := fakes.NewTestEnv(t)
+	defer cleanup()
+	cmd := &command.Command{
+		Args:        []string{"tool"},
+		ExecRoot:    e.ExecRoot,
+		OutputFiles: []string{"bin/tool.exe", "bin/tool.pdb", "gen/a.h"},
+	}
+	opt := &command.ExecutionOptions{
+		AcceptCached:        true,
+		DownloadOutputs:     true,
+		DownloadOutputRegex: `^bin/`,
+		DownloadExclusions:  []string{`\.pdb$`},
+	}
+	e.Set(cmd, opt, &command.Result{Status: command.SuccessResultStatus},
+		&fakes.OutputFile{Path: "bin/tool.exe", Contents: "exe"},
+		&fakes.OutputFile{Path: "bin/tool.pdb", Contents: "pdb"},
+		&fakes.OutputFile{Path: "gen/a.h", Contents: "h"})
+
+	res, meta := e.Client.Run(context.Background(), cmd, opt, outerr.NewRecordingOutErr())
+
+	if res.Err != nil {
+		t.Fatalf("Run() failed: %v", res.Err)
+	}
+	if _, err := os.Stat(filepath.Join(e.ExecRoot, "bin/tool.exe")); err != nil {
+		t.Errorf("output bin/tool.exe was not downloaded: %v", err)
+	}
+	for _, path := range []string{"bin/tool.pdb", "gen/a.h"} {
+		if _, err := os.Stat(filepath.Join(e.ExecRoot, path)); !os.IsNotExist(err) {
+			t.Errorf("filtered output %s was downloaded", path)
+		}
+		if _, ok := meta.OutputFileDigests[path]; !ok {
+			t.Errorf("Run() gave no digest for the filtered output %s", path)
+		}
+	}
+
+	opt.DownloadExclusions = []string{"("}
+	if res, _ := e.Client.Run(context.Background(), cmd, opt, outerr.NewRecordingOutErr()); res.Status != command.LocalErrorResultStatus {
+		t.Errorf("Run() with an invalid exclusion gave result %+v, want a local error", res)
+	}
+}
+
+func TestDownloadOutputFiltersRemovesStaleOutputs(t *testing.T) {
+	e, cleanup := fakes.NewTestEnv(t)
+	defer cleanup()
+	if err := os.MkdirAll(filepath.Join(e.ExecRoot, "out"), os.ModePerm); err != nil {
+		t.Fatalf("failed to create out: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(e.ExecRoot, "out/a.txt"), []byte("a"), 0644); err != nil {
+		t.Fatalf("failed to write out/a.txt: %v", err)
+	}
+	cmd := &command.Command{
+		Args:        []string{"tool"},
+		ExecRoot:    e.ExecRoot,
+		OutputDirs:  []string{"out"},
+		OutputFiles: []string{"log.txt"},
+	}
+	opt := &command.ExecutionOptions{
+		AcceptCached:        true,
+		DownloadOutputs:     true,
+		DownloadOutputRegex: `^out/`,
+	}
+	e.Set(cmd, opt, &command.Result{Status: command.SuccessResultStatus},
+		&fakes.OutputDir{Path: "out"},
+		&fakes.OutputFile{Path: "log.txt", Contents: "log"})
+	// A file left by an earlier run, which is not an output of this one.
+	stale := filepath.Join(e.ExecRoot, "out/stale.txt")
+	if err := os.WriteFile(stale, []byte("stale"), 0644); err != nil {
+		t.Fatalf("failed to write %s: %v", stale, err)
+	}
+
+	res, _ := e.Client.Run(context.Background(), cmd, opt, outerr.NewRecordingOutErr())
+
+	if res.Err != nil {
+		t.Fatalf("Run() failed: %v", res.Err)
+	}
+	if _, err := os.Stat(filepath.Join(e.ExecRoot, "out/a.txt")); err != nil {
+		t.Errorf("output out/a.txt was not downloaded: %v", err)
+	}
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Errorf("stale file out/stale.txt was kept in the output directory")
+	}
+}
+
+func TestOutputGlobs(t *testing.T) {
+	e, cleanup := fakes.NewTestEnv(t)
+	defer cleanup()
+	cmd := &command.Command{
+		Args:        []string{"tool"},
+		ExecRoot:    e.ExecRoot,
+		OutputFiles: []string{"obj/keep.d"},
+		Outputs:     []*command.OutputSpec{{Path: "obj/**/*.o", Type: command.GlobOutputType}},
+	}
+	opt := &command.ExecutionOptions{AcceptCached: true, DownloadOutputs: true}
+	e.Set(cmd, opt, &command.Result{Status: command.SuccessResultStatus},
+		&fakes.OutputFile{Path: "obj/a.o", Contents: "a"},
+		&fakes.OutputFile{Path: "obj/x/y/b.o", Contents: "b"},
+		&fakes.OutputFile{Path: "obj/x/c.d", Contents: "c"},
+		&fakes.OutputFile{Path: "obj/keep.d", Contents: "d"})
+
+	res, _ := e.Client.Run(context.Background(), cmd, opt, outerr.NewRecordingOutErr())
+
+	if res.Err != nil {
+		t.Fatalf("Run() failed: %v", res.Err)
+	}
+	for _, path := range []string{"obj/a.o", "obj/x/y/b.o", "obj/keep.d"} {
+		if _, err := os.Stat(filepath.Join(e.ExecRoot, path)); err != nil {
+			t.Errorf("output %s was not downloaded: %v", path, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(e.ExecRoot, "obj/x/c.d")); !os.IsNotExist(err) {
+		t.Errorf("output obj/x/c.d matching no glob was downloaded")
+	}
+}
+
+// fakeOutputService holds outputs in memory rather than on disk.
+type fakeOutputService struct {
+	outs map[string]*client.TreeOutput
+}
+
+func (s *fakeOutputService) PutOutputs(_ context.Context, outDir string, outs map[string]*client.TreeOutput) error {
+	for path, out := range outs {
+		s.outs[filepath.Join(outDir, path)] = out
+	}
+	return nil
+}
+
+func (s *fakeOutputService) Metadata(path string) *filemetadata.Metadata {
+	out, ok := s.outs[path]
+	if !ok {
+		return nil
+	}
+	return &filemetadata.Metadata{Digest: out.Digest, IsExecutable: out.IsExecutable}
+}
+
+func TestOutputService(t *testing.T) {
+	e, cleanup := fakes.NewTestEnv(t)
+	defer cleanup()
+	svc := &fakeOutputService{outs: make(map[string]*client.TreeOutput)}
+	e.Client.GrpcClient.OutputService = svc
+	cmd := &command.Command{Args: []string{"tool"}, ExecRoot: e.ExecRoot, OutputFiles: []string{"out/a"}}
+	opt := &command.ExecutionOptions{AcceptCached: true, DownloadOutputs: true}
+	e.Set(cmd, opt, &command.Result{Status: command.SuccessResultStatus}, &fakes.OutputFile{Path: "out/a", Contents: "a"})
+
+	res, _ := e.Client.Run(context.Background(), cmd, opt, outerr.NewRecordingOutErr())
+
+	if res.Err != nil {
+		t.Fatalf("Run() failed: %v", res.Err)
+	}
+	aPath := filepath.Join(e.ExecRoot, "out/a")
+	if _, err := os.Stat(aPath); !os.IsNotExist(err) {
+		t.Errorf("output out/a was written to disk, want it delegated to the output service")
+	}
+	aDg := digest.NewFromBlob([]byte("a"))
+	if out := svc.outs[aPath]; out == nil || out.Digest != aDg {
+		t.Fatalf("output service got out/a %+v, want digest %v", out, aDg)
+	}
+	// The delegated output can be used as an input although it is not on disk.
+	_, inputs, _, err := e.Client.GrpcClient.ComputeMerkleTree(context.Background(), e.ExecRoot, "", "", &command.InputSpec{Inputs: []string{"out/a"}}, e.Client.FileMetadataCache)
+	if err != nil {
+		t.Fatalf("ComputeMerkleTree() failed: %v", err)
+	}
+	found := false
+	for _, ue := range inputs {
+		found = found || ue.Digest == aDg
+	}
+	if !found {
+		t.Errorf("ComputeMerkleTree() gave inputs without out/a's digest %v", aDg)
+	}
+}
+
 func TestStreamOutErr(t *testing.T) {
 	tests := []struct {
 		name            string
@@ -631,11 +1078,12 @@ func TestOutputSymlinks(t *testing.T) {
 		OutputSymlinks:         map[string]string{"a/b/sl": "out"},
 		StderrDigest:           stderrDg,
 		StdoutDigest:           stdoutDg,
+		CachedResult:           true,
 	}
 	if diff := cmp.Diff(wantRes, res); diff != "" {
 		t.Errorf("Run() gave result diff (-want +got):\n%s", diff)
 	}
-	if diff := cmp.Diff(wantMeta, meta, cmpopts.IgnoreFields(command.Metadata{}, "EventTimes", "AuxiliaryMetadata")); diff != "" {
+	if diff := cmp.Diff(wantMeta, meta, cmpopts.IgnoreFields(command.Metadata{}, "EventTimes", "CachedEventTimes", "ExecutionDuration", "AuxiliaryMetadata")); diff != "" {
 		t.Errorf("Run() gave result diff (-want +got):\n%s", diff)
 	}
 }
@@ -715,6 +1163,7 @@ func TestUpdateRemoteCache(t *testing.T) {
 		OutputFiles: []string{"a/b/out"},
 	}
 	opt := command.DefaultExecutionOptions()
+	opt.CachePriority = 5
 	oe := outerr.NewRecordingOutErr()
 
 	ec, err := e.Client.NewContext(context.Background(), cmd, opt, oe)
@@ -740,6 +1189,9 @@ func TestUpdateRemoteCache(t *testing.T) {
 	if _, ok := e.Server.CAS.Get(ec.Metadata.CommandDigest); !ok {
 		t.Error("UpdateCachedResult() failed to upload Command proto")
 	}
+	if got := e.Server.ActionCache.LastUpdateRequest.GetResultsCachePolicy().GetPriority(); got != 5 {
+		t.Errorf("UpdateCachedResult() requested cache priority %d, want 5", got)
+	}
 	// Now delete the local result and check that we get a remote cache hit and download it.
 	if err := os.Remove(outPath); err != nil {
 		t.Fatalf("failed to remove output file %s", outPath)
@@ -832,3 +1284,579 @@ func TestDownloadResults(t *testing.T) {
 		t.Errorf("DownloadOutputs() stderr = %v, want 'stderr'", string(oe.Stderr()))
 	}
 }
+
+func TestExecOutputValidator(t *testing.T) {
+	e, cleanup := fakes.NewTestEnv(t)
+	defer cleanup()
+	cmd := &command.Command{Args: []string{"tool"}, ExecRoot: e.ExecRoot, OutputFiles: []string{"a", "b"}}
+	opt := &command.ExecutionOptions{AcceptCached: false, DownloadOutputs: true, DownloadOutErr: true}
+	e.Set(cmd, opt, &command.Result{Status: command.SuccessResultStatus},
+		&fakes.OutputFile{Path: "a", Contents: "a"}, &fakes.OutputFile{Path: "b", Contents: ""})
+
+	t.Run("rewrite", func(t *testing.T) {
+		var gotRoot string
+		e.Client.OutputValidator = func(_ context.Context, _ *command.Command, ar *repb.ActionResult, execRoot string) error {
+			gotRoot = execRoot
+			ar.OutputFiles = ar.OutputFiles[:1]
+			return nil
+		}
+		res, meta := e.Client.Run(context.Background(), cmd, opt, outerr.NewRecordingOutErr())
+		if res.Err != nil {
+			t.Fatalf("Run() failed: %v", res.Err)
+		}
+		if gotRoot != e.ExecRoot {
+			t.Errorf("OutputValidator got exec root %q, want %q", gotRoot, e.ExecRoot)
+		}
+		if meta.OutputFiles != 1 {
+			t.Errorf("Run() gave %d output files, want the 1 kept by the validator", meta.OutputFiles)
+		}
+	})
+
+	t.Run("reject", func(t *testing.T) {
+		e.Client.OutputValidator = func(_ context.Context, _ *command.Command, ar *repb.ActionResult, _ string) error {
+			for _, f := range ar.OutputFiles {
+				if f.Digest.GetSizeBytes() == 0 {
+					return fmt.Errorf("output %s is empty", f.Path)
+				}
+			}
+			return nil
+		}
+		res, _ := e.Client.Run(context.Background(), cmd, opt, outerr.NewRecordingOutErr())
+		if res.Status != command.LocalErrorResultStatus {
+			t.Errorf("Run() gave result %+v, want a local error", res)
+		}
+	})
+}
+
+func TestUpdateRemoteCacheOutputValidatorRejects(t *testing.T) {
+	e, cleanup := fakes.NewTestEnv(t)
+	defer cleanup()
+	e.Client.OutputValidator = func(context.Context, *command.Command, *repb.ActionResult, string) error {
+		return errors.New("rejected")
+	}
+	cmd := &command.Command{Args: []string{"tool"}, ExecRoot: e.ExecRoot, OutputFiles: []string{"out"}}
+	if err := os.WriteFile(filepath.Join(e.ExecRoot, "out"), []byte("out"), 0644); err != nil {
+		t.Fatalf("failed to write output file: %v", err)
+	}
+	ec, err := e.Client.NewContext(context.Background(), cmd, command.DefaultExecutionOptions(), outerr.NewRecordingOutErr())
+	if err != nil {
+		t.Fatalf("failed creating execution context: %v", err)
+	}
+	ec.UpdateCachedResult()
+	if ec.Result.Status != command.LocalErrorResultStatus {
+		t.Errorf("UpdateCachedResult() gave result %+v, want a local error", ec.Result)
+	}
+	if n := e.Server.ActionCache.Writes(ec.Metadata.ActionDigest); n != 0 {
+		t.Errorf("UpdateCachedResult() wrote the rejected result to the action cache %d times", n)
+	}
+}
+
+func TestExecMiddleware(t *testing.T) {
+	e, cleanup := fakes.NewTestEnv(t)
+	defer cleanup()
+	e.Client.Middleware = []rexec.Middleware{
+		func(_ context.Context, cmd *command.Command, _ *command.ExecutionOptions) error {
+			if cmd.InputSpec.EnvironmentVariables == nil {
+				cmd.InputSpec.EnvironmentVariables = make(map[string]string)
+			}
+			cmd.InputSpec.EnvironmentVariables["ORG"] = "example"
+			return nil
+		},
+		func(_ context.Context, cmd *command.Command, opt *command.ExecutionOptions) error {
+			if cmd.Platform["container-image"] == "docker://old" {
+				cmd.Platform["container-image"] = "docker://new"
+			}
+			opt.Priority = 3
+			opt.CachePriority = 4
+			return nil
+		},
+	}
+	cmd := &command.Command{
+		Args:      []string{"tool"},
+		ExecRoot:  e.ExecRoot,
+		InputSpec: &command.InputSpec{},
+		Platform:  map[string]string{"container-image": "docker://old"},
+	}
+	opt := &command.ExecutionOptions{AcceptCached: false, DownloadOutputs: true, DownloadOutErr: true}
+	wantRes := &command.Result{Status: command.SuccessResultStatus}
+	cmdDg, _, _, _ := e.Set(cmd, opt, wantRes)
+
+	res, _ := e.Client.Run(context.Background(), cmd, opt, outerr.NewRecordingOutErr())
+
+	if diff := cmp.Diff(wantRes, res); diff != "" {
+		t.Errorf("Run() gave result diff (-want +got):\n%s", diff)
+	}
+	if opt.Priority != 0 {
+		t.Errorf("Run() modified the passed execution options")
+	}
+	if got := e.Server.Exec.LastExecuteRequest.GetExecutionPolicy().GetPriority(); got != 3 {
+		t.Errorf("Run() executed with priority %d, want 3", got)
+	}
+	if got := e.Server.Exec.LastExecuteRequest.GetResultsCachePolicy().GetPriority(); got != 4 {
+		t.Errorf("Run() executed with cache priority %d, want 4", got)
+	}
+	blob, ok := e.Server.CAS.Get(cmdDg)
+	if !ok {
+		t.Fatalf("Command %v is missing from the CAS", cmdDg)
+	}
+	cmdPb := &repb.Command{}
+	if err := proto.Unmarshal(blob, cmdPb); err != nil {
+		t.Fatalf("failed to unmarshal Command: %v", err)
+	}
+	wantEnv := []*repb.Command_EnvironmentVariable{{Name: "ORG", Value: "example"}}
+	if diff := cmp.Diff(wantEnv, cmdPb.EnvironmentVariables, cmp.Comparer(proto.Equal)); diff != "" {
+		t.Errorf("Run() executed Command with environment diff (-want +got):\n%s", diff)
+	}
+	wantPlatform := &repb.Platform{Properties: []*repb.Platform_Property{{Name: "container-image", Value: "docker://new"}}}
+	if diff := cmp.Diff(wantPlatform, cmdPb.Platform, cmp.Comparer(proto.Equal)); diff != "" {
+		t.Errorf("Run() executed Command with platform diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestPrepareCommandKeepsCommand(t *testing.T) {
+	e, cleanup := fakes.NewTestEnv(t)
+	defer cleanup()
+	e.Client.DefaultPlatform = map[string]string{"OSFamily": "linux"}
+	e.Client.Middleware = []rexec.Middleware{func(_ context.Context, cmd *command.Command, _ *command.ExecutionOptions) error {
+		cmd.Args = append(cmd.Args, "--flag")
+		cmd.InputSpec.Inputs = append(cmd.InputSpec.Inputs, "extra")
+		cmd.Platform["container-image"] = "docker://new"
+		return nil
+	}}
+	cmd := &command.Command{
+		Args:      []string{"tool"},
+		ExecRoot:  e.ExecRoot,
+		InputSpec: &command.InputSpec{Inputs: []string{"in"}},
+	}
+	want := cmd.Clone()
+	for i := 0; i < 2; i++ {
+		got, _, err := e.Client.PrepareCommand(context.Background(), cmd, command.DefaultExecutionOptions())
+		if err != nil {
+			t.Fatalf("PrepareCommand() failed: %v", err)
+		}
+		if diff := cmp.Diff([]string{"tool", "--flag"}, got.Args); diff != "" {
+			t.Errorf("PrepareCommand() #%d gave args diff (-want +got):\n%s", i, diff)
+		}
+		if diff := cmp.Diff([]string{"in", "extra"}, got.InputSpec.Inputs); diff != "" {
+			t.Errorf("PrepareCommand() #%d gave inputs diff (-want +got):\n%s", i, diff)
+		}
+	}
+	if diff := cmp.Diff(want, cmd); diff != "" {
+		t.Errorf("PrepareCommand() modified the command (-want +got):\n%s", diff)
+	}
+}
+
+func TestExecMiddlewareError(t *testing.T) {
+	e, cleanup := fakes.NewTestEnv(t)
+	defer cleanup()
+	e.Client.Middleware = []rexec.Middleware{
+		func(context.Context, *command.Command, *command.ExecutionOptions) error {
+			return errors.New("forbidden container image")
+		},
+	}
+	cmd := &command.Command{Args: []string{"tool"}, ExecRoot: e.ExecRoot}
+	res, _ := e.Client.Run(context.Background(), cmd, command.DefaultExecutionOptions(), outerr.NewRecordingOutErr())
+	if res.Status != command.LocalErrorResultStatus {
+		t.Errorf("Run() gave result %+v, want a local error", res)
+	}
+}
+
+func TestExecReexecPolicy(t *testing.T) {
+	policy := &rexec.ReexecPolicy{
+		MaxRetries:      2,
+		ExitCodes:       []int{137},
+		MessagePatterns: []*regexp.Regexp{regexp.MustCompile("worker lost")},
+	}
+	tests := []struct {
+		name        string
+		res         *command.Result
+		wantRetries int
+	}{
+		{
+			name:        "infra exit code",
+			res:         &command.Result{Status: command.NonZeroExitResultStatus, ExitCode: 137},
+			wantRetries: 2,
+		},
+		{
+			name:        "worker lost",
+			res:         &command.Result{Status: command.RemoteErrorResultStatus, Err: status.Error(codes.FailedPrecondition, "worker lost")},
+			wantRetries: 2,
+		},
+		{
+			name: "command failure",
+			res:  &command.Result{Status: command.NonZeroExitResultStatus, ExitCode: 1},
+		},
+		{
+			name: "success",
+			res:  &command.Result{Status: command.SuccessResultStatus},
+		},
+	}
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			e, cleanup := fakes.NewTestEnv(t)
+			defer cleanup()
+			e.Client.ReexecPolicy = policy
+			cmd := &command.Command{Args: []string{"tool"}, ExecRoot: e.ExecRoot}
+			opt := &command.ExecutionOptions{AcceptCached: false, DownloadOutputs: true, DownloadOutErr: true}
+			e.Set(cmd, opt, tc.res, fakes.StdOut("stdout"))
+			oe := outerr.NewRecordingOutErr()
+
+			res, meta := e.Client.Run(context.Background(), cmd, opt, oe)
+
+			if res.Status != tc.res.Status {
+				t.Errorf("Run() gave status %v, want %v", res.Status, tc.res.Status)
+			}
+			if len(meta.Reexecutions) != tc.wantRetries {
+				t.Errorf("Run() gave %d reexecutions, want %d: %+v", len(meta.Reexecutions), tc.wantRetries, meta.Reexecutions)
+			}
+			if got := e.Server.Exec.ExecuteCalls(); got != tc.wantRetries+1 {
+				t.Errorf("Run() called Execute %d times, want %d", got, tc.wantRetries+1)
+			}
+			if tc.res.Status != command.RemoteErrorResultStatus && string(oe.Stdout()) != "stdout" {
+				t.Errorf("Run() wrote stdout %q, want %q once", oe.Stdout(), "stdout")
+			}
+		})
+	}
+}
+
+func TestExecRetainsFailureArtifacts(t *testing.T) {
+	e, cleanup := fakes.NewTestEnv(t)
+	defer cleanup()
+	debugDir := t.TempDir()
+	e.Client.FailureArtifacts = &rexec.FailureArtifacts{Dir: debugDir, MaxActions: 1}
+	opt := &command.ExecutionOptions{AcceptCached: false, DownloadOutputs: false, DownloadOutErr: true}
+
+	var dirs []string
+	for _, arg := range []string{"first", "second"} {
+		cmd := &command.Command{Args: []string{"tool", arg}, ExecRoot: e.ExecRoot, OutputFiles: []string{"out"}}
+		e.Set(cmd, opt, &command.Result{ExitCode: 1, Status: command.NonZeroExitResultStatus},
+			fakes.StdErr("stderr "+arg), &fakes.OutputFile{Path: "out", Contents: "partial " + arg})
+		_, meta := e.Client.Run(context.Background(), cmd, opt, outerr.NewRecordingOutErr())
+		if meta.FailureArtifactsDir == "" {
+			t.Fatalf("Run(%s) did not retain failure artifacts", arg)
+		}
+		dirs = append(dirs, meta.FailureArtifactsDir)
+	}
+
+	for path, want := range map[string]string{
+		"stderr":      "stderr second",
+		"stdout":      "",
+		"outputs/out": "partial second",
+	} {
+		got, err := os.ReadFile(filepath.Join(dirs[1], path))
+		if err != nil {
+			t.Errorf("failed to read retained artifact %s: %v", path, err)
+		} else if string(got) != want {
+			t.Errorf("retained artifact %s = %q, want %q", path, got, want)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dirs[1], "action_result.textproto")); err != nil {
+		t.Errorf("ActionResult was not retained: %v", err)
+	}
+	if _, err := os.Stat(dirs[0]); !os.IsNotExist(err) {
+		t.Errorf("failure artifacts of the first action were not pruned: %v", err)
+	}
+}
+
+func TestExecFailureArtifactsNotRetained(t *testing.T) {
+	e, cleanup := fakes.NewTestEnv(t)
+	defer cleanup()
+	// The artifacts cannot be saved under a regular file.
+	debugDir := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(debugDir, nil, 0666); err != nil {
+		t.Fatalf("failed to write %s: %v", debugDir, err)
+	}
+	e.Client.FailureArtifacts = &rexec.FailureArtifacts{Dir: debugDir}
+	cmd := &command.Command{Args: []string{"tool"}, ExecRoot: e.ExecRoot}
+	opt := &command.ExecutionOptions{AcceptCached: false, DownloadOutputs: true, DownloadOutErr: true}
+	e.Set(cmd, opt, &command.Result{ExitCode: 1, Status: command.NonZeroExitResultStatus})
+	res, meta := e.Client.Run(context.Background(), cmd, opt, outerr.NewRecordingOutErr())
+	if res.Status != command.NonZeroExitResultStatus {
+		t.Errorf("Run() gave result %+v, want a non zero exit", res)
+	}
+	if meta.FailureArtifactsDir != "" {
+		t.Errorf("Run() reported failure artifacts in %s, which could not be retained", meta.FailureArtifactsDir)
+	}
+}
+
+func TestExecSuccessDoesNotRetainArtifacts(t *testing.T) {
+	e, cleanup := fakes.NewTestEnv(t)
+	defer cleanup()
+	debugDir := t.TempDir()
+	e.Client.FailureArtifacts = &rexec.FailureArtifacts{Dir: debugDir}
+	cmd := &command.Command{Args: []string{"tool"}, ExecRoot: e.ExecRoot}
+	opt := &command.ExecutionOptions{AcceptCached: false, DownloadOutputs: true, DownloadOutErr: true}
+	e.Set(cmd, opt, &command.Result{Status: command.SuccessResultStatus})
+	_, meta := e.Client.Run(context.Background(), cmd, opt, outerr.NewRecordingOutErr())
+	if meta.FailureArtifactsDir != "" {
+		t.Errorf("Run() retained failure artifacts of a successful action in %s", meta.FailureArtifactsDir)
+	}
+	if entries, _ := os.ReadDir(debugDir); len(entries) != 0 {
+		t.Errorf("Run() created %d entries in the failure artifacts directory, want none", len(entries))
+	}
+}
+
+func TestExecOutErrDigestsWithoutDownload(t *testing.T) {
+	e, cleanup := fakes.NewTestEnv(t)
+	defer cleanup()
+	cmd := &command.Command{Args: []string{"tool"}, ExecRoot: e.ExecRoot}
+	opt := &command.ExecutionOptions{AcceptCached: false, DownloadOutputs: false, DownloadOutErr: false}
+	_, _, stderrDg, stdoutDg := e.Set(cmd, opt, &command.Result{Status: command.SuccessResultStatus}, fakes.StdOut("stdout"), fakes.StdErr("stderr"))
+	oe := outerr.NewRecordingOutErr()
+
+	res, meta := e.Client.Run(context.Background(), cmd, opt, oe)
+
+	if res.Err != nil {
+		t.Fatalf("Run() failed: %v", res.Err)
+	}
+	if len(oe.Stdout()) != 0 || len(oe.Stderr()) != 0 {
+		t.Errorf("Run() downloaded stdout %q and stderr %q, want nothing", oe.Stdout(), oe.Stderr())
+	}
+	if meta.StdoutDigest != stdoutDg || meta.StderrDigest != stderrDg {
+		t.Errorf("Run() gave stdout/stderr digests %v, %v, want %v, %v", meta.StdoutDigest, meta.StderrDigest, stdoutDg, stderrDg)
+	}
+	if err := e.Client.FetchOutErr(context.Background(), meta, oe); err != nil {
+		t.Fatalf("FetchOutErr() failed: %v", err)
+	}
+	if string(oe.Stdout()) != "stdout" || string(oe.Stderr()) != "stderr" {
+		t.Errorf("FetchOutErr() gave stdout %q and stderr %q, want \"stdout\" and \"stderr\"", oe.Stdout(), oe.Stderr())
+	}
+}
+
+func TestExecSpeculativeUpload(t *testing.T) {
+	tests := []struct {
+		name       string
+		policy     *rexec.SpeculativeUpload
+		status     command.ResultStatus
+		wantUpload bool
+	}{
+		{
+			name:   "cache hit",
+			policy: &rexec.SpeculativeUpload{},
+			status: command.CacheHitResultStatus,
+		},
+		{
+			name:       "cache miss",
+			policy:     &rexec.SpeculativeUpload{},
+			status:     command.SuccessResultStatus,
+			wantUpload: true,
+		},
+		{
+			name:       "cache miss over the size limit",
+			policy:     &rexec.SpeculativeUpload{MaxInputBytes: 1},
+			status:     command.SuccessResultStatus,
+			wantUpload: true,
+		},
+	}
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			e, cleanup := fakes.NewTestEnv(t)
+			defer cleanup()
+			e.Client.SpeculativeUpload = tc.policy
+			fooBlob := []byte("hello")
+			if err := os.WriteFile(filepath.Join(e.ExecRoot, "foo"), fooBlob, 0777); err != nil {
+				t.Fatalf("failed to write input file: %v", err)
+			}
+			cmd := &command.Command{Args: []string{"tool"}, ExecRoot: e.ExecRoot, InputSpec: &command.InputSpec{Inputs: []string{"foo"}}}
+			opt := command.DefaultExecutionOptions()
+			e.Set(cmd, opt, &command.Result{Status: tc.status}, fakes.StdOut("stdout"))
+
+			res, meta := e.Client.Run(context.Background(), cmd, opt, outerr.NewRecordingOutErr())
+
+			if res.Status != tc.status {
+				t.Errorf("Run() gave status %v, want %v: %v", res.Status, tc.status, res.Err)
+			}
+			if tc.wantUpload {
+				if n := e.Server.CAS.BlobMissingReqs(digest.NewFromBlob(fooBlob)); n != 1 {
+					t.Errorf("Run() queried the input %d times, want 1", n)
+				}
+				if meta.EventTimes[command.EventUploadInputs] == nil {
+					t.Errorf("Run() gave no %s event", command.EventUploadInputs)
+				}
+			}
+		})
+	}
+}
+
+func TestSpeculativeUploadWithoutRemoteExecution(t *testing.T) {
+	e, cleanup := fakes.NewTestEnv(t)
+	defer cleanup()
+	e.Client.SpeculativeUpload = &rexec.SpeculativeUpload{}
+	if err := os.WriteFile(filepath.Join(e.ExecRoot, "foo"), []byte("hello"), 0777); err != nil {
+		t.Fatalf("failed to write input file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(e.ExecRoot, "out"), []byte("out"), 0777); err != nil {
+		t.Fatalf("failed to write output file: %v", err)
+	}
+	cmd := &command.Command{Args: []string{"tool"}, ExecRoot: e.ExecRoot, InputSpec: &command.InputSpec{Inputs: []string{"foo"}}, OutputFiles: []string{"out"}}
+	opt := command.DefaultExecutionOptions()
+
+	// A miss followed by a local execution stops the upload.
+	ec, err := e.Client.NewContext(context.Background(), cmd, opt, outerr.NewRecordingOutErr())
+	if err != nil {
+		t.Fatalf("failed creating execution context: %v", err)
+	}
+	ec.GetCachedResult()
+	if ec.Result != nil {
+		t.Fatalf("GetCachedResult() gave result %+v, want a cache miss", ec.Result)
+	}
+	ec.UpdateCachedResult()
+	if ec.Result.Status != command.SuccessResultStatus {
+		t.Errorf("UpdateCachedResult() gave result %+v, want success", ec.Result)
+	}
+	if n := e.Server.ActionCache.Writes(ec.Metadata.ActionDigest); n != 1 {
+		t.Errorf("UpdateCachedResult() wrote the result to the action cache %d times, want 1", n)
+	}
+	ec.Close()
+
+	// A miss that is abandoned is stopped by Close.
+	ec, err = e.Client.NewContext(context.Background(), &command.Command{Args: []string{"other"}, ExecRoot: e.ExecRoot, InputSpec: cmd.InputSpec}, opt, outerr.NewRecordingOutErr())
+	if err != nil {
+		t.Fatalf("failed creating execution context: %v", err)
+	}
+	ec.GetCachedResult()
+	if ec.Result != nil {
+		t.Fatalf("GetCachedResult() gave result %+v, want a cache miss", ec.Result)
+	}
+	ec.Close()
+	ec.Close()
+}
+
+func TestExecDiskCache(t *testing.T) {
+	e, cleanup := fakes.NewTestEnv(t)
+	defer cleanup()
+	dc, err := diskcache.New(t.TempDir(), 1<<20)
+	if err != nil {
+		t.Fatalf("diskcache.New() failed: %v", err)
+	}
+	e.Client.DiskCache = dc
+	cmd := &command.Command{
+		Args:        []string{"tool"},
+		ExecRoot:    e.ExecRoot,
+		InputSpec:   &command.InputSpec{},
+		OutputFiles: []string{"a/b/out"},
+	}
+	opt := command.DefaultExecutionOptions()
+	_, acDg, _, _ := e.Set(cmd, opt, &command.Result{Status: command.SuccessResultStatus}, &fakes.OutputFile{Path: "a/b/out", Contents: "output"})
+	outDg := digest.NewFromBlob([]byte("output"))
+	outPath := filepath.Join(e.ExecRoot, "a/b/out")
+
+	res, _ := e.Client.Run(context.Background(), cmd, opt, outerr.NewRecordingOutErr())
+	if res.Status != command.SuccessResultStatus {
+		t.Fatalf("Run() gave status %v, want %v: %v", res.Status, command.SuccessResultStatus, res.Err)
+	}
+	acReads, casReads := e.Server.ActionCache.Reads(acDg), e.Server.CAS.BlobReads(outDg)
+	if err := os.Remove(outPath); err != nil {
+		t.Fatalf("failed to remove output file: %v", err)
+	}
+
+	res, meta := e.Client.Run(context.Background(), cmd, opt, outerr.NewRecordingOutErr())
+	if res.Status != command.CacheHitResultStatus {
+		t.Fatalf("Run() gave status %v, want %v: %v", res.Status, command.CacheHitResultStatus, res.Err)
+	}
+	if !meta.CachedResult {
+		t.Errorf("Run() gave CachedResult = false, want true")
+	}
+	if n := e.Server.ActionCache.Reads(acDg); n != acReads {
+		t.Errorf("Run() read the remote action cache %d more times, want 0", n-acReads)
+	}
+	if n := e.Server.CAS.BlobReads(outDg); n != casReads {
+		t.Errorf("Run() read the output from the remote CAS %d more times, want 0", n-casReads)
+	}
+	if b, err := os.ReadFile(outPath); err != nil || string(b) != "output" {
+		t.Errorf("reading %s gave %q, %v, want %q", outPath, b, err, "output")
+	}
+	if fi, err := os.Stat(outPath); err != nil {
+		t.Errorf("os.Stat(%s) failed: %v", outPath, err)
+	} else if fi.Mode().Perm() != e.Client.GrpcClient.RegularMode {
+		t.Errorf("%s has mode %v, want %v", outPath, fi.Mode(), e.Client.GrpcClient.RegularMode)
+	}
+	if st := dc.GetStats(); st.ActionCacheHits != 1 || st.CasHits != 1 {
+		t.Errorf("disk cache stats = %+v, want 1 action cache hit and 1 CAS hit", st)
+	}
+}
+
+func TestExecDiskCacheAfterRemoteEviction(t *testing.T) {
+	e, cleanup := fakes.NewTestEnv(t)
+	defer cleanup()
+	dc, err := diskcache.New(t.TempDir(), 1<<20)
+	if err != nil {
+		t.Fatalf("diskcache.New() failed: %v", err)
+	}
+	e.Client.DiskCache = dc
+	if err := os.MkdirAll(filepath.Join(e.ExecRoot, "dir"), os.ModePerm); err != nil {
+		t.Fatalf("failed to create dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(e.ExecRoot, "dir/f"), []byte("f"), 0644); err != nil {
+		t.Fatalf("failed to write dir/f: %v", err)
+	}
+	cmd := &command.Command{
+		Args:       []string{"tool"},
+		ExecRoot:   e.ExecRoot,
+		InputSpec:  &command.InputSpec{},
+		OutputDirs: []string{"dir"},
+	}
+	opt := command.DefaultExecutionOptions()
+	e.Set(cmd, opt, &command.Result{Status: command.SuccessResultStatus}, &fakes.OutputDir{Path: "dir"}, fakes.StdOut("stdout"))
+	if res, _ := e.Client.Run(context.Background(), cmd, opt, outerr.NewRecordingOutErr()); res.Status != command.SuccessResultStatus {
+		t.Fatalf("Run() gave status %v, want %v: %v", res.Status, command.SuccessResultStatus, res.Err)
+	}
+	// The remote CAS evicts everything: the disk cache must hold the stdout and the Tree too.
+	e.Server.CAS.Clear()
+	if err := os.RemoveAll(filepath.Join(e.ExecRoot, "dir")); err != nil {
+		t.Fatalf("failed to remove dir: %v", err)
+	}
+
+	oe := outerr.NewRecordingOutErr()
+	res, _ := e.Client.Run(context.Background(), cmd, opt, oe)
+	if res.Status != command.CacheHitResultStatus {
+		t.Fatalf("Run() gave status %v, want %v: %v", res.Status, command.CacheHitResultStatus, res.Err)
+	}
+	if got := string(oe.Stdout()); got != "stdout" {
+		t.Errorf("Run() gave stdout %q, want %q", got, "stdout")
+	}
+	if b, err := os.ReadFile(filepath.Join(e.ExecRoot, "dir/f")); err != nil || string(b) != "f" {
+		t.Errorf("reading dir/f gave %q, %v, want %q", b, err, "f")
+	}
+}
+
+func TestExecDiskCacheStaleResult(t *testing.T) {
+	e, cleanup := fakes.NewTestEnv(t)
+	defer cleanup()
+	dc, err := diskcache.New(t.TempDir(), 1<<20)
+	if err != nil {
+		t.Fatalf("diskcache.New() failed: %v", err)
+	}
+	e.Client.DiskCache = dc
+	cmd := &command.Command{
+		Args:        []string{"tool"},
+		ExecRoot:    e.ExecRoot,
+		InputSpec:   &command.InputSpec{},
+		OutputFiles: []string{"out"},
+	}
+	opt := command.DefaultExecutionOptions()
+	_, acDg, _, _ := e.Set(cmd, opt, &command.Result{Status: command.CacheHitResultStatus}, &fakes.OutputFile{Path: "out", Contents: "output"})
+	// A disk cached result whose output is in neither CAS.
+	gone := digest.NewFromBlob([]byte("gone"))
+	stale := &repb.ActionResult{OutputFiles: []*repb.OutputFile{{Path: "out", Digest: gone.ToProto()}}}
+	if err := dc.StoreActionCache(acDg, stale); err != nil {
+		t.Fatalf("StoreActionCache() failed: %v", err)
+	}
+
+	res, _ := e.Client.Run(context.Background(), cmd, opt, outerr.NewRecordingOutErr())
+	if res.Status != command.CacheHitResultStatus {
+		t.Fatalf("Run() gave status %v, want %v: %v", res.Status, command.CacheHitResultStatus, res.Err)
+	}
+	if b, err := os.ReadFile(filepath.Join(e.ExecRoot, "out")); err != nil || string(b) != "output" {
+		t.Errorf("reading out gave %q, %v, want %q", b, err, "output")
+	}
+	ar, ok := dc.LoadActionCache(acDg)
+	if !ok {
+		t.Fatalf("the remote result was not stored in the disk cache")
+	}
+	if got := digest.NewFromProtoUnvalidated(ar.OutputFiles[0].Digest); got == gone {
+		t.Errorf("the stale disk cached result was kept")
+	}
+}
@@ -0,0 +1,69 @@
+package rexec
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/command"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/outerr"
+)
+
+// Backend is a remote execution backend that commands are routed to by platform properties.
+type Backend struct {
+	// Platform holds the platform properties a command must have, with the same values, to be
+	// routed to this backend. An empty Platform matches all commands.
+	Platform map[string]string
+	// Client executes the commands routed to this backend. It carries the backend's own connection,
+	// credentials and capabilities.
+	Client *Client
+}
+
+func (b *Backend) matches(cmd *command.Command) bool {
+	for name, val := range b.Platform {
+		if v, ok := cmd.Platform[name]; !ok || v != val {
+			return false
+		}
+	}
+	return true
+}
+
+// Router selects one of several remote execution backends for each command, by matching the
+// command's platform properties, and executes it there. For example, commands with
+// OSFamily=Windows may be routed to a different cluster than all other commands.
+type Router struct {
+	// Backends are tried in order, and the first one matching the command is used.
+	Backends []*Backend
+	// Default, if set, is used for commands that match none of the Backends.
+	Default *Client
+}
+
+// ClientFor returns the client of the backend the command is routed to.
+func (r *Router) ClientFor(cmd *command.Command) (*Client, error) {
+	for _, b := range r.Backends {
+		if b.matches(cmd) {
+			return b.Client, nil
+		}
+	}
+	if r.Default != nil {
+		return r.Default, nil
+	}
+	return nil, fmt.Errorf("no backend matches the platform %v", cmd.Platform)
+}
+
+// NewContext starts a new Context for a given command on the backend it is routed to.
+func (r *Router) NewContext(ctx context.Context, cmd *command.Command, opt *command.ExecutionOptions, oe outerr.OutErr) (*Context, error) {
+	c, err := r.ClientFor(cmd)
+	if err != nil {
+		return nil, err
+	}
+	return c.NewContext(ctx, cmd, opt, oe)
+}
+
+// Run executes a command remotely on the backend it is routed to.
+func (r *Router) Run(ctx context.Context, cmd *command.Command, opt *command.ExecutionOptions, oe outerr.OutErr) (*command.Result, *command.Metadata) {
+	c, err := r.ClientFor(cmd)
+	if err != nil {
+		return command.NewLocalErrorResult(err), &command.Metadata{}
+	}
+	return c.Run(ctx, cmd, opt, oe)
+}
@@ -0,0 +1,163 @@
+package rexec_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/command"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/fakes"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/outerr"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/rexec"
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestRouterRun(t *testing.T) {
+	linux, cleanup := fakes.NewTestEnv(t)
+	defer cleanup()
+	windows, cleanup := fakes.NewTestEnv(t)
+	defer cleanup()
+	r := &rexec.Router{
+		Backends: []*rexec.Backend{{Platform: map[string]string{"OSFamily": "Windows"}, Client: windows.Client}},
+		Default:  linux.Client,
+	}
+	opt := &command.ExecutionOptions{AcceptCached: false, DownloadOutputs: true, DownloadOutErr: true}
+	wantRes := &command.Result{Status: command.SuccessResultStatus}
+	tests := []struct {
+		name       string
+		platform   map[string]string
+		env        *fakes.TestEnv
+		wantStdout string
+	}{
+		{
+			name:       "matching backend",
+			platform:   map[string]string{"OSFamily": "Windows", "Pool": "default"},
+			env:        windows,
+			wantStdout: "windows",
+		},
+		{
+			name:       "default",
+			platform:   map[string]string{"OSFamily": "Linux"},
+			env:        linux,
+			wantStdout: "linux",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			cmd := &command.Command{Args: []string{"tool"}, ExecRoot: tc.env.ExecRoot, Platform: tc.platform}
+			tc.env.Set(cmd, opt, wantRes, fakes.StdOutRaw(tc.wantStdout))
+			oe := outerr.NewRecordingOutErr()
+
+			res, _ := r.Run(context.Background(), cmd, opt, oe)
+
+			if diff := cmp.Diff(wantRes, res); diff != "" {
+				t.Errorf("Run() gave result diff (-want +got):\n%s", diff)
+			}
+			if !bytes.Equal(oe.Stdout(), []byte(tc.wantStdout)) {
+				t.Errorf("Run() gave stdout %q, want %q", oe.Stdout(), tc.wantStdout)
+			}
+		})
+	}
+}
+
+func TestRouterNoMatch(t *testing.T) {
+	e, cleanup := fakes.NewTestEnv(t)
+	defer cleanup()
+	r := &rexec.Router{Backends: []*rexec.Backend{{Platform: map[string]string{"OSFamily": "Windows"}, Client: e.Client}}}
+	cmd := &command.Command{Args: []string{"tool"}, ExecRoot: e.ExecRoot, Platform: map[string]string{"OSFamily": "Linux"}}
+
+	res, _ := r.Run(context.Background(), cmd, command.DefaultExecutionOptions(), outerr.NewRecordingOutErr())
+
+	if res.Status != command.LocalErrorResultStatus {
+		t.Errorf("Run() gave status %v, want %v", res.Status, command.LocalErrorResultStatus)
+	}
+}
+
+func TestRacerRun(t *testing.T) {
+	opt := &command.ExecutionOptions{AcceptCached: false, DownloadOutputs: true, DownloadOutErr: true}
+	wantRes := &command.Result{Status: command.SuccessResultStatus}
+	tests := []struct {
+		name          string
+		delay         time.Duration
+		primaryQueue  time.Duration
+		primaryRes    *command.Result
+		wantStdout    string
+		wantSecondary int
+	}{
+		{
+			name:          "primary wins before the delay",
+			delay:         time.Minute,
+			primaryRes:    wantRes,
+			wantStdout:    "primary",
+			wantSecondary: 0,
+		},
+		{
+			name:          "secondary wins a slow primary",
+			primaryQueue:  time.Minute,
+			primaryRes:    wantRes,
+			wantStdout:    "secondary",
+			wantSecondary: 1,
+		},
+		{
+			name:          "primary fails before the delay",
+			delay:         time.Minute,
+			primaryRes:    &command.Result{Status: command.RemoteErrorResultStatus, Err: status.Error(codes.FailedPrecondition, "worker lost")},
+			wantStdout:    "secondary",
+			wantSecondary: 1,
+		},
+	}
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			primary, cleanup := fakes.NewTestEnv(t)
+			defer cleanup()
+			secondary, cleanup := fakes.NewTestEnv(t)
+			defer cleanup()
+			// Both backends share the exec root, as they would for the same build.
+			cmd := &command.Command{Args: []string{"tool"}, ExecRoot: primary.ExecRoot}
+			primary.Set(cmd, opt, tc.primaryRes, fakes.StdOutRaw("primary"))
+			primary.Server.Exec.QueueDelay = tc.primaryQueue
+			secondary.Set(cmd, opt, wantRes, fakes.StdOutRaw("secondary"))
+			r := &rexec.Racer{Primary: primary.Client, Secondary: secondary.Client, Delay: tc.delay}
+			oe := outerr.NewRecordingOutErr()
+
+			res, _ := r.Run(context.Background(), cmd, opt, oe)
+
+			if diff := cmp.Diff(wantRes, res); diff != "" {
+				t.Errorf("Run() gave result diff (-want +got):\n%s", diff)
+			}
+			if !bytes.Equal(oe.Stdout(), []byte(tc.wantStdout)) {
+				t.Errorf("Run() gave stdout %q, want %q", oe.Stdout(), tc.wantStdout)
+			}
+			if got := secondary.Server.Exec.ExecuteCalls(); got != tc.wantSecondary {
+				t.Errorf("Run() executed on the secondary %d times, want %d", got, tc.wantSecondary)
+			}
+		})
+	}
+}
+
+func TestRacerRunDoesNotShareCommand(t *testing.T) {
+	primary, cleanup := fakes.NewTestEnv(t)
+	defer cleanup()
+	secondary, cleanup := fakes.NewTestEnv(t)
+	defer cleanup()
+	// Middleware of one backend must not affect the command executed by the other, nor the caller's.
+	primary.Client.Middleware = []rexec.Middleware{func(_ context.Context, cmd *command.Command, _ *command.ExecutionOptions) error {
+		cmd.Identifiers.ToolName = "primary"
+		cmd.InputSpec.Inputs = append(cmd.InputSpec.Inputs[:0], "primary")
+		return nil
+	}}
+	cmd := &command.Command{Args: []string{"tool"}, ExecRoot: primary.ExecRoot, InputSpec: &command.InputSpec{Inputs: []string{"in"}}}
+	opt := &command.ExecutionOptions{AcceptCached: false, DownloadOutErr: true}
+	r := &rexec.Racer{Primary: primary.Client, Secondary: secondary.Client}
+	want := cmd.Clone()
+
+	r.Run(context.Background(), cmd, opt, outerr.NewRecordingOutErr())
+
+	if diff := cmp.Diff(want, cmd); diff != "" {
+		t.Errorf("Run() modified the command (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,85 @@
+package rexec
+
+import (
+	"context"
+	"time"
+
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/command"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/digest"
+
+	log "github.com/golang/glog"
+)
+
+// SpeculativeUpload configures uploading the inputs of an action while its action cache lookup is
+// in flight, rather than after it missed. This trades bandwidth, spent on the inputs of actions
+// that turn out to be cached, for latency on cache misses. The upload is canceled on a cache hit.
+// On a miss, it is used by ExecuteRemotely, and canceled by UpdateCachedResult or Close, which
+// callers not executing the action remotely must call.
+type SpeculativeUpload struct {
+	// MaxInputBytes is the largest total input size of an action whose inputs are uploaded
+	// speculatively, limiting the bandwidth wasted on cache hits. Non-positive means no limit.
+	MaxInputBytes int64
+}
+
+// speculativeUpload is an input upload started before the cache lookup completed.
+type speculativeUpload struct {
+	cancel  context.CancelFunc
+	done    chan struct{}
+	start   time.Time
+	missing []digest.Digest
+	bytes   int64
+	err     error
+}
+
+// startSpeculativeUpload starts uploading the inputs in the background, if the client's
+// SpeculativeUpload policy allows it.
+func (ec *Context) startSpeculativeUpload() {
+	ec.cancelSpeculativeUpload()
+	p := ec.client.SpeculativeUpload
+	if p == nil || p.MaxInputBytes > 0 && ec.Metadata.TotalInputBytes > p.MaxInputBytes {
+		return
+	}
+	ctx, cancel := context.WithCancel(ec.ctx)
+	su := &speculativeUpload{cancel: cancel, done: make(chan struct{}), start: time.Now()}
+	go func() {
+		defer close(su.done)
+		su.missing, su.bytes, su.err = ec.client.GrpcClient.UploadIfMissing(ctx, ec.inputBlobs...)
+	}()
+	ec.specUpload = su
+}
+
+// cancelSpeculativeUpload stops the speculative upload, if any, when the inputs are not needed.
+func (ec *Context) cancelSpeculativeUpload() {
+	su := ec.specUpload
+	if su == nil {
+		return
+	}
+	ec.specUpload = nil
+	su.cancel()
+	<-su.done
+	log.V(1).Infof("%s %s> Canceled the speculative input upload", ec.cmd.Identifiers.CommandID, ec.cmd.Identifiers.ExecutionID)
+}
+
+// Close releases the resources of the Context, canceling the speculative input upload if it was
+// not used. The Context must not be used afterwards.
+func (ec *Context) Close() {
+	ec.cancelSpeculativeUpload()
+}
+
+// awaitSpeculativeUpload waits for the speculative upload, if any, and returns whether it uploaded
+// the inputs. A failed speculative upload is reported as not done, so that the upload is retried.
+func (ec *Context) awaitSpeculativeUpload() (missing []digest.Digest, bytes int64, ok bool) {
+	su := ec.specUpload
+	if su == nil {
+		return nil, 0, false
+	}
+	ec.specUpload = nil
+	<-su.done
+	su.cancel()
+	if su.err != nil {
+		log.Warningf("%s %s> Speculative input upload failed, uploading again: %v", ec.cmd.Identifiers.CommandID, ec.cmd.Identifiers.ExecutionID, su.err)
+		return nil, 0, false
+	}
+	ec.Metadata.EventTimes[command.EventUploadInputs] = &command.TimeInterval{From: su.start, To: time.Now()}
+	return su.missing, su.bytes, true
+}
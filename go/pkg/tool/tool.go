@@ -26,6 +26,7 @@ import (
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/filemetadata"
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/outerr"
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/rexec"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/tree"
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/uploadinfo"
 
 	cpb "github.com/bazelbuild/remote-apis-sdks/go/api/command"
@@ -271,7 +272,7 @@ func (c *Client) DownloadBlob(ctx context.Context, blobDigest, path string) (str
 
 // UploadBlob uploads a blob from the specified path into the remote cache.
 func (c *Client) UploadBlob(ctx context.Context, path string) error {
-	dg, err := digest.NewFromFile(path)
+	dg, err := c.GrpcClient.DigestFunction().NewFromFile(path)
 	if err != nil {
 		return err
 	}
@@ -325,6 +326,35 @@ func (c *Client) DownloadDirectory(ctx context.Context, rootDigest, path string)
 	return err
 }
 
+// DiffTrees compares the input roots with the given digests, read from the remote cache, and
+// returns the paths that differ, one per line.
+func (c *Client) DiffTrees(ctx context.Context, rootDigest, otherRootDigest string) (string, error) {
+	dgA, err := digest.NewFromString(rootDigest)
+	if err != nil {
+		return "", err
+	}
+	dgB, err := digest.NewFromString(otherRootDigest)
+	if err != nil {
+		return "", err
+	}
+	fetch := func(ctx context.Context, dg digest.Digest) (*repb.Directory, error) {
+		dir := &repb.Directory{}
+		if _, err := c.GrpcClient.ReadProto(ctx, dg, dir); err != nil {
+			return nil, err
+		}
+		return dir, nil
+	}
+	changes, err := tree.Diff(ctx, dgA, dgB, fetch)
+	if err != nil {
+		return "", err
+	}
+	var res bytes.Buffer
+	for _, ch := range changes {
+		res.WriteString(ch.String() + "\n")
+	}
+	return res.String(), nil
+}
+
 // UploadStats contains various metadata of a directory upload.
 type UploadStats struct {
 	rc.TreeStats
@@ -562,7 +592,7 @@ func (c *Client) prepProtos(ctx context.Context, actionRoot string) (string, err
 	if err := prototext.Unmarshal(cmdTxt, cmdPb); err != nil {
 		return "", err
 	}
-	ue, err := uploadinfo.EntryFromProto(cmdPb)
+	ue, err := uploadinfo.EntryFromProtoWith(c.GrpcClient.DigestFunction(), cmdPb)
 	if err != nil {
 		return "", err
 	}
@@ -577,19 +607,19 @@ func (c *Client) prepProtos(ctx context.Context, actionRoot string) (string, err
 	if err := prototext.Unmarshal(ac, acPb); err != nil {
 		return "", err
 	}
-	dg, err := digest.NewFromMessage(cmdPb)
+	dg, err := c.GrpcClient.DigestFunction().NewFromMessage(cmdPb)
 	if err != nil {
 		return "", err
 	}
 	acPb.CommandDigest = dg.ToProto()
-	ue, err = uploadinfo.EntryFromProto(acPb)
+	ue, err = uploadinfo.EntryFromProtoWith(c.GrpcClient.DigestFunction(), acPb)
 	if err != nil {
 		return "", err
 	}
 	if _, _, err := c.GrpcClient.UploadIfMissing(ctx, ue); err != nil {
 		return "", err
 	}
-	dg, err = digest.NewFromMessage(acPb)
+	dg, err = c.GrpcClient.DigestFunction().NewFromMessage(acPb)
 	if err != nil {
 		return "", err
 	}
@@ -626,6 +656,7 @@ func (c *Client) ExecuteAction(ctx context.Context, actionDigest, actionRoot, ou
 	if err != nil {
 		return nil, err
 	}
+	defer ec.Close()
 	ec.ExecuteRemotely()
 	fmt.Printf("Action complete\n")
 	fmt.Printf("---------------\n")
@@ -651,6 +682,10 @@ func (c *Client) ExecuteAction(ctx context.Context, actionDigest, actionRoot, ou
 		oe.WriteErr([]byte(fmt.Sprintf("Remote execution error: %v.\n", ec.Result.Err)))
 	case command.LocalErrorResultStatus:
 		oe.WriteErr([]byte(fmt.Sprintf("Local error: %v.\n", ec.Result.Err)))
+	case command.ClientDeadlineResultStatus:
+		oe.WriteErr([]byte(fmt.Sprintf("Gave up waiting for the remote action: %v.\n", ec.Result.Err)))
+	case command.CancelledResultStatus:
+		oe.WriteErr([]byte(fmt.Sprintf("Remote execution was cancelled: %v.\n", ec.Result.Err)))
 	}
 	if ec.Result.Err == nil && outDir != "" {
 		ec.DownloadOutputs(outDir)
@@ -792,17 +827,10 @@ func (c *Client) getInputTree(ctx context.Context, root *repb.Digest) (string, [
 	}
 	res.WriteString(fmt.Sprintf("[Root directory digest: %v]", dg))
 
-	dirs, err := c.GrpcClient.GetDirectoryTree(ctx, root)
+	t, err := c.GrpcClient.GetDirectoryTreeProto(ctx, root)
 	if err != nil {
 		return "", nil, err
 	}
-	if len(dirs) == 0 {
-		return "", nil, fmt.Errorf("Empty directories returned by GetTree for %v", dg)
-	}
-	t := &repb.Tree{
-		Root:     dirs[0],
-		Children: dirs,
-	}
 	inputs, paths, err := c.flattenTree(ctx, t)
 	if err != nil {
 		return "", nil, err
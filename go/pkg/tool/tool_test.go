@@ -14,6 +14,7 @@ import (
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/outerr"
 	"github.com/google/go-cmp/cmp"
 	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/proto"
 
 	cpb "github.com/bazelbuild/remote-apis-sdks/go/api/command"
 	repb "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
@@ -411,6 +412,33 @@ func TestTool_DownloadBlob(t *testing.T) {
 	}
 }
 
+func TestTool_DiffTrees(t *testing.T) {
+	e, cleanup := fakes.NewTestEnv(t)
+	defer cleanup()
+	cas := e.Server.CAS
+	fooDg := cas.Put([]byte("foo"))
+	barDg := cas.Put([]byte("bar"))
+	putDir := func(dir *repb.Directory) digest.Digest {
+		blob, err := proto.Marshal(dir)
+		if err != nil {
+			t.Fatalf("proto.Marshal(%v) failed: %v", dir, err)
+		}
+		return cas.Put(blob)
+	}
+	rootA := putDir(&repb.Directory{Files: []*repb.FileNode{{Name: "a", Digest: fooDg.ToProto()}}})
+	rootB := putDir(&repb.Directory{Files: []*repb.FileNode{{Name: "a", Digest: barDg.ToProto()}, {Name: "b", Digest: fooDg.ToProto()}}})
+
+	toolClient := &Client{GrpcClient: e.Client.GrpcClient}
+	got, err := toolClient.DiffTrees(context.Background(), rootA.String(), rootB.String())
+	if err != nil {
+		t.Fatalf("DiffTrees(%v, %v) failed: %v", rootA, rootB, err)
+	}
+	want := fmt.Sprintf("modified a: file %v -> file %v\nadded b: file %v\n", fooDg, barDg, fooDg)
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("DiffTrees(%v, %v) returned diff (-want +got): %v", rootA, rootB, diff)
+	}
+}
+
 func TestTool_UploadBlob(t *testing.T) {
 	e, cleanup := fakes.NewTestEnv(t)
 	defer cleanup()
@@ -0,0 +1,123 @@
+package tree
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/digest"
+
+	repb "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+)
+
+// WalkFunc is called by Walk and WalkDirectory for each node of a tree, with its slash-separated
+// path relative to the root, "." for the root itself. As with filepath.WalkDir, returning
+// fs.SkipDir for a directory skips its contents, and for a file or symlink skips the remaining
+// nodes of its directory; returning fs.SkipAll stops the walk without error. Any other error stops
+// the walk and is returned.
+type WalkFunc func(path string, n *Node) error
+
+// Walk calls fn for each node of the tree, in lexical order of the paths, directories before their
+// contents. The directories are looked up among the children of the tree, digested with the
+// default digest function.
+func Walk(t *repb.Tree, fn WalkFunc) error {
+	return WalkWith(digest.Function{}, t, fn)
+}
+
+// WalkWith is like Walk, for a tree whose directories are digested with the digest function df,
+// such as client.Client.DigestFunction of the client that built or downloaded it.
+func WalkWith(df digest.Function, t *repb.Tree, fn WalkFunc) error {
+	root, err := df.NewFromMessage(t.GetRoot())
+	if err != nil {
+		return err
+	}
+	dirs := map[digest.Digest]*repb.Directory{root: t.GetRoot()}
+	for _, dir := range t.GetChildren() {
+		dg, err := df.NewFromMessage(dir)
+		if err != nil {
+			return err
+		}
+		dirs[dg] = dir
+	}
+	fetch := func(ctx context.Context, dg digest.Digest) (*repb.Directory, error) {
+		if dir, ok := dirs[dg]; ok {
+			return dir, nil
+		}
+		return nil, fmt.Errorf("directory %v is not in the tree", dg)
+	}
+	return WalkDirectory(context.Background(), root, fetch, fn)
+}
+
+// WalkDirectory is like Walk, for the tree rooted at the Directory with the given digest, whose
+// directories are fetched as they are walked.
+func WalkDirectory(ctx context.Context, root digest.Digest, fetch FetchFunc, fn WalkFunc) error {
+	if err := walkDir(ctx, ".", root, fetch, fn); err != nil && err != fs.SkipAll {
+		return err
+	}
+	return nil
+}
+
+func walkDir(ctx context.Context, p string, dg digest.Digest, fetch FetchFunc, fn WalkFunc) error {
+	dir, err := fetch(ctx, dg)
+	if err != nil {
+		return fmt.Errorf("failed to fetch directory %s (%v): %w", p, dg, err)
+	}
+	if err := fn(p, &Node{Digest: dg, IsDirectory: true, NodeProperties: dir.NodeProperties}); err != nil {
+		if err == fs.SkipDir {
+			return nil
+		}
+		return err
+	}
+	ns := nodes(dir)
+	names := make([]string, 0, len(ns))
+	for name := range ns {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		n, np := ns[name], path.Join(p, name)
+		if n.IsDirectory {
+			err = walkDir(ctx, np, n.Digest, fetch, fn)
+		} else {
+			err = fn(np, n)
+		}
+		switch {
+		case err == fs.SkipDir:
+			return nil
+		case err != nil:
+			return err
+		}
+	}
+	return nil
+}
+
+// Flatten returns the leaves of the tree by path: its files, symlinks and empty directories,
+// including the root if it is empty. The directories are digested with the default digest
+// function.
+func Flatten(t *repb.Tree) (map[string]*Node, error) {
+	return FlattenWith(digest.Function{}, t)
+}
+
+// FlattenWith is like Flatten, for a tree whose directories are digested with the digest function
+// df.
+func FlattenWith(df digest.Function, t *repb.Tree) (map[string]*Node, error) {
+	leaves := make(map[string]*Node)
+	// nonEmpty holds the directories with contents, which are not leaves.
+	nonEmpty := make(map[string]bool)
+	err := WalkWith(df, t, func(p string, n *Node) error {
+		if p != "." {
+			nonEmpty[path.Dir(p)] = true
+		}
+		leaves[p] = n
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for p := range nonEmpty {
+		delete(leaves, p)
+	}
+	return leaves, nil
+}
@@ -0,0 +1,140 @@
+package tree
+
+import (
+	"errors"
+	"io/fs"
+	"testing"
+
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/digest"
+	"github.com/google/go-cmp/cmp"
+
+	repb "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+)
+
+func testTree(t *testing.T) (*repb.Tree, digest.Digest) {
+	t.Helper()
+	fooDg := digest.NewFromBlob([]byte("foo"))
+	empty := &repb.Directory{}
+	sub := &repb.Directory{
+		Files:       []*repb.FileNode{{Name: "foo", Digest: fooDg.ToProto(), IsExecutable: true}},
+		Directories: []*repb.DirectoryNode{{Name: "empty", Digest: digest.TestNewFromMessage(empty).ToProto()}},
+	}
+	root := &repb.Directory{
+		Files:       []*repb.FileNode{{Name: "b", Digest: fooDg.ToProto()}},
+		Directories: []*repb.DirectoryNode{{Name: "a", Digest: digest.TestNewFromMessage(sub).ToProto()}},
+		Symlinks:    []*repb.SymlinkNode{{Name: "c", Target: "a/foo"}},
+	}
+	return &repb.Tree{Root: root, Children: []*repb.Directory{sub, empty}}, fooDg
+}
+
+func TestFlatten(t *testing.T) {
+	tr, fooDg := testTree(t)
+	got, err := Flatten(tr)
+	if err != nil {
+		t.Fatalf("Flatten() failed: %v", err)
+	}
+	want := map[string]*Node{
+		"a/empty": {Digest: digest.Empty, IsDirectory: true},
+		"a/foo":   {Digest: fooDg, IsExecutable: true},
+		"b":       {Digest: fooDg},
+		"c":       {SymlinkTarget: "a/foo"},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Flatten() gave diff (-want +got):\n%s", diff)
+	}
+
+	got, err = Flatten(&repb.Tree{Root: &repb.Directory{}})
+	if err != nil {
+		t.Fatalf("Flatten() of an empty tree failed: %v", err)
+	}
+	if len(got) != 1 || got["."] == nil || !got["."].IsDirectory {
+		t.Errorf("Flatten() of an empty tree = %v, want the empty root", got)
+	}
+}
+
+func TestFlattenWith(t *testing.T) {
+	df, err := digest.NewFunction(repb.DigestFunction_SHA512)
+	if err != nil {
+		t.Fatalf("NewFunction(SHA512) failed: %v", err)
+	}
+	fooDg := df.NewFromBlob([]byte("foo"))
+	sub := &repb.Directory{Files: []*repb.FileNode{{Name: "foo", Digest: fooDg.ToProto()}}}
+	subDg, err := df.NewFromMessage(sub)
+	if err != nil {
+		t.Fatalf("NewFromMessage() failed: %v", err)
+	}
+	tr := &repb.Tree{
+		Root:     &repb.Directory{Directories: []*repb.DirectoryNode{{Name: "a", Digest: subDg.ToProto()}}},
+		Children: []*repb.Directory{sub},
+	}
+	if _, err := Flatten(tr); err == nil {
+		t.Errorf("Flatten() of a tree digested with %v succeeded, want error", df)
+	}
+	got, err := FlattenWith(df, tr)
+	if err != nil {
+		t.Fatalf("FlattenWith(%v) failed: %v", df, err)
+	}
+	if diff := cmp.Diff(map[string]*Node{"a/foo": {Digest: fooDg}}, got); diff != "" {
+		t.Errorf("FlattenWith(%v) gave diff (-want +got):\n%s", df, diff)
+	}
+}
+
+func TestFlattenMissingDirectory(t *testing.T) {
+	tr, _ := testTree(t)
+	tr.Children = tr.Children[:1]
+	if _, err := Flatten(tr); err == nil {
+		t.Errorf("Flatten() of a tree missing a directory succeeded, want error")
+	}
+}
+
+func TestWalk(t *testing.T) {
+	tr, _ := testTree(t)
+	tests := []struct {
+		desc string
+		// stop maps the paths to the error returned for them.
+		stop map[string]error
+		want []string
+		err  bool
+	}{
+		{
+			desc: "all",
+			want: []string{".", "a", "a/empty", "a/foo", "b", "c"},
+		},
+		{
+			desc: "skip directory",
+			stop: map[string]error{"a": fs.SkipDir},
+			want: []string{".", "a", "b", "c"},
+		},
+		{
+			desc: "skip rest of directory",
+			stop: map[string]error{"b": fs.SkipDir},
+			want: []string{".", "a", "a/empty", "a/foo", "b"},
+		},
+		{
+			desc: "skip all",
+			stop: map[string]error{"b": fs.SkipAll},
+			want: []string{".", "a", "a/empty", "a/foo", "b"},
+		},
+		{
+			desc: "error",
+			stop: map[string]error{"a/foo": errors.New("stop")},
+			want: []string{".", "a", "a/empty", "a/foo"},
+			err:  true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			var got []string
+			err := Walk(tr, func(p string, n *Node) error {
+				got = append(got, p)
+				return tc.stop[p]
+			})
+			if (err != nil) != tc.err {
+				t.Errorf("Walk() returned error %v, want error: %v", err, tc.err)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("Walk() visited diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
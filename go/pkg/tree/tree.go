@@ -0,0 +1,184 @@
+// Package tree walks, flattens and compares Merkle trees of Directory protos, e.g. the outputs of an
+// action or the input roots of two actions that were expected to hit the same cache entry.
+package tree
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/digest"
+	"google.golang.org/protobuf/proto"
+
+	repb "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+)
+
+// FetchFunc returns the Directory with the given digest, typically read from the CAS.
+type FetchFunc func(ctx context.Context, dg digest.Digest) (*repb.Directory, error)
+
+// ChangeType is the kind of a Change.
+type ChangeType int
+
+const (
+	// Added is a path only in the second tree.
+	Added ChangeType = iota
+	// Removed is a path only in the first tree.
+	Removed
+	// Modified is a path in both trees with different nodes.
+	Modified
+)
+
+var changeTypes = [...]string{"added", "removed", "modified"}
+
+func (t ChangeType) String() string {
+	if Added <= t && t <= Modified {
+		return changeTypes[t]
+	}
+	return fmt.Sprintf("InvalidChangeType(%d)", t)
+}
+
+// Node is a file, directory or symlink of a tree.
+type Node struct {
+	// Digest is the digest of the file, or of the Directory.
+	Digest         digest.Digest
+	IsDirectory    bool
+	IsExecutable   bool
+	SymlinkTarget  string
+	NodeProperties *repb.NodeProperties
+}
+
+// String returns a description of the node.
+func (n *Node) String() string {
+	switch {
+	case n == nil:
+		return "none"
+	case n.IsDirectory:
+		return fmt.Sprintf("directory %v", n.Digest)
+	case n.SymlinkTarget != "":
+		return fmt.Sprintf("symlink -> %s", n.SymlinkTarget)
+	case n.IsExecutable:
+		return fmt.Sprintf("executable file %v", n.Digest)
+	default:
+		return fmt.Sprintf("file %v", n.Digest)
+	}
+}
+
+func (n *Node) equal(o *Node) bool {
+	return n.Digest == o.Digest && n.IsDirectory == o.IsDirectory && n.IsExecutable == o.IsExecutable &&
+		n.SymlinkTarget == o.SymlinkTarget && proto.Equal(n.NodeProperties, o.NodeProperties)
+}
+
+// Change is a path that differs between two trees.
+type Change struct {
+	// Path is the slash-separated path relative to the roots, "." for the roots themselves.
+	Path string
+	Type ChangeType
+	// Before is the node in the first tree, nil if the path was added.
+	Before *Node
+	// After is the node in the second tree, nil if the path was removed.
+	After *Node
+}
+
+// String returns a description of the change.
+func (c *Change) String() string {
+	switch c.Type {
+	case Added:
+		return fmt.Sprintf("added %s: %v", c.Path, c.After)
+	case Removed:
+		return fmt.Sprintf("removed %s: %v", c.Path, c.Before)
+	default:
+		return fmt.Sprintf("modified %s: %v -> %v", c.Path, c.Before, c.After)
+	}
+}
+
+// Diff walks the trees rooted at the Directories rootA and rootB, and returns the paths that differ,
+// sorted. Subtrees with the same digest are not fetched. A directory in only one of the trees is
+// reported as a single change, without its contents; a directory in both is reported as modified
+// only if its own node properties differ, and its contents are compared. Files and symlinks are
+// modified if any of their fields differ, including a change between a file and a directory.
+func Diff(ctx context.Context, rootA, rootB digest.Digest, fetch FetchFunc) ([]*Change, error) {
+	d := &differ{fetch: fetch, dirs: make(map[digest.Digest]*repb.Directory)}
+	if err := d.diffDirs(ctx, ".", rootA, rootB); err != nil {
+		return nil, err
+	}
+	sort.Slice(d.changes, func(i, j int) bool { return d.changes[i].Path < d.changes[j].Path })
+	return d.changes, nil
+}
+
+type differ struct {
+	fetch FetchFunc
+	// dirs holds the fetched Directories, since identical subtrees are common.
+	dirs    map[digest.Digest]*repb.Directory
+	changes []*Change
+}
+
+func (d *differ) dir(ctx context.Context, dg digest.Digest) (*repb.Directory, error) {
+	if dir, ok := d.dirs[dg]; ok {
+		return dir, nil
+	}
+	dir, err := d.fetch(ctx, dg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch directory %v: %w", dg, err)
+	}
+	d.dirs[dg] = dir
+	return dir, nil
+}
+
+func (d *differ) diffDirs(ctx context.Context, p string, a, b digest.Digest) error {
+	if a == b {
+		return nil
+	}
+	dirA, err := d.dir(ctx, a)
+	if err != nil {
+		return err
+	}
+	dirB, err := d.dir(ctx, b)
+	if err != nil {
+		return err
+	}
+	if !proto.Equal(dirA.NodeProperties, dirB.NodeProperties) {
+		d.changes = append(d.changes, &Change{
+			Path:   p,
+			Type:   Modified,
+			Before: &Node{Digest: a, IsDirectory: true, NodeProperties: dirA.NodeProperties},
+			After:  &Node{Digest: b, IsDirectory: true, NodeProperties: dirB.NodeProperties},
+		})
+	}
+	nodesA, nodesB := nodes(dirA), nodes(dirB)
+	for name, na := range nodesA {
+		np := path.Join(p, name)
+		nb, ok := nodesB[name]
+		switch {
+		case !ok:
+			d.changes = append(d.changes, &Change{Path: np, Type: Removed, Before: na})
+		case na.IsDirectory && nb.IsDirectory:
+			if err := d.diffDirs(ctx, np, na.Digest, nb.Digest); err != nil {
+				return err
+			}
+		case !na.equal(nb):
+			d.changes = append(d.changes, &Change{Path: np, Type: Modified, Before: na, After: nb})
+		}
+	}
+	for name, nb := range nodesB {
+		if _, ok := nodesA[name]; !ok {
+			d.changes = append(d.changes, &Change{Path: path.Join(p, name), Type: Added, After: nb})
+		}
+	}
+	return nil
+}
+
+// nodes returns the nodes of the directory by name.
+func nodes(dir *repb.Directory) map[string]*Node {
+	res := make(map[string]*Node, len(dir.Files)+len(dir.Directories)+len(dir.Symlinks))
+	for _, f := range dir.Files {
+		res[f.Name] = &Node{Digest: digest.NewFromProtoUnvalidated(f.Digest), IsExecutable: f.IsExecutable, NodeProperties: f.NodeProperties}
+	}
+	for _, sd := range dir.Directories {
+		res[sd.Name] = &Node{Digest: digest.NewFromProtoUnvalidated(sd.Digest), IsDirectory: true}
+	}
+	for _, s := range dir.Symlinks {
+		res[s.Name] = &Node{SymlinkTarget: s.Target, NodeProperties: s.NodeProperties}
+	}
+	return res
+}
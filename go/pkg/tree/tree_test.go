@@ -0,0 +1,114 @@
+package tree
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/digest"
+	"github.com/google/go-cmp/cmp"
+
+	repb "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+)
+
+// store is an in-memory CAS of Directories.
+type store map[digest.Digest]*repb.Directory
+
+func (s store) add(dir *repb.Directory) *repb.Digest {
+	dg := digest.TestNewFromMessage(dir)
+	s[dg] = dir
+	return dg.ToProto()
+}
+
+func (s store) fetch(ctx context.Context, dg digest.Digest) (*repb.Directory, error) {
+	if dir, ok := s[dg]; ok {
+		return dir, nil
+	}
+	return nil, fmt.Errorf("directory %v not found", dg)
+}
+
+func TestDiff(t *testing.T) {
+	fooDg := digest.NewFromBlob([]byte("foo")).ToProto()
+	barDg := digest.NewFromBlob([]byte("bar")).ToProto()
+	s := store{}
+	shared := s.add(&repb.Directory{Files: []*repb.FileNode{{Name: "foo", Digest: fooDg}}})
+	rootA := s.add(&repb.Directory{
+		Files: []*repb.FileNode{
+			{Name: "changed", Digest: fooDg},
+			{Name: "exec", Digest: fooDg},
+			{Name: "removed", Digest: fooDg},
+		},
+		Directories: []*repb.DirectoryNode{
+			{Name: "shared", Digest: shared},
+			{Name: "sub", Digest: s.add(&repb.Directory{Files: []*repb.FileNode{{Name: "a", Digest: fooDg}}})},
+			{Name: "kind", Digest: shared},
+		},
+		Symlinks: []*repb.SymlinkNode{{Name: "link", Target: "changed"}},
+	})
+	rootB := s.add(&repb.Directory{
+		Files: []*repb.FileNode{
+			{Name: "added", Digest: barDg},
+			{Name: "changed", Digest: barDg},
+			{Name: "exec", Digest: fooDg, IsExecutable: true},
+			{Name: "kind", Digest: fooDg},
+		},
+		Directories: []*repb.DirectoryNode{
+			{Name: "shared", Digest: shared},
+			{Name: "sub", Digest: s.add(&repb.Directory{Files: []*repb.FileNode{{Name: "a", Digest: barDg}}})},
+		},
+		Symlinks: []*repb.SymlinkNode{{Name: "link", Target: "exec"}},
+	})
+	fetched := store{}
+	fetch := func(ctx context.Context, dg digest.Digest) (*repb.Directory, error) {
+		fetched[dg] = nil
+		return s.fetch(ctx, dg)
+	}
+
+	got, err := Diff(context.Background(), digest.NewFromProtoUnvalidated(rootA), digest.NewFromProtoUnvalidated(rootB), fetch)
+	if err != nil {
+		t.Fatalf("Diff() failed: %v", err)
+	}
+	var gotStrs []string
+	for _, c := range got {
+		gotStrs = append(gotStrs, c.String())
+	}
+	foo, bar := digest.NewFromProtoUnvalidated(fooDg), digest.NewFromProtoUnvalidated(barDg)
+	want := []string{
+		fmt.Sprintf("added added: file %v", bar),
+		fmt.Sprintf("modified changed: file %v -> file %v", foo, bar),
+		fmt.Sprintf("modified exec: file %v -> executable file %v", foo, foo),
+		fmt.Sprintf("modified kind: directory %v -> file %v", digest.NewFromProtoUnvalidated(shared), foo),
+		"modified link: symlink -> changed -> symlink -> exec",
+		fmt.Sprintf("removed removed: file %v", foo),
+		fmt.Sprintf("modified sub/a: file %v -> file %v", foo, bar),
+	}
+	if diff := cmp.Diff(want, gotStrs); diff != "" {
+		t.Errorf("Diff() gave diff (-want +got):\n%s", diff)
+	}
+	if _, ok := fetched[digest.NewFromProtoUnvalidated(shared)]; ok {
+		t.Errorf("Diff() fetched the subtree shared by both trees")
+	}
+}
+
+func TestDiffNodeProperties(t *testing.T) {
+	s := store{}
+	props := &repb.NodeProperties{Properties: []*repb.NodeProperty{{Name: "k", Value: "v"}}}
+	a := s.add(&repb.Directory{})
+	b := s.add(&repb.Directory{NodeProperties: props})
+	got, err := Diff(context.Background(), digest.NewFromProtoUnvalidated(a), digest.NewFromProtoUnvalidated(b), s.fetch)
+	if err != nil {
+		t.Fatalf("Diff() failed: %v", err)
+	}
+	if len(got) != 1 || got[0].Path != "." || got[0].Type != Modified {
+		t.Errorf("Diff() = %v, want the root modified", got)
+	}
+}
+
+func TestDiffFetchError(t *testing.T) {
+	s := store{}
+	a := s.add(&repb.Directory{})
+	missing := digest.NewFromBlob([]byte("missing"))
+	if _, err := Diff(context.Background(), digest.NewFromProtoUnvalidated(a), missing, s.fetch); err == nil {
+		t.Errorf("Diff() with a missing directory succeeded, want error")
+	}
+}
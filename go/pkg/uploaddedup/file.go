@@ -0,0 +1,189 @@
+package uploaddedup
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/client"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/digest"
+
+	log "github.com/golang/glog"
+)
+
+// FileStore remembers uploaded digests in a file, so that short-lived processes, such as one CLI
+// invocation per action, skip FindMissingBlobs for the blobs uploaded by previous processes without
+// running the service. It implements client.UploadedDigests.
+//
+// The file is an append-only log of "<unix time> <digest> <instance>" lines. It is read when the
+// store is opened and compacted then if it grew past the limit, so a store does not see the digests
+// added by other processes after it was opened.
+type FileStore struct {
+	// Instance is the remote instance name the digests belong to.
+	Instance string
+	// TTL is the time digests are remembered for after they were last added.
+	TTL time.Duration
+
+	path  string
+	mu    sync.Mutex
+	added map[key]time.Time
+	now   func() time.Time
+}
+
+var _ client.UploadedDigests = (*FileStore)(nil)
+
+// OpenFile opens the store persisted in the file at path, creating it if needed, with the default
+// TTL. The file is compacted to at most maxEntries unexpired digests, the most recent ones, if it
+// holds more; a non-positive maxEntries means DefaultMaxEntries.
+func OpenFile(path, instance string, maxEntries int) (*FileStore, error) {
+	return openFile(path, instance, maxEntries, time.Now)
+}
+
+func openFile(path, instance string, maxEntries int, now func() time.Time) (*FileStore, error) {
+	s := &FileStore{
+		Instance: instance,
+		TTL:      DefaultTTL,
+		path:     path,
+		added:    make(map[key]time.Time),
+		now:      now,
+	}
+	if maxEntries <= 0 {
+		maxEntries = DefaultMaxEntries
+	}
+	lines, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	if lines > maxEntries {
+		if err := s.compact(maxEntries); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// load reads the file and returns its number of lines. Malformed lines, e.g. the last one of a
+// process that was killed, are skipped.
+func (s *FileStore) load() (int, error) {
+	f, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	lines := 0
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		lines++
+		parts := strings.SplitN(sc.Text(), " ", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		sec, err := strconv.ParseInt(parts[0], 10, 64)
+		if err != nil {
+			continue
+		}
+		dg, err := digest.NewFromString(parts[1])
+		if err != nil {
+			continue
+		}
+		k, t := key{parts[2], dg}, time.Unix(sec, 0)
+		if t.After(s.added[k]) {
+			s.added[k] = t
+		}
+	}
+	return lines, sc.Err()
+}
+
+// compact drops the expired digests and all but the maxEntries most recent ones, and rewrites the
+// file with the remaining ones.
+func (s *FileStore) compact(maxEntries int) error {
+	now := s.now()
+	keys := make([]key, 0, len(s.added))
+	for k, t := range s.added {
+		if now.Sub(t) < s.TTL {
+			keys = append(keys, k)
+		} else {
+			delete(s.added, k)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return s.added[keys[i]].After(s.added[keys[j]]) })
+	if len(keys) > maxEntries {
+		for _, k := range keys[maxEntries:] {
+			delete(s.added, k)
+		}
+		keys = keys[:maxEntries]
+	}
+	f, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	for _, k := range keys {
+		fmt.Fprint(w, line(s.added[k], k))
+	}
+	err = w.Flush()
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), s.path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+func line(t time.Time, k key) string {
+	return fmt.Sprintf("%d %s %s\n", t.Unix(), k.dg, k.instance)
+}
+
+// Uploaded returns the subset of dgs known to be uploaded.
+func (s *FileStore) Uploaded(_ context.Context, dgs []digest.Digest) ([]digest.Digest, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	var uploaded []digest.Digest
+	for _, dg := range dgs {
+		if t, ok := s.added[key{s.Instance, dg}]; ok && now.Sub(t) < s.TTL {
+			uploaded = append(uploaded, dg)
+		}
+	}
+	return uploaded, nil
+}
+
+// Add records dgs as uploaded, appending them to the file.
+func (s *FileStore) Add(_ context.Context, dgs []digest.Digest) error {
+	if len(dgs) == 0 {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	var b strings.Builder
+	for _, dg := range dgs {
+		k := key{s.Instance, dg}
+		s.added[k] = now
+		b.WriteString(line(now, k))
+	}
+	f, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	// A single write, so that the lines of concurrent processes do not interleave.
+	if _, err := f.WriteString(b.String()); err != nil {
+		f.Close()
+		return err
+	}
+	log.V(3).Infof("Recorded %d uploaded digests in %s", len(dgs), s.path)
+	return f.Close()
+}
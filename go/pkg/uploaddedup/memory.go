@@ -0,0 +1,45 @@
+package uploaddedup
+
+import (
+	"context"
+	"time"
+
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/client"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/digest"
+)
+
+// MemoryStore remembers uploaded digests in memory, for a long-running process whose clients share
+// inputs across many actions, without running the service. It implements client.UploadedDigests.
+type MemoryStore struct {
+	// Instance is the remote instance name the digests belong to.
+	Instance string
+
+	srv *Server
+}
+
+var _ client.UploadedDigests = (*MemoryStore)(nil)
+
+// NewMemoryStore returns a store remembering digests for ttl after they were last added, and at
+// most maxEntries digests. Non-positive values mean DefaultTTL and DefaultMaxEntries.
+func NewMemoryStore(instance string, ttl time.Duration, maxEntries int) *MemoryStore {
+	srv := NewServer()
+	if ttl > 0 {
+		srv.TTL = ttl
+	}
+	if maxEntries > 0 {
+		srv.MaxEntries = maxEntries
+	}
+	return &MemoryStore{Instance: instance, srv: srv}
+}
+
+// Uploaded returns the subset of dgs known to be uploaded.
+func (s *MemoryStore) Uploaded(_ context.Context, dgs []digest.Digest) ([]digest.Digest, error) {
+	reply := &Reply{}
+	err := s.srv.Lookup(&Args{Instance: s.Instance, Digests: dgs}, reply)
+	return reply.Digests, err
+}
+
+// Add records dgs as uploaded.
+func (s *MemoryStore) Add(_ context.Context, dgs []digest.Digest) error {
+	return s.srv.Add(&Args{Instance: s.Instance, Digests: dgs}, &Reply{})
+}
@@ -0,0 +1,195 @@
+// Package uploaddedup provides a small local service that lets the processes of a machine share
+// which digests were recently uploaded to the CAS. When many independent tools build from the same
+// tree, each of them would otherwise query FindMissingBlobs for, and possibly upload, the same
+// blobs. The service listens on a Unix domain socket, and Client implements
+// client.UploadedDigests on top of it. FileStore implements it on top of a file instead, for
+// machines that do not run the service.
+//
+// The service only holds hints: a digest is forgotten after a while, since the CAS may evict it,
+// and clients keep working without the service.
+package uploaddedup
+
+import (
+	"context"
+	"net"
+	"net/rpc"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/client"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/digest"
+
+	log "github.com/golang/glog"
+)
+
+const (
+	serviceName = "UploadDedup"
+
+	// DefaultTTL is the default time digests are remembered for.
+	DefaultTTL = time.Hour
+
+	// DefaultMaxEntries is the default maximum number of digests remembered.
+	DefaultMaxEntries = 1_000_000
+)
+
+// Args is the request of both the Lookup and the Add methods of the service.
+type Args struct {
+	// Instance is the remote instance name the digests belong to.
+	Instance string
+	// Digests are the digests to look up or add.
+	Digests []digest.Digest
+}
+
+// Reply is the reply of both the Lookup and the Add methods of the service.
+type Reply struct {
+	// Digests are the requested digests known to be uploaded. It is empty for Add.
+	Digests []digest.Digest
+}
+
+type key struct {
+	instance string
+	dg       digest.Digest
+}
+
+// Server remembers uploaded digests and serves them to the processes of the machine.
+type Server struct {
+	// TTL is the time digests are remembered for after they were last added.
+	TTL time.Duration
+	// MaxEntries is the maximum number of digests remembered. When it is exceeded, expired digests
+	// are dropped first, then arbitrary ones.
+	MaxEntries int
+
+	mu    sync.Mutex
+	added map[key]time.Time
+	now   func() time.Time
+}
+
+// NewServer returns a server with the default TTL and size limit.
+func NewServer() *Server {
+	return &Server{
+		TTL:        DefaultTTL,
+		MaxEntries: DefaultMaxEntries,
+		added:      make(map[key]time.Time),
+		now:        time.Now,
+	}
+}
+
+// Lookup replies with the requested digests that were added within the TTL.
+func (s *Server) Lookup(args *Args, reply *Reply) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	for _, dg := range args.Digests {
+		if t, ok := s.added[key{args.Instance, dg}]; ok && now.Sub(t) < s.TTL {
+			reply.Digests = append(reply.Digests, dg)
+		}
+	}
+	return nil
+}
+
+// Add records the digests as uploaded.
+func (s *Server) Add(args *Args, _ *Reply) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	for _, dg := range args.Digests {
+		s.added[key{args.Instance, dg}] = now
+	}
+	if s.MaxEntries > 0 && len(s.added) > s.MaxEntries {
+		s.evict(now)
+	}
+	return nil
+}
+
+func (s *Server) evict(now time.Time) {
+	for k, t := range s.added {
+		if now.Sub(t) >= s.TTL {
+			delete(s.added, k)
+		}
+	}
+	for k := range s.added {
+		if len(s.added) <= s.MaxEntries {
+			return
+		}
+		delete(s.added, k)
+	}
+}
+
+// Serve serves connections accepted on l until it is closed.
+func (s *Server) Serve(l net.Listener) error {
+	srv := rpc.NewServer()
+	if err := srv.RegisterName(serviceName, s); err != nil {
+		return err
+	}
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go srv.ServeConn(conn)
+	}
+}
+
+// ListenAndServe listens on the Unix domain socket at path, replacing a stale socket file, and
+// serves connections until the listener fails.
+func (s *Server) ListenAndServe(path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	log.Infof("Serving uploaded digests on %s", path)
+	return s.Serve(l)
+}
+
+// Client is a connection to the service of the machine. It implements client.UploadedDigests.
+type Client struct {
+	// Instance is the remote instance name the digests belong to.
+	Instance string
+
+	rpc *rpc.Client
+}
+
+var _ client.UploadedDigests = (*Client)(nil)
+
+// Dial connects to the service listening on the Unix domain socket at path.
+func Dial(path, instance string) (*Client, error) {
+	c, err := rpc.Dial("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{Instance: instance, rpc: c}, nil
+}
+
+// Uploaded returns the subset of dgs known to be uploaded.
+func (c *Client) Uploaded(ctx context.Context, dgs []digest.Digest) ([]digest.Digest, error) {
+	reply := &Reply{}
+	if err := c.call(ctx, serviceName+".Lookup", &Args{Instance: c.Instance, Digests: dgs}, reply); err != nil {
+		return nil, err
+	}
+	return reply.Digests, nil
+}
+
+// Add records dgs as uploaded.
+func (c *Client) Add(ctx context.Context, dgs []digest.Digest) error {
+	return c.call(ctx, serviceName+".Add", &Args{Instance: c.Instance, Digests: dgs}, &Reply{})
+}
+
+func (c *Client) call(ctx context.Context, method string, args *Args, reply *Reply) error {
+	call := c.rpc.Go(method, args, reply, make(chan *rpc.Call, 1))
+	select {
+	case <-call.Done:
+		return call.Error
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close closes the connection.
+func (c *Client) Close() error {
+	return c.rpc.Close()
+}
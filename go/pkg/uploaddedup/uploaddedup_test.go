@@ -0,0 +1,182 @@
+package uploaddedup
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/digest"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestLookupAndAdd(t *testing.T) {
+	ctx := context.Background()
+	now := time.Unix(1000, 0)
+	s := NewServer()
+	s.TTL = time.Minute
+	s.now = func() time.Time { return now }
+	path := filepath.Join(t.TempDir(), "dedup.sock")
+	go s.ListenAndServe(path)
+
+	var c *Client
+	var err error
+	for i := 0; i < 100; i++ {
+		if c, err = Dial(path, "instance"); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("Dial(%q) failed: %v", path, err)
+	}
+	defer c.Close()
+
+	foo, bar := digest.NewFromBlob([]byte("foo")), digest.NewFromBlob([]byte("bar"))
+	if err := c.Add(ctx, []digest.Digest{foo}); err != nil {
+		t.Fatalf("Add() failed: %v", err)
+	}
+	got, err := c.Uploaded(ctx, []digest.Digest{foo, bar})
+	if err != nil {
+		t.Fatalf("Uploaded() failed: %v", err)
+	}
+	if diff := cmp.Diff([]digest.Digest{foo}, got); diff != "" {
+		t.Errorf("Uploaded() gave diff (-want +got):\n%s", diff)
+	}
+
+	other, err := Dial(path, "other")
+	if err != nil {
+		t.Fatalf("Dial(%q) failed: %v", path, err)
+	}
+	defer other.Close()
+	if got, err := other.Uploaded(ctx, []digest.Digest{foo}); err != nil || len(got) != 0 {
+		t.Errorf("Uploaded() on another instance = %v, %v, want none", got, err)
+	}
+
+	now = now.Add(time.Minute)
+	if got, err := c.Uploaded(ctx, []digest.Digest{foo}); err != nil || len(got) != 0 {
+		t.Errorf("Uploaded() after the TTL = %v, %v, want none", got, err)
+	}
+}
+
+func TestMaxEntries(t *testing.T) {
+	s := NewServer()
+	s.MaxEntries = 2
+	var dgs []digest.Digest
+	for _, b := range []string{"a", "b", "c"} {
+		dgs = append(dgs, digest.NewFromBlob([]byte(b)))
+	}
+	if err := s.Add(&Args{Digests: dgs}, &Reply{}); err != nil {
+		t.Fatalf("Add() failed: %v", err)
+	}
+	reply := &Reply{}
+	if err := s.Lookup(&Args{Digests: dgs}, reply); err != nil {
+		t.Fatalf("Lookup() failed: %v", err)
+	}
+	if len(reply.Digests) != 2 {
+		t.Errorf("Lookup() gave %d digests, want 2", len(reply.Digests))
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	ctx := context.Background()
+	now := time.Unix(1000, 0)
+	path := filepath.Join(t.TempDir(), "uploaded")
+	open := func(instance string, maxEntries int) *FileStore {
+		t.Helper()
+		s, err := openFile(path, instance, maxEntries, func() time.Time { return now })
+		if err != nil {
+			t.Fatalf("OpenFile(%q) failed: %v", path, err)
+		}
+		s.TTL = time.Minute
+		return s
+	}
+
+	foo, bar := digest.NewFromBlob([]byte("foo")), digest.NewFromBlob([]byte("bar"))
+	if err := open("instance", 0).Add(ctx, []digest.Digest{foo}); err != nil {
+		t.Fatalf("Add() failed: %v", err)
+	}
+
+	// A later process sees the digests added by the previous one.
+	s := open("instance", 0)
+	got, err := s.Uploaded(ctx, []digest.Digest{foo, bar})
+	if err != nil {
+		t.Fatalf("Uploaded() failed: %v", err)
+	}
+	if diff := cmp.Diff([]digest.Digest{foo}, got); diff != "" {
+		t.Errorf("Uploaded() gave diff (-want +got):\n%s", diff)
+	}
+	if got, err := open("other", 0).Uploaded(ctx, []digest.Digest{foo}); err != nil || len(got) != 0 {
+		t.Errorf("Uploaded() on another instance = %v, %v, want none", got, err)
+	}
+
+	now = now.Add(time.Minute)
+	if got, err := s.Uploaded(ctx, []digest.Digest{foo}); err != nil || len(got) != 0 {
+		t.Errorf("Uploaded() after the TTL = %v, %v, want none", got, err)
+	}
+}
+
+func TestFileStoreCompaction(t *testing.T) {
+	ctx := context.Background()
+	now := time.Unix(1000, 0)
+	path := filepath.Join(t.TempDir(), "uploaded")
+	clock := func() time.Time { return now }
+	s, err := openFile(path, "instance", 0, clock)
+	if err != nil {
+		t.Fatalf("OpenFile(%q) failed: %v", path, err)
+	}
+	var dgs []digest.Digest
+	for _, b := range []string{"a", "b", "c"} {
+		dg := digest.NewFromBlob([]byte(b))
+		dgs = append(dgs, dg)
+		if err := s.Add(ctx, []digest.Digest{dg}); err != nil {
+			t.Fatalf("Add() failed: %v", err)
+		}
+		now = now.Add(time.Second)
+	}
+
+	if s, err = openFile(path, "instance", 2, clock); err != nil {
+		t.Fatalf("OpenFile(%q) failed: %v", path, err)
+	}
+	got, err := s.Uploaded(ctx, dgs)
+	if err != nil {
+		t.Fatalf("Uploaded() failed: %v", err)
+	}
+	// The oldest digest is dropped.
+	if diff := cmp.Diff(dgs[1:], got); diff != "" {
+		t.Errorf("Uploaded() after compaction gave diff (-want +got):\n%s", diff)
+	}
+	blob, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("os.ReadFile(%q) failed: %v", path, err)
+	}
+	if n := strings.Count(string(blob), "\n"); n != 2 {
+		t.Errorf("Compacted file has %d lines, want 2", n)
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	ctx := context.Background()
+	now := time.Unix(1000, 0)
+	s := NewMemoryStore("instance", time.Minute, 0)
+	s.srv.now = func() time.Time { return now }
+
+	foo, bar := digest.NewFromBlob([]byte("foo")), digest.NewFromBlob([]byte("bar"))
+	if err := s.Add(ctx, []digest.Digest{foo}); err != nil {
+		t.Fatalf("Add() failed: %v", err)
+	}
+	got, err := s.Uploaded(ctx, []digest.Digest{foo, bar})
+	if err != nil {
+		t.Fatalf("Uploaded() failed: %v", err)
+	}
+	if diff := cmp.Diff([]digest.Digest{foo}, got); diff != "" {
+		t.Errorf("Uploaded() gave diff (-want +got):\n%s", diff)
+	}
+
+	now = now.Add(time.Minute)
+	if got, err := s.Uploaded(ctx, []digest.Digest{foo}); err != nil || len(got) != 0 {
+		t.Errorf("Uploaded() after the TTL = %v, %v, want none", got, err)
+	}
+}
@@ -3,6 +3,11 @@
 package uploadinfo
 
 import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/digest"
 	"google.golang.org/protobuf/proto"
 )
@@ -10,6 +15,7 @@ import (
 const (
 	ueBlob = iota
 	uePath
+	ueReader
 )
 
 // Entry should remain immutable upon creation.
@@ -22,6 +28,7 @@ type Entry struct {
 
 	ueType      int
 	virtualFile bool
+	opener      func() (io.ReadCloser, error)
 }
 
 // IsBlob returns whether this Entry is for a blob in memory.
@@ -34,6 +41,11 @@ func (ue *Entry) IsFile() bool {
 	return ue.ueType == uePath
 }
 
+// IsReader returns whether this Entry is for data read from an arbitrary source.
+func (ue *Entry) IsReader() bool {
+	return ue.ueType == ueReader
+}
+
 // IsVirtualFile returns whether this Entry is a virtual file.
 func (ue *Entry) IsVirtualFile() bool {
 	return ue.virtualFile
@@ -41,20 +53,31 @@ func (ue *Entry) IsVirtualFile() bool {
 
 // EntryFromBlob creates an Entry from an in memory blob.
 func EntryFromBlob(blob []byte) *Entry {
+	return EntryFromBlobWith(digest.Function{}, blob)
+}
+
+// EntryFromBlobWith creates an Entry from an in memory blob, digested with the digest function fn.
+func EntryFromBlobWith(fn digest.Function, blob []byte) *Entry {
 	return &Entry{
 		Contents: blob,
-		Digest:   digest.NewFromBlob(blob),
+		Digest:   fn.NewFromBlob(blob),
 		ueType:   ueBlob,
 	}
 }
 
 // EntryFromProto creates an Entry from an in memory proto.
 func EntryFromProto(msg proto.Message) (*Entry, error) {
+	return EntryFromProtoWith(digest.Function{}, msg)
+}
+
+// EntryFromProtoWith creates an Entry from an in memory proto, digested with the digest function
+// fn.
+func EntryFromProtoWith(fn digest.Function, msg proto.Message) (*Entry, error) {
 	blob, err := proto.Marshal(msg)
 	if err != nil {
 		return nil, err
 	}
-	return EntryFromBlob(blob), nil
+	return EntryFromBlobWith(fn, blob), nil
 }
 
 // EntryFromFile creates an entry from a file in disk.
@@ -66,6 +89,20 @@ func EntryFromFile(dg digest.Digest, path string) *Entry {
 	}
 }
 
+// EntryFromUndigestedFile creates an entry from a file in disk whose digest is not known yet. The
+// client's Upload computes it before uploading the file.
+func EntryFromUndigestedFile(path string) *Entry {
+	return &Entry{
+		Path:   path,
+		ueType: uePath,
+	}
+}
+
+// IsDigested returns whether the digest of this Entry is known.
+func (ue *Entry) IsDigested() bool {
+	return ue.Digest.Hash != ""
+}
+
 // EntryFromVirtualFile creates an entry from a file not on disk.
 // The digest is expected to exist in the CAS.
 func EntryFromVirtualFile(dg digest.Digest, path string) *Entry {
@@ -76,3 +113,31 @@ func EntryFromVirtualFile(dg digest.Digest, path string) *Entry {
 		virtualFile: true,
 	}
 }
+
+// EntryFromReader creates an Entry from data read from an arbitrary source, such as a file in a
+// zip archive, a network stream or the output of a process, so that it can be uploaded without
+// being written to disk first. The digest, which includes the size, must be of the data. opener
+// is called for every read of the data, e.g. again to retry an upload, and must return the same
+// data every time.
+func EntryFromReader(dg digest.Digest, opener func() (io.ReadCloser, error)) *Entry {
+	return &Entry{
+		Digest: dg,
+		ueType: ueReader,
+		opener: opener,
+	}
+}
+
+// Open returns a reader of the data of the Entry, which the caller must close. Virtual files have
+// no data to read.
+func (ue *Entry) Open() (io.ReadCloser, error) {
+	switch {
+	case ue.virtualFile:
+		return nil, fmt.Errorf("virtual file %s with digest %v cannot be read", ue.Path, ue.Digest)
+	case ue.IsBlob():
+		return io.NopCloser(bytes.NewReader(ue.Contents)), nil
+	case ue.IsReader():
+		return ue.opener()
+	default:
+		return os.Open(ue.Path)
+	}
+}
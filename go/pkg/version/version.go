@@ -0,0 +1,50 @@
+// Package version reports the version of the SDK a binary was built with.
+package version
+
+import (
+	"runtime/debug"
+	"sync"
+)
+
+const modulePath = "github.com/bazelbuild/remote-apis-sdks"
+
+var (
+	sdkOnce sync.Once
+	sdk     string
+)
+
+// SDK returns the version of the SDK module the binary was built with, such as "v0.1.0", or
+// "devel" if it is unknown, e.g. when the SDK is the main module or the binary was built without
+// module information.
+func SDK() string {
+	sdkOnce.Do(func() {
+		sdk = "devel"
+		bi, ok := debug.ReadBuildInfo()
+		if !ok {
+			return
+		}
+		mod := &bi.Main
+		for _, dep := range bi.Deps {
+			if dep.Path == modulePath {
+				mod = dep
+			}
+		}
+		if mod.Replace != nil {
+			mod = mod.Replace
+		}
+		if mod.Path == modulePath && mod.Version != "" && mod.Version != "(devel)" {
+			sdk = mod.Version
+		}
+	})
+	return sdk
+}
+
+// UserAgent returns the gRPC user agent of a tool: the tool's own user agent, if any, followed by
+// the SDK's.
+func UserAgent(tool string) string {
+	ua := "remote-apis-sdks/" + SDK()
+	if tool != "" {
+		ua = tool + " " + ua
+	}
+	return ua
+}
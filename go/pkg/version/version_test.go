@@ -0,0 +1,12 @@
+package version
+
+import "testing"
+
+func TestUserAgent(t *testing.T) {
+	if got, want := UserAgent(""), "remote-apis-sdks/"+SDK(); got != want {
+		t.Errorf("UserAgent(\"\") = %q, want %q", got, want)
+	}
+	if got, want := UserAgent("mytool/1.2"), "mytool/1.2 remote-apis-sdks/"+SDK(); got != want {
+		t.Errorf("UserAgent(\"mytool/1.2\") = %q, want %q", got, want)
+	}
+}